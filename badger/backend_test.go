@@ -0,0 +1,28 @@
+package badger
+
+import (
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := Open(t.TempDir(), WithSync(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestTxn(t *testing.T) {
+	metatest.RunTxnSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := Open(t.TempDir(), WithSync(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
@@ -0,0 +1,606 @@
+// Package badger implements metastorage.Backend on a BadgerDB key-value
+// store, an embedded option with higher write throughput than bbolt for
+// high-volume queues: writes go to a value log and an LSM tree instead of
+// rewriting B+tree pages under a single writer lock.
+//
+// Every message has a metadata key and an index key in its state:
+//
+//	<ns>meta/<id>           encoded metadata
+//	<ns>state/<state>/<id>  empty, index of the messages in a state
+//	<ns>seq/<state>         last sequence assigned in a state
+//
+// Every operation is one Badger transaction. Transactions are optimistic
+// and serializable: a transaction that read a key changed by a concurrent
+// commit fails with badger.ErrConflict and is retried, so MoveToState has
+// exactly one winner. StoreMeta and MoveToState set StateEnteredAt and
+// assign the next Sequence of the state entered in their transaction.
+// State counts are kept in memory and rebuilt from the index when the
+// backend is created.
+//
+// Begin starts a metastorage.Txn whose operations Commit runs in one
+// Badger transaction. Large transactions fail with badger.ErrTxnTooBig.
+//
+// Badger locks the directory: only one process can open it at a time.
+//
+// Importing the package registers the "badger://" DSN scheme.
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/codec"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// conflictRetries bounds how often a transaction is retried after a
+// conflict with a concurrent commit
+const conflictRetries = 16
+
+type syncKey struct{}
+
+// WithSync sets whether every commit waits for the value log to be synced
+// to disk (default true). Without sync, writes of the last moments before
+// a machine crash can be lost, in exchange for much higher ingest rates;
+// process crashes lose nothing.
+func WithSync(sync bool) options.Option {
+	return options.WithValue(syncKey{}, sync)
+}
+
+func init() {
+	registry.Register("badger", open)
+}
+
+// open handles "badger:///var/spool/retryspool/meta" DSNs. The sync
+// parameter sets WithSync, e.g. "badger:///var/spool/meta?sync=false".
+func open(_ context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	if dsn.Path == "" {
+		return nil, errors.New("badger DSN: path is required")
+	}
+	if v := dsn.Query().Get("sync"); v != "" {
+		sync, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("badger DSN: invalid sync %q", v)
+		}
+		opts = append(opts, WithSync(sync))
+	}
+	return Open(dsn.Path, opts...)
+}
+
+// Backend stores message metadata in a Badger database
+type Backend struct {
+	db        *badger.DB
+	prefix    []byte
+	codec     codec.Codec
+	clock     clock.Clock
+	batchSize int
+	skew      time.Duration
+
+	countMu sync.Mutex
+	counts  map[metastorage.QueueState]int64
+}
+
+// Open opens or creates the database in directory dir. With a namespace,
+// all keys are prefixed with "<namespace>/", so several backends can
+// share a database through one *badger.DB, see New.
+func Open(dir string, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	db, err := badger.Open(badger.DefaultOptions(dir).
+		WithLogger(logger{o.Logger}).
+		WithSyncWrites(options.ValueOr(o, syncKey{}, true)))
+	if err != nil {
+		return nil, fmt.Errorf("badger: open %s: %w", dir, err)
+	}
+	b, err := New(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// New creates a backend on an open database and counts the stored
+// messages. Close closes db.
+func New(db *badger.DB, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	b := &Backend{
+		db:        db,
+		codec:     o.Codec,
+		clock:     o.Clock,
+		batchSize: o.BatchSize,
+		skew:      o.ClockSkew,
+		counts:    make(map[metastorage.QueueState]int64),
+	}
+	if o.Namespace != "" {
+		b.prefix = []byte(o.Namespace + "/")
+	}
+	if err := b.loadCounts(); err != nil {
+		return nil, fmt.Errorf("badger: count messages: %w", err)
+	}
+	return b, nil
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (b *Backend) ClockSkew() time.Duration {
+	return b.skew
+}
+
+// logger passes badger's log to slog. Routine messages such as value log
+// replays and compactions are logged at debug level.
+type logger struct {
+	log *slog.Logger
+}
+
+func (l logger) Errorf(format string, args ...any) {
+	l.log.Error("badger: " + fmt.Sprintf(format, args...))
+}
+
+func (l logger) Warningf(format string, args ...any) {
+	l.log.Warn("badger: " + fmt.Sprintf(format, args...))
+}
+
+func (l logger) Infof(format string, args ...any) {
+	l.log.Debug("badger: " + fmt.Sprintf(format, args...))
+}
+
+func (l logger) Debugf(format string, args ...any) {
+	l.log.Debug("badger: " + fmt.Sprintf(format, args...))
+}
+
+func (b *Backend) key(parts ...string) []byte {
+	k := bytes.Clone(b.prefix)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+func (b *Backend) metaKey(id string) []byte {
+	return b.key("meta/", id)
+}
+
+func (b *Backend) statePrefix(state metastorage.QueueState) []byte {
+	return b.key("state/", metastorage.StateLabel(state), "/")
+}
+
+func (b *Backend) indexKey(state metastorage.QueueState, id string) []byte {
+	return b.key("state/", metastorage.StateLabel(state), "/", id)
+}
+
+func (b *Backend) sequenceKey(state metastorage.QueueState) []byte {
+	return b.key("seq/", metastorage.StateLabel(state))
+}
+
+// loadCounts counts the index keys per state
+func (b *Backend) loadCounts() error {
+	return b.db.View(func(txn *badger.Txn) error {
+		for _, state := range metastorage.States() {
+			prefix := b.statePrefix(state)
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+			for it.Rewind(); it.ValidForPrefix(prefix); it.Next() {
+				b.counts[state]++
+			}
+			it.Close()
+		}
+		return nil
+	})
+}
+
+// add adjusts the count of state by delta
+func (b *Backend) add(state metastorage.QueueState, delta int64) {
+	b.countMu.Lock()
+	defer b.countMu.Unlock()
+	b.counts[state] = max(b.counts[state]+delta, 0)
+}
+
+// translate maps badger errors to metastorage errors
+func translate(err error) error {
+	if errors.Is(err, badger.ErrDBClosed) {
+		return metastorage.ErrBackendClosed
+	}
+	return err
+}
+
+// update runs fn in a read-write transaction, retrying it after a
+// conflict with a concurrent commit. fn must not have side effects
+// outside the transaction.
+func (b *Backend) update(fn func(txn *badger.Txn) error) error {
+	for range conflictRetries {
+		err := b.db.Update(fn)
+		if !errors.Is(err, badger.ErrConflict) {
+			return translate(err)
+		}
+	}
+	return fmt.Errorf("badger: %w", badger.ErrConflict)
+}
+
+// countDelta collects the changes a transaction makes to the state
+// counts, applied once it committed
+type countDelta map[metastorage.QueueState]int64
+
+// write is update for transactions changing state counts: fn records its
+// changes in counts, which are applied after the commit
+func (b *Backend) write(fn func(txn *badger.Txn, counts countDelta) error) error {
+	var counts countDelta
+	err := b.update(func(txn *badger.Txn) error {
+		counts = countDelta{}
+		return fn(txn, counts)
+	})
+	if err != nil {
+		return err
+	}
+	for state, delta := range counts {
+		b.add(state, delta)
+	}
+	return nil
+}
+
+// get returns a copy of the value of key, nil if it does not exist
+func get(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// load returns the stored metadata of id
+func (b *Backend) load(txn *badger.Txn, id string) (metastorage.MessageMetadata, error) {
+	data, err := get(txn, b.metaKey(id))
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	if data == nil {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	var m metastorage.MessageMetadata
+	if err := b.codec.Unmarshal(data, &m); err != nil {
+		return metastorage.MessageMetadata{}, fmt.Errorf("badger: %s: %w", id, err)
+	}
+	m.ID = id
+	return m, nil
+}
+
+// save writes the metadata of m
+func (b *Backend) save(txn *badger.Txn, m metastorage.MessageMetadata) error {
+	data, err := b.codec.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return txn.Set(b.metaKey(m.ID), data)
+}
+
+// nextSequence increments the sequence counter of state in txn and
+// returns the new value
+func (b *Backend) nextSequence(txn *badger.Txn, state metastorage.QueueState) (uint64, error) {
+	last, err := b.lastSequence(txn, state)
+	if err != nil {
+		return 0, err
+	}
+	return last + 1, txn.Set(b.sequenceKey(state), binary.BigEndian.AppendUint64(nil, last+1))
+}
+
+func (b *Backend) lastSequence(txn *badger.Txn, state metastorage.QueueState) (uint64, error) {
+	v, err := get(txn, b.sequenceKey(state))
+	if err != nil || v == nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("badger: sequence of %s: invalid counter", state)
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID. It assigns the next sequence of the message's state and
+// sets StateEnteredAt if it is unset.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.write(func(txn *badger.Txn, counts countDelta) error {
+		return b.store(txn, counts, messageID, metadata)
+	})
+}
+
+// store writes the message of StoreMeta in txn
+func (b *Backend) store(txn *badger.Txn, counts countDelta, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata = metastorage.NormalizeTimes(metastorage.EnterState(metadata, b.clock.Now()))
+	metadata.ID = messageID
+	metadata.Version = 0 // versions are not tracked
+	prev, err := b.load(txn, messageID)
+	switch {
+	case err == nil:
+		if err := txn.Delete(b.indexKey(prev.State, messageID)); err != nil {
+			return err
+		}
+		counts[prev.State]--
+	case !errors.Is(err, metastorage.ErrMessageNotFound):
+		return err
+	}
+	if metadata.Sequence, err = b.nextSequence(txn, metadata.State); err != nil {
+		return err
+	}
+	if err := b.save(txn, metadata); err != nil {
+		return err
+	}
+	counts[metadata.State]++
+	return txn.Set(b.indexKey(metadata.State, messageID), nil)
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	var m metastorage.MessageMetadata
+	err := b.db.View(func(txn *badger.Txn) error {
+		var err error
+		m, err = b.load(txn, messageID)
+		return err
+	})
+	return m, translate(err)
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.update(func(txn *badger.Txn) error {
+		return b.updateIn(txn, messageID, metadata)
+	})
+}
+
+// updateIn writes the message of UpdateMeta in txn
+func (b *Backend) updateIn(txn *badger.Txn, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := metastorage.RejectVersion(messageID, metadata.Version); err != nil {
+		return err
+	}
+	metadata = metastorage.NormalizeTimes(metadata)
+	metadata.ID = messageID
+	old, err := b.load(txn, messageID)
+	if err != nil {
+		return err
+	}
+	if metadata.State != old.State {
+		return fmt.Errorf("%w: %s is %s, update has %s", metastorage.ErrStateConflict, messageID, old.State, metadata.State)
+	}
+	return b.save(txn, metadata)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.write(func(txn *badger.Txn, counts countDelta) error {
+		return b.delete(txn, counts, messageID)
+	})
+}
+
+// delete removes the message in txn
+func (b *Backend) delete(txn *badger.Txn, counts countDelta, messageID string) error {
+	m, err := b.load(txn, messageID)
+	if err != nil {
+		return err
+	}
+	if err := txn.Delete(b.indexKey(m.State, messageID)); err != nil {
+		return err
+	}
+	counts[m.State]--
+	return txn.Delete(b.metaKey(messageID))
+}
+
+// MoveToState moves the index key of the message to toState and updates
+// its metadata in one transaction, recording when it entered toState and
+// assigning the next sequence of toState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.write(func(txn *badger.Txn, counts countDelta) error {
+		return b.move(txn, counts, messageID, fromState, toState)
+	})
+}
+
+// move moves the message in txn
+func (b *Backend) move(txn *badger.Txn, counts countDelta, messageID string, fromState, toState metastorage.QueueState) error {
+	m, err := b.load(txn, messageID)
+	if err != nil {
+		return err
+	}
+	if m.State != fromState {
+		return fmt.Errorf("%w: %s is %s, expected %s", metastorage.ErrStateConflict, messageID, m.State, fromState)
+	}
+	if fromState == toState {
+		return nil
+	}
+	m.State = toState
+	m.StateEnteredAt = b.clock.Now().UTC()
+	if m.Sequence, err = b.nextSequence(txn, toState); err != nil {
+		return err
+	}
+	if err := b.save(txn, m); err != nil {
+		return err
+	}
+	if err := txn.Delete(b.indexKey(fromState, messageID)); err != nil {
+		return err
+	}
+	counts[fromState]--
+	counts[toState]++
+	return txn.Set(b.indexKey(toState, messageID), nil)
+}
+
+// LastSequence returns the highest sequence assigned in state, see
+// metastorage.SequenceBackend
+func (b *Backend) LastSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var last uint64
+	err := b.db.View(func(txn *badger.Txn) error {
+		var err error
+		last, err = b.lastSequence(txn, state)
+		return err
+	})
+	return last, translate(err)
+}
+
+// TracksStateTime reports that StateEnteredAt is maintained, see
+// metastorage.StateTimeBackend
+func (b *Backend) TracksStateTime() bool {
+	return true
+}
+
+// GetStateCount returns the number of messages in state, -1 if the
+// backend is closed
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	if b.db.IsClosed() {
+		return -1
+	}
+	b.countMu.Lock()
+	defer b.countMu.Unlock()
+	return b.counts[state]
+}
+
+// scan calls fn for up to limit messages of state in ID order, starting
+// after the index key after (nil: from the start), in one read
+// transaction. limit <= 0 reads all. It returns the last index key read
+// and whether more keys follow.
+func (b *Backend) scan(state metastorage.QueueState, after []byte, limit int, fn func(metastorage.MessageMetadata)) ([]byte, bool, error) {
+	prefix := b.statePrefix(state)
+	more := false
+	err := b.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		if after == nil {
+			it.Rewind()
+		} else {
+			it.Seek(append(bytes.Clone(after), 0))
+		}
+		n := 0
+		for ; it.ValidForPrefix(prefix); it.Next() {
+			if limit > 0 && n == limit {
+				more = true
+				return nil
+			}
+			key := it.Item().KeyCopy(nil)
+			m, err := b.load(txn, string(key[len(prefix):]))
+			if errors.Is(err, metastorage.ErrMessageNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			fn(m)
+			after = key
+			n++
+		}
+		return nil
+	})
+	return after, more, translate(err)
+}
+
+// ListMessages lists the IDs of messages in state, see
+// metastorage.ListPage for the supported options. The whole state is
+// read; use NewMessageIterator for large states.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	var ms []metastorage.MessageMetadata
+	_, _, err := b.scan(state, nil, 0, func(m metastorage.MessageMetadata) {
+		ms = append(ms, m)
+	})
+	if err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the messages in state,
+// ordered by ID. Each batch is read in its own transaction, continuing
+// after the last returned key, so changes between batches are visible.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &iterator{backend: b, state: state, batchSize: max(batchSize, 1)}, nil
+}
+
+// Close closes the database
+func (b *Backend) Close() error {
+	if b.db.IsClosed() {
+		return nil
+	}
+	return b.db.Close()
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	batchSize int
+	after     []byte // last index key read, nil before the first batch
+	batch     []metastorage.MessageMetadata
+	done      bool
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	if len(it.batch) == 0 && !it.done {
+		after, more, err := it.backend.scan(it.state, it.after, it.batchSize, func(m metastorage.MessageMetadata) {
+			it.batch = append(it.batch, m)
+		})
+		if err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		it.after, it.done = after, !more
+	}
+	if len(it.batch) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *iterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = n
+	}
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.done = true
+	it.batch = nil
+	return nil
+}
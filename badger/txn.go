@@ -0,0 +1,45 @@
+package badger
+
+import (
+	"context"
+
+	"github.com/dgraph-io/badger/v4"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Begin starts a transaction committed in one Badger transaction, see
+// metastorage.TxnBackend
+func (b *Backend) Begin(ctx context.Context) (metastorage.Txn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metastorage.NewTxn(b.commit), nil
+}
+
+// commit runs ops in a Badger transaction, which a failing operation
+// discards
+func (b *Backend) commit(ctx context.Context, ops []metastorage.TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.write(func(txn *badger.Txn, counts countDelta) error {
+		for i, op := range ops {
+			var err error
+			switch op.Kind {
+			case metastorage.TxnStore:
+				err = b.store(txn, counts, op.ID, op.Metadata)
+			case metastorage.TxnUpdate:
+				err = b.updateIn(txn, op.ID, op.Metadata)
+			case metastorage.TxnMove:
+				err = b.move(txn, counts, op.ID, op.From, op.To)
+			case metastorage.TxnDelete:
+				err = b.delete(txn, counts, op.ID)
+			}
+			if err != nil {
+				return op.Err(i, err)
+			}
+		}
+		return nil
+	})
+}
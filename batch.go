@@ -0,0 +1,118 @@
+package metastorage
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchBackend is implemented by backends that store, read and delete
+// several messages in one round trip, e.g. with one multi-row statement
+// or transaction.
+//
+// Every method returns one error per item, in the order of the input, nil
+// for items that succeeded; errors.Join(errs...) combines them. A failure
+// of the whole batch, such as a lost connection, is reported for every
+// item.
+type BatchBackend interface {
+	Backend
+
+	// StoreMetaBatch stores messages under their ID like StoreMeta;
+	// messages without an ID fail alone with ErrMissingID
+	StoreMetaBatch(ctx context.Context, messages []MessageMetadata) []error
+
+	// GetMetaBatch reads the messages of ids like GetMeta; unknown IDs
+	// fail with ErrMessageNotFound
+	GetMetaBatch(ctx context.Context, ids []string) ([]MessageMetadata, []error)
+
+	// DeleteMetaBatch deletes the messages of ids like DeleteMeta
+	DeleteMetaBatch(ctx context.Context, ids []string) []error
+}
+
+// StoreMetaBatch stores messages under their ID, e.g. to requeue many
+// deferred messages at once. If the outermost layer of b implements
+// BatchBackend the batch is stored natively, otherwise message by
+// message with StoreMeta. The result has one error per message, see
+// BatchBackend.
+func StoreMetaBatch(ctx context.Context, b Backend, messages []MessageMetadata) []error {
+	if bb, ok := Outer[BatchBackend](b); ok {
+		return bb.StoreMetaBatch(ctx, messages)
+	}
+	errs := make([]error, len(messages))
+	for i, m := range messages {
+		if err := ctx.Err(); err != nil {
+			FailBatch(errs[i:], err)
+			return errs
+		}
+		if m.ID == "" {
+			errs[i] = ErrMissingID
+			continue
+		}
+		if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+			errs[i] = fmt.Errorf("store %s: %w", m.ID, err)
+		}
+	}
+	return errs
+}
+
+// GetMetaBatch reads the messages of ids, in the order of ids. If the
+// outermost layer of b implements BatchBackend the batch is read
+// natively, otherwise with GetMetaMulti. The result has one error per ID,
+// ErrMessageNotFound for unknown ones, see BatchBackend.
+func GetMetaBatch(ctx context.Context, b Backend, ids []string) ([]MessageMetadata, []error) {
+	if bb, ok := Outer[BatchBackend](b); ok {
+		return bb.GetMetaBatch(ctx, ids)
+	}
+	ms := make([]MessageMetadata, len(ids))
+	errs := make([]error, len(ids))
+	found, missing, err := GetMetaMulti(ctx, b, ids)
+	unknown := make(map[string]bool, len(missing))
+	for _, id := range missing {
+		unknown[id] = true
+	}
+	for i, id := range ids {
+		m, ok := found[id]
+		switch {
+		case ok:
+			ms[i] = m
+		case unknown[id] || err == nil:
+			errs[i] = fmt.Errorf("get %s: %w", id, ErrMessageNotFound)
+		default:
+			// the joined failures are not mapped to their IDs
+			errs[i] = fmt.Errorf("get %s: %w", id, err)
+		}
+	}
+	return ms, errs
+}
+
+// DeleteMetaBatch deletes the messages of ids. If the outermost layer of
+// b implements BatchBackend the batch is deleted natively, otherwise
+// message by message with DeleteMeta. The result has one error per ID,
+// see BatchBackend.
+func DeleteMetaBatch(ctx context.Context, b Backend, ids []string) []error {
+	if bb, ok := Outer[BatchBackend](b); ok {
+		return bb.DeleteMetaBatch(ctx, ids)
+	}
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			FailBatch(errs[i:], err)
+			return errs
+		}
+		if err := b.DeleteMeta(ctx, id); err != nil {
+			errs[i] = fmt.Errorf("delete %s: %w", id, err)
+		}
+	}
+	return errs
+}
+
+// FailBatch sets the entries of errs without an error to err and returns
+// errs. Backends implementing BatchBackend use it when the rest of a
+// batch fails.
+func FailBatch(errs []error, err error) []error {
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+	}
+	return errs
+}
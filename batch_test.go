@@ -0,0 +1,52 @@
+package metastorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// plain hides the optional interfaces of the wrapped backend
+type plain struct {
+	metastorage.Backend
+}
+
+func TestBatch(t *testing.T) {
+	for name, b := range map[string]metastorage.Backend{
+		"native":   memory.New(),
+		"fallback": plain{memory.New()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			errs := metastorage.StoreMetaBatch(ctx, b, []metastorage.MessageMetadata{
+				{ID: "m1", State: metastorage.StateDeferred},
+				{ID: "m2", State: metastorage.StateDeferred},
+			})
+			if err := errors.Join(errs...); err != nil || len(errs) != 2 {
+				t.Fatalf("store: %v", errs)
+			}
+
+			ms, errs := metastorage.GetMetaBatch(ctx, b, []string{"m2", "unknown", "m1"})
+			if ms[0].ID != "m2" || ms[2].ID != "m1" || errs[0] != nil || errs[2] != nil {
+				t.Fatalf("get: %v, %v", ms, errs)
+			}
+			if !errors.Is(errs[1], metastorage.ErrMessageNotFound) {
+				t.Fatalf("unknown ID: %v, want not found", errs[1])
+			}
+
+			errs = metastorage.DeleteMetaBatch(ctx, b, []string{"m1", "unknown"})
+			if errs[0] != nil || !errors.Is(errs[1], metastorage.ErrMessageNotFound) {
+				t.Fatalf("delete: %v", errs)
+			}
+			if _, err := b.GetMeta(ctx, "m1"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+				t.Fatalf("m1 after delete: %v", err)
+			}
+			if _, err := b.GetMeta(ctx, "m2"); err != nil {
+				t.Fatalf("m2 after delete: %v", err)
+			}
+		})
+	}
+}
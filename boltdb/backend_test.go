@@ -0,0 +1,29 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := Open(filepath.Join(t.TempDir(), "meta.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestTxn(t *testing.T) {
+	metatest.RunTxnSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := Open(filepath.Join(t.TempDir(), "meta.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
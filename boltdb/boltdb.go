@@ -0,0 +1,571 @@
+// Package boltdb implements metastorage.Backend on a bbolt database file,
+// single-file embedded persistence for small spools.
+//
+// Messages are stored in one bucket per state, keyed by ID. An index
+// bucket maps every ID to its state and a counts bucket holds the number
+// of messages per state, so lookups and counts do not scan. Every
+// operation is a single bbolt transaction, which makes MoveToState atomic
+// with exactly one winner. Iterators scan the state bucket with a cursor,
+// one read transaction per batch, instead of loading the state at once.
+//
+// StoreMeta and MoveToState set StateEnteredAt and assign the next
+// Sequence of the state entered, taken from the sequence of the state
+// bucket, in their transaction.
+//
+// Begin starts a metastorage.Txn whose operations Commit runs in one
+// bbolt transaction.
+//
+// bbolt locks the file: only one process can open it at a time.
+//
+// Importing the package registers the "bolt://" DSN scheme.
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/codec"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// DefaultLockTimeout is how long Open waits for the file lock held by
+// another process
+const DefaultLockTimeout = 5 * time.Second
+
+// rootBucket holds the buckets of backends without a namespace
+const rootBucket = "metastorage"
+
+var (
+	idsBucket    = []byte("ids")
+	countsBucket = []byte("counts")
+)
+
+type lockTimeoutKey struct{}
+
+// WithLockTimeout sets how long Open waits for the file lock (default
+// DefaultLockTimeout)
+func WithLockTimeout(d time.Duration) options.Option {
+	return options.WithValue(lockTimeoutKey{}, d)
+}
+
+func init() {
+	registry.Register("bolt", open)
+}
+
+// open handles "bolt:///var/spool/retryspool/meta.db" DSNs
+func open(_ context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	if dsn.Path == "" {
+		return nil, errors.New("bolt DSN: path is required")
+	}
+	return Open(dsn.Path, opts...)
+}
+
+// Backend stores message metadata in a bbolt database
+type Backend struct {
+	db        *bbolt.DB
+	root      []byte
+	codec     codec.Codec
+	clock     clock.Clock
+	batchSize int
+	skew      time.Duration
+}
+
+// Open opens or creates the database file at path. With a namespace, the
+// data lives in a top-level bucket of that name, so several backends can
+// share a file through one *bbolt.DB, see New.
+func Open(path string, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{
+		Timeout: options.ValueOr(o, lockTimeoutKey{}, DefaultLockTimeout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("boltdb: open %s: %w", path, err)
+	}
+	b, err := New(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// New creates a backend on an open database, creating its buckets if
+// needed. Close closes db.
+func New(db *bbolt.DB, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	root := rootBucket
+	if o.Namespace != "" {
+		root = o.Namespace
+	}
+	b := &Backend{db: db, root: []byte(root), codec: o.Codec, clock: o.Clock, batchSize: o.BatchSize, skew: o.ClockSkew}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		r, err := tx.CreateBucketIfNotExists(b.root)
+		if err != nil {
+			return err
+		}
+		for _, name := range append([][]byte{idsBucket, countsBucket}, stateBuckets()...) {
+			if _, err := r.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("boltdb: create buckets: %w", err)
+	}
+	return b, nil
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (b *Backend) ClockSkew() time.Duration {
+	return b.skew
+}
+
+func stateBuckets() [][]byte {
+	var names [][]byte
+	for _, s := range metastorage.States() {
+		names = append(names, stateKey(s))
+	}
+	return names
+}
+
+// stateKey returns the name of the bucket of state, also used as its key
+// in the ids and counts buckets
+func stateKey(state metastorage.QueueState) []byte {
+	return []byte(metastorage.StateLabel(state))
+}
+
+// buckets are the buckets of a backend within one transaction
+type buckets struct {
+	root, ids, counts *bbolt.Bucket
+}
+
+func (b *Backend) buckets(tx *bbolt.Tx) buckets {
+	r := tx.Bucket(b.root)
+	return buckets{root: r, ids: r.Bucket(idsBucket), counts: r.Bucket(countsBucket)}
+}
+
+// state returns the bucket of state, creating it for states unknown to
+// New in writable transactions
+func (bs buckets) state(state metastorage.QueueState) (*bbolt.Bucket, error) {
+	if s := bs.root.Bucket(stateKey(state)); s != nil {
+		return s, nil
+	}
+	if !bs.root.Writable() {
+		return nil, nil
+	}
+	return bs.root.CreateBucket(stateKey(state))
+}
+
+// add adjusts the count of state by delta
+func (bs buckets) add(state metastorage.QueueState, delta int64) error {
+	n := int64(0)
+	if v := bs.counts.Get(stateKey(state)); len(v) == 8 {
+		n = int64(binary.BigEndian.Uint64(v))
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(max(n+delta, 0)))
+	return bs.counts.Put(stateKey(state), v)
+}
+
+// locate returns the state of id from the index
+func (bs buckets) locate(id string) (metastorage.QueueState, error) {
+	v := bs.ids.Get([]byte(id))
+	if v == nil {
+		return 0, metastorage.ErrMessageNotFound
+	}
+	return metastorage.ParseQueueState(string(v))
+}
+
+func (b *Backend) decode(data []byte, id string, state metastorage.QueueState) (metastorage.MessageMetadata, error) {
+	var m metastorage.MessageMetadata
+	if err := b.codec.Unmarshal(data, &m); err != nil {
+		return metastorage.MessageMetadata{}, fmt.Errorf("boltdb: %s: %w", id, err)
+	}
+	m.ID = id
+	m.State = state
+	return m, nil
+}
+
+// translate maps bbolt errors to metastorage errors
+func translate(err error) error {
+	if errors.Is(err, bbolt.ErrDatabaseNotOpen) {
+		return metastorage.ErrBackendClosed
+	}
+	return err
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID. It assigns the next sequence of the message's state and
+// sets StateEnteredAt if it is unset.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return translate(b.db.Update(func(tx *bbolt.Tx) error {
+		return b.store(b.buckets(tx), messageID, metadata)
+	}))
+}
+
+// store writes the message of StoreMeta to bs
+func (b *Backend) store(bs buckets, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata = metastorage.NormalizeTimes(metastorage.EnterState(metadata, b.clock.Now()))
+	metadata.ID = messageID
+	metadata.Version = 0 // versions are not tracked
+	key := []byte(messageID)
+	if old, err := bs.locate(messageID); err == nil {
+		s, err := bs.state(old)
+		if err != nil {
+			return err
+		}
+		if err := s.Delete(key); err != nil {
+			return err
+		}
+		if err := bs.add(old, -1); err != nil {
+			return err
+		}
+	}
+	s, err := bs.state(metadata.State)
+	if err != nil {
+		return err
+	}
+	if metadata.Sequence, err = s.NextSequence(); err != nil {
+		return err
+	}
+	data, err := b.codec.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := s.Put(key, data); err != nil {
+		return err
+	}
+	if err := bs.ids.Put(key, stateKey(metadata.State)); err != nil {
+		return err
+	}
+	return bs.add(metadata.State, 1)
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	var m metastorage.MessageMetadata
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bs := b.buckets(tx)
+		state, err := bs.locate(messageID)
+		if err != nil {
+			return err
+		}
+		s, _ := bs.state(state)
+		if s == nil {
+			return metastorage.ErrMessageNotFound
+		}
+		data := s.Get([]byte(messageID))
+		if data == nil {
+			return metastorage.ErrMessageNotFound
+		}
+		m, err = b.decode(data, messageID, state)
+		return err
+	})
+	return m, translate(err)
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return translate(b.db.Update(func(tx *bbolt.Tx) error {
+		return b.update(b.buckets(tx), messageID, metadata)
+	}))
+}
+
+// update writes the message of UpdateMeta to bs
+func (b *Backend) update(bs buckets, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := metastorage.RejectVersion(messageID, metadata.Version); err != nil {
+		return err
+	}
+	metadata = metastorage.NormalizeTimes(metadata)
+	metadata.ID = messageID
+	state, err := bs.locate(messageID)
+	if err != nil {
+		return err
+	}
+	if metadata.State != state {
+		return fmt.Errorf("%w: %s is %s, update has %s", metastorage.ErrStateConflict, messageID, state, metadata.State)
+	}
+	s, err := bs.state(state)
+	if err != nil {
+		return err
+	}
+	data, err := b.codec.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return s.Put([]byte(messageID), data)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return translate(b.db.Update(func(tx *bbolt.Tx) error {
+		return b.delete(b.buckets(tx), messageID)
+	}))
+}
+
+// delete removes the message from bs
+func (b *Backend) delete(bs buckets, messageID string) error {
+	state, err := bs.locate(messageID)
+	if err != nil {
+		return err
+	}
+	s, err := bs.state(state)
+	if err != nil {
+		return err
+	}
+	key := []byte(messageID)
+	if err := s.Delete(key); err != nil {
+		return err
+	}
+	if err := bs.ids.Delete(key); err != nil {
+		return err
+	}
+	return bs.add(state, -1)
+}
+
+// MoveToState moves the message between the state buckets in one
+// transaction, recording when it entered toState and assigning the next
+// sequence of toState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return translate(b.db.Update(func(tx *bbolt.Tx) error {
+		return b.move(b.buckets(tx), messageID, fromState, toState)
+	}))
+}
+
+// move moves the message between the state buckets of bs
+func (b *Backend) move(bs buckets, messageID string, fromState, toState metastorage.QueueState) error {
+	state, err := bs.locate(messageID)
+	if err != nil {
+		return err
+	}
+	if state != fromState {
+		return metastorage.ErrStateConflict
+	}
+	if fromState == toState {
+		return nil
+	}
+	from, err := bs.state(fromState)
+	if err != nil {
+		return err
+	}
+	to, err := bs.state(toState)
+	if err != nil {
+		return err
+	}
+	key := []byte(messageID)
+	data := from.Get(key)
+	if data == nil {
+		return metastorage.ErrMessageNotFound
+	}
+	m, err := b.decode(data, messageID, toState)
+	if err != nil {
+		return err
+	}
+	if m.Sequence, err = to.NextSequence(); err != nil {
+		return err
+	}
+	m.StateEnteredAt = b.clock.Now().UTC()
+	if data, err = b.codec.Marshal(m); err != nil {
+		return err
+	}
+	if err := from.Delete(key); err != nil {
+		return err
+	}
+	if err := to.Put(key, data); err != nil {
+		return err
+	}
+	if err := bs.ids.Put(key, stateKey(toState)); err != nil {
+		return err
+	}
+	if err := bs.add(fromState, -1); err != nil {
+		return err
+	}
+	return bs.add(toState, 1)
+}
+
+// LastSequence returns the highest sequence assigned in state, see
+// metastorage.SequenceBackend
+func (b *Backend) LastSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var last uint64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		s, err := b.buckets(tx).state(state)
+		if s != nil {
+			last = s.Sequence()
+		}
+		return err
+	})
+	return last, translate(err)
+}
+
+// TracksStateTime reports that StateEnteredAt is maintained, see
+// metastorage.StateTimeBackend
+func (b *Backend) TracksStateTime() bool {
+	return true
+}
+
+// GetStateCount returns the stored count of state, -1 on errors
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	n := int64(-1)
+	b.db.View(func(tx *bbolt.Tx) error {
+		n = 0
+		if v := b.buckets(tx).counts.Get(stateKey(state)); len(v) == 8 {
+			n = int64(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return n
+}
+
+// ListMessages lists the IDs of messages in state, see
+// metastorage.ListPage for the supported options. The whole state is
+// read; use NewMessageIterator for large states.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	var ms []metastorage.MessageMetadata
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		s, _ := b.buckets(tx).state(state)
+		if s == nil {
+			return nil
+		}
+		return s.ForEach(func(k, v []byte) error {
+			m, err := b.decode(v, string(k), state)
+			if err != nil {
+				return err
+			}
+			ms = append(ms, m)
+			return nil
+		})
+	})
+	if err != nil {
+		return metastorage.MessageListResult{}, translate(err)
+	}
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the messages in state,
+// ordered by ID. Each batch is read in its own transaction, continuing
+// after the last returned ID, so changes between batches are visible.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &iterator{backend: b, state: state, batchSize: max(batchSize, 1)}, nil
+}
+
+// Close closes the database
+func (b *Backend) Close() error {
+	return b.db.Close()
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	batchSize int
+	after     []byte // last key read, nil before the first batch
+	batch     []metastorage.MessageMetadata
+	done      bool
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	if len(it.batch) == 0 && !it.done {
+		if err := it.fetch(); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	if len(it.batch) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+// fetch reads the next batch with a cursor positioned after the last key
+func (it *iterator) fetch() error {
+	b := it.backend
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		s, _ := b.buckets(tx).state(it.state)
+		if s == nil {
+			it.done = true
+			return nil
+		}
+		c := s.Cursor()
+		var k, v []byte
+		if it.after == nil {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(it.after)
+			if k != nil && string(k) == string(it.after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && len(it.batch) < it.batchSize; k, v = c.Next() {
+			m, err := b.decode(v, string(k), it.state)
+			if err != nil {
+				return err
+			}
+			it.batch = append(it.batch, m)
+			it.after = append(it.after[:0], k...)
+		}
+		if k == nil {
+			it.done = true
+		}
+		return nil
+	})
+	return translate(err)
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *iterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = n
+	}
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.done = true
+	it.batch = nil
+	return nil
+}
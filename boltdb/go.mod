@@ -0,0 +1,12 @@
+module schneider.vip/retryspool/storage/meta/boltdb
+
+go 1.25.0
+
+require (
+	go.etcd.io/bbolt v1.5.0
+	schneider.vip/retryspool/storage/meta v0.0.0
+)
+
+require golang.org/x/sys v0.45.0 // indirect
+
+replace schneider.vip/retryspool/storage/meta => ..
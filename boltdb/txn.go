@@ -0,0 +1,46 @@
+package boltdb
+
+import (
+	"context"
+
+	bbolt "go.etcd.io/bbolt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Begin starts a transaction committed in one bbolt transaction, see
+// metastorage.TxnBackend
+func (b *Backend) Begin(ctx context.Context) (metastorage.Txn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metastorage.NewTxn(b.commit), nil
+}
+
+// commit runs ops in a bbolt transaction, which a failing operation rolls
+// back
+func (b *Backend) commit(ctx context.Context, ops []metastorage.TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return translate(b.db.Update(func(tx *bbolt.Tx) error {
+		bs := b.buckets(tx)
+		for i, op := range ops {
+			var err error
+			switch op.Kind {
+			case metastorage.TxnStore:
+				err = b.store(bs, op.ID, op.Metadata)
+			case metastorage.TxnUpdate:
+				err = b.update(bs, op.ID, op.Metadata)
+			case metastorage.TxnMove:
+				err = b.move(bs, op.ID, op.From, op.To)
+			case metastorage.TxnDelete:
+				err = b.delete(bs, op.ID)
+			}
+			if err != nil {
+				return op.Err(i, err)
+			}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,99 @@
+// Package bridge coordinates the metadata backend with the retryspool
+// data storage holding message bodies, so integrators do not have to get
+// the write ordering right themselves:
+//
+//   - StoreMessage writes the body first and the metadata second. The
+//     metadata makes a message visible to workers, so a crash in between
+//     leaves an unreferenced body but never metadata without a body. If
+//     storing the metadata fails, the body is removed again.
+//   - DeleteMessage removes the metadata first and the body second, for
+//     the same reason.
+//
+// Bodies orphaned by crashes can be found by comparing the data storage
+// with the metadata backend and removed at leisure.
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// DataBackend is the part of the retryspool data storage backend the
+// bridge needs
+type DataBackend interface {
+	// StoreData stores the message body and returns its size
+	StoreData(ctx context.Context, messageID string, data io.Reader) (int64, error)
+
+	// DeleteData removes the message body
+	DeleteData(ctx context.Context, messageID string) error
+}
+
+// Store combines a metadata and a data backend
+type Store struct {
+	Meta metastorage.Backend
+	Data DataBackend
+}
+
+// New creates a Store
+func New(meta metastorage.Backend, data DataBackend) *Store {
+	return &Store{Meta: meta, Data: data}
+}
+
+// StoreMessage stores the body read from data, then the metadata. Size is
+// set to the stored body size if it is 0. If the metadata cannot be
+// stored, the body is deleted and both errors are returned.
+func (s *Store) StoreMessage(ctx context.Context, messageID string, metadata metastorage.MessageMetadata, data io.Reader) error {
+	size, err := s.Data.StoreData(ctx, messageID, data)
+	if err != nil {
+		return fmt.Errorf("bridge: store body of %s: %w", messageID, err)
+	}
+	if metadata.Size == 0 {
+		metadata.Size = size
+	}
+	if err := s.Meta.StoreMeta(ctx, messageID, metadata); err != nil {
+		err = fmt.Errorf("bridge: store metadata of %s: %w", messageID, err)
+		// the caller's ctx may be what failed; cleanup must still run
+		if derr := s.Data.DeleteData(context.WithoutCancel(ctx), messageID); derr != nil {
+			err = errors.Join(err, fmt.Errorf("bridge: remove body of %s: %w", messageID, derr))
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteMessage deletes the metadata, then the body. A message whose
+// metadata is already gone still has its body deleted.
+func (s *Store) DeleteMessage(ctx context.Context, messageID string) error {
+	err := s.Meta.DeleteMeta(ctx, messageID)
+	if err != nil && !errors.Is(err, metastorage.ErrMessageNotFound) {
+		return fmt.Errorf("bridge: delete metadata of %s: %w", messageID, err)
+	}
+	if err := s.Data.DeleteData(ctx, messageID); err != nil {
+		return fmt.Errorf("bridge: delete body of %s: %w", messageID, err)
+	}
+	return nil
+}
+
+// OrphanedData returns the IDs in dataIDs without metadata, i.e. bodies
+// left behind by crashes between the two writes of StoreMessage or
+// DeleteMessage. dataIDs is usually a listing of the data storage.
+// Bodies being stored concurrently are reported too, so only delete
+// orphans older than the longest expected StoreMessage call.
+func (s *Store) OrphanedData(ctx context.Context, dataIDs []string) ([]string, error) {
+	var orphans []string
+	for _, id := range dataIDs {
+		_, err := s.Meta.GetMeta(ctx, id)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			orphans = append(orphans, id)
+			continue
+		}
+		if err != nil {
+			return orphans, err
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,94 @@
+package metastorage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+)
+
+// BulkMoveBackend is implemented by backends moving many messages between
+// states in one operation, e.g. one UPDATE in PostgreSQL, instead of one
+// MoveToState per message
+type BulkMoveBackend interface {
+	Backend
+
+	// MoveStateBulk moves the messages of fromState matching filter to
+	// toState, see the MoveStateBulk function
+	MoveStateBulk(ctx context.Context, fromState, toState QueueState, filter func(MessageMetadata) bool) (int, error)
+}
+
+// MoveStateBulk moves all messages of fromState to toState, or only those
+// filter returns true for if it is not nil, and returns how many were
+// moved; e.g. requeuing everything on hold:
+//
+//	n, err := metastorage.MoveStateBulk(ctx, b, metastorage.StateHold, metastorage.StateIncoming, nil)
+//
+// Moved messages take sequences of toState in their order in fromState.
+// Moving a state to itself moves nothing.
+//
+// If the outermost layer of b implements BulkMoveBackend the messages are
+// moved natively; otherwise the matching IDs are read first and moved one
+// by one with MoveToState, skipping messages that were moved or deleted
+// in between. The fallback is not atomic: after an error the messages
+// moved before stay moved and are included in the count.
+func MoveStateBulk(ctx context.Context, b Backend, fromState, toState QueueState, filter func(MessageMetadata) bool) (int, error) {
+	if fromState == toState {
+		return 0, ctx.Err()
+	}
+	if bb, ok := Outer[BulkMoveBackend](b); ok {
+		return bb.MoveStateBulk(ctx, fromState, toState, filter)
+	}
+	ids, err := matching(ctx, b, fromState, filter)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, id := range ids {
+		err := b.MoveToState(ctx, id, fromState, toState)
+		switch {
+		case err == nil:
+			moved++
+		case errors.Is(err, ErrStateConflict), errors.Is(err, ErrMessageNotFound):
+		default:
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// matching reads the IDs of the messages of state filter returns true
+// for, ordered by sequence, then ID. They are read before moving any, as
+// moves would change the state being iterated.
+func matching(ctx context.Context, b Backend, state QueueState, filter func(MessageMetadata) bool) ([]string, error) {
+	iter, err := b.NewMessageIterator(ctx, state, 100)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	type key struct {
+		seq uint64
+		id  string
+	}
+	var keys []key
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		if filter == nil || filter(m) {
+			keys = append(keys, key{m.Sequence, m.ID})
+		}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		return cmp.Or(cmp.Compare(a.seq, b.seq), cmp.Compare(a.id, b.id))
+	})
+	ids := make([]string, len(keys))
+	for i, k := range keys {
+		ids[i] = k.id
+	}
+	return ids, nil
+}
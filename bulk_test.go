@@ -0,0 +1,61 @@
+package metastorage_test
+
+import (
+	"context"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func TestMoveStateBulk(t *testing.T) {
+	for name, wrap := range map[string]func(*memory.Backend) metastorage.Backend{
+		"native":  func(b *memory.Backend) metastorage.Backend { return b },
+		"generic": func(b *memory.Backend) metastorage.Backend { return plain{b} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			b := wrap(memory.New())
+			for _, id := range []string{"c", "a", "b", "d"} {
+				if err := b.StoreMeta(ctx, id, metastorage.MessageMetadata{ID: id, State: metastorage.StateHold}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := b.StoreMeta(ctx, "x", metastorage.MessageMetadata{ID: "x", State: metastorage.StateDeferred}); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := metastorage.MoveStateBulk(ctx, b, metastorage.StateHold, metastorage.StateIncoming, func(m metastorage.MessageMetadata) bool {
+				return m.ID != "d"
+			})
+			if err != nil || n != 3 {
+				t.Fatalf("filtered move: %d, %v, want 3", n, err)
+			}
+			// sequences follow the order the messages were stored on hold
+			var last uint64
+			for _, id := range []string{"c", "a", "b"} {
+				m, err := b.GetMeta(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if m.State != metastorage.StateIncoming || m.Sequence <= last {
+					t.Errorf("%s: %s with sequence %d after %d", id, m.State, m.Sequence, last)
+				}
+				last = m.Sequence
+			}
+
+			if n, err := metastorage.MoveStateBulk(ctx, b, metastorage.StateHold, metastorage.StateIncoming, nil); err != nil || n != 1 {
+				t.Errorf("move all: %d, %v, want 1", n, err)
+			}
+			if n, err := metastorage.MoveStateBulk(ctx, b, metastorage.StateHold, metastorage.StateIncoming, nil); err != nil || n != 0 {
+				t.Errorf("move of empty state: %d, %v, want 0", n, err)
+			}
+			if n, err := metastorage.MoveStateBulk(ctx, b, metastorage.StateDeferred, metastorage.StateDeferred, nil); err != nil || n != 0 {
+				t.Errorf("move to the same state: %d, %v, want 0", n, err)
+			}
+			if m, err := b.GetMeta(ctx, "x"); err != nil || m.State != metastorage.StateDeferred {
+				t.Errorf("deferred message: %v, %v", m.State, err)
+			}
+		})
+	}
+}
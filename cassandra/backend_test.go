@@ -0,0 +1,29 @@
+package cassandra
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// TestMoveRace runs against the server in META_TEST_CASSANDRA_DSN, e.g.
+// "cassandra://localhost:9042/meta_test"; every subtest uses a namespace of
+// its own.
+func TestMoveRace(t *testing.T) {
+	dsn := os.Getenv("META_TEST_CASSANDRA_DSN")
+	if dsn == "" {
+		t.Skip("META_TEST_CASSANDRA_DSN not set")
+	}
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := registry.Open(context.Background(), dsn, options.WithNamespace(metatest.Namespace()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
@@ -0,0 +1,117 @@
+package metastorage
+
+import "reflect"
+
+// Middleware decorates a Backend with additional behavior (logging, metrics, retries, ...)
+type Middleware func(Backend) Backend
+
+// Wrapper is implemented by decorators that expose the backend they wrap
+type Wrapper interface {
+	// Unwrap returns the next backend in the decorator chain
+	Unwrap() Backend
+}
+
+// Chain applies middlewares to backend. The first middleware becomes the
+// outermost layer, so Chain(b, a, c) is equivalent to a(c(b)).
+//
+// Every layer is passed through Wrap, which keeps optional extension
+// interfaces of the inner backend visible on the decorated result.
+func Chain(backend Backend, middlewares ...Middleware) Backend {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		backend = Wrap(backend, middlewares[i](backend))
+	}
+	return backend
+}
+
+// Wrap returns outer augmented with the optional extension interfaces of
+// inner that outer does not implement itself.
+//
+// Currently StateCounterBackend is forwarded directly, unless outer has
+// methods of its own beyond Backend, Wrapper and TxnDecorator (as drain
+// has those of DrainBackend), which the forwarding layer would hide. Such
+// layers are returned as they are. Begin looks through the forwarding
+// layer, so WrapTxn is not hidden. Extension interfaces that are not
+// forwarded can still be reached with As.
+func Wrap(inner, outer Backend) Backend {
+	if inner == nil || outer == nil || inner == outer {
+		return outer
+	}
+	if _, ok := outer.(StateCounterBackend); ok {
+		return outer
+	}
+	counter, ok := inner.(StateCounterBackend)
+	if !ok || hasOwnMethods(outer) {
+		return outer
+	}
+	return &stateCounterForwarder{Backend: outer, counter: counter}
+}
+
+// forwarderType is the type whose method set a forwarded layer keeps
+var forwarderType = reflect.TypeOf(&stateCounterForwarder{})
+
+// hasOwnMethods reports whether b has methods a stateCounterForwarder
+// around it would not expose, other than WrapTxn
+func hasOwnMethods(b Backend) bool {
+	t := reflect.TypeOf(b)
+	for i := 0; i < t.NumMethod(); i++ {
+		name := t.Method(i).Name
+		if _, ok := forwarderType.MethodByName(name); !ok && name != "WrapTxn" {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the backend wrapped by b, or nil if b is not a Wrapper
+func Unwrap(b Backend) Backend {
+	if w, ok := b.(Wrapper); ok {
+		return w.Unwrap()
+	}
+	return nil
+}
+
+// As walks the decorator chain starting at b and returns the first layer
+// implementing T. Calls made through the returned value bypass all layers
+// above it.
+func As[T any](b Backend) (T, bool) {
+	for b != nil {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		b = Unwrap(b)
+	}
+	var zero T
+	return zero, false
+}
+
+// Outer returns b as T if its outermost layer implements T. Unlike As it
+// only looks through the transparent layers added by Wrap, so calls made
+// through the result still pass every decorator of the chain.
+func Outer[T any](b Backend) (T, bool) {
+	for b != nil {
+		if t, ok := b.(T); ok {
+			return t, true
+		}
+		f, ok := b.(*stateCounterForwarder)
+		if !ok {
+			break
+		}
+		b = f.Backend
+	}
+	var zero T
+	return zero, false
+}
+
+// stateCounterForwarder adds GetStateCount of an inner backend to a decorator
+type stateCounterForwarder struct {
+	Backend
+	counter StateCounterBackend
+}
+
+func (f *stateCounterForwarder) GetStateCount(state QueueState) int64 {
+	return f.counter.GetStateCount(state)
+}
+
+func (f *stateCounterForwarder) Unwrap() Backend {
+	return f.Backend
+}
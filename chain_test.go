@@ -0,0 +1,24 @@
+package metastorage_test
+
+import (
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/middleware/drain"
+	"schneider.vip/retryspool/storage/meta/middleware/logging"
+)
+
+func TestWrapKeepsInterfacesOfBothLayers(t *testing.T) {
+	if _, ok := logging.New(memory.New()).(metastorage.StateCounterBackend); !ok {
+		t.Error("state counter of the inner backend hidden by logging")
+	}
+
+	b := drain.New(memory.New())
+	if _, ok := b.(metastorage.DrainBackend); !ok {
+		t.Error("DrainBackend of the decorator hidden by Wrap")
+	}
+	if _, ok := metastorage.As[metastorage.StateCounterBackend](b); !ok {
+		t.Error("state counter of the inner backend not reachable with As")
+	}
+}
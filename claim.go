@@ -0,0 +1,421 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/clock"
+)
+
+// Lease headers set on claimed messages
+const (
+	HeaderLeaseOwner   = ReservedHeaderPrefix + "lease-owner"   // worker ID
+	HeaderLeaseExpires = ReservedHeaderPrefix + "lease-expires" // RFC 3339 UTC
+	HeaderClaimedFrom  = ReservedHeaderPrefix + "claimed-from"  // state the message was claimed from
+)
+
+// ErrLeaseLost is returned when a lease cannot be extended because the
+// message is no longer claimed by the worker
+var ErrLeaseLost = errors.New("lease lost")
+
+// claimOverscan is how many candidates per requested message the generic
+// ClaimBatch keeps, to make up for claims lost to other workers
+const claimOverscan = 4
+
+// ClaimBatchBackend extends Backend with native batch claiming, e.g. a
+// single UPDATE ... RETURNING or FOR UPDATE SKIP LOCKED query
+type ClaimBatchBackend interface {
+	Backend
+
+	// ClaimBatch atomically claims up to n due messages of state for
+	// workerID, see the ClaimBatch function
+	ClaimBatch(ctx context.Context, state QueueState, workerID string, n int, lease time.Duration) ([]MessageMetadata, error)
+}
+
+// ClaimBackend is implemented by backends that claim, extend and release
+// leases each in one atomic step, e.g. under one lock, instead of the
+// MoveToState and UpdateMeta pairs of the ClaimNext, ExtendLease and
+// Release functions
+type ClaimBackend interface {
+	Backend
+
+	// ClaimNext claims the next due message of state for workerID, see
+	// the ClaimNext function
+	ClaimNext(ctx context.Context, state QueueState, workerID string, lease time.Duration) (MessageMetadata, bool, error)
+
+	// ExtendLease renews the lease of a message claimed by workerID, see
+	// the ExtendLease function
+	ExtendLease(ctx context.Context, messageID, workerID string, lease time.Duration) (time.Time, error)
+
+	// Release gives up the lease of a message claimed by workerID, see
+	// the Release function
+	Release(ctx context.Context, messageID, workerID string) error
+}
+
+// OrderedBackend is implemented by decorators whose iterators return the
+// messages of some states in the order they must be claimed, e.g. arrival
+// order. For those states claim candidates keep the iteration order
+// instead of being sorted by priority.
+type OrderedBackend interface {
+	Backend
+
+	// ClaimsInIterationOrder reports whether messages of state must be
+	// claimed in iteration order
+	ClaimsInIterationOrder(state QueueState) bool
+}
+
+// LeaseOf returns the lease owner and expiry of a claimed message
+func LeaseOf(m MessageMetadata) (owner string, expires time.Time, ok bool) {
+	owner, ok = m.Headers[HeaderLeaseOwner]
+	if !ok {
+		return "", time.Time{}, false
+	}
+	expires, _ = time.Parse(time.RFC3339Nano, m.Headers[HeaderLeaseExpires])
+	return owner, expires, true
+}
+
+// SetLease returns m with the lease headers of a message claimed from
+// state from by owner until expires. The headers of m are copied, not
+// modified.
+func SetLease(m MessageMetadata, owner string, from QueueState, expires time.Time) MessageMetadata {
+	headers := make(map[string]string, len(m.Headers)+3)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[HeaderClaimedFrom] = from.String()
+	headers[HeaderLeaseOwner] = owner
+	headers[HeaderLeaseExpires] = expires.UTC().Format(time.RFC3339Nano)
+	m.Headers = headers
+	return m
+}
+
+// ClearLease returns m without the lease headers set by claiming
+func ClearLease(m MessageMetadata) MessageMetadata {
+	headers := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		switch k {
+		case HeaderLeaseOwner, HeaderLeaseExpires, HeaderClaimedFrom:
+		default:
+			headers[k] = v
+		}
+	}
+	m.Headers = headers
+	return m
+}
+
+// IsDue reports whether m may be delivered at now: its NextRetry has
+// passed, tolerating clock.DefaultSkewTolerance of clock skew, and its
+// delivery window allows delivery
+func IsDue(m MessageMetadata, now time.Time) bool {
+	return IsDueWithin(m, now, clock.DefaultSkewTolerance)
+}
+
+// IsDueWithin is IsDue tolerating skew of clock difference, see clock.Due
+func IsDueWithin(m MessageMetadata, now time.Time, skew time.Duration) bool {
+	return clock.Due(m.NextRetry, now, skew) && m.DeliveryWindow.Allows(now)
+}
+
+// ClockSkew returns the clock skew tolerated by due checks on b: the
+// window of the first SkewBackend layer, or clock.DefaultSkewTolerance
+func ClockSkew(b Backend) time.Duration {
+	if s, ok := As[SkewBackend](b); ok {
+		return s.ClockSkew()
+	}
+	return clock.DefaultSkewTolerance
+}
+
+// serverNow returns the time of the first ServerTimeBackend layer of b,
+// so the due checks and lease expiries of nodes with skewed clocks agree,
+// or the local time if there is none
+func serverNow(ctx context.Context, b Backend) (time.Time, error) {
+	if st, ok := As[ServerTimeBackend](b); ok {
+		now, err := st.ServerTime(ctx)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("server time: %w", err)
+		}
+		return now.UTC(), nil
+	}
+	return time.Now().UTC(), nil
+}
+
+// ClaimBatch claims up to n due messages of state for workerID: each is
+// moved to StateActive and marked with the lease headers, expiring after
+// lease. Messages are claimed by descending Priority, then oldest
+// NextRetry first, or in iteration order for states of an
+// OrderedBackend. Every returned message is owned by workerID alone; fewer
+// than n are returned when fewer are due or other workers won the race for
+// some of them.
+//
+// If the outermost layer of b implements ClaimBatchBackend it claims
+// natively; decorators implement it only to forward to the next layer
+// with their own side effects, so a native claim deeper in the chain is
+// never reached past a decorator that does not. Otherwise the state is
+// scanned once and candidates are claimed one by one with
+// MoveToState, whose CAS guarantees exclusive ownership. If claiming fails
+// midway, the messages claimed so far are returned with the error. Due
+// checks and lease expiries use the clock of the backend server if a
+// layer implements ServerTimeBackend.
+func ClaimBatch(ctx context.Context, b Backend, state QueueState, workerID string, n int, lease time.Duration) ([]MessageMetadata, error) {
+	if state == StateActive {
+		return nil, fmt.Errorf("%w: cannot claim from %s", ErrInvalidState, state)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	if c, ok := Outer[ClaimBatchBackend](b); ok {
+		return c.ClaimBatch(ctx, state, workerID, n, lease)
+	}
+
+	now, err := serverNow(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := DueMessages(ctx, b, state, now, n*claimOverscan)
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]MessageMetadata, 0, n)
+	for _, c := range candidates {
+		if len(claimed) == n {
+			break
+		}
+		m, err := claim(ctx, b, c.ID, state, workerID, now, lease)
+		if errors.Is(err, ErrStateConflict) || errors.Is(err, ErrMessageNotFound) {
+			continue // another worker was faster
+		}
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, m)
+	}
+	return claimed, nil
+}
+
+// DueMessages returns up to limit messages of state that are due at now
+// within the ClockSkew of b, in the order ClaimBatch claims them
+func DueMessages(ctx context.Context, b Backend, state QueueState, now time.Time, limit int) ([]MessageMetadata, error) {
+	return dueMessages(ctx, b, state, now, limit, nil)
+}
+
+// dueMessages is DueMessages restricted to messages accepted by keep (nil
+// keeps all)
+func dueMessages(ctx context.Context, b Backend, state QueueState, now time.Time, limit int, keep func(MessageMetadata) bool) ([]MessageMetadata, error) {
+	iter, err := b.NewMessageIterator(ctx, state, 100)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	skew := ClockSkew(b)
+	ordered := false
+	if o, ok := As[OrderedBackend](b); ok {
+		ordered = o.ClaimsInIterationOrder(state)
+	}
+	var due []MessageMetadata
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		if !IsDueWithin(m, now, skew) || (keep != nil && !keep(m)) {
+			continue
+		}
+		due = append(due, m)
+		if ordered {
+			if len(due) == limit {
+				break
+			}
+			continue
+		}
+		// trim periodically instead of per message to keep the scan cheap
+		if len(due) >= 2*limit {
+			sortClaimOrder(due)
+			due = due[:limit]
+		}
+	}
+	if !ordered {
+		sortClaimOrder(due)
+	}
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func sortClaimOrder(ms []MessageMetadata) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ClaimsBefore(ms[i], ms[j])
+	})
+}
+
+// ClaimsBefore reports whether a is claimed before b: by descending
+// Priority, then oldest NextRetry, then arrival Sequence
+func ClaimsBefore(a, b MessageMetadata) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if !a.NextRetry.Equal(b.NextRetry) {
+		return a.NextRetry.Before(b.NextRetry)
+	}
+	return a.Sequence < b.Sequence
+}
+
+// ClaimNext claims the next due message of state for workerID like
+// ClaimBatch with n 1, reporting false if none is due. It is the checkout
+// of a worker: the message is active and owned by workerID until the
+// lease expires, is extended with ExtendLease or given up with Release.
+// If the outermost layer of b implements ClaimBackend it claims natively.
+func ClaimNext(ctx context.Context, b Backend, state QueueState, workerID string, lease time.Duration) (MessageMetadata, bool, error) {
+	if state == StateActive {
+		return MessageMetadata{}, false, fmt.Errorf("%w: cannot claim from %s", ErrInvalidState, state)
+	}
+	if c, ok := Outer[ClaimBackend](b); ok {
+		return c.ClaimNext(ctx, state, workerID, lease)
+	}
+	claimed, err := ClaimBatch(ctx, b, state, workerID, 1, lease)
+	if len(claimed) == 0 {
+		return MessageMetadata{}, false, err
+	}
+	return claimed[0], true, err
+}
+
+// Claim claims a single message of state from for workerID like
+// ClaimBatch, without checking whether it is due. It fails with
+// ErrStateConflict if the message is not in from, e.g. because another
+// worker claimed it first.
+func Claim(ctx context.Context, b Backend, messageID string, from QueueState, workerID string, lease time.Duration) (MessageMetadata, error) {
+	if from == StateActive {
+		return MessageMetadata{}, fmt.Errorf("%w: cannot claim from %s", ErrInvalidState, from)
+	}
+	now, err := serverNow(ctx, b)
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+	return claim(ctx, b, messageID, from, workerID, now, lease)
+}
+
+// claim moves one message to StateActive and records the lease, expiring
+// lease after now
+func claim(ctx context.Context, b Backend, id string, from QueueState, workerID string, now time.Time, lease time.Duration) (MessageMetadata, error) {
+	if err := b.MoveToState(ctx, id, from, StateActive); err != nil {
+		return MessageMetadata{}, err
+	}
+	m, err := b.GetMeta(ctx, id)
+	if err != nil {
+		return MessageMetadata{}, err
+	}
+	m = SetLease(m, workerID, from, now.Add(lease))
+	m.State = StateActive
+	m.Updated = now
+	if err := b.UpdateMeta(ctx, id, m); err != nil {
+		return MessageMetadata{}, err
+	}
+	return m, nil
+}
+
+// ExtendLease moves the lease expiry of a message claimed by workerID to
+// lease from now and returns the new expiry. It fails with ErrLeaseLost if
+// the message left StateActive or is claimed by another worker. If the
+// outermost layer of b implements ClaimBackend it extends natively.
+func ExtendLease(ctx context.Context, b Backend, messageID, workerID string, lease time.Duration) (time.Time, error) {
+	if c, ok := Outer[ClaimBackend](b); ok {
+		return c.ExtendLease(ctx, messageID, workerID, lease)
+	}
+	m, err := leased(ctx, b, messageID, workerID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now, err := serverNow(ctx, b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expires := now.Add(lease)
+	headers := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[HeaderLeaseExpires] = expires.Format(time.RFC3339Nano)
+	m.Headers = headers
+	m.Updated = now
+	if err := b.UpdateMeta(ctx, messageID, m); err != nil {
+		return time.Time{}, err
+	}
+	return expires, nil
+}
+
+// Release gives up the lease of a message claimed by workerID without
+// finishing it: the lease headers are removed and the message returns to
+// the state it was claimed from, or StateDeferred if that is unknown, to
+// be claimed again. It fails with ErrLeaseLost if the message left
+// StateActive or is claimed by another worker. If the outermost layer of
+// b implements ClaimBackend it releases natively.
+//
+// On backends tracking versions the update clearing the lease is
+// conditional on the version read and the move on the version the update
+// wrote, so a message changed in between is neither overwritten nor
+// moved; the release then fails with ErrVersionConflict.
+func Release(ctx context.Context, b Backend, messageID, workerID string) error {
+	if c, ok := Outer[ClaimBackend](b); ok {
+		return c.Release(ctx, messageID, workerID)
+	}
+	m, err := leased(ctx, b, messageID, workerID)
+	if err != nil {
+		return err
+	}
+	now, err := serverNow(ctx, b)
+	if err != nil {
+		return err
+	}
+	from := ReleaseState(m)
+	m = ClearLease(m)
+	m.Updated = now
+	if err := b.UpdateMeta(ctx, messageID, m); err != nil {
+		return err
+	}
+	if m.Version != 0 {
+		return MoveToStateIfVersion(ctx, b, messageID, StateActive, from, m.Version+1)
+	}
+	return b.MoveToState(ctx, messageID, StateActive, from)
+}
+
+// ReleaseState returns the state a released message returns to: the
+// state it was claimed from, or StateDeferred
+func ReleaseState(m MessageMetadata) QueueState {
+	from, err := ParseQueueState(m.Headers[HeaderClaimedFrom])
+	if err != nil || from == StateActive {
+		return StateDeferred
+	}
+	return from
+}
+
+// leased returns a message if it is active and claimed by workerID, and
+// fails with ErrLeaseLost otherwise
+func leased(ctx context.Context, b Backend, messageID, workerID string) (MessageMetadata, error) {
+	m, err := b.GetMeta(ctx, messageID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return m, fmt.Errorf("%w: %s was removed", ErrLeaseLost, messageID)
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := CheckLease(m, workerID); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// CheckLease returns ErrLeaseLost unless m is active and claimed by
+// workerID
+func CheckLease(m MessageMetadata, workerID string) error {
+	owner, _, ok := LeaseOf(m)
+	if m.State != StateActive || !ok || owner != workerID {
+		return fmt.Errorf("%w: %s is not claimed by %s", ErrLeaseLost, m.ID, workerID)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package metastorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// store keeps messages in a map; other Backend methods are not used
+type store struct {
+	metastorage.Backend
+	messages map[string]metastorage.MessageMetadata
+}
+
+func (s *store) GetMeta(_ context.Context, id string) (metastorage.MessageMetadata, error) {
+	m, ok := s.messages[id]
+	if !ok {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func (s *store) UpdateMeta(_ context.Context, id string, m metastorage.MessageMetadata) error {
+	if _, ok := s.messages[id]; !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	s.messages[id] = m
+	return nil
+}
+
+func (s *store) MoveToState(_ context.Context, id string, from, to metastorage.QueueState) error {
+	m, ok := s.messages[id]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	if m.State != from {
+		return metastorage.ErrStateConflict
+	}
+	m.State = to
+	s.messages[id] = m
+	return nil
+}
+
+func (s *store) NewMessageIterator(_ context.Context, state metastorage.QueueState, _ int) (metastorage.MessageIterator, error) {
+	var ms []metastorage.MessageMetadata
+	for _, m := range s.messages {
+		if m.State == state {
+			ms = append(ms, m)
+		}
+	}
+	return &sliceIterator{messages: ms}, nil
+}
+
+type sliceIterator struct {
+	messages []metastorage.MessageMetadata
+}
+
+func (it *sliceIterator) Next(context.Context) (metastorage.MessageMetadata, bool, error) {
+	if len(it.messages) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.messages[0]
+	it.messages = it.messages[1:]
+	return m, true, nil
+}
+
+func (it *sliceIterator) Close() error { return nil }
+
+// nativeClaimer counts native ClaimBatch calls and claims nothing
+type nativeClaimer struct {
+	*store
+	calls int
+}
+
+func (n *nativeClaimer) ClaimBatch(context.Context, metastorage.QueueState, string, int, time.Duration) ([]metastorage.MessageMetadata, error) {
+	n.calls++
+	return nil, nil
+}
+
+// passthrough is a decorator that does not forward ClaimBatch
+type passthrough struct {
+	metastorage.Backend
+}
+
+func (p passthrough) Unwrap() metastorage.Backend {
+	return p.Backend
+}
+
+func TestClaimBatchNativeOnlyThroughOutermostLayer(t *testing.T) {
+	ctx := context.Background()
+	native := &nativeClaimer{store: &store{messages: map[string]metastorage.MessageMetadata{
+		"m1": {ID: "m1", State: metastorage.StateIncoming},
+	}}}
+
+	if _, err := metastorage.ClaimBatch(ctx, native, metastorage.StateIncoming, "w", 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if native.calls != 1 {
+		t.Fatalf("native claims = %d, want 1", native.calls)
+	}
+
+	decorated := metastorage.Chain(native, func(b metastorage.Backend) metastorage.Backend { return passthrough{b} })
+	claimed, err := metastorage.ClaimBatch(ctx, decorated, metastorage.StateIncoming, "w", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if native.calls != 1 {
+		t.Fatalf("native claim bypassed the decorator: %d calls", native.calls)
+	}
+	if len(claimed) != 1 || claimed[0].ID != "m1" || claimed[0].State != metastorage.StateActive {
+		t.Fatalf("claimed %+v, want m1 through the generic path", claimed)
+	}
+	if owner, _, ok := metastorage.LeaseOf(native.messages["m1"]); !ok || owner != "w" {
+		t.Fatalf("lease owner = %q, %v; want w", owner, ok)
+	}
+}
+
+func TestClaimBatchPriorityOrder(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	s := &store{messages: map[string]metastorage.MessageMetadata{
+		"low":    {ID: "low", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Hour)},
+		"high":   {ID: "high", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Minute), Priority: 5},
+		"later":  {ID: "later", State: metastorage.StateDeferred, NextRetry: now.Add(time.Hour), Priority: 9},
+		"active": {ID: "active", State: metastorage.StateActive},
+	}}
+	claimed, err := metastorage.ClaimBatch(ctx, s, metastorage.StateDeferred, "w", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 || claimed[0].ID != "high" || claimed[1].ID != "low" {
+		t.Fatalf("claimed %+v, want high then low", claimed)
+	}
+}
+
+func TestIsDueWithinSkew(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := metastorage.MessageMetadata{NextRetry: now.Add(time.Second)}
+	if metastorage.IsDueWithin(m, now, 0) {
+		t.Fatal("due before NextRetry without skew")
+	}
+	if !metastorage.IsDueWithin(m, now, 2*time.Second) {
+		t.Fatal("not due within the skew window")
+	}
+}
+
+func TestClaimNextExtendRelease(t *testing.T) {
+	for name, wrap := range map[string]func(*memory.Backend) metastorage.Backend{
+		"native":  func(b *memory.Backend) metastorage.Backend { return b },
+		"generic": func(b *memory.Backend) metastorage.Backend { return passthrough{b} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			b := wrap(memory.New())
+			now := time.Now().UTC()
+			for _, m := range []metastorage.MessageMetadata{
+				{ID: "low", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Minute)},
+				{ID: "high", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Minute), Priority: 5},
+				{ID: "later", State: metastorage.StateDeferred, NextRetry: now.Add(time.Hour), Priority: 9},
+			} {
+				if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m, ok, err := metastorage.ClaimNext(ctx, b, metastorage.StateDeferred, "w1", time.Minute)
+			if err != nil || !ok || m.ID != "high" {
+				t.Fatalf("first claim: %s %v %v, want high", m.ID, ok, err)
+			}
+			if owner, _, _ := metastorage.LeaseOf(m); owner != "w1" || m.State != metastorage.StateActive {
+				t.Errorf("claimed: owner %q in %s", owner, m.State)
+			}
+			if _, err := metastorage.ExtendLease(ctx, b, "high", "w2", time.Minute); !errors.Is(err, metastorage.ErrLeaseLost) {
+				t.Errorf("extend by other worker: %v, want lease lost", err)
+			}
+			expires, err := metastorage.ExtendLease(ctx, b, "high", "w1", time.Hour)
+			if err != nil || expires.Before(now.Add(59*time.Minute)) {
+				t.Errorf("extend: %v %v", expires, err)
+			}
+
+			if err := metastorage.Release(ctx, b, "high", "w2"); !errors.Is(err, metastorage.ErrLeaseLost) {
+				t.Errorf("release by other worker: %v, want lease lost", err)
+			}
+			if err := metastorage.Release(ctx, b, "high", "w1"); err != nil {
+				t.Fatal(err)
+			}
+			released, err := b.GetMeta(ctx, "high")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, leased := metastorage.LeaseOf(released); leased || released.State != metastorage.StateDeferred {
+				t.Errorf("released: leased %v in %s, want deferred without lease", leased, released.State)
+			}
+
+			// high is due again, low follows; later is not due
+			for _, want := range []string{"high", "low"} {
+				if m, ok, err := metastorage.ClaimNext(ctx, b, metastorage.StateDeferred, "w1", time.Minute); err != nil || !ok || m.ID != want {
+					t.Fatalf("claim: %s %v %v, want %s", m.ID, ok, err, want)
+				}
+			}
+			if m, ok, err := metastorage.ClaimNext(ctx, b, metastorage.StateDeferred, "w1", time.Minute); err != nil || ok {
+				t.Errorf("claim with nothing due: %s %v %v", m.ID, ok, err)
+			}
+		})
+	}
+}
+
+// aheadClock is a store whose server clock is an hour ahead of the local
+// one
+type aheadClock struct {
+	*store
+}
+
+func (a aheadClock) ServerTime(context.Context) (time.Time, error) {
+	return time.Now().Add(time.Hour), nil
+}
+
+func TestClaimBatchUsesServerTime(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	b := aheadClock{&store{messages: map[string]metastorage.MessageMetadata{
+		"m1": {ID: "m1", State: metastorage.StateDeferred, NextRetry: now.Add(30 * time.Minute)},
+	}}}
+	claimed, err := metastorage.ClaimBatch(ctx, b, metastorage.StateDeferred, "w", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatalf("claimed %+v, want m1 due by the server clock", claimed)
+	}
+	if _, expires, _ := metastorage.LeaseOf(claimed[0]); expires.Before(now.Add(time.Hour)) {
+		t.Errorf("lease expires %v, want after the server time", expires)
+	}
+}
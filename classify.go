@@ -0,0 +1,114 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Classifier decides whether a backend specific error is transient
+// (retrying the same operation may succeed, e.g. deadlocks, timeouts,
+// leader changes) or permanent. ok is false if the classifier does not
+// recognize err, letting the next classifier decide.
+type Classifier interface {
+	Classify(err error) (retryable bool, ok bool)
+}
+
+// ClassifierFunc adapts a function to the Classifier interface
+type ClassifierFunc func(err error) (retryable bool, ok bool)
+
+// Classify calls f
+func (f ClassifierFunc) Classify(err error) (bool, bool) {
+	return f(err)
+}
+
+var (
+	classifiersMu sync.RWMutex
+	classifiers   []Classifier
+)
+
+// RegisterClassifier adds a classifier consulted by IsRetryable.
+// Backend packages register one for their driver errors in init.
+func RegisterClassifier(c Classifier) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+	classifiers = append(classifiers, c)
+}
+
+// classifiedError carries an explicit classification
+type classifiedError struct {
+	err       error
+	retryable bool
+}
+
+func (e *classifiedError) Error() string   { return e.err.Error() }
+func (e *classifiedError) Unwrap() error   { return e.err }
+func (e *classifiedError) Retryable() bool { return e.retryable }
+
+// Transient marks err as retryable
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, retryable: true}
+}
+
+// Permanent marks err as not retryable
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, retryable: false}
+}
+
+// IsRetryable reports whether the operation that returned err may succeed
+// when retried unchanged. It is used by retrying wrappers and schedulers to
+// handle backend specific errors uniformly.
+//
+// Decision order:
+//  1. errors implementing Retryable() bool (including Transient/Permanent)
+//  2. contract errors of this package and context cancellation: permanent
+//  3. context.DeadlineExceeded and errors with Timeout() or Temporary()
+//     returning true (net.Error): transient
+//  4. classifiers added with RegisterClassifier
+//  5. everything else: permanent
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, ErrMessageNotFound),
+		errors.Is(err, ErrBackendClosed),
+		errors.Is(err, ErrInvalidState),
+		errors.Is(err, ErrStateConflict),
+		errors.Is(err, ErrVersionConflict):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
+	}
+
+	classifiersMu.RLock()
+	defer classifiersMu.RUnlock()
+	for _, c := range classifiers {
+		if retryable, ok := c.Classify(err); ok {
+			return retryable
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+// Package clock abstracts time so backends and wrappers can be driven by a
+// controllable clock in tests and simulations.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+}
+
+// System is the wall clock
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Func adapts a function to the Clock interface
+type Func func() time.Time
+
+// Now calls f
+func (f Func) Now() time.Time {
+	return f()
+}
+
+// Manual is a clock that only moves when told to. It is safe for concurrent use.
+type Manual struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManual creates a manual clock starting at now
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+// Now returns the current manual time
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// Set sets the current time
+func (m *Manual) Set(now time.Time) {
+	m.mu.Lock()
+	m.now = now
+	m.mu.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	m.now = m.now.Add(d)
+	m.mu.Unlock()
+}
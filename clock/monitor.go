@@ -0,0 +1,66 @@
+package clock
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SkewMonitor periodically compares a backend's server time with the local
+// clock and warns when they diverge by more than the tolerance
+type SkewMonitor struct {
+	Server    ServerTimer
+	Local     Clock
+	Tolerance time.Duration
+	Interval  time.Duration
+	Logger    *slog.Logger
+
+	// OnSkew is called with every measured offset (optional)
+	OnSkew func(offset time.Duration)
+}
+
+// Check measures the skew once and logs a warning if it exceeds the tolerance
+func (m *SkewMonitor) Check(ctx context.Context) (time.Duration, error) {
+	local := m.Local
+	if local == nil {
+		local = System
+	}
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	offset, rtt, err := MeasureSkew(ctx, m.Server, local)
+	if err != nil {
+		return 0, err
+	}
+	if m.OnSkew != nil {
+		m.OnSkew(offset)
+	}
+	if SkewExceeded(offset, m.Tolerance) {
+		logger.WarnContext(ctx, "backend server clock diverges from local clock",
+			slog.Duration("offset", offset),
+			slog.Duration("tolerance", m.Tolerance),
+			slog.Duration("rtt", rtt))
+	}
+	return offset, nil
+}
+
+// Run checks the skew every Interval (default 1 minute) until ctx is done
+func (m *SkewMonitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Check(ctx); err != nil && ctx.Err() == nil && m.Logger != nil {
+			m.Logger.DebugContext(ctx, "clock skew check failed", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
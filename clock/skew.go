@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultSkewTolerance is used for due checks when no skew window is configured
+const DefaultSkewTolerance = 2 * time.Second
+
+// Due reports whether a message scheduled for at is due at now. Up to skew
+// of clock difference between the node that scheduled the message and the
+// node checking it is tolerated, so messages on slightly skewed hosts are
+// not perpetually "not yet due".
+func Due(at, now time.Time, skew time.Duration) bool {
+	return !at.After(now.Add(skew))
+}
+
+// ServerTimer is implemented by backends that can report the time of their server
+type ServerTimer interface {
+	ServerTime(ctx context.Context) (time.Time, error)
+}
+
+// MeasureSkew estimates the offset of the server clock relative to local,
+// compensating for half the round trip. A positive offset means the server
+// clock is ahead.
+func MeasureSkew(ctx context.Context, server ServerTimer, local Clock) (offset, rtt time.Duration, err error) {
+	t0 := local.Now()
+	st, err := server.ServerTime(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	t1 := local.Now()
+	rtt = t1.Sub(t0)
+	return st.Sub(t0.Add(rtt / 2)), rtt, nil
+}
+
+// SkewExceeded reports whether offset is outside of tolerance in either direction
+func SkewExceeded(offset, tolerance time.Duration) bool {
+	if offset < 0 {
+		offset = -offset
+	}
+	return offset > tolerance
+}
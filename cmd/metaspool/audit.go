@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/middleware/audit"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/s3"
+)
+
+func init() {
+	register("audit-verify", "verify the hash chain of an audit log", runAuditVerify)
+}
+
+func runAuditVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit-verify", flag.ExitOnError)
+	log := fs.String("log", "", "log file path, or s3://bucket/prefix?region=...&endpoint=... for an object log")
+	head := fs.String("head", "", "head hash of an earlier verification; fails if the log was truncated since")
+	_ = fs.Parse(args)
+
+	if *log == "" {
+		return errors.New("-log is required")
+	}
+	records, err := auditRecords(ctx, *log)
+	if err != nil {
+		return err
+	}
+	report, err := audit.VerifyRecords(ctx, records, *head)
+	fmt.Printf("%d records verified", report.Records)
+	if report.Records > 0 {
+		fmt.Printf(" (%s to %s)\nhead %s", report.First.Format(time.RFC3339), report.Last.Format(time.RFC3339), report.Head)
+	}
+	fmt.Println()
+	return err
+}
+
+// auditRecords returns the reader of the records of the log named by
+// location
+func auditRecords(ctx context.Context, location string) (func(context.Context, func(audit.Record) error) error, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		path := strings.TrimPrefix(location, "file://")
+		return func(ctx context.Context, fn func(audit.Record) error) error {
+			return audit.ReadFile(ctx, path, fn)
+		}, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	opts := []options.Option{s3.WithBucket(u.Host)}
+	if region := u.Query().Get("region"); region != "" {
+		opts = append(opts, s3.WithRegion(region))
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		opts = append(opts, s3.WithEndpoint(endpoint))
+	}
+	store, err := s3.OpenAuditStore(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return audit.NewObjectSink(store, prefix).Records, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+// backends linked into metaspool, available to -url and stack configs
+import (
+	_ "schneider.vip/retryspool/storage/meta/badger"
+	_ "schneider.vip/retryspool/storage/meta/boltdb"
+	_ "schneider.vip/retryspool/storage/meta/cassandra"
+	_ "schneider.vip/retryspool/storage/meta/consul"
+	_ "schneider.vip/retryspool/storage/meta/dynamodb"
+	_ "schneider.vip/retryspool/storage/meta/etcd"
+	_ "schneider.vip/retryspool/storage/meta/filesystem"
+	_ "schneider.vip/retryspool/storage/meta/grpcbackend"
+	_ "schneider.vip/retryspool/storage/meta/httpbackend"
+	_ "schneider.vip/retryspool/storage/meta/memory"
+	_ "schneider.vip/retryspool/storage/meta/mongodb"
+	_ "schneider.vip/retryspool/storage/meta/mysql"
+	_ "schneider.vip/retryspool/storage/meta/natskv"
+	_ "schneider.vip/retryspool/storage/meta/plugin"
+	_ "schneider.vip/retryspool/storage/meta/postgres"
+	_ "schneider.vip/retryspool/storage/meta/s3"
+	_ "schneider.vip/retryspool/storage/meta/sqlite"
+)
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/compose"
+	"schneider.vip/retryspool/storage/meta/diff"
+	"schneider.vip/retryspool/storage/meta/export"
+)
+
+func init() {
+	register("diff", "compare a backend with another backend or a dump file", runDiff)
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	otherURL := fs.String("other", "", "DSN of the backend to compare with")
+	otherConfig := fs.String("other-config", "", "stack config file of the backend to compare with")
+	file := fs.String("file", "", "JSONL dump file to compare with instead of a backend")
+	states := fs.String("states", "", "comma separated states to compare (default all)")
+	ignore := fs.String("ignore", "", "comma separated fields not to compare, e.g. updated,sequence")
+	precision := fs.Duration("precision", time.Microsecond, "timestamp precision")
+	show := fs.Int("show", 50, "differences to print")
+	_ = fs.Parse(args)
+
+	selected, err := parseStates(*states)
+	if err != nil {
+		return err
+	}
+	opts := diff.Options{TimePrecision: *precision, MaxDifferences: *show}
+	for _, f := range strings.Split(*ignore, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			opts.Ignore = append(opts.Ignore, f)
+		}
+	}
+
+	var right diff.Source
+	switch {
+	case *file != "":
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		right = diff.FromDecoder(export.NewJSONLDecoder(f), selected...)
+	case *otherURL != "" || *otherConfig != "":
+		other, err := openOther(ctx, *otherURL, *otherConfig)
+		if err != nil {
+			return err
+		}
+		defer other.Close()
+		right = diff.FromBackend(other, selected...)
+	default:
+		return errors.New("one of -other, -other-config or -file is required")
+	}
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	report, err := diff.Compare(ctx, diff.FromBackend(backend, selected...), right, opts)
+	if err != nil {
+		return err
+	}
+	for _, d := range report.Differences {
+		switch d.Kind {
+		case diff.KindMismatch:
+			fmt.Printf("~ %s (%s)\n", d.ID, strings.Join(d.Fields, ", "))
+		case diff.KindOnlyLeft:
+			fmt.Printf("< %s (%s)\n", d.ID, d.Left.State)
+		case diff.KindOnlyRight:
+			fmt.Printf("> %s (%s)\n", d.ID, d.Right.State)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "left %d, right %d: %d only left, %d only right, %d mismatched\n",
+		report.Left, report.Right, report.OnlyLeft, report.OnlyRight, report.Mismatched)
+	if !report.Equal() {
+		return errors.New("backends differ")
+	}
+	return nil
+}
+
+func openOther(ctx context.Context, url, config string) (metastorage.Backend, error) {
+	if config == "" {
+		return compose.Build(ctx, compose.Config{Backend: url})
+	}
+	cfg, err := compose.LoadFile(config)
+	if err != nil {
+		return nil, err
+	}
+	if url != "" {
+		cfg.Backend = url
+	}
+	return compose.Build(ctx, cfg)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/doctor"
+)
+
+func init() {
+	register("doctor", "check a backend for deployment problems", runDoctor)
+}
+
+func runDoctor(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	var cfg doctor.Config
+	fs.IntVar(&cfg.Probes, "probes", 5, "latency probes")
+	fs.DurationVar(&cfg.MaxLatency, "max-latency", 100*time.Millisecond, "warn above this median read latency")
+	fs.DurationVar(&cfg.SkewTolerance, "skew", 2*time.Second, "warn above this clock offset")
+	fs.IntVar(&cfg.MaxScan, "max-scan", 100000, "skip counter checks for larger states")
+	strict := fs.Bool("strict", false, "fail on warnings as well")
+	_ = fs.Parse(args)
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	report := doctor.Run(ctx, backend, cfg)
+	fmt.Print(report.String())
+	switch worst := report.Worst(); {
+	case worst == doctor.Failure:
+		return errors.New("problems found")
+	case worst == doctor.Warning && *strict:
+		return errors.New("warnings found")
+	}
+	return nil
+}
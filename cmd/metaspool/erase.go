@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+func init() {
+	register("erase", "hard-delete all messages of a data subject (right to erasure)", runErase)
+}
+
+func runErase(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("erase", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	header := fs.String("header", "", "header identifying the data subject, e.g. to")
+	value := fs.String("value", "", "header value of the data subject, e.g. user@example.com")
+	states := fs.String("states", "", "comma separated states to search (default all)")
+	overridePins := fs.Bool("override-pins", false, "erase pinned messages too")
+	batch := fs.Int("batch", 0, "iterator batch size (default 500)")
+	_ = fs.Parse(args)
+
+	if *header == "" || *value == "" {
+		return errors.New("-header and -value are required")
+	}
+	opts := metastorage.EraseOptions{BatchSize: *batch, OverridePins: *overridePins}
+	var err error
+	if opts.States, err = parseStates(*states); err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	report, err := metastorage.EraseByHeader(ctx, backend, *header, *value, opts)
+	for _, id := range report.Erased {
+		fmt.Println(id)
+	}
+	for _, id := range report.Pinned {
+		fmt.Printf("%s skipped: pinned\n", id)
+	}
+	fmt.Printf("erased %d of %d messages scanned\n", len(report.Erased), report.Scanned)
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"schneider.vip/retryspool/storage/meta/export"
+	"schneider.vip/retryspool/storage/meta/export/parquet"
+)
+
+func init() {
+	register("export", "write backend contents to a dump file", runExport)
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	out := fs.String("out", "-", "output file (- for stdout)")
+	format := fs.String("format", "jsonl", "output format: jsonl, csv or parquet")
+	states := fs.String("states", "", "comma separated states to export (default all)")
+	anonymize := fs.Bool("anonymize", false, "hash IDs and scrub personal data from headers and errors")
+	keepHeaders := fs.String("keep-headers", "", "with -anonymize: comma separated headers to keep unchanged")
+	checkpoint := fs.String("checkpoint", "", "incremental export: only write changes since the checkpoint in this file, then update it")
+	_ = fs.Parse(args)
+
+	opts := export.Options{}
+	var err error
+	if opts.States, err = parseStates(*states); err != nil {
+		return err
+	}
+	if *anonymize {
+		a := export.DefaultAnonymizer()
+		for _, h := range strings.Split(*keepHeaders, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				a.Headers[h] = export.Keep
+			}
+		}
+		opts.Transforms = append(opts.Transforms, a.Transform())
+	}
+
+	newEncoder, err := encoderFor(*format)
+	if err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	w, closeOut, err := createOutput(*out)
+	if err != nil {
+		return err
+	}
+	if *checkpoint == "" {
+		stats, err := export.Export(ctx, backend, newEncoder(w), opts)
+		if cerr := closeOut(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "exported %d messages (%d dropped)\n", stats.Exported, stats.Dropped)
+		return nil
+	}
+
+	prev, err := export.LoadCheckpoint(*checkpoint)
+	if err != nil {
+		return err
+	}
+	next, stats, err := export.ExportIncremental(ctx, backend, newEncoder(w), prev, opts)
+	if cerr := closeOut(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// only advance the checkpoint once the dump is safely written
+	if err := next.Save(*checkpoint); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d changed messages, %d tombstones, %d unchanged\n", stats.Exported, stats.Tombstones, stats.Unchanged)
+	return nil
+}
+
+// encoderFor returns the constructor for the named output format
+func encoderFor(format string) (func(io.Writer) export.Encoder, error) {
+	switch format {
+	case "jsonl":
+		return func(w io.Writer) export.Encoder { return export.NewJSONLEncoder(w) }, nil
+	case "csv":
+		return func(w io.Writer) export.Encoder { return export.NewCSVEncoder(w) }, nil
+	case "parquet":
+		return func(w io.Writer) export.Encoder { return parquet.NewEncoder(w) }, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// createOutput opens path for writing; "-" selects stdout
+func createOutput(path string) (io.Writer, func() error, error) {
+	if path == "-" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+
+	"schneider.vip/retryspool/storage/meta/middleware/encrypt"
+)
+
+func init() {
+	register("genkey", "generate a data key for a keyring file", runGenkey)
+}
+
+func runGenkey(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("genkey", flag.ExitOnError)
+	service := fs.String("service", "", "key service URI wrapping the key, e.g. awskms://alias/retryspool")
+	_ = fs.Parse(args)
+
+	var key []byte
+	if *service == "" {
+		key = make([]byte, encrypt.KeySize)
+		defer clear(key)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+	} else {
+		svc, err := encrypt.OpenKeyService(ctx, *service)
+		if err != nil {
+			return err
+		}
+		if key, err = encrypt.GenerateKey(ctx, svc); err != nil {
+			return err
+		}
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(key))
+	return nil
+}
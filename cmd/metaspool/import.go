@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"schneider.vip/retryspool/storage/meta/export"
+)
+
+func init() {
+	register("import", "load a dump file into a backend", runImport)
+}
+
+func runImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	in := fs.String("in", "-", "input file (- for stdin)")
+	policy := fs.String("policy", "skip-existing", "conflict policy: skip-existing, overwrite, merge, fail")
+	keepGoing := fs.Bool("continue", false, "continue after per-record errors")
+	_ = fs.Parse(args)
+
+	opts := export.ImportOptions{ContinueOnError: *keepGoing}
+	var err error
+	if opts.Policy, err = export.ParseConflictPolicy(*policy); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	report, err := export.Import(ctx, backend, export.NewJSONLDecoder(r), opts)
+	for _, recErr := range report.Errors {
+		fmt.Fprintln(os.Stderr, recErr)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d, overwritten %d, merged %d, skipped %d, deleted %d, errors %d\n",
+		report.Imported, report.Overwritten, report.Merged, report.Skipped, report.Deleted, len(report.Errors))
+	return err
+}
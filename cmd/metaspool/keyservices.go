@@ -0,0 +1,8 @@
+package main
+
+// key services linked into metaspool, available to keyring files
+import (
+	_ "schneider.vip/retryspool/storage/meta/middleware/encrypt/awskms"
+	_ "schneider.vip/retryspool/storage/meta/middleware/encrypt/gcpkms"
+	_ "schneider.vip/retryspool/storage/meta/middleware/encrypt/vaulttransit"
+)
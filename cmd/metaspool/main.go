@@ -0,0 +1,126 @@
+// Command metaspool is the operator tool for retryspool metadata backends.
+//
+// Usage:
+//
+//	metaspool <command> [flags]
+//
+// The backend is selected with -url (a registered DSN) or -config (a
+// compose stack file); without either, RETRYSPOOL_META_URL and
+// RETRYSPOOL_META_CONFIG are used.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/compose"
+)
+
+type command struct {
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{}
+
+func register(name, summary string, run func(ctx context.Context, args []string) error) {
+	commands[name] = command{summary: summary, run: run}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "metaspool: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "metaspool %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: metaspool <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+// backendFlags adds the common backend selection flags to fs
+type backendFlags struct {
+	url    *string
+	config *string
+}
+
+func addBackendFlags(fs *flag.FlagSet) backendFlags {
+	return backendFlags{
+		url:    fs.String("url", "", "backend DSN (default $"+compose.EnvURL+")"),
+		config: fs.String("config", "", "stack config file (default $"+compose.EnvConfig+")"),
+	}
+}
+
+func (f backendFlags) open(ctx context.Context) (metastorage.Backend, error) {
+	cfg, err := f.stack()
+	if err != nil {
+		return nil, err
+	}
+	return compose.Build(ctx, cfg)
+}
+
+// stack returns the stack config selected by the flags
+func (f backendFlags) stack() (compose.Config, error) {
+	switch {
+	case *f.config != "":
+		cfg, err := compose.LoadFile(*f.config)
+		if err != nil {
+			return compose.Config{}, err
+		}
+		if *f.url != "" {
+			cfg.Backend = *f.url
+		}
+		return cfg, nil
+	case *f.url != "":
+		return compose.Config{Backend: *f.url}, nil
+	default:
+		return compose.ConfigFromEnv(os.LookupEnv)
+	}
+}
+
+// parseStates parses a comma separated list of state names
+func parseStates(list string) ([]metastorage.QueueState, error) {
+	var states []metastorage.QueueState
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		s, err := metastorage.ParseQueueState(name)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/middleware/encrypt"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+func init() {
+	register("reencrypt", "re-encrypt messages under the current key of their namespace", runReencrypt)
+}
+
+func runReencrypt(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	keys := fs.String("keys", "", "keyring file, required unless the stack has an encrypt layer")
+	namespace := fs.String("namespace", "", "with -keys: namespace selecting the current key")
+	plainHeaders := fs.String("plain-headers", "", "with -keys: comma separated headers stored unencrypted")
+	states := fs.String("states", "", "comma separated states to rotate (default all)")
+	checkpoint := fs.String("checkpoint", "", "file recording completed states, to resume an interrupted run")
+	batch := fs.Int("batch", 0, "iterator batch size (default backend specific)")
+	_ = fs.Parse(args)
+
+	opts := encrypt.RotateOptions{
+		Checkpoint: *checkpoint,
+		BatchSize:  *batch,
+		Progress: func(p encrypt.RotateProgress) {
+			status := "..."
+			if p.Done {
+				status = "done"
+			}
+			fmt.Fprintf(os.Stderr, "%-10s scanned %d, rotated %d %s\n", p.State, p.Scanned, p.Rotated, status)
+		},
+	}
+	var err error
+	if opts.States, err = parseStates(*states); err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	// a keyring on the command line wraps the backend with an encrypt
+	// layer of its own, otherwise the one of the stack config is rotated
+	_, stacked := metastorage.As[*encrypt.Backend](backend)
+	switch {
+	case *keys != "" && stacked:
+		return errors.New("the stack has an encrypt layer, -keys must not be given")
+	case *keys != "":
+		keyring, err := encrypt.LoadKeyring(ctx, *keys)
+		if err != nil {
+			return err
+		}
+		var plain []string
+		for _, h := range strings.Split(*plainHeaders, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				plain = append(plain, h)
+			}
+		}
+		backend = encrypt.New(backend, keyring, options.WithNamespace(*namespace), encrypt.WithPlainHeaders(plain...))
+	case !stacked:
+		return errors.New("-keys is required, the stack has no encrypt layer")
+	}
+
+	stats, err := encrypt.Rotate(ctx, backend, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "scanned %d messages, rotated %d, %d deleted meanwhile, %d states resumed\n",
+		stats.Scanned, stats.Rotated, stats.Vanished, stats.Resumed)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/compose"
+	"schneider.vip/retryspool/storage/meta/retention"
+)
+
+func init() {
+	register("retention", "report the ages of messages carrying personal data", runRetention)
+}
+
+func runRetention(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	maxAge := fs.String("max-age", "30d", "longest allowed age of personal data, e.g. 30d or 72h (0 = report only)")
+	namespaces := fs.String("namespaces", "", "comma separated namespaces to check (default the configured one)")
+	states := fs.String("states", "", "comma separated states to check (default all)")
+	headers := fs.String("headers", strings.Join(retention.DefaultPersonalHeaders, ","), "comma separated headers holding personal data")
+	maxListed := fs.Int("max-listed", retention.DefaultMaxListed, "violating message IDs listed per namespace")
+	asJSON := fs.Bool("json", false, "write the report as JSON")
+	_ = fs.Parse(args)
+
+	var policy retention.Policy
+	var err error
+	if policy.MaxAge, err = retention.ParseAge(*maxAge); err != nil {
+		return err
+	}
+	if policy.States, err = parseStates(*states); err != nil {
+		return err
+	}
+	for _, h := range strings.Split(*headers, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			policy.PersonalHeaders = append(policy.PersonalHeaders, h)
+		}
+	}
+	policy.MaxListed = *maxListed
+
+	cfg, err := backendFlags.stack()
+	if err != nil {
+		return err
+	}
+	names := []string{cfg.Namespace}
+	if *namespaces != "" {
+		names = nil
+		for _, ns := range strings.Split(*namespaces, ",") {
+			names = append(names, strings.TrimSpace(ns))
+		}
+	}
+	sources := make([]retention.Source, 0, len(names))
+	defer func() {
+		for _, src := range sources {
+			src.Backend.Close()
+		}
+	}()
+	for _, ns := range names {
+		backend, err := openNamespace(ctx, cfg, ns)
+		if err != nil {
+			return fmt.Errorf("namespace %q: %w", ns, err)
+		}
+		sources = append(sources, retention.Source{Namespace: ns, Backend: backend})
+	}
+
+	report, err := retention.Generate(ctx, sources, policy, time.Now())
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Print(report.String())
+	}
+	if !report.Conforms() {
+		return errors.New("retention policy violated")
+	}
+	return nil
+}
+
+// openNamespace builds the stack of cfg for namespace ns
+func openNamespace(ctx context.Context, cfg compose.Config, ns string) (metastorage.Backend, error) {
+	cfg.Namespace = ns
+	return compose.Build(ctx, cfg)
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/locale"
+	"schneider.vip/retryspool/storage/meta/query"
+)
+
+func init() {
+	register("shell", "explore a backend interactively", runShell)
+}
+
+const shellHelp = `commands:
+  get <id>                    show a message
+  ls <state> [query]          list matching messages of a state
+  find <query>                list matching messages of all states
+  count <state> [query]       count (matching) messages of a state
+  move <id> <from> <to>       move a message between states
+  hold <id>                   move a message to hold
+  moveall <from> <to> [query] move all (matching) messages between states
+  limit [n]                   show or set the listing limit
+  help                        show this help
+  exit                        leave the shell
+
+queries filter by fields, e.g. attempts >= 3 and header.x-domain = "example.com"
+fields: %s, header.<name>
+`
+
+type shell struct {
+	backend metastorage.Backend
+	out     io.Writer
+	limit   int
+	loc     *locale.Localizer // nil: untranslated
+}
+
+func runShell(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	limit := fs.Int("limit", 20, "maximum messages listed by ls and find")
+	lang := fs.String("lang", "", "language of state names and errors, e.g. de (default untranslated)")
+	_ = fs.Parse(args)
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	sh := &shell{backend: backend, limit: *limit}
+	if *lang != "" {
+		l := locale.For(*lang)
+		sh.loc = &l
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// scripted use: one command per input line
+		sh.out = os.Stdout
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			if done := sh.exec(ctx, sc.Text()); done {
+				return nil
+			}
+		}
+		return sc.Err()
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "meta> ")
+	t.AutoCompleteCallback = complete
+	sh.out = t
+	for ctx.Err() == nil {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if done := sh.exec(ctx, line); done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// exec runs one command line and reports whether the shell should exit
+func (sh *shell) exec(ctx context.Context, line string) bool {
+	cmd, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	rest = strings.TrimSpace(rest)
+	args := strings.Fields(rest)
+	var err error
+	switch cmd {
+	case "":
+	case "exit", "quit":
+		return true
+	case "help", "?":
+		fmt.Fprintf(sh.out, shellHelp, strings.Join(query.Fields(), ", "))
+	case "get":
+		err = sh.get(ctx, args)
+	case "ls":
+		err = sh.list(ctx, args, rest)
+	case "find":
+		err = sh.find(ctx, rest)
+	case "count":
+		err = sh.count(ctx, args, rest)
+	case "move":
+		err = sh.move(ctx, args)
+	case "hold":
+		err = sh.hold(ctx, args)
+	case "moveall":
+		err = sh.moveAll(ctx, args, rest)
+	case "limit":
+		err = sh.setLimit(args)
+	default:
+		err = fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(sh.out, "error:", sh.errorText(err))
+	}
+	return false
+}
+
+// stateName returns the display name of s
+func (sh *shell) stateName(s metastorage.QueueState) string {
+	if sh.loc == nil {
+		return s.String()
+	}
+	return sh.loc.State(s)
+}
+
+// errorText returns the translated error class of err, followed by the
+// original message if it adds detail
+func (sh *shell) errorText(err error) string {
+	if sh.loc == nil {
+		return err.Error()
+	}
+	text := sh.loc.Error(err)
+	if text != err.Error() {
+		text += " (" + err.Error() + ")"
+	}
+	return text
+}
+
+func (sh *shell) get(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get <id>")
+	}
+	m, err := sh.backend.GetMeta(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id\t%s\n", m.ID)
+	fmt.Fprintf(w, "state\t%s\n", sh.stateName(m.State))
+	fmt.Fprintf(w, "attempts\t%d/%d\n", m.Attempts, m.MaxAttempts)
+	fmt.Fprintf(w, "priority\t%d (%s)\n", m.Priority, metastorage.BandOf(m, metastorage.DefaultBandMapping))
+	fmt.Fprintf(w, "size\t%d\n", m.Size)
+	fmt.Fprintf(w, "created\t%s\n", formatTime(m.Created))
+	fmt.Fprintf(w, "updated\t%s\n", formatTime(m.Updated))
+	fmt.Fprintf(w, "next_retry\t%s\n", formatTime(m.NextRetry))
+	now := time.Now()
+	fmt.Fprintf(w, "age\t%s\n", m.Age(now).Round(time.Second))
+	if !m.StateEnteredAt.IsZero() {
+		fmt.Fprintf(w, "time_in_state\t%s\n", m.TimeInState(now).Round(time.Second))
+	}
+	fmt.Fprintf(w, "due\t%t\n", m.IsDue(now))
+	if m.LastError != "" {
+		fmt.Fprintf(w, "last_error\t%s\n", m.LastError)
+	}
+	if m.RetryPolicyName != "" {
+		fmt.Fprintf(w, "policy\t%s\n", m.RetryPolicyName)
+	}
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "header.%s\t%s\n", k, m.Headers[k])
+	}
+	return w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// stateArg parses the state of "<state> [query]" arguments and returns the
+// remaining query text
+func stateArg(args []string, rest string) (metastorage.QueueState, *query.Query, error) {
+	if len(args) == 0 {
+		return 0, nil, errors.New("missing state")
+	}
+	state, err := metastorage.ParseQueueState(args[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	q, err := query.Parse(strings.TrimPrefix(rest, args[0]))
+	return state, q, err
+}
+
+func (sh *shell) list(ctx context.Context, args []string, rest string) error {
+	state, q, err := stateArg(args, rest)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tATTEMPTS\tNEXT RETRY\tLAST ERROR")
+	n, err := sh.scan(ctx, state, q, sh.limit, func(m metastorage.MessageMetadata) {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", m.ID, sh.stateName(m.State), m.Attempts, formatTime(m.NextRetry), truncate(m.LastError, 40))
+	})
+	w.Flush()
+	if n == sh.limit {
+		fmt.Fprintf(sh.out, "(limited to %d, see limit)\n", sh.limit)
+	}
+	return err
+}
+
+func (sh *shell) find(ctx context.Context, rest string) error {
+	q, err := query.Parse(rest)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tATTEMPTS\tNEXT RETRY\tLAST ERROR")
+	left := sh.limit
+	for _, state := range metastorage.States() {
+		n, err := sh.scan(ctx, state, q, left, func(m metastorage.MessageMetadata) {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", m.ID, sh.stateName(m.State), m.Attempts, formatTime(m.NextRetry), truncate(m.LastError, 40))
+		})
+		if err != nil {
+			w.Flush()
+			return err
+		}
+		if left -= n; left == 0 {
+			w.Flush()
+			fmt.Fprintf(sh.out, "(limited to %d, see limit)\n", sh.limit)
+			return nil
+		}
+	}
+	return w.Flush()
+}
+
+func (sh *shell) count(ctx context.Context, args []string, rest string) error {
+	state, q, err := stateArg(args, rest)
+	if err != nil {
+		return err
+	}
+	if q.String() == "" {
+		if counter, ok := metastorage.As[metastorage.StateCounterBackend](sh.backend); ok {
+			if n := counter.GetStateCount(state); n >= 0 {
+				fmt.Fprintln(sh.out, n)
+				return nil
+			}
+		}
+	}
+	n, err := sh.scan(ctx, state, q, -1, func(metastorage.MessageMetadata) {})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, n)
+	return nil
+}
+
+// scan calls fn for up to limit messages of state matching q (limit < 0:
+// no limit) and returns how many matched
+func (sh *shell) scan(ctx context.Context, state metastorage.QueueState, q *query.Query, limit int, fn func(metastorage.MessageMetadata)) (int, error) {
+	if limit == 0 {
+		return 0, nil
+	}
+	iter, err := sh.backend.NewMessageIterator(ctx, state, 500)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	now := time.Now().UTC()
+	n := 0
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil || !more {
+			return n, err
+		}
+		if !q.Match(m, now) {
+			continue
+		}
+		fn(m)
+		if n++; n == limit {
+			return n, nil
+		}
+	}
+}
+
+func (sh *shell) move(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: move <id> <from> <to>")
+	}
+	from, err := metastorage.ParseQueueState(args[1])
+	if err != nil {
+		return err
+	}
+	to, err := metastorage.ParseQueueState(args[2])
+	if err != nil {
+		return err
+	}
+	if err := sh.backend.MoveToState(ctx, args[0], from, to); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%s: %s -> %s\n", args[0], from, to)
+	return nil
+}
+
+func (sh *shell) hold(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: hold <id>")
+	}
+	m, err := sh.backend.GetMeta(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return sh.move(ctx, []string{m.ID, m.State.String(), metastorage.StateHold.String()})
+}
+
+func (sh *shell) moveAll(ctx context.Context, args []string, rest string) error {
+	if len(args) < 2 {
+		return errors.New("usage: moveall <from> <to> [query]")
+	}
+	from, err := metastorage.ParseQueueState(args[0])
+	if err != nil {
+		return err
+	}
+	to, q, err := stateArg(args[1:], strings.TrimSpace(strings.TrimPrefix(rest, args[0])))
+	if err != nil {
+		return err
+	}
+	var filter func(metastorage.MessageMetadata) bool
+	if q.String() != "" {
+		now := time.Now().UTC()
+		filter = func(m metastorage.MessageMetadata) bool { return q.Match(m, now) }
+	}
+	n, err := metastorage.MoveStateBulk(ctx, sh.backend, from, to, filter)
+	fmt.Fprintf(sh.out, "%d moved: %s -> %s\n", n, from, to)
+	return err
+}
+
+func (sh *shell) setLimit(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(sh.out, sh.limit)
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return errors.New("usage: limit <n>, n > 0")
+	}
+	sh.limit = n
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+var shellCommands = []string{"count", "exit", "find", "get", "help", "hold", "limit", "ls", "move", "moveall"}
+
+// complete completes the word before the cursor on tab
+func complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	start := strings.LastIndexAny(head, " \t(") + 1
+	word := head[start:]
+	words := strings.Fields(head[:start])
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		candidates = shellCommands
+	case (words[0] == "ls" || words[0] == "count") && len(words) == 1,
+		words[0] == "move" && (len(words) == 2 || len(words) == 3),
+		words[0] == "moveall" && (len(words) == 1 || len(words) == 2):
+		for _, s := range metastorage.States() {
+			candidates = append(candidates, s.String())
+		}
+	case words[0] == "ls" || words[0] == "count" || words[0] == "find" || words[0] == "moveall":
+		candidates = queryCandidates(words[len(words)-1])
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := commonPrefix(matches)
+	if len(matches) == 1 && !strings.HasSuffix(completion, ".") {
+		completion += " "
+	}
+	if completion == word {
+		return "", 0, false
+	}
+	return head[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// queryCandidates returns what may follow prev in a query
+func queryCandidates(prev string) []string {
+	switch {
+	case slices.Contains(query.Operators(), prev):
+		var states []string
+		for _, s := range metastorage.States() {
+			states = append(states, s.String())
+		}
+		return append(states, "now")
+	case slices.Contains(query.Fields(), prev) || strings.HasPrefix(prev, query.HeaderPrefix):
+		return append(query.Operators(), query.Keywords()...)
+	case prev == "and" || prev == "or" || prev == "not" || isStateWord(prev):
+		return append(query.Fields(), query.HeaderPrefix, "not")
+	default:
+		// after a value, or the state argument of ls/count
+		return append(append(query.Fields(), query.HeaderPrefix), query.Keywords()...)
+	}
+}
+
+func isStateWord(s string) bool {
+	_, err := metastorage.ParseQueueState(s)
+	return err == nil
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/export"
+	"schneider.vip/retryspool/storage/meta/simulate"
+)
+
+func init() {
+	register("simulate", "project queue depths and retry storms for a retry policy", runSimulate)
+}
+
+func runSimulate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	var cfg simulate.Config
+	in := fs.String("in", "", "replay arrivals from a dump file (- for stdin) instead of -rate")
+	policy := fs.String("policy", "exp:1m:4h:2", "retry policy: fixed:<delay> or exp:<initial>[:<max>[:<factor>]]")
+	samples := fs.Bool("samples", false, "print one line per sample")
+	fs.Float64Var(&cfg.ArrivalRate, "rate", 10, "synthetic arrivals per second")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "simulated time (default: 24h after the last arrival)")
+	fs.Float64Var(&cfg.Jitter, "jitter", 0.1, "relative jitter of retry delays")
+	fs.IntVar(&cfg.MaxAttempts, "max-attempts", 10, "attempts before giving up (-1 = unlimited)")
+	fs.Float64Var(&cfg.FailureRate, "failure", 0.1, "probability an attempt fails transiently")
+	fs.Float64Var(&cfg.PermanentRate, "permanent", 0.01, "probability an attempt fails permanently")
+	fs.Float64Var(&cfg.Throughput, "throughput", 0, "delivery attempts per second (0 = unlimited)")
+	fs.DurationVar(&cfg.SampleInterval, "sample-interval", time.Minute, "time between samples")
+	fs.Float64Var(&cfg.StormFactor, "storm-factor", 3, "samples with more than this multiple of the median retries are a storm")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "random seed")
+	fs.Func("outage", "`start-end` offsets in which every attempt fails, e.g. 2h-3h30m (repeatable)", func(s string) error {
+		start, end, ok := strings.Cut(s, "-")
+		if !ok {
+			return errors.New("want start-end")
+		}
+		var o simulate.Outage
+		var err error
+		if o.Start, err = time.ParseDuration(start); err != nil {
+			return err
+		}
+		if o.End, err = time.ParseDuration(end); err != nil {
+			return err
+		}
+		cfg.Outages = append(cfg.Outages, o)
+		return nil
+	})
+	_ = fs.Parse(args)
+
+	var err error
+	if cfg.Policy, err = simulate.ParsePolicy(*policy); err != nil {
+		return err
+	}
+	if *in != "" {
+		if cfg.Arrivals, err = readArrivals(*in); err != nil {
+			return err
+		}
+		if len(cfg.Arrivals) == 0 {
+			return fmt.Errorf("%s: no messages with a creation time", *in)
+		}
+	}
+
+	report, err := simulate.Run(ctx, cfg)
+	if *samples {
+		fmt.Println("time\tready\tdeferred\tarrivals\tattempts\tretries")
+		for _, s := range report.Samples {
+			fmt.Printf("%s\t%d\t%d\t%d\t%d\t%d\n", s.At, s.Ready, s.Deferred, s.Arrivals, s.Attempts, s.Retries)
+		}
+	}
+	fmt.Print(report.String())
+	return err
+}
+
+// readArrivals reads the live messages of a dump file
+func readArrivals(path string) ([]simulate.Arrival, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	dec := export.NewJSONLDecoder(r)
+	var ms []metastorage.MessageMetadata
+	for {
+		rec, err := dec.Decode()
+		if err == io.EOF {
+			return simulate.ArrivalsFromMessages(ms), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !rec.Deleted {
+			ms = append(ms, rec.Message)
+		}
+	}
+}
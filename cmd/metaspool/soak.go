@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/soak"
+)
+
+func init() {
+	register("soak", "drive a backend for hours while verifying invariants", runSoak)
+}
+
+func runSoak(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	backendFlags := addBackendFlags(fs)
+	var cfg soak.Config
+	fs.DurationVar(&cfg.Duration, "duration", time.Hour, "total run time")
+	fs.IntVar(&cfg.Rate, "rate", 100, "target operations per second")
+	fs.IntVar(&cfg.Workers, "workers", 4, "concurrent workers")
+	fs.IntVar(&cfg.MaxMessages, "max-messages", 1000, "live messages per worker")
+	fs.DurationVar(&cfg.CheckInterval, "check-interval", 30*time.Second, "invariant check interval")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "workload seed (default: time based)")
+	fs.BoolVar(&cfg.CheckCounters, "check-counters", false, "verify state counters (backend must not be shared)")
+	fs.BoolVar(&cfg.FailFast, "fail-fast", false, "stop at the first violation")
+	fs.BoolVar(&cfg.Cleanup, "cleanup", true, "delete created messages at the end")
+	_ = fs.Parse(args)
+
+	backend, err := backendFlags.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	cfg.Progress = func(r soak.Report) {
+		fmt.Fprint(os.Stderr, r.String())
+	}
+	report, err := soak.New(backend, cfg).Run(ctx)
+	fmt.Print(report.String())
+	return err
+}
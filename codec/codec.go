@@ -0,0 +1,63 @@
+// Package codec defines how message metadata is serialized by backends that
+// store opaque bytes (key-value stores, files, object storage).
+//
+// All codecs normalize timestamps to UTC when encoding, so records written
+// by nodes in different time zones sort consistently.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Codec encodes and decodes message metadata
+type Codec interface {
+	// Marshal encodes metadata
+	Marshal(metadata metastorage.MessageMetadata) ([]byte, error)
+
+	// Unmarshal decodes data into metadata
+	Unmarshal(data []byte, metadata *metastorage.MessageMetadata) error
+
+	// Name returns the codec name
+	Name() string
+}
+
+// JSON is the default codec. It rejects records containing non-UTC
+// timestamps with an error wrapping metastorage.ErrNonUTCTimestamp; use
+// LegacyJSON together with MigrateUTC to convert existing data.
+var JSON Codec = jsonCodec{strict: true}
+
+// LegacyJSON decodes timestamps in whatever zone they were stored in. It
+// is meant for reading data written before UTC normalization.
+var LegacyJSON Codec = jsonCodec{}
+
+type jsonCodec struct {
+	strict bool
+}
+
+func (jsonCodec) Marshal(metadata metastorage.MessageMetadata) ([]byte, error) {
+	return json.Marshal(metastorage.NormalizeTimes(metadata))
+}
+
+func (c jsonCodec) Unmarshal(data []byte, metadata *metastorage.MessageMetadata) error {
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return err
+	}
+	if !c.strict {
+		return nil
+	}
+	if err := metastorage.CheckTimes(*metadata); err != nil {
+		return fmt.Errorf("decode %s: %w", metadata.ID, err)
+	}
+	*metadata = metastorage.NormalizeTimes(*metadata)
+	return nil
+}
+
+func (c jsonCodec) Name() string {
+	if c.strict {
+		return "json"
+	}
+	return "json-legacy"
+}
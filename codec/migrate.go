@@ -0,0 +1,49 @@
+package codec
+
+import (
+	"context"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// MigrateUTC rewrites every message of backend whose timestamps are not in
+// UTC. Byte oriented backends must be opened with LegacyJSON (or another
+// non-validating codec) so the affected records can be read. It returns
+// the number of rewritten messages.
+func MigrateUTC(ctx context.Context, backend metastorage.Backend, batchSize int) (int, error) {
+	migrated := 0
+	for _, state := range metastorage.States() {
+		var ids []string
+		var pending []metastorage.MessageMetadata
+
+		iter, err := backend.NewMessageIterator(ctx, state, batchSize)
+		if err != nil {
+			return migrated, fmt.Errorf("iterate %s: %w", state, err)
+		}
+		for {
+			m, more, err := iter.Next(ctx)
+			if err != nil {
+				iter.Close()
+				return migrated, fmt.Errorf("iterate %s: %w", state, err)
+			}
+			if !more {
+				break
+			}
+			if metastorage.CheckTimes(m) != nil {
+				ids = append(ids, m.ID)
+				pending = append(pending, metastorage.NormalizeTimes(m))
+			}
+		}
+		iter.Close()
+
+		// rewrite after iterating so updates don't disturb the iterator
+		for i, m := range pending {
+			if err := backend.UpdateMeta(ctx, ids[i], m); err != nil {
+				return migrated, fmt.Errorf("migrate %s: %w", ids[i], err)
+			}
+			migrated++
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,382 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/middleware/audit"
+	"schneider.vip/retryspool/storage/meta/middleware/batchsize"
+	"schneider.vip/retryspool/storage/meta/middleware/bloom"
+	"schneider.vip/retryspool/storage/meta/middleware/cache"
+	"schneider.vip/retryspool/storage/meta/middleware/claimlimit"
+	"schneider.vip/retryspool/storage/meta/middleware/defaults"
+	"schneider.vip/retryspool/storage/meta/middleware/drain"
+	"schneider.vip/retryspool/storage/meta/middleware/encrypt"
+	"schneider.vip/retryspool/storage/meta/middleware/fifo"
+	"schneider.vip/retryspool/storage/meta/middleware/headerguard"
+	"schneider.vip/retryspool/storage/meta/middleware/itermetrics"
+	"schneider.vip/retryspool/storage/meta/middleware/logging"
+	"schneider.vip/retryspool/storage/meta/middleware/lww"
+	"schneider.vip/retryspool/storage/meta/middleware/maxattempts"
+	"schneider.vip/retryspool/storage/meta/middleware/pinguard"
+	"schneider.vip/retryspool/storage/meta/middleware/recovery"
+	"schneider.vip/retryspool/storage/meta/middleware/statetime"
+	"schneider.vip/retryspool/storage/meta/middleware/watch"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/query"
+)
+
+func init() {
+	RegisterMiddleware("logging", buildLogging)
+	RegisterMiddleware("recovery", buildRecovery)
+	RegisterMiddleware("fifo", buildFIFO)
+	RegisterMiddleware("pinguard", buildPinGuard)
+	RegisterMiddleware("watch", buildWatch)
+	RegisterMiddleware("cache", buildCache)
+	RegisterMiddleware("claimlimit", buildClaimLimit)
+	RegisterMiddleware("maxattempts", buildMaxAttempts)
+	RegisterMiddleware("defaults", buildDefaults)
+	RegisterMiddleware("statetime", buildStateTime)
+	RegisterMiddleware("drain", buildDrain)
+	RegisterMiddleware("headerguard", buildHeaderGuard)
+	RegisterMiddleware("bloom", buildBloom)
+	RegisterMiddleware("batchsize", buildBatchSize)
+	RegisterMiddleware("lww", buildLWW)
+	RegisterMiddleware("encrypt", buildEncrypt)
+	RegisterMiddleware("audit", buildAudit)
+	RegisterMiddleware("itermetrics", buildIterMetrics)
+}
+
+// buildLogging accepts an optional "level" param (debug, info, warn, error)
+// that sets the minimum level of a stderr text logger. Without it the
+// stack logger from the options is used.
+func buildLogging(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	level, err := params.String("level", "")
+	if err != nil {
+		return nil, err
+	}
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("param \"level\": %w", err)
+		}
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l})
+		opts = append(opts, options.WithLogger(slog.New(handler)))
+	}
+	return logging.Middleware(opts...), nil
+}
+
+func buildRecovery(_ Params, opts ...options.Option) (metastorage.Middleware, error) {
+	return recovery.Middleware(opts...), nil
+}
+
+func buildStateTime(_ Params, opts ...options.Option) (metastorage.Middleware, error) {
+	return statetime.Middleware(opts...), nil
+}
+
+func buildDrain(_ Params, opts ...options.Option) (metastorage.Middleware, error) {
+	return drain.Middleware(opts...), nil
+}
+
+func buildLWW(_ Params, opts ...options.Option) (metastorage.Middleware, error) {
+	return lww.Middleware(opts...), nil
+}
+
+// buildEncrypt requires "keys", the path of a keyring file (see
+// encrypt.LoadKeyring), and accepts "plain_headers" to keep unencrypted
+func buildEncrypt(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	path, err := params.String("keys", "")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, errors.New("param \"keys\" is required")
+	}
+	keys, err := encrypt.LoadKeyring(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := params.Strings("plain_headers")
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.Middleware(keys, append(opts, encrypt.WithPlainHeaders(plain...))...), nil
+}
+
+// buildAudit requires "file", the path of the hash-chained log file the
+// mutations are appended to, and accepts "digest_key_file", the path of
+// the key of the recorded digests (see audit.WithDigestKey)
+func buildAudit(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	path, err := params.String("file", "")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, errors.New("param \"file\" is required")
+	}
+	keyFile, err := params.String("digest_key_file", "")
+	if err != nil {
+		return nil, err
+	}
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, audit.WithDigestKey(bytes.TrimSpace(key)))
+	}
+	sink, err := audit.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	log, err := audit.NewLog(context.Background(), sink, opts...)
+	if err != nil {
+		sink.Close()
+		return nil, err
+	}
+	return audit.Middleware(log), nil
+}
+
+// buildIterMetrics accepts an optional "query" whose non-matching messages
+// are skipped by iterators and counted as wasted reads
+func buildIterMetrics(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	expr, err := params.String("query", "")
+	if err != nil {
+		return nil, err
+	}
+	if expr != "" {
+		q, err := query.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("param \"query\": %w", err)
+		}
+		opts = append(opts, itermetrics.WithQuery(q))
+	}
+	return itermetrics.Middleware(opts...), nil
+}
+
+func buildPinGuard(_ Params, opts ...options.Option) (metastorage.Middleware, error) {
+	return pinguard.Middleware(opts...), nil
+}
+
+// buildFIFO accepts "states" (list of state names) and "group_header"
+func buildFIFO(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	states, err := params.States("states")
+	if err != nil {
+		return nil, err
+	}
+	if len(states) > 0 {
+		opts = append(opts, fifo.WithStates(states...))
+	}
+	group, err := params.String("group_header", "")
+	if err != nil {
+		return nil, err
+	}
+	if group != "" {
+		opts = append(opts, fifo.WithGroupHeader(group))
+	}
+	return fifo.Middleware(opts...), nil
+}
+
+// buildWatch accepts "buffer", the events buffered per subscriber
+func buildWatch(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	buffer, err := params.Int("buffer", watch.DefaultBuffer)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Middleware(append(opts, watch.WithBuffer(buffer))...), nil
+}
+
+// buildCache accepts "size", "ttl" and "watch" (default true)
+func buildCache(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	size, err := params.Int("size", cache.DefaultSize)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := params.Duration("ttl", cache.DefaultTTL)
+	if err != nil {
+		return nil, err
+	}
+	watching, err := params.Bool("watch", true)
+	if err != nil {
+		return nil, err
+	}
+	return cache.Middleware(append(opts, cache.WithSize(size), cache.WithTTL(ttl), cache.WithWatch(watching))...), nil
+}
+
+// buildClaimLimit accepts "limit_<state>" (e.g. limit_deferred),
+// "group_header" and "group_limit"
+func buildClaimLimit(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	for _, s := range metastorage.States() {
+		n, err := params.Int("limit_"+s.String(), -1)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 0 {
+			opts = append(opts, claimlimit.WithStateLimit(s, n))
+		}
+	}
+	group, err := params.String("group_header", "")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := params.Int("group_limit", 0)
+	if err != nil {
+		return nil, err
+	}
+	if group != "" && limit > 0 {
+		opts = append(opts, claimlimit.WithGroupHeader(group), claimlimit.WithGroupLimit(limit))
+	}
+	return claimlimit.Middleware(opts...), nil
+}
+
+// buildMaxAttempts accepts "default", "band_<band>" (e.g. band_urgent),
+// "bulk_max" and "urgent_min" for the band mapping, "group_header" and
+// "groups" (map of group value to MaxAttempts)
+func buildMaxAttempts(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	def, err := params.Int("default", 0)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, maxattempts.WithDefault(def))
+	for _, band := range metastorage.Bands() {
+		n, err := params.Int("band_"+band.String(), -1)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 0 {
+			opts = append(opts, maxattempts.WithBandDefault(band, n))
+		}
+	}
+	mapping := metastorage.DefaultBandMapping
+	if mapping.BulkMax, err = params.Int("bulk_max", mapping.BulkMax); err != nil {
+		return nil, err
+	}
+	if mapping.UrgentMin, err = params.Int("urgent_min", mapping.UrgentMin); err != nil {
+		return nil, err
+	}
+	if err := mapping.Validate(); err != nil {
+		return nil, err
+	}
+	opts = append(opts, maxattempts.WithBandMapping(mapping))
+	group, err := params.String("group_header", "")
+	if err != nil {
+		return nil, err
+	}
+	groups, err := params.IntMap("groups")
+	if err != nil {
+		return nil, err
+	}
+	if group != "" {
+		opts = append(opts, maxattempts.WithGroupDefaults(group, groups))
+	}
+	return maxattempts.Middleware(opts...), nil
+}
+
+// buildDefaults accepts the template fields "max_attempts", "priority",
+// "policy" and "headers" (map), and "required_headers" (list)
+func buildDefaults(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	var t metastorage.MessageMetadata
+	var err error
+	if t.MaxAttempts, err = params.Int("max_attempts", 0); err != nil {
+		return nil, err
+	}
+	if t.Priority, err = params.Int("priority", 0); err != nil {
+		return nil, err
+	}
+	if t.RetryPolicyName, err = params.String("policy", ""); err != nil {
+		return nil, err
+	}
+	if t.Headers, err = params.StringMap("headers"); err != nil {
+		return nil, err
+	}
+	required, err := params.Strings("required_headers")
+	if err != nil {
+		return nil, err
+	}
+	return defaults.Middleware(append(opts, defaults.WithTemplate(t), defaults.WithRequiredHeaders(required...))...), nil
+}
+
+// buildHeaderGuard accepts "max_headers", "max_key_length",
+// "max_value_size" (0 = unlimited) and "policy" (reject, truncate)
+func buildHeaderGuard(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	maxHeaders, err := params.Int("max_headers", headerguard.DefaultMaxHeaders)
+	if err != nil {
+		return nil, err
+	}
+	maxKeyLength, err := params.Int("max_key_length", headerguard.DefaultMaxKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	maxValueSize, err := params.Int("max_value_size", headerguard.DefaultMaxValueSize)
+	if err != nil {
+		return nil, err
+	}
+	name, err := params.String("policy", "reject")
+	if err != nil {
+		return nil, err
+	}
+	policy, err := headerguard.ParsePolicy(name)
+	if err != nil {
+		return nil, fmt.Errorf("param \"policy\": %w", err)
+	}
+	return headerguard.Middleware(append(opts,
+		headerguard.WithMaxHeaders(maxHeaders),
+		headerguard.WithMaxKeyLength(maxKeyLength),
+		headerguard.WithMaxValueSize(maxValueSize),
+		headerguard.WithPolicy(policy),
+	)...), nil
+}
+
+// buildBloom accepts "expected", the messages per state the filters are
+// sized for, and "fp_rate", their false positive rate
+func buildBloom(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	expected, err := params.Int("expected", bloom.DefaultExpected)
+	if err != nil {
+		return nil, err
+	}
+	fpRate, err := params.Float("fp_rate", bloom.DefaultFalsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, fmt.Errorf("param \"fp_rate\": %v is not between 0 and 1", fpRate)
+	}
+	return bloom.Middleware(append(opts, bloom.WithExpected(expected), bloom.WithFalsePositiveRate(fpRate))...), nil
+}
+
+// buildBatchSize accepts "target_latency" per batch, "max_batch_bytes",
+// "min_size", "max_size" and "increase", the additive step
+func buildBatchSize(params Params, opts ...options.Option) (metastorage.Middleware, error) {
+	latency, err := params.Duration("target_latency", batchsize.DefaultTargetLatency)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := params.Int("max_batch_bytes", batchsize.DefaultMaxBatchBytes)
+	if err != nil {
+		return nil, err
+	}
+	minSize, err := params.Int("min_size", batchsize.DefaultMinBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := params.Int("max_size", batchsize.DefaultMaxBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	increase, err := params.Int("increase", batchsize.DefaultIncrease)
+	if err != nil {
+		return nil, err
+	}
+	return batchsize.Middleware(append(opts,
+		batchsize.WithTargetLatency(latency),
+		batchsize.WithMaxBatchBytes(maxBytes),
+		batchsize.WithMinBatchSize(minSize),
+		batchsize.WithMaxBatchSize(maxSize),
+		batchsize.WithIncrease(increase),
+	)...), nil
+}
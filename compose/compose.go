@@ -0,0 +1,183 @@
+// Package compose assembles a complete, decorated storage stack from a
+// declarative YAML or JSON document:
+//
+//	backend: postgres://spool@db/retryspool
+//	namespace: tenant-a
+//	batch_size: 500
+//	middlewares:
+//	  - name: logging
+//
+// Middlewares are applied in document order, the first entry being the
+// outermost layer.
+package compose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// ErrUnknownMiddleware is returned when a config references an unregistered middleware
+var ErrUnknownMiddleware = errors.New("unknown middleware")
+
+// Config describes a storage stack
+type Config struct {
+	Backend     string             `json:"backend" yaml:"backend"`                           // Backend DSN
+	Namespace   string             `json:"namespace,omitempty" yaml:"namespace,omitempty"`   // options.WithNamespace
+	BatchSize   int                `json:"batch_size,omitempty" yaml:"batch_size,omitempty"` // options.WithBatchSize
+	Middlewares []MiddlewareConfig `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+}
+
+// MiddlewareConfig selects a registered middleware and its parameters
+type MiddlewareConfig struct {
+	Name   string `json:"name" yaml:"name"`
+	Params Params `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// MiddlewareBuilder creates a middleware from its config parameters.
+// opts carries the stack wide options (namespace, batch size, logger, ...).
+type MiddlewareBuilder func(params Params, opts ...options.Option) (metastorage.Middleware, error)
+
+var (
+	mu          sync.RWMutex
+	middlewares = make(map[string]MiddlewareBuilder)
+)
+
+// RegisterMiddleware makes a middleware available to configs under name.
+// It panics if builder is nil or the name is registered twice.
+func RegisterMiddleware(name string, builder MiddlewareBuilder) {
+	mu.Lock()
+	defer mu.Unlock()
+	if builder == nil {
+		panic("compose: RegisterMiddleware builder is nil")
+	}
+	if _, dup := middlewares[name]; dup {
+		panic("compose: RegisterMiddleware called twice for " + name)
+	}
+	middlewares[name] = builder
+}
+
+// Middlewares returns the sorted names of all registered middlewares
+func Middlewares() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(middlewares))
+	for name := range middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse decodes a config document. JSON documents are detected by their
+// leading '{'; everything else is parsed as YAML. Unknown fields are rejected.
+func Parse(data []byte) (Config, error) {
+	var cfg Config
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return Config{}, fmt.Errorf("parse JSON config: %w", err)
+		}
+		return cfg, nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(trimmed))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("parse YAML config: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadFile reads and parses a config file
+func LoadFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that the config references a backend and only known middlewares
+func (c Config) Validate() error {
+	if strings.TrimSpace(c.Backend) == "" {
+		return errors.New("config: backend DSN is required")
+	}
+	if c.BatchSize < 0 {
+		return errors.New("config: batch_size must not be negative")
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for i, m := range c.Middlewares {
+		if _, ok := middlewares[m.Name]; !ok {
+			return fmt.Errorf("config: middlewares[%d]: %w: %q", i, ErrUnknownMiddleware, m.Name)
+		}
+	}
+	return nil
+}
+
+// Options returns the options described by the config's tuning fields
+func (c Config) Options() []options.Option {
+	var opts []options.Option
+	if c.Namespace != "" {
+		opts = append(opts, options.WithNamespace(c.Namespace))
+	}
+	if c.BatchSize > 0 {
+		opts = append(opts, options.WithBatchSize(c.BatchSize))
+	}
+	return opts
+}
+
+// Build opens the configured backend and applies all middlewares.
+// opts are applied before the config's own tuning, which therefore wins.
+func Build(ctx context.Context, cfg Config, opts ...options.Option) (metastorage.Backend, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	opts = append(append([]options.Option{}, opts...), cfg.Options()...)
+
+	chain := make([]metastorage.Middleware, 0, len(cfg.Middlewares))
+	for i, m := range cfg.Middlewares {
+		mu.RLock()
+		builder := middlewares[m.Name]
+		mu.RUnlock()
+		mw, err := builder(m.Params, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("config: middlewares[%d] %q: %w", i, m.Name, err)
+		}
+		chain = append(chain, mw)
+	}
+
+	backend, err := registry.Open(ctx, cfg.Backend, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return metastorage.Chain(backend, chain...), nil
+}
+
+// BuildFile loads a config file and builds its storage stack
+func BuildFile(ctx context.Context, path string, opts ...options.Option) (metastorage.Backend, error) {
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Build(ctx, cfg, opts...)
+}
@@ -0,0 +1,83 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Environment variables honored by OpenDefault
+const (
+	EnvURL         = "RETRYSPOOL_META_URL"         // Backend DSN
+	EnvConfig      = "RETRYSPOOL_META_CONFIG"      // Path to a YAML/JSON stack config
+	EnvNamespace   = "RETRYSPOOL_META_NAMESPACE"   // Overrides the namespace
+	EnvBatchSize   = "RETRYSPOOL_META_BATCH_SIZE"  // Overrides the batch size
+	EnvMiddlewares = "RETRYSPOOL_META_MIDDLEWARES" // Comma separated middleware names, replaces the config's list
+)
+
+// ErrNoDefaultBackend is returned by OpenDefault when neither
+// RETRYSPOOL_META_URL nor RETRYSPOOL_META_CONFIG is set
+var ErrNoDefaultBackend = errors.New("no default backend configured: set " + EnvURL + " or " + EnvConfig)
+
+// ConfigFromEnv builds a Config from environment variables read via lookup.
+// RETRYSPOOL_META_CONFIG is loaded first; RETRYSPOOL_META_URL and the other
+// variables override individual fields of it.
+func ConfigFromEnv(lookup func(string) (string, bool)) (Config, error) {
+	var cfg Config
+	get := func(key string) string {
+		v, _ := lookup(key)
+		return strings.TrimSpace(v)
+	}
+
+	path := get(EnvConfig)
+	dsn := get(EnvURL)
+	if path == "" && dsn == "" {
+		return Config{}, ErrNoDefaultBackend
+	}
+	if path != "" {
+		loaded, err := LoadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg = loaded
+	}
+	if dsn != "" {
+		cfg.Backend = dsn
+	}
+	if ns := get(EnvNamespace); ns != "" {
+		cfg.Namespace = ns
+	}
+	if bs := get(EnvBatchSize); bs != "" {
+		n, err := strconv.Atoi(bs)
+		if err != nil || n <= 0 {
+			return Config{}, fmt.Errorf("%s: invalid batch size %q", EnvBatchSize, bs)
+		}
+		cfg.BatchSize = n
+	}
+	if mws, ok := lookup(EnvMiddlewares); ok {
+		cfg.Middlewares = nil
+		for _, name := range strings.Split(mws, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.Middlewares = append(cfg.Middlewares, MiddlewareConfig{Name: name})
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// OpenDefault opens the storage stack described by the process environment
+// (see the Env* constants). The backend packages referenced by the DSN must
+// be imported by the program so their schemes are registered.
+func OpenDefault(ctx context.Context, opts ...options.Option) (metastorage.Backend, error) {
+	cfg, err := ConfigFromEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return Build(ctx, cfg, opts...)
+}
@@ -0,0 +1,218 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Params holds the free-form parameters of a middleware entry. Accessors
+// accept the value shapes produced by both the JSON and YAML decoders.
+type Params map[string]any
+
+// String returns the string parameter key, or def if unset
+func (p Params) String(key, def string) (string, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("param %q: expected string, got %T", key, v)
+	}
+	return s, nil
+}
+
+// Int returns the integer parameter key, or def if unset
+func (p Params) Int(key string, def int) (int, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case uint64:
+		return int(n), nil
+	case float64:
+		if n != float64(int(n)) {
+			return 0, fmt.Errorf("param %q: expected integer, got %v", key, n)
+		}
+		return int(n), nil
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("param %q: %w", key, err)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("param %q: expected integer, got %T", key, v)
+}
+
+// Float returns the numeric parameter key, or def if unset
+func (p Params) Float(key string, def float64) (float64, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("param %q: %w", key, err)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("param %q: expected number, got %T", key, v)
+}
+
+// Bool returns the boolean parameter key, or def if unset
+func (p Params) Bool(key string, def bool) (bool, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		parsed, err := strconv.ParseBool(b)
+		if err != nil {
+			return false, fmt.Errorf("param %q: %w", key, err)
+		}
+		return parsed, nil
+	}
+	return false, fmt.Errorf("param %q: expected bool, got %T", key, v)
+}
+
+// Duration returns the duration parameter key ("30s", "5m"), or def if unset.
+// Plain numbers are interpreted as seconds.
+func (p Params) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	if s, ok := v.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("param %q: %w", key, err)
+		}
+		return d, nil
+	}
+	secs, err := p.Float(key, 0)
+	if err != nil {
+		return 0, fmt.Errorf("param %q: expected duration, got %T", key, v)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// Strings returns a list parameter. A single string is treated as a
+// comma separated list.
+func (p Params) Strings(key string) ([]string, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch l := v.(type) {
+	case string:
+		var out []string
+		for _, s := range strings.Split(l, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out, nil
+	case []string:
+		return l, nil
+	case []any:
+		out := make([]string, 0, len(l))
+		for _, item := range l {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("param %q: expected list of strings, got %T element", key, item)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("param %q: expected list, got %T", key, v)
+}
+
+// States returns a list of queue state names as QueueStates
+func (p Params) States(key string) ([]metastorage.QueueState, error) {
+	names, err := p.Strings(key)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]metastorage.QueueState, 0, len(names))
+	for _, name := range names {
+		s, err := metastorage.ParseQueueState(name)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %w", key, err)
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Map returns a nested map parameter, or nil if unset
+func (p Params) Map(key string) (Params, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch m := v.(type) {
+	case Params:
+		return m, nil
+	case map[string]any:
+		return m, nil
+	}
+	return nil, fmt.Errorf("param %q: expected map, got %T", key, v)
+}
+
+// IntMap returns a map parameter with integer values, e.g. per-group limits
+func (p Params) IntMap(key string) (map[string]int, error) {
+	m, err := p.Map(key)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	out := make(map[string]int, len(m))
+	for k := range m {
+		n, err := m.Int(k, 0)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %w", key, err)
+		}
+		out[k] = n
+	}
+	return out, nil
+}
+
+// StringMap returns a map parameter with string values, e.g. headers
+func (p Params) StringMap(key string) (map[string]string, error) {
+	m, err := p.Map(key)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(m))
+	for k := range m {
+		s, err := m.String(k, "")
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %w", key, err)
+		}
+		out[k] = s
+	}
+	return out, nil
+}
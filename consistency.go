@@ -0,0 +1,169 @@
+package metastorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ConsistencyLevel is how fresh the data returned by a read must be.
+// Backends that are always strongly consistent, such as memory or a single
+// SQL database, ignore it.
+type ConsistencyLevel int
+
+const (
+	// ConsistencyDefault leaves the choice to the backend's configuration
+	ConsistencyDefault ConsistencyLevel = iota
+	// ConsistencyStrong reads the latest committed write: caches are
+	// bypassed and replicated backends read from the primary
+	ConsistencyStrong
+	// ConsistencyEventual accepts stale data for lower latency: caches
+	// serve expired entries and replicated backends read from any replica
+	ConsistencyEventual
+	// ConsistencyBoundedStaleness accepts data up to a maximum age, see
+	// ReadOptions.MaxStaleness: caches serve entries read within it.
+	// Backends unable to bound the staleness of their replicas read
+	// strongly.
+	ConsistencyBoundedStaleness
+)
+
+// String returns the name of c
+func (c ConsistencyLevel) String() string {
+	switch c {
+	case ConsistencyDefault:
+		return "default"
+	case ConsistencyStrong:
+		return "strong"
+	case ConsistencyEventual:
+		return "eventual"
+	case ConsistencyBoundedStaleness:
+		return "bounded_staleness"
+	default:
+		return fmt.Sprintf("consistency_%d", int(c))
+	}
+}
+
+// ParseConsistencyLevel parses a name returned by ConsistencyLevel.String
+func ParseConsistencyLevel(name string) (ConsistencyLevel, error) {
+	for _, c := range []ConsistencyLevel{ConsistencyDefault, ConsistencyStrong, ConsistencyEventual, ConsistencyBoundedStaleness} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown consistency level %q", name)
+}
+
+// ReadPreference is which copy of the data a replicated backend reads
+type ReadPreference int
+
+const (
+	// ReadDefault leaves the choice to the backend's configuration
+	ReadDefault ReadPreference = iota
+	// ReadPrimary reads from the primary, or the first endpoint in
+	// configuration order
+	ReadPrimary
+	// ReadReplica prefers other copies, taking load off the primary
+	ReadReplica
+)
+
+// String returns the name of p
+func (p ReadPreference) String() string {
+	switch p {
+	case ReadDefault:
+		return "default"
+	case ReadPrimary:
+		return "primary"
+	case ReadReplica:
+		return "replica"
+	default:
+		return fmt.Sprintf("read_preference_%d", int(p))
+	}
+}
+
+// ParseReadPreference parses a name returned by ReadPreference.String
+func ParseReadPreference(name string) (ReadPreference, error) {
+	for _, p := range []ReadPreference{ReadDefault, ReadPrimary, ReadReplica} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown read preference %q", name)
+}
+
+type (
+	consistencyKey    struct{}
+	readPreferenceKey struct{}
+)
+
+// ReadOptions are the consistency requirements of a read, set per call
+// with GetMetaWithOptions, the fields of MessageListOptions or the
+// context
+type ReadOptions struct {
+	Consistency  ConsistencyLevel
+	MaxStaleness time.Duration // Maximum age of data read with ConsistencyBoundedStaleness
+}
+
+// WithConsistency returns a context whose reads ask for consistency c,
+// e.g. ConsistencyEventual for the GetMeta calls of a dashboard while the
+// scheduler keeps the default. It is a hint: layers able to trade
+// consistency for latency honour it, all others ignore it.
+func WithConsistency(ctx context.Context, c ConsistencyLevel) context.Context {
+	return WithReadOptions(ctx, ReadOptions{Consistency: c})
+}
+
+// WithBoundedStaleness returns a context whose reads accept data up to
+// maxStaleness old, see ConsistencyBoundedStaleness
+func WithBoundedStaleness(ctx context.Context, maxStaleness time.Duration) context.Context {
+	return WithReadOptions(ctx, ReadOptions{Consistency: ConsistencyBoundedStaleness, MaxStaleness: maxStaleness})
+}
+
+// WithReadOptions returns a context whose reads have the requirements of
+// o, see WithConsistency
+func WithReadOptions(ctx context.Context, o ReadOptions) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, o)
+}
+
+// ConsistencyFromContext returns the level set with WithConsistency, or
+// ConsistencyDefault
+func ConsistencyFromContext(ctx context.Context) ConsistencyLevel {
+	return ReadOptionsFromContext(ctx).Consistency
+}
+
+// ReadOptionsFromContext returns the requirements set with
+// WithReadOptions, WithConsistency or WithBoundedStaleness
+func ReadOptionsFromContext(ctx context.Context) ReadOptions {
+	o, _ := ctx.Value(consistencyKey{}).(ReadOptions)
+	return o
+}
+
+// ListContext returns ctx with the consistency of opts, if set, so layers
+// honour the options of a listing like those of the context
+func ListContext(ctx context.Context, opts MessageListOptions) context.Context {
+	if opts.Consistency == ConsistencyDefault {
+		return ctx
+	}
+	return WithReadOptions(ctx, ReadOptions{Consistency: opts.Consistency, MaxStaleness: opts.MaxStaleness})
+}
+
+// GetMetaWithOptions reads a message with the consistency of opts, e.g.
+// ConsistencyStrong for the scheduler deciding on a retry
+func GetMetaWithOptions(ctx context.Context, b Backend, messageID string, opts ReadOptions) (MessageMetadata, error) {
+	if opts.Consistency != ConsistencyDefault {
+		ctx = WithReadOptions(ctx, opts)
+	}
+	return b.GetMeta(ctx, messageID)
+}
+
+// WithReadPreference returns a context whose reads prefer copies of
+// preference p. Like WithConsistency it is a hint for layers with several
+// copies to choose from.
+func WithReadPreference(ctx context.Context, p ReadPreference) context.Context {
+	return context.WithValue(ctx, readPreferenceKey{}, p)
+}
+
+// ReadPreferenceFromContext returns the preference set with
+// WithReadPreference, or ReadDefault
+func ReadPreferenceFromContext(ctx context.Context) ReadPreference {
+	p, _ := ctx.Value(readPreferenceKey{}).(ReadPreference)
+	return p
+}
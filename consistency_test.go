@@ -0,0 +1,89 @@
+package metastorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/middleware/cache"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+func TestConsistencyContext(t *testing.T) {
+	ctx := context.Background()
+	if c := metastorage.ConsistencyFromContext(ctx); c != metastorage.ConsistencyDefault {
+		t.Errorf("default consistency %s", c)
+	}
+	ctx = metastorage.WithReadPreference(metastorage.WithConsistency(ctx, metastorage.ConsistencyEventual), metastorage.ReadReplica)
+	if c := metastorage.ConsistencyFromContext(ctx); c != metastorage.ConsistencyEventual {
+		t.Errorf("consistency %s, want eventual", c)
+	}
+	if p := metastorage.ReadPreferenceFromContext(ctx); p != metastorage.ReadReplica {
+		t.Errorf("read preference %s, want replica", p)
+	}
+	if c, err := metastorage.ParseConsistencyLevel("strong"); err != nil || c != metastorage.ConsistencyStrong {
+		t.Errorf("parse strong: %s %v", c, err)
+	}
+	if _, err := metastorage.ParseReadPreference("nearest"); err == nil {
+		t.Error("parsed unknown read preference")
+	}
+}
+
+func TestStrongReadBypassesCache(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	b := cache.New(inner, cache.WithWatch(false))
+	defer b.Close()
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateDeferred}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetMeta(ctx, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	// changed behind the cache's back
+	if err := inner.MoveToState(ctx, "m1", metastorage.StateDeferred, metastorage.StateHold); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, _ := b.GetMeta(ctx, "m1"); m.State != metastorage.StateDeferred {
+		t.Errorf("default read: %s, want the cached deferred", m.State)
+	}
+	strong := metastorage.WithConsistency(ctx, metastorage.ConsistencyStrong)
+	if m, _ := b.GetMeta(strong, "m1"); m.State != metastorage.StateHold {
+		t.Errorf("strong read: %s, want hold", m.State)
+	}
+	// the strong read refreshed the entry
+	if m, _ := b.GetMeta(ctx, "m1"); m.State != metastorage.StateHold {
+		t.Errorf("default read after strong read: %s, want hold", m.State)
+	}
+}
+
+func TestBoundedStalenessCache(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	inner := memory.New()
+	b := cache.New(inner, cache.WithWatch(false), cache.WithTTL(time.Minute), options.WithClock(clk))
+	defer b.Close()
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateDeferred}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetMeta(ctx, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.MoveToState(ctx, "m1", metastorage.StateDeferred, metastorage.StateHold); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(10 * time.Second)
+
+	opts := metastorage.ReadOptions{Consistency: metastorage.ConsistencyBoundedStaleness, MaxStaleness: 30 * time.Second}
+	if m, _ := metastorage.GetMetaWithOptions(ctx, b, "m1", opts); m.State != metastorage.StateDeferred {
+		t.Errorf("read within staleness: %s, want the cached deferred", m.State)
+	}
+	opts.MaxStaleness = 5 * time.Second
+	if m, _ := metastorage.GetMetaWithOptions(ctx, b, "m1", opts); m.State != metastorage.StateHold {
+		t.Errorf("read beyond staleness: %s, want hold", m.State)
+	}
+}
@@ -0,0 +1,186 @@
+// Package consul implements metastorage.Backend on the Consul KV store,
+// for deployments already running Consul that want a small highly
+// available metadata store without new infrastructure.
+//
+// Store adapts Consul KV to kvadapter.CASStore: compare-and-swap writes
+// use Consul's check-and-set on the ModifyIndex the record was read at,
+// so MoveToState and UpdateMeta have exactly one winner across all
+// clients, and reads of records are consistent reads through the leader.
+// The kvadapter layout, index repair and in-process state counts apply:
+//
+//	<prefix>[<ns>/]m/<id>          encoded metadata
+//	<prefix>[<ns>/]s/<state>/<id>  index of the messages in a state
+//
+// Consul is meant for small stores: values are limited to 512 KiB and
+// listings return the whole index of a state, so keep it to thousands of
+// messages.
+//
+// Importing the package registers the
+// "consul://host:8500/prefix?datacenter=dc1&tls=true" DSN scheme. The
+// ACL token and other settings come from the CONSUL_* environment
+// variables, as for the consul CLI.
+package consul
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/kvadapter"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// DefaultPrefix is the prefix of all keys
+const DefaultPrefix = "retryspool/meta/"
+
+type prefixKey struct{}
+
+// WithPrefix sets the prefix of all keys (default DefaultPrefix)
+func WithPrefix(prefix string) options.Option {
+	return options.WithValue(prefixKey{}, prefix)
+}
+
+func init() {
+	registry.Register("consul", open)
+}
+
+// open handles "consul://host:8500/prefix?datacenter=dc1&tls=true" DSNs
+func open(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	cfg := api.DefaultConfig()
+	if dsn.Host != "" {
+		cfg.Address = dsn.Host
+	}
+	q := dsn.Query()
+	if dc := q.Get("datacenter"); dc != "" {
+		cfg.Datacenter = dc
+	}
+	switch q.Get("tls") {
+	case "":
+	case "true":
+		cfg.Scheme = "https"
+	case "false":
+		cfg.Scheme = "http"
+	default:
+		return nil, fmt.Errorf("consul DSN: invalid tls %q", q.Get("tls"))
+	}
+	if prefix := strings.TrimPrefix(dsn.Path, "/"); prefix != "" {
+		opts = append(opts, WithPrefix(strings.TrimSuffix(prefix, "/")+"/"))
+	}
+	return Open(ctx, cfg, opts...)
+}
+
+// Open creates a client with cfg and a backend on it
+func Open(ctx context.Context, cfg *api.Config, opts ...options.Option) (*kvadapter.Backend, error) {
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+	return New(ctx, client, opts...)
+}
+
+// New creates a kvadapter backend on the KV store of client. With a
+// namespace, keys are prefixed with "<namespace>/" after the prefix.
+func New(ctx context.Context, client *api.Client, opts ...options.Option) (*kvadapter.Backend, error) {
+	o := options.Apply(opts...)
+	b, err := kvadapter.New(ctx, NewStore(client, options.ValueOr(o, prefixKey{}, DefaultPrefix)), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+	return b, nil
+}
+
+// Store is a kvadapter.CASStore on Consul KV
+type Store struct {
+	kv     *api.KV
+	prefix string
+}
+
+// NewStore returns a store for the keys below prefix
+func NewStore(client *api.Client, prefix string) *Store {
+	return &Store{kv: client.KV(), prefix: prefix}
+}
+
+func consistent(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx)
+}
+
+func write(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+// Get returns the value of key with a consistent read
+func (s *Store) Get(ctx context.Context, key []byte) ([]byte, error) {
+	pair, _, err := s.kv.Get(s.prefix+string(key), consistent(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, kvadapter.ErrNotFound
+	}
+	return pair.Value, nil
+}
+
+// Put sets the value of key
+func (s *Store) Put(ctx context.Context, key, value []byte) error {
+	_, err := s.kv.Put(&api.KVPair{Key: s.prefix + string(key), Value: value}, write(ctx))
+	return err
+}
+
+// Delete removes key
+func (s *Store) Delete(ctx context.Context, key []byte) error {
+	_, err := s.kv.Delete(s.prefix+string(key), write(ctx))
+	return err
+}
+
+// Scan lists the keys starting with prefix. Consul cannot start a listing
+// after a key or limit it, so the whole prefix is read and cut here.
+func (s *Store) Scan(ctx context.Context, prefix, after []byte, limit int) ([]kvadapter.KV, error) {
+	pairs, _, err := s.kv.List(s.prefix+string(prefix), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]kvadapter.KV, 0, len(pairs))
+	for _, pair := range pairs {
+		k := []byte(strings.TrimPrefix(pair.Key, s.prefix))
+		if after != nil && bytes.Compare(k, after) <= 0 {
+			continue
+		}
+		kvs = append(kvs, kvadapter.KV{Key: k, Value: pair.Value})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+	}
+	return kvs, nil
+}
+
+// CompareAndSwap sets key to value if its current value equals old, with
+// a check-and-set on the ModifyIndex the value was read at; ModifyIndex 0
+// creates the key only if it does not exist
+func (s *Store) CompareAndSwap(ctx context.Context, key, old, value []byte) (bool, error) {
+	pair := &api.KVPair{Key: s.prefix + string(key), Value: value}
+	if old != nil {
+		cur, _, err := s.kv.Get(pair.Key, consistent(ctx))
+		if err != nil {
+			return false, err
+		}
+		if cur == nil || !bytes.Equal(cur.Value, old) {
+			return false, nil
+		}
+		pair.ModifyIndex = cur.ModifyIndex
+	}
+	ok, _, err := s.kv.CAS(pair, write(ctx))
+	return ok, err
+}
+
+// Close does nothing; the client holds no resources that need closing
+func (s *Store) Close() error {
+	return nil
+}
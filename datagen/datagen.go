@@ -0,0 +1,272 @@
+// Package datagen produces deterministic, realistic message metadata for
+// load tests, benchmarks and capacity planning. The same Config (including
+// Seed) always yields the same sequence of messages.
+package datagen
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// DefaultNow is the reference time used when Config.Now is zero, keeping
+// generated timestamps reproducible across runs
+var DefaultNow = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Config controls the generated distributions. Zero values select defaults.
+type Config struct {
+	Seed            int64
+	Now             time.Time                          // Reference time, default DefaultNow
+	IDPrefix        string                             // Default "msg-"
+	StateWeights    map[metastorage.QueueState]float64 // Relative state frequencies
+	PriorityWeights map[int]float64                    // Relative priority frequencies
+	MeanAge         time.Duration                      // Mean of the exponential age distribution, default 2h
+	MaxAge          time.Duration                      // Ages are capped here, default 5 days
+	MaxAttempts     int                                // Default 10
+	MeanSize        int64                              // Median message size in bytes, default 16 KiB
+	Domains         []string                           // Recipient domains, Zipf distributed
+	RetryPolicies   []string                           // Retry policy names, uniformly chosen
+	ExtraHeaders    map[string][]string                // Additional header keys with candidate values
+}
+
+var defaultStateWeights = map[metastorage.QueueState]float64{
+	metastorage.StateIncoming: 0.05,
+	metastorage.StateActive:   0.05,
+	metastorage.StateDeferred: 0.70,
+	metastorage.StateHold:     0.05,
+	metastorage.StateBounce:   0.10,
+	metastorage.StateArchived: 0.05,
+}
+
+var defaultPriorityWeights = map[int]float64{0: 0.80, 5: 0.15, 10: 0.05}
+
+var defaultDomains = []string{
+	"gmail.com", "outlook.com", "yahoo.com", "example.com", "example.org",
+	"mail.example.net", "corp.example", "gmx.de", "web.de", "icloud.com",
+}
+
+var transientErrors = []string{
+	"421 4.7.0 Try again later",
+	"450 4.2.1 Mailbox temporarily unavailable",
+	"451 4.3.0 Temporary local problem",
+	"452 4.2.2 Mailbox full",
+	"dial tcp: i/o timeout",
+	"connection reset by peer",
+	"TLS handshake timeout",
+}
+
+var permanentErrors = []string{
+	"550 5.1.1 User unknown",
+	"552 5.2.2 Mailbox quota exceeded",
+	"554 5.7.1 Message rejected as spam",
+	"no MX records found",
+}
+
+var subjects = []string{"Invoice", "Newsletter", "Password reset", "Order confirmation", "Meeting", "Report", "Alert"}
+
+// Generator yields messages one at a time
+type Generator struct {
+	cfg        Config
+	rng        *rand.Rand
+	states     weighted[metastorage.QueueState]
+	priorities weighted[int]
+	domains    *rand.Zipf
+	n          int
+}
+
+// New creates a generator for cfg
+func New(cfg Config) *Generator {
+	if cfg.Now.IsZero() {
+		cfg.Now = DefaultNow
+	}
+	if cfg.IDPrefix == "" {
+		cfg.IDPrefix = "msg-"
+	}
+	if len(cfg.StateWeights) == 0 {
+		cfg.StateWeights = defaultStateWeights
+	}
+	if len(cfg.PriorityWeights) == 0 {
+		cfg.PriorityWeights = defaultPriorityWeights
+	}
+	if cfg.MeanAge <= 0 {
+		cfg.MeanAge = 2 * time.Hour
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 5 * 24 * time.Hour
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.MeanSize <= 0 {
+		cfg.MeanSize = 16 << 10
+	}
+	if len(cfg.Domains) == 0 {
+		cfg.Domains = defaultDomains
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	g := &Generator{
+		cfg:        cfg,
+		rng:        rng,
+		states:     newWeighted(cfg.StateWeights, func(a, b metastorage.QueueState) bool { return a < b }),
+		priorities: newWeighted(cfg.PriorityWeights, func(a, b int) bool { return a < b }),
+	}
+	if len(cfg.Domains) > 1 {
+		g.domains = rand.NewZipf(rng, 1.2, 1, uint64(len(cfg.Domains)-1))
+	}
+	return g
+}
+
+// Next returns the next generated message
+func (g *Generator) Next() metastorage.MessageMetadata {
+	g.n++
+	cfg := g.cfg
+	state := g.states.pick(g.rng)
+
+	age := time.Duration(g.rng.ExpFloat64() * float64(cfg.MeanAge))
+	if age > cfg.MaxAge {
+		age = cfg.MaxAge
+	}
+	created := cfg.Now.Add(-age).Truncate(time.Millisecond)
+
+	m := metastorage.MessageMetadata{
+		ID:          fmt.Sprintf("%s%08d", cfg.IDPrefix, g.n),
+		State:       state,
+		MaxAttempts: cfg.MaxAttempts,
+		Created:     created,
+		Updated:     created,
+		Priority:    g.priorities.pick(g.rng),
+		Size:        g.size(),
+		Headers:     g.headers(),
+	}
+	if len(cfg.RetryPolicies) > 0 {
+		m.RetryPolicyName = cfg.RetryPolicies[g.rng.Intn(len(cfg.RetryPolicies))]
+	}
+
+	switch state {
+	case metastorage.StateIncoming:
+		// never attempted
+	case metastorage.StateActive:
+		m.Attempts = g.rng.Intn(cfg.MaxAttempts)
+	case metastorage.StateDeferred:
+		m.Attempts = 1
+		if cfg.MaxAttempts > 2 {
+			m.Attempts += g.rng.Intn(cfg.MaxAttempts - 1)
+		}
+		m.LastError = transientErrors[g.rng.Intn(len(transientErrors))]
+		// roughly a quarter is already due
+		m.NextRetry = cfg.Now.Add(time.Duration((g.rng.Float64()*4 - 1) * float64(15*time.Minute))).Truncate(time.Millisecond)
+	case metastorage.StateHold:
+		m.Attempts = g.rng.Intn(cfg.MaxAttempts)
+	case metastorage.StateBounce, metastorage.StateArchived:
+		m.Attempts = cfg.MaxAttempts
+		if g.rng.Float64() < 0.7 {
+			m.Attempts = 1 + g.rng.Intn(cfg.MaxAttempts)
+			m.LastError = permanentErrors[g.rng.Intn(len(permanentErrors))]
+		} else {
+			m.LastError = transientErrors[g.rng.Intn(len(transientErrors))]
+		}
+	}
+	if m.Attempts > 0 {
+		m.Updated = created.Add(time.Duration(g.rng.Float64() * float64(age))).Truncate(time.Millisecond)
+	}
+	return m
+}
+
+// Generate returns the next n messages
+func (g *Generator) Generate(n int) []metastorage.MessageMetadata {
+	messages := make([]metastorage.MessageMetadata, n)
+	for i := range messages {
+		messages[i] = g.Next()
+	}
+	return messages
+}
+
+// size draws a log-normal size around the configured median
+func (g *Generator) size() int64 {
+	s := float64(g.cfg.MeanSize) * math.Exp(g.rng.NormFloat64()*0.9)
+	if s < 256 {
+		s = 256
+	}
+	return int64(s)
+}
+
+func (g *Generator) headers() map[string]string {
+	domain := g.cfg.Domains[0]
+	if g.domains != nil {
+		domain = g.cfg.Domains[g.domains.Uint64()]
+	}
+	h := map[string]string{
+		"from":    fmt.Sprintf("noreply@sender%d.example", g.rng.Intn(20)),
+		"to":      fmt.Sprintf("user%d@%s", g.rng.Intn(100000), domain),
+		"subject": subjects[g.rng.Intn(len(subjects))],
+	}
+	keys := make([]string, 0, len(g.cfg.ExtraHeaders))
+	for k := range g.cfg.ExtraHeaders {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // map order must not influence the random sequence
+	for _, k := range keys {
+		if values := g.cfg.ExtraHeaders[k]; len(values) > 0 {
+			h[k] = values[g.rng.Intn(len(values))]
+		}
+	}
+	return h
+}
+
+// Seed generates count messages and stores them in backend.
+// It returns the number of messages stored.
+func Seed(ctx context.Context, backend metastorage.Backend, cfg Config, count int) (int, error) {
+	g := New(cfg)
+	for i := 0; i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		m := g.Next()
+		if err := backend.StoreMeta(ctx, m.ID, m); err != nil {
+			return i, fmt.Errorf("store %s: %w", m.ID, err)
+		}
+	}
+	return count, nil
+}
+
+// weighted picks values with probability proportional to their weight
+type weighted[T comparable] struct {
+	values []T
+	cum    []float64
+}
+
+func newWeighted[T comparable](weights map[T]float64, less func(a, b T) bool) weighted[T] {
+	var w weighted[T]
+	for v, weight := range weights {
+		if weight > 0 {
+			w.values = append(w.values, v)
+		}
+	}
+	// deterministic order independent of map iteration
+	sort.Slice(w.values, func(i, j int) bool { return less(w.values[i], w.values[j]) })
+	total := 0.0
+	for _, v := range w.values {
+		total += weights[v]
+		w.cum = append(w.cum, total)
+	}
+	return w
+}
+
+func (w weighted[T]) pick(rng *rand.Rand) T {
+	var zero T
+	if len(w.values) == 0 {
+		return zero
+	}
+	x := rng.Float64() * w.cum[len(w.cum)-1]
+	i := sort.SearchFloat64s(w.cum, x)
+	if i >= len(w.values) {
+		i = len(w.values) - 1
+	}
+	return w.values[i]
+}
@@ -0,0 +1,58 @@
+package metastorage
+
+import "time"
+
+// IsTerminal reports whether s is a final state that messages do not
+// leave on their own (bounce, archived)
+func (s QueueState) IsTerminal() bool {
+	return s == StateBounce || s == StateArchived
+}
+
+// Age returns the time since m was created, 0 if Created is unset
+func (m MessageMetadata) Age(now time.Time) time.Duration {
+	if m.Created.IsZero() {
+		return 0
+	}
+	return now.Sub(m.Created)
+}
+
+// Idle returns the time since m was last updated, 0 if Updated is unset
+func (m MessageMetadata) Idle(now time.Time) time.Duration {
+	if m.Updated.IsZero() {
+		return 0
+	}
+	return now.Sub(m.Updated)
+}
+
+// AttemptsRemaining returns the delivery attempts left before MaxAttempts
+// is reached, or -1 if MaxAttempts is 0 (unlimited)
+func (m MessageMetadata) AttemptsRemaining() int {
+	if m.MaxAttempts <= 0 {
+		return -1
+	}
+	return max(m.MaxAttempts-m.Attempts, 0)
+}
+
+// Exhausted reports whether m has used up its MaxAttempts
+func (m MessageMetadata) Exhausted() bool {
+	return m.AttemptsRemaining() == 0
+}
+
+// IsDue reports whether m may be delivered at now, see the IsDue function
+func (m MessageMetadata) IsDue(now time.Time) bool {
+	return IsDue(m, now)
+}
+
+// IsTerminal reports whether m is in a terminal state
+func (m MessageMetadata) IsTerminal() bool {
+	return m.State.IsTerminal()
+}
+
+// TimeInState returns the time since m entered its state, 0 if
+// StateEnteredAt is unset
+func (m MessageMetadata) TimeInState(now time.Time) time.Duration {
+	if m.StateEnteredAt.IsZero() {
+		return 0
+	}
+	return now.Sub(m.StateEnteredAt)
+}
@@ -0,0 +1,254 @@
+// Package diff compares the messages of two sources, backends or export
+// files, and reports messages missing on either side and messages whose
+// fields differ. It is meant for validating migrations and measuring
+// replication lag.
+//
+// The right source is loaded into memory and the left one is streamed
+// against it, so put the smaller source on the right.
+//
+// For continuous checks of live backends, the Verifier compares random
+// samples instead of complete contents.
+package diff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/export"
+)
+
+// Source yields the messages to compare
+type Source interface {
+	// Each calls fn for every message
+	Each(ctx context.Context, fn func(metastorage.MessageMetadata) error) error
+}
+
+type backendSource struct {
+	backend metastorage.Backend
+	states  []metastorage.QueueState
+}
+
+// FromBackend returns a source reading states of backend (default all)
+func FromBackend(backend metastorage.Backend, states ...metastorage.QueueState) Source {
+	if len(states) == 0 {
+		states = metastorage.States()
+	}
+	return backendSource{backend: backend, states: states}
+}
+
+func (s backendSource) Each(ctx context.Context, fn func(metastorage.MessageMetadata) error) error {
+	for _, state := range s.states {
+		iter, err := s.backend.NewMessageIterator(ctx, state, 500)
+		if err != nil {
+			return err
+		}
+		for {
+			m, more, err := iter.Next(ctx)
+			if err == nil && more {
+				err = fn(m)
+			}
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			if !more {
+				break
+			}
+		}
+		iter.Close()
+	}
+	return nil
+}
+
+type decoderSource struct {
+	dec    export.Decoder
+	states map[metastorage.QueueState]bool
+}
+
+// FromDecoder returns a source reading an export file. Tombstones of
+// incremental exports are skipped. If states are given, messages of other
+// states are skipped as well.
+func FromDecoder(dec export.Decoder, states ...metastorage.QueueState) Source {
+	s := decoderSource{dec: dec}
+	if len(states) > 0 {
+		s.states = make(map[metastorage.QueueState]bool, len(states))
+		for _, state := range states {
+			s.states[state] = true
+		}
+	}
+	return s
+}
+
+func (s decoderSource) Each(ctx context.Context, fn func(metastorage.MessageMetadata) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, err := s.dec.Decode()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.Deleted || (s.states != nil && !s.states[r.Message.State]) {
+			continue
+		}
+		if err := fn(r.Message); err != nil {
+			return err
+		}
+	}
+}
+
+// Kind classifies a difference
+type Kind string
+
+const (
+	KindOnlyLeft  Kind = "only-left"  // missing on the right
+	KindOnlyRight Kind = "only-right" // missing on the left
+	KindMismatch  Kind = "mismatch"   // present on both sides with different fields
+)
+
+// Difference describes one message that differs
+type Difference struct {
+	ID     string
+	Kind   Kind
+	Fields []string                     // KindMismatch: names of the differing fields
+	Left   *metastorage.MessageMetadata // nil for KindOnlyRight
+	Right  *metastorage.MessageMetadata // nil for KindOnlyLeft
+}
+
+// Options controls a comparison
+type Options struct {
+	Ignore         []string      // Field names not to compare, e.g. "updated", "sequence"
+	TimePrecision  time.Duration // Timestamps are truncated to this before comparing, default 1µs
+	MaxDifferences int           // Differences kept in the report, default 1000; all are counted
+}
+
+// Report is the result of a comparison
+type Report struct {
+	Left       int // messages read from the left source
+	Right      int // messages read from the right source
+	OnlyLeft   int
+	OnlyRight  int
+	Mismatched int
+
+	// Differences holds up to Options.MaxDifferences differences, sorted by ID
+	Differences []Difference
+}
+
+// Equal reports whether no differences were found
+func (r Report) Equal() bool {
+	return r.OnlyLeft == 0 && r.OnlyRight == 0 && r.Mismatched == 0
+}
+
+// Compare reads both sources and reports their differences
+func Compare(ctx context.Context, left, right Source, opts Options) (Report, error) {
+	if opts.TimePrecision <= 0 {
+		opts.TimePrecision = time.Microsecond
+	}
+	if opts.MaxDifferences <= 0 {
+		opts.MaxDifferences = 1000
+	}
+	ignore := make(map[string]bool, len(opts.Ignore))
+	for _, f := range opts.Ignore {
+		ignore[f] = true
+	}
+
+	var r Report
+	add := func(d Difference) {
+		if len(r.Differences) < opts.MaxDifferences {
+			r.Differences = append(r.Differences, d)
+		}
+	}
+
+	rights := make(map[string]metastorage.MessageMetadata)
+	err := right.Each(ctx, func(m metastorage.MessageMetadata) error {
+		r.Right++
+		rights[m.ID] = m
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = left.Each(ctx, func(m metastorage.MessageMetadata) error {
+		r.Left++
+		other, ok := rights[m.ID]
+		if !ok {
+			r.OnlyLeft++
+			add(Difference{ID: m.ID, Kind: KindOnlyLeft, Left: &m})
+			return nil
+		}
+		delete(rights, m.ID)
+		if fields := differingFields(m, other, opts.TimePrecision, ignore); len(fields) > 0 {
+			r.Mismatched++
+			add(Difference{ID: m.ID, Kind: KindMismatch, Fields: fields, Left: &m, Right: &other})
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	ids := make([]string, 0, len(rights))
+	for id := range rights {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		m := rights[id]
+		r.OnlyRight++
+		add(Difference{ID: id, Kind: KindOnlyRight, Right: &m})
+	}
+	sort.SliceStable(r.Differences, func(i, j int) bool { return r.Differences[i].ID < r.Differences[j].ID })
+	return r, nil
+}
+
+// differingFields returns the names of the fields that differ between a
+// and b, using the field names of the query package
+func differingFields(a, b metastorage.MessageMetadata, precision time.Duration, ignore map[string]bool) []string {
+	sameTime := func(x, y time.Time) bool {
+		return x.Truncate(precision).Equal(y.Truncate(precision))
+	}
+	checks := []struct {
+		name  string
+		equal bool
+	}{
+		{"state", a.State == b.State},
+		{"attempts", a.Attempts == b.Attempts},
+		{"max_attempts", a.MaxAttempts == b.MaxAttempts},
+		{"next_retry", sameTime(a.NextRetry, b.NextRetry)},
+		{"created", sameTime(a.Created, b.Created)},
+		{"updated", sameTime(a.Updated, b.Updated)},
+		{"last_error", a.LastError == b.LastError},
+		{"size", a.Size == b.Size},
+		{"priority", a.Priority == b.Priority},
+		{"headers", maps.Equal(a.Headers, b.Headers)},
+		{"policy", a.RetryPolicyName == b.RetryPolicyName},
+		{"sequence", a.Sequence == b.Sequence},
+		{"delivery_window", sameWindow(a.DeliveryWindow, b.DeliveryWindow, sameTime)},
+		{"state_entered", sameTime(a.StateEnteredAt, b.StateEnteredAt)},
+	}
+	var fields []string
+	for _, c := range checks {
+		if !c.equal && !ignore[c.name] {
+			fields = append(fields, c.name)
+		}
+	}
+	return fields
+}
+
+func sameWindow(a, b metastorage.DeliveryWindow, sameTime func(x, y time.Time) bool) bool {
+	return sameTime(a.NotBefore, b.NotBefore) &&
+		sameTime(a.NotAfter, b.NotAfter) &&
+		a.Hours == b.Hours &&
+		slices.Equal(a.Weekdays, b.Weekdays) &&
+		a.TimeZone == b.TimeZone
+}
@@ -0,0 +1,282 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+const (
+	// MetricSampled counts messages checked by the Verifier, labeled by
+	// state and side (primary, replica)
+	MetricSampled = "metastorage_verify_sampled_total"
+
+	// MetricDivergent counts sampled messages that differ beyond the lag
+	// tolerance, labeled by state and kind (missing, mismatch, extra)
+	MetricDivergent = "metastorage_verify_divergent_total"
+
+	// MetricDivergence is the divergent fraction of the last round's
+	// samples, labeled by state
+	MetricDivergence = "metastorage_verify_divergence_ratio"
+)
+
+// Verifier defaults
+const (
+	DefaultVerifyInterval = time.Minute
+	DefaultSampleSize     = 100
+	DefaultLagTolerance   = 5 * time.Second
+)
+
+// maxExamples is the number of differences kept per Verifier round
+const maxExamples = 10
+
+type (
+	intervalKey      struct{}
+	sampleSizeKey    struct{}
+	statesKey        struct{}
+	lagToleranceKey  struct{}
+	ignoreKey        struct{}
+	resultHandlerKey struct{}
+)
+
+// WithInterval sets the time between verification rounds (default
+// DefaultVerifyInterval)
+func WithInterval(d time.Duration) options.Option {
+	return options.WithValue(intervalKey{}, d)
+}
+
+// WithSampleSize sets the messages sampled per state and side in every
+// round (default DefaultSampleSize)
+func WithSampleSize(n int) options.Option {
+	return options.WithValue(sampleSizeKey{}, n)
+}
+
+// WithStates selects the verified states (default all)
+func WithStates(states ...metastorage.QueueState) options.Option {
+	return options.WithValue(statesKey{}, states)
+}
+
+// WithLagTolerance sets how recently a message may have changed on the
+// primary for a difference to count as replication lag rather than
+// divergence (default DefaultLagTolerance)
+func WithLagTolerance(d time.Duration) options.Option {
+	return options.WithValue(lagToleranceKey{}, d)
+}
+
+// WithIgnore sets field names not to compare, see Options.Ignore
+func WithIgnore(fields ...string) options.Option {
+	return options.WithValue(ignoreKey{}, fields)
+}
+
+// WithResultHandler sets a function called with the result of every round
+func WithResultHandler(h func(VerifyResult)) options.Option {
+	return options.WithValue(resultHandlerKey{}, h)
+}
+
+// StateResult counts the outcome of one state in a verification round
+type StateResult struct {
+	Sampled    int // messages checked on both sides
+	Missing    int // sampled on the primary, absent on the replica
+	Mismatched int // fields differ
+	Extra      int // sampled on the replica, absent on the primary
+	Lagging    int // differences within the lag tolerance, not counted as divergent
+}
+
+// Divergent returns the number of divergent messages
+func (r StateResult) Divergent() int {
+	return r.Missing + r.Mismatched + r.Extra
+}
+
+// VerifyResult is the outcome of one verification round
+type VerifyResult struct {
+	Time     time.Time
+	States   map[metastorage.QueueState]StateResult
+	Examples []Difference // up to 10 divergent messages
+}
+
+// Divergent returns the number of divergent messages across all states
+func (r VerifyResult) Divergent() int {
+	n := 0
+	for _, s := range r.States {
+		n += s.Divergent()
+	}
+	return n
+}
+
+// Verifier continuously compares random samples of a primary backend and
+// its replica, e.g. the two targets of a dual-write setup. Differences on
+// messages that changed on the primary within the lag tolerance are
+// counted as lag; everything else is divergence.
+type Verifier struct {
+	primary  metastorage.Backend
+	replica  metastorage.Backend
+	interval time.Duration
+	sample   int
+	states   []metastorage.QueueState
+	lag      time.Duration
+	ignore   map[string]bool
+	handler  func(VerifyResult)
+	clock    clock.Clock
+	logger   *slog.Logger
+	metrics  metrics.Recorder
+
+	mu   sync.Mutex
+	last VerifyResult
+}
+
+// NewVerifier creates a verifier for primary and replica
+func NewVerifier(primary, replica metastorage.Backend, opts ...options.Option) *Verifier {
+	o := options.Apply(opts...)
+	v := &Verifier{
+		primary:  primary,
+		replica:  replica,
+		interval: options.ValueOr(o, intervalKey{}, DefaultVerifyInterval),
+		sample:   options.ValueOr(o, sampleSizeKey{}, DefaultSampleSize),
+		states:   options.ValueOr(o, statesKey{}, metastorage.States()),
+		lag:      options.ValueOr(o, lagToleranceKey{}, DefaultLagTolerance),
+		ignore:   make(map[string]bool),
+		handler:  options.ValueOr[func(VerifyResult)](o, resultHandlerKey{}, nil),
+		clock:    o.Clock,
+		logger:   o.Logger,
+		metrics:  o.Metrics,
+	}
+	for _, f := range options.ValueOr[[]string](o, ignoreKey{}, nil) {
+		v.ignore[f] = true
+	}
+	return v
+}
+
+// Last returns the result of the last completed round
+func (v *Verifier) Last() VerifyResult {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.last
+}
+
+// Run verifies every interval until ctx is done and returns ctx.Err()
+func (v *Verifier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := v.Verify(ctx); err != nil && ctx.Err() == nil {
+			v.logger.Warn("metastorage verifier: round failed", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Verify runs one round: it samples every state on both sides and looks
+// the samples up on the other side
+func (v *Verifier) Verify(ctx context.Context) (VerifyResult, error) {
+	res := VerifyResult{States: make(map[metastorage.QueueState]StateResult, len(v.states))}
+	for _, state := range v.states {
+		sr, err := v.verifyState(ctx, state, &res)
+		if err != nil {
+			return res, err
+		}
+		res.States[state] = sr
+		labels := metrics.Labels{metrics.LabelState: metastorage.StateLabel(state)}
+		ratio := 0.0
+		if sr.Sampled > 0 {
+			ratio = float64(sr.Divergent()) / float64(sr.Sampled)
+		}
+		v.metrics.Gauge(MetricDivergence, labels, ratio)
+	}
+	res.Time = v.clock.Now()
+	v.mu.Lock()
+	v.last = res
+	v.mu.Unlock()
+	if n := res.Divergent(); n > 0 {
+		v.logger.Warn("metastorage replica diverges from primary", slog.Int("messages", n))
+	}
+	if v.handler != nil {
+		v.handler(res)
+	}
+	return res, nil
+}
+
+func (v *Verifier) verifyState(ctx context.Context, state metastorage.QueueState, res *VerifyResult) (StateResult, error) {
+	var sr StateResult
+	now := v.clock.Now()
+	diverged := func(kind string, d Difference) {
+		v.metrics.Counter(MetricDivergent, metrics.Labels{metrics.LabelState: metastorage.StateLabel(state), "kind": kind}, 1)
+		if len(res.Examples) < maxExamples {
+			res.Examples = append(res.Examples, d)
+		}
+	}
+
+	primaries, err := metastorage.SampleMessages(ctx, v.primary, state, v.sample)
+	if err != nil {
+		return sr, err
+	}
+	v.metrics.Counter(MetricSampled, metrics.Labels{metrics.LabelState: metastorage.StateLabel(state), "side": "primary"}, float64(len(primaries)))
+	for _, p := range primaries {
+		sr.Sampled++
+		r, err := v.replica.GetMeta(ctx, p.ID)
+		switch {
+		case errors.Is(err, metastorage.ErrMessageNotFound):
+			if v.recent(p, now) {
+				sr.Lagging++
+				continue
+			}
+			sr.Missing++
+			diverged("missing", Difference{ID: p.ID, Kind: KindOnlyLeft, Left: &p})
+		case err != nil:
+			return sr, err
+		default:
+			fields := differingFields(p, r, time.Microsecond, v.ignore)
+			if len(fields) == 0 {
+				continue
+			}
+			// the message may have changed again since it was sampled
+			if v.recent(p, now) || v.changed(ctx, p) {
+				sr.Lagging++
+				continue
+			}
+			sr.Mismatched++
+			diverged("mismatch", Difference{ID: p.ID, Kind: KindMismatch, Fields: fields, Left: &p, Right: &r})
+		}
+	}
+
+	replicas, err := metastorage.SampleMessages(ctx, v.replica, state, v.sample)
+	if err != nil {
+		return sr, err
+	}
+	v.metrics.Counter(MetricSampled, metrics.Labels{metrics.LabelState: metastorage.StateLabel(state), "side": "replica"}, float64(len(replicas)))
+	for _, r := range replicas {
+		sr.Sampled++
+		_, err := v.primary.GetMeta(ctx, r.ID)
+		switch {
+		case errors.Is(err, metastorage.ErrMessageNotFound):
+			// a delete not yet replicated looks the same, but deletes
+			// leave nothing to date them; lag shows up as a transient extra
+			sr.Extra++
+			diverged("extra", Difference{ID: r.ID, Kind: KindOnlyRight, Right: &r})
+		case err != nil:
+			return sr, err
+		}
+	}
+	return sr, nil
+}
+
+// recent reports whether m changed on the primary within the lag tolerance
+func (v *Verifier) recent(m metastorage.MessageMetadata, now time.Time) bool {
+	return now.Sub(m.Updated) < v.lag
+}
+
+// changed reports whether the primary copy of m changed since it was sampled
+func (v *Verifier) changed(ctx context.Context, m metastorage.MessageMetadata) bool {
+	cur, err := v.primary.GetMeta(ctx, m.ID)
+	return err != nil || len(differingFields(m, cur, time.Microsecond, nil)) > 0
+}
@@ -0,0 +1,327 @@
+// Package doctor runs preflight checks against a backend: connectivity,
+// latency, index presence, clock skew, counter drift and stale leases.
+// Every check yields a Finding with a suggested action, so a new
+// deployment can be verified in one step.
+//
+// All checks are read-only.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+)
+
+// Severity grades a finding
+type Severity int
+
+const (
+	OK Severity = iota
+	Skipped
+	Warning
+	Failure
+)
+
+func (s Severity) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Skipped:
+		return "skip"
+	case Warning:
+		return "warn"
+	default:
+		return "FAIL"
+	}
+}
+
+// Finding is the result of one check
+type Finding struct {
+	Check    string
+	Severity Severity
+	Detail   string
+	Action   string // what to do about it, empty for OK
+}
+
+// IndexChecker is implemented by backends that can verify their schema,
+// e.g. SQL backends checking that the indexes used by iterators exist
+type IndexChecker interface {
+	// MissingIndexes returns the names of expected indexes that do not exist
+	MissingIndexes(ctx context.Context) ([]string, error)
+}
+
+// Config controls a doctor run
+type Config struct {
+	Probes        int           // Latency probes, default 5
+	MaxLatency    time.Duration // Warn above this probe latency, default 100ms
+	SkewTolerance time.Duration // Warn above this clock offset, default clock.DefaultSkewTolerance
+	MaxScan       int           // Skip drift checks for states with more messages, default 100000
+	Timeout       time.Duration // Per check, default 30s
+}
+
+func (c *Config) defaults() {
+	if c.Probes <= 0 {
+		c.Probes = 5
+	}
+	if c.MaxLatency <= 0 {
+		c.MaxLatency = 100 * time.Millisecond
+	}
+	if c.SkewTolerance <= 0 {
+		c.SkewTolerance = clock.DefaultSkewTolerance
+	}
+	if c.MaxScan <= 0 {
+		c.MaxScan = 100000
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+}
+
+// Report is the outcome of a doctor run
+type Report struct {
+	Findings []Finding
+}
+
+// Worst returns the highest severity of all findings
+func (r Report) Worst() Severity {
+	worst := OK
+	for _, f := range r.Findings {
+		worst = max(worst, f.Severity)
+	}
+	return worst
+}
+
+func (r Report) String() string {
+	var sb strings.Builder
+	for _, f := range r.Findings {
+		fmt.Fprintf(&sb, "[%-4s] %-14s %s\n", f.Severity, f.Check, f.Detail)
+		if f.Action != "" {
+			fmt.Fprintf(&sb, "       %-14s -> %s\n", "", f.Action)
+		}
+	}
+	return sb.String()
+}
+
+// probeID is read by the connectivity and latency checks; it never exists
+const probeID = metastorage.ReservedHeaderPrefix + "doctor-probe"
+
+// Run performs all checks. The remaining checks are skipped if the backend
+// is not reachable.
+func Run(ctx context.Context, b metastorage.Backend, cfg Config) Report {
+	cfg.defaults()
+	var r Report
+	check := func(fn func(context.Context, metastorage.Backend, Config) Finding) Finding {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+		f := fn(ctx, b, cfg)
+		r.Findings = append(r.Findings, f)
+		return f
+	}
+	if check(checkConnectivity).Severity == Failure {
+		return r
+	}
+	check(checkLatency)
+	check(checkIndexes)
+	check(checkSkew)
+	check(checkDrift)
+	check(checkLeases)
+	return r
+}
+
+func probe(ctx context.Context, b metastorage.Backend) error {
+	_, err := b.GetMeta(ctx, probeID)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		return nil
+	}
+	return err
+}
+
+func checkConnectivity(ctx context.Context, b metastorage.Backend, _ Config) Finding {
+	f := Finding{Check: "connectivity"}
+	if err := probe(ctx, b); err != nil {
+		f.Severity = Failure
+		f.Detail = err.Error()
+		f.Action = "verify the DSN, credentials and network path to the backend"
+		return f
+	}
+	f.Detail = "backend answers reads"
+	return f
+}
+
+func checkLatency(ctx context.Context, b metastorage.Backend, cfg Config) Finding {
+	f := Finding{Check: "latency"}
+	samples := make([]time.Duration, 0, cfg.Probes)
+	for range cfg.Probes {
+		start := time.Now()
+		if err := probe(ctx, b); err != nil {
+			f.Severity = Failure
+			f.Detail = err.Error()
+			f.Action = "the backend is reachable but reads fail intermittently; check its health"
+			return f
+		}
+		samples = append(samples, time.Since(start))
+	}
+	slices.Sort(samples)
+	median, worst := samples[len(samples)/2], samples[len(samples)-1]
+	f.Detail = fmt.Sprintf("median %s, max %s over %d reads", median.Round(time.Microsecond), worst.Round(time.Microsecond), len(samples))
+	if median > cfg.MaxLatency {
+		f.Severity = Warning
+		f.Action = fmt.Sprintf("reads take longer than %s; place the backend closer to the spool or add a cache layer", cfg.MaxLatency)
+	}
+	return f
+}
+
+func checkIndexes(ctx context.Context, b metastorage.Backend, _ Config) Finding {
+	f := Finding{Check: "indexes"}
+	checker, ok := metastorage.As[IndexChecker](b)
+	if !ok {
+		f.Severity = Skipped
+		f.Detail = "backend cannot report its indexes"
+		return f
+	}
+	missing, err := checker.MissingIndexes(ctx)
+	switch {
+	case err != nil:
+		f.Severity = Warning
+		f.Detail = err.Error()
+		f.Action = "grant the backend user read access to the schema catalog"
+	case len(missing) > 0:
+		f.Severity = Failure
+		f.Detail = "missing: " + strings.Join(missing, ", ")
+		f.Action = "run the backend's migrations; iteration falls back to full scans"
+	default:
+		f.Detail = "all expected indexes exist"
+	}
+	return f
+}
+
+func checkSkew(ctx context.Context, b metastorage.Backend, cfg Config) Finding {
+	f := Finding{Check: "clock skew"}
+	server, ok := metastorage.As[metastorage.ServerTimeBackend](b)
+	if !ok {
+		f.Severity = Skipped
+		f.Detail = "backend does not report its server time"
+		return f
+	}
+	offset, rtt, err := clock.MeasureSkew(ctx, server, clock.System)
+	if err != nil {
+		f.Severity = Warning
+		f.Detail = err.Error()
+		return f
+	}
+	f.Detail = fmt.Sprintf("server offset %s (rtt %s)", offset.Round(time.Millisecond), rtt.Round(time.Millisecond))
+	if clock.SkewExceeded(offset, cfg.SkewTolerance) {
+		f.Severity = Warning
+		f.Action = fmt.Sprintf("offset exceeds %s; synchronize the hosts with NTP or raise options.WithClockSkew", cfg.SkewTolerance)
+	}
+	return f
+}
+
+func checkDrift(ctx context.Context, b metastorage.Backend, cfg Config) Finding {
+	f := Finding{Check: "counter drift"}
+	counter, ok := metastorage.As[metastorage.StateCounterBackend](b)
+	if !ok {
+		f.Severity = Skipped
+		f.Detail = "backend has no state counters"
+		return f
+	}
+	var drifted, skipped []string
+	for _, state := range metastorage.States() {
+		want := counter.GetStateCount(state)
+		if want < 0 || want > int64(cfg.MaxScan) {
+			skipped = append(skipped, state.String())
+			continue
+		}
+		got, err := count(ctx, b, state, cfg.MaxScan)
+		if err != nil {
+			f.Severity = Warning
+			f.Detail = fmt.Sprintf("counting %s: %v", state, err)
+			return f
+		}
+		if got != want {
+			drifted = append(drifted, fmt.Sprintf("%s counter %d, actual %d", state, want, got))
+		}
+	}
+	switch {
+	case len(drifted) > 0:
+		f.Severity = Warning
+		f.Detail = strings.Join(drifted, "; ")
+		f.Action = "counters may lag under concurrent writes; if the drift persists, rebuild them"
+	default:
+		f.Detail = "counters match the stored messages"
+	}
+	if len(skipped) > 0 {
+		f.Detail += " (not verified: " + strings.Join(skipped, ", ") + ")"
+	}
+	return f
+}
+
+func count(ctx context.Context, b metastorage.Backend, state metastorage.QueueState, limit int) (int64, error) {
+	iter, err := b.NewMessageIterator(ctx, state, 500)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	var n int64
+	for n <= int64(limit) {
+		_, more, err := iter.Next(ctx)
+		if err != nil {
+			return n, err
+		}
+		if !more {
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+func checkLeases(ctx context.Context, b metastorage.Backend, cfg Config) Finding {
+	f := Finding{Check: "stale leases"}
+	iter, err := b.NewMessageIterator(ctx, metastorage.StateActive, 500)
+	if err != nil {
+		f.Severity = Warning
+		f.Detail = err.Error()
+		return f
+	}
+	defer iter.Close()
+
+	now := time.Now()
+	var stale []string
+	oldest := now
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			f.Severity = Warning
+			f.Detail = err.Error()
+			return f
+		}
+		if !more {
+			break
+		}
+		_, expires, ok := metastorage.LeaseOf(m)
+		if !ok || expires.IsZero() || now.Before(expires.Add(cfg.SkewTolerance)) {
+			continue
+		}
+		stale = append(stale, m.ID)
+		if expires.Before(oldest) {
+			oldest = expires
+		}
+	}
+	if len(stale) == 0 {
+		f.Detail = "no expired leases in the active state"
+		return f
+	}
+	f.Severity = Warning
+	examples := stale[:min(len(stale), 5)]
+	f.Detail = fmt.Sprintf("%d expired leases, oldest expired %s ago (e.g. %s)",
+		len(stale), now.Sub(oldest).Round(time.Second), strings.Join(examples, ", "))
+	f.Action = "their workers crashed or hung; run lease.ExpiryMonitor or move the messages back to be retried"
+	return f
+}
@@ -0,0 +1,121 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrDraining is returned when a new incoming message is stored while the
+// backend is draining
+var ErrDraining = errors.New("backend is draining")
+
+// DrainBackend extends Backend with a draining flag. While draining, new
+// incoming messages are refused with ErrDraining; producers can check
+// IsDraining up front to hand messages to another node.
+type DrainBackend interface {
+	Backend
+
+	// SetDraining sets the draining flag
+	SetDraining(draining bool)
+
+	// Draining reports whether the flag is set
+	Draining() bool
+}
+
+// IsDraining reports whether any layer of b is draining
+func IsDraining(b Backend) bool {
+	d, ok := As[DrainBackend](b)
+	return ok && d.Draining()
+}
+
+// DrainOptions controls Drain
+type DrainOptions struct {
+	States       []QueueState  // States that must empty, default active and deferred
+	Timeout      time.Duration // Maximum wait, 0 waits until ctx is done
+	PollInterval time.Duration // Time between counts, default 1s
+}
+
+// DrainReport is the result of Drain
+type DrainReport struct {
+	Drained   bool               // All states emptied in time
+	Refusing  bool               // New incoming messages were refused during the drain
+	Remaining map[QueueState]int // Messages left per state at the end
+	Elapsed   time.Duration
+}
+
+// Drain prepares a node for decommissioning: it sets the draining flag of
+// b, so new incoming messages are refused, and waits until the drained
+// states are empty or the timeout passes. Without a DrainBackend layer
+// (see the drain middleware) nothing is refused and Drain only waits.
+// The flag stays set; call SetDraining(false) to resume.
+//
+// A timeout is not an error: the report lists the remaining work. An
+// error is returned if counting fails or ctx is done.
+func Drain(ctx context.Context, b Backend, opts DrainOptions) (DrainReport, error) {
+	if len(opts.States) == 0 {
+		opts.States = []QueueState{StateActive, StateDeferred}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	start := time.Now()
+	var report DrainReport
+	if d, ok := As[DrainBackend](b); ok {
+		d.SetDraining(true)
+		report.Refusing = true
+	}
+	var deadline <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		remaining, err := remainingWork(ctx, b, opts.States)
+		report.Remaining = remaining
+		report.Elapsed = time.Since(start)
+		if err != nil {
+			return report, err
+		}
+		report.Drained = true
+		for _, n := range remaining {
+			if n > 0 {
+				report.Drained = false
+			}
+		}
+		if report.Drained {
+			return report, nil
+		}
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		case <-deadline:
+			return report, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// remainingWork counts the messages of states
+func remainingWork(ctx context.Context, b Backend, states []QueueState) (map[QueueState]int, error) {
+	remaining := make(map[QueueState]int, len(states))
+	counter, counted := As[StateCounterBackend](b)
+	for _, state := range states {
+		if counted {
+			if n := counter.GetStateCount(state); n >= 0 {
+				remaining[state] = int(n)
+				continue
+			}
+		}
+		res, err := b.ListMessages(ctx, state, MessageListOptions{Limit: 1})
+		if err != nil {
+			return remaining, err
+		}
+		remaining[state] = res.Total
+	}
+	return remaining, nil
+}
@@ -0,0 +1,113 @@
+package metastorage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// DueBackend is implemented by backends that find due messages through
+// an index on NextRetry instead of scanning the deferred state, e.g. the
+// (state, next_retry) index of PostgreSQL
+type DueBackend interface {
+	Backend
+
+	// GetDueMessages returns up to limit deferred messages whose NextRetry
+	// is not after before, see the GetDueMessages function
+	GetDueMessages(ctx context.Context, before time.Time, limit int) ([]MessageMetadata, error)
+
+	// NewDueIterator iterates over the deferred messages whose NextRetry
+	// is not after before, see the NewDueIterator function
+	NewDueIterator(ctx context.Context, before time.Time, batchSize int) (MessageIterator, error)
+}
+
+// GetDueMessages returns up to limit deferred messages whose NextRetry is
+// not after before, oldest NextRetry first, then by ID.
+// limit <= 0 returns all. Unlike DueMessages it ignores delivery windows
+// and clock skew; it answers "what should have been retried by then".
+//
+// If the outermost layer of b implements DueBackend the messages are read
+// natively; otherwise the deferred state is scanned and filtered.
+func GetDueMessages(ctx context.Context, b Backend, before time.Time, limit int) ([]MessageMetadata, error) {
+	if d, ok := Outer[DueBackend](b); ok {
+		return d.GetDueMessages(ctx, before, limit)
+	}
+	iter, err := b.NewMessageIterator(ctx, StateDeferred, 100)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var due []MessageMetadata
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		if m.NextRetry.After(before) {
+			continue
+		}
+		due = append(due, m)
+		// trim periodically instead of per message to keep the scan cheap
+		if limit > 0 && len(due) >= 2*limit {
+			SortDue(due)
+			due = due[:limit]
+		}
+	}
+	SortDue(due)
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// NewDueIterator returns an iterator over the deferred messages whose
+// NextRetry is not after before, in the order of GetDueMessages. Native
+// iterators of a DueBackend fetch batchSize messages at a time; the
+// fallback reads all due messages with GetDueMessages when it is opened.
+func NewDueIterator(ctx context.Context, b Backend, before time.Time, batchSize int) (MessageIterator, error) {
+	if d, ok := Outer[DueBackend](b); ok {
+		return d.NewDueIterator(ctx, before, batchSize)
+	}
+	due, err := GetDueMessages(ctx, b, before, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &sliceIterator{messages: due}, nil
+}
+
+// SortDue sorts messages by NextRetry, then ID, the order of
+// GetDueMessages
+func SortDue(ms []MessageMetadata) {
+	sort.Slice(ms, func(i, j int) bool {
+		a, b := ms[i], ms[j]
+		if !a.NextRetry.Equal(b.NextRetry) {
+			return a.NextRetry.Before(b.NextRetry)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// sliceIterator iterates over messages read in advance
+type sliceIterator struct {
+	messages []MessageMetadata
+}
+
+func (it *sliceIterator) Next(ctx context.Context) (MessageMetadata, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return MessageMetadata{}, false, err
+	}
+	if len(it.messages) == 0 {
+		return MessageMetadata{}, false, nil
+	}
+	m := it.messages[0]
+	it.messages = it.messages[1:]
+	return m, true, nil
+}
+
+func (it *sliceIterator) Close() error {
+	it.messages = nil
+	return nil
+}
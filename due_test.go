@@ -0,0 +1,71 @@
+package metastorage_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func TestDueMessages(t *testing.T) {
+	for name, wrap := range map[string]func(*memory.Backend) metastorage.Backend{
+		"native":  func(b *memory.Backend) metastorage.Backend { return b },
+		"generic": func(b *memory.Backend) metastorage.Backend { return plain{b} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			b := wrap(memory.New())
+			now := time.Now().UTC()
+			for _, m := range []metastorage.MessageMetadata{
+				{ID: "c", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Minute)},
+				{ID: "a", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Hour)},
+				{ID: "b", State: metastorage.StateDeferred, NextRetry: now.Add(-time.Minute)},
+				{ID: "later", State: metastorage.StateDeferred, NextRetry: now.Add(time.Hour)},
+				{ID: "incoming", State: metastorage.StateIncoming},
+			} {
+				if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			due, err := metastorage.GetDueMessages(ctx, b, now, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(due); !slices.Equal(got, []string{"a", "b"}) {
+				t.Errorf("due with limit 2: %v, want [a b]", got)
+			}
+
+			iter, err := metastorage.NewDueIterator(ctx, b, now, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer iter.Close()
+			var all []metastorage.MessageMetadata
+			for {
+				m, more, err := iter.Next(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !more {
+					break
+				}
+				all = append(all, m)
+			}
+			if got := ids(all); !slices.Equal(got, []string{"a", "b", "c"}) {
+				t.Errorf("due iterator: %v, want [a b c]", got)
+			}
+		})
+	}
+}
+
+func ids(ms []metastorage.MessageMetadata) []string {
+	var ids []string
+	for _, m := range ms {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
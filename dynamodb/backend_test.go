@@ -0,0 +1,59 @@
+package dynamodb
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// TestMoveRace runs against the server in META_TEST_DYNAMODB_DSN, e.g.
+// "dynamodb://meta-test?region=eu-central-1&endpoint=http://localhost:8000";
+// every subtest uses a namespace of its own.
+func TestMoveRace(t *testing.T) {
+	dsn := os.Getenv("META_TEST_DYNAMODB_DSN")
+	if dsn == "" {
+		t.Skip("META_TEST_DYNAMODB_DSN not set")
+	}
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := registry.Open(context.Background(), dsn, options.WithNamespace(metatest.Namespace()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestItemAttributes(t *testing.T) {
+	it := item{
+		PK: "ns#m1", ID: "m1", StateKey: "ns#2", State: 2, Attempts: 3, MaxAttempts: 5,
+		NextRetry: 1767322245000000000, Created: 1, Updated: 2, Size: 1 << 40, Priority: -1,
+		Headers: `{"rcpt":"a@example.com"}`, Sequence: 1<<63 + 1, StateEnteredAt: 3,
+	}
+	av := it.attributes()
+	if _, ok := av["last_error"]; ok {
+		t.Error("empty last_error stored")
+	}
+	got, err := itemFrom(av)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != it {
+		t.Fatalf("round trip = %+v, want %+v", got, it)
+	}
+
+	// projected items only carry some attributes
+	got, err = itemFrom(map[string]types.AttributeValue{"id": av["id"], "priority": av["priority"]})
+	if err != nil || got.ID != "m1" || got.Priority != -1 || got.State != 0 {
+		t.Fatalf("projection = %+v, %v", got, err)
+	}
+	if _, err := itemFrom(map[string]types.AttributeValue{"size": &types.AttributeValueMemberN{Value: "x"}}); err == nil {
+		t.Fatal("invalid number accepted")
+	}
+}
@@ -0,0 +1,668 @@
+// Package dynamodb implements metastorage.Backend on Amazon DynamoDB with
+// the AWS SDK for Go v2, for serverless deployments without a database
+// server to operate.
+//
+// Messages are items of one table with the partition key "pk", the
+// message ID prefixed with the namespace and '#', so several namespaces
+// can share the table. The global secondary index StateIndex, partitioned
+// by namespace and state and sorted by creation time, serves
+// ListMessages, iterators and state counts. MoveToState and UpdateMeta are
+// single writes conditional on the current state, so concurrent moves have
+// exactly one winner and updates never overwrite a move.
+//
+// Timestamps are stored as unix nanoseconds; headers and the delivery
+// window as JSON strings. Reads through the index are eventually
+// consistent: a message moved or stored moments ago may be missing from,
+// or still be listed in, its previous state.
+//
+// Importing the package registers the "dynamodb://table" DSN scheme with
+// the optional query parameters "region" and "endpoint", e.g. for DynamoDB
+// Local. Credentials come from the default AWS credential chain.
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awsdynamodb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// DefaultTable is the name of the messages table
+const DefaultTable = "retryspool_meta"
+
+// StateIndex is the global secondary index on state and creation time
+const StateIndex = "state-created"
+
+// createTimeout bounds waiting for a created table to become active
+const createTimeout = 5 * time.Minute
+
+type (
+	tableKey    struct{}
+	regionKey   struct{}
+	endpointKey struct{}
+)
+
+// WithTable sets the name of the table (default DefaultTable)
+func WithTable(name string) options.Option {
+	return options.WithValue(tableKey{}, name)
+}
+
+// WithRegion sets the AWS region of Open, overriding the default
+// configuration
+func WithRegion(region string) options.Option {
+	return options.WithValue(regionKey{}, region)
+}
+
+// WithEndpoint sets the endpoint URL of Open, e.g. http://localhost:8000
+// for DynamoDB Local
+func WithEndpoint(endpoint string) options.Option {
+	return options.WithValue(endpointKey{}, endpoint)
+}
+
+func init() {
+	registry.Register("dynamodb", open)
+}
+
+// open handles "dynamodb://table?region=eu-central-1" DSNs
+func open(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	q := dsn.Query()
+	if dsn.Host != "" {
+		opts = append(opts, WithTable(dsn.Host))
+	}
+	if region := q.Get("region"); region != "" {
+		opts = append(opts, WithRegion(region))
+	}
+	if endpoint := q.Get("endpoint"); endpoint != "" {
+		opts = append(opts, WithEndpoint(endpoint))
+	}
+	return Open(ctx, opts...)
+}
+
+// item is the stored form of a message, see attributes for the
+// attribute names
+type item struct {
+	PK             string
+	ID             string
+	StateKey       string // partition key of StateIndex
+	State          int
+	Attempts       int
+	MaxAttempts    int
+	NextRetry      int64
+	Created        int64 // sort key of StateIndex
+	Updated        int64
+	LastError      string
+	Size           int64
+	Priority       int
+	Headers        string
+	RetryPolicy    string
+	Sequence       uint64
+	StateEnteredAt int64
+	DeliveryWindow string
+}
+
+// attributes returns the attribute map of it. Empty optional strings are
+// left out.
+func (it item) attributes() map[string]types.AttributeValue {
+	av := map[string]types.AttributeValue{
+		"pk":               &types.AttributeValueMemberS{Value: it.PK},
+		"id":               &types.AttributeValueMemberS{Value: it.ID},
+		"state_key":        &types.AttributeValueMemberS{Value: it.StateKey},
+		"state":            numberValue(it.State),
+		"attempts":         numberValue(it.Attempts),
+		"max_attempts":     numberValue(it.MaxAttempts),
+		"next_retry":       int64Value(it.NextRetry),
+		"created":          int64Value(it.Created),
+		"updated":          int64Value(it.Updated),
+		"size":             int64Value(it.Size),
+		"priority":         numberValue(it.Priority),
+		"sequence":         &types.AttributeValueMemberN{Value: strconv.FormatUint(it.Sequence, 10)},
+		"state_entered_at": int64Value(it.StateEnteredAt),
+	}
+	for name, v := range map[string]string{
+		"last_error":      it.LastError,
+		"headers":         it.Headers,
+		"retry_policy":    it.RetryPolicy,
+		"delivery_window": it.DeliveryWindow,
+	} {
+		if v != "" {
+			av[name] = &types.AttributeValueMemberS{Value: v}
+		}
+	}
+	return av
+}
+
+// itemFrom decodes an attribute map. Missing attributes, e.g. those not
+// projected by a query, keep their zero value.
+func itemFrom(av map[string]types.AttributeValue) (item, error) {
+	var it item
+	var err error
+	str := func(name string, dst *string) {
+		if v, ok := av[name].(*types.AttributeValueMemberS); ok {
+			*dst = v.Value
+		}
+	}
+	num := func(name string, dst *int64) {
+		v, ok := av[name].(*types.AttributeValueMemberN)
+		if !ok || err != nil {
+			return
+		}
+		if *dst, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			err = fmt.Errorf("dynamodb: attribute %s: %w", name, err)
+		}
+	}
+	small := func(name string, dst *int) {
+		var n int64
+		num(name, &n)
+		*dst = int(n)
+	}
+	str("pk", &it.PK)
+	str("id", &it.ID)
+	str("state_key", &it.StateKey)
+	small("state", &it.State)
+	small("attempts", &it.Attempts)
+	small("max_attempts", &it.MaxAttempts)
+	num("next_retry", &it.NextRetry)
+	num("created", &it.Created)
+	num("updated", &it.Updated)
+	str("last_error", &it.LastError)
+	num("size", &it.Size)
+	small("priority", &it.Priority)
+	str("headers", &it.Headers)
+	str("retry_policy", &it.RetryPolicy)
+	if v, ok := av["sequence"].(*types.AttributeValueMemberN); ok && err == nil {
+		if it.Sequence, err = strconv.ParseUint(v.Value, 10, 64); err != nil {
+			err = fmt.Errorf("dynamodb: attribute sequence: %w", err)
+		}
+	}
+	num("state_entered_at", &it.StateEnteredAt)
+	str("delivery_window", &it.DeliveryWindow)
+	return it, err
+}
+
+// unixNano maps the zero time to 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano maps 0 to the zero time
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func (b *Backend) toItem(id string, m metastorage.MessageMetadata) (item, error) {
+	it := item{
+		PK:             b.pk(id),
+		ID:             id,
+		StateKey:       b.stateKey(m.State),
+		State:          int(m.State),
+		Attempts:       m.Attempts,
+		MaxAttempts:    m.MaxAttempts,
+		NextRetry:      unixNano(m.NextRetry),
+		Created:        unixNano(m.Created),
+		Updated:        unixNano(m.Updated),
+		LastError:      m.LastError,
+		Size:           m.Size,
+		Priority:       m.Priority,
+		RetryPolicy:    m.RetryPolicyName,
+		Sequence:       m.Sequence,
+		StateEnteredAt: unixNano(m.StateEnteredAt),
+	}
+	if m.Headers != nil {
+		data, err := json.Marshal(m.Headers)
+		if err != nil {
+			return it, err
+		}
+		it.Headers = string(data)
+	}
+	if !m.DeliveryWindow.IsZero() {
+		data, err := json.Marshal(m.DeliveryWindow)
+		if err != nil {
+			return it, err
+		}
+		it.DeliveryWindow = string(data)
+	}
+	return it, nil
+}
+
+func (it *item) metadata() (metastorage.MessageMetadata, error) {
+	m := metastorage.MessageMetadata{
+		ID:              it.ID,
+		State:           metastorage.QueueState(it.State),
+		Attempts:        it.Attempts,
+		MaxAttempts:     it.MaxAttempts,
+		NextRetry:       fromUnixNano(it.NextRetry),
+		Created:         fromUnixNano(it.Created),
+		Updated:         fromUnixNano(it.Updated),
+		LastError:       it.LastError,
+		Size:            it.Size,
+		Priority:        it.Priority,
+		RetryPolicyName: it.RetryPolicy,
+		Sequence:        it.Sequence,
+		StateEnteredAt:  fromUnixNano(it.StateEnteredAt),
+	}
+	if it.Headers != "" {
+		if err := json.Unmarshal([]byte(it.Headers), &m.Headers); err != nil {
+			return m, fmt.Errorf("dynamodb: %s: headers: %w", m.ID, err)
+		}
+	}
+	if it.DeliveryWindow != "" {
+		if err := json.Unmarshal([]byte(it.DeliveryWindow), &m.DeliveryWindow); err != nil {
+			return m, fmt.Errorf("dynamodb: %s: delivery window: %w", m.ID, err)
+		}
+	}
+	return m, nil
+}
+
+// Backend stores message metadata in a DynamoDB table
+type Backend struct {
+	client    *awsdynamodb.Client
+	table     string
+	namespace string
+	batchSize int
+	closed    atomic.Bool
+}
+
+// Open creates a client from the default AWS configuration, adjusted by
+// WithRegion and WithEndpoint, and creates the table if needed
+func Open(ctx context.Context, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	var loadOpts []func(*config.LoadOptions) error
+	if region, ok := options.Value[string](o, regionKey{}); ok {
+		loadOpts = append(loadOpts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("dynamodb: %w", err)
+	}
+	client := awsdynamodb.NewFromConfig(cfg, func(c *awsdynamodb.Options) {
+		if endpoint, ok := options.Value[string](o, endpointKey{}); ok {
+			c.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return New(ctx, client, opts...)
+}
+
+// New creates a backend on client, creating the table with on-demand
+// capacity if it does not exist. With a namespace, items are keyed with
+// it, so several backends can share the table.
+func New(ctx context.Context, client *awsdynamodb.Client, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	if strings.Contains(o.Namespace, "#") {
+		return nil, fmt.Errorf("dynamodb: namespace %q must not contain '#'", o.Namespace)
+	}
+	b := &Backend{
+		client:    client,
+		table:     options.ValueOr(o, tableKey{}, DefaultTable),
+		namespace: o.Namespace,
+		batchSize: o.BatchSize,
+	}
+	if err := b.createTable(ctx); err != nil {
+		return nil, fmt.Errorf("dynamodb: create table: %w", err)
+	}
+	return b, nil
+}
+
+func (b *Backend) createTable(ctx context.Context) error {
+	_, err := b.client.DescribeTable(ctx, &awsdynamodb.DescribeTableInput{TableName: aws.String(b.table)})
+	var notFound *types.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return err
+	}
+	_, err = b.client.CreateTable(ctx, &awsdynamodb.CreateTableInput{
+		TableName:   aws.String(b.table),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("state_key"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("created"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: types.KeyTypeHash},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(StateIndex),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String("state_key"), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String("created"), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+	})
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) { // inUse: created concurrently
+		return err
+	}
+	return awsdynamodb.NewTableExistsWaiter(b.client).Wait(ctx,
+		&awsdynamodb.DescribeTableInput{TableName: aws.String(b.table)}, createTimeout)
+}
+
+// pk returns the partition key of id
+func (b *Backend) pk(id string) string {
+	return b.namespace + "#" + id
+}
+
+// stateKey returns the StateIndex partition key of state
+func (b *Backend) stateKey(state metastorage.QueueState) string {
+	return b.namespace + "#" + strconv.Itoa(int(state))
+}
+
+func (b *Backend) key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: b.pk(id)}}
+}
+
+func (b *Backend) check() error {
+	if b.closed.Load() {
+		return metastorage.ErrBackendClosed
+	}
+	return nil
+}
+
+func numberValue(n int) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+}
+
+func int64Value(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// conflictOrNotFound explains a failed condition from the item returned
+// with it
+func conflictOrNotFound(err error, id string, expected metastorage.QueueState) error {
+	var failed *types.ConditionalCheckFailedException
+	if !errors.As(err, &failed) {
+		return err
+	}
+	if len(failed.Item) == 0 {
+		return metastorage.ErrMessageNotFound
+	}
+	current, err := itemFrom(failed.Item)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s is %s, expected %s", metastorage.ErrStateConflict, id, metastorage.QueueState(current.State), expected)
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata.Version = 0 // versions are not tracked
+	if err := b.check(); err != nil {
+		return err
+	}
+	it, err := b.toItem(messageID, metadata)
+	if err != nil {
+		return err
+	}
+	av := it.attributes()
+	_, err = b.client.PutItem(ctx, &awsdynamodb.PutItemInput{TableName: aws.String(b.table), Item: av})
+	return err
+}
+
+// GetMeta retrieves message metadata with a strongly consistent read
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := b.check(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	out, err := b.client.GetItem(ctx, &awsdynamodb.GetItemInput{
+		TableName:      aws.String(b.table),
+		Key:            b.key(messageID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	if len(out.Item) == 0 {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	it, err := itemFrom(out.Item)
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	return it.metadata()
+}
+
+// UpdateMeta replaces the metadata of an existing message with a write
+// conditional on its state. The state is only changed by MoveToState: an
+// update carrying a different state fails with ErrStateConflict, as the
+// caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := metastorage.RejectVersion(messageID, metadata.Version); err != nil {
+		return err
+	}
+	if err := b.check(); err != nil {
+		return err
+	}
+	it, err := b.toItem(messageID, metadata)
+	if err != nil {
+		return err
+	}
+	av := it.attributes()
+	_, err = b.client.PutItem(ctx, &awsdynamodb.PutItemInput{
+		TableName:                           aws.String(b.table),
+		Item:                                av,
+		ConditionExpression:                 aws.String("attribute_exists(pk) AND #state = :state"),
+		ExpressionAttributeNames:            map[string]string{"#state": "state"},
+		ExpressionAttributeValues:           map[string]types.AttributeValue{":state": numberValue(int(metadata.State))},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	return conflictOrNotFound(err, messageID, metadata.State)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	_, err := b.client.DeleteItem(ctx, &awsdynamodb.DeleteItemInput{
+		TableName:           aws.String(b.table),
+		Key:                 b.key(messageID),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return metastorage.ErrMessageNotFound
+	}
+	return err
+}
+
+// MoveToState changes the state with one UpdateItem conditional on
+// fromState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	_, err := b.client.UpdateItem(ctx, &awsdynamodb.UpdateItemInput{
+		TableName:                aws.String(b.table),
+		Key:                      b.key(messageID),
+		UpdateExpression:         aws.String("SET #state = :to, state_key = :key"),
+		ConditionExpression:      aws.String("attribute_exists(pk) AND #state = :from"),
+		ExpressionAttributeNames: map[string]string{"#state": "state"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":to":   numberValue(int(toState)),
+			":from": numberValue(int(fromState)),
+			":key":  &types.AttributeValueMemberS{Value: b.stateKey(toState)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	return conflictOrNotFound(err, messageID, fromState)
+}
+
+// queryState returns the query of the StateIndex partition of state
+func (b *Backend) queryState(state metastorage.QueueState) *awsdynamodb.QueryInput {
+	return &awsdynamodb.QueryInput{
+		TableName:                 aws.String(b.table),
+		IndexName:                 aws.String(StateIndex),
+		KeyConditionExpression:    aws.String("state_key = :key"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":key": &types.AttributeValueMemberS{Value: b.stateKey(state)}},
+	}
+}
+
+// GetStateCount counts the messages in state with the state index, -1 on
+// errors
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	if b.check() != nil {
+		return -1
+	}
+	in := b.queryState(state)
+	in.Select = types.SelectCount
+	var n int64
+	pages := awsdynamodb.NewQueryPaginator(b.client, in)
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(context.Background())
+		if err != nil {
+			return -1
+		}
+		n += int64(out.Count)
+	}
+	return n
+}
+
+// ListMessages lists the IDs of messages in state with
+// metastorage.ListPage. The sort fields of all messages of the state are
+// read from the state index, as DynamoDB cannot skip to an offset.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := b.check(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	in := b.queryState(state)
+	// placeholders, as several attribute names are reserved words
+	in.ProjectionExpression = aws.String("#id, #created, #updated, #priority, #attempts")
+	in.ExpressionAttributeNames = map[string]string{
+		"#id": "id", "#created": "created", "#updated": "updated", "#priority": "priority", "#attempts": "attempts",
+	}
+	var ms []metastorage.MessageMetadata
+	pages := awsdynamodb.NewQueryPaginator(b.client, in)
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return metastorage.MessageListResult{}, err
+		}
+		for _, av := range out.Items {
+			it, err := itemFrom(av)
+			if err != nil {
+				return metastorage.MessageListResult{}, err
+			}
+			ms = append(ms, metastorage.MessageMetadata{
+				ID:       it.ID,
+				Created:  fromUnixNano(it.Created),
+				Updated:  fromUnixNano(it.Updated),
+				Priority: it.Priority,
+				Attempts: it.Attempts,
+			})
+		}
+	}
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the messages in state,
+// ordered by creation time. Each batch is a query of the state index
+// continuing after the last returned item, so changes between batches are
+// visible.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &iterator{backend: b, state: state, batchSize: max(batchSize, 1)}, nil
+}
+
+// Close marks the backend closed; the client holds no connections that
+// need closing
+func (b *Backend) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	batchSize int
+	after     map[string]types.AttributeValue // LastEvaluatedKey of the previous batch
+	batch     []metastorage.MessageMetadata
+	done      bool
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	// DynamoDB may return an empty page with more to follow
+	for len(it.batch) == 0 && !it.done {
+		if err := it.fetch(ctx); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	if len(it.batch) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+func (it *iterator) fetch(ctx context.Context) error {
+	if err := it.backend.check(); err != nil {
+		return err
+	}
+	in := it.backend.queryState(it.state)
+	in.Limit = aws.Int32(int32(it.batchSize))
+	in.ExclusiveStartKey = it.after
+	out, err := it.backend.client.Query(ctx, in)
+	if err != nil {
+		return err
+	}
+	for _, av := range out.Items {
+		i, err := itemFrom(av)
+		if err != nil {
+			return err
+		}
+		m, err := i.metadata()
+		if err != nil {
+			return err
+		}
+		it.batch = append(it.batch, m)
+	}
+	it.after = out.LastEvaluatedKey
+	if len(it.after) == 0 {
+		it.done = true
+	}
+	return nil
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *iterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = n
+	}
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.done = true
+	it.batch = nil
+	return nil
+}
@@ -0,0 +1,131 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EraseBackend is implemented by layers keeping traces of a message
+// beyond its metadata, such as delete markers, revision history or audit
+// records. EraseByHeader calls Erase after deleting the message.
+type EraseBackend interface {
+	Backend
+
+	// Erase removes what the layer still keeps about the deleted message.
+	// It must succeed if nothing is left.
+	Erase(ctx context.Context, messageID string, opts EraseOptions) error
+}
+
+// EraseOptions controls EraseByHeader
+type EraseOptions struct {
+	States    []QueueState // States searched, default all
+	BatchSize int          // Iterator batch size, default 500
+	// OverridePins erases pinned messages too, e.g. when the erasure
+	// request outranks the reason of the pin. By default they are skipped.
+	OverridePins bool
+}
+
+// ErasureReport is the result of EraseByHeader
+type ErasureReport struct {
+	Scanned int      // Messages read
+	Erased  []string // IDs of the erased messages
+	Pinned  []string // IDs of matching pinned messages that were skipped
+	Layers  int      // EraseBackend layers of the backend
+}
+
+// EraseByHeader hard-deletes every message whose header key (matched
+// case-insensitively) has exactly the given value, e.g. all messages to
+// one address for a GDPR erasure request. The matching messages of all
+// states are collected first and then deleted, and every layer of b
+// implementing EraseBackend erases its remaining traces of them.
+//
+// Pinned messages are skipped and listed in the report, unless
+// opts.OverridePins is set. Messages deleted meanwhile are erased from the
+// layers all the same. Erasure stops at the first failure; the report
+// lists the messages erased until then, so a retry continues with the
+// rest.
+func EraseByHeader(ctx context.Context, b Backend, key, value string, opts EraseOptions) (ErasureReport, error) {
+	var report ErasureReport
+	if key == "" || value == "" {
+		return report, errors.New("erase: header key and value are required")
+	}
+	if len(opts.States) == 0 {
+		opts.States = States()
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	var layers []EraseBackend
+	for l := b; l != nil; l = Unwrap(l) {
+		if e, ok := l.(EraseBackend); ok {
+			layers = append(layers, e)
+		}
+	}
+	report.Layers = len(layers)
+
+	var ids []string
+	for _, state := range opts.States {
+		matched, scanned, err := matchHeader(ctx, b, state, key, value, opts.BatchSize)
+		report.Scanned += scanned
+		if err != nil {
+			return report, fmt.Errorf("erase: scan %s: %w", state, err)
+		}
+		ids = append(ids, matched...)
+	}
+
+	for _, id := range ids {
+		var err error
+		if opts.OverridePins {
+			err = b.DeleteMeta(WithPinOverride(ctx), id)
+		} else {
+			err = DeleteUnpinned(ctx, b, id)
+		}
+		if errors.Is(err, ErrPinned) {
+			report.Pinned = append(report.Pinned, id)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrMessageNotFound) {
+			return report, fmt.Errorf("erase: delete %s: %w", id, err)
+		}
+		for _, l := range layers {
+			if err := l.Erase(ctx, id, opts); err != nil {
+				return report, fmt.Errorf("erase: %s: %w", id, err)
+			}
+		}
+		report.Erased = append(report.Erased, id)
+	}
+	return report, nil
+}
+
+// matchHeader returns the IDs of the messages in state whose header key
+// has the value, and the number of messages read
+func matchHeader(ctx context.Context, b Backend, state QueueState, key, value string, batchSize int) ([]string, int, error) {
+	iter, err := b.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer iter.Close()
+	var (
+		ids     []string
+		scanned int
+	)
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return ids, scanned, err
+		}
+		if !more {
+			return ids, scanned, nil
+		}
+		scanned++
+		for k, v := range m.Headers {
+			if v == value && strings.EqualFold(k, key) {
+				ids = append(ids, m.ID)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package metastorage_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// eraser records the IDs it is asked to erase
+type eraser struct {
+	*memory.Backend
+	erased []string
+}
+
+func (e *eraser) Erase(_ context.Context, id string, _ metastorage.EraseOptions) error {
+	e.erased = append(e.erased, id)
+	return nil
+}
+
+func TestEraseByHeader(t *testing.T) {
+	ctx := context.Background()
+	b := &eraser{Backend: memory.New()}
+	messages := []metastorage.MessageMetadata{
+		{ID: "m1", State: metastorage.StateIncoming, Headers: map[string]string{"To": "alice@example.com"}},
+		{ID: "m2", State: metastorage.StateDeferred, Headers: map[string]string{"to": "alice@example.com"}},
+		{ID: "m3", State: metastorage.StateDeferred, Headers: map[string]string{"to": "bob@example.com"}},
+		{ID: "m4", State: metastorage.StateBounce, Headers: map[string]string{"from": "alice@example.com"}},
+		{ID: "m5", State: metastorage.StateBounce, Headers: map[string]string{"to": "alice@example.com", metastorage.HeaderPinned: "legal hold"}},
+	}
+	for _, m := range messages {
+		if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := metastorage.EraseByHeader(ctx, b, "to", "alice@example.com", metastorage.EraseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.Erased)
+	if !slices.Equal(report.Erased, []string{"m1", "m2"}) || report.Scanned != 5 || report.Layers != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	if !slices.Equal(report.Pinned, []string{"m5"}) {
+		t.Fatalf("pinned %v, want m5 skipped", report.Pinned)
+	}
+	slices.Sort(b.erased)
+	if !slices.Equal(b.erased, []string{"m1", "m2"}) {
+		t.Fatalf("layer erased %v", b.erased)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if _, err := b.GetMeta(ctx, id); !errors.Is(err, metastorage.ErrMessageNotFound) {
+			t.Fatalf("%s: %v, want not found", id, err)
+		}
+	}
+	for _, id := range []string{"m3", "m4", "m5"} {
+		if _, err := b.GetMeta(ctx, id); err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+	}
+
+	report, err = metastorage.EraseByHeader(ctx, b, "to", "alice@example.com", metastorage.EraseOptions{OverridePins: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Erased, []string{"m5"}) || len(report.Pinned) != 0 {
+		t.Fatalf("report with override %+v, want m5 erased", report)
+	}
+}
@@ -0,0 +1,76 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// event converts a change of the record of id, reporting false for
+// records that cannot be decoded
+func (b *Backend) event(id string, ev *clientv3.Event) (metastorage.Event, bool) {
+	e := metastorage.Event{MessageID: id, Time: time.Now().UTC()}
+	if ev.Type == clientv3.EventTypeDelete {
+		e.Type = metastorage.EventDeleted
+		return e, true
+	}
+	m, err := b.decode(id, ev.Kv.Value)
+	if err != nil {
+		return e, false
+	}
+	e.State = m.State
+	if ev.IsCreate() || ev.PrevKv == nil {
+		e.Type = metastorage.EventStored
+		return e, true
+	}
+	prev, err := b.decode(id, ev.PrevKv.Value)
+	if err == nil && prev.State != m.State {
+		e.Type = metastorage.EventMoved
+		e.From = prev.State
+		return e, true
+	}
+	e.Type = metastorage.EventUpdated
+	return e, true
+}
+
+// Watch delivers events for changes of the records from an etcd watch,
+// including changes made by other clients. StoreMeta of a new message is
+// reported as EventStored; StoreMeta replacing a message as EventUpdated
+// or, if its state changed, EventMoved. The channel is closed when ctx is
+// done or the watch fails, e.g. because the cluster lost its leader or
+// compacted revisions the watch still needed. Event times are the times
+// the events were received.
+func (b *Backend) Watch(ctx context.Context) (<-chan metastorage.Event, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	prefix := b.prefix + "m/"
+	changes := b.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithPrevKV())
+	events := make(chan metastorage.Event)
+	go func() {
+		defer close(events)
+		defer cancel()
+		for resp := range changes {
+			if resp.Err() != nil || resp.Canceled {
+				return
+			}
+			for _, ev := range resp.Events {
+				e, ok := b.event(strings.TrimPrefix(string(ev.Kv.Key), prefix), ev)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,29 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+)
+
+// ExistsBackend is implemented by layers that answer existence checks
+// cheaper than GetMeta, e.g. from a filter of known IDs
+type ExistsBackend interface {
+	Backend
+
+	// Exists reports whether the message is stored
+	Exists(ctx context.Context, messageID string) (bool, error)
+}
+
+// Exists reports whether messageID is stored in b, e.g. to drop
+// duplicates at ingest. Layers implementing ExistsBackend answer it;
+// otherwise it is derived from GetMeta.
+func Exists(ctx context.Context, b Backend, messageID string) (bool, error) {
+	if e, ok := As[ExistsBackend](b); ok {
+		return e.Exists(ctx, messageID)
+	}
+	_, err := b.GetMeta(ctx, messageID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// HeaderAction decides what happens to a header during anonymization
+type HeaderAction int
+
+const (
+	Keep       HeaderAction = iota // Keep the value unchanged
+	Drop                           // Remove the header
+	Hash                           // Replace the value with a keyed hash
+	Redact                         // Replace the value with "REDACTED"
+	ScrubEmail                     // Hash the local part of e-mail addresses, keep the domain
+)
+
+// Anonymizer removes personal data from exported records so real queue
+// dumps can be shared with vendors or attached to bug reports. Hashes are
+// keyed HMAC-SHA256 values: equal inputs map to equal outputs within one
+// export (relationships stay visible) but cannot be reversed or
+// correlated across exports made with different keys.
+type Anonymizer struct {
+	Key           []byte                  // HMAC key; a random key is generated if empty
+	HashIDs       bool                    // Replace message IDs
+	Headers       map[string]HeaderAction // Per header policy (case-insensitive keys)
+	DefaultAction HeaderAction            // Policy for headers not listed
+	ScrubErrors   bool                    // Scrub e-mail addresses in LastError
+}
+
+// DefaultAnonymizer keeps recipient domains and technical headers but
+// removes everything that identifies people or content
+func DefaultAnonymizer() *Anonymizer {
+	return &Anonymizer{
+		HashIDs: true,
+		Headers: map[string]HeaderAction{
+			"to":         ScrubEmail,
+			"from":       ScrubEmail,
+			"cc":         ScrubEmail,
+			"reply-to":   ScrubEmail,
+			"subject":    Drop,
+			"message-id": Hash,
+		},
+		DefaultAction: Hash,
+		ScrubErrors:   true,
+	}
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+
+// Transform returns the anonymization step for Options.Transforms
+func (a *Anonymizer) Transform() Transform {
+	key := a.Key
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic("export: generate anonymization key: " + err.Error())
+		}
+	}
+	policy := make(map[string]HeaderAction, len(a.Headers))
+	for k, v := range a.Headers {
+		policy[strings.ToLower(k)] = v
+	}
+
+	hash := func(s string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	scrub := func(s string) string {
+		return emailPattern.ReplaceAllStringFunc(s, func(addr string) string {
+			at := strings.LastIndexByte(addr, '@')
+			return hash(addr[:at]) + addr[at:]
+		})
+	}
+
+	return func(r Record) (Record, bool) {
+		m := r.Message
+		if a.HashIDs {
+			m.ID = hash(m.ID)
+		}
+		if a.ScrubErrors {
+			m.LastError = scrub(m.LastError)
+		}
+		if len(m.Headers) > 0 {
+			headers := make(map[string]string, len(m.Headers))
+			for k, v := range m.Headers {
+				action, ok := policy[strings.ToLower(k)]
+				if !ok {
+					action = a.DefaultAction
+					if strings.HasPrefix(k, metastorage.ReservedHeaderPrefix) {
+						action = Drop // pins and operator notes may contain anything
+					}
+				}
+				switch action {
+				case Keep:
+					headers[k] = v
+				case Hash:
+					headers[k] = hash(v)
+				case Redact:
+					headers[k] = "REDACTED"
+				case ScrubEmail:
+					headers[k] = scrub(v)
+				}
+			}
+			m.Headers = headers
+		}
+		r.Message = m
+		return r, true
+	}
+}
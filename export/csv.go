@@ -0,0 +1,93 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVColumns are the columns written by CSVEncoder. Headers and the
+// delivery window are embedded as JSON objects.
+var CSVColumns = []string{
+	"id", "state", "attempts", "max_attempts", "priority", "size",
+	"created", "updated", "next_retry", "last_error", "retry_policy",
+	"sequence", "headers", "delivery_window", "state_entered_at", "deleted",
+}
+
+// CSVEncoder writes records as CSV with a header row, for loading into
+// spreadsheets, notebooks and warehouses
+type CSVEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVEncoder creates a CSV encoder writing to w
+func NewCSVEncoder(w io.Writer) *CSVEncoder {
+	return &CSVEncoder{w: csv.NewWriter(w)}
+}
+
+// Encode writes r as one row
+func (e *CSVEncoder) Encode(r Record) error {
+	if !e.wroteHeader {
+		if err := e.w.Write(CSVColumns); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	m := r.Message
+	headers := ""
+	if len(m.Headers) > 0 {
+		data, err := json.Marshal(m.Headers)
+		if err != nil {
+			return err
+		}
+		headers = string(data)
+	}
+	window := ""
+	if !m.DeliveryWindow.IsZero() {
+		data, err := json.Marshal(m.DeliveryWindow)
+		if err != nil {
+			return err
+		}
+		window = string(data)
+	}
+	return e.w.Write([]string{
+		m.ID,
+		m.State.String(),
+		strconv.Itoa(m.Attempts),
+		strconv.Itoa(m.MaxAttempts),
+		strconv.Itoa(m.Priority),
+		strconv.FormatInt(m.Size, 10),
+		csvTime(m.Created),
+		csvTime(m.Updated),
+		csvTime(m.NextRetry),
+		m.LastError,
+		m.RetryPolicyName,
+		strconv.FormatUint(m.Sequence, 10),
+		headers,
+		window,
+		csvTime(m.StateEnteredAt),
+		strconv.FormatBool(r.Deleted),
+	})
+}
+
+// Close flushes buffered rows. An empty export still gets a header row.
+func (e *CSVEncoder) Close() error {
+	if !e.wroteHeader {
+		if err := e.w.Write(CSVColumns); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
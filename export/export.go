@@ -0,0 +1,95 @@
+// Package export writes the contents of a metadata backend to portable
+// dump files and reads them back.
+package export
+
+import (
+	"context"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Record is one exported message. Incremental exports also contain
+// tombstones (Deleted set, only Message.ID filled) for
+// messages removed since the previous export.
+type Record struct {
+	Message metastorage.MessageMetadata `json:"message"`
+	Deleted bool                        `json:"deleted,omitempty"`
+}
+
+// Encoder writes records in a specific file format
+type Encoder interface {
+	Encode(r Record) error
+
+	// Close flushes buffered output; it does not close the underlying writer
+	Close() error
+}
+
+// Decoder reads records written by the matching Encoder
+type Decoder interface {
+	// Decode returns the next record, or io.EOF at the end of the input
+	Decode() (Record, error)
+}
+
+// Transform rewrites a record before it is encoded. Returning false drops it.
+type Transform func(Record) (Record, bool)
+
+// Options controls an export
+type Options struct {
+	States     []metastorage.QueueState // States to export, default all
+	BatchSize  int                      // Iterator batch size, default 500
+	Transforms []Transform              // Applied in order to every record
+}
+
+// Stats summarizes an export
+type Stats struct {
+	Exported   int
+	Dropped    int
+	Unchanged  int // incremental exports: records skipped as unchanged
+	Tombstones int // incremental exports: deleted records
+	PerState   map[metastorage.QueueState]int
+}
+
+// Export streams all messages of the selected states to enc
+func Export(ctx context.Context, backend metastorage.Backend, enc Encoder, opts Options) (Stats, error) {
+	states := opts.States
+	if len(states) == 0 {
+		states = metastorage.States()
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	stats := Stats{PerState: make(map[metastorage.QueueState]int)}
+	for _, state := range states {
+		if err := exportState(ctx, backend, state, enc, opts, &stats); err != nil {
+			return stats, fmt.Errorf("export %s: %w", state, err)
+		}
+	}
+	return stats, enc.Close()
+}
+
+func exportState(ctx context.Context, backend metastorage.Backend, state metastorage.QueueState, enc Encoder, opts Options, stats *Stats) error {
+	return scan(ctx, backend, state, opts.BatchSize, func(m metastorage.MessageMetadata) error {
+		r, keep := apply(Record{Message: m}, opts.Transforms)
+		if !keep {
+			stats.Dropped++
+			return nil
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		stats.Exported++
+		stats.PerState[state]++
+		return nil
+	})
+}
+
+func apply(r Record, transforms []Transform) (Record, bool) {
+	for _, t := range transforms {
+		var keep bool
+		if r, keep = t(r); !keep {
+			return r, false
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,231 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// ErrConflict is reported for records that already exist under the Fail policy
+var ErrConflict = errors.New("message already exists")
+
+// ConflictPolicy decides how Import treats records whose ID already exists
+type ConflictPolicy int
+
+const (
+	// SkipExisting keeps the stored message and ignores the record
+	SkipExisting ConflictPolicy = iota
+	// Overwrite replaces the stored message (including its state)
+	Overwrite
+	// Merge fills fields missing in the stored message from the record;
+	// headers are combined with stored values winning, Attempts and
+	// Updated take the larger value, the stored state is kept
+	Merge
+	// Fail aborts the import at the first existing record
+	Fail
+)
+
+var policyNames = map[ConflictPolicy]string{
+	SkipExisting: "skip-existing",
+	Overwrite:    "overwrite",
+	Merge:        "merge",
+	Fail:         "fail",
+}
+
+func (p ConflictPolicy) String() string {
+	if name, ok := policyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// ParseConflictPolicy parses the String form of a policy
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	for p, n := range policyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q", name)
+}
+
+// ImportOptions controls an import
+type ImportOptions struct {
+	Policy          ConflictPolicy
+	ContinueOnError bool        // Record per-record errors and go on instead of stopping
+	Transforms      []Transform // Applied to every record before it is written
+}
+
+// RecordError describes a record that could not be imported
+type RecordError struct {
+	Index     int // Zero based position in the input
+	MessageID string
+	Err       error
+}
+
+func (e RecordError) Error() string {
+	return fmt.Sprintf("record %d (%s): %v", e.Index, e.MessageID, e.Err)
+}
+
+func (e RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReport summarizes an import
+type ImportReport struct {
+	Imported    int // new messages
+	Overwritten int
+	Merged      int
+	Skipped     int
+	Deleted     int // tombstones applied
+	Errors      []RecordError
+}
+
+// Import reads records from dec and writes them to backend according to
+// opts.Policy. Tombstones delete the referenced message. Import stops at
+// the first failing record unless ContinueOnError is set; the Fail policy
+// always stops at the first conflict. The returned error is the first
+// per-record error (or a decode error), the report lists all of them.
+func Import(ctx context.Context, backend metastorage.Backend, dec Decoder, opts ImportOptions) (ImportReport, error) {
+	var report ImportReport
+	var first error
+	for index := 0; ; index++ {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		r, err := dec.Decode()
+		if err == io.EOF {
+			return report, first
+		}
+		if err != nil {
+			return report, fmt.Errorf("decode record %d: %w", index, err)
+		}
+		r, keep := apply(r, opts.Transforms)
+		if !keep {
+			report.Skipped++
+			continue
+		}
+
+		if err := importRecord(ctx, backend, r, opts.Policy, &report); err != nil {
+			recErr := RecordError{Index: index, MessageID: r.Message.ID, Err: err}
+			report.Errors = append(report.Errors, recErr)
+			if first == nil {
+				first = recErr
+			}
+			if !opts.ContinueOnError || errors.Is(err, ErrConflict) {
+				return report, first
+			}
+		}
+	}
+}
+
+func importRecord(ctx context.Context, backend metastorage.Backend, r Record, policy ConflictPolicy, report *ImportReport) error {
+	id := r.Message.ID
+	if id == "" {
+		return errors.New("record has no message ID")
+	}
+	if r.Deleted {
+		err := backend.DeleteMeta(ctx, id)
+		switch {
+		case err == nil:
+			report.Deleted++
+		case errors.Is(err, metastorage.ErrMessageNotFound):
+			report.Skipped++
+		default:
+			return err
+		}
+		return nil
+	}
+
+	existing, err := backend.GetMeta(ctx, id)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		if err := backend.StoreMeta(ctx, id, r.Message); err != nil {
+			return err
+		}
+		report.Imported++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch policy {
+	case SkipExisting:
+		report.Skipped++
+		return nil
+	case Fail:
+		return ErrConflict
+	case Overwrite:
+		if err := replace(ctx, backend, existing, r.Message); err != nil {
+			return err
+		}
+		report.Overwritten++
+		return nil
+	case Merge:
+		if err := backend.UpdateMeta(ctx, id, merge(existing, r.Message)); err != nil {
+			return err
+		}
+		report.Merged++
+		return nil
+	}
+	return fmt.Errorf("unsupported conflict policy %s", policy)
+}
+
+// replace overwrites existing with m, moving it first if the state differs
+func replace(ctx context.Context, backend metastorage.Backend, existing, m metastorage.MessageMetadata) error {
+	if existing.State != m.State {
+		if err := backend.MoveToState(ctx, m.ID, existing.State, m.State); err != nil {
+			return fmt.Errorf("move to %s: %w", m.State, err)
+		}
+	}
+	return backend.UpdateMeta(ctx, m.ID, m)
+}
+
+// merge fills the gaps of existing with values from imported
+func merge(existing, imported metastorage.MessageMetadata) metastorage.MessageMetadata {
+	m := existing
+	if imported.Attempts > m.Attempts {
+		m.Attempts = imported.Attempts
+	}
+	if m.MaxAttempts == 0 {
+		m.MaxAttempts = imported.MaxAttempts
+	}
+	if m.NextRetry.IsZero() {
+		m.NextRetry = imported.NextRetry
+	}
+	if m.Created.IsZero() {
+		m.Created = imported.Created
+	}
+	if imported.Updated.After(m.Updated) {
+		m.Updated = imported.Updated
+	}
+	if m.LastError == "" {
+		m.LastError = imported.LastError
+	}
+	if m.Size == 0 {
+		m.Size = imported.Size
+	}
+	if m.RetryPolicyName == "" {
+		m.RetryPolicyName = imported.RetryPolicyName
+	}
+	if m.DeliveryWindow.IsZero() {
+		m.DeliveryWindow = imported.DeliveryWindow
+	}
+	if m.StateEnteredAt.IsZero() && m.State == imported.State {
+		m.StateEnteredAt = imported.StateEnteredAt
+	}
+	if len(imported.Headers) > 0 {
+		headers := make(map[string]string, len(m.Headers)+len(imported.Headers))
+		for k, v := range imported.Headers {
+			headers[k] = v
+		}
+		for k, v := range m.Headers {
+			headers[k] = v
+		}
+		m.Headers = headers
+	}
+	return m
+}
@@ -0,0 +1,46 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// JSONLEncoder writes one JSON object per line
+type JSONLEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSONLEncoder creates a JSON Lines encoder writing to w
+func NewJSONLEncoder(w io.Writer) *JSONLEncoder {
+	bw := bufio.NewWriter(w)
+	return &JSONLEncoder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+// Encode writes r as one line
+func (e *JSONLEncoder) Encode(r Record) error {
+	return e.enc.Encode(r)
+}
+
+// Close flushes buffered output
+func (e *JSONLEncoder) Close() error {
+	return e.w.Flush()
+}
+
+// JSONLDecoder reads files written by JSONLEncoder
+type JSONLDecoder struct {
+	dec *json.Decoder
+}
+
+// NewJSONLDecoder creates a decoder reading from r
+func NewJSONLDecoder(r io.Reader) *JSONLDecoder {
+	return &JSONLDecoder{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+// Decode returns the next record or io.EOF
+func (d *JSONLDecoder) Decode() (Record, error) {
+	var r Record
+	err := d.dec.Decode(&r)
+	return r, err
+}
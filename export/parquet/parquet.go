@@ -0,0 +1,91 @@
+// Package parquet writes export records as Parquet files, the columnar
+// format most analytics engines load directly. It is a module of its own,
+// so importers of the export package do not pull in the Parquet library.
+package parquet
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	pq "github.com/parquet-go/parquet-go"
+
+	"schneider.vip/retryspool/storage/meta/export"
+)
+
+// parquetRow is the column layout written by Encoder. Timestamps
+// are stored as UTC microseconds; unset times are null.
+type parquetRow struct {
+	ID             string            `parquet:"id,dict"`
+	State          string            `parquet:"state,dict"`
+	Attempts       int64             `parquet:"attempts"`
+	MaxAttempts    int64             `parquet:"max_attempts"`
+	Priority       int64             `parquet:"priority"`
+	Size           int64             `parquet:"size"`
+	Created        *time.Time        `parquet:"created,optional,timestamp(microsecond)"`
+	Updated        *time.Time        `parquet:"updated,optional,timestamp(microsecond)"`
+	NextRetry      *time.Time        `parquet:"next_retry,optional,timestamp(microsecond)"`
+	LastError      string            `parquet:"last_error,optional"`
+	RetryPolicy    string            `parquet:"retry_policy,optional,dict"`
+	Sequence       uint64            `parquet:"sequence"`
+	Headers        map[string]string `parquet:"headers"`
+	DeliveryWindow string            `parquet:"delivery_window,optional"`
+	StateEnteredAt *time.Time        `parquet:"state_entered_at,optional,timestamp(microsecond)"`
+	Deleted        bool              `parquet:"deleted"`
+}
+
+// Encoder writes records as a Parquet file. The file is only complete
+// after Close.
+type Encoder struct {
+	w *pq.GenericWriter[parquetRow]
+}
+
+// NewEncoder creates a Parquet encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: pq.NewGenericWriter[parquetRow](w)}
+}
+
+// Encode buffers r as one row
+func (e *Encoder) Encode(r export.Record) error {
+	m := r.Message
+	row := parquetRow{
+		ID:          m.ID,
+		State:       m.State.String(),
+		Attempts:    int64(m.Attempts),
+		MaxAttempts: int64(m.MaxAttempts),
+		Priority:    int64(m.Priority),
+		Size:        m.Size,
+		Created:     parquetTime(m.Created),
+		Updated:     parquetTime(m.Updated),
+		NextRetry:   parquetTime(m.NextRetry),
+		LastError:   m.LastError,
+		RetryPolicy: m.RetryPolicyName,
+		Sequence:    m.Sequence,
+		Headers:     m.Headers,
+		Deleted:     r.Deleted,
+
+		StateEnteredAt: parquetTime(m.StateEnteredAt),
+	}
+	if !m.DeliveryWindow.IsZero() {
+		data, err := json.Marshal(m.DeliveryWindow)
+		if err != nil {
+			return err
+		}
+		row.DeliveryWindow = string(data)
+	}
+	_, err := e.w.Write([]parquetRow{row})
+	return err
+}
+
+// Close flushes the remaining rows and writes the file footer
+func (e *Encoder) Close() error {
+	return e.w.Close()
+}
+
+func parquetTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
@@ -19,4 +19,12 @@ var (
 	// the message is not in the expected fromState.
 	// This is the expected outcome when multiple workers race for the same message.
 	ErrStateConflict = errors.New("state conflict: message not in expected state")
+
+	// ErrVersionConflict is returned by backends tracking versions when a
+	// write carries a version other than the stored one: another writer
+	// changed the message since it was read, see VersionBackend.
+	ErrVersionConflict = errors.New("version conflict: message changed since it was read")
+
+	// ErrMissingID is returned for batch items without a message ID
+	ErrMissingID = errors.New("message without ID")
 )
@@ -0,0 +1,404 @@
+// Package failover spreads a backend over several replicas of a remote
+// metadata service. Calls go to the preferred healthy endpoint and fail
+// over to the next one on transport errors, so losing one replica does not
+// stall the spool.
+//
+// Reads honour the read preference and consistency of their context, see
+// metastorage.WithReadPreference: metastorage.ReadPrimary and
+// metastorage.ConsistencyStrong read from the first healthy endpoint in
+// configuration order, metastorage.ReadReplica from the others while any
+// of them is healthy. Writes always use the default order.
+package failover
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+const (
+	// MetricEndpointUp is 1 while an endpoint is healthy, labeled by endpoint
+	MetricEndpointUp = "metastorage_failover_endpoint_up"
+
+	// MetricFailovers counts calls moved to another endpoint, labeled by
+	// the endpoint that failed
+	MetricFailovers = "metastorage_failover_total"
+)
+
+// Defaults for the health checker
+const (
+	DefaultHealthInterval = 5 * time.Second
+	DefaultProbeTimeout   = 2 * time.Second
+)
+
+// probeID is looked up by the default probe; not finding it proves the
+// endpoint answers
+const probeID = "x-retryspool-healthcheck"
+
+// ErrNoEndpoints is returned by New without endpoints
+var ErrNoEndpoints = errors.New("failover: no endpoints")
+
+// Endpoint is one replica of the remote service
+type Endpoint struct {
+	Name    string // Used in logs and metric labels, e.g. the address
+	Backend metastorage.Backend
+}
+
+// Probe checks whether an endpoint is usable
+type Probe func(ctx context.Context, b metastorage.Backend) error
+
+// DefaultProbe looks up a message that does not exist; ErrMessageNotFound
+// counts as healthy
+func DefaultProbe(ctx context.Context, b metastorage.Backend) error {
+	_, err := b.GetMeta(ctx, probeID)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		return nil
+	}
+	return err
+}
+
+type (
+	intervalKey       struct{}
+	probeTimeoutKey   struct{}
+	probeKey          struct{}
+	latencyRoutingKey struct{}
+)
+
+// WithHealthInterval sets how often all endpoints are probed (default
+// DefaultHealthInterval). Values <= 0 disable background checks; endpoints
+// are then only marked down by failed calls and up by successful ones.
+func WithHealthInterval(d time.Duration) options.Option {
+	return options.WithValue(intervalKey{}, d)
+}
+
+// WithProbeTimeout bounds a single probe (default DefaultProbeTimeout)
+func WithProbeTimeout(d time.Duration) options.Option {
+	return options.WithValue(probeTimeoutKey{}, d)
+}
+
+// WithProbe replaces DefaultProbe
+func WithProbe(p Probe) options.Option {
+	return options.WithValue(probeKey{}, p)
+}
+
+// WithLatencyRouting routes calls to the healthy endpoint with the lowest
+// observed latency instead of the first healthy one in configuration order
+func WithLatencyRouting(enabled bool) options.Option {
+	return options.WithValue(latencyRoutingKey{}, enabled)
+}
+
+type endpoint struct {
+	Endpoint
+	index   int
+	up      atomic.Bool
+	latency atomic.Int64 // moving average in nanoseconds, 0 = unknown
+}
+
+// observe folds a successful call's duration into the moving average
+func (e *endpoint) observe(d time.Duration) {
+	for {
+		old := e.latency.Load()
+		next := int64(d)
+		if old != 0 {
+			next = old + (int64(d)-old)*3/10
+		}
+		if e.latency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Backend routes calls to a healthy endpoint
+type Backend struct {
+	endpoints      []*endpoint
+	probe          Probe
+	probeTimeout   time.Duration
+	latencyRouting bool
+	logger         *slog.Logger
+	metrics        metrics.Recorder
+
+	closed atomic.Bool
+	stop   context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a failover backend over endpoints, in order of preference.
+// All endpoints start healthy. The backend owns the endpoints and closes
+// them on Close.
+func New(endpoints []Endpoint, opts ...options.Option) (*Backend, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	o := options.Apply(opts...)
+	b := &Backend{
+		probe:          options.ValueOr[Probe](o, probeKey{}, DefaultProbe),
+		probeTimeout:   options.ValueOr(o, probeTimeoutKey{}, DefaultProbeTimeout),
+		latencyRouting: options.ValueOr(o, latencyRoutingKey{}, false),
+		logger:         o.Logger,
+		metrics:        o.Metrics,
+	}
+	for i, e := range endpoints {
+		ep := &endpoint{Endpoint: e, index: i}
+		ep.up.Store(true)
+		b.endpoints = append(b.endpoints, ep)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stop = cancel
+	if interval := options.ValueOr(o, intervalKey{}, DefaultHealthInterval); interval > 0 {
+		b.wg.Add(1)
+		go b.healthLoop(ctx, interval)
+	}
+	return b, nil
+}
+
+func (b *Backend) healthLoop(ctx context.Context, interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.CheckHealth(ctx)
+		}
+	}
+}
+
+// CheckHealth probes all endpoints concurrently and updates their status.
+// It is called periodically in the background and may be called directly,
+// e.g. right after startup.
+func (b *Backend) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, ep := range b.endpoints {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, b.probeTimeout)
+			defer cancel()
+			start := time.Now()
+			if err := b.probe(pctx, ep.Backend); err != nil {
+				b.markDown(ep, err)
+				return
+			}
+			ep.observe(time.Since(start))
+			b.markUp(ep)
+		}(ep)
+	}
+	wg.Wait()
+}
+
+func (b *Backend) markUp(ep *endpoint) {
+	if !ep.up.Swap(true) {
+		b.logger.Info("metastorage endpoint recovered", slog.String("endpoint", ep.Name))
+	}
+	b.metrics.Gauge(MetricEndpointUp, metrics.Labels{"endpoint": ep.Name}, 1)
+}
+
+func (b *Backend) markDown(ep *endpoint, err error) {
+	if ep.up.Swap(false) {
+		b.logger.Warn("metastorage endpoint down", slog.String("endpoint", ep.Name), slog.String("error", err.Error()))
+	}
+	b.metrics.Gauge(MetricEndpointUp, metrics.Labels{"endpoint": ep.Name}, 0)
+}
+
+// Status reports the health of each endpoint by name
+func (b *Backend) Status() map[string]bool {
+	status := make(map[string]bool, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		status[ep.Name] = ep.up.Load()
+	}
+	return status
+}
+
+// candidates returns the endpoints in the order they should be tried:
+// healthy ones first, by preference or latency, then the unhealthy ones
+// as a last resort
+func (b *Backend) candidates() []*endpoint {
+	eps := append([]*endpoint(nil), b.endpoints...)
+	sort.SliceStable(eps, func(i, j int) bool {
+		ui, uj := eps[i].up.Load(), eps[j].up.Load()
+		if ui != uj {
+			return ui
+		}
+		if b.latencyRouting {
+			li, lj := eps[i].latency.Load(), eps[j].latency.Load()
+			if li != lj && li != 0 && lj != 0 {
+				return li < lj
+			}
+		}
+		return eps[i].index < eps[j].index
+	})
+	return eps
+}
+
+// readCandidates returns the endpoints in the order a read with the read
+// preference and consistency of ctx should try them
+func (b *Backend) readCandidates(ctx context.Context) []*endpoint {
+	pref := metastorage.ReadPreferenceFromContext(ctx)
+	if pref == metastorage.ReadPrimary || metastorage.ConsistencyFromContext(ctx) == metastorage.ConsistencyStrong {
+		eps := append([]*endpoint(nil), b.endpoints...)
+		sort.SliceStable(eps, func(i, j int) bool {
+			return eps[i].up.Load() && !eps[j].up.Load()
+		})
+		return eps
+	}
+	eps := b.candidates()
+	if pref == metastorage.ReadReplica {
+		// move the primary behind the healthy replicas
+		for i, ep := range eps {
+			if ep.index != 0 {
+				continue
+			}
+			rest := eps[i+1:]
+			n := 0
+			for n < len(rest) && rest[n].up.Load() {
+				n++
+			}
+			copy(eps[i:], rest[:n])
+			eps[i+n] = ep
+			break
+		}
+	}
+	return eps
+}
+
+// do runs op on the first usable endpoint. Errors that metastorage.IsRetryable
+// classifies as transient mark the endpoint down and move on to the next
+// one; all other errors, including contract errors, are returned as is.
+func (b *Backend) do(ctx context.Context, op func(metastorage.Backend) error) error {
+	return b.try(ctx, b.candidates(), op)
+}
+
+// read is do for reads, see readCandidates
+func (b *Backend) read(ctx context.Context, op func(metastorage.Backend) error) error {
+	return b.try(ctx, b.readCandidates(ctx), op)
+}
+
+// try runs op on the first usable endpoint of eps, see do
+func (b *Backend) try(ctx context.Context, eps []*endpoint, op func(metastorage.Backend) error) error {
+	if b.closed.Load() {
+		return metastorage.ErrBackendClosed
+	}
+	var lastErr error
+	for _, ep := range eps {
+		start := time.Now()
+		err := op(ep.Backend)
+		if err == nil {
+			ep.observe(time.Since(start))
+			b.markUp(ep)
+			return nil
+		}
+		if !metastorage.IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		b.markDown(ep, err)
+		b.metrics.Counter(MetricFailovers, metrics.Labels{"endpoint": ep.Name}, 1)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// StoreMeta stores message metadata
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	return b.do(ctx, func(be metastorage.Backend) error {
+		return be.StoreMeta(ctx, messageID, metadata)
+	})
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	var m metastorage.MessageMetadata
+	err := b.read(ctx, func(be metastorage.Backend) error {
+		var err error
+		m, err = be.GetMeta(ctx, messageID)
+		return err
+	})
+	return m, err
+}
+
+// UpdateMeta updates message metadata
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	return b.do(ctx, func(be metastorage.Backend) error {
+		return be.UpdateMeta(ctx, messageID, metadata)
+	})
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	return b.do(ctx, func(be metastorage.Backend) error {
+		return be.DeleteMeta(ctx, messageID)
+	})
+}
+
+// ListMessages lists messages with pagination and filtering
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	var res metastorage.MessageListResult
+	err := b.read(metastorage.ListContext(ctx, opts), func(be metastorage.Backend) error {
+		var err error
+		res, err = be.ListMessages(ctx, state, opts)
+		return err
+	})
+	return res, err
+}
+
+// NewMessageIterator opens the iterator on a healthy endpoint. The
+// iterator stays on that endpoint; if it fails mid-scan the error is
+// returned to the caller, who can start a new scan.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	var it metastorage.MessageIterator
+	err := b.read(ctx, func(be metastorage.Backend) error {
+		var err error
+		it, err = be.NewMessageIterator(ctx, state, batchSize)
+		return err
+	})
+	return it, err
+}
+
+// MoveToState moves a message between states. A move that reached a
+// failed endpoint before the failure may surface as ErrStateConflict on
+// the next endpoint, as with any lost response.
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	return b.do(ctx, func(be metastorage.Backend) error {
+		return be.MoveToState(ctx, messageID, fromState, toState)
+	})
+}
+
+// GetStateCount returns the count of the first healthy endpoint able to
+// count states, or -1 if there is none
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	for _, ep := range b.candidates() {
+		if !ep.up.Load() {
+			break
+		}
+		if counter, ok := metastorage.As[metastorage.StateCounterBackend](ep.Backend); ok {
+			return counter.GetStateCount(state)
+		}
+	}
+	return -1
+}
+
+// Close stops the health checker and closes all endpoints
+func (b *Backend) Close() error {
+	if b.closed.Swap(true) {
+		return nil
+	}
+	b.stop()
+	b.wg.Wait()
+	var errs []error
+	for _, ep := range b.endpoints {
+		if err := ep.Backend.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
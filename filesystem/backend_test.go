@@ -0,0 +1,46 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestVersionRejected(t *testing.T) {
+	ctx := context.Background()
+	b, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, Version: 7}
+	if err := b.StoreMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 0 {
+		t.Fatalf("stored version %d, want 0", got.Version)
+	}
+	if err := b.UpdateMeta(ctx, "m1", m); !errors.Is(err, metastorage.ErrNotSupported) {
+		t.Fatalf("versioned update: %v, want ErrNotSupported", err)
+	}
+	got.Attempts++
+	if err := b.UpdateMeta(ctx, "m1", got); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+}
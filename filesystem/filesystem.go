@@ -0,0 +1,547 @@
+// Package filesystem implements metastorage.Backend with one file per
+// message in a directory per state:
+//
+//	<dir>/incoming/<id>.json
+//	<dir>/active/<id>.json
+//	<dir>/deferred/<id>.json
+//	...
+//
+// A message's state is the directory it is in; the state stored in the
+// file is ignored. MoveToState is a single rename between the state
+// directories, so it is atomic and has exactly one winner. Files are
+// written to <dir>/tmp first and renamed into place, so readers never see
+// partial files.
+//
+// Operations on the same message are serialized in process. Several
+// processes may share a directory for reading, but only one should write.
+//
+// StoreMeta and MoveToState set StateEnteredAt and assign the next
+// Sequence of the state entered while holding the message's lock; a move
+// rewrites the file after the rename. Sequences continue after the
+// highest one found in a state directory the first time it is entered.
+//
+// Importing the package registers the "file://" DSN scheme.
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/codec"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// ext is the file name suffix of message files
+const ext = ".json"
+
+// lockStripes is the number of mutexes serializing operations by message ID
+const lockStripes = 64
+
+func init() {
+	registry.Register("file", open)
+}
+
+// open handles "file:///var/spool/retryspool/meta" DSNs
+func open(_ context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	if dsn.Path == "" {
+		return nil, errors.New("file DSN: path is required")
+	}
+	return New(dsn.Path, opts...)
+}
+
+// Backend stores message metadata as files
+type Backend struct {
+	dir    string
+	codec  codec.Codec
+	skew   time.Duration
+	clock  clock.Clock
+	locks  [lockStripes]sync.Mutex
+	closed atomic.Bool
+
+	seqMu sync.Mutex
+	last  map[metastorage.QueueState]uint64 // highest sequence per state, loaded on first use
+}
+
+// New creates a backend in dir, creating the state directories if needed.
+// With a namespace, the data lives in dir/<namespace>.
+func New(dir string, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	if o.Namespace != "" {
+		dir = filepath.Join(dir, o.Namespace)
+	}
+	b := &Backend{
+		dir:   dir,
+		codec: o.Codec,
+		skew:  o.ClockSkew,
+		clock: o.Clock,
+		last:  make(map[metastorage.QueueState]uint64),
+	}
+	for _, d := range append([]string{"tmp"}, stateDirs()...) {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o700); err != nil {
+			return nil, fmt.Errorf("filesystem: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (b *Backend) ClockSkew() time.Duration {
+	return b.skew
+}
+
+func stateDirs() []string {
+	var dirs []string
+	for _, s := range metastorage.States() {
+		dirs = append(dirs, s.String())
+	}
+	return dirs
+}
+
+// fileName maps a message ID to a file name; IDs may contain any
+// character, including path separators
+func fileName(id string) string {
+	return url.PathEscape(id) + ext
+}
+
+func (b *Backend) path(state metastorage.QueueState, id string) string {
+	return filepath.Join(b.dir, state.String(), fileName(id))
+}
+
+// lock serializes operations on id and returns the unlock function
+func (b *Backend) lock(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &b.locks[h.Sum32()%lockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (b *Backend) check(ctx context.Context) error {
+	if b.closed.Load() {
+		return metastorage.ErrBackendClosed
+	}
+	return ctx.Err()
+}
+
+// locate returns the state whose directory holds id
+func (b *Backend) locate(id string) (metastorage.QueueState, error) {
+	for _, s := range metastorage.States() {
+		_, err := os.Stat(b.path(s, id))
+		if err == nil {
+			return s, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return 0, metastorage.ErrMessageNotFound
+}
+
+// read decodes the file of id in state
+func (b *Backend) read(state metastorage.QueueState, id string) (metastorage.MessageMetadata, error) {
+	data, err := os.ReadFile(b.path(state, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	var m metastorage.MessageMetadata
+	if err := b.codec.Unmarshal(data, &m); err != nil {
+		return metastorage.MessageMetadata{}, fmt.Errorf("filesystem: %s: %w", id, err)
+	}
+	m.ID = id
+	m.State = state
+	return m, nil
+}
+
+// write atomically replaces the file of m in its state directory
+func (b *Backend) write(m metastorage.MessageMetadata) error {
+	data, err := b.codec.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Join(b.dir, "tmp"), "meta-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), b.path(m.State, m.ID))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID. It assigns the next sequence of the message's state and
+// sets StateEnteredAt if it is unset.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata.Version = 0 // versions are not tracked
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	seq, err := b.next(metadata.State)
+	if err != nil {
+		return err
+	}
+	metadata = metastorage.EnterState(metadata, b.clock.Now())
+	metadata.ID = messageID
+	metadata.Sequence = seq
+	if err := b.write(metadata); err != nil {
+		return err
+	}
+	for _, s := range metastorage.States() {
+		if s == metadata.State {
+			continue
+		}
+		if err := os.Remove(b.path(s, messageID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMeta retrieves message metadata. It holds the message's lock while
+// looking through the state directories, as a concurrent move could take
+// the file from a directory not yet searched to one already searched.
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := b.check(ctx); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	defer b.lock(messageID)()
+	for _, s := range metastorage.States() {
+		m, err := b.read(s, messageID)
+		if !errors.Is(err, metastorage.ErrMessageNotFound) {
+			return m, err
+		}
+	}
+	return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := metastorage.RejectVersion(messageID, metadata.Version); err != nil {
+		return err
+	}
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	state, err := b.locate(messageID)
+	if err != nil {
+		return err
+	}
+	if metadata.State != state {
+		return fmt.Errorf("%w: %s is %s, update has %s", metastorage.ErrStateConflict, messageID, state, metadata.State)
+	}
+	metadata.ID = messageID
+	return b.write(metadata)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	state, err := b.locate(messageID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(b.path(state, messageID))
+}
+
+// MoveToState renames the message file from the fromState to the toState
+// directory and records when it entered toState with the next sequence of
+// toState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	if fromState == toState {
+		_, err := b.read(fromState, messageID)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			return b.conflictOrNotFound(messageID)
+		}
+		return err
+	}
+	err := os.Rename(b.path(fromState, messageID), b.path(toState, messageID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return b.conflictOrNotFound(messageID)
+	}
+	if err != nil {
+		return err
+	}
+	m, err := b.read(toState, messageID)
+	if err != nil {
+		return err
+	}
+	if m.Sequence, err = b.next(toState); err != nil {
+		return err
+	}
+	m.StateEnteredAt = b.clock.Now().UTC()
+	return b.write(m)
+}
+
+// next returns the next sequence of state, scanning the state directory
+// for the highest stored one first if the state was not entered yet
+func (b *Backend) next(state metastorage.QueueState) (uint64, error) {
+	b.seqMu.Lock()
+	defer b.seqMu.Unlock()
+	last, ok := b.last[state]
+	if !ok {
+		var err error
+		if last, err = b.highest(state); err != nil {
+			return 0, err
+		}
+	}
+	last++
+	b.last[state] = last
+	return last, nil
+}
+
+// highest returns the highest sequence stored in state
+func (b *Backend) highest(state metastorage.QueueState) (uint64, error) {
+	ids, err := b.ids(state)
+	if err != nil {
+		return 0, err
+	}
+	var highest uint64
+	for _, id := range ids {
+		m, err := b.read(state, id)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		highest = max(highest, m.Sequence)
+	}
+	return highest, nil
+}
+
+// LastSequence returns the highest sequence assigned in state, see
+// metastorage.SequenceBackend
+func (b *Backend) LastSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	if err := b.check(ctx); err != nil {
+		return 0, err
+	}
+	b.seqMu.Lock()
+	defer b.seqMu.Unlock()
+	last, ok := b.last[state]
+	if !ok {
+		var err error
+		if last, err = b.highest(state); err != nil {
+			return 0, err
+		}
+		b.last[state] = last
+	}
+	return last, nil
+}
+
+// TracksStateTime reports that StateEnteredAt is maintained, see
+// metastorage.StateTimeBackend
+func (b *Backend) TracksStateTime() bool {
+	return true
+}
+
+func (b *Backend) conflictOrNotFound(id string) error {
+	if _, err := b.locate(id); err != nil {
+		return err
+	}
+	return metastorage.ErrStateConflict
+}
+
+// ids returns the sorted IDs in the state directory
+func (b *Backend) ids(state metastorage.QueueState) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(b.dir, state.String()))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ext)
+		if !ok || e.IsDir() {
+			continue
+		}
+		id, err := url.PathUnescape(name)
+		if err != nil {
+			continue // not written by this backend
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// GetStateCount returns the number of files in the state directory, -1 on
+// errors
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	if b.closed.Load() {
+		return -1
+	}
+	ids, err := b.ids(state)
+	if err != nil {
+		return -1
+	}
+	return int64(len(ids))
+}
+
+// Recover removes the temporary files of writes interrupted by a crash
+// and, for messages found in several state directories after an
+// interrupted StoreMeta, keeps the most recently written file. See
+// metastorage.Recover.
+func (b *Backend) Recover(ctx context.Context) error {
+	if err := b.check(ctx); err != nil {
+		return err
+	}
+	tmp := filepath.Join(b.dir, "tmp")
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		return fmt.Errorf("filesystem: %w", err)
+	}
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(tmp, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("filesystem: %w", err)
+		}
+	}
+
+	type file struct {
+		state    metastorage.QueueState
+		modified time.Time
+	}
+	seen := make(map[string]file)
+	for _, s := range metastorage.States() {
+		ids, err := b.ids(s)
+		if err != nil {
+			return fmt.Errorf("filesystem: %w", err)
+		}
+		for _, id := range ids {
+			info, err := os.Stat(b.path(s, id))
+			if err != nil {
+				continue
+			}
+			f := file{state: s, modified: info.ModTime()}
+			prev, dup := seen[id]
+			if !dup {
+				seen[id] = f
+				continue
+			}
+			drop := s
+			if f.modified.After(prev.modified) {
+				drop = prev.state
+				seen[id] = f
+			}
+			if err := os.Remove(b.path(drop, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("filesystem: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ListMessages lists the IDs of messages in state, see
+// metastorage.ListPage for the supported options. Every file of the state
+// is read.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := b.check(ctx); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	ids, err := b.ids(state)
+	if err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	ms := make([]metastorage.MessageMetadata, 0, len(ids))
+	for _, id := range ids {
+		m, err := b.read(state, id)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			continue // moved or deleted meanwhile
+		}
+		if err != nil {
+			return metastorage.MessageListResult{}, err
+		}
+		ms = append(ms, m)
+	}
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the files in the state
+// directory at the time of the call, ordered by ID. Files are read when
+// the iterator reaches them; messages moved or deleted meanwhile are
+// skipped.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, _ int) (metastorage.MessageIterator, error) {
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := b.ids(state)
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{backend: b, state: state, ids: ids}, nil
+}
+
+// Close marks the backend closed. Later calls fail with ErrBackendClosed.
+func (b *Backend) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+type iterator struct {
+	backend *Backend
+	state   metastorage.QueueState
+	ids     []string
+}
+
+// Next reads the next message file that still exists
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	for len(it.ids) > 0 {
+		if err := it.backend.check(ctx); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		id := it.ids[0]
+		it.ids = it.ids[1:]
+		m, err := it.backend.read(it.state, id)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		return m, true, nil
+	}
+	return metastorage.MessageMetadata{}, false, nil
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.ids = nil
+	return nil
+}
@@ -1,3 +1,5 @@
 module schneider.vip/retryspool/storage/meta
 
-go 1.21
+go 1.22
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,124 @@
+package grpcbackend
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+// serve serves backend on an in-memory listener and returns a client
+func serve(t *testing.T, backend metastorage.Backend) *Client {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	NewServer(backend).Register(gs)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return New(conn)
+}
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		return serve(t, memory.New())
+	})
+}
+
+// hinted records the hints of the reads reaching the served backend
+type hinted struct {
+	*memory.Backend
+	options    metastorage.ReadOptions
+	preference metastorage.ReadPreference
+}
+
+func (h *hinted) GetMeta(ctx context.Context, id string) (metastorage.MessageMetadata, error) {
+	h.options = metastorage.ReadOptionsFromContext(ctx)
+	h.preference = metastorage.ReadPreferenceFromContext(ctx)
+	return h.Backend.GetMeta(ctx, id)
+}
+
+func (h *hinted) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	h.options = metastorage.ReadOptionsFromContext(ctx)
+	return h.Backend.ListMessages(ctx, state, opts)
+}
+
+func TestHints(t *testing.T) {
+	backend := &hinted{Backend: memory.New()}
+	c := serve(t, backend)
+	ctx := metastorage.WithReadPreference(metastorage.WithBoundedStaleness(context.Background(), 5*time.Second), metastorage.ReadReplica)
+	if err := c.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetMeta(ctx, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	want := metastorage.ReadOptions{Consistency: metastorage.ConsistencyBoundedStaleness, MaxStaleness: 5 * time.Second}
+	if backend.options != want || backend.preference != metastorage.ReadReplica {
+		t.Fatalf("server read with %+v, %s; want %+v, replica", backend.options, backend.preference, want)
+	}
+
+	opts := metastorage.MessageListOptions{Limit: 10, Consistency: metastorage.ConsistencyStrong}
+	if _, err := c.ListMessages(context.Background(), metastorage.StateIncoming, opts); err != nil {
+		t.Fatal(err)
+	}
+	if backend.options.Consistency != metastorage.ConsistencyStrong {
+		t.Errorf("server listed with %s, want strong", backend.options.Consistency)
+	}
+}
+
+// failingMove fails moves of m3 and hides the native bulk move of the
+// backend it wraps
+type failingMove struct {
+	metastorage.Backend
+}
+
+func (f failingMove) MoveToState(ctx context.Context, id string, from, to metastorage.QueueState) error {
+	if id == "m3" {
+		return errors.New("disk full")
+	}
+	return f.Backend.MoveToState(ctx, id, from, to)
+}
+
+func TestMoveStateBulk(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	for i, id := range []string{"m1", "m2", "m3"} {
+		if err := inner.StoreMeta(ctx, id, metastorage.MessageMetadata{ID: id, State: metastorage.StateHold, Priority: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := serve(t, inner)
+	if _, ok := metastorage.Outer[metastorage.BulkMoveBackend](c); !ok {
+		t.Fatal("client does not move natively")
+	}
+	n, err := metastorage.MoveStateBulk(ctx, c, metastorage.StateHold, metastorage.StateIncoming, func(m metastorage.MessageMetadata) bool { return m.Priority == 1 })
+	if err != nil || n != 1 {
+		t.Fatalf("filtered move: %d, %v; want 1", n, err)
+	}
+	if m, err := inner.GetMeta(ctx, "m2"); err != nil || m.State != metastorage.StateIncoming {
+		t.Fatalf("m2 in %s, %v; want incoming", m.State, err)
+	}
+
+	c = serve(t, failingMove{inner})
+	n, err = metastorage.MoveStateBulk(ctx, c, metastorage.StateHold, metastorage.StateIncoming, nil)
+	if err == nil || n != 1 {
+		t.Fatalf("failing move: %d, %v; want 1 moved and an error", n, err)
+	}
+}
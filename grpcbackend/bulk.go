@@ -0,0 +1,110 @@
+package grpcbackend
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/grpcbackend/metapb"
+)
+
+// movedTrailer carries the number of messages a failed MoveStateBulk
+// moved before the failure
+const movedTrailer = "x-meta-moved"
+
+// MoveStateBulk moves messages on the served backend with
+// metastorage.MoveStateBulk, natively if it implements
+// metastorage.BulkMoveBackend. Filtered requests move only the listed
+// messages.
+func (s *Server) MoveStateBulk(ctx context.Context, req *metapb.MoveStateBulkRequest) (*metapb.MoveStateBulkResponse, error) {
+	if err := validState(req.GetFromState()); err != nil {
+		return nil, err
+	}
+	if err := validState(req.GetToState()); err != nil {
+		return nil, err
+	}
+	var filter func(metastorage.MessageMetadata) bool
+	if req.GetFiltered() {
+		ids := make(map[string]struct{}, len(req.GetMessageIds()))
+		for _, token := range req.GetMessageIds() {
+			// a forged token references no message
+			if id, err := s.ids.Decode(token); err == nil {
+				ids[id] = struct{}{}
+			}
+		}
+		filter = func(m metastorage.MessageMetadata) bool {
+			_, ok := ids[m.ID]
+			return ok
+		}
+	}
+	n, err := metastorage.MoveStateBulk(ctx, s.backend, stateFromPB(req.GetFromState()), stateFromPB(req.GetToState()), filter)
+	if err != nil {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(movedTrailer, strconv.Itoa(n)))
+		return nil, toStatus(err)
+	}
+	return &metapb.MoveStateBulkResponse{Moved: int64(n)}, nil
+}
+
+// MoveStateBulk moves messages of fromState to toState in one call, see
+// metastorage.BulkMoveBackend. filter runs on the client: the IDs of the
+// matching messages are read with an iterator and sent along, and those
+// still in fromState are moved. After an error the messages moved before
+// are counted.
+func (c *Client) MoveStateBulk(ctx context.Context, fromState, toState metastorage.QueueState, filter func(metastorage.MessageMetadata) bool) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	req := &metapb.MoveStateBulkRequest{
+		FromState: stateToPB(fromState),
+		ToState:   stateToPB(toState),
+		Filtered:  filter != nil,
+	}
+	if filter != nil {
+		ids, err := c.matching(ctx, fromState, filter)
+		if err != nil || len(ids) == 0 {
+			return 0, err
+		}
+		req.MessageIds = ids
+	}
+	release, err := c.acquire(ctx, PriorityBulk)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	var trailer metadata.MD
+	resp, err := c.rpc.MoveStateBulk(ctx, req, grpc.Trailer(&trailer))
+	if err != nil {
+		moved := 0
+		if values := trailer.Get(movedTrailer); len(values) > 0 {
+			moved, _ = strconv.Atoi(values[0])
+		}
+		return moved, fromStatus(err)
+	}
+	return int(resp.GetMoved()), nil
+}
+
+// matching returns the IDs of the messages of state filter returns true
+// for
+func (c *Client) matching(ctx context.Context, state metastorage.QueueState, filter func(metastorage.MessageMetadata) bool) ([]string, error) {
+	iter, err := c.NewMessageIterator(ctx, state, MaxStreamBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var ids []string
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return ids, nil
+		}
+		if filter(m) {
+			ids = append(ids, m.ID)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package grpcbackend
+
+import (
+	"context"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/grpcbackend/metapb"
+)
+
+// ClaimBatch claims messages on the served backend with
+// metastorage.ClaimBatch, so the candidate scan and the per message moves
+// stay next to the backend
+func (s *Server) ClaimBatch(ctx context.Context, req *metapb.ClaimBatchRequest) (*metapb.ClaimBatchResponse, error) {
+	if err := validState(req.GetState()); err != nil {
+		return nil, err
+	}
+	lease := time.Duration(req.GetLeaseMillis()) * time.Millisecond
+	claimed, err := metastorage.ClaimBatch(ctx, s.backend, stateFromPB(req.GetState()), req.GetWorkerId(), int(req.GetN()), lease)
+	// messages claimed before a failure are owned by the worker now and
+	// must not be lost, so they are only dropped if nothing was claimed
+	if err != nil && len(claimed) == 0 {
+		return nil, toStatus(err)
+	}
+	resp := &metapb.ClaimBatchResponse{Messages: make([]*metapb.MessageMetadata, len(claimed))}
+	for i, m := range claimed {
+		resp.Messages[i] = s.metaToPB(m)
+	}
+	return resp, nil
+}
+
+// ClaimBatch claims up to n due messages of state in one round trip
+func (c *Client) ClaimBatch(ctx context.Context, state metastorage.QueueState, workerID string, n int, lease time.Duration) ([]metastorage.MessageMetadata, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	resp, err := c.rpc.ClaimBatch(ctx, &metapb.ClaimBatchRequest{
+		State:       stateToPB(state),
+		WorkerId:    workerID,
+		N:           int32(n),
+		LeaseMillis: lease.Milliseconds(),
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return metasFromPB(resp.GetMessages()), nil
+}
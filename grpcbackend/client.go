@@ -0,0 +1,329 @@
+package grpcbackend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/grpcbackend/metapb"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// stateCountTimeout bounds GetStateCount, which has no context parameter
+const stateCountTimeout = 5 * time.Second
+
+type dialOptionsKey struct{}
+
+// WithDialOptions adds gRPC dial options used by Dial, e.g. transport
+// credentials. Without credentials Dial connects insecurely.
+func WithDialOptions(dialOpts ...grpc.DialOption) options.Option {
+	return func(o *options.Options) {
+		prev := options.ValueOr[[]grpc.DialOption](*o, dialOptionsKey{}, nil)
+		options.WithValue(dialOptionsKey{}, append(prev[:len(prev):len(prev)], dialOpts...))(o)
+	}
+}
+
+// Client implements metastorage.Backend against a remote MetaStorage
+// service
+type Client struct {
+	rpc       metapb.MetaStorageClient
+	conn      *grpc.ClientConn // owned connection, nil if passed to New
+	batchSize int
+	skew      time.Duration
+	sched     *scheduler // nil without WithMaxInFlight
+	closed    atomic.Bool
+}
+
+// New creates a client using an existing connection. Closing the client
+// does not close conn. With WithMaxInFlight, calls are scheduled so
+// claims and moves are not starved by scans sharing the connection. The
+// hints of metastorage.WithConsistency and metastorage.WithReadPreference
+// are sent to the server as call metadata.
+func New(conn grpc.ClientConnInterface, opts ...options.Option) *Client {
+	o := options.Apply(opts...)
+	return &Client{rpc: metapb.NewMetaStorageClient(hintConn{conn}), batchSize: o.BatchSize, skew: o.ClockSkew, sched: newScheduler(o)}
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (c *Client) ClockSkew() time.Duration {
+	return c.skew
+}
+
+// Dial connects to the service at target and returns a client owning the
+// connection. Transport tuning is taken from WithTransport.
+func Dial(target string, opts ...options.Option) (*Client, error) {
+	o := options.Apply(opts...)
+	transport := options.ValueOr(o, transportKey{}, Transport{})
+	if err := transport.Validate(); err != nil {
+		return nil, err
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	dialOpts = append(dialOpts, transport.DialOptions()...)
+	dialOpts = append(dialOpts, options.ValueOr[[]grpc.DialOption](o, dialOptionsKey{}, nil)...)
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c := New(conn, opts...)
+	c.conn = conn
+	return c, nil
+}
+
+func (c *Client) check() error {
+	if c.closed.Load() {
+		return metastorage.ErrBackendClosed
+	}
+	return nil
+}
+
+// acquire waits for a scheduler slot for a call of priority def, unless
+// ctx carries a priority set with WithPriority
+func (c *Client) acquire(ctx context.Context, def Priority) (func(), error) {
+	return c.sched.acquire(ctx, priorityOf(ctx, def))
+}
+
+// StoreMeta stores message metadata
+func (c *Client) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = c.rpc.StoreMeta(ctx, &metapb.StoreMetaRequest{MessageId: messageID, Metadata: metaToPB(metadata)})
+	return fromStatus(err)
+}
+
+// GetMeta retrieves message metadata
+func (c *Client) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := c.check(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	defer release()
+	resp, err := c.rpc.GetMeta(ctx, &metapb.GetMetaRequest{MessageId: messageID})
+	if err != nil {
+		return metastorage.MessageMetadata{}, fromStatus(err)
+	}
+	return metaFromPB(resp.GetMetadata()), nil
+}
+
+// UpdateMeta updates message metadata
+func (c *Client) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = c.rpc.UpdateMeta(ctx, &metapb.UpdateMetaRequest{MessageId: messageID, Metadata: metaToPB(metadata)})
+	return fromStatus(err)
+}
+
+// DeleteMeta removes message metadata
+func (c *Client) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = c.rpc.DeleteMeta(ctx, &metapb.DeleteMetaRequest{MessageId: messageID})
+	return fromStatus(err)
+}
+
+// ListMessages lists messages with pagination and filtering
+func (c *Client) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := c.check(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	release, err := c.acquire(ctx, PriorityBulk)
+	if err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	defer release()
+	resp, err := c.rpc.ListMessages(metastorage.ListContext(ctx, opts), &metapb.ListMessagesRequest{
+		State:     stateToPB(state),
+		Limit:     int64(opts.Limit),
+		Offset:    int64(opts.Offset),
+		SortBy:    opts.SortBy,
+		SortOrder: opts.SortOrder,
+		Since:     timeToPB(opts.Since),
+	})
+	if err != nil {
+		return metastorage.MessageListResult{}, fromStatus(err)
+	}
+	return metastorage.MessageListResult{
+		MessageIDs: resp.GetMessageIds(),
+		Total:      int(resp.GetTotal()),
+		HasMore:    resp.GetHasMore(),
+	}, nil
+}
+
+// MoveToState moves a message between states with CAS semantics
+func (c *Client) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = c.rpc.MoveToState(ctx, &metapb.MoveToStateRequest{
+		MessageId: messageID,
+		FromState: stateToPB(fromState),
+		ToState:   stateToPB(toState),
+	})
+	return fromStatus(err)
+}
+
+// GetStateCount returns the count reported by the service, or -1 if the
+// served backend cannot count states or the call fails
+func (c *Client) GetStateCount(state metastorage.QueueState) int64 {
+	if c.check() != nil {
+		return -1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stateCountTimeout)
+	defer cancel()
+	release, err := c.acquire(ctx, PriorityBulk)
+	if err != nil {
+		return -1
+	}
+	defer release()
+	resp, err := c.rpc.GetStateCount(ctx, &metapb.GetStateCountRequest{State: stateToPB(state)})
+	if err != nil {
+		return -1
+	}
+	return resp.GetCount()
+}
+
+// ServerTime returns the time of the service, see
+// metastorage.ServerTimeBackend. Served backends that report their own
+// server time, such as SQL databases, are asked for it; otherwise the
+// clock of the service host is returned.
+func (c *Client) ServerTime(ctx context.Context) (time.Time, error) {
+	if err := c.check(); err != nil {
+		return time.Time{}, err
+	}
+	release, err := c.acquire(ctx, PriorityCritical)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer release()
+	var header metadata.MD
+	if _, err := c.rpc.GetStateCount(ctx, &metapb.GetStateCountRequest{State: stateToPB(metastorage.StateIncoming)}, grpc.Header(&header)); err != nil {
+		return time.Time{}, fromStatus(err)
+	}
+	values := header.Get(serverTimeHeader)
+	if len(values) == 0 {
+		return time.Time{}, errors.New("grpcbackend: service does not report its time")
+	}
+	return time.Parse(time.RFC3339Nano, values[0])
+}
+
+// NewMessageIterator streams the state from the service with
+// ListMessagesStream. The stream lives until the iterator is exhausted,
+// closed, or ctx is done. With a scheduler, the stream holds a bulk slot
+// only while it receives a batch.
+func (c *Client) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = c.batchSize
+	}
+	priority := priorityOf(ctx, PriorityBulk)
+	release, err := c.sched.acquire(ctx, priority)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.rpc.ListMessagesStream(ctx, &metapb.ListMessagesStreamRequest{
+		State:     stateToPB(state),
+		BatchSize: int32(min(batchSize, MaxStreamBatchSize)),
+	})
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	return &streamIterator{stream: stream, cancel: cancel, sched: c.sched, priority: priority}, nil
+}
+
+// Close closes the connection if it was created by Dial
+func (c *Client) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// streamIterator reads batches from a ListMessagesStream
+type streamIterator struct {
+	stream   metapb.MetaStorage_ListMessagesStreamClient
+	cancel   context.CancelFunc
+	sched    *scheduler
+	priority Priority
+	batch    []metastorage.MessageMetadata
+	done     bool
+}
+
+// Next returns the next message, receiving the next batch when the
+// current one is used up
+func (it *streamIterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	for len(it.batch) == 0 {
+		if it.done {
+			return metastorage.MessageMetadata{}, false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		release, err := it.sched.acquire(ctx, it.priority)
+		if err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		resp, err := it.stream.Recv()
+		release()
+		if errors.Is(err, io.EOF) {
+			it.done = true
+			it.cancel()
+			continue
+		}
+		if err != nil {
+			return metastorage.MessageMetadata{}, false, fromStatus(err)
+		}
+		it.batch = metasFromPB(resp.GetMessages())
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+// Close stops the stream
+func (it *streamIterator) Close() error {
+	it.done = true
+	it.batch = nil
+	it.cancel()
+	return nil
+}
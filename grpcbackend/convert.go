@@ -0,0 +1,105 @@
+package grpcbackend
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/grpcbackend/metapb"
+)
+
+func stateToPB(s metastorage.QueueState) metapb.QueueState {
+	return metapb.QueueState(s + 1)
+}
+
+func stateFromPB(s metapb.QueueState) metastorage.QueueState {
+	return metastorage.QueueState(s - 1)
+}
+
+func timeToPB(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timeFromPB(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func metaToPB(m metastorage.MessageMetadata) *metapb.MessageMetadata {
+	pb := &metapb.MessageMetadata{
+		Id:              m.ID,
+		State:           stateToPB(m.State),
+		Attempts:        int64(m.Attempts),
+		MaxAttempts:     int64(m.MaxAttempts),
+		NextRetry:       timeToPB(m.NextRetry),
+		Created:         timeToPB(m.Created),
+		Updated:         timeToPB(m.Updated),
+		LastError:       m.LastError,
+		Size:            m.Size,
+		Priority:        int64(m.Priority),
+		Headers:         m.Headers,
+		RetryPolicyName: m.RetryPolicyName,
+		Sequence:        m.Sequence,
+		StateEnteredAt:  timeToPB(m.StateEnteredAt),
+	}
+	if w := m.DeliveryWindow; !w.IsZero() {
+		pb.DeliveryWindow = &metapb.DeliveryWindow{
+			NotBefore: timeToPB(w.NotBefore),
+			NotAfter:  timeToPB(w.NotAfter),
+			Hours:     &metapb.HourRange{From: int32(w.Hours.From), To: int32(w.Hours.To)},
+			TimeZone:  w.TimeZone,
+		}
+		for _, d := range w.Weekdays {
+			pb.DeliveryWindow.Weekdays = append(pb.DeliveryWindow.Weekdays, int32(d))
+		}
+	}
+	return pb
+}
+
+func metaFromPB(pb *metapb.MessageMetadata) metastorage.MessageMetadata {
+	if pb == nil {
+		return metastorage.MessageMetadata{}
+	}
+	m := metastorage.MessageMetadata{
+		ID:              pb.GetId(),
+		State:           stateFromPB(pb.GetState()),
+		Attempts:        int(pb.GetAttempts()),
+		MaxAttempts:     int(pb.GetMaxAttempts()),
+		NextRetry:       timeFromPB(pb.GetNextRetry()),
+		Created:         timeFromPB(pb.GetCreated()),
+		Updated:         timeFromPB(pb.GetUpdated()),
+		LastError:       pb.GetLastError(),
+		Size:            pb.GetSize(),
+		Priority:        int(pb.GetPriority()),
+		Headers:         pb.GetHeaders(),
+		RetryPolicyName: pb.GetRetryPolicyName(),
+		Sequence:        pb.GetSequence(),
+		StateEnteredAt:  timeFromPB(pb.GetStateEnteredAt()),
+	}
+	if w := pb.GetDeliveryWindow(); w != nil {
+		m.DeliveryWindow = metastorage.DeliveryWindow{
+			NotBefore: timeFromPB(w.GetNotBefore()),
+			NotAfter:  timeFromPB(w.GetNotAfter()),
+			Hours:     metastorage.HourRange{From: int(w.GetHours().GetFrom()), To: int(w.GetHours().GetTo())},
+			TimeZone:  w.GetTimeZone(),
+		}
+		for _, d := range w.GetWeekdays() {
+			m.DeliveryWindow.Weekdays = append(m.DeliveryWindow.Weekdays, time.Weekday(d))
+		}
+	}
+	return m
+}
+
+func metasFromPB(pbs []*metapb.MessageMetadata) []metastorage.MessageMetadata {
+	out := make([]metastorage.MessageMetadata, len(pbs))
+	for i, pb := range pbs {
+		out[i] = metaFromPB(pb)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package grpcbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// errorDomain identifies ErrorInfo details set by this package
+const errorDomain = "retryspool.meta"
+
+// sentinels maps contract errors to their status code and ErrorInfo
+// reason, so clients can restore the exact error for errors.Is
+var sentinels = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{metastorage.ErrMessageNotFound, codes.NotFound, "MESSAGE_NOT_FOUND"},
+	{metastorage.ErrStateConflict, codes.Aborted, "STATE_CONFLICT"},
+	{metastorage.ErrVersionConflict, codes.Aborted, "VERSION_CONFLICT"},
+	{metastorage.ErrInvalidState, codes.FailedPrecondition, "INVALID_STATE"},
+	{metastorage.ErrPinned, codes.FailedPrecondition, "PINNED"},
+	{metastorage.ErrNonUTCTimestamp, codes.InvalidArgument, "NON_UTC_TIMESTAMP"},
+	{metastorage.ErrMissingID, codes.InvalidArgument, "MISSING_ID"},
+	{metastorage.ErrStaleWrite, codes.Aborted, "STALE_WRITE"},
+	{metastorage.ErrBackendClosed, codes.Unavailable, "BACKEND_CLOSED"},
+}
+
+func init() {
+	metastorage.RegisterClassifier(metastorage.ClassifierFunc(classify))
+}
+
+// classify treats transport level failures of the remote service as
+// transient
+func classify(err error) (bool, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false, false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// toStatus converts a backend error into a gRPC status error
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			st := status.New(s.code, err.Error())
+			if detailed, derr := st.WithDetails(&errdetails.ErrorInfo{Domain: errorDomain, Reason: s.reason}); derr == nil {
+				st = detailed
+			}
+			return st.Err()
+		}
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// remoteError is a contract error returned by the server
+type remoteError struct {
+	sentinel error
+	msg      string
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.sentinel }
+
+// fromStatus converts a gRPC error back into the contract error it was
+// created from. Unimplemented calls wrap errors.ErrUnsupported; other
+// errors are returned unchanged.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != errorDomain {
+			continue
+		}
+		for _, s := range sentinels {
+			if s.reason == info.GetReason() {
+				return &remoteError{sentinel: s.err, msg: st.Message()}
+			}
+		}
+	}
+	switch st.Code() {
+	case codes.Unimplemented:
+		return fmt.Errorf("%w: %s", errors.ErrUnsupported, st.Message())
+	case codes.Canceled:
+		return context.Canceled
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package grpcbackend
+
+import (
+	"errors"
+
+	"schneider.vip/retryspool/storage/meta/failover"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// DialFailover dials every target and returns a failover backend over the
+// resulting clients, preferring targets in the given order. Health
+// checking and routing are configured with the failover package options.
+func DialFailover(targets []string, opts ...options.Option) (*failover.Backend, error) {
+	endpoints := make([]failover.Endpoint, 0, len(targets))
+	for _, target := range targets {
+		c, err := Dial(target, opts...)
+		if err != nil {
+			for _, e := range endpoints {
+				_ = e.Backend.Close()
+			}
+			return nil, err
+		}
+		endpoints = append(endpoints, failover.Endpoint{Name: target, Backend: c})
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("grpc: no targets")
+	}
+	return failover.New(endpoints, opts...)
+}
@@ -0,0 +1,19 @@
+module schneider.vip/retryspool/storage/meta/grpcbackend
+
+go 1.25.0
+
+require (
+	github.com/klauspost/compress v1.19.2
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800
+	google.golang.org/grpc v1.82.1
+	google.golang.org/protobuf v1.36.11
+	schneider.vip/retryspool/storage/meta v0.0.0
+)
+
+require (
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+)
+
+replace schneider.vip/retryspool/storage/meta => ..
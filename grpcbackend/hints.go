@@ -0,0 +1,115 @@
+package grpcbackend
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Metadata keys carrying the hints of metastorage.WithConsistency and
+// metastorage.WithReadPreference to the served backend
+const (
+	hintConsistency    = "x-meta-consistency"
+	hintMaxStaleness   = "x-meta-max-staleness" // a Go duration, e.g. "5s"
+	hintReadPreference = "x-meta-read-preference"
+)
+
+// hintConn adds the hints of the call context to the outgoing metadata of
+// every call on the connection
+type hintConn struct {
+	grpc.ClientConnInterface
+}
+
+func (c hintConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return c.ClientConnInterface.Invoke(outgoingHints(ctx), method, args, reply, opts...)
+}
+
+func (c hintConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return c.ClientConnInterface.NewStream(outgoingHints(ctx), desc, method, opts...)
+}
+
+// outgoingHints returns ctx with its hints appended to the outgoing
+// metadata
+func outgoingHints(ctx context.Context) context.Context {
+	var kv []string
+	if o := metastorage.ReadOptionsFromContext(ctx); o.Consistency != metastorage.ConsistencyDefault {
+		kv = append(kv, hintConsistency, o.Consistency.String())
+		if o.Consistency == metastorage.ConsistencyBoundedStaleness {
+			kv = append(kv, hintMaxStaleness, o.MaxStaleness.String())
+		}
+	}
+	if p := metastorage.ReadPreferenceFromContext(ctx); p != metastorage.ReadDefault {
+		kv = append(kv, hintReadPreference, p.String())
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// incomingHints returns ctx with the hints of its incoming metadata.
+// Unknown values are ignored like any hint a backend cannot honour.
+func incomingHints(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if c, err := metastorage.ParseConsistencyLevel(first(hintConsistency)); err == nil && c != metastorage.ConsistencyDefault {
+		o := metastorage.ReadOptions{Consistency: c}
+		if c == metastorage.ConsistencyBoundedStaleness {
+			o.MaxStaleness, _ = time.ParseDuration(first(hintMaxStaleness))
+		}
+		ctx = metastorage.WithReadOptions(ctx, o)
+	}
+	if p, err := metastorage.ParseReadPreference(first(hintReadPreference)); err == nil && p != metastorage.ReadDefault {
+		ctx = metastorage.WithReadPreference(ctx, p)
+	}
+	return ctx
+}
+
+// hintRegistrar registers services whose handlers run with the hints of
+// the incoming metadata in their context
+type hintRegistrar struct {
+	grpc.ServiceRegistrar
+}
+
+func (r hintRegistrar) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	d := *desc
+	d.Methods = make([]grpc.MethodDesc, len(desc.Methods))
+	for i, m := range desc.Methods {
+		handler := m.Handler
+		m.Handler = func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			return handler(srv, incomingHints(ctx), dec, interceptor)
+		}
+		d.Methods[i] = m
+	}
+	d.Streams = make([]grpc.StreamDesc, len(desc.Streams))
+	for i, s := range desc.Streams {
+		handler := s.Handler
+		s.Handler = func(srv any, stream grpc.ServerStream) error {
+			return handler(srv, hintStream{stream, incomingHints(stream.Context())})
+		}
+		d.Streams[i] = s
+	}
+	r.ServiceRegistrar.RegisterService(&d, impl)
+}
+
+// hintStream is a server stream with the hints in its context
+type hintStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s hintStream) Context() context.Context {
+	return s.ctx
+}
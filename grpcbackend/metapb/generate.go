@@ -0,0 +1,5 @@
+// Package metapb contains the protobuf messages and gRPC stubs of the
+// metadata service.
+package metapb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metastorage.proto
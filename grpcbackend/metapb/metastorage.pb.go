@@ -0,0 +1,1821 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: metastorage.proto
+
+package metapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// QueueState mirrors metastorage.QueueState; values are shifted by one so
+// the zero value is never a valid state
+type QueueState int32
+
+const (
+	QueueState_QUEUE_STATE_UNSPECIFIED QueueState = 0
+	QueueState_QUEUE_STATE_INCOMING    QueueState = 1
+	QueueState_QUEUE_STATE_ACTIVE      QueueState = 2
+	QueueState_QUEUE_STATE_DEFERRED    QueueState = 3
+	QueueState_QUEUE_STATE_HOLD        QueueState = 4
+	QueueState_QUEUE_STATE_BOUNCE      QueueState = 5
+	QueueState_QUEUE_STATE_ARCHIVED    QueueState = 6
+)
+
+// Enum value maps for QueueState.
+var (
+	QueueState_name = map[int32]string{
+		0: "QUEUE_STATE_UNSPECIFIED",
+		1: "QUEUE_STATE_INCOMING",
+		2: "QUEUE_STATE_ACTIVE",
+		3: "QUEUE_STATE_DEFERRED",
+		4: "QUEUE_STATE_HOLD",
+		5: "QUEUE_STATE_BOUNCE",
+		6: "QUEUE_STATE_ARCHIVED",
+	}
+	QueueState_value = map[string]int32{
+		"QUEUE_STATE_UNSPECIFIED": 0,
+		"QUEUE_STATE_INCOMING":    1,
+		"QUEUE_STATE_ACTIVE":      2,
+		"QUEUE_STATE_DEFERRED":    3,
+		"QUEUE_STATE_HOLD":        4,
+		"QUEUE_STATE_BOUNCE":      5,
+		"QUEUE_STATE_ARCHIVED":    6,
+	}
+)
+
+func (x QueueState) Enum() *QueueState {
+	p := new(QueueState)
+	*p = x
+	return p
+}
+
+func (x QueueState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (QueueState) Descriptor() protoreflect.EnumDescriptor {
+	return file_metastorage_proto_enumTypes[0].Descriptor()
+}
+
+func (QueueState) Type() protoreflect.EnumType {
+	return &file_metastorage_proto_enumTypes[0]
+}
+
+func (x QueueState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use QueueState.Descriptor instead.
+func (QueueState) EnumDescriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{0}
+}
+
+type EventType int32
+
+const (
+	EventType_EVENT_TYPE_UNSPECIFIED   EventType = 0
+	EventType_EVENT_TYPE_STORED        EventType = 1
+	EventType_EVENT_TYPE_UPDATED       EventType = 2
+	EventType_EVENT_TYPE_DELETED       EventType = 3
+	EventType_EVENT_TYPE_MOVED         EventType = 4
+	EventType_EVENT_TYPE_LEASE_EXPIRED EventType = 5
+	EventType_EVENT_TYPE_STUCK         EventType = 6
+)
+
+// Enum value maps for EventType.
+var (
+	EventType_name = map[int32]string{
+		0: "EVENT_TYPE_UNSPECIFIED",
+		1: "EVENT_TYPE_STORED",
+		2: "EVENT_TYPE_UPDATED",
+		3: "EVENT_TYPE_DELETED",
+		4: "EVENT_TYPE_MOVED",
+		5: "EVENT_TYPE_LEASE_EXPIRED",
+		6: "EVENT_TYPE_STUCK",
+	}
+	EventType_value = map[string]int32{
+		"EVENT_TYPE_UNSPECIFIED":   0,
+		"EVENT_TYPE_STORED":        1,
+		"EVENT_TYPE_UPDATED":       2,
+		"EVENT_TYPE_DELETED":       3,
+		"EVENT_TYPE_MOVED":         4,
+		"EVENT_TYPE_LEASE_EXPIRED": 5,
+		"EVENT_TYPE_STUCK":         6,
+	}
+)
+
+func (x EventType) Enum() *EventType {
+	p := new(EventType)
+	*p = x
+	return p
+}
+
+func (x EventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_metastorage_proto_enumTypes[1].Descriptor()
+}
+
+func (EventType) Type() protoreflect.EnumType {
+	return &file_metastorage_proto_enumTypes[1]
+}
+
+func (x EventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventType.Descriptor instead.
+func (EventType) EnumDescriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{1}
+}
+
+type HourRange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	From          int32                  `protobuf:"varint,1,opt,name=from,proto3" json:"from,omitempty"`
+	To            int32                  `protobuf:"varint,2,opt,name=to,proto3" json:"to,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HourRange) Reset() {
+	*x = HourRange{}
+	mi := &file_metastorage_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HourRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HourRange) ProtoMessage() {}
+
+func (x *HourRange) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HourRange.ProtoReflect.Descriptor instead.
+func (*HourRange) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *HourRange) GetFrom() int32 {
+	if x != nil {
+		return x.From
+	}
+	return 0
+}
+
+func (x *HourRange) GetTo() int32 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+type DeliveryWindow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NotBefore     *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter      *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	Hours         *HourRange             `protobuf:"bytes,3,opt,name=hours,proto3" json:"hours,omitempty"`
+	Weekdays      []int32                `protobuf:"varint,4,rep,packed,name=weekdays,proto3" json:"weekdays,omitempty"`
+	TimeZone      string                 `protobuf:"bytes,5,opt,name=time_zone,json=timeZone,proto3" json:"time_zone,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeliveryWindow) Reset() {
+	*x = DeliveryWindow{}
+	mi := &file_metastorage_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeliveryWindow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeliveryWindow) ProtoMessage() {}
+
+func (x *DeliveryWindow) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeliveryWindow.ProtoReflect.Descriptor instead.
+func (*DeliveryWindow) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DeliveryWindow) GetNotBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotBefore
+	}
+	return nil
+}
+
+func (x *DeliveryWindow) GetNotAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotAfter
+	}
+	return nil
+}
+
+func (x *DeliveryWindow) GetHours() *HourRange {
+	if x != nil {
+		return x.Hours
+	}
+	return nil
+}
+
+func (x *DeliveryWindow) GetWeekdays() []int32 {
+	if x != nil {
+		return x.Weekdays
+	}
+	return nil
+}
+
+func (x *DeliveryWindow) GetTimeZone() string {
+	if x != nil {
+		return x.TimeZone
+	}
+	return ""
+}
+
+type MessageMetadata struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	State           QueueState             `protobuf:"varint,2,opt,name=state,proto3,enum=retryspool.meta.v1.QueueState" json:"state,omitempty"`
+	Attempts        int64                  `protobuf:"varint,3,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	MaxAttempts     int64                  `protobuf:"varint,4,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	NextRetry       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=next_retry,json=nextRetry,proto3" json:"next_retry,omitempty"`
+	Created         *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created,proto3" json:"created,omitempty"`
+	Updated         *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=updated,proto3" json:"updated,omitempty"`
+	LastError       string                 `protobuf:"bytes,8,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	Size            int64                  `protobuf:"varint,9,opt,name=size,proto3" json:"size,omitempty"`
+	Priority        int64                  `protobuf:"varint,10,opt,name=priority,proto3" json:"priority,omitempty"`
+	Headers         map[string]string      `protobuf:"bytes,11,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	RetryPolicyName string                 `protobuf:"bytes,12,opt,name=retry_policy_name,json=retryPolicyName,proto3" json:"retry_policy_name,omitempty"`
+	Sequence        uint64                 `protobuf:"varint,13,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	DeliveryWindow  *DeliveryWindow        `protobuf:"bytes,14,opt,name=delivery_window,json=deliveryWindow,proto3" json:"delivery_window,omitempty"`
+	StateEnteredAt  *timestamppb.Timestamp `protobuf:"bytes,15,opt,name=state_entered_at,json=stateEnteredAt,proto3" json:"state_entered_at,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *MessageMetadata) Reset() {
+	*x = MessageMetadata{}
+	mi := &file_metastorage_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MessageMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageMetadata) ProtoMessage() {}
+
+func (x *MessageMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageMetadata.ProtoReflect.Descriptor instead.
+func (*MessageMetadata) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *MessageMetadata) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *MessageMetadata) GetState() QueueState {
+	if x != nil {
+		return x.State
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *MessageMetadata) GetAttempts() int64 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *MessageMetadata) GetMaxAttempts() int64 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *MessageMetadata) GetNextRetry() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextRetry
+	}
+	return nil
+}
+
+func (x *MessageMetadata) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *MessageMetadata) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+func (x *MessageMetadata) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+func (x *MessageMetadata) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *MessageMetadata) GetPriority() int64 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *MessageMetadata) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *MessageMetadata) GetRetryPolicyName() string {
+	if x != nil {
+		return x.RetryPolicyName
+	}
+	return ""
+}
+
+func (x *MessageMetadata) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *MessageMetadata) GetDeliveryWindow() *DeliveryWindow {
+	if x != nil {
+		return x.DeliveryWindow
+	}
+	return nil
+}
+
+func (x *MessageMetadata) GetStateEnteredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StateEnteredAt
+	}
+	return nil
+}
+
+type StoreMetaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Metadata      *MessageMetadata       `protobuf:"bytes,2,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StoreMetaRequest) Reset() {
+	*x = StoreMetaRequest{}
+	mi := &file_metastorage_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StoreMetaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreMetaRequest) ProtoMessage() {}
+
+func (x *StoreMetaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreMetaRequest.ProtoReflect.Descriptor instead.
+func (*StoreMetaRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StoreMetaRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *StoreMetaRequest) GetMetadata() *MessageMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type StoreMetaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StoreMetaResponse) Reset() {
+	*x = StoreMetaResponse{}
+	mi := &file_metastorage_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StoreMetaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoreMetaResponse) ProtoMessage() {}
+
+func (x *StoreMetaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoreMetaResponse.ProtoReflect.Descriptor instead.
+func (*StoreMetaResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{4}
+}
+
+type GetMetaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetaRequest) Reset() {
+	*x = GetMetaRequest{}
+	mi := &file_metastorage_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetaRequest) ProtoMessage() {}
+
+func (x *GetMetaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetaRequest.ProtoReflect.Descriptor instead.
+func (*GetMetaRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetMetaRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+type GetMetaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metadata      *MessageMetadata       `protobuf:"bytes,1,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetaResponse) Reset() {
+	*x = GetMetaResponse{}
+	mi := &file_metastorage_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetaResponse) ProtoMessage() {}
+
+func (x *GetMetaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetaResponse.ProtoReflect.Descriptor instead.
+func (*GetMetaResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetMetaResponse) GetMetadata() *MessageMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type UpdateMetaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Metadata      *MessageMetadata       `protobuf:"bytes,2,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateMetaRequest) Reset() {
+	*x = UpdateMetaRequest{}
+	mi := &file_metastorage_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateMetaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateMetaRequest) ProtoMessage() {}
+
+func (x *UpdateMetaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateMetaRequest.ProtoReflect.Descriptor instead.
+func (*UpdateMetaRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *UpdateMetaRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *UpdateMetaRequest) GetMetadata() *MessageMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type UpdateMetaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateMetaResponse) Reset() {
+	*x = UpdateMetaResponse{}
+	mi := &file_metastorage_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateMetaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateMetaResponse) ProtoMessage() {}
+
+func (x *UpdateMetaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateMetaResponse.ProtoReflect.Descriptor instead.
+func (*UpdateMetaResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{8}
+}
+
+type DeleteMetaRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMetaRequest) Reset() {
+	*x = DeleteMetaRequest{}
+	mi := &file_metastorage_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMetaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMetaRequest) ProtoMessage() {}
+
+func (x *DeleteMetaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMetaRequest.ProtoReflect.Descriptor instead.
+func (*DeleteMetaRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DeleteMetaRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+type DeleteMetaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMetaResponse) Reset() {
+	*x = DeleteMetaResponse{}
+	mi := &file_metastorage_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMetaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMetaResponse) ProtoMessage() {}
+
+func (x *DeleteMetaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMetaResponse.ProtoReflect.Descriptor instead.
+func (*DeleteMetaResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{10}
+}
+
+type ListMessagesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         QueueState             `protobuf:"varint,1,opt,name=state,proto3,enum=retryspool.meta.v1.QueueState" json:"state,omitempty"`
+	Limit         int64                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int64                  `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	SortBy        string                 `protobuf:"bytes,4,opt,name=sort_by,json=sortBy,proto3" json:"sort_by,omitempty"`
+	SortOrder     string                 `protobuf:"bytes,5,opt,name=sort_order,json=sortOrder,proto3" json:"sort_order,omitempty"`
+	Since         *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=since,proto3" json:"since,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMessagesRequest) Reset() {
+	*x = ListMessagesRequest{}
+	mi := &file_metastorage_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMessagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMessagesRequest) ProtoMessage() {}
+
+func (x *ListMessagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMessagesRequest.ProtoReflect.Descriptor instead.
+func (*ListMessagesRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListMessagesRequest) GetState() QueueState {
+	if x != nil {
+		return x.State
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *ListMessagesRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListMessagesRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListMessagesRequest) GetSortBy() string {
+	if x != nil {
+		return x.SortBy
+	}
+	return ""
+}
+
+func (x *ListMessagesRequest) GetSortOrder() string {
+	if x != nil {
+		return x.SortOrder
+	}
+	return ""
+}
+
+func (x *ListMessagesRequest) GetSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+type ListMessagesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageIds    []string               `protobuf:"bytes,1,rep,name=message_ids,json=messageIds,proto3" json:"message_ids,omitempty"`
+	Total         int64                  `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	HasMore       bool                   `protobuf:"varint,3,opt,name=has_more,json=hasMore,proto3" json:"has_more,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMessagesResponse) Reset() {
+	*x = ListMessagesResponse{}
+	mi := &file_metastorage_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMessagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMessagesResponse) ProtoMessage() {}
+
+func (x *ListMessagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMessagesResponse.ProtoReflect.Descriptor instead.
+func (*ListMessagesResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListMessagesResponse) GetMessageIds() []string {
+	if x != nil {
+		return x.MessageIds
+	}
+	return nil
+}
+
+func (x *ListMessagesResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *ListMessagesResponse) GetHasMore() bool {
+	if x != nil {
+		return x.HasMore
+	}
+	return false
+}
+
+type MoveToStateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MessageId     string                 `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	FromState     QueueState             `protobuf:"varint,2,opt,name=from_state,json=fromState,proto3,enum=retryspool.meta.v1.QueueState" json:"from_state,omitempty"`
+	ToState       QueueState             `protobuf:"varint,3,opt,name=to_state,json=toState,proto3,enum=retryspool.meta.v1.QueueState" json:"to_state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MoveToStateRequest) Reset() {
+	*x = MoveToStateRequest{}
+	mi := &file_metastorage_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MoveToStateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MoveToStateRequest) ProtoMessage() {}
+
+func (x *MoveToStateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MoveToStateRequest.ProtoReflect.Descriptor instead.
+func (*MoveToStateRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MoveToStateRequest) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *MoveToStateRequest) GetFromState() QueueState {
+	if x != nil {
+		return x.FromState
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *MoveToStateRequest) GetToState() QueueState {
+	if x != nil {
+		return x.ToState
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+type MoveToStateResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MoveToStateResponse) Reset() {
+	*x = MoveToStateResponse{}
+	mi := &file_metastorage_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MoveToStateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MoveToStateResponse) ProtoMessage() {}
+
+func (x *MoveToStateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MoveToStateResponse.ProtoReflect.Descriptor instead.
+func (*MoveToStateResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{14}
+}
+
+type GetStateCountRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         QueueState             `protobuf:"varint,1,opt,name=state,proto3,enum=retryspool.meta.v1.QueueState" json:"state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStateCountRequest) Reset() {
+	*x = GetStateCountRequest{}
+	mi := &file_metastorage_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStateCountRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStateCountRequest) ProtoMessage() {}
+
+func (x *GetStateCountRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStateCountRequest.ProtoReflect.Descriptor instead.
+func (*GetStateCountRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *GetStateCountRequest) GetState() QueueState {
+	if x != nil {
+		return x.State
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+type GetStateCountResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// count is -1 when the served backend cannot count states
+	Count         int64 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStateCountResponse) Reset() {
+	*x = GetStateCountResponse{}
+	mi := &file_metastorage_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStateCountResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStateCountResponse) ProtoMessage() {}
+
+func (x *GetStateCountResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStateCountResponse.ProtoReflect.Descriptor instead.
+func (*GetStateCountResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *GetStateCountResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type ListMessagesStreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	State QueueState             `protobuf:"varint,1,opt,name=state,proto3,enum=retryspool.meta.v1.QueueState" json:"state,omitempty"`
+	// batch_size is the number of messages per streamed batch and the
+	// batch size used on the served backend's iterator
+	BatchSize     int32 `protobuf:"varint,2,opt,name=batch_size,json=batchSize,proto3" json:"batch_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMessagesStreamRequest) Reset() {
+	*x = ListMessagesStreamRequest{}
+	mi := &file_metastorage_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMessagesStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMessagesStreamRequest) ProtoMessage() {}
+
+func (x *ListMessagesStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMessagesStreamRequest.ProtoReflect.Descriptor instead.
+func (*ListMessagesStreamRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *ListMessagesStreamRequest) GetState() QueueState {
+	if x != nil {
+		return x.State
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *ListMessagesStreamRequest) GetBatchSize() int32 {
+	if x != nil {
+		return x.BatchSize
+	}
+	return 0
+}
+
+type MessageBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*MessageMetadata     `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MessageBatch) Reset() {
+	*x = MessageBatch{}
+	mi := &file_metastorage_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MessageBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageBatch) ProtoMessage() {}
+
+func (x *MessageBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageBatch.ProtoReflect.Descriptor instead.
+func (*MessageBatch) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *MessageBatch) GetMessages() []*MessageMetadata {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+type WatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_metastorage_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{19}
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          EventType              `protobuf:"varint,1,opt,name=type,proto3,enum=retryspool.meta.v1.EventType" json:"type,omitempty"`
+	MessageId     string                 `protobuf:"bytes,2,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	State         QueueState             `protobuf:"varint,3,opt,name=state,proto3,enum=retryspool.meta.v1.QueueState" json:"state,omitempty"`
+	From          QueueState             `protobuf:"varint,4,opt,name=from,proto3,enum=retryspool.meta.v1.QueueState" json:"from,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=time,proto3" json:"time,omitempty"`
+	LeaseOwner    string                 `protobuf:"bytes,6,opt,name=lease_owner,json=leaseOwner,proto3" json:"lease_owner,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_metastorage_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *Event) GetType() EventType {
+	if x != nil {
+		return x.Type
+	}
+	return EventType_EVENT_TYPE_UNSPECIFIED
+}
+
+func (x *Event) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *Event) GetState() QueueState {
+	if x != nil {
+		return x.State
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *Event) GetFrom() QueueState {
+	if x != nil {
+		return x.From
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetLeaseOwner() string {
+	if x != nil {
+		return x.LeaseOwner
+	}
+	return ""
+}
+
+type ClaimBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         QueueState             `protobuf:"varint,1,opt,name=state,proto3,enum=retryspool.meta.v1.QueueState" json:"state,omitempty"`
+	WorkerId      string                 `protobuf:"bytes,2,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	N             int32                  `protobuf:"varint,3,opt,name=n,proto3" json:"n,omitempty"`
+	LeaseMillis   int64                  `protobuf:"varint,4,opt,name=lease_millis,json=leaseMillis,proto3" json:"lease_millis,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClaimBatchRequest) Reset() {
+	*x = ClaimBatchRequest{}
+	mi := &file_metastorage_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClaimBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClaimBatchRequest) ProtoMessage() {}
+
+func (x *ClaimBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClaimBatchRequest.ProtoReflect.Descriptor instead.
+func (*ClaimBatchRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ClaimBatchRequest) GetState() QueueState {
+	if x != nil {
+		return x.State
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *ClaimBatchRequest) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *ClaimBatchRequest) GetN() int32 {
+	if x != nil {
+		return x.N
+	}
+	return 0
+}
+
+func (x *ClaimBatchRequest) GetLeaseMillis() int64 {
+	if x != nil {
+		return x.LeaseMillis
+	}
+	return 0
+}
+
+type ClaimBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*MessageMetadata     `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClaimBatchResponse) Reset() {
+	*x = ClaimBatchResponse{}
+	mi := &file_metastorage_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClaimBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClaimBatchResponse) ProtoMessage() {}
+
+func (x *ClaimBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClaimBatchResponse.ProtoReflect.Descriptor instead.
+func (*ClaimBatchResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ClaimBatchResponse) GetMessages() []*MessageMetadata {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+type MoveStateBulkRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	FromState QueueState             `protobuf:"varint,1,opt,name=from_state,json=fromState,proto3,enum=retryspool.meta.v1.QueueState" json:"from_state,omitempty"`
+	ToState   QueueState             `protobuf:"varint,2,opt,name=to_state,json=toState,proto3,enum=retryspool.meta.v1.QueueState" json:"to_state,omitempty"`
+	// filtered restricts the move to message_ids, the messages a client side
+	// filter matched
+	Filtered      bool     `protobuf:"varint,3,opt,name=filtered,proto3" json:"filtered,omitempty"`
+	MessageIds    []string `protobuf:"bytes,4,rep,name=message_ids,json=messageIds,proto3" json:"message_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MoveStateBulkRequest) Reset() {
+	*x = MoveStateBulkRequest{}
+	mi := &file_metastorage_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MoveStateBulkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MoveStateBulkRequest) ProtoMessage() {}
+
+func (x *MoveStateBulkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MoveStateBulkRequest.ProtoReflect.Descriptor instead.
+func (*MoveStateBulkRequest) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *MoveStateBulkRequest) GetFromState() QueueState {
+	if x != nil {
+		return x.FromState
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *MoveStateBulkRequest) GetToState() QueueState {
+	if x != nil {
+		return x.ToState
+	}
+	return QueueState_QUEUE_STATE_UNSPECIFIED
+}
+
+func (x *MoveStateBulkRequest) GetFiltered() bool {
+	if x != nil {
+		return x.Filtered
+	}
+	return false
+}
+
+func (x *MoveStateBulkRequest) GetMessageIds() []string {
+	if x != nil {
+		return x.MessageIds
+	}
+	return nil
+}
+
+type MoveStateBulkResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Moved         int64                  `protobuf:"varint,1,opt,name=moved,proto3" json:"moved,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MoveStateBulkResponse) Reset() {
+	*x = MoveStateBulkResponse{}
+	mi := &file_metastorage_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MoveStateBulkResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MoveStateBulkResponse) ProtoMessage() {}
+
+func (x *MoveStateBulkResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metastorage_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MoveStateBulkResponse.ProtoReflect.Descriptor instead.
+func (*MoveStateBulkResponse) Descriptor() ([]byte, []int) {
+	return file_metastorage_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *MoveStateBulkResponse) GetMoved() int64 {
+	if x != nil {
+		return x.Moved
+	}
+	return 0
+}
+
+var File_metastorage_proto protoreflect.FileDescriptor
+
+const file_metastorage_proto_rawDesc = "" +
+	"\n" +
+	"\x11metastorage.proto\x12\x12retryspool.meta.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"/\n" +
+	"\tHourRange\x12\x12\n" +
+	"\x04from\x18\x01 \x01(\x05R\x04from\x12\x0e\n" +
+	"\x02to\x18\x02 \x01(\x05R\x02to\"\xf2\x01\n" +
+	"\x0eDeliveryWindow\x129\n" +
+	"\n" +
+	"not_before\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\tnotBefore\x127\n" +
+	"\tnot_after\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\bnotAfter\x123\n" +
+	"\x05hours\x18\x03 \x01(\v2\x1d.retryspool.meta.v1.HourRangeR\x05hours\x12\x1a\n" +
+	"\bweekdays\x18\x04 \x03(\x05R\bweekdays\x12\x1b\n" +
+	"\ttime_zone\x18\x05 \x01(\tR\btimeZone\"\xef\x05\n" +
+	"\x0fMessageMetadata\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x124\n" +
+	"\x05state\x18\x02 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x05state\x12\x1a\n" +
+	"\battempts\x18\x03 \x01(\x03R\battempts\x12!\n" +
+	"\fmax_attempts\x18\x04 \x01(\x03R\vmaxAttempts\x129\n" +
+	"\n" +
+	"next_retry\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tnextRetry\x124\n" +
+	"\acreated\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\aupdated\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\x12\x1d\n" +
+	"\n" +
+	"last_error\x18\b \x01(\tR\tlastError\x12\x12\n" +
+	"\x04size\x18\t \x01(\x03R\x04size\x12\x1a\n" +
+	"\bpriority\x18\n" +
+	" \x01(\x03R\bpriority\x12J\n" +
+	"\aheaders\x18\v \x03(\v20.retryspool.meta.v1.MessageMetadata.HeadersEntryR\aheaders\x12*\n" +
+	"\x11retry_policy_name\x18\f \x01(\tR\x0fretryPolicyName\x12\x1a\n" +
+	"\bsequence\x18\r \x01(\x04R\bsequence\x12K\n" +
+	"\x0fdelivery_window\x18\x0e \x01(\v2\".retryspool.meta.v1.DeliveryWindowR\x0edeliveryWindow\x12D\n" +
+	"\x10state_entered_at\x18\x0f \x01(\v2\x1a.google.protobuf.TimestampR\x0estateEnteredAt\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"r\n" +
+	"\x10StoreMetaRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12?\n" +
+	"\bmetadata\x18\x02 \x01(\v2#.retryspool.meta.v1.MessageMetadataR\bmetadata\"\x13\n" +
+	"\x11StoreMetaResponse\"/\n" +
+	"\x0eGetMetaRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\"R\n" +
+	"\x0fGetMetaResponse\x12?\n" +
+	"\bmetadata\x18\x01 \x01(\v2#.retryspool.meta.v1.MessageMetadataR\bmetadata\"s\n" +
+	"\x11UpdateMetaRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12?\n" +
+	"\bmetadata\x18\x02 \x01(\v2#.retryspool.meta.v1.MessageMetadataR\bmetadata\"\x14\n" +
+	"\x12UpdateMetaResponse\"2\n" +
+	"\x11DeleteMetaRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\"\x14\n" +
+	"\x12DeleteMetaResponse\"\xe3\x01\n" +
+	"\x13ListMessagesRequest\x124\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x05state\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x03R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x03R\x06offset\x12\x17\n" +
+	"\asort_by\x18\x04 \x01(\tR\x06sortBy\x12\x1d\n" +
+	"\n" +
+	"sort_order\x18\x05 \x01(\tR\tsortOrder\x120\n" +
+	"\x05since\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x05since\"h\n" +
+	"\x14ListMessagesResponse\x12\x1f\n" +
+	"\vmessage_ids\x18\x01 \x03(\tR\n" +
+	"messageIds\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\x12\x19\n" +
+	"\bhas_more\x18\x03 \x01(\bR\ahasMore\"\xad\x01\n" +
+	"\x12MoveToStateRequest\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x01 \x01(\tR\tmessageId\x12=\n" +
+	"\n" +
+	"from_state\x18\x02 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\tfromState\x129\n" +
+	"\bto_state\x18\x03 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\atoState\"\x15\n" +
+	"\x13MoveToStateResponse\"L\n" +
+	"\x14GetStateCountRequest\x124\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x05state\"-\n" +
+	"\x15GetStateCountResponse\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x03R\x05count\"p\n" +
+	"\x19ListMessagesStreamRequest\x124\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x05state\x12\x1d\n" +
+	"\n" +
+	"batch_size\x18\x02 \x01(\x05R\tbatchSize\"O\n" +
+	"\fMessageBatch\x12?\n" +
+	"\bmessages\x18\x01 \x03(\v2#.retryspool.meta.v1.MessageMetadataR\bmessages\"\x0e\n" +
+	"\fWatchRequest\"\x94\x02\n" +
+	"\x05Event\x121\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1d.retryspool.meta.v1.EventTypeR\x04type\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x02 \x01(\tR\tmessageId\x124\n" +
+	"\x05state\x18\x03 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x05state\x122\n" +
+	"\x04from\x18\x04 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x04from\x12.\n" +
+	"\x04time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
+	"\vlease_owner\x18\x06 \x01(\tR\n" +
+	"leaseOwner\"\x97\x01\n" +
+	"\x11ClaimBatchRequest\x124\n" +
+	"\x05state\x18\x01 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\x05state\x12\x1b\n" +
+	"\tworker_id\x18\x02 \x01(\tR\bworkerId\x12\f\n" +
+	"\x01n\x18\x03 \x01(\x05R\x01n\x12!\n" +
+	"\flease_millis\x18\x04 \x01(\x03R\vleaseMillis\"U\n" +
+	"\x12ClaimBatchResponse\x12?\n" +
+	"\bmessages\x18\x01 \x03(\v2#.retryspool.meta.v1.MessageMetadataR\bmessages\"\xcd\x01\n" +
+	"\x14MoveStateBulkRequest\x12=\n" +
+	"\n" +
+	"from_state\x18\x01 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\tfromState\x129\n" +
+	"\bto_state\x18\x02 \x01(\x0e2\x1e.retryspool.meta.v1.QueueStateR\atoState\x12\x1a\n" +
+	"\bfiltered\x18\x03 \x01(\bR\bfiltered\x12\x1f\n" +
+	"\vmessage_ids\x18\x04 \x03(\tR\n" +
+	"messageIds\"-\n" +
+	"\x15MoveStateBulkResponse\x12\x14\n" +
+	"\x05moved\x18\x01 \x01(\x03R\x05moved*\xbd\x01\n" +
+	"\n" +
+	"QueueState\x12\x1b\n" +
+	"\x17QUEUE_STATE_UNSPECIFIED\x10\x00\x12\x18\n" +
+	"\x14QUEUE_STATE_INCOMING\x10\x01\x12\x16\n" +
+	"\x12QUEUE_STATE_ACTIVE\x10\x02\x12\x18\n" +
+	"\x14QUEUE_STATE_DEFERRED\x10\x03\x12\x14\n" +
+	"\x10QUEUE_STATE_HOLD\x10\x04\x12\x16\n" +
+	"\x12QUEUE_STATE_BOUNCE\x10\x05\x12\x18\n" +
+	"\x14QUEUE_STATE_ARCHIVED\x10\x06*\xb8\x01\n" +
+	"\tEventType\x12\x1a\n" +
+	"\x16EVENT_TYPE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11EVENT_TYPE_STORED\x10\x01\x12\x16\n" +
+	"\x12EVENT_TYPE_UPDATED\x10\x02\x12\x16\n" +
+	"\x12EVENT_TYPE_DELETED\x10\x03\x12\x14\n" +
+	"\x10EVENT_TYPE_MOVED\x10\x04\x12\x1c\n" +
+	"\x18EVENT_TYPE_LEASE_EXPIRED\x10\x05\x12\x14\n" +
+	"\x10EVENT_TYPE_STUCK\x10\x062\x92\b\n" +
+	"\vMetaStorage\x12X\n" +
+	"\tStoreMeta\x12$.retryspool.meta.v1.StoreMetaRequest\x1a%.retryspool.meta.v1.StoreMetaResponse\x12R\n" +
+	"\aGetMeta\x12\".retryspool.meta.v1.GetMetaRequest\x1a#.retryspool.meta.v1.GetMetaResponse\x12[\n" +
+	"\n" +
+	"UpdateMeta\x12%.retryspool.meta.v1.UpdateMetaRequest\x1a&.retryspool.meta.v1.UpdateMetaResponse\x12[\n" +
+	"\n" +
+	"DeleteMeta\x12%.retryspool.meta.v1.DeleteMetaRequest\x1a&.retryspool.meta.v1.DeleteMetaResponse\x12a\n" +
+	"\fListMessages\x12'.retryspool.meta.v1.ListMessagesRequest\x1a(.retryspool.meta.v1.ListMessagesResponse\x12^\n" +
+	"\vMoveToState\x12&.retryspool.meta.v1.MoveToStateRequest\x1a'.retryspool.meta.v1.MoveToStateResponse\x12d\n" +
+	"\rGetStateCount\x12(.retryspool.meta.v1.GetStateCountRequest\x1a).retryspool.meta.v1.GetStateCountResponse\x12g\n" +
+	"\x12ListMessagesStream\x12-.retryspool.meta.v1.ListMessagesStreamRequest\x1a .retryspool.meta.v1.MessageBatch0\x01\x12F\n" +
+	"\x05Watch\x12 .retryspool.meta.v1.WatchRequest\x1a\x19.retryspool.meta.v1.Event0\x01\x12[\n" +
+	"\n" +
+	"ClaimBatch\x12%.retryspool.meta.v1.ClaimBatchRequest\x1a&.retryspool.meta.v1.ClaimBatchResponse\x12d\n" +
+	"\rMoveStateBulk\x12(.retryspool.meta.v1.MoveStateBulkRequest\x1a).retryspool.meta.v1.MoveStateBulkResponseB:Z8schneider.vip/retryspool/storage/meta/grpcbackend/metapbb\x06proto3"
+
+var (
+	file_metastorage_proto_rawDescOnce sync.Once
+	file_metastorage_proto_rawDescData []byte
+)
+
+func file_metastorage_proto_rawDescGZIP() []byte {
+	file_metastorage_proto_rawDescOnce.Do(func() {
+		file_metastorage_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_metastorage_proto_rawDesc), len(file_metastorage_proto_rawDesc)))
+	})
+	return file_metastorage_proto_rawDescData
+}
+
+var file_metastorage_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_metastorage_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_metastorage_proto_goTypes = []any{
+	(QueueState)(0),                   // 0: retryspool.meta.v1.QueueState
+	(EventType)(0),                    // 1: retryspool.meta.v1.EventType
+	(*HourRange)(nil),                 // 2: retryspool.meta.v1.HourRange
+	(*DeliveryWindow)(nil),            // 3: retryspool.meta.v1.DeliveryWindow
+	(*MessageMetadata)(nil),           // 4: retryspool.meta.v1.MessageMetadata
+	(*StoreMetaRequest)(nil),          // 5: retryspool.meta.v1.StoreMetaRequest
+	(*StoreMetaResponse)(nil),         // 6: retryspool.meta.v1.StoreMetaResponse
+	(*GetMetaRequest)(nil),            // 7: retryspool.meta.v1.GetMetaRequest
+	(*GetMetaResponse)(nil),           // 8: retryspool.meta.v1.GetMetaResponse
+	(*UpdateMetaRequest)(nil),         // 9: retryspool.meta.v1.UpdateMetaRequest
+	(*UpdateMetaResponse)(nil),        // 10: retryspool.meta.v1.UpdateMetaResponse
+	(*DeleteMetaRequest)(nil),         // 11: retryspool.meta.v1.DeleteMetaRequest
+	(*DeleteMetaResponse)(nil),        // 12: retryspool.meta.v1.DeleteMetaResponse
+	(*ListMessagesRequest)(nil),       // 13: retryspool.meta.v1.ListMessagesRequest
+	(*ListMessagesResponse)(nil),      // 14: retryspool.meta.v1.ListMessagesResponse
+	(*MoveToStateRequest)(nil),        // 15: retryspool.meta.v1.MoveToStateRequest
+	(*MoveToStateResponse)(nil),       // 16: retryspool.meta.v1.MoveToStateResponse
+	(*GetStateCountRequest)(nil),      // 17: retryspool.meta.v1.GetStateCountRequest
+	(*GetStateCountResponse)(nil),     // 18: retryspool.meta.v1.GetStateCountResponse
+	(*ListMessagesStreamRequest)(nil), // 19: retryspool.meta.v1.ListMessagesStreamRequest
+	(*MessageBatch)(nil),              // 20: retryspool.meta.v1.MessageBatch
+	(*WatchRequest)(nil),              // 21: retryspool.meta.v1.WatchRequest
+	(*Event)(nil),                     // 22: retryspool.meta.v1.Event
+	(*ClaimBatchRequest)(nil),         // 23: retryspool.meta.v1.ClaimBatchRequest
+	(*ClaimBatchResponse)(nil),        // 24: retryspool.meta.v1.ClaimBatchResponse
+	(*MoveStateBulkRequest)(nil),      // 25: retryspool.meta.v1.MoveStateBulkRequest
+	(*MoveStateBulkResponse)(nil),     // 26: retryspool.meta.v1.MoveStateBulkResponse
+	nil,                               // 27: retryspool.meta.v1.MessageMetadata.HeadersEntry
+	(*timestamppb.Timestamp)(nil),     // 28: google.protobuf.Timestamp
+}
+var file_metastorage_proto_depIdxs = []int32{
+	28, // 0: retryspool.meta.v1.DeliveryWindow.not_before:type_name -> google.protobuf.Timestamp
+	28, // 1: retryspool.meta.v1.DeliveryWindow.not_after:type_name -> google.protobuf.Timestamp
+	2,  // 2: retryspool.meta.v1.DeliveryWindow.hours:type_name -> retryspool.meta.v1.HourRange
+	0,  // 3: retryspool.meta.v1.MessageMetadata.state:type_name -> retryspool.meta.v1.QueueState
+	28, // 4: retryspool.meta.v1.MessageMetadata.next_retry:type_name -> google.protobuf.Timestamp
+	28, // 5: retryspool.meta.v1.MessageMetadata.created:type_name -> google.protobuf.Timestamp
+	28, // 6: retryspool.meta.v1.MessageMetadata.updated:type_name -> google.protobuf.Timestamp
+	27, // 7: retryspool.meta.v1.MessageMetadata.headers:type_name -> retryspool.meta.v1.MessageMetadata.HeadersEntry
+	3,  // 8: retryspool.meta.v1.MessageMetadata.delivery_window:type_name -> retryspool.meta.v1.DeliveryWindow
+	28, // 9: retryspool.meta.v1.MessageMetadata.state_entered_at:type_name -> google.protobuf.Timestamp
+	4,  // 10: retryspool.meta.v1.StoreMetaRequest.metadata:type_name -> retryspool.meta.v1.MessageMetadata
+	4,  // 11: retryspool.meta.v1.GetMetaResponse.metadata:type_name -> retryspool.meta.v1.MessageMetadata
+	4,  // 12: retryspool.meta.v1.UpdateMetaRequest.metadata:type_name -> retryspool.meta.v1.MessageMetadata
+	0,  // 13: retryspool.meta.v1.ListMessagesRequest.state:type_name -> retryspool.meta.v1.QueueState
+	28, // 14: retryspool.meta.v1.ListMessagesRequest.since:type_name -> google.protobuf.Timestamp
+	0,  // 15: retryspool.meta.v1.MoveToStateRequest.from_state:type_name -> retryspool.meta.v1.QueueState
+	0,  // 16: retryspool.meta.v1.MoveToStateRequest.to_state:type_name -> retryspool.meta.v1.QueueState
+	0,  // 17: retryspool.meta.v1.GetStateCountRequest.state:type_name -> retryspool.meta.v1.QueueState
+	0,  // 18: retryspool.meta.v1.ListMessagesStreamRequest.state:type_name -> retryspool.meta.v1.QueueState
+	4,  // 19: retryspool.meta.v1.MessageBatch.messages:type_name -> retryspool.meta.v1.MessageMetadata
+	1,  // 20: retryspool.meta.v1.Event.type:type_name -> retryspool.meta.v1.EventType
+	0,  // 21: retryspool.meta.v1.Event.state:type_name -> retryspool.meta.v1.QueueState
+	0,  // 22: retryspool.meta.v1.Event.from:type_name -> retryspool.meta.v1.QueueState
+	28, // 23: retryspool.meta.v1.Event.time:type_name -> google.protobuf.Timestamp
+	0,  // 24: retryspool.meta.v1.ClaimBatchRequest.state:type_name -> retryspool.meta.v1.QueueState
+	4,  // 25: retryspool.meta.v1.ClaimBatchResponse.messages:type_name -> retryspool.meta.v1.MessageMetadata
+	0,  // 26: retryspool.meta.v1.MoveStateBulkRequest.from_state:type_name -> retryspool.meta.v1.QueueState
+	0,  // 27: retryspool.meta.v1.MoveStateBulkRequest.to_state:type_name -> retryspool.meta.v1.QueueState
+	5,  // 28: retryspool.meta.v1.MetaStorage.StoreMeta:input_type -> retryspool.meta.v1.StoreMetaRequest
+	7,  // 29: retryspool.meta.v1.MetaStorage.GetMeta:input_type -> retryspool.meta.v1.GetMetaRequest
+	9,  // 30: retryspool.meta.v1.MetaStorage.UpdateMeta:input_type -> retryspool.meta.v1.UpdateMetaRequest
+	11, // 31: retryspool.meta.v1.MetaStorage.DeleteMeta:input_type -> retryspool.meta.v1.DeleteMetaRequest
+	13, // 32: retryspool.meta.v1.MetaStorage.ListMessages:input_type -> retryspool.meta.v1.ListMessagesRequest
+	15, // 33: retryspool.meta.v1.MetaStorage.MoveToState:input_type -> retryspool.meta.v1.MoveToStateRequest
+	17, // 34: retryspool.meta.v1.MetaStorage.GetStateCount:input_type -> retryspool.meta.v1.GetStateCountRequest
+	19, // 35: retryspool.meta.v1.MetaStorage.ListMessagesStream:input_type -> retryspool.meta.v1.ListMessagesStreamRequest
+	21, // 36: retryspool.meta.v1.MetaStorage.Watch:input_type -> retryspool.meta.v1.WatchRequest
+	23, // 37: retryspool.meta.v1.MetaStorage.ClaimBatch:input_type -> retryspool.meta.v1.ClaimBatchRequest
+	25, // 38: retryspool.meta.v1.MetaStorage.MoveStateBulk:input_type -> retryspool.meta.v1.MoveStateBulkRequest
+	6,  // 39: retryspool.meta.v1.MetaStorage.StoreMeta:output_type -> retryspool.meta.v1.StoreMetaResponse
+	8,  // 40: retryspool.meta.v1.MetaStorage.GetMeta:output_type -> retryspool.meta.v1.GetMetaResponse
+	10, // 41: retryspool.meta.v1.MetaStorage.UpdateMeta:output_type -> retryspool.meta.v1.UpdateMetaResponse
+	12, // 42: retryspool.meta.v1.MetaStorage.DeleteMeta:output_type -> retryspool.meta.v1.DeleteMetaResponse
+	14, // 43: retryspool.meta.v1.MetaStorage.ListMessages:output_type -> retryspool.meta.v1.ListMessagesResponse
+	16, // 44: retryspool.meta.v1.MetaStorage.MoveToState:output_type -> retryspool.meta.v1.MoveToStateResponse
+	18, // 45: retryspool.meta.v1.MetaStorage.GetStateCount:output_type -> retryspool.meta.v1.GetStateCountResponse
+	20, // 46: retryspool.meta.v1.MetaStorage.ListMessagesStream:output_type -> retryspool.meta.v1.MessageBatch
+	22, // 47: retryspool.meta.v1.MetaStorage.Watch:output_type -> retryspool.meta.v1.Event
+	24, // 48: retryspool.meta.v1.MetaStorage.ClaimBatch:output_type -> retryspool.meta.v1.ClaimBatchResponse
+	26, // 49: retryspool.meta.v1.MetaStorage.MoveStateBulk:output_type -> retryspool.meta.v1.MoveStateBulkResponse
+	39, // [39:50] is the sub-list for method output_type
+	28, // [28:39] is the sub-list for method input_type
+	28, // [28:28] is the sub-list for extension type_name
+	28, // [28:28] is the sub-list for extension extendee
+	0,  // [0:28] is the sub-list for field type_name
+}
+
+func init() { file_metastorage_proto_init() }
+func file_metastorage_proto_init() {
+	if File_metastorage_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_metastorage_proto_rawDesc), len(file_metastorage_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   26,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_metastorage_proto_goTypes,
+		DependencyIndexes: file_metastorage_proto_depIdxs,
+		EnumInfos:         file_metastorage_proto_enumTypes,
+		MessageInfos:      file_metastorage_proto_msgTypes,
+	}.Build()
+	File_metastorage_proto = out.File
+	file_metastorage_proto_goTypes = nil
+	file_metastorage_proto_depIdxs = nil
+}
@@ -0,0 +1,59 @@
+package grpcbackend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/failover"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+func init() {
+	registry.Register("grpc", open)
+}
+
+// open handles "grpc://host:port" DSNs. Transport settings can be given
+// as query parameters, e.g. "grpc://meta:7070?compression=zstd&keepalive=30s".
+//
+// Replicas are listed in the failover parameter and tried in order after
+// the host; routing=latency prefers the fastest healthy replica instead:
+// "grpc://meta1:7070?failover=meta2:7070,meta3:7070&routing=latency".
+//
+// max_in_flight and bulk_share enable the call scheduler of the clients,
+// e.g. "grpc://meta:7070?max_in_flight=64&bulk_share=0.25".
+func open(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	q := dsn.Query()
+	t, err := transportFromQuery(q)
+	if err != nil {
+		return nil, fmt.Errorf("grpc DSN: %w", err)
+	}
+	opts = append(opts, WithTransport(t))
+	sched, err := schedulerFromQuery(q)
+	if err != nil {
+		return nil, fmt.Errorf("grpc DSN: %w", err)
+	}
+	opts = append(opts, sched...)
+
+	replicas := q.Get("failover")
+	if replicas == "" {
+		return Dial(dsn.Host, opts...)
+	}
+	targets := []string{dsn.Host}
+	for _, r := range strings.Split(replicas, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			targets = append(targets, r)
+		}
+	}
+	switch routing := q.Get("routing"); routing {
+	case "", "priority":
+	case "latency":
+		opts = append(opts, failover.WithLatencyRouting(true))
+	default:
+		return nil, fmt.Errorf("grpc DSN: unknown routing %q", routing)
+	}
+	return DialFailover(targets, opts...)
+}
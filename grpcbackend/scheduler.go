@@ -0,0 +1,218 @@
+package grpcbackend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricQueueWait is the time calls waited for a slot of the client
+// scheduler in seconds, labeled by priority
+const MetricQueueWait = "metastorage_grpc_queue_wait_seconds"
+
+// DefaultBulkShare is the share of the in-flight slots bulk calls may use
+const DefaultBulkShare = 0.5
+
+// Priority classifies client calls for the scheduler
+type Priority int
+
+const (
+	// PriorityCritical is used for calls on the delivery path: claims,
+	// moves and single message reads and writes
+	PriorityCritical Priority = iota
+	// PriorityBulk is used for scans: ListMessages, iterator streams and
+	// state counts
+	PriorityBulk
+)
+
+// String returns the metric label of p
+func (p Priority) String() string {
+	if p == PriorityBulk {
+		return "bulk"
+	}
+	return "critical"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose calls are scheduled with p instead
+// of the priority of the operation, e.g. to mark the GetMeta calls of an
+// export as bulk traffic
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityOf returns the priority set with WithPriority, or def
+func priorityOf(ctx context.Context, def Priority) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return def
+}
+
+type (
+	maxInFlightKey struct{}
+	bulkShareKey   struct{}
+)
+
+// WithMaxInFlight limits the calls a client runs concurrently on its
+// connection. Calls beyond the limit queue, and critical calls are
+// dispatched before bulk ones. 0 (the default) disables scheduling.
+func WithMaxInFlight(n int) options.Option {
+	return options.WithValue(maxInFlightKey{}, n)
+}
+
+// WithBulkShare sets the share of the in-flight slots bulk calls may use,
+// keeping the rest free for critical calls (default DefaultBulkShare).
+// Bulk calls always get at least one slot.
+func WithBulkShare(share float64) options.Option {
+	return options.WithValue(bulkShareKey{}, share)
+}
+
+// scheduler hands out in-flight slots of a client, critical calls first.
+// Waiters of a priority are served in FIFO order.
+type scheduler struct {
+	limit     int
+	bulkLimit int
+	clock     clock.Clock
+	metrics   metrics.Recorder
+
+	mu       sync.Mutex
+	inFlight int
+	bulk     int          // bulk calls in flight
+	queues   [2][]*waiter // indexed by Priority
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// newScheduler returns the scheduler configured in o, nil if scheduling
+// is disabled
+func newScheduler(o options.Options) *scheduler {
+	limit := options.ValueOr(o, maxInFlightKey{}, 0)
+	if limit <= 0 {
+		return nil
+	}
+	share := options.ValueOr(o, bulkShareKey{}, DefaultBulkShare)
+	return &scheduler{
+		limit:     limit,
+		bulkLimit: min(max(int(float64(limit)*share), 1), limit),
+		clock:     o.Clock,
+		metrics:   o.Metrics,
+	}
+}
+
+// acquire waits for a slot for a call of priority p and returns the
+// function releasing it. A nil scheduler admits every call.
+func (s *scheduler) acquire(ctx context.Context, p Priority) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	release := func() { s.release(p) }
+	start := s.clock.Now()
+	s.mu.Lock()
+	// bulk calls must not overtake queued critical calls
+	if len(s.queues[PriorityCritical]) == 0 && len(s.queues[p]) == 0 && s.fits(p) {
+		s.take(p)
+		s.mu.Unlock()
+		s.observe(p, start)
+		return release, nil
+	}
+	w := &waiter{ready: make(chan struct{})}
+	s.queues[p] = append(s.queues[p], w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		s.observe(p, start)
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.granted {
+			s.mu.Unlock()
+			s.release(p)
+			return nil, ctx.Err()
+		}
+		s.queues[p] = slices.DeleteFunc(s.queues[p], func(q *waiter) bool { return q == w })
+		// a cancelled critical waiter may have been holding back bulk calls
+		s.dispatch()
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *scheduler) release(p Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if p == PriorityBulk {
+		s.bulk--
+	}
+	s.dispatch()
+}
+
+// fits reports whether a call of priority p may start now
+func (s *scheduler) fits(p Priority) bool {
+	if p == PriorityBulk && s.bulk >= s.bulkLimit {
+		return false
+	}
+	return s.inFlight < s.limit
+}
+
+func (s *scheduler) take(p Priority) {
+	s.inFlight++
+	if p == PriorityBulk {
+		s.bulk++
+	}
+}
+
+// dispatch grants free slots to the queued calls, critical ones first
+func (s *scheduler) dispatch() {
+	for _, p := range []Priority{PriorityCritical, PriorityBulk} {
+		for len(s.queues[p]) > 0 && s.fits(p) {
+			w := s.queues[p][0]
+			s.queues[p] = s.queues[p][1:]
+			s.take(p)
+			w.granted = true
+			close(w.ready)
+		}
+		if len(s.queues[p]) > 0 {
+			return
+		}
+	}
+}
+
+func (s *scheduler) observe(p Priority, start time.Time) {
+	s.metrics.Histogram(MetricQueueWait, metrics.Labels{"priority": p.String()}, s.clock.Now().Sub(start).Seconds())
+}
+
+// schedulerFromQuery reads the scheduler settings from DSN query
+// parameters: max_in_flight and bulk_share
+func schedulerFromQuery(q url.Values) ([]options.Option, error) {
+	var opts []options.Option
+	if v := q.Get("max_in_flight"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("max_in_flight: invalid value %q", v)
+		}
+		opts = append(opts, WithMaxInFlight(n))
+	}
+	if v := q.Get("bulk_share"); v != "" {
+		share, err := strconv.ParseFloat(v, 64)
+		if err != nil || share <= 0 || share > 1 {
+			return nil, fmt.Errorf("bulk_share: must be in (0, 1], got %q", v)
+		}
+		opts = append(opts, WithBulkShare(share))
+	}
+	return opts, nil
+}
@@ -0,0 +1,269 @@
+// Package grpcbackend serves a metadata backend over gRPC and provides a
+// client implementing metastorage.Backend on top of that service, so the
+// metadata of many spool nodes can live behind one central service.
+package grpcbackend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/grpcbackend/metapb"
+	"schneider.vip/retryspool/storage/meta/idcodec"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MaxStreamBatchSize caps the batch size a client may request for
+// ListMessagesStream
+const MaxStreamBatchSize = 1000
+
+// serverTimeHeader carries the service time in GetStateCount responses
+const serverTimeHeader = "x-meta-server-time"
+
+type idCodecKey struct{}
+
+// WithIDCodec makes the server expose message IDs as tokens of codec:
+// responses and events carry encoded IDs and requests must reference
+// messages by token. A request with an invalid token fails with
+// NotFound. Clients storing new messages need a server without a codec,
+// as they cannot create valid tokens.
+func WithIDCodec(codec idcodec.Codec) options.Option {
+	return options.WithValue(idCodecKey{}, codec)
+}
+
+// Server implements the MetaStorage gRPC service on top of a backend
+type Server struct {
+	metapb.UnimplementedMetaStorageServer
+	backend   metastorage.Backend
+	batchSize int
+	clock     clock.Clock
+	ids       idcodec.Codec
+}
+
+// NewServer creates a service for backend. options.WithBatchSize sets the
+// stream batch size used when a client does not request one.
+func NewServer(backend metastorage.Backend, opts ...options.Option) *Server {
+	o := options.Apply(opts...)
+	return &Server{
+		backend:   backend,
+		batchSize: o.BatchSize,
+		clock:     o.Clock,
+		ids:       options.ValueOr(o, idCodecKey{}, idcodec.Identity),
+	}
+}
+
+// decodeID returns the message ID referenced by a request
+func (s *Server) decodeID(token string) (string, error) {
+	id, err := s.ids.Decode(token)
+	if err != nil {
+		// a forged token references no message
+		return "", toStatus(fmt.Errorf("%w: %w", metastorage.ErrMessageNotFound, err))
+	}
+	return id, nil
+}
+
+// metaFromPB converts request metadata referenced by token
+func (s *Server) metaFromPB(pb *metapb.MessageMetadata, token, id string) metastorage.MessageMetadata {
+	m := metaFromPB(pb)
+	if m.ID == token {
+		m.ID = id
+	}
+	return m
+}
+
+// metaToPB converts metadata for a response, with the ID encoded
+func (s *Server) metaToPB(m metastorage.MessageMetadata) *metapb.MessageMetadata {
+	m.ID = s.ids.Encode(m.ID)
+	return metaToPB(m)
+}
+
+// Register registers the service on gs. Calls reach the backend with the
+// consistency and read preference hints sent by the client in their
+// context.
+func (s *Server) Register(gs grpc.ServiceRegistrar) {
+	metapb.RegisterMetaStorageServer(hintRegistrar{gs}, s)
+}
+
+func validState(st metapb.QueueState) error {
+	if st == metapb.QueueState_QUEUE_STATE_UNSPECIFIED {
+		return status.Error(codes.InvalidArgument, "queue state not specified")
+	}
+	if _, ok := metapb.QueueState_name[int32(st)]; !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown queue state %d", st)
+	}
+	return nil
+}
+
+// StoreMeta stores message metadata
+func (s *Server) StoreMeta(ctx context.Context, req *metapb.StoreMetaRequest) (*metapb.StoreMetaResponse, error) {
+	if err := validState(req.GetMetadata().GetState()); err != nil {
+		return nil, err
+	}
+	id, err := s.decodeID(req.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+	err = s.backend.StoreMeta(ctx, id, s.metaFromPB(req.GetMetadata(), req.GetMessageId(), id))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &metapb.StoreMetaResponse{}, nil
+}
+
+// GetMeta retrieves message metadata
+func (s *Server) GetMeta(ctx context.Context, req *metapb.GetMetaRequest) (*metapb.GetMetaResponse, error) {
+	id, err := s.decodeID(req.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.backend.GetMeta(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &metapb.GetMetaResponse{Metadata: s.metaToPB(m)}, nil
+}
+
+// UpdateMeta updates message metadata
+func (s *Server) UpdateMeta(ctx context.Context, req *metapb.UpdateMetaRequest) (*metapb.UpdateMetaResponse, error) {
+	if err := validState(req.GetMetadata().GetState()); err != nil {
+		return nil, err
+	}
+	id, err := s.decodeID(req.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+	err = s.backend.UpdateMeta(ctx, id, s.metaFromPB(req.GetMetadata(), req.GetMessageId(), id))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &metapb.UpdateMetaResponse{}, nil
+}
+
+// DeleteMeta removes message metadata
+func (s *Server) DeleteMeta(ctx context.Context, req *metapb.DeleteMetaRequest) (*metapb.DeleteMetaResponse, error) {
+	id, err := s.decodeID(req.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.DeleteMeta(ctx, id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &metapb.DeleteMetaResponse{}, nil
+}
+
+// ListMessages lists messages with pagination and filtering
+func (s *Server) ListMessages(ctx context.Context, req *metapb.ListMessagesRequest) (*metapb.ListMessagesResponse, error) {
+	if err := validState(req.GetState()); err != nil {
+		return nil, err
+	}
+	res, err := s.backend.ListMessages(ctx, stateFromPB(req.GetState()), metastorage.MessageListOptions{
+		Limit:     int(req.GetLimit()),
+		Offset:    int(req.GetOffset()),
+		SortBy:    req.GetSortBy(),
+		SortOrder: req.GetSortOrder(),
+		Since:     timeFromPB(req.GetSince()),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	ids := make([]string, len(res.MessageIDs))
+	for i, id := range res.MessageIDs {
+		ids[i] = s.ids.Encode(id)
+	}
+	return &metapb.ListMessagesResponse{MessageIds: ids, Total: int64(res.Total), HasMore: res.HasMore}, nil
+}
+
+// MoveToState moves a message between states with CAS semantics
+func (s *Server) MoveToState(ctx context.Context, req *metapb.MoveToStateRequest) (*metapb.MoveToStateResponse, error) {
+	if err := validState(req.GetFromState()); err != nil {
+		return nil, err
+	}
+	if err := validState(req.GetToState()); err != nil {
+		return nil, err
+	}
+	id, err := s.decodeID(req.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+	err = s.backend.MoveToState(ctx, id, stateFromPB(req.GetFromState()), stateFromPB(req.GetToState()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &metapb.MoveToStateResponse{}, nil
+}
+
+// GetStateCount returns the state count, or -1 if the backend does not
+// implement metastorage.StateCounterBackend. The server time is sent in
+// the serverTimeHeader response header, see Client.ServerTime.
+func (s *Server) GetStateCount(ctx context.Context, req *metapb.GetStateCountRequest) (*metapb.GetStateCountResponse, error) {
+	if err := validState(req.GetState()); err != nil {
+		return nil, err
+	}
+	now, err := s.serverTime(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(serverTimeHeader, now.Format(time.RFC3339Nano)))
+	counter, ok := metastorage.As[metastorage.StateCounterBackend](s.backend)
+	if !ok {
+		return &metapb.GetStateCountResponse{Count: -1}, nil
+	}
+	return &metapb.GetStateCountResponse{Count: counter.GetStateCount(stateFromPB(req.GetState()))}, nil
+}
+
+// serverTime returns the time of the served backend's server if it
+// implements metastorage.ServerTimeBackend, and the local time otherwise
+func (s *Server) serverTime(ctx context.Context) (time.Time, error) {
+	if st, ok := metastorage.As[metastorage.ServerTimeBackend](s.backend); ok {
+		return st.ServerTime(ctx)
+	}
+	return s.clock.Now().UTC(), nil
+}
+
+// ListMessagesStream walks the state with a backend iterator and sends
+// one batch per message of the stream. Send blocks while the client is
+// behind, so a slow reader holds at most a few batches in memory.
+func (s *Server) ListMessagesStream(req *metapb.ListMessagesStreamRequest, stream metapb.MetaStorage_ListMessagesStreamServer) error {
+	if err := validState(req.GetState()); err != nil {
+		return err
+	}
+	batchSize := int(req.GetBatchSize())
+	if batchSize <= 0 {
+		batchSize = s.batchSize
+	}
+	batchSize = min(batchSize, MaxStreamBatchSize)
+
+	ctx := stream.Context()
+	it, err := s.backend.NewMessageIterator(ctx, stateFromPB(req.GetState()), batchSize)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer it.Close()
+
+	batch := make([]*metapb.MessageMetadata, 0, batchSize)
+	for {
+		m, hasMore, err := it.Next(ctx)
+		if err != nil {
+			return toStatus(err)
+		}
+		if hasMore {
+			batch = append(batch, s.metaToPB(m))
+		}
+		if len(batch) == batchSize || (!hasMore && len(batch) > 0) {
+			if err := stream.Send(&metapb.MessageBatch{Messages: batch}); err != nil {
+				return err
+			}
+			batch = make([]*metapb.MessageMetadata, 0, batchSize)
+		}
+		if !hasMore {
+			return nil
+		}
+	}
+}
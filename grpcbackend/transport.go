@@ -0,0 +1,136 @@
+package grpcbackend
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Gzip is the name of the gzip compressor
+const Gzip = gzip.Name
+
+// Transport tunes the gRPC connection for WAN links between spool nodes
+// and a central metadata service. The zero value keeps the gRPC defaults.
+type Transport struct {
+	// Compression selects the compressor for requests: Gzip, Zstd or ""
+	// for none. Servers answer with the compressor the client used, so it
+	// only needs to be set on clients.
+	Compression string
+
+	// KeepaliveTime is the idle time after which a ping is sent to detect
+	// dead connections, e.g. behind NAT gateways dropping idle flows
+	KeepaliveTime time.Duration
+
+	// KeepaliveTimeout is how long to wait for the ping ack before the
+	// connection is considered dead
+	KeepaliveTimeout time.Duration
+
+	// InitialWindowSize and InitialConnWindowSize set the HTTP/2 flow
+	// control windows per stream and per connection. Raising them above
+	// the 64KiB default improves streaming throughput on high latency
+	// links. Values below 64KiB are ignored by gRPC.
+	InitialWindowSize     int32
+	InitialConnWindowSize int32
+}
+
+type transportKey struct{}
+
+// WithTransport sets the transport tuning used by Dial and ServerOptions
+func WithTransport(t Transport) options.Option {
+	return options.WithValue(transportKey{}, t)
+}
+
+// Validate checks that the compressor is registered
+func (t Transport) Validate() error {
+	if t.Compression != "" && encoding.GetCompressor(t.Compression) == nil {
+		return fmt.Errorf("grpc transport: unknown compression %q", t.Compression)
+	}
+	if t.KeepaliveTime < 0 || t.KeepaliveTimeout < 0 {
+		return fmt.Errorf("grpc transport: negative keepalive")
+	}
+	return nil
+}
+
+// DialOptions returns the client side gRPC options for t
+func (t Transport) DialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if t.Compression != "" {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(t.Compression)))
+	}
+	if t.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                t.KeepaliveTime,
+			Timeout:             t.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	if t.InitialWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(t.InitialWindowSize))
+	}
+	if t.InitialConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(t.InitialConnWindowSize))
+	}
+	return opts
+}
+
+// ServerOptions returns the gRPC server options for the transport set
+// with WithTransport. Servers accept client pings as often as
+// KeepaliveTime; clients with a shorter interval are disconnected.
+func ServerOptions(opts ...options.Option) []grpc.ServerOption {
+	t := options.ValueOr(options.Apply(opts...), transportKey{}, Transport{})
+	var sopts []grpc.ServerOption
+	if t.KeepaliveTime > 0 {
+		sopts = append(sopts,
+			grpc.KeepaliveParams(keepalive.ServerParameters{Time: t.KeepaliveTime, Timeout: t.KeepaliveTimeout}),
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: t.KeepaliveTime, PermitWithoutStream: true}),
+		)
+	}
+	if t.InitialWindowSize > 0 {
+		sopts = append(sopts, grpc.InitialWindowSize(t.InitialWindowSize))
+	}
+	if t.InitialConnWindowSize > 0 {
+		sopts = append(sopts, grpc.InitialConnWindowSize(t.InitialConnWindowSize))
+	}
+	return sopts
+}
+
+// transportFromQuery reads transport settings from DSN query parameters:
+// compression, keepalive, keepalive_timeout, window_size and
+// conn_window_size
+func transportFromQuery(q url.Values) (Transport, error) {
+	t := Transport{Compression: q.Get("compression")}
+	var err error
+	if v := q.Get("keepalive"); v != "" {
+		if t.KeepaliveTime, err = time.ParseDuration(v); err != nil {
+			return t, fmt.Errorf("keepalive: %w", err)
+		}
+	}
+	if v := q.Get("keepalive_timeout"); v != "" {
+		if t.KeepaliveTimeout, err = time.ParseDuration(v); err != nil {
+			return t, fmt.Errorf("keepalive_timeout: %w", err)
+		}
+	}
+	if v := q.Get("window_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return t, fmt.Errorf("window_size: %w", err)
+		}
+		t.InitialWindowSize = int32(n)
+	}
+	if v := q.Get("conn_window_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return t, fmt.Errorf("conn_window_size: %w", err)
+		}
+		t.InitialConnWindowSize = int32(n)
+	}
+	return t, t.Validate()
+}
@@ -0,0 +1,117 @@
+package grpcbackend
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/grpcbackend/metapb"
+)
+
+func eventToPB(e metastorage.Event) *metapb.Event {
+	pb := &metapb.Event{
+		Type:       metapb.EventType(e.Type),
+		MessageId:  e.MessageID,
+		Time:       timeToPB(e.Time),
+		LeaseOwner: e.LeaseOwner,
+	}
+	if e.Type != metastorage.EventDeleted {
+		pb.State = stateToPB(e.State)
+	}
+	if e.Type == metastorage.EventMoved {
+		pb.From = stateToPB(e.From)
+	}
+	return pb
+}
+
+func eventFromPB(pb *metapb.Event) metastorage.Event {
+	e := metastorage.Event{
+		Type:       metastorage.EventType(pb.GetType()),
+		MessageID:  pb.GetMessageId(),
+		Time:       timeFromPB(pb.GetTime()),
+		LeaseOwner: pb.GetLeaseOwner(),
+	}
+	if pb.GetState() != metapb.QueueState_QUEUE_STATE_UNSPECIFIED {
+		e.State = stateFromPB(pb.GetState())
+	}
+	if pb.GetFrom() != metapb.QueueState_QUEUE_STATE_UNSPECIFIED {
+		e.From = stateFromPB(pb.GetFrom())
+	}
+	return e
+}
+
+// Watch streams the events of the served backend. The backend must
+// implement metastorage.WatchBackend, e.g. by wrapping it with the watch
+// middleware; otherwise the call fails with Unimplemented.
+func (s *Server) Watch(_ *metapb.WatchRequest, stream metapb.MetaStorage_WatchServer) error {
+	watcher, ok := metastorage.As[metastorage.WatchBackend](s.backend)
+	if !ok {
+		return status.Error(codes.Unimplemented, "backend does not support watching")
+	}
+	ctx := stream.Context()
+	events, err := watcher.Watch(ctx)
+	if err != nil {
+		return toStatus(err)
+	}
+	// headers confirm the subscription, so the client's Watch call
+	// returns only once no event can be missed anymore
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watch dropped")
+			}
+			e.MessageID = s.ids.Encode(e.MessageID)
+			if err := stream.Send(eventToPB(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Watch subscribes to change events of the remote backend. It returns
+// once the subscription is active. The channel is closed when ctx is done
+// or the stream breaks.
+func (c *Client) Watch(ctx context.Context) (<-chan metastorage.Event, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.rpc.Watch(ctx, &metapb.WatchRequest{})
+	if err == nil {
+		var md metadata.MD
+		if md, err = stream.Header(); err == nil && md == nil {
+			// ended without headers: the status tells why
+			_, err = stream.Recv()
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	events := make(chan metastorage.Event)
+	go func() {
+		defer cancel()
+		defer close(events)
+		for {
+			pb, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- eventFromPB(pb):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,77 @@
+package grpcbackend
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+// Zstd is the name of the zstd compressor registered by this package.
+// It compresses metadata batches considerably better than gzip at a
+// fraction of the CPU cost.
+const Zstd = "zstd"
+
+func init() {
+	encoding.RegisterCompressor(&zstdCompressor{})
+}
+
+// zstdCompressor implements encoding.Compressor with pooled coders
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	defer w.pool.Put(w)
+	return w.Encoder.Close()
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Close() error {
+	// reset drops the reference to the source before pooling
+	_ = r.Decoder.Reset(nil)
+	r.pool.Put(r)
+	return nil
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if z, ok := c.encoders.Get().(*zstdWriter); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{Encoder: enc, pool: &c.encoders}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	if z, ok := c.decoders.Get().(*zstdReader); ok {
+		if err := z.Reset(r); err != nil {
+			c.decoders.Put(z)
+			return nil, err
+		}
+		return z, nil
+	}
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{Decoder: dec, pool: &c.decoders}, nil
+}
+
+func (c *zstdCompressor) Name() string {
+	return Zstd
+}
@@ -0,0 +1,74 @@
+package httpbackend
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+// serve serves backend on a test server and returns a client
+func serve(t *testing.T, backend metastorage.Backend) *Client {
+	t.Helper()
+	h := NewHandler(backend)
+	srv := httptest.NewServer(h)
+	t.Cleanup(func() {
+		srv.Close()
+		h.Close()
+	})
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		return serve(t, memory.New())
+	})
+}
+
+// failingMove fails moves of m3 and hides the native bulk move of the
+// backend it wraps
+type failingMove struct {
+	metastorage.Backend
+}
+
+func (f failingMove) MoveToState(ctx context.Context, id string, from, to metastorage.QueueState) error {
+	if id == "m3" {
+		return errors.New("disk full")
+	}
+	return f.Backend.MoveToState(ctx, id, from, to)
+}
+
+func TestMoveStateBulk(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	for i, id := range []string{"m1", "m2", "m3"} {
+		if err := inner.StoreMeta(ctx, id, metastorage.MessageMetadata{ID: id, State: metastorage.StateHold, Priority: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := serve(t, inner)
+	if _, ok := metastorage.Outer[metastorage.BulkMoveBackend](c); !ok {
+		t.Fatal("client does not move natively")
+	}
+	n, err := metastorage.MoveStateBulk(ctx, c, metastorage.StateHold, metastorage.StateIncoming, func(m metastorage.MessageMetadata) bool { return m.Priority == 1 })
+	if err != nil || n != 1 {
+		t.Fatalf("filtered move: %d, %v; want 1", n, err)
+	}
+	if m, err := inner.GetMeta(ctx, "m2"); err != nil || m.State != metastorage.StateIncoming {
+		t.Fatalf("m2 in %s, %v; want incoming", m.State, err)
+	}
+
+	c = serve(t, failingMove{inner})
+	n, err = metastorage.MoveStateBulk(ctx, c, metastorage.StateHold, metastorage.StateIncoming, nil)
+	if err == nil || n != 1 {
+		t.Fatalf("failing move: %d, %v; want 1 moved and an error", n, err)
+	}
+}
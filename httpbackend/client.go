@@ -0,0 +1,431 @@
+package httpbackend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// DefaultRetries is how often a failed request is repeated by default
+const DefaultRetries = 3
+
+// requestTimeout bounds calls without a context parameter
+const requestTimeout = 5 * time.Second
+
+// retry backoff
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 2 * time.Second
+)
+
+type (
+	httpClientKey struct{}
+	retriesKey    struct{}
+	headerKey     struct{}
+)
+
+// WithHTTPClient sets the HTTP client used for requests, e.g. one with
+// TLS settings or timeouts (default http.DefaultClient)
+func WithHTTPClient(c *http.Client) options.Option {
+	return options.WithValue(httpClientKey{}, c)
+}
+
+// WithRetries sets how often a failed request is repeated (default
+// DefaultRetries). Requests rejected for overload (429, 503) are repeated
+// for all calls; network errors and gateway failures only for reads and
+// updates, as a store, delete or move may have been applied before the
+// response was lost.
+func WithRetries(n int) options.Option {
+	return options.WithValue(retriesKey{}, n)
+}
+
+// WithHeader adds a header to every request, e.g. Authorization. It can be
+// given several times.
+func WithHeader(key, value string) options.Option {
+	return func(o *options.Options) {
+		prev := options.ValueOr[http.Header](*o, headerKey{}, nil).Clone()
+		if prev == nil {
+			prev = make(http.Header)
+		}
+		prev.Add(key, value)
+		options.WithValue(headerKey{}, prev)(o)
+	}
+}
+
+// Client implements metastorage.Backend against a remote Handler
+type Client struct {
+	base      string
+	http      *http.Client
+	header    http.Header
+	retries   int
+	batchSize int
+	skew      time.Duration
+	closed    atomic.Bool
+}
+
+// New creates a client for the Handler mounted at baseURL, e.g.
+// "https://meta.example.com/spool"
+func New(baseURL string, opts ...options.Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("httpbackend: unsupported scheme %q", u.Scheme)
+	}
+	o := options.Apply(opts...)
+	return &Client{
+		base:      strings.TrimSuffix(u.String(), "/"),
+		http:      options.ValueOr(o, httpClientKey{}, http.DefaultClient),
+		header:    options.ValueOr[http.Header](o, headerKey{}, nil),
+		retries:   options.ValueOr(o, retriesKey{}, DefaultRetries),
+		batchSize: o.BatchSize,
+		skew:      o.ClockSkew,
+	}, nil
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (c *Client) ClockSkew() time.Duration {
+	return c.skew
+}
+
+func (c *Client) check() error {
+	if c.closed.Load() {
+		return metastorage.ErrBackendClosed
+	}
+	return nil
+}
+
+func messagePath(id string) string {
+	return "/v1/messages/" + url.PathEscape(id)
+}
+
+func statePath(state metastorage.QueueState, endpoint string) string {
+	return "/v1/states/" + url.PathEscape(metastorage.StateLabel(state)) + "/" + endpoint
+}
+
+// do sends a request with in as JSON body and decodes the response into
+// out, retrying failures as described at WithRetries
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, path, query, body, out)
+		if err == nil || attempt >= c.retries || !retryable(method, err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// retryable reports whether a request of method that failed with err may
+// be repeated
+func retryable(method string, err error) bool {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		// network error, the request may have been applied
+		return method == http.MethodGet || method == http.MethodPut
+	}
+	if !se.temporary() {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodPut
+}
+
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body []byte, out any) error {
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, r)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	setHints(ctx, req.Header)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// StoreMeta stores message metadata
+func (c *Client) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, messagePath(messageID), nil, toWire(metadata), nil)
+}
+
+// GetMeta retrieves message metadata
+func (c *Client) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := c.check(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	var resp message
+	if err := c.do(ctx, http.MethodGet, messagePath(messageID), nil, nil, &resp); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	return fromWire(resp)
+}
+
+// UpdateMeta updates message metadata
+func (c *Client) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPut, messagePath(messageID), nil, toWire(metadata), nil)
+}
+
+// DeleteMeta removes message metadata
+func (c *Client) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodDelete, messagePath(messageID), nil, nil, nil)
+}
+
+// ListMessages lists messages with pagination and filtering
+func (c *Client) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := c.check(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	q := url.Values{}
+	if opts.Limit != 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset != 0 {
+		q.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.SortBy != "" {
+		q.Set("sort_by", opts.SortBy)
+	}
+	if opts.SortOrder != "" {
+		q.Set("sort_order", opts.SortOrder)
+	}
+	if !opts.Since.IsZero() {
+		q.Set("since", opts.Since.UTC().Format(time.RFC3339Nano))
+	}
+	var resp listResponse
+	// the consistency of opts travels in the hint headers
+	if err := c.do(metastorage.ListContext(ctx, opts), http.MethodGet, statePath(state, "ids"), q, nil, &resp); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	return metastorage.MessageListResult{MessageIDs: resp.MessageIDs, Total: resp.Total, HasMore: resp.HasMore}, nil
+}
+
+// MoveToState moves a message between states with CAS semantics
+func (c *Client) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	body := moveRequest{From: metastorage.StateLabel(fromState), To: metastorage.StateLabel(toState)}
+	return c.do(ctx, http.MethodPost, messagePath(messageID)+"/move", nil, body, nil)
+}
+
+// MoveStateBulk moves messages of fromState to toState in one request,
+// see metastorage.BulkMoveBackend. filter runs on the client: the IDs of
+// the matching messages are read with an iterator and sent along, and
+// those still in fromState are moved. After an error the messages moved
+// before are counted.
+func (c *Client) MoveStateBulk(ctx context.Context, fromState, toState metastorage.QueueState, filter func(metastorage.MessageMetadata) bool) (int, error) {
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	body := bulkMoveRequest{To: metastorage.StateLabel(toState), Filtered: filter != nil}
+	if filter != nil {
+		ids, err := c.matching(ctx, fromState, filter)
+		if err != nil || len(ids) == 0 {
+			return 0, err
+		}
+		body.MessageIDs = ids
+	}
+	var resp bulkMoveResponse
+	if err := c.do(ctx, http.MethodPost, statePath(fromState, "move"), nil, body, &resp); err != nil {
+		var se *StatusError
+		if errors.As(err, &se) {
+			return se.moved, err
+		}
+		return 0, err
+	}
+	return resp.Moved, nil
+}
+
+// matching returns the IDs of the messages of state filter returns true
+// for
+func (c *Client) matching(ctx context.Context, state metastorage.QueueState, filter func(metastorage.MessageMetadata) bool) ([]string, error) {
+	iter, err := c.NewMessageIterator(ctx, state, MaxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var ids []string
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return ids, nil
+		}
+		if filter(m) {
+			ids = append(ids, m.ID)
+		}
+	}
+}
+
+// GetStateCount returns the count reported by the service, or -1 if the
+// served backend cannot count states or the call fails
+func (c *Client) GetStateCount(state metastorage.QueueState) int64 {
+	if c.check() != nil {
+		return -1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	var resp countResponse
+	if err := c.do(ctx, http.MethodGet, statePath(state, "count"), nil, nil, &resp); err != nil {
+		return -1
+	}
+	return resp.Count
+}
+
+// ServerTime returns the time of the service, see
+// metastorage.ServerTimeBackend. Served backends that report their own
+// server time are asked for it; otherwise the clock of the service host
+// is returned.
+func (c *Client) ServerTime(ctx context.Context) (time.Time, error) {
+	if err := c.check(); err != nil {
+		return time.Time{}, err
+	}
+	var resp timeResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/time", nil, nil, &resp); err != nil {
+		return time.Time{}, err
+	}
+	return resp.Time, nil
+}
+
+// NewMessageIterator pages through the state with a server side cursor.
+// The first page is requested by the first call to Next.
+func (c *Client) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = c.batchSize
+	}
+	return &pageIterator{client: c, state: state, pageSize: min(batchSize, MaxPageSize)}, nil
+}
+
+// Close marks the client closed. The HTTP client is not closed, as it may
+// be shared.
+func (c *Client) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// pageIterator reads pages of a server side cursor
+type pageIterator struct {
+	client   *Client
+	state    metastorage.QueueState
+	pageSize int
+	token    string // token of the next page, empty before the first
+	batch    []message
+	done     bool
+}
+
+// Next returns the next message, requesting the next page when the
+// current one is used up
+func (it *pageIterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	for len(it.batch) == 0 {
+		if it.done {
+			return metastorage.MessageMetadata{}, false, nil
+		}
+		if err := it.client.check(); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		q := url.Values{"page_size": {strconv.Itoa(it.pageSize)}}
+		if it.token != "" {
+			q.Set("page_token", it.token)
+		}
+		var resp pageResponse
+		if err := it.client.do(ctx, http.MethodGet, statePath(it.state, "messages"), q, nil, &resp); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		it.batch = resp.Messages
+		it.token = resp.NextPageToken
+		it.done = it.token == ""
+	}
+	m, err := fromWire(it.batch[0])
+	it.batch = it.batch[1:]
+	if err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	return m, true, nil
+}
+
+// Close releases the server side cursor of an unfinished iteration
+func (it *pageIterator) Close() error {
+	token := it.token
+	wasDone := it.done
+	it.done = true
+	it.batch = nil
+	if wasDone || token == "" || it.client.check() != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	// best effort, the cursor expires anyway
+	_ = it.client.send(ctx, http.MethodDelete, "/v1/cursors/"+url.PathEscape(token), nil, nil, nil)
+	return nil
+}
@@ -0,0 +1,144 @@
+package httpbackend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/locale"
+)
+
+// ErrCursorExpired is returned when an iteration continues after its
+// server side cursor expired, or on another server than the one that
+// created it
+var ErrCursorExpired = errors.New("iteration cursor expired")
+
+// statusClientClosed reports requests canceled by the client
+const statusClientClosed = 499
+
+// sentinels maps contract errors to their HTTP status and reason, so
+// clients can restore the exact error for errors.Is
+var sentinels = []struct {
+	err    error
+	status int
+	reason string
+}{
+	{metastorage.ErrMessageNotFound, http.StatusNotFound, "MESSAGE_NOT_FOUND"},
+	{metastorage.ErrStateConflict, http.StatusConflict, "STATE_CONFLICT"},
+	{metastorage.ErrVersionConflict, http.StatusConflict, "VERSION_CONFLICT"},
+	{metastorage.ErrInvalidState, http.StatusUnprocessableEntity, "INVALID_STATE"},
+	{metastorage.ErrPinned, http.StatusConflict, "PINNED"},
+	{metastorage.ErrNonUTCTimestamp, http.StatusBadRequest, "NON_UTC_TIMESTAMP"},
+	{metastorage.ErrMissingID, http.StatusBadRequest, "MISSING_ID"},
+	{metastorage.ErrStaleWrite, http.StatusConflict, "STALE_WRITE"},
+	{metastorage.ErrBackendClosed, http.StatusServiceUnavailable, "BACKEND_CLOSED"},
+	{ErrCursorExpired, http.StatusGone, "CURSOR_EXPIRED"},
+}
+
+func init() {
+	metastorage.RegisterClassifier(metastorage.ClassifierFunc(classify))
+}
+
+// classify treats overload and gateway failures of the remote service as
+// transient
+func classify(err error) (bool, bool) {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return false, false
+	}
+	return se.temporary(), true
+}
+
+// StatusError is a failed request. Errors of the metastorage contract
+// unwrap to their sentinel, e.g. metastorage.ErrStateConflict.
+type StatusError struct {
+	StatusCode int
+	Reason     string // contract error name, empty for other failures
+	Message    string
+
+	sentinel error
+	moved    int // messages a failed bulk move moved before failing
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+func (e *StatusError) Unwrap() error { return e.sentinel }
+
+// temporary reports whether the request was rejected before the backend
+// saw it, or failed in a gateway, so it may succeed later
+func (e *StatusError) temporary() bool {
+	if e.Reason != "" {
+		return false
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// writeError writes err as an errorResponse, with a display message if
+// the request asks for a language
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, resp := errorBody(r, err)
+	writeJSON(w, status, resp)
+}
+
+// errorBody returns the status and errorResponse of err
+func errorBody(r *http.Request, err error) (int, errorResponse) {
+	status, reason := http.StatusInternalServerError, ""
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		status = statusClientClosed
+	case errors.Is(err, errors.ErrUnsupported):
+		status = http.StatusNotImplemented
+	default:
+		for _, s := range sentinels {
+			if errors.Is(err, s.err) {
+				status, reason = s.status, s.reason
+				break
+			}
+		}
+	}
+	resp := errorResponse{Error: err.Error(), Reason: reason}
+	if header, ok := r.Header["Accept-Language"]; ok {
+		resp.Message = locale.FromAcceptLanguage(strings.Join(header, ",")).Error(err)
+	}
+	return status, resp
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+}
+
+// readError converts a failed response back into the error it was
+// created from
+func readError(resp *http.Response) error {
+	var body errorResponse
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	se := &StatusError{StatusCode: resp.StatusCode, Reason: body.Reason, Message: body.Error, moved: body.Moved}
+	for _, s := range sentinels {
+		if body.Reason != "" && s.reason == body.Reason {
+			se.sentinel = s.err
+			return se
+		}
+	}
+	if resp.StatusCode == http.StatusNotImplemented {
+		se.sentinel = errors.ErrUnsupported
+	}
+	if se.Message == "" {
+		se.Message = fmt.Sprintf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+	}
+	return se
+}
@@ -0,0 +1,526 @@
+// Package httpbackend serves a metadata backend as HTTP/JSON endpoints and
+// provides a client implementing metastorage.Backend on top of them, for
+// environments where gRPC is not available and for consumers written in
+// other languages.
+//
+// The Handler exposes, relative to where it is mounted:
+//
+//	GET    /v1/messages/{id}             read a message
+//	POST   /v1/messages/{id}             store a message
+//	PUT    /v1/messages/{id}             update a message
+//	DELETE /v1/messages/{id}             delete a message
+//	POST   /v1/messages/{id}/move        move with CAS, body {"from": "incoming", "to": "active"}
+//	GET    /v1/states/{state}/ids        list IDs (limit, offset, sort_by, sort_order, since)
+//	GET    /v1/states/{state}/count      count a state, -1 if the backend cannot
+//	POST   /v1/states/{state}/move       move a state, body {"to": "incoming"}, optionally
+//	                                     restricted with "filtered" and "message_ids"
+//	GET    /v1/states/{state}/messages   iterate a state (page_size, page_token)
+//	DELETE /v1/cursors/{token}           end an iteration early
+//	GET    /v1/time                      server time, of the backend's server if it reports one
+//
+// Messages are JSON objects with snake_case fields, states are sent by
+// name. Failures carry {"error": "...", "reason": "STATE_CONFLICT"} with
+// the reason naming the contract error; failed bulk moves add "moved",
+// the number of messages moved before the failure. Requests with an Accept-Language
+// header also get display strings from package locale: "state_name" in
+// messages and "message" in failures.
+//
+// Iterations keep a backend iterator open on the server between pages,
+// so consecutive pages see a consistent walk of the state. Cursors expire
+// after WithCursorTTL without a request; behind a load balancer, pages of
+// one iteration must reach the same server.
+package httpbackend
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+const (
+	// MaxPageSize caps the page size a client may request when iterating
+	MaxPageSize = 1000
+
+	// DefaultCursorTTL is how long an idle iteration is kept open
+	DefaultCursorTTL = time.Minute
+)
+
+// maxBodySize limits request bodies, which hold at most one message
+const maxBodySize = 1 << 20
+
+type cursorTTLKey struct{}
+
+// WithCursorTTL sets how long the Handler keeps an iteration open without
+// a page request (default DefaultCursorTTL)
+func WithCursorTTL(d time.Duration) options.Option {
+	return options.WithValue(cursorTTLKey{}, d)
+}
+
+// Handler serves a backend as HTTP/JSON endpoints
+type Handler struct {
+	backend   metastorage.Backend
+	batchSize int
+	ttl       time.Duration
+	clock     clock.Clock
+	mux       *http.ServeMux
+
+	mu      sync.Mutex
+	cursors map[string]*cursor
+}
+
+// cursor is an open iteration. seq counts the pages served; the last page
+// is kept so a retried request for it gets the same answer. used is
+// guarded by Handler.mu, the other fields by mu.
+type cursor struct {
+	mu     sync.Mutex
+	state  metastorage.QueueState
+	iter   metastorage.MessageIterator
+	cancel context.CancelFunc
+	seq    int
+	last   pageResponse
+	done   bool
+	failed bool
+	closed bool
+	used   time.Time
+}
+
+// NewHandler creates a handler for backend. options.WithBatchSize sets the
+// page size used when a client does not request one.
+func NewHandler(backend metastorage.Backend, opts ...options.Option) *Handler {
+	o := options.Apply(opts...)
+	h := &Handler{
+		backend:   backend,
+		batchSize: o.BatchSize,
+		ttl:       options.ValueOr(o, cursorTTLKey{}, DefaultCursorTTL),
+		clock:     o.Clock,
+		mux:       http.NewServeMux(),
+		cursors:   make(map[string]*cursor),
+	}
+	h.mux.HandleFunc("GET /v1/messages/{id}", h.getMeta)
+	h.mux.HandleFunc("POST /v1/messages/{id}", h.storeMeta)
+	h.mux.HandleFunc("PUT /v1/messages/{id}", h.updateMeta)
+	h.mux.HandleFunc("DELETE /v1/messages/{id}", h.deleteMeta)
+	h.mux.HandleFunc("POST /v1/messages/{id}/move", h.moveToState)
+	h.mux.HandleFunc("GET /v1/states/{state}/ids", h.listMessages)
+	h.mux.HandleFunc("GET /v1/states/{state}/count", h.stateCount)
+	h.mux.HandleFunc("POST /v1/states/{state}/move", h.moveStateBulk)
+	h.mux.HandleFunc("GET /v1/states/{state}/messages", h.page)
+	h.mux.HandleFunc("DELETE /v1/cursors/{token}", h.closeCursor)
+	h.mux.HandleFunc("GET /v1/time", h.serverTime)
+	return h
+}
+
+// ServeHTTP dispatches a request to its endpoint. The consistency and
+// read preference a client set on its context apply to the call of the
+// served backend.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, withHints(r))
+}
+
+// Close ends all open iterations. It does not close the backend.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, c := range h.cursors {
+		c.mu.Lock()
+		c.close()
+		c.mu.Unlock()
+		delete(h.cursors, id)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("decode body: %w", err)
+	}
+	return nil
+}
+
+// readMessage decodes the message of a store or update. A missing ID
+// defaults to the one of the path.
+func readMessage(w http.ResponseWriter, r *http.Request) (metastorage.MessageMetadata, error) {
+	var body message
+	if err := readJSON(w, r, &body); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	if body.ID == "" {
+		body.ID = r.PathValue("id")
+	}
+	return fromWire(body)
+}
+
+func pathState(r *http.Request) (metastorage.QueueState, error) {
+	return metastorage.ParseQueueState(r.PathValue("state"))
+}
+
+func (h *Handler) getMeta(w http.ResponseWriter, r *http.Request) {
+	m, err := h.backend.GetMeta(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, localize(r, toWire(m)))
+}
+
+func (h *Handler) storeMeta(w http.ResponseWriter, r *http.Request) {
+	m, err := readMessage(w, r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	if err := h.backend.StoreMeta(r.Context(), r.PathValue("id"), m); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) updateMeta(w http.ResponseWriter, r *http.Request) {
+	m, err := readMessage(w, r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	if err := h.backend.UpdateMeta(r.Context(), r.PathValue("id"), m); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) deleteMeta(w http.ResponseWriter, r *http.Request) {
+	if err := h.backend.DeleteMeta(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) moveToState(w http.ResponseWriter, r *http.Request) {
+	var body moveRequest
+	if err := readJSON(w, r, &body); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	from, err := metastorage.ParseQueueState(body.From)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	to, err := metastorage.ParseQueueState(body.To)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	if err := h.backend.MoveToState(r.Context(), r.PathValue("id"), from, to); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moveStateBulk moves messages with metastorage.MoveStateBulk, natively
+// if the backend implements metastorage.BulkMoveBackend
+func (h *Handler) moveStateBulk(w http.ResponseWriter, r *http.Request) {
+	from, err := pathState(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	var body bulkMoveRequest
+	if err := readJSON(w, r, &body); err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	to, err := metastorage.ParseQueueState(body.To)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	var filter func(metastorage.MessageMetadata) bool
+	if body.Filtered {
+		ids := make(map[string]struct{}, len(body.MessageIDs))
+		for _, id := range body.MessageIDs {
+			ids[id] = struct{}{}
+		}
+		filter = func(m metastorage.MessageMetadata) bool {
+			_, ok := ids[m.ID]
+			return ok
+		}
+	}
+	n, err := metastorage.MoveStateBulk(r.Context(), h.backend, from, to, filter)
+	if err != nil {
+		status, resp := errorBody(r, err)
+		resp.Moved = n
+		writeJSON(w, status, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, bulkMoveResponse{Moved: n})
+}
+
+// listOptions parses the query of a list request
+func listOptions(r *http.Request) (metastorage.MessageListOptions, error) {
+	q := r.URL.Query()
+	opts := metastorage.MessageListOptions{
+		SortBy:    q.Get("sort_by"),
+		SortOrder: q.Get("sort_order"),
+	}
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if opts.Limit, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("limit: %w", err)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if opts.Offset, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("offset: %w", err)
+		}
+	}
+	if v := q.Get("since"); v != "" {
+		if opts.Since, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return opts, fmt.Errorf("since: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+func (h *Handler) listMessages(w http.ResponseWriter, r *http.Request) {
+	state, err := pathState(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	opts, err := listOptions(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	res, err := h.backend.ListMessages(r.Context(), state, opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ids := res.MessageIDs
+	if ids == nil {
+		ids = []string{}
+	}
+	writeJSON(w, http.StatusOK, listResponse{MessageIDs: ids, Total: res.Total, HasMore: res.HasMore})
+}
+
+func (h *Handler) stateCount(w http.ResponseWriter, r *http.Request) {
+	state, err := pathState(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	count := int64(-1)
+	if counter, ok := metastorage.As[metastorage.StateCounterBackend](h.backend); ok {
+		count = counter.GetStateCount(state)
+	}
+	writeJSON(w, http.StatusOK, countResponse{Count: count})
+}
+
+func (h *Handler) serverTime(w http.ResponseWriter, r *http.Request) {
+	now := h.clock.Now()
+	if st, ok := metastorage.As[metastorage.ServerTimeBackend](h.backend); ok {
+		var err error
+		if now, err = st.ServerTime(r.Context()); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, timeResponse{Time: now.UTC()})
+}
+
+// page serves the next page of an iteration. Without page_token a new
+// iteration is started; page tokens name the cursor and the page number,
+// so a retried request for the page served last is answered again.
+func (h *Handler) page(w http.ResponseWriter, r *http.Request) {
+	state, err := pathState(r)
+	if err != nil {
+		writeBadRequest(w, err)
+		return
+	}
+	q := r.URL.Query()
+	size := h.batchSize
+	if v := q.Get("page_size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size <= 0 {
+			writeBadRequest(w, fmt.Errorf("page_size: invalid value %q", v))
+			return
+		}
+	}
+	size = min(size, MaxPageSize)
+
+	token := q.Get("page_token")
+	var (
+		id  string
+		c   *cursor
+		seq int
+	)
+	if token == "" {
+		id, c, err = h.openCursor(state, size)
+	} else {
+		id, seq, c, err = h.lookupCursor(token, state)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed {
+		writeError(w, r, ErrCursorExpired)
+		return
+	}
+	switch seq {
+	case c.seq - 1:
+		// retry of the page served last
+	case c.seq:
+		if c.done {
+			c.last = pageResponse{Messages: []message{}}
+			break
+		}
+		resp, err := c.fill(r.Context(), size)
+		if err != nil {
+			// the iterator may have lost its position
+			c.failed = true
+			c.close()
+			writeError(w, r, err)
+			return
+		}
+		c.seq++
+		if !c.done {
+			resp.NextPageToken = id + "." + strconv.Itoa(c.seq)
+		}
+		c.last = resp
+	default:
+		writeError(w, r, ErrCursorExpired)
+		return
+	}
+	page := c.last
+	if _, ok := r.Header["Accept-Language"]; ok {
+		page.Messages = make([]message, len(c.last.Messages))
+		for i, m := range c.last.Messages {
+			page.Messages[i] = localize(r, m)
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// fill reads the next page from the iterator
+func (c *cursor) fill(ctx context.Context, size int) (pageResponse, error) {
+	resp := pageResponse{Messages: make([]message, 0, size)}
+	for len(resp.Messages) < size {
+		m, more, err := c.iter.Next(ctx)
+		if err != nil {
+			return resp, err
+		}
+		if !more {
+			c.done = true
+			c.close()
+			break
+		}
+		resp.Messages = append(resp.Messages, toWire(m))
+	}
+	return resp, nil
+}
+
+// close releases the iterator. c.mu must be held.
+func (c *cursor) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.cancel()
+	c.iter.Close()
+}
+
+// openCursor starts an iteration of state
+func (h *Handler) openCursor(state metastorage.QueueState, size int) (string, *cursor, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", nil, err
+	}
+	// the iteration outlives the request that starts it
+	ctx, cancel := context.WithCancel(context.Background())
+	iter, err := h.backend.NewMessageIterator(ctx, state, size)
+	if err != nil {
+		cancel()
+		return "", nil, err
+	}
+	id := hex.EncodeToString(b[:])
+	c := &cursor{state: state, iter: iter, cancel: cancel, used: h.clock.Now()}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire()
+	h.cursors[id] = c
+	return id, c, nil
+}
+
+// lookupCursor returns the cursor and page number of a page token
+func (h *Handler) lookupCursor(token string, state metastorage.QueueState) (string, int, *cursor, error) {
+	id, n, ok := strings.Cut(token, ".")
+	seq, err := strconv.Atoi(n)
+	if !ok || err != nil {
+		return "", 0, nil, fmt.Errorf("%w: invalid page token", ErrCursorExpired)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire()
+	c, ok := h.cursors[id]
+	if !ok || c.state != state {
+		return "", 0, nil, ErrCursorExpired
+	}
+	// keep the cursor alive while the page is read
+	c.used = h.clock.Now()
+	return id, seq, c, nil
+}
+
+// expire closes idle cursors. h.mu must be held.
+func (h *Handler) expire() {
+	now := h.clock.Now()
+	for id, c := range h.cursors {
+		if c.mu.TryLock() {
+			if now.Sub(c.used) > h.ttl {
+				c.close()
+				delete(h.cursors, id)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (h *Handler) closeCursor(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(r.PathValue("token"), ".")
+	h.mu.Lock()
+	c, ok := h.cursors[id]
+	delete(h.cursors, id)
+	h.mu.Unlock()
+	if ok {
+		c.mu.Lock()
+		c.close()
+		c.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,35 @@
+package httpbackend
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+func init() {
+	registry.Register("http", open)
+	registry.Register("https", open)
+}
+
+// open handles "http://host:port/prefix" and "https://..." DSNs. The
+// retries parameter sets WithRetries, e.g.
+// "https://meta.example.com/spool?retries=5".
+func open(_ context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	q := dsn.Query()
+	if v := q.Get("retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("http DSN: invalid retries %q", v)
+		}
+		opts = append(opts, WithRetries(n))
+	}
+	base := *dsn
+	base.RawQuery = ""
+	base.Fragment = ""
+	return New(base.String(), opts...)
+}
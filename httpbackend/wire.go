@@ -0,0 +1,220 @@
+package httpbackend
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/locale"
+)
+
+// message is the JSON form of metastorage.MessageMetadata. States are
+// sent by name and timestamps as RFC 3339 in UTC, so clients in other
+// languages need no knowledge of the Go types.
+type message struct {
+	ID              string            `json:"id"`
+	State           string            `json:"state"`
+	Attempts        int               `json:"attempts,omitempty"`
+	MaxAttempts     int               `json:"max_attempts,omitempty"`
+	NextRetry       time.Time         `json:"next_retry,omitzero"`
+	Created         time.Time         `json:"created,omitzero"`
+	Updated         time.Time         `json:"updated,omitzero"`
+	LastError       string            `json:"last_error,omitempty"`
+	Size            int64             `json:"size,omitempty"`
+	Priority        int               `json:"priority,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	RetryPolicyName string            `json:"retry_policy_name,omitempty"`
+	Sequence        uint64            `json:"sequence,omitempty"`
+	StateEnteredAt  time.Time         `json:"state_entered_at,omitzero"`
+	DeliveryWindow  deliveryWindow    `json:"delivery_window,omitzero"`
+	Version         uint64            `json:"version,omitempty"`
+	StateName       string            `json:"state_name,omitempty"` // display name, only in responses
+}
+
+type deliveryWindow struct {
+	NotBefore time.Time `json:"not_before,omitzero"`
+	NotAfter  time.Time `json:"not_after,omitzero"`
+	Hours     hourRange `json:"hours,omitzero"`
+	Weekdays  []int     `json:"weekdays,omitempty"` // 0 = Sunday
+	TimeZone  string    `json:"time_zone,omitempty"`
+}
+
+type hourRange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// moveRequest is the body of a move
+type moveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// bulkMoveRequest is the body of a bulk move. Filtered restricts it to
+// MessageIDs, the messages a client side filter matched.
+type bulkMoveRequest struct {
+	To         string   `json:"to"`
+	Filtered   bool     `json:"filtered,omitempty"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+}
+
+// bulkMoveResponse is the result of MoveStateBulk
+type bulkMoveResponse struct {
+	Moved int `json:"moved"`
+}
+
+// listResponse is the result of ListMessages
+type listResponse struct {
+	MessageIDs []string `json:"message_ids"`
+	Total      int      `json:"total"`
+	HasMore    bool     `json:"has_more"`
+}
+
+// countResponse is the result of GetStateCount, -1 if the served backend
+// cannot count states
+type countResponse struct {
+	Count int64 `json:"count"`
+}
+
+// timeResponse is the result of ServerTime
+type timeResponse struct {
+	Time time.Time `json:"time"`
+}
+
+// pageResponse is one page of an iteration. An empty NextPageToken ends
+// the iteration.
+type pageResponse struct {
+	Messages      []message `json:"messages"`
+	NextPageToken string    `json:"next_page_token,omitempty"`
+}
+
+// errorResponse is the body of failed requests. Reason names the contract
+// error, e.g. "STATE_CONFLICT", so clients can tell them apart; Message
+// is a display string in the language of the request.
+type errorResponse struct {
+	Error   string `json:"error"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	Moved   int    `json:"moved,omitempty"` // messages a failed bulk move moved before failing
+}
+
+// localize sets the display name of the state of m if the request asks
+// for a language
+func localize(r *http.Request, m message) message {
+	header, ok := r.Header["Accept-Language"]
+	if !ok {
+		return m
+	}
+	if state, err := metastorage.ParseQueueState(m.State); err == nil {
+		m.StateName = locale.FromAcceptLanguage(strings.Join(header, ",")).State(state)
+	}
+	return m
+}
+
+func toWire(m metastorage.MessageMetadata) message {
+	m = metastorage.NormalizeTimes(m)
+	w := message{
+		ID:              m.ID,
+		State:           metastorage.StateLabel(m.State),
+		Attempts:        m.Attempts,
+		MaxAttempts:     m.MaxAttempts,
+		NextRetry:       m.NextRetry,
+		Created:         m.Created,
+		Updated:         m.Updated,
+		LastError:       m.LastError,
+		Size:            m.Size,
+		Priority:        m.Priority,
+		Headers:         m.Headers,
+		RetryPolicyName: m.RetryPolicyName,
+		Sequence:        m.Sequence,
+		Version:         m.Version,
+		StateEnteredAt:  m.StateEnteredAt,
+		DeliveryWindow: deliveryWindow{
+			NotBefore: m.DeliveryWindow.NotBefore,
+			NotAfter:  m.DeliveryWindow.NotAfter,
+			Hours:     hourRange{From: m.DeliveryWindow.Hours.From, To: m.DeliveryWindow.Hours.To},
+			TimeZone:  m.DeliveryWindow.TimeZone,
+		},
+	}
+	for _, d := range m.DeliveryWindow.Weekdays {
+		w.DeliveryWindow.Weekdays = append(w.DeliveryWindow.Weekdays, int(d))
+	}
+	return w
+}
+
+func fromWire(w message) (metastorage.MessageMetadata, error) {
+	state, err := metastorage.ParseQueueState(w.State)
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	m := metastorage.MessageMetadata{
+		ID:              w.ID,
+		State:           state,
+		Attempts:        w.Attempts,
+		MaxAttempts:     w.MaxAttempts,
+		NextRetry:       w.NextRetry,
+		Created:         w.Created,
+		Updated:         w.Updated,
+		LastError:       w.LastError,
+		Size:            w.Size,
+		Priority:        w.Priority,
+		Headers:         w.Headers,
+		RetryPolicyName: w.RetryPolicyName,
+		Sequence:        w.Sequence,
+		Version:         w.Version,
+		StateEnteredAt:  w.StateEnteredAt,
+		DeliveryWindow: metastorage.DeliveryWindow{
+			NotBefore: w.DeliveryWindow.NotBefore,
+			NotAfter:  w.DeliveryWindow.NotAfter,
+			Hours:     metastorage.HourRange{From: w.DeliveryWindow.Hours.From, To: w.DeliveryWindow.Hours.To},
+			TimeZone:  w.DeliveryWindow.TimeZone,
+		},
+	}
+	for _, d := range w.DeliveryWindow.Weekdays {
+		m.DeliveryWindow.Weekdays = append(m.DeliveryWindow.Weekdays, time.Weekday(d))
+	}
+	return metastorage.NormalizeTimes(m), nil
+}
+
+// Request headers carrying the hints of metastorage.WithConsistency and
+// metastorage.WithReadPreference to the served backend
+const (
+	headerConsistency    = "X-Meta-Consistency"
+	headerMaxStaleness   = "X-Meta-Max-Staleness" // a Go duration, e.g. "5s"
+	headerReadPreference = "X-Meta-Read-Preference"
+)
+
+// setHints sets the hint headers for the context of a request
+func setHints(ctx context.Context, h http.Header) {
+	if o := metastorage.ReadOptionsFromContext(ctx); o.Consistency != metastorage.ConsistencyDefault {
+		h.Set(headerConsistency, o.Consistency.String())
+		if o.Consistency == metastorage.ConsistencyBoundedStaleness {
+			h.Set(headerMaxStaleness, o.MaxStaleness.String())
+		}
+	}
+	if p := metastorage.ReadPreferenceFromContext(ctx); p != metastorage.ReadDefault {
+		h.Set(headerReadPreference, p.String())
+	}
+}
+
+// withHints returns the context of r with the hints of its headers.
+// Unknown values are ignored like any hint a backend cannot honour.
+func withHints(r *http.Request) *http.Request {
+	ctx := r.Context()
+	if c, err := metastorage.ParseConsistencyLevel(r.Header.Get(headerConsistency)); err == nil && c != metastorage.ConsistencyDefault {
+		o := metastorage.ReadOptions{Consistency: c}
+		if c == metastorage.ConsistencyBoundedStaleness {
+			o.MaxStaleness, _ = time.ParseDuration(r.Header.Get(headerMaxStaleness))
+		}
+		ctx = metastorage.WithReadOptions(ctx, o)
+	}
+	if p, err := metastorage.ParseReadPreference(r.Header.Get(headerReadPreference)); err == nil && p != metastorage.ReadDefault {
+		ctx = metastorage.WithReadPreference(ctx, p)
+	}
+	if ctx == r.Context() {
+		return r
+	}
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,119 @@
+// Package idcodec maps internal message IDs to opaque external tokens, so
+// consumers of the remote surfaces can reference messages without
+// learning how IDs are built (hostnames, timestamps, sequence numbers).
+//
+// The HMAC codec is deterministic: the same ID always yields the same
+// token, so tokens can be compared, cached and logged. Tokens are
+// authenticated; a modified or forged token fails to decode.
+package idcodec
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidToken is returned by Decode for tokens not produced by the
+// codec
+var ErrInvalidToken = errors.New("invalid message token")
+
+// Codec maps message IDs to external tokens and back
+type Codec interface {
+	// Encode returns the token of id
+	Encode(id string) string
+
+	// Decode returns the ID of token, or an error wrapping
+	// ErrInvalidToken
+	Decode(token string) (string, error)
+}
+
+// Identity exposes IDs unchanged
+var Identity Codec = identity{}
+
+type identity struct{}
+
+func (identity) Encode(id string) string             { return id }
+func (identity) Decode(token string) (string, error) { return token, nil }
+
+// MinKeySize is the minimum key length accepted by NewHMAC
+const MinKeySize = 16
+
+// tagSize is the length of the synthetic IV, which doubles as the
+// authentication tag
+const tagSize = 16
+
+// HMAC encrypts IDs deterministically with HMAC-SHA256 in the SIV
+// construction: the tag is a MAC of the ID and seeds the keystream the ID
+// is XORed with. A token is the unpadded URL-safe base64 encoding of the
+// 16 byte tag followed by the encrypted ID.
+type HMAC struct {
+	macKey    []byte
+	streamKey []byte
+}
+
+// NewHMAC creates a codec from a secret key of at least MinKeySize bytes.
+// All servers exposing the same backend must share the key; changing it
+// invalidates all tokens handed out before.
+func NewHMAC(key []byte) (*HMAC, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("idcodec: key must have at least %d bytes, got %d", MinKeySize, len(key))
+	}
+	return &HMAC{
+		macKey:    derive(key, "retryspool id mac"),
+		streamKey: derive(key, "retryspool id stream"),
+	}, nil
+}
+
+// derive returns a subkey of key for purpose
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Encode returns the token of id
+func (c *HMAC) Encode(id string) string {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(id))
+	tag := mac.Sum(nil)[:tagSize]
+	token := make([]byte, tagSize+len(id))
+	copy(token, tag)
+	c.xorStream(token[tagSize:], []byte(id), tag)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// Decode returns the ID of token
+func (c *HMAC) Decode(token string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < tagSize {
+		return "", ErrInvalidToken
+	}
+	tag := data[:tagSize]
+	id := make([]byte, len(data)-tagSize)
+	c.xorStream(id, data[tagSize:], tag)
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write(id)
+	if !hmac.Equal(mac.Sum(nil)[:tagSize], tag) {
+		return "", ErrInvalidToken
+	}
+	return string(id), nil
+}
+
+// xorStream sets dst to src XOR the keystream seeded by iv, the
+// concatenation of HMAC(streamKey, iv || counter) blocks
+func (c *HMAC) xorStream(dst, src, iv []byte) {
+	var counter [8]byte
+	for i := 0; i < len(src); i += sha256.Size {
+		binary.BigEndian.PutUint64(counter[:], uint64(i/sha256.Size))
+		mac := hmac.New(sha256.New, c.streamKey)
+		mac.Write(iv)
+		mac.Write(counter[:])
+		block := mac.Sum(nil)
+		for j := 0; j < sha256.Size && i+j < len(src); j++ {
+			dst[i+j] = src[i+j] ^ block[j]
+		}
+	}
+}
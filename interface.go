@@ -2,6 +2,9 @@ package metastorage
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -37,6 +40,37 @@ func (s QueueState) String() string {
 	}
 }
 
+// StateLabel returns the name of s for metric labels and stats keys. Unlike
+// String, states unknown to this version get distinct names ("state_7"),
+// so they are not merged into one "unknown" series.
+func StateLabel(s QueueState) string {
+	if name := s.String(); name != "unknown" {
+		return name
+	}
+	return "state_" + strconv.Itoa(int(s))
+}
+
+// ParseQueueState returns the state with the given String() or
+// StateLabel name
+func ParseQueueState(name string) (QueueState, error) {
+	for _, s := range States() {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	if n, ok := strings.CutPrefix(name, "state_"); ok {
+		if v, err := strconv.Atoi(n); err == nil {
+			return QueueState(v), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown queue state %q", name)
+}
+
+// States returns all known queue states in their natural order
+func States() []QueueState {
+	return []QueueState{StateIncoming, StateActive, StateDeferred, StateHold, StateBounce, StateArchived}
+}
+
 // MessageMetadata contains metadata about a message
 type MessageMetadata struct {
 	ID              string
@@ -51,6 +85,10 @@ type MessageMetadata struct {
 	Priority        int
 	Headers         map[string]string
 	RetryPolicyName string
+	Sequence        uint64         // Arrival order within State, assigned when the message enters it (0 = unassigned)
+	StateEnteredAt  time.Time      // When the message entered State (zero = unknown)
+	DeliveryWindow  DeliveryWindow // Scheduling constraints, zero = deliver any time
+	Version         uint64         // Incremented by every write of backends tracking versions, see VersionBackend (0 = unknown)
 }
 
 // MessageListOptions contains options for listing messages
@@ -60,6 +98,11 @@ type MessageListOptions struct {
 	SortBy    string    // Sort field: "created", "updated", "priority", "attempts"
 	SortOrder string    // Sort order: "asc" or "desc"
 	Since     time.Time // Only return messages created/updated after this time
+
+	// Consistency and MaxStaleness are the read requirements of the
+	// listing, see ReadOptions; the default keeps those of the context
+	Consistency  ConsistencyLevel
+	MaxStaleness time.Duration
 }
 
 // MessageListResult contains the result of listing messages
@@ -77,7 +120,9 @@ type Backend interface {
 	// GetMeta retrieves message metadata
 	GetMeta(ctx context.Context, messageID string) (MessageMetadata, error)
 
-	// UpdateMeta updates message metadata
+	// UpdateMeta updates message metadata. Backends implementing
+	// VersionBackend fail with ErrVersionConflict if metadata.Version is
+	// set and differs from the stored version.
 	UpdateMeta(ctx context.Context, messageID string, metadata MessageMetadata) error
 
 	// DeleteMeta removes message metadata
@@ -112,6 +157,60 @@ type StateCounterBackend interface {
 	GetStateCount(state QueueState) int64
 }
 
+// SequenceBackend extends Backend with natively assigned sequence numbers.
+// Implementations set MessageMetadata.Sequence atomically whenever a
+// message enters a state (StoreMeta, MoveToState), strictly increasing per
+// state across all clients.
+type SequenceBackend interface {
+	Backend
+
+	// LastSequence returns the highest sequence number assigned in state
+	LastSequence(ctx context.Context, state QueueState) (uint64, error)
+}
+
+// StateTimeBackend is implemented by backends that maintain
+// MessageMetadata.StateEnteredAt natively: StoreMeta sets it to Created
+// or the current time if it is unset, and MoveToState sets it atomically
+// with the move.
+type StateTimeBackend interface {
+	Backend
+
+	// TracksStateTime reports whether StateEnteredAt is maintained
+	TracksStateTime() bool
+}
+
+// ThrottleBackend extends Backend with per-group concurrency tokens stored
+// alongside the metadata, so all scheduler nodes share the same limits
+type ThrottleBackend interface {
+	Backend
+
+	// GetToken takes one of limit tokens of group for holder until ttl
+	// elapses. It returns false if all tokens are taken. Taking a token the
+	// holder already owns refreshes its ttl.
+	GetToken(ctx context.Context, group, holder string, limit int, ttl time.Duration) (bool, error)
+
+	// ReturnToken gives back the token of group owned by holder
+	ReturnToken(ctx context.Context, group, holder string) error
+}
+
+// ServerTimeBackend extends Backend with access to the backend server's clock
+type ServerTimeBackend interface {
+	Backend
+
+	// ServerTime returns the current time as seen by the backend server
+	ServerTime(ctx context.Context) (time.Time, error)
+}
+
+// SkewBackend is implemented by backends configured with
+// options.WithClockSkew. Due checks on their messages tolerate the
+// configured clock difference, see ClockSkew.
+type SkewBackend interface {
+	Backend
+
+	// ClockSkew returns the tolerated clock difference between nodes
+	ClockSkew() time.Duration
+}
+
 // MessageIterator provides streaming access to messages in a specific state
 type MessageIterator interface {
 	// Next returns the next message metadata, whether more messages are available, and any error
@@ -123,6 +222,16 @@ type MessageIterator interface {
 	Close() error
 }
 
+// ResizableIterator is implemented by iterators that fetch messages in
+// batches and can change the batch size while iterating
+type ResizableIterator interface {
+	MessageIterator
+
+	// SetBatchSize sets the number of messages fetched per backend call,
+	// starting with the next fetch. n <= 0 is ignored.
+	SetBatchSize(n int)
+}
+
 // Factory creates metadata storage backends
 type Factory interface {
 	// Create creates a new metadata storage backend
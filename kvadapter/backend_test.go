@@ -0,0 +1,113 @@
+package kvadapter
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+// mapStore is an in-memory CASStore
+type mapStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{data: make(map[string][]byte)}
+}
+
+func (s *mapStore) Get(_ context.Context, key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(v), nil
+}
+
+func (s *mapStore) Put(_ context.Context, key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[string(key)] = bytes.Clone(value)
+	return nil
+}
+
+func (s *mapStore) Delete(_ context.Context, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *mapStore) Scan(_ context.Context, prefix, after []byte, limit int) ([]KV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kvs []KV
+	for k, v := range s.data {
+		if bytes.HasPrefix([]byte(k), prefix) && bytes.Compare([]byte(k), after) > 0 {
+			kvs = append(kvs, KV{Key: []byte(k), Value: bytes.Clone(v)})
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+	}
+	return kvs, nil
+}
+
+func (s *mapStore) CompareAndSwap(_ context.Context, key, old, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.data[string(key)]
+	if old == nil && ok || old != nil && (!ok || !bytes.Equal(cur, old)) {
+		return false, nil
+	}
+	s.data[string(key)] = bytes.Clone(value)
+	return true, nil
+}
+
+func (s *mapStore) Close() error {
+	return nil
+}
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := New(context.Background(), newMapStore())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
+
+func TestTokensSharedThroughStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMapStore()
+	a, err := New(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.GetToken(ctx, "example.com", "w1", 1, time.Minute); err != nil || !ok {
+		t.Fatalf("first token: %v, %v", ok, err)
+	}
+	if ok, err := b.GetToken(ctx, "example.com", "w2", 1, time.Minute); err != nil || ok {
+		t.Fatalf("token beyond the limit: %v, %v", ok, err)
+	}
+	if err := a.ReturnToken(ctx, "example.com", "w1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.GetToken(ctx, "example.com", "w2", 1, time.Minute); err != nil || !ok {
+		t.Fatalf("token after return: %v, %v", ok, err)
+	}
+}
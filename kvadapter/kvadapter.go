@@ -0,0 +1,695 @@
+// Package kvadapter turns a simple ordered key-value store into a full
+// metastorage.Backend. The store only has to Get, Put, Delete and Scan
+// keys; the adapter keeps the state index, counts and moves on top:
+//
+//	<ns>m/<id>          encoded metadata, the source of truth
+//	<ns>s/<state>/<id>  empty, index of the messages in a state
+//	<ns>q/<state>       last sequence assigned in a state
+//	<ns>t/<group>       throttle tokens of a group, holder -> expiry
+//
+// Writes of one message are serialized in process. Stores implementing
+// CASStore make the metadata write a compare-and-swap, so MoveToState has
+// exactly one winner across processes as well; with a plain Store only
+// one process may write.
+//
+// The metadata record is written first and the index after it, so a
+// crash in between leaves stale or missing index entries. Readers check
+// every index entry against the record and skip stale ones; Recover
+// repairs the index. Counts are kept in memory, rebuilt from the index by
+// New and Recover, and only reflect the writes of this process.
+//
+// StoreMeta and MoveToState set StateEnteredAt and a Sequence in the same
+// record write that stores the message or changes its state. Sequences
+// are taken from a per-state counter key, incremented with
+// compare-and-swap on a CASStore.
+//
+// Every record write increments the message's Version; updates and moves
+// carrying a version are checked against the record they swap, see
+// metastorage.VersionBackend.
+//
+// The backend implements metastorage.ThrottleBackend: the tokens of a
+// group are one record, updated with compare-and-swap on a CASStore, so
+// group limits hold across all processes sharing the store.
+package kvadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/codec"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// ErrNotFound is returned by Store.Get for missing keys
+var ErrNotFound = errors.New("kvadapter: key not found")
+
+// lockStripes is the number of mutexes serializing writes by message ID
+const lockStripes = 64
+
+// casRetries bounds how often a write is retried after losing a
+// compare-and-swap to a concurrent writer
+const casRetries = 16
+
+// KV is a key and its value
+type KV struct {
+	Key, Value []byte
+}
+
+// Store is the key-value interface the adapter needs
+type Store interface {
+	// Get returns the value of key or ErrNotFound
+	Get(ctx context.Context, key []byte) ([]byte, error)
+
+	// Put sets the value of key
+	Put(ctx context.Context, key, value []byte) error
+
+	// Delete removes key; missing keys are not an error
+	Delete(ctx context.Context, key []byte) error
+
+	// Scan returns up to limit (all if <= 0) keys starting with prefix
+	// and greater than after, in ascending byte order
+	Scan(ctx context.Context, prefix, after []byte, limit int) ([]KV, error)
+
+	// Close releases the store
+	Close() error
+}
+
+// CASStore is a Store with an atomic compare-and-swap
+type CASStore interface {
+	Store
+
+	// CompareAndSwap sets key to value if its current value equals old;
+	// old nil means the key must not exist. It reports whether the value
+	// was set.
+	CompareAndSwap(ctx context.Context, key, old, value []byte) (bool, error)
+}
+
+// Backend implements metastorage.Backend on a Store
+type Backend struct {
+	store     Store
+	cas       CASStore // nil if store has no compare-and-swap
+	prefix    []byte
+	codec     codec.Codec
+	batchSize int
+	skew      time.Duration
+	clock     clock.Clock
+	locks     [lockStripes]sync.Mutex
+	seqMu     sync.Mutex // serializes sequence increments in process
+	tokenMu   sync.Mutex // serializes token updates in process
+
+	countsMu sync.RWMutex
+	counts   map[metastorage.QueueState]*atomic.Int64
+}
+
+// New creates a backend on store and counts the indexed messages. With a
+// namespace, all keys are prefixed with "<namespace>/".
+func New(ctx context.Context, store Store, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	b := &Backend{
+		store:     store,
+		codec:     o.Codec,
+		batchSize: o.BatchSize,
+		skew:      o.ClockSkew,
+		clock:     o.Clock,
+		counts:    make(map[metastorage.QueueState]*atomic.Int64),
+	}
+	if o.Namespace != "" {
+		b.prefix = []byte(o.Namespace + "/")
+	}
+	if cas, ok := store.(CASStore); ok {
+		b.cas = cas
+	}
+	if err := b.recount(ctx); err != nil {
+		return nil, fmt.Errorf("kvadapter: count: %w", err)
+	}
+	return b, nil
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (b *Backend) ClockSkew() time.Duration {
+	return b.skew
+}
+
+func (b *Backend) key(parts ...string) []byte {
+	k := append([]byte(nil), b.prefix...)
+	for _, p := range parts {
+		k = append(k, p...)
+	}
+	return k
+}
+
+func (b *Backend) metaKey(id string) []byte {
+	return b.key("m/", id)
+}
+
+func (b *Backend) statePrefix(state metastorage.QueueState) []byte {
+	return b.key("s/", metastorage.StateLabel(state), "/")
+}
+
+func (b *Backend) indexKey(state metastorage.QueueState, id string) []byte {
+	return b.key("s/", metastorage.StateLabel(state), "/", id)
+}
+
+func (b *Backend) sequenceKey(state metastorage.QueueState) []byte {
+	return b.key("q/", metastorage.StateLabel(state))
+}
+
+// nextSequence increments the sequence counter of state and returns the
+// new value. With a CASStore the increment is a compare-and-swap, so
+// sequences are unique across processes.
+func (b *Backend) nextSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	b.seqMu.Lock()
+	defer b.seqMu.Unlock()
+	key := b.sequenceKey(state)
+	for range casRetries {
+		old, last, err := b.lastSequence(ctx, state)
+		if err != nil {
+			return 0, err
+		}
+		next := binary.BigEndian.AppendUint64(nil, last+1)
+		if b.cas == nil {
+			return last + 1, b.store.Put(ctx, key, next)
+		}
+		ok, err := b.cas.CompareAndSwap(ctx, key, old, next)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return last + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("kvadapter: sequence of %s: %w", state, metastorage.ErrStateConflict)
+}
+
+// lastSequence returns the raw and decoded counter of state, nil and 0 if
+// no sequence was assigned yet
+func (b *Backend) lastSequence(ctx context.Context, state metastorage.QueueState) ([]byte, uint64, error) {
+	v, err := b.store.Get(ctx, b.sequenceKey(state))
+	if errors.Is(err, ErrNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(v) != 8 {
+		return nil, 0, fmt.Errorf("kvadapter: sequence of %s: invalid counter", state)
+	}
+	return v, binary.BigEndian.Uint64(v), nil
+}
+
+// LastSequence returns the highest sequence assigned in state, see
+// metastorage.SequenceBackend
+func (b *Backend) LastSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	_, last, err := b.lastSequence(ctx, state)
+	return last, err
+}
+
+// TracksStateTime reports that StateEnteredAt is maintained, see
+// metastorage.StateTimeBackend
+func (b *Backend) TracksStateTime() bool {
+	return true
+}
+
+func (b *Backend) tokenKey(group string) []byte {
+	return b.key("t/", group)
+}
+
+// updateTokens applies update to the token record of group, holder ->
+// expiry in Unix nanoseconds, and writes it back if update reports a
+// change. With a CASStore the write is a compare-and-swap, retried with
+// a fresh record after a lost swap.
+func (b *Backend) updateTokens(ctx context.Context, group string, update func(map[string]int64) bool) error {
+	b.tokenMu.Lock()
+	defer b.tokenMu.Unlock()
+	key := b.tokenKey(group)
+	for range casRetries {
+		old, err := b.store.Get(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			old, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		holders := make(map[string]int64)
+		if old != nil {
+			if err := json.Unmarshal(old, &holders); err != nil {
+				return fmt.Errorf("kvadapter: tokens of %s: %w", group, err)
+			}
+		}
+		if !update(holders) {
+			return nil
+		}
+		data, err := json.Marshal(holders)
+		if err != nil {
+			return err
+		}
+		if b.cas == nil {
+			return b.store.Put(ctx, key, data)
+		}
+		ok, err := b.cas.CompareAndSwap(ctx, key, old, data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("kvadapter: tokens of %s: %w", group, metastorage.ErrStateConflict)
+}
+
+// GetToken takes one of limit tokens of group for holder until ttl
+// elapses, see metastorage.ThrottleBackend. Expired tokens are dropped
+// from the record.
+func (b *Backend) GetToken(ctx context.Context, group, holder string, limit int, ttl time.Duration) (bool, error) {
+	var taken bool
+	err := b.updateTokens(ctx, group, func(holders map[string]int64) bool {
+		now := b.clock.Now()
+		for h, expires := range holders {
+			if now.UnixNano() >= expires {
+				delete(holders, h)
+			}
+		}
+		if _, owned := holders[holder]; !owned && len(holders) >= limit {
+			taken = false
+			return false
+		}
+		holders[holder] = now.Add(ttl).UnixNano()
+		taken = true
+		return true
+	})
+	return taken, err
+}
+
+// ReturnToken gives back the token of group owned by holder, see
+// metastorage.ThrottleBackend
+func (b *Backend) ReturnToken(ctx context.Context, group, holder string) error {
+	return b.updateTokens(ctx, group, func(holders map[string]int64) bool {
+		if _, owned := holders[holder]; !owned {
+			return false
+		}
+		delete(holders, holder)
+		return true
+	})
+}
+
+// lock serializes writes of id and returns the unlock function
+func (b *Backend) lock(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &b.locks[h.Sum32()%lockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (b *Backend) counter(state metastorage.QueueState) *atomic.Int64 {
+	b.countsMu.RLock()
+	c, ok := b.counts[state]
+	b.countsMu.RUnlock()
+	if ok {
+		return c
+	}
+	b.countsMu.Lock()
+	defer b.countsMu.Unlock()
+	if c, ok = b.counts[state]; !ok {
+		c = new(atomic.Int64)
+		b.counts[state] = c
+	}
+	return c
+}
+
+// load returns the raw and decoded record of id
+func (b *Backend) load(ctx context.Context, id string) ([]byte, metastorage.MessageMetadata, error) {
+	data, err := b.store.Get(ctx, b.metaKey(id))
+	if errors.Is(err, ErrNotFound) {
+		return nil, metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, metastorage.MessageMetadata{}, err
+	}
+	var m metastorage.MessageMetadata
+	if err := b.codec.Unmarshal(data, &m); err != nil {
+		return nil, metastorage.MessageMetadata{}, fmt.Errorf("kvadapter: %s: %w", id, err)
+	}
+	m.ID = id
+	return data, m, nil
+}
+
+// write replaces the record of id, which had the raw value old (nil if
+// absent), with m. With a CASStore it reports false if the record changed
+// since old was read.
+func (b *Backend) write(ctx context.Context, id string, old []byte, m metastorage.MessageMetadata) (bool, error) {
+	data, err := b.codec.Marshal(m)
+	if err != nil {
+		return false, err
+	}
+	if b.cas != nil {
+		return b.cas.CompareAndSwap(ctx, b.metaKey(id), old, data)
+	}
+	return true, b.store.Put(ctx, b.metaKey(id), data)
+}
+
+// reindex moves the index entry of id from one state to another
+func (b *Backend) reindex(ctx context.Context, id string, from *metastorage.QueueState, to metastorage.QueueState) error {
+	if err := b.store.Put(ctx, b.indexKey(to, id), nil); err != nil {
+		return err
+	}
+	b.counter(to).Add(1)
+	if from == nil {
+		return nil
+	}
+	b.counter(*from).Add(-1)
+	return b.store.Delete(ctx, b.indexKey(*from, id))
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID. It assigns the next sequence of the message's state and
+// sets StateEnteredAt if it is unset.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	metadata = metastorage.NormalizeTimes(metastorage.EnterState(metadata, b.clock.Now()))
+	metadata.ID = messageID
+	seq, err := b.nextSequence(ctx, metadata.State)
+	if err != nil {
+		return err
+	}
+	metadata.Sequence = seq
+	for range casRetries {
+		old, prev, err := b.load(ctx, messageID)
+		if err != nil && !errors.Is(err, metastorage.ErrMessageNotFound) {
+			return err
+		}
+		metadata.Version = prev.Version + 1
+		ok, err := b.write(ctx, messageID, old, metadata)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		switch {
+		case old == nil:
+			return b.reindex(ctx, messageID, nil, metadata.State)
+		case prev.State != metadata.State:
+			return b.reindex(ctx, messageID, &prev.State, metadata.State)
+		}
+		return nil
+	}
+	return fmt.Errorf("kvadapter: store %s: %w", messageID, metastorage.ErrStateConflict)
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	_, m, err := b.load(ctx, messageID)
+	return m, err
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	metadata = metastorage.NormalizeTimes(metadata)
+	metadata.ID = messageID
+	version := metadata.Version
+	for range casRetries {
+		old, prev, err := b.load(ctx, messageID)
+		if err != nil {
+			return err
+		}
+		if metadata.State != prev.State {
+			return fmt.Errorf("%w: %s is %s, update has %s", metastorage.ErrStateConflict, messageID, prev.State, metadata.State)
+		}
+		if err := metastorage.CheckVersion(prev, version); err != nil {
+			return err
+		}
+		metadata.Version = prev.Version + 1
+		ok, err := b.write(ctx, messageID, old, metadata)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return fmt.Errorf("kvadapter: update %s: %w", messageID, metastorage.ErrStateConflict)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	_, m, err := b.load(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if err := b.store.Delete(ctx, b.metaKey(messageID)); err != nil {
+		return err
+	}
+	b.counter(m.State).Add(-1)
+	return b.store.Delete(ctx, b.indexKey(m.State, messageID))
+}
+
+// MoveToState changes the state, StateEnteredAt and Sequence in the
+// record, then moves the index entry. With a CASStore the record is
+// swapped atomically, so concurrent moves have one winner.
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	return b.move(ctx, messageID, fromState, toState, 0)
+}
+
+// MoveToStateIfVersion is MoveToState for a message that still has
+// version, see metastorage.VersionBackend
+func (b *Backend) MoveToStateIfVersion(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	return b.move(ctx, messageID, fromState, toState, version)
+}
+
+// move moves the message if it has version, or any version for 0
+func (b *Backend) move(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer b.lock(messageID)()
+	var seq uint64
+	for range casRetries {
+		old, m, err := b.load(ctx, messageID)
+		if err != nil {
+			return err
+		}
+		if m.State != fromState {
+			return metastorage.ErrStateConflict
+		}
+		if err := metastorage.CheckVersion(m, version); err != nil {
+			return err
+		}
+		if fromState == toState {
+			return nil
+		}
+		if seq == 0 { // taken once, retries after a lost swap reuse it
+			if seq, err = b.nextSequence(ctx, toState); err != nil {
+				return err
+			}
+		}
+		m.State = toState
+		m.Sequence = seq
+		m.StateEnteredAt = b.clock.Now().UTC()
+		m.Version++
+		ok, err := b.write(ctx, messageID, old, m)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return b.reindex(ctx, messageID, &fromState, toState)
+		}
+	}
+	return metastorage.ErrStateConflict
+}
+
+// GetStateCount returns the in-memory count of state
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	return max(b.counter(state).Load(), 0)
+}
+
+// indexed returns up to limit records indexed in state after the index
+// key after, skipping stale entries, and the last index key read
+func (b *Backend) indexed(ctx context.Context, state metastorage.QueueState, after []byte, limit int) ([]metastorage.MessageMetadata, []byte, bool, error) {
+	prefix := b.statePrefix(state)
+	kvs, err := b.store.Scan(ctx, prefix, after, limit)
+	if err != nil {
+		return nil, after, false, err
+	}
+	ms := make([]metastorage.MessageMetadata, 0, len(kvs))
+	for _, kv := range kvs {
+		id := string(bytes.TrimPrefix(kv.Key, prefix))
+		_, m, err := b.load(ctx, id)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, after, false, err
+		}
+		if m.State == state {
+			ms = append(ms, m)
+		}
+	}
+	if len(kvs) > 0 {
+		after = kvs[len(kvs)-1].Key
+	}
+	return ms, after, limit > 0 && len(kvs) == limit, nil
+}
+
+// ListMessages lists the IDs of messages in state, see
+// metastorage.ListPage for the supported options. The whole state is
+// read.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	ms, _, _, err := b.indexed(ctx, state, nil, 0)
+	if err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the messages in state,
+// ordered by ID. Each batch is a Scan continuing after the last index
+// key, so changes between batches are visible.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &iterator{backend: b, state: state, batchSize: max(batchSize, 1), more: true}, nil
+}
+
+// recount rebuilds the counts from the state index
+func (b *Backend) recount(ctx context.Context) error {
+	counts := make(map[metastorage.QueueState]*atomic.Int64)
+	kvs, err := b.store.Scan(ctx, b.key("s/"), nil, 0)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		label, _, ok := bytes.Cut(bytes.TrimPrefix(kv.Key, b.key("s/")), []byte("/"))
+		if !ok {
+			continue
+		}
+		state, err := metastorage.ParseQueueState(string(label))
+		if err != nil {
+			continue
+		}
+		if counts[state] == nil {
+			counts[state] = new(atomic.Int64)
+		}
+		counts[state].Add(1)
+	}
+	b.countsMu.Lock()
+	b.counts = counts
+	b.countsMu.Unlock()
+	return nil
+}
+
+// Recover repairs the state index after a crash between a record write
+// and its index update, then rebuilds the counts; see
+// metastorage.Recover. Writes must not run concurrently.
+func (b *Backend) Recover(ctx context.Context) error {
+	records, err := b.store.Scan(ctx, b.key("m/"), nil, 0)
+	if err != nil {
+		return fmt.Errorf("kvadapter: recover: %w", err)
+	}
+	want := make(map[string]bool, len(records))
+	for _, kv := range records {
+		id := string(bytes.TrimPrefix(kv.Key, b.key("m/")))
+		var m metastorage.MessageMetadata
+		if err := b.codec.Unmarshal(kv.Value, &m); err != nil {
+			return fmt.Errorf("kvadapter: recover %s: %w", id, err)
+		}
+		key := b.indexKey(m.State, id)
+		want[string(key)] = true
+		if err := b.store.Put(ctx, key, nil); err != nil {
+			return fmt.Errorf("kvadapter: recover: %w", err)
+		}
+	}
+	index, err := b.store.Scan(ctx, b.key("s/"), nil, 0)
+	if err != nil {
+		return fmt.Errorf("kvadapter: recover: %w", err)
+	}
+	for _, kv := range index {
+		if want[string(kv.Key)] {
+			continue
+		}
+		if err := b.store.Delete(ctx, kv.Key); err != nil {
+			return fmt.Errorf("kvadapter: recover: %w", err)
+		}
+	}
+	return b.recount(ctx)
+}
+
+// Close closes the store
+func (b *Backend) Close() error {
+	return b.store.Close()
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	batchSize int
+	after     []byte
+	batch     []metastorage.MessageMetadata
+	more      bool
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	for len(it.batch) == 0 {
+		if !it.more {
+			return metastorage.MessageMetadata{}, false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+		var err error
+		it.batch, it.after, it.more, err = it.backend.indexed(ctx, it.state, it.after, it.batchSize)
+		if err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *iterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = n
+	}
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.more = false
+	it.batch = nil
+	return nil
+}
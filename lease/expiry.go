@@ -0,0 +1,285 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/middleware/watch"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricLeasesExpired counts leases that ran out without being released
+const MetricLeasesExpired = "metastorage_leases_expired_total"
+
+// DefaultSweepInterval is how often the ExpiryMonitor rescans the active
+// state to catch changes it was not told about
+const DefaultSweepInterval = time.Minute
+
+// watch reconnect backoff
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+type (
+	sweepIntervalKey struct{}
+	expiryHandlerKey struct{}
+)
+
+// WithSweepInterval sets how often the active state is rescanned (default
+// DefaultSweepInterval). Without a watchable backend this is the only way
+// new claims are discovered.
+func WithSweepInterval(d time.Duration) options.Option {
+	return options.WithValue(sweepIntervalKey{}, d)
+}
+
+// WithExpiryHandler sets a function called for every expired lease, in
+// addition to publishing the event to the backend's watchers
+func WithExpiryHandler(h func(metastorage.Event)) options.Option {
+	return options.WithValue(expiryHandlerKey{}, h)
+}
+
+type claim struct {
+	owner    string
+	expires  time.Time
+	notified bool
+}
+
+// ExpiryMonitor reports leases of claimed messages (see
+// metastorage.ClaimBatch) that expire while the message is still active,
+// i.e. the worker crashed or hung instead of finishing or releasing it.
+//
+// Each expiry is reported once as a metastorage.EventLeaseExpired event:
+// to the handler set with WithExpiryHandler, and to the watchers of the
+// backend if it implements watch.Publisher (the watch middleware does).
+// The monitor learns about claims through the backend's Watch when
+// available and otherwise through periodic sweeps of the active state.
+type ExpiryMonitor struct {
+	backend   metastorage.Backend
+	publisher watch.Publisher
+	handler   func(metastorage.Event)
+	interval  time.Duration
+	skew      time.Duration
+	clock     clock.Clock
+	logger    *slog.Logger
+	metrics   metrics.Recorder
+
+	claims map[string]*claim // only accessed by Run
+}
+
+// NewExpiryMonitor creates a monitor for the claims in backend. It does
+// nothing until Run is called.
+func NewExpiryMonitor(backend metastorage.Backend, opts ...options.Option) *ExpiryMonitor {
+	o := options.Apply(opts...)
+	m := &ExpiryMonitor{
+		backend:  backend,
+		handler:  options.ValueOr[func(metastorage.Event)](o, expiryHandlerKey{}, nil),
+		interval: options.ValueOr(o, sweepIntervalKey{}, DefaultSweepInterval),
+		skew:     o.ClockSkew,
+		clock:    o.Clock,
+		logger:   o.Logger,
+		metrics:  o.Metrics,
+		claims:   make(map[string]*claim),
+	}
+	if p, ok := metastorage.As[watch.Publisher](backend); ok {
+		m.publisher = p
+	}
+	return m
+}
+
+// Run monitors leases until ctx is done and returns ctx.Err()
+func (m *ExpiryMonitor) Run(ctx context.Context) error {
+	watcher, canWatch := metastorage.As[metastorage.WatchBackend](m.backend)
+	var events <-chan metastorage.Event
+	var retry <-chan time.Time
+	backoff := minBackoff
+
+	subscribe := func() {
+		ch, err := watcher.Watch(ctx)
+		if err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				canWatch = false
+				return
+			}
+			m.logger.Warn("metastorage lease monitor: watch failed", slog.String("error", err.Error()))
+			retry = time.After(backoff)
+			backoff = min(backoff*2, maxBackoff)
+			return
+		}
+		backoff = minBackoff
+		events = ch
+	}
+	if canWatch {
+		subscribe()
+	}
+	// subscribe before the first sweep, so no claim falls in between
+	m.sweep(ctx)
+
+	sweep := time.NewTicker(m.interval)
+	defer sweep.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		m.check(ctx)
+		resetTimer(timer, m.nextWake())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				// events may have been missed, resynchronize
+				events = nil
+				subscribe()
+				m.sweep(ctx)
+				continue
+			}
+			m.apply(ctx, e)
+		case <-retry:
+			retry = nil
+			if canWatch {
+				subscribe()
+				m.sweep(ctx)
+			}
+		case <-sweep.C:
+			m.sweep(ctx)
+		case <-timer.C:
+		}
+	}
+}
+
+// nextWake returns the time until the earliest unreported expiry
+func (m *ExpiryMonitor) nextWake() time.Duration {
+	var next time.Time
+	for _, c := range m.claims {
+		if !c.notified && (next.IsZero() || c.expires.Before(next)) {
+			next = c.expires
+		}
+	}
+	if next.IsZero() {
+		return m.interval
+	}
+	return max(next.Add(m.skew).Sub(m.clock.Now()), 0)
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// sweep rebuilds the tracked claims from the active state
+func (m *ExpiryMonitor) sweep(ctx context.Context) {
+	iter, err := m.backend.NewMessageIterator(ctx, metastorage.StateActive, 100)
+	if err != nil {
+		m.logger.Warn("metastorage lease monitor: sweep failed", slog.String("error", err.Error()))
+		return
+	}
+	defer iter.Close()
+
+	seen := make(map[string]bool, len(m.claims))
+	for {
+		msg, more, err := iter.Next(ctx)
+		if err != nil {
+			m.logger.Warn("metastorage lease monitor: sweep failed", slog.String("error", err.Error()))
+			return
+		}
+		if !more {
+			break
+		}
+		seen[msg.ID] = true
+		m.track(msg)
+	}
+	for id := range m.claims {
+		if !seen[id] {
+			delete(m.claims, id)
+		}
+	}
+}
+
+// track records the lease of an active message, keeping the reported
+// flag while the lease is unchanged
+func (m *ExpiryMonitor) track(msg metastorage.MessageMetadata) {
+	owner, expires, ok := metastorage.LeaseOf(msg)
+	if !ok || expires.IsZero() || msg.State != metastorage.StateActive {
+		delete(m.claims, msg.ID)
+		return
+	}
+	if c, ok := m.claims[msg.ID]; ok && c.owner == owner && c.expires.Equal(expires) {
+		return
+	}
+	m.claims[msg.ID] = &claim{owner: owner, expires: expires}
+}
+
+func (m *ExpiryMonitor) apply(ctx context.Context, e metastorage.Event) {
+	switch {
+	case e.Type == metastorage.EventLeaseExpired:
+		// possibly our own event echoed back
+	case e.Type == metastorage.EventDeleted, e.State != metastorage.StateActive:
+		delete(m.claims, e.MessageID)
+	default:
+		// claimed or lease renewed, the event does not carry the lease
+		m.refresh(ctx, e.MessageID)
+	}
+}
+
+func (m *ExpiryMonitor) refresh(ctx context.Context, id string) {
+	msg, err := m.backend.GetMeta(ctx, id)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		delete(m.claims, id)
+		return
+	}
+	if err != nil {
+		// the next sweep picks it up
+		m.logger.Warn("metastorage lease monitor: reading message failed",
+			slog.String("message_id", id), slog.String("error", err.Error()))
+		return
+	}
+	m.track(msg)
+}
+
+// check reports all due expiries that are confirmed by the backend
+func (m *ExpiryMonitor) check(ctx context.Context) {
+	now := m.clock.Now()
+	for id, c := range m.claims {
+		if c.notified || now.Before(c.expires.Add(m.skew)) {
+			continue
+		}
+		// the lease may have been renewed or released meanwhile
+		m.refresh(ctx, id)
+		c, ok := m.claims[id]
+		if !ok || c.notified || now.Before(c.expires.Add(m.skew)) {
+			continue
+		}
+		c.notified = true
+		m.report(metastorage.Event{
+			Type:       metastorage.EventLeaseExpired,
+			MessageID:  id,
+			State:      metastorage.StateActive,
+			LeaseOwner: c.owner,
+			Time:       now.UTC(),
+		})
+	}
+}
+
+func (m *ExpiryMonitor) report(e metastorage.Event) {
+	m.logger.Warn("metastorage lease expired without release",
+		slog.String("message_id", e.MessageID), slog.String("owner", e.LeaseOwner))
+	m.metrics.Counter(MetricLeasesExpired, nil, 1)
+	if m.publisher != nil {
+		m.publisher.Publish(e)
+	}
+	if m.handler != nil {
+		m.handler(e)
+	}
+}
@@ -0,0 +1,159 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// DefaultJitter is the default fraction by which renewal intervals vary
+const DefaultJitter = 0.1
+
+type (
+	renewIntervalKey struct{}
+	jitterKey        struct{}
+	renewFailedKey   struct{}
+	leaseLostKey     struct{}
+)
+
+// WithRenewInterval sets how often a Keeper renews leases (default a third
+// of the lease duration)
+func WithRenewInterval(d time.Duration) options.Option {
+	return options.WithValue(renewIntervalKey{}, d)
+}
+
+// WithJitter sets the fraction (0..1) by which renewal intervals are varied
+// randomly, so workers started together do not renew in lockstep (default
+// DefaultJitter)
+func WithJitter(f float64) options.Option {
+	return options.WithValue(jitterKey{}, f)
+}
+
+// WithRenewFailed sets a function called for every failed renewal. The
+// Keeper keeps trying until the lease has expired.
+func WithRenewFailed(f func(messageID string, err error)) options.Option {
+	return options.WithValue(renewFailedKey{}, f)
+}
+
+// WithLeaseLost sets a function called when a Keeper gives up a lease,
+// because another worker owns the message or it expired before it could
+// be renewed
+func WithLeaseLost(f func(messageID string, err error)) options.Option {
+	return options.WithValue(leaseLostKey{}, f)
+}
+
+// Keeper renews the leases of messages claimed by one worker while they
+// are being processed, so long-running deliveries keep ownership
+type Keeper struct {
+	backend  metastorage.Backend
+	workerID string
+	lease    time.Duration
+	interval time.Duration
+	jitter   float64
+	onFailed func(string, error)
+	onLost   func(string, error)
+	clock    clock.Clock
+	logger   *slog.Logger
+}
+
+// NewKeeper creates a Keeper renewing leases of workerID for lease at a time
+func NewKeeper(backend metastorage.Backend, workerID string, lease time.Duration, opts ...options.Option) *Keeper {
+	o := options.Apply(opts...)
+	return &Keeper{
+		backend:  backend,
+		workerID: workerID,
+		lease:    lease,
+		interval: options.ValueOr(o, renewIntervalKey{}, lease/3),
+		jitter:   min(max(options.ValueOr(o, jitterKey{}, DefaultJitter), 0), 1),
+		onFailed: options.ValueOr[func(string, error)](o, renewFailedKey{}, nil),
+		onLost:   options.ValueOr[func(string, error)](o, leaseLostKey{}, nil),
+		clock:    o.Clock,
+		logger:   o.Logger,
+	}
+}
+
+// Keep renews the lease of a claimed message in the background until stop
+// is called or ctx is done. The returned context is canceled with cause
+// metastorage.ErrLeaseLost when the lease is lost, so the handler can
+// abort a delivery it no longer owns. stop waits for a running renewal to
+// finish.
+//
+//	ctx, stop := keeper.Keep(ctx, m.ID)
+//	defer stop()
+func (k *Keeper) Keep(ctx context.Context, messageID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		k.run(ctx, cancel, done, messageID)
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			cancel(context.Canceled)
+		})
+	}
+}
+
+func (k *Keeper) run(ctx context.Context, cancel context.CancelCauseFunc, done <-chan struct{}, messageID string) {
+	// the claim set an expiry of at most lease from now
+	expires := k.clock.Now().Add(k.lease)
+	timer := time.NewTimer(k.next())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		next, err := metastorage.ExtendLease(ctx, k.backend, messageID, k.workerID, k.lease)
+		if err == nil {
+			expires = next
+			timer.Reset(k.next())
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(err, metastorage.ErrLeaseLost) {
+			k.logger.Warn("metastorage lease renewal failed",
+				slog.String("message_id", messageID), slog.String("error", err.Error()))
+			if k.onFailed != nil {
+				k.onFailed(messageID, err)
+			}
+			if left := expires.Sub(k.clock.Now()); left > 0 {
+				// retry sooner, the lease is running out
+				timer.Reset(max(min(k.next(), left/2), time.Millisecond))
+				continue
+			}
+			err = errors.Join(metastorage.ErrLeaseLost, err)
+		}
+		k.logger.Warn("metastorage lease lost",
+			slog.String("message_id", messageID), slog.String("error", err.Error()))
+		if k.onLost != nil {
+			k.onLost(messageID, err)
+		}
+		cancel(err)
+		return
+	}
+}
+
+// next returns the jittered renewal interval
+func (k *Keeper) next() time.Duration {
+	d := float64(k.interval) * (1 + k.jitter*(2*rand.Float64()-1))
+	return max(time.Duration(d), time.Millisecond)
+}
@@ -0,0 +1,287 @@
+// Package lease keeps bookkeeping of leases and locks held by workers and
+// detects coordination states that will not resolve on their own: leases
+// that are never renewed (crashed or hung holders), expired leases that
+// were never released, and holders that wait for each other (cross-held
+// locks). ForceRelease lets operators break such states without
+// restarting nodes.
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// ErrNotHeld is returned when a lease is not held (by the given holder)
+var ErrNotHeld = errors.New("lease not held")
+
+// Lease is the bookkeeping record of one held resource
+type Lease struct {
+	Resource string
+	Holder   string
+	Acquired time.Time
+	Renewed  time.Time // zero if never renewed
+	Expires  time.Time
+	Renewals int
+}
+
+// Kind classifies a finding
+type Kind string
+
+const (
+	KindNeverRenewed Kind = "never-renewed" // held longer than the threshold without renewal
+	KindExpired      Kind = "expired"       // past expiry but never released
+	KindCycle        Kind = "cycle"         // holders waiting on each other's resources
+)
+
+// Finding describes a stuck coordination state
+type Finding struct {
+	Kind      Kind
+	Resources []string
+	Holders   []string
+	Detail    string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Kind, f.Detail)
+}
+
+// Releaser releases a lease in the underlying store. It is invoked by
+// ForceRelease before the bookkeeping entry is dropped.
+type Releaser func(ctx context.Context, l Lease) error
+
+type releaserKey struct{}
+
+// WithReleaser sets the function ForceRelease uses to release leases in the store
+func WithReleaser(r Releaser) options.Option {
+	return options.WithValue(releaserKey{}, r)
+}
+
+// Tracker records lease activity. It is safe for concurrent use.
+type Tracker struct {
+	clock    clock.Clock
+	releaser Releaser
+
+	mu      sync.Mutex
+	leases  map[string]*Lease
+	waiting map[string]string // holder -> resource it waits for
+}
+
+// NewTracker creates an empty tracker
+func NewTracker(opts ...options.Option) *Tracker {
+	o := options.Apply(opts...)
+	return &Tracker{
+		clock:    o.Clock,
+		releaser: options.ValueOr[Releaser](o, releaserKey{}, nil),
+		leases:   make(map[string]*Lease),
+		waiting:  make(map[string]string),
+	}
+}
+
+// Acquired records that holder obtained resource for ttl
+func (t *Tracker) Acquired(resource, holder string, ttl time.Duration) {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.leases[resource] = &Lease{Resource: resource, Holder: holder, Acquired: now, Expires: now.Add(ttl)}
+	if t.waiting[holder] == resource {
+		delete(t.waiting, holder)
+	}
+}
+
+// Renewed records that holder extended resource by ttl
+func (t *Tracker) Renewed(resource, holder string, ttl time.Duration) error {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.leases[resource]
+	if !ok || l.Holder != holder {
+		return ErrNotHeld
+	}
+	l.Renewed = now
+	l.Expires = now.Add(ttl)
+	l.Renewals++
+	return nil
+}
+
+// Released records that holder gave up resource
+func (t *Tracker) Released(resource, holder string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.leases[resource]
+	if !ok || l.Holder != holder {
+		return ErrNotHeld
+	}
+	delete(t.leases, resource)
+	return nil
+}
+
+// Waiting records that holder is blocked waiting for resource
+func (t *Tracker) Waiting(holder, resource string) {
+	t.mu.Lock()
+	t.waiting[holder] = resource
+	t.mu.Unlock()
+}
+
+// DoneWaiting clears the wait record of holder
+func (t *Tracker) DoneWaiting(holder string) {
+	t.mu.Lock()
+	delete(t.waiting, holder)
+	t.mu.Unlock()
+}
+
+// Leases returns all tracked leases sorted by resource
+func (t *Tracker) Leases() []Lease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Lease, 0, len(t.leases))
+	for _, l := range t.leases {
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Resource < out[j].Resource })
+	return out
+}
+
+// Get returns the lease on resource
+func (t *Tracker) Get(resource string) (Lease, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.leases[resource]
+	if !ok {
+		return Lease{}, false
+	}
+	return *l, true
+}
+
+// Detect reports leases held for longer than neverRenewedAfter without a
+// renewal, expired leases that were never released, and wait-for cycles
+// between holders. A zero neverRenewedAfter disables that check.
+func (t *Tracker) Detect(neverRenewedAfter time.Duration) []Finding {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var findings []Finding
+	resources := make([]string, 0, len(t.leases))
+	for r := range t.leases {
+		resources = append(resources, r)
+	}
+	sort.Strings(resources)
+
+	for _, r := range resources {
+		l := t.leases[r]
+		switch {
+		case now.After(l.Expires):
+			findings = append(findings, Finding{
+				Kind:      KindExpired,
+				Resources: []string{r},
+				Holders:   []string{l.Holder},
+				Detail:    fmt.Sprintf("%s held by %s expired %s ago without release", r, l.Holder, now.Sub(l.Expires).Round(time.Second)),
+			})
+		case neverRenewedAfter > 0 && l.Renewals == 0 && now.Sub(l.Acquired) > neverRenewedAfter:
+			findings = append(findings, Finding{
+				Kind:      KindNeverRenewed,
+				Resources: []string{r},
+				Holders:   []string{l.Holder},
+				Detail:    fmt.Sprintf("%s held by %s for %s without renewal", r, l.Holder, now.Sub(l.Acquired).Round(time.Second)),
+			})
+		}
+	}
+	return append(findings, t.cycles()...)
+}
+
+// cycles finds holders that transitively wait for a resource they hold
+// themselves. t.mu must be held.
+func (t *Tracker) cycles() []Finding {
+	holders := make([]string, 0, len(t.waiting))
+	for h := range t.waiting {
+		holders = append(holders, h)
+	}
+	sort.Strings(holders)
+
+	var findings []Finding
+	reported := make(map[string]bool)
+	for _, start := range holders {
+		if reported[start] {
+			continue
+		}
+		var path []string
+		var res []string
+		visited := make(map[string]int)
+		h := start
+		for {
+			if i, seen := visited[h]; seen {
+				cycleHolders := path[i:]
+				cycleResources := res[i:]
+				if reported[cycleHolders[0]] {
+					break // same cycle reached from a holder outside of it
+				}
+				for _, ch := range cycleHolders {
+					reported[ch] = true
+				}
+				findings = append(findings, Finding{
+					Kind:      KindCycle,
+					Resources: append([]string(nil), cycleResources...),
+					Holders:   append([]string(nil), cycleHolders...),
+					Detail:    describeCycle(cycleHolders, cycleResources),
+				})
+				break
+			}
+			want, waiting := t.waiting[h]
+			if !waiting {
+				break
+			}
+			l, held := t.leases[want]
+			if !held {
+				break
+			}
+			visited[h] = len(path)
+			path = append(path, h)
+			res = append(res, want)
+			h = l.Holder
+		}
+	}
+	return findings
+}
+
+func describeCycle(holders, resources []string) string {
+	parts := make([]string, len(holders))
+	for i := range holders {
+		parts[i] = fmt.Sprintf("%s waits for %s", holders[i], resources[i])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ForceRelease releases the lease on resource regardless of its holder.
+// The configured Releaser (see WithReleaser) is invoked first; the
+// bookkeeping entry is only removed if it succeeds.
+func (t *Tracker) ForceRelease(ctx context.Context, resource string) (Lease, error) {
+	t.mu.Lock()
+	l, ok := t.leases[resource]
+	if !ok {
+		t.mu.Unlock()
+		return Lease{}, ErrNotHeld
+	}
+	lease := *l
+	t.mu.Unlock()
+
+	if t.releaser != nil {
+		if err := t.releaser(ctx, lease); err != nil {
+			return lease, fmt.Errorf("force release %s: %w", resource, err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if current, ok := t.leases[resource]; ok && current.Holder == lease.Holder && current.Acquired.Equal(lease.Acquired) {
+		delete(t.leases, resource)
+	}
+	return lease, nil
+}
@@ -0,0 +1,101 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+func TestDetect(t *testing.T) {
+	c := clock.NewManual(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	tr := NewTracker(options.WithClock(c))
+	tr.Acquired("renewed", "w1", time.Hour)
+	tr.Acquired("idle", "w2", time.Hour)
+	tr.Acquired("short", "w3", time.Minute)
+	c.Advance(30 * time.Second)
+	if err := tr.Renewed("renewed", "w1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Renewed("renewed", "w2", time.Hour); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("renewal by another holder: %v, want ErrNotHeld", err)
+	}
+	c.Advance(10 * time.Minute)
+
+	findings := tr.Detect(5 * time.Minute)
+	if len(findings) != 2 {
+		t.Fatalf("findings = %v, want 2", findings)
+	}
+	if f := findings[0]; f.Kind != KindNeverRenewed || f.Resources[0] != "idle" {
+		t.Errorf("first finding = %v, want idle never renewed", f)
+	}
+	if f := findings[1]; f.Kind != KindExpired || f.Resources[0] != "short" {
+		t.Errorf("second finding = %v, want short expired", f)
+	}
+	if findings := tr.Detect(0); len(findings) != 1 {
+		t.Errorf("findings without renewal check = %v, want only the expired lease", findings)
+	}
+}
+
+func TestDetectCycle(t *testing.T) {
+	tr := NewTracker()
+	tr.Acquired("a", "w1", time.Hour)
+	tr.Acquired("b", "w2", time.Hour)
+	tr.Waiting("w1", "b")
+	tr.Waiting("w2", "a")
+	tr.Waiting("w3", "a") // waits on the cycle without being part of it
+
+	findings := tr.Detect(0)
+	if len(findings) != 1 || findings[0].Kind != KindCycle {
+		t.Fatalf("findings = %v, want one cycle", findings)
+	}
+	if got := findings[0].Holders; len(got) != 2 || got[0] != "w1" || got[1] != "w2" {
+		t.Errorf("cycle holders = %v, want [w1 w2]", got)
+	}
+
+	tr.DoneWaiting("w2")
+	if findings := tr.Detect(0); len(findings) != 0 {
+		t.Errorf("findings after the wait ended = %v", findings)
+	}
+}
+
+func TestForceRelease(t *testing.T) {
+	ctx := context.Background()
+	fail := errors.New("store unavailable")
+	var released []Lease
+	var err error
+	tr := NewTracker(WithReleaser(func(_ context.Context, l Lease) error {
+		if err != nil {
+			return err
+		}
+		released = append(released, l)
+		return nil
+	}))
+	tr.Acquired("a", "w1", time.Hour)
+
+	err = fail
+	if _, got := tr.ForceRelease(ctx, "a"); !errors.Is(got, fail) {
+		t.Fatalf("ForceRelease = %v, want the releaser error", got)
+	}
+	if _, ok := tr.Get("a"); !ok {
+		t.Fatal("lease dropped although the release failed")
+	}
+
+	err = nil
+	l, got := tr.ForceRelease(ctx, "a")
+	if got != nil || l.Holder != "w1" {
+		t.Fatalf("ForceRelease = %v, %v", l, got)
+	}
+	if len(released) != 1 || released[0].Resource != "a" {
+		t.Errorf("released = %v, want a", released)
+	}
+	if _, ok := tr.Get("a"); ok {
+		t.Error("lease still tracked after ForceRelease")
+	}
+	if _, got := tr.ForceRelease(ctx, "a"); !errors.Is(got, ErrNotHeld) {
+		t.Errorf("second ForceRelease = %v, want ErrNotHeld", got)
+	}
+}
@@ -0,0 +1,66 @@
+package metastorage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ListPage applies opts to all messages of a state for backends that
+// cannot filter and sort natively. Messages are sorted by SortBy
+// ("created" by default, "updated", "priority", "attempts") in SortOrder
+// ("asc" by default), ties broken by ID. Since selects messages created or
+// updated after it. A Limit <= 0 only reports the Total. ms is reordered.
+func ListPage(ms []MessageMetadata, opts MessageListOptions) (MessageListResult, error) {
+	less, err := listLess(opts.SortBy)
+	if err != nil {
+		return MessageListResult{}, err
+	}
+	desc := strings.EqualFold(opts.SortOrder, "desc")
+
+	matches := ms[:0]
+	for _, m := range ms {
+		if opts.Since.IsZero() || m.Created.After(opts.Since) || m.Updated.After(opts.Since) {
+			matches = append(matches, m)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if desc {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
+	})
+
+	res := MessageListResult{Total: len(matches)}
+	start := max(opts.Offset, 0)
+	if opts.Limit <= 0 || start >= len(matches) {
+		return res, nil
+	}
+	end := min(start+opts.Limit, len(matches))
+	for _, m := range matches[start:end] {
+		res.MessageIDs = append(res.MessageIDs, m.ID)
+	}
+	res.HasMore = end < len(matches)
+	return res, nil
+}
+
+func listLess(field string) (func(a, b MessageMetadata) bool, error) {
+	switch strings.ToLower(field) {
+	case "", "created":
+		return func(a, b MessageMetadata) bool { return a.Created.Before(b.Created) }, nil
+	case "updated":
+		return func(a, b MessageMetadata) bool { return a.Updated.Before(b.Updated) }, nil
+	case "priority":
+		return func(a, b MessageMetadata) bool { return a.Priority < b.Priority }, nil
+	case "attempts":
+		return func(a, b MessageMetadata) bool { return a.Attempts < b.Attempts }, nil
+	}
+	return nil, fmt.Errorf("unknown sort field %q", field)
+}
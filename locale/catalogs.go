@@ -0,0 +1,47 @@
+package locale
+
+var english = Messages{
+	"state.incoming": "Incoming",
+	"state.active":   "Active",
+	"state.deferred": "Deferred",
+	"state.hold":     "On hold",
+	"state.bounce":   "Bounced",
+	"state.archived": "Archived",
+
+	"error.message_not_found": "Message not found",
+	"error.state_conflict":    "The message was changed by someone else",
+	"error.version_conflict":  "The message was changed since it was loaded",
+	"error.invalid_state":     "This state change is not allowed",
+	"error.pinned":            "The message is pinned and cannot be deleted",
+	"error.non_utc_timestamp": "A timestamp is not in UTC",
+	"error.stale_write":       "A newer version of the message is stored",
+	"error.lease_lost":        "The claim on the message has expired",
+	"error.draining":          "The node is shutting down",
+	"error.backend_closed":    "The storage is closed",
+	"error.unsupported":       "Not supported by this storage",
+	"error.timeout":           "The request timed out",
+	"error.canceled":          "The request was canceled",
+}
+
+var german = Messages{
+	"state.incoming": "Eingang",
+	"state.active":   "In Zustellung",
+	"state.deferred": "Zurückgestellt",
+	"state.hold":     "Angehalten",
+	"state.bounce":   "Unzustellbar",
+	"state.archived": "Archiviert",
+
+	"error.message_not_found": "Nachricht nicht gefunden",
+	"error.state_conflict":    "Die Nachricht wurde zwischenzeitlich geändert",
+	"error.version_conflict":  "Die Nachricht wurde seit dem Laden geändert",
+	"error.invalid_state":     "Dieser Statuswechsel ist nicht erlaubt",
+	"error.pinned":            "Die Nachricht ist angeheftet und kann nicht gelöscht werden",
+	"error.non_utc_timestamp": "Ein Zeitstempel ist nicht in UTC",
+	"error.stale_write":       "Es ist eine neuere Version der Nachricht gespeichert",
+	"error.lease_lost":        "Die Reservierung der Nachricht ist abgelaufen",
+	"error.draining":          "Der Knoten wird heruntergefahren",
+	"error.backend_closed":    "Der Speicher ist geschlossen",
+	"error.unsupported":       "Von diesem Speicher nicht unterstützt",
+	"error.timeout":           "Zeitüberschreitung der Anfrage",
+	"error.canceled":          "Die Anfrage wurde abgebrochen",
+}
@@ -0,0 +1,231 @@
+// Package locale maps queue states and error classes to display strings
+// in the language of the reader, for APIs and consoles embedding spool
+// views. Catalogs are pluggable: English and German are built in, and
+// Register adds or replaces the catalog of a language.
+//
+// Catalog keys are "state.<name>" for states, e.g. "state.deferred", and
+// "error.<class>" for error classes as returned by ErrorClass, e.g.
+// "error.state_conflict". Keys missing in a catalog fall back to the base
+// language ("de" for "de-CH"), then to English.
+package locale
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// DefaultLanguage is the language of the last fallback
+const DefaultLanguage = "en"
+
+// Catalog provides the display strings of one language
+type Catalog interface {
+	// Text returns the string for key and whether the catalog has it
+	Text(key string) (string, bool)
+}
+
+// Messages is a Catalog backed by a map from key to display string
+type Messages map[string]string
+
+// Text returns the string for key
+func (m Messages) Text(key string) (string, bool) {
+	s, ok := m[key]
+	return s, ok
+}
+
+var (
+	mu       sync.RWMutex
+	catalogs = map[string]Catalog{
+		"en": english,
+		"de": german,
+	}
+)
+
+// Register makes c the catalog of lang, e.g. "fr" or "pt-BR", replacing a
+// previously registered or built-in one
+func Register(lang string, c Catalog) {
+	mu.Lock()
+	defer mu.Unlock()
+	catalogs[normalize(lang)] = c
+}
+
+// Languages returns the sorted languages with a catalog
+func Languages() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// normalize returns lang in lower case with "-" separators and without
+// an encoding suffix, so "de_DE.UTF-8" becomes "de-de"
+func normalize(lang string) string {
+	lang, _, _ = strings.Cut(lang, ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+// Localizer looks up display strings in the catalogs of preferred
+// languages. The zero Localizer uses English.
+type Localizer struct {
+	langs []string // normalized, most preferred first
+}
+
+// For returns a Localizer for the given languages, most preferred first.
+// Environment values such as "de_DE.UTF-8" are accepted.
+func For(langs ...string) Localizer {
+	var l Localizer
+	for _, lang := range langs {
+		lang = normalize(lang)
+		if lang == "" || lang == "c" || lang == "posix" {
+			continue
+		}
+		l.langs = append(l.langs, lang)
+		if base, _, ok := strings.Cut(lang, "-"); ok {
+			l.langs = append(l.langs, base)
+		}
+	}
+	return l
+}
+
+// FromAcceptLanguage returns a Localizer for the languages of an HTTP
+// Accept-Language header, ordered by their quality values
+func FromAcceptLanguage(header string) Localizer {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var ws []weighted
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(part, ";")
+		lang = strings.TrimSpace(lang)
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q > 0 {
+			ws = append(ws, weighted{lang, q})
+		}
+	}
+	slices.SortStableFunc(ws, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	langs := make([]string, len(ws))
+	for i, w := range ws {
+		langs[i] = w.lang
+	}
+	return For(langs...)
+}
+
+// Language returns the most preferred language with a catalog, or
+// DefaultLanguage, e.g. for a Content-Language header
+func (l Localizer) Language() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, lang := range l.langs {
+		if _, ok := catalogs[lang]; ok {
+			return lang
+		}
+	}
+	return DefaultLanguage
+}
+
+// Text returns the display string of key, or key itself if no catalog in
+// the fallback chain has it
+func (l Localizer) Text(key string) string {
+	if s, ok := l.lookup(key); ok {
+		return s
+	}
+	return key
+}
+
+func (l Localizer) lookup(key string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, lang := range slices.Concat(l.langs, []string{DefaultLanguage}) {
+		if c, ok := catalogs[lang]; ok {
+			if s, ok := c.Text(key); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// State returns the display name of s. States without a catalog entry
+// are shown by their metastorage.StateLabel.
+func (l Localizer) State(s metastorage.QueueState) string {
+	label := metastorage.StateLabel(s)
+	if text, ok := l.lookup("state." + label); ok {
+		return text
+	}
+	return label
+}
+
+// Error returns the display string of the class of err. Errors of no
+// known class, or of a class without catalog entry, are shown by their
+// own message.
+func (l Localizer) Error(err error) string {
+	if err == nil {
+		return ""
+	}
+	if class := ErrorClass(err); class != "" {
+		if text, ok := l.lookup("error." + class); ok {
+			return text
+		}
+	}
+	return err.Error()
+}
+
+// errorClasses maps errors to class names, most specific first
+var errorClasses = []struct {
+	err   error
+	class string
+}{
+	{metastorage.ErrMessageNotFound, "message_not_found"},
+	{metastorage.ErrStateConflict, "state_conflict"},
+	{metastorage.ErrVersionConflict, "version_conflict"},
+	{metastorage.ErrInvalidState, "invalid_state"},
+	{metastorage.ErrPinned, "pinned"},
+	{metastorage.ErrNonUTCTimestamp, "non_utc_timestamp"},
+	{metastorage.ErrStaleWrite, "stale_write"},
+	{metastorage.ErrLeaseLost, "lease_lost"},
+	{metastorage.ErrDraining, "draining"},
+	{metastorage.ErrBackendClosed, "backend_closed"},
+	{errors.ErrUnsupported, "unsupported"},
+	{context.DeadlineExceeded, "timeout"},
+	{context.Canceled, "canceled"},
+}
+
+// ErrorClass returns the class name of err used in catalog keys, e.g.
+// "state_conflict", or "" for errors of no known class
+func ErrorClass(err error) string {
+	for _, c := range errorClasses {
+		if errors.Is(err, c.err) {
+			return c.class
+		}
+	}
+	return ""
+}
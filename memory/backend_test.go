@@ -0,0 +1,26 @@
+package memory
+
+import (
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+)
+
+func TestMoveRace(t *testing.T) {
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		return New()
+	})
+}
+
+func TestVersions(t *testing.T) {
+	metatest.RunVersionSuite(t, func(t *testing.T) metastorage.Backend {
+		return New()
+	})
+}
+
+func TestTxn(t *testing.T) {
+	metatest.RunTxnSuite(t, func(t *testing.T) metastorage.Backend {
+		return New()
+	})
+}
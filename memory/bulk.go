@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// MoveStateBulk moves the matching messages of fromState under the lock,
+// see metastorage.BulkMoveBackend. filter is called with the lock held.
+// After an error the messages moved before stay moved and are counted.
+func (b *Backend) MoveStateBulk(ctx context.Context, fromState, toState metastorage.QueueState, filter func(metastorage.MessageMetadata) bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if fromState == toState {
+		return 0, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, metastorage.ErrBackendClosed
+	}
+	var moving []metastorage.MessageMetadata
+	for id := range b.states[fromState] {
+		if m := b.messages[id]; filter == nil || filter(clone(m)) {
+			moving = append(moving, m)
+		}
+	}
+	slices.SortFunc(moving, func(x, y metastorage.MessageMetadata) int {
+		return cmp.Or(cmp.Compare(x.Sequence, y.Sequence), cmp.Compare(x.ID, y.ID))
+	})
+	for i, m := range moving {
+		if err := b.transition(m.ID, fromState, toState, 0); err != nil {
+			return i, err
+		}
+	}
+	return len(moving), nil
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// ClaimNext selects, moves and marks the next due message of state under
+// the lock, see metastorage.ClaimBackend
+func (b *Backend) ClaimNext(ctx context.Context, state metastorage.QueueState, workerID string, lease time.Duration) (metastorage.MessageMetadata, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	if state == metastorage.StateActive {
+		return metastorage.MessageMetadata{}, false, fmt.Errorf("%w: cannot claim from %s", metastorage.ErrInvalidState, state)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.MessageMetadata{}, false, metastorage.ErrBackendClosed
+	}
+	now := b.clock.Now().UTC()
+	var next metastorage.MessageMetadata
+	found := false
+	for id := range b.states[state] {
+		m := b.messages[id]
+		if !metastorage.IsDueWithin(m, now, b.skew) || (found && !metastorage.ClaimsBefore(m, next)) {
+			continue
+		}
+		next, found = m, true
+	}
+	if !found {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	if err := b.transition(next.ID, state, metastorage.StateActive, 0); err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	m := metastorage.SetLease(b.messages[next.ID], workerID, state, now.Add(lease))
+	m.Updated = now
+	b.messages[next.ID] = m
+	return clone(m), true, nil
+}
+
+// ExtendLease renews a lease under the lock, see metastorage.ClaimBackend
+func (b *Backend) ExtendLease(ctx context.Context, messageID, workerID string, lease time.Duration) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, err := b.leased(messageID, workerID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := b.clock.Now().UTC()
+	expires := now.Add(lease)
+	m = clone(m)
+	m.Headers[metastorage.HeaderLeaseExpires] = expires.Format(time.RFC3339Nano)
+	m.Updated = now
+	m.Version++
+	b.messages[messageID] = m
+	return expires, nil
+}
+
+// Release returns a claimed message to the state it was claimed from
+// under the lock, see metastorage.ClaimBackend
+func (b *Backend) Release(ctx context.Context, messageID, workerID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, err := b.leased(messageID, workerID)
+	if err != nil {
+		return err
+	}
+	from := metastorage.ReleaseState(m)
+	m = metastorage.ClearLease(m)
+	m.Updated = b.clock.Now().UTC()
+	b.messages[messageID] = m
+	return b.transition(messageID, metastorage.StateActive, from, 0)
+}
+
+// leased returns a message claimed by workerID; the caller holds the
+// write lock
+func (b *Backend) leased(messageID, workerID string) (metastorage.MessageMetadata, error) {
+	if b.closed {
+		return metastorage.MessageMetadata{}, metastorage.ErrBackendClosed
+	}
+	m, ok := b.messages[messageID]
+	if !ok {
+		return m, fmt.Errorf("%w: %s was removed", metastorage.ErrLeaseLost, messageID)
+	}
+	if err := metastorage.CheckLease(m, workerID); err != nil {
+		return m, err
+	}
+	return m, nil
+}
@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+func TestClaimNextWithinSkew(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := New(options.WithClock(clock.NewManual(now)), options.WithClockSkew(10*time.Second))
+	if got := metastorage.ClockSkew(b); got != 10*time.Second {
+		t.Fatalf("ClockSkew = %v, want 10s", got)
+	}
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateDeferred, NextRetry: now.Add(5 * time.Second)}
+	if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := b.ClaimNext(ctx, metastorage.StateDeferred, "w", time.Minute); err != nil || !ok {
+		t.Fatalf("claim within the skew window: %v, %v", ok, err)
+	}
+}
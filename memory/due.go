@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// GetDueMessages returns the due deferred messages, see
+// metastorage.DueBackend
+func (b *Backend) GetDueMessages(ctx context.Context, before time.Time, limit int) ([]metastorage.MessageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, metastorage.ErrBackendClosed
+	}
+	due := b.due(before)
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	for i, m := range due {
+		due[i] = clone(m)
+	}
+	return due, nil
+}
+
+// NewDueIterator iterates over the due deferred messages, see
+// metastorage.DueBackend. Like NewMessageIterator it works on the IDs due
+// when it was opened and skips messages that left the deferred state.
+func (b *Backend) NewDueIterator(ctx context.Context, before time.Time, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, metastorage.ErrBackendClosed
+	}
+	due := b.due(before)
+	ids := make([]string, len(due))
+	for i, m := range due {
+		ids[i] = m.ID
+	}
+	return &iterator{backend: b, state: metastorage.StateDeferred, ids: ids, batchSize: batchSize}, nil
+}
+
+// due returns the deferred messages due at before in order, uncloned; the
+// caller holds the lock
+func (b *Backend) due(before time.Time) []metastorage.MessageMetadata {
+	var due []metastorage.MessageMetadata
+	for id := range b.states[metastorage.StateDeferred] {
+		if m := b.messages[id]; !m.NextRetry.After(before) {
+			due = append(due, m)
+		}
+	}
+	metastorage.SortDue(due)
+	return due
+}
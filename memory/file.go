@@ -0,0 +1,30 @@
+//go:build !metaminimal && !tinygo && !js
+
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Open creates a backend restored from the snapshot file at path, if it
+// exists. Close saves the contents back to path.
+func Open(ctx context.Context, path string, opts ...options.Option) (*Backend, error) {
+	b := New(opts...)
+	if _, err := metastorage.LoadStateFile(ctx, b, path); err != nil {
+		return nil, fmt.Errorf("memory: load snapshot %s: %w", path, err)
+	}
+	b.snapshot = path
+	return b, nil
+}
+
+// saveSnapshot writes the snapshot file of a backend created with Open
+func (b *Backend) saveSnapshot() error {
+	if err := metastorage.SaveStateFile(context.Background(), b, b.snapshot); err != nil {
+		return fmt.Errorf("memory: save snapshot %s: %w", b.snapshot, err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build metaminimal || tinygo || js
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Open fails in minimal builds, which have no snapshot files; use New,
+// and SaveState and LoadState to persist elsewhere
+func Open(ctx context.Context, path string, opts ...options.Option) (*Backend, error) {
+	return nil, fmt.Errorf("memory: snapshot file %s: %w in minimal builds", path, errors.ErrUnsupported)
+}
+
+// saveSnapshot is never called, as Open sets no snapshot file
+func (b *Backend) saveSnapshot() error {
+	return nil
+}
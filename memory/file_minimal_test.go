@@ -0,0 +1,15 @@
+//go:build metaminimal || tinygo || js
+
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOpenMinimal(t *testing.T) {
+	if _, err := Open(context.Background(), "meta.snap"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Open: %v, want ErrUnsupported", err)
+	}
+}
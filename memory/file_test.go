@@ -0,0 +1,35 @@
+//go:build !metaminimal && !tinygo && !js
+
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+func TestOpenSnapshotFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "meta.snap")
+	b, err := Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateDeferred}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if m, err := b.GetMeta(ctx, "m1"); err != nil || m.State != metastorage.StateDeferred {
+		t.Errorf("restored m1: %v %v", m.State, err)
+	}
+}
@@ -0,0 +1,561 @@
+// Package memory implements metastorage.Backend in process memory. It is
+// the reference implementation of the backend contract and suits tests,
+// tools and small single-node deployments that can afford to lose the
+// spool metadata on restart.
+//
+// All operations are serialized by one lock; MoveToState checks and
+// changes the state under it, so concurrent moves of a message have
+// exactly one winner. Stored and returned metadata are deep copies, so
+// callers cannot modify the stored state through shared maps or slices.
+//
+// StoreMeta and MoveToState set StateEnteredAt and assign the next
+// Sequence of the state entered under the same lock, see
+// metastorage.StateTimeBackend and metastorage.SequenceBackend. Every
+// write increments the message's Version, and updates and moves carrying
+// a version are compare-and-swap, see metastorage.VersionBackend.
+//
+// Group throttle tokens are kept in a throttle.Table, shared by all users
+// of the backend.
+//
+// Backends opened with Open survive restarts: they are restored from a
+// snapshot file and save it again on Close. Builds without a file system,
+// with the build tag metaminimal, TinyGo and js/wasm, leave snapshot
+// files out, so the package compiles to WebAssembly; SaveState and
+// LoadState still work on any reader and writer there.
+//
+// Importing the package registers the "memory://" DSN scheme.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+	"schneider.vip/retryspool/storage/meta/throttle"
+)
+
+func init() {
+	registry.Register("memory", open)
+}
+
+// open handles "memory://" DSNs; every call creates an empty backend
+// unless a snapshot file is given, e.g. "memory://?snapshot=/var/lib/spool/meta.snap"
+func open(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	if path := dsn.Query().Get("snapshot"); path != "" {
+		return Open(ctx, path, opts...)
+	}
+	return New(opts...), nil
+}
+
+// Backend stores message metadata in maps indexed by ID and state
+type Backend struct {
+	batchSize int
+	skew      time.Duration
+	clock     clock.Clock
+	snapshot  string // file saved on Close, see Open
+	tokens    *throttle.Table
+
+	mu       sync.RWMutex
+	messages map[string]metastorage.MessageMetadata
+	states   map[metastorage.QueueState]map[string]struct{}
+	last     map[metastorage.QueueState]uint64 // highest sequence per state
+	closed   bool
+}
+
+// New creates an empty backend
+func New(opts ...options.Option) *Backend {
+	o := options.Apply(opts...)
+	return &Backend{
+		batchSize: o.BatchSize,
+		skew:      o.ClockSkew,
+		clock:     o.Clock,
+		tokens:    throttle.NewTable(o.Clock),
+		messages:  make(map[string]metastorage.MessageMetadata),
+		states:    make(map[metastorage.QueueState]map[string]struct{}),
+		last:      make(map[metastorage.QueueState]uint64),
+	}
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (b *Backend) ClockSkew() time.Duration {
+	return b.skew
+}
+
+// clone returns a deep copy of m
+func clone(m metastorage.MessageMetadata) metastorage.MessageMetadata {
+	if m.Headers != nil {
+		headers := make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			headers[k] = v
+		}
+		m.Headers = headers
+	}
+	m.DeliveryWindow.Weekdays = slices.Clone(m.DeliveryWindow.Weekdays)
+	return m
+}
+
+// index adds id to the index of state; the caller holds the write lock
+func (b *Backend) index(id string, state metastorage.QueueState) {
+	ids, ok := b.states[state]
+	if !ok {
+		ids = make(map[string]struct{})
+		b.states[state] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// next returns the next sequence of state; the caller holds the write lock
+func (b *Backend) next(state metastorage.QueueState) uint64 {
+	b.last[state]++
+	return b.last[state]
+}
+
+// StoreMeta stores message metadata under messageID, replacing an existing
+// message with the same ID. It assigns the next sequence of the message's
+// state and sets StateEnteredAt if it is unset.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	b.store(messageID, metadata, b.clock.Now())
+	return nil
+}
+
+// store stores metadata under messageID; the caller holds the write lock
+func (b *Backend) store(messageID string, metadata metastorage.MessageMetadata, now time.Time) {
+	var version uint64
+	if old, ok := b.messages[messageID]; ok {
+		delete(b.states[old.State], messageID)
+		version = old.Version
+	}
+	metadata = clone(metastorage.NormalizeTimes(metastorage.EnterState(metadata, now)))
+	metadata.ID = messageID
+	metadata.Version = version + 1
+	metadata.Sequence = b.next(metadata.State)
+	b.messages[messageID] = metadata
+	b.index(messageID, metadata.State)
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return metastorage.MessageMetadata{}, metastorage.ErrBackendClosed
+	}
+	m, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	return clone(m), nil
+}
+
+// GetMetaMulti reads the messages of ids from one consistent view, see
+// metastorage.GetMetaMulti
+func (b *Backend) GetMetaMulti(ctx context.Context, ids []string) (map[string]metastorage.MessageMetadata, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, nil, metastorage.ErrBackendClosed
+	}
+	found := make(map[string]metastorage.MessageMetadata, len(ids))
+	for _, id := range ids {
+		if m, ok := b.messages[id]; ok {
+			found[id] = clone(m)
+		}
+	}
+	return found, metastorage.MissingIDs(ids, found), nil
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	return b.update(messageID, metadata)
+}
+
+// update replaces the metadata of messageID; the caller holds the write
+// lock
+func (b *Backend) update(messageID string, metadata metastorage.MessageMetadata) error {
+	old, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	if metadata.State != old.State {
+		return fmt.Errorf("%w: %s is %s, update has %s", metastorage.ErrStateConflict, messageID, old.State, metadata.State)
+	}
+	if err := metastorage.CheckVersion(old, metadata.Version); err != nil {
+		return err
+	}
+	metadata = clone(metastorage.NormalizeTimes(metadata))
+	metadata.ID = messageID
+	metadata.Version = old.Version + 1
+	b.messages[messageID] = metadata
+	return nil
+}
+
+// UpdateMetaFields writes the fields set in patch under the lock, see
+// metastorage.PatchBackend
+func (b *Backend) UpdateMetaFields(ctx context.Context, messageID string, patch metastorage.MetadataPatch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	old, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	if err := patch.Check(old); err != nil {
+		return err
+	}
+	m := clone(metastorage.NormalizeTimes(patch.Apply(old)))
+	m.Version++
+	b.messages[messageID] = m
+	return nil
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	return b.delete(messageID)
+}
+
+// delete removes the message; the caller holds the write lock
+func (b *Backend) delete(messageID string) error {
+	m, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	delete(b.messages, messageID)
+	delete(b.states[m.State], messageID)
+	return nil
+}
+
+// StoreMetaBatch stores the messages under one lock, see
+// metastorage.BatchBackend
+func (b *Backend) StoreMetaBatch(ctx context.Context, messages []metastorage.MessageMetadata) []error {
+	errs := make([]error, len(messages))
+	if err := ctx.Err(); err != nil {
+		return metastorage.FailBatch(errs, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.FailBatch(errs, metastorage.ErrBackendClosed)
+	}
+	now := b.clock.Now()
+	for i, m := range messages {
+		if m.ID == "" {
+			errs[i] = metastorage.ErrMissingID
+			continue
+		}
+		b.store(m.ID, m, now)
+	}
+	return errs
+}
+
+// GetMetaBatch reads the messages of ids from one consistent view, see
+// metastorage.BatchBackend
+func (b *Backend) GetMetaBatch(ctx context.Context, ids []string) ([]metastorage.MessageMetadata, []error) {
+	ms := make([]metastorage.MessageMetadata, len(ids))
+	errs := make([]error, len(ids))
+	if err := ctx.Err(); err != nil {
+		return ms, metastorage.FailBatch(errs, err)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ms, metastorage.FailBatch(errs, metastorage.ErrBackendClosed)
+	}
+	for i, id := range ids {
+		if m, ok := b.messages[id]; ok {
+			ms[i] = clone(m)
+		} else {
+			errs[i] = metastorage.ErrMessageNotFound
+		}
+	}
+	return ms, errs
+}
+
+// DeleteMetaBatch deletes the messages of ids under one lock, see
+// metastorage.BatchBackend
+func (b *Backend) DeleteMetaBatch(ctx context.Context, ids []string) []error {
+	errs := make([]error, len(ids))
+	if err := ctx.Err(); err != nil {
+		return metastorage.FailBatch(errs, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.FailBatch(errs, metastorage.ErrBackendClosed)
+	}
+	for i, id := range ids {
+		errs[i] = b.delete(id)
+	}
+	return errs
+}
+
+// MoveToState moves a message from fromState to toState with CAS
+// semantics, recording when it entered toState and assigning the next
+// sequence of toState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	return b.move(ctx, messageID, fromState, toState, 0)
+}
+
+// MoveToStateIfVersion is MoveToState for a message that still has
+// version, see metastorage.VersionBackend
+func (b *Backend) MoveToStateIfVersion(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	return b.move(ctx, messageID, fromState, toState, version)
+}
+
+// move moves the message if it has version, or any version for 0
+func (b *Backend) move(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	return b.transition(messageID, fromState, toState, version)
+}
+
+// transition moves the message if it has version, or any version for 0;
+// the caller holds the write lock
+func (b *Backend) transition(messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	m, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	if m.State != fromState {
+		return metastorage.ErrStateConflict
+	}
+	if err := metastorage.CheckVersion(m, version); err != nil {
+		return err
+	}
+	if fromState == toState {
+		return nil
+	}
+	delete(b.states[fromState], messageID)
+	m.State = toState
+	m.Version++
+	m.StateEnteredAt = b.clock.Now().UTC()
+	m.Sequence = b.next(toState)
+	b.messages[messageID] = m
+	b.index(messageID, toState)
+	return nil
+}
+
+// LastSequence returns the highest sequence assigned in state, see
+// metastorage.SequenceBackend
+func (b *Backend) LastSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return 0, metastorage.ErrBackendClosed
+	}
+	return b.last[state], nil
+}
+
+// TracksStateTime reports that StateEnteredAt is maintained, see
+// metastorage.StateTimeBackend
+func (b *Backend) TracksStateTime() bool {
+	return true
+}
+
+// GetToken takes one of limit tokens of group for holder until ttl
+// elapses, see metastorage.ThrottleBackend
+func (b *Backend) GetToken(ctx context.Context, group, holder string, limit int, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if b.isClosed() {
+		return false, metastorage.ErrBackendClosed
+	}
+	return b.tokens.Get(group, holder, limit, ttl), nil
+}
+
+// ReturnToken gives back the token of group owned by holder, see
+// metastorage.ThrottleBackend
+func (b *Backend) ReturnToken(ctx context.Context, group, holder string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.isClosed() {
+		return metastorage.ErrBackendClosed
+	}
+	b.tokens.Return(group, holder)
+	return nil
+}
+
+// GetStateCount returns the number of messages in state, -1 after Close
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return -1
+	}
+	return int64(len(b.states[state]))
+}
+
+// ListMessages lists the IDs of messages in state, see
+// metastorage.ListPage for the supported options
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return metastorage.MessageListResult{}, metastorage.ErrBackendClosed
+	}
+	ms := make([]metastorage.MessageMetadata, 0, len(b.states[state]))
+	for id := range b.states[state] {
+		ms = append(ms, b.messages[id])
+	}
+	b.mu.RUnlock()
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the messages in state at the
+// time of the call, ordered by ID. Metadata is read in batches of
+// batchSize when the iterator reaches them: messages deleted or moved to
+// another state in the meantime are skipped, updates are visible.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, metastorage.ErrBackendClosed
+	}
+	ids := make([]string, 0, len(b.states[state]))
+	for id := range b.states[state] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return &iterator{backend: b, state: state, ids: ids, batchSize: batchSize}, nil
+}
+
+// Close releases the stored metadata, after saving it to the snapshot
+// file of backends created with Open. Later calls fail with
+// ErrBackendClosed.
+func (b *Backend) Close() error {
+	var err error
+	if b.snapshot != "" && !b.isClosed() {
+		err = b.saveSnapshot()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.messages = nil
+	b.states = nil
+	return err
+}
+
+func (b *Backend) isClosed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.closed
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	ids       []string // not yet fetched
+	batch     []metastorage.MessageMetadata
+	batchSize int
+	closed    bool
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	for len(it.batch) == 0 {
+		if it.closed || len(it.ids) == 0 {
+			return metastorage.MessageMetadata{}, false, nil
+		}
+		if err := it.fetch(); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+// fetch reads the next batch of IDs that are still in the state
+func (it *iterator) fetch() error {
+	n := min(it.batchSize, len(it.ids))
+	ids := it.ids[:n]
+	it.ids = it.ids[n:]
+
+	b := it.backend
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	for _, id := range ids {
+		if m, ok := b.messages[id]; ok && m.State == it.state {
+			it.batch = append(it.batch, clone(m))
+		}
+	}
+	return nil
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.closed = true
+	it.batch = nil
+	it.ids = nil
+	return nil
+}
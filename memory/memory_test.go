@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+func TestStoreMetaBatchMissingID(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	errs := b.StoreMetaBatch(ctx, []metastorage.MessageMetadata{
+		{ID: "m1", State: metastorage.StateIncoming},
+		{State: metastorage.StateIncoming},
+	})
+	if errs[0] != nil || !errors.Is(errs[1], metastorage.ErrMissingID) {
+		t.Fatalf("errs = %v, want only the second to fail with ErrMissingID", errs)
+	}
+	if n := b.GetStateCount(metastorage.StateIncoming); n != 1 {
+		t.Errorf("incoming = %d, want 1", n)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LastSequence(ctx, metastorage.StateIncoming); !errors.Is(err, metastorage.ErrBackendClosed) {
+		t.Errorf("LastSequence: %v", err)
+	}
+	if _, err := b.GetToken(ctx, "g", "h", 1, time.Minute); !errors.Is(err, metastorage.ErrBackendClosed) {
+		t.Errorf("GetToken: %v", err)
+	}
+	if err := b.ReturnToken(ctx, "g", "h"); !errors.Is(err, metastorage.ErrBackendClosed) {
+		t.Errorf("ReturnToken: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// AddNote appends a note to the notes header under the lock, see
+// metastorage.NoteBackend
+func (b *Backend) AddNote(ctx context.Context, messageID, author, text string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	m, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	notes, err := metastorage.NotesOf(m)
+	if err != nil {
+		return err
+	}
+	notes = append(notes, metastorage.Note{Author: author, Text: text, Created: b.clock.Now().UTC()})
+	encoded, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+	m = metastorage.MetadataPatch{Headers: map[string]string{metastorage.HeaderNotes: string(encoded)}}.Apply(m)
+	m.Version++
+	b.messages[messageID] = m
+	return nil
+}
+
+// Notes returns the notes of a message, see metastorage.NoteBackend
+func (b *Backend) Notes(ctx context.Context, messageID string) ([]metastorage.Note, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, metastorage.ErrBackendClosed
+	}
+	m, ok := b.messages[messageID]
+	if !ok {
+		return nil, metastorage.ErrMessageNotFound
+	}
+	return metastorage.NotesOf(m)
+}
@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+func TestNotesNative(t *testing.T) {
+	ctx := context.Background()
+	b := New()
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"first", "second"} {
+		if err := metastorage.AddNote(ctx, b, "m1", "ops", text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes, err := metastorage.Notes(ctx, b, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Text != "first" || notes[1].Text != "second" {
+		t.Fatalf("notes = %+v, want first then second", notes)
+	}
+	m, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 3 {
+		t.Errorf("version = %d, want 3 after two notes", m.Version)
+	}
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Pin sets the pin header under the lock, see metastorage.PinBackend
+func (b *Backend) Pin(ctx context.Context, messageID, reason string) error {
+	return b.UpdateMetaFields(ctx, messageID, metastorage.MetadataPatch{Headers: map[string]string{metastorage.HeaderPinned: reason}})
+}
+
+// Unpin removes the pin header under the lock, see metastorage.PinBackend
+func (b *Backend) Unpin(ctx context.Context, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	m, ok := b.messages[messageID]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	if _, pinned := metastorage.IsPinned(m); !pinned {
+		return nil
+	}
+	m = metastorage.MetadataPatch{RemoveHeaders: []string{metastorage.HeaderPinned}}.Apply(m)
+	m.Version++
+	b.messages[messageID] = m
+	return nil
+}
+
+// DeleteUnpinned deletes a message unless it is pinned, checking the pin
+// under the lock, see metastorage.PinBackend
+func (b *Backend) DeleteUnpinned(ctx context.Context, messageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	if _, pinned := metastorage.IsPinned(b.messages[messageID]); pinned {
+		return fmt.Errorf("%w: %s", metastorage.ErrPinned, messageID)
+	}
+	return b.delete(messageID)
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// snapshotVersion is the format version written by SaveState
+const snapshotVersion = 1
+
+// snapshotHeader is the first line of a snapshot; every further line holds
+// one message
+type snapshotHeader struct {
+	Version  int `json:"version"`
+	Messages int `json:"messages"`
+}
+
+// SaveState writes all messages as JSON lines to w. The backend is locked
+// for reading while the snapshot is written.
+func (b *Backend) SaveState(ctx context.Context, w io.Writer) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Messages: len(b.messages)}); err != nil {
+		return err
+	}
+	for _, m := range b.messages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadState replaces all messages with the snapshot read from r. The
+// snapshot is decoded completely before it is swapped in, so a truncated
+// or corrupt snapshot leaves the backend unchanged.
+func (b *Backend) LoadState(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("memory: read snapshot header: %w", err)
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("memory: unsupported snapshot version %d", header.Version)
+	}
+	messages := make(map[string]metastorage.MessageMetadata, header.Messages)
+	states := make(map[metastorage.QueueState]map[string]struct{})
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var m metastorage.MessageMetadata
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("memory: read snapshot: %w", err)
+		}
+		messages[m.ID] = metastorage.NormalizeTimes(m)
+		if states[m.State] == nil {
+			states[m.State] = make(map[string]struct{})
+		}
+		states[m.State][m.ID] = struct{}{}
+	}
+	if len(messages) != header.Messages {
+		return fmt.Errorf("memory: snapshot truncated: %d of %d messages", len(messages), header.Messages)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	b.messages = messages
+	b.states = states
+	return nil
+}
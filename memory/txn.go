@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"maps"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Begin starts a transaction committed under the lock, see
+// metastorage.TxnBackend
+func (b *Backend) Begin(ctx context.Context) (metastorage.Txn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metastorage.NewTxn(b.commit), nil
+}
+
+// saved is a message as it was before a transaction changed it
+type saved struct {
+	m  metastorage.MessageMetadata
+	ok bool // whether the message existed
+}
+
+// commit runs ops under the lock. After a failing operation the messages
+// and sequences touched are restored.
+func (b *Backend) commit(ctx context.Context, ops []metastorage.TxnOp) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return metastorage.ErrBackendClosed
+	}
+	last := maps.Clone(b.last)
+	before := make(map[string]saved)
+	now := b.clock.Now()
+	for i, op := range ops {
+		if _, ok := before[op.ID]; !ok {
+			m, ok := b.messages[op.ID]
+			before[op.ID] = saved{m, ok}
+		}
+		var err error
+		switch op.Kind {
+		case metastorage.TxnStore:
+			b.store(op.ID, op.Metadata, now)
+		case metastorage.TxnUpdate:
+			err = b.update(op.ID, op.Metadata)
+		case metastorage.TxnMove:
+			err = b.transition(op.ID, op.From, op.To, 0)
+		case metastorage.TxnDelete:
+			err = b.delete(op.ID)
+		}
+		if err != nil {
+			b.restore(before, last)
+			return op.Err(i, err)
+		}
+	}
+	return nil
+}
+
+// restore undoes a failed transaction; the caller holds the write lock
+func (b *Backend) restore(before map[string]saved, last map[metastorage.QueueState]uint64) {
+	for id, s := range before {
+		if m, ok := b.messages[id]; ok {
+			delete(b.states[m.State], id)
+			delete(b.messages, id)
+		}
+		if s.ok {
+			b.messages[id] = s.m
+			b.index(id, s.m.State)
+		}
+	}
+	b.last = last
+}
@@ -0,0 +1,109 @@
+// Package metatest contains the conformance suite for metastorage.Backend
+// implementations. Backend packages call the Run* functions from their own
+// tests:
+//
+//	func TestConformance(t *testing.T) {
+//		metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+//			return mybackend.New(t.TempDir())
+//		})
+//	}
+package metatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Factory returns a fresh, empty backend for one subtest. The suite closes
+// the backend when the subtest ends.
+type Factory func(t *testing.T) metastorage.Backend
+
+var namespaces atomic.Uint64
+
+// Namespace returns a namespace not used by any earlier run, for factories
+// of backends on a shared server. It only contains lowercase letters and
+// digits, so every backend accepts it.
+func Namespace() string {
+	return fmt.Sprintf("mt%x%d", time.Now().UnixNano(), namespaces.Add(1))
+}
+
+func newBackend(t *testing.T, factory Factory) metastorage.Backend {
+	t.Helper()
+	b := factory(t)
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+func newMessage(id string, state metastorage.QueueState) metastorage.MessageMetadata {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return metastorage.MessageMetadata{
+		ID:          id,
+		State:       state,
+		MaxAttempts: 5,
+		Created:     now,
+		Updated:     now,
+		Headers:     map[string]string{"to": "rcpt@example.com"},
+	}
+}
+
+func store(t *testing.T, b metastorage.Backend, meta metastorage.MessageMetadata) {
+	t.Helper()
+	if err := b.StoreMeta(context.Background(), meta.ID, meta); err != nil {
+		t.Fatalf("StoreMeta(%s): %v", meta.ID, err)
+	}
+}
+
+// statesContaining returns every state whose listing contains id
+func statesContaining(t *testing.T, b metastorage.Backend, id string) []metastorage.QueueState {
+	t.Helper()
+	ctx := context.Background()
+	var found []metastorage.QueueState
+	for _, state := range metastorage.States() {
+		iter, err := b.NewMessageIterator(ctx, state, 100)
+		if err != nil {
+			t.Fatalf("NewMessageIterator(%s): %v", state, err)
+		}
+		for {
+			meta, more, err := iter.Next(ctx)
+			if err != nil {
+				iter.Close()
+				t.Fatalf("iterate %s: %v", state, err)
+			}
+			if !more {
+				break
+			}
+			if meta.ID == id {
+				found = append(found, state)
+			}
+		}
+		iter.Close()
+	}
+	return found
+}
+
+// assertConsistent checks that id is listed in exactly the state GetMeta
+// reports, or nowhere if it was deleted
+func assertConsistent(t *testing.T, b metastorage.Backend, id string) (metastorage.MessageMetadata, bool) {
+	t.Helper()
+	meta, err := b.GetMeta(context.Background(), id)
+	listed := statesContaining(t, b, id)
+	switch {
+	case errors.Is(err, metastorage.ErrMessageNotFound):
+		if len(listed) != 0 {
+			t.Errorf("%s: deleted message still listed in %v", id, listed)
+		}
+		return meta, false
+	case err != nil:
+		t.Fatalf("GetMeta(%s): %v", id, err)
+	}
+	if len(listed) != 1 || listed[0] != meta.State {
+		t.Errorf("%s: GetMeta reports state %s but message is listed in %v", id, meta.State, listed)
+	}
+	return meta, true
+}
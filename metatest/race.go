@@ -0,0 +1,226 @@
+package metatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+const (
+	raceRounds  = 20
+	raceWorkers = 16
+)
+
+// RunMoveRaceSuite verifies the atomicity contract of MoveToState under
+// concurrency: racing movers, move vs. delete and move vs. update.
+// These are the most safety-critical guarantees of a backend; a failure
+// means two workers can process the same message.
+func RunMoveRaceSuite(t *testing.T, factory Factory) {
+	t.Run("ConcurrentMoversSameTarget", func(t *testing.T) { testConcurrentMovers(t, factory, false) })
+	t.Run("ConcurrentMoversDifferentTargets", func(t *testing.T) { testConcurrentMovers(t, factory, true) })
+	t.Run("MoveVsDelete", func(t *testing.T) { testMoveVsDelete(t, factory) })
+	t.Run("MoveVsUpdate", func(t *testing.T) { testMoveVsUpdate(t, factory) })
+	t.Run("PingPongUnderContention", func(t *testing.T) { testPingPong(t, factory) })
+}
+
+// testConcurrentMovers races raceWorkers movers on one message; exactly one must win
+func testConcurrentMovers(t *testing.T, factory Factory, differentTargets bool) {
+	b := newBackend(t, factory)
+	ctx := context.Background()
+	targets := []metastorage.QueueState{metastorage.StateActive, metastorage.StateHold, metastorage.StateBounce}
+
+	for round := 0; round < raceRounds; round++ {
+		id := fmt.Sprintf("race-movers-%d", round)
+		store(t, b, newMessage(id, metastorage.StateIncoming))
+
+		var (
+			wg     sync.WaitGroup
+			start  = make(chan struct{})
+			mu     sync.Mutex
+			winner = -1
+			wins   int
+		)
+		for w := 0; w < raceWorkers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				to := metastorage.StateActive
+				if differentTargets {
+					to = targets[w%len(targets)]
+				}
+				<-start
+				err := b.MoveToState(ctx, id, metastorage.StateIncoming, to)
+				switch {
+				case err == nil:
+					mu.Lock()
+					wins++
+					winner = w
+					mu.Unlock()
+				case !errors.Is(err, metastorage.ErrStateConflict):
+					t.Errorf("%s: losing mover got %v, want ErrStateConflict", id, err)
+				}
+			}(w)
+		}
+		close(start)
+		wg.Wait()
+
+		if wins != 1 {
+			t.Fatalf("%s: %d movers succeeded, want exactly 1", id, wins)
+		}
+		meta, ok := assertConsistent(t, b, id)
+		if !ok {
+			t.Fatalf("%s: message vanished", id)
+		}
+		want := metastorage.StateActive
+		if differentTargets {
+			want = targets[winner%len(targets)]
+		}
+		if meta.State != want {
+			t.Errorf("%s: final state %s, want winner's target %s", id, meta.State, want)
+		}
+	}
+}
+
+// testMoveVsDelete races a move against a delete; the message must never be resurrected
+func testMoveVsDelete(t *testing.T, factory Factory) {
+	b := newBackend(t, factory)
+	ctx := context.Background()
+
+	for round := 0; round < raceRounds; round++ {
+		id := fmt.Sprintf("race-delete-%d", round)
+		store(t, b, newMessage(id, metastorage.StateDeferred))
+
+		var wg sync.WaitGroup
+		var moveErr, deleteErr error
+		start := make(chan struct{})
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			moveErr = b.MoveToState(ctx, id, metastorage.StateDeferred, metastorage.StateActive)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			deleteErr = b.DeleteMeta(ctx, id)
+		}()
+		close(start)
+		wg.Wait()
+
+		if deleteErr != nil {
+			t.Fatalf("%s: DeleteMeta: %v", id, deleteErr)
+		}
+		if moveErr != nil && !errors.Is(moveErr, metastorage.ErrMessageNotFound) && !errors.Is(moveErr, metastorage.ErrStateConflict) {
+			t.Errorf("%s: MoveToState after concurrent delete returned %v, want nil, ErrMessageNotFound or ErrStateConflict", id, moveErr)
+		}
+		if _, exists := assertConsistent(t, b, id); exists {
+			t.Errorf("%s: message exists after delete (resurrected by concurrent move)", id)
+		}
+	}
+}
+
+// testMoveVsUpdate races a move against an attempt counter update; the
+// message must end up listed in exactly one state matching GetMeta
+func testMoveVsUpdate(t *testing.T, factory Factory) {
+	b := newBackend(t, factory)
+	ctx := context.Background()
+
+	for round := 0; round < raceRounds; round++ {
+		id := fmt.Sprintf("race-update-%d", round)
+		meta := newMessage(id, metastorage.StateDeferred)
+		store(t, b, meta)
+
+		var wg sync.WaitGroup
+		var moveErr, updateErr error
+		start := make(chan struct{})
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			moveErr = b.MoveToState(ctx, id, metastorage.StateDeferred, metastorage.StateActive)
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			updated := meta
+			updated.Attempts++
+			updated.LastError = "temporary failure"
+			updateErr = b.UpdateMeta(ctx, id, updated)
+		}()
+		close(start)
+		wg.Wait()
+
+		if moveErr != nil && !errors.Is(moveErr, metastorage.ErrStateConflict) {
+			t.Errorf("%s: MoveToState: %v", id, moveErr)
+		}
+		if updateErr != nil && !errors.Is(updateErr, metastorage.ErrStateConflict) {
+			t.Errorf("%s: UpdateMeta: %v", id, updateErr)
+		}
+		if _, exists := assertConsistent(t, b, id); !exists {
+			t.Errorf("%s: message vanished", id)
+		}
+	}
+}
+
+// testPingPong lets workers repeatedly move one message between two states
+// using the state they last observed. The number of successful moves must
+// equal the number of observed transitions.
+func testPingPong(t *testing.T, factory Factory) {
+	b := newBackend(t, factory)
+	ctx := context.Background()
+	id := "race-pingpong"
+	store(t, b, newMessage(id, metastorage.StateDeferred))
+
+	other := func(s metastorage.QueueState) metastorage.QueueState {
+		if s == metastorage.StateDeferred {
+			return metastorage.StateActive
+		}
+		return metastorage.StateDeferred
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for w := 0; w < raceWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < raceRounds; i++ {
+				meta, err := b.GetMeta(ctx, id)
+				if err != nil {
+					t.Errorf("GetMeta: %v", err)
+					return
+				}
+				err = b.MoveToState(ctx, id, meta.State, other(meta.State))
+				switch {
+				case err == nil:
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				case !errors.Is(err, metastorage.ErrStateConflict):
+					t.Errorf("MoveToState: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	meta, exists := assertConsistent(t, b, id)
+	if !exists {
+		t.Fatalf("%s: message vanished", id)
+	}
+	want := metastorage.StateDeferred
+	if succeeded%2 == 1 {
+		want = metastorage.StateActive
+	}
+	if meta.State != want {
+		t.Errorf("%s: %d successful moves should leave the message in %s, got %s (lost or duplicated transition)", id, succeeded, want, meta.State)
+	}
+}
@@ -0,0 +1,72 @@
+package metatest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// RunTxnSuite verifies the contract of backends implementing
+// metastorage.TxnBackend: the operations of a committed transaction all
+// take effect, and none of a transaction with a failing operation does.
+func RunTxnSuite(t *testing.T, factory Factory) {
+	b := newBackend(t, factory)
+	ctx := context.Background()
+	if _, ok := b.(metastorage.TxnBackend); !ok {
+		t.Fatalf("%T does not implement TxnBackend", b)
+	}
+	for _, id := range []string{"m1", "m2", "m3"} {
+		store(t, b, newMessage(id, metastorage.StateDeferred))
+	}
+
+	err := metastorage.RunTxn(ctx, b, func(txn metastorage.Txn) error {
+		for _, id := range []string{"m1", "m2"} {
+			if err := txn.MoveToState(ctx, id, metastorage.StateDeferred, metastorage.StateBounce); err != nil {
+				return err
+			}
+		}
+		if err := txn.StoreMeta(ctx, "m4", newMessage("m4", metastorage.StateIncoming)); err != nil {
+			return err
+		}
+		return txn.DeleteMeta(ctx, "m3")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]metastorage.QueueState{"m1": metastorage.StateBounce, "m2": metastorage.StateBounce, "m4": metastorage.StateIncoming} {
+		if m, err := b.GetMeta(ctx, id); err != nil || m.State != want {
+			t.Errorf("%s after commit: %s, %v; want %s", id, m.State, err, want)
+		}
+	}
+	if _, err := b.GetMeta(ctx, "m3"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Errorf("deleted m3: %v, want not found", err)
+	}
+
+	// the move of m3 fails, so the store and the move of m1 are undone
+	txn, err := metastorage.Begin(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []error{
+		txn.StoreMeta(ctx, "m5", newMessage("m5", metastorage.StateIncoming)),
+		txn.MoveToState(ctx, "m1", metastorage.StateBounce, metastorage.StateIncoming),
+		txn.MoveToState(ctx, "m3", metastorage.StateDeferred, metastorage.StateIncoming),
+	}
+	if err := errors.Join(steps...); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(ctx); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("commit: %v, want not found", err)
+	}
+	if m, err := b.GetMeta(ctx, "m1"); err != nil || m.State != metastorage.StateBounce {
+		t.Errorf("m1 after failed commit: %s, %v; want bounce", m.State, err)
+	}
+	if _, err := b.GetMeta(ctx, "m5"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Errorf("m5 after failed commit: %v, want not found", err)
+	}
+	if err := txn.DeleteMeta(ctx, "m1"); !errors.Is(err, metastorage.ErrTxnDone) {
+		t.Errorf("after commit: %v, want ErrTxnDone", err)
+	}
+}
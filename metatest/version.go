@@ -0,0 +1,69 @@
+package metatest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// RunVersionSuite verifies the compare-and-swap contract of backends
+// implementing metastorage.VersionBackend: every write, bulk moves
+// included, increments the version, and stale updates and moves fail with ErrVersionConflict.
+func RunVersionSuite(t *testing.T, factory Factory) {
+	b := newBackend(t, factory)
+	ctx := context.Background()
+	if _, ok := b.(metastorage.VersionBackend); !ok {
+		t.Fatalf("%T does not implement VersionBackend", b)
+	}
+	store(t, b, newMessage("m1", metastorage.StateActive))
+
+	first, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 1 {
+		t.Fatalf("stored version %d, want 1", first.Version)
+	}
+	second := first
+	first.Attempts++
+	if err := b.UpdateMeta(ctx, "m1", first); err != nil {
+		t.Fatal(err)
+	}
+	second.Attempts += 2
+	if err := b.UpdateMeta(ctx, "m1", second); !errors.Is(err, metastorage.ErrVersionConflict) {
+		t.Fatalf("stale update: %v, want version conflict", err)
+	}
+
+	err = metastorage.MoveToStateIfVersion(ctx, b, "m1", metastorage.StateActive, metastorage.StateDeferred, 1)
+	if !errors.Is(err, metastorage.ErrVersionConflict) {
+		t.Fatalf("stale move: %v, want version conflict", err)
+	}
+	if err := metastorage.MoveToStateIfVersion(ctx, b, "m1", metastorage.StateActive, metastorage.StateDeferred, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 3 || got.Attempts != 1 {
+		t.Fatalf("after move: version %d, attempts %d, want 3 and 1", got.Version, got.Attempts)
+	}
+
+	got.Version = 0
+	if err := b.UpdateMeta(ctx, "m1", got); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+	store(t, b, got)
+	if got, err = b.GetMeta(ctx, "m1"); err != nil || got.Version != 5 {
+		t.Fatalf("after update and store: version %d, %v; want 5", got.Version, err)
+	}
+
+	if n, err := metastorage.MoveStateBulk(ctx, b, got.State, metastorage.StateHold, nil); err != nil || n != 1 {
+		t.Fatalf("bulk move: %d, %v; want 1", n, err)
+	}
+	if got, err = b.GetMeta(ctx, "m1"); err != nil || got.Version != 6 {
+		t.Fatalf("after bulk move: version %d, %v; want 6", got.Version, err)
+	}
+}
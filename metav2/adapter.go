@@ -0,0 +1,264 @@
+package metav2
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Bookkeeping headers written by FromV1 adapters
+const (
+	HeaderVersion  = metastorage.ReservedHeaderPrefix + "version"   // decimal record version
+	HeaderStoredAt = metastorage.ReservedHeaderPrefix + "stored-at" // RFC 3339 UTC
+)
+
+func init() {
+	metastorage.RegisterReservedHeader(HeaderVersion, HeaderStoredAt)
+}
+
+// lockStripes is the number of mutexes serializing writes by message ID
+const lockStripes = 64
+
+// FromV1 adapts a version 1 backend to Backend. Versions and write times
+// are kept in the HeaderVersion and HeaderStoredAt headers, which are
+// hidden from returned records. Messages written to b directly have
+// version 0 until they are written through the adapter.
+//
+// Conditional updates read and write the message under a lock per ID,
+// so they are only safe if all writers of b go through the same adapter.
+// Backends wrapped with ToV1 are unwrapped instead of adapted.
+func FromV1(b metastorage.Backend) Backend {
+	if v, ok := b.(*v1Backend); ok {
+		return v.backend
+	}
+	return &v2Backend{backend: b}
+}
+
+// ToV1 adapts b to metastorage.Backend, for middleware and tools written
+// for version 1. Backends created with FromV1 are unwrapped instead of
+// adapted.
+func ToV1(b Backend) metastorage.Backend {
+	if v, ok := b.(*v2Backend); ok {
+		return v.backend
+	}
+	return &v1Backend{backend: b}
+}
+
+type v2Backend struct {
+	backend metastorage.Backend
+	locks   [lockStripes]sync.Mutex
+}
+
+// lock serializes writes of id and returns the unlock function
+func (b *v2Backend) lock(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &b.locks[h.Sum32()%lockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// record splits the bookkeeping headers off m
+func record(m metastorage.MessageMetadata) Record {
+	r := Record{MessageMetadata: m}
+	v, hasVersion := m.Headers[HeaderVersion]
+	at, hasStoredAt := m.Headers[HeaderStoredAt]
+	if !hasVersion && !hasStoredAt {
+		return r
+	}
+	r.Version, _ = strconv.ParseUint(v, 10, 64)
+	r.StoredAt, _ = time.Parse(time.RFC3339Nano, at)
+	headers := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		if k != HeaderVersion && k != HeaderStoredAt {
+			headers[k] = v
+		}
+	}
+	r.Headers = headers
+	return r
+}
+
+// stamp returns m with the bookkeeping headers of version set
+func stamp(m metastorage.MessageMetadata, version uint64) (metastorage.MessageMetadata, WriteResult) {
+	res := WriteResult{Version: version, StoredAt: time.Now().UTC()}
+	headers := make(map[string]string, len(m.Headers)+2)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[HeaderVersion] = strconv.FormatUint(version, 10)
+	headers[HeaderStoredAt] = res.StoredAt.Format(time.RFC3339Nano)
+	m.Headers = headers
+	return m, res
+}
+
+func (b *v2Backend) Store(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) (WriteResult, error) {
+	defer b.lock(messageID)()
+	var version uint64
+	if old, err := b.backend.GetMeta(ctx, messageID); err == nil {
+		version = record(old).Version
+	}
+	metadata, res := stamp(metadata, version+1)
+	if err := b.backend.StoreMeta(ctx, messageID, metadata); err != nil {
+		return WriteResult{}, err
+	}
+	return res, nil
+}
+
+func (b *v2Backend) Get(ctx context.Context, messageID string) (Record, error) {
+	m, err := b.backend.GetMeta(ctx, messageID)
+	if err != nil {
+		return Record{}, err
+	}
+	return record(m), nil
+}
+
+func (b *v2Backend) Update(ctx context.Context, messageID string, metadata metastorage.MessageMetadata, opts UpdateOptions) (WriteResult, error) {
+	defer b.lock(messageID)()
+	old, err := b.backend.GetMeta(ctx, messageID)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	version := record(old).Version
+	if opts.IfVersion != 0 && opts.IfVersion != version {
+		return WriteResult{}, fmt.Errorf("%w: %s has version %d, expected %d", ErrVersionConflict, messageID, version, opts.IfVersion)
+	}
+	metadata, res := stamp(metadata, version+1)
+	if err := b.backend.UpdateMeta(ctx, messageID, metadata); err != nil {
+		return WriteResult{}, err
+	}
+	return res, nil
+}
+
+func (b *v2Backend) Delete(ctx context.Context, messageID string) error {
+	return b.backend.DeleteMeta(ctx, messageID)
+}
+
+func (b *v2Backend) List(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (ListResult, error) {
+	res, err := b.backend.ListMessages(ctx, state, opts)
+	if err != nil {
+		return ListResult{}, err
+	}
+	return ListResult{
+		MessageIDs: res.MessageIDs,
+		Total:      res.Total,
+		HasMore:    res.HasMore,
+		NextOffset: opts.Offset + len(res.MessageIDs),
+	}, nil
+}
+
+func (b *v2Backend) Iterate(ctx context.Context, state metastorage.QueueState, batchSize int) (Iterator, error) {
+	iter, err := b.backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return &v2Iterator{iter: iter}, nil
+}
+
+// Move moves the message and reports the version it has afterwards;
+// moves do not change the version
+func (b *v2Backend) Move(ctx context.Context, messageID string, from, to metastorage.QueueState) (MoveResult, error) {
+	if err := b.backend.MoveToState(ctx, messageID, from, to); err != nil {
+		return MoveResult{}, err
+	}
+	res := MoveResult{From: from, To: to}
+	if m, err := b.backend.GetMeta(ctx, messageID); err == nil {
+		r := record(m)
+		res.Version, res.StoredAt = r.Version, r.StoredAt
+	}
+	return res, nil
+}
+
+// Close closes the version 1 backend; if ctx is done first, Close returns
+// ctx.Err() while the backend finishes closing in the background
+func (b *v2Backend) Close(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() { done <- b.backend.Close() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type v2Iterator struct {
+	iter metastorage.MessageIterator
+}
+
+func (it *v2Iterator) Next(ctx context.Context) (Record, bool, error) {
+	m, more, err := it.iter.Next(ctx)
+	if err != nil || !more {
+		return Record{}, more, err
+	}
+	return record(m), true, nil
+}
+
+func (it *v2Iterator) Close(context.Context) error {
+	return it.iter.Close()
+}
+
+type v1Backend struct {
+	backend Backend
+}
+
+func (b *v1Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	_, err := b.backend.Store(ctx, messageID, metadata)
+	return err
+}
+
+func (b *v1Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	r, err := b.backend.Get(ctx, messageID)
+	return r.MessageMetadata, err
+}
+
+func (b *v1Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	_, err := b.backend.Update(ctx, messageID, metadata, UpdateOptions{})
+	return err
+}
+
+func (b *v1Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	return b.backend.Delete(ctx, messageID)
+}
+
+func (b *v1Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	res, err := b.backend.List(ctx, state, opts)
+	if err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	return metastorage.MessageListResult{MessageIDs: res.MessageIDs, Total: res.Total, HasMore: res.HasMore}, nil
+}
+
+func (b *v1Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	iter, err := b.backend.Iterate(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return &v1Iterator{iter: iter}, nil
+}
+
+func (b *v1Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	_, err := b.backend.Move(ctx, messageID, fromState, toState)
+	return err
+}
+
+func (b *v1Backend) Close() error {
+	return b.backend.Close(context.Background())
+}
+
+type v1Iterator struct {
+	iter Iterator
+}
+
+func (it *v1Iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	r, more, err := it.iter.Next(ctx)
+	return r.MessageMetadata, more, err
+}
+
+func (it *v1Iterator) Close() error {
+	return it.iter.Close(context.Background())
+}
@@ -0,0 +1,102 @@
+// Package metav2 defines version 2 of the metadata backend interface. It
+// lives next to metastorage.Backend so contract gaps of the first version
+// can be fixed without breaking every existing backend at once:
+//
+//   - Close takes a context, so shutdown can be bounded
+//   - writes return the version and time of the stored record
+//   - reads return records carrying their version, enabling conditional
+//     updates instead of lost updates
+//   - moves report the resulting record version
+//   - iterators are closed with a context as well
+//
+// Existing backends are used through FromV1; v2 backends can be plugged
+// into middleware and tools written for version 1 through ToV1.
+package metav2
+
+import (
+	"context"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// ErrVersionConflict is returned by conditional updates when the stored
+// record has a different version than expected. It is the error of
+// version 1 backends tracking versions, see metastorage.VersionBackend.
+var ErrVersionConflict = metastorage.ErrVersionConflict
+
+// Record is stored message metadata with its storage bookkeeping
+type Record struct {
+	metastorage.MessageMetadata
+	Version  uint64    // Incremented by every Store and Update, starting at 1; 0 = unknown
+	StoredAt time.Time // When this version was written
+}
+
+// WriteResult describes a written record
+type WriteResult struct {
+	Version  uint64
+	StoredAt time.Time
+}
+
+// MoveResult describes a completed move
+type MoveResult struct {
+	From, To metastorage.QueueState
+	WriteResult
+}
+
+// UpdateOptions controls Update
+type UpdateOptions struct {
+	// IfVersion makes the update conditional: it fails with
+	// ErrVersionConflict unless the stored version matches. 0 updates
+	// unconditionally.
+	IfVersion uint64
+}
+
+// ListResult contains the result of listing messages
+type ListResult struct {
+	MessageIDs []string
+	Total      int  // Total number of messages matching the criteria
+	HasMore    bool // Whether NextOffset returns more messages
+	NextOffset int  // Offset of the next page
+}
+
+// Iterator iterates over the records of a state
+type Iterator interface {
+	// Next returns the next record; false once the iteration is done
+	Next(ctx context.Context) (Record, bool, error)
+
+	// Close releases the iterator
+	Close(ctx context.Context) error
+}
+
+// Backend is version 2 of the metadata backend interface. Semantics not
+// described here are those of the metastorage.Backend method of the same
+// purpose.
+type Backend interface {
+	// Store stores message metadata, replacing an existing message
+	Store(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) (WriteResult, error)
+
+	// Get retrieves a record
+	Get(ctx context.Context, messageID string) (Record, error)
+
+	// Update replaces the metadata of an existing message, optionally only
+	// if it still has the expected version. The state must match the
+	// stored state, see metastorage.ErrStateConflict.
+	Update(ctx context.Context, messageID string, metadata metastorage.MessageMetadata, opts UpdateOptions) (WriteResult, error)
+
+	// Delete removes a message
+	Delete(ctx context.Context, messageID string) error
+
+	// List lists messages with pagination and filtering
+	List(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (ListResult, error)
+
+	// Iterate creates an iterator over the records in state
+	Iterate(ctx context.Context, state metastorage.QueueState, batchSize int) (Iterator, error)
+
+	// Move moves a message between states with the CAS semantics of
+	// metastorage.Backend.MoveToState
+	Move(ctx context.Context, messageID string, from, to metastorage.QueueState) (MoveResult, error)
+
+	// Close closes the backend, giving up when ctx is done
+	Close(ctx context.Context) error
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import "maps"
+
+// Label names shared by all metrics of this module
+const (
+	LabelNamespace = "namespace" // options.Namespace of the emitting backend or wrapper
+	LabelState     = "state"     // queue state name
+)
+
+// WithLabels returns a Recorder adding labels to every observation of r,
+// e.g. the namespace of a tenant. Labels passed with an observation take
+// precedence.
+func WithLabels(r Recorder, labels Labels) Recorder {
+	if len(labels) == 0 {
+		return r
+	}
+	if l, ok := r.(*labeled); ok {
+		merged := maps.Clone(l.labels)
+		maps.Copy(merged, labels)
+		return &labeled{next: l.next, labels: merged}
+	}
+	return &labeled{next: r, labels: maps.Clone(labels)}
+}
+
+type labeled struct {
+	next   Recorder
+	labels Labels
+}
+
+func (l *labeled) with(labels Labels) Labels {
+	merged := make(Labels, len(l.labels)+len(labels))
+	maps.Copy(merged, l.labels)
+	maps.Copy(merged, labels)
+	return merged
+}
+
+func (l *labeled) Counter(name string, labels Labels, delta float64) {
+	l.next.Counter(name, l.with(labels), delta)
+}
+
+func (l *labeled) Gauge(name string, labels Labels, value float64) {
+	l.next.Gauge(name, l.with(labels), value)
+}
+
+func (l *labeled) Histogram(name string, labels Labels, value float64) {
+	l.next.Histogram(name, l.with(labels), value)
+}
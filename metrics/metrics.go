@@ -0,0 +1,231 @@
+// Package metrics defines the minimal instrumentation interface used by
+// wrappers and backends of this module. Adapters to Prometheus,
+// OpenTelemetry etc. implement Recorder in the application.
+package metrics
+
+import (
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Labels are metric dimensions
+type Labels map[string]string
+
+// Recorder receives metric observations
+type Recorder interface {
+	// Counter adds delta to a monotonically increasing counter
+	Counter(name string, labels Labels, delta float64)
+
+	// Gauge sets the current value of a gauge
+	Gauge(name string, labels Labels, value float64)
+
+	// Histogram records one observation (durations in seconds)
+	Histogram(name string, labels Labels, value float64)
+}
+
+// Nop discards all observations
+var Nop Recorder = nop{}
+
+type nop struct{}
+
+func (nop) Counter(string, Labels, float64)   {}
+func (nop) Gauge(string, Labels, float64)     {}
+func (nop) Histogram(string, Labels, float64) {}
+
+// Memory is an in-process Recorder, useful for tests, CLI tools and
+// exposing stats without a metrics system. It is safe for concurrent use.
+type Memory struct {
+	mu         sync.Mutex
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*HistogramSummary
+	series     map[string]series // by Key, for aggregation
+}
+
+type series struct {
+	name   string
+	labels Labels
+}
+
+// HistogramSummary aggregates histogram observations
+type HistogramSummary struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// Mean returns the average observation, 0 without observations
+func (h HistogramSummary) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+// NewMemory creates an empty in-memory recorder
+func NewMemory() *Memory {
+	return &Memory{
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*HistogramSummary),
+		series:     make(map[string]series),
+	}
+}
+
+// key returns the Key of name and labels and remembers the series
+func (m *Memory) key(name string, labels Labels) string {
+	key := Key(name, labels)
+	if _, ok := m.series[key]; !ok {
+		m.series[key] = series{name: name, labels: maps.Clone(labels)}
+	}
+	return key
+}
+
+// Counter implements Recorder
+func (m *Memory) Counter(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	m.counters[m.key(name, labels)] += delta
+	m.mu.Unlock()
+}
+
+// Gauge implements Recorder
+func (m *Memory) Gauge(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	m.gauges[m.key(name, labels)] = value
+	m.mu.Unlock()
+}
+
+// Histogram implements Recorder
+func (m *Memory) Histogram(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.key(name, labels)
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &HistogramSummary{Min: value, Max: value}
+		m.histograms[key] = h
+	}
+	h.Count++
+	h.Sum += value
+	if value < h.Min {
+		h.Min = value
+	}
+	if value > h.Max {
+		h.Max = value
+	}
+}
+
+// CounterValue returns the current value of a counter
+func (m *Memory) CounterValue(name string, labels Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[Key(name, labels)]
+}
+
+// GaugeValue returns the current value of a gauge
+func (m *Memory) GaugeValue(name string, labels Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gauges[Key(name, labels)]
+}
+
+// HistogramValue returns the summary of a histogram
+func (m *Memory) HistogramValue(name string, labels Labels) HistogramSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.histograms[Key(name, labels)]; ok {
+		return *h
+	}
+	return HistogramSummary{}
+}
+
+// CounterTotal returns the sum of all counters named name whose labels
+// include match, e.g. a counter across all namespaces with
+// Labels{LabelState: "deferred"}
+func (m *Memory) CounterTotal(name string, match Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total(m.counters, name, match)
+}
+
+// GaugeTotal returns the sum of all gauges named name whose labels include match
+func (m *Memory) GaugeTotal(name string, match Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total(m.gauges, name, match)
+}
+
+// HistogramTotal merges all histograms named name whose labels include match
+func (m *Memory) HistogramTotal(name string, match Labels) HistogramSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sum HistogramSummary
+	for key, h := range m.histograms {
+		if !m.matches(key, name, match) {
+			continue
+		}
+		if sum.Count == 0 || h.Min < sum.Min {
+			sum.Min = h.Min
+		}
+		if sum.Count == 0 || h.Max > sum.Max {
+			sum.Max = h.Max
+		}
+		sum.Count += h.Count
+		sum.Sum += h.Sum
+	}
+	return sum
+}
+
+func (m *Memory) total(values map[string]float64, name string, match Labels) float64 {
+	var sum float64
+	for key, v := range values {
+		if m.matches(key, name, match) {
+			sum += v
+		}
+	}
+	return sum
+}
+
+// matches reports whether the series key is named name and has all labels
+// of match
+func (m *Memory) matches(key, name string, match Labels) bool {
+	s := m.series[key]
+	if s.name != name {
+		return false
+	}
+	for k, want := range match {
+		if s.labels[k] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Key renders name and labels as `name{k1="v1",k2="v2"}` with sorted keys
+func Key(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(labels[k])
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
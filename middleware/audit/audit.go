@@ -0,0 +1,254 @@
+// Package audit provides a backend decorator recording every mutation in
+// a tamper-evident log for forensic review.
+//
+// Records are hash-chained: each one carries the SHA-256 hash of its
+// predecessor and of itself, so changing, removing or reordering a record
+// breaks the chain from that record on, which Verify detects. Truncating
+// the end of the log is only detected against a head hash kept elsewhere,
+// e.g. printed by Verify into the operator's ticket.
+//
+// Records name the message, the operation and the states involved. Of the
+// metadata written only a digest is kept, so the log holds no header
+// values or errors, and a copy of a message can be matched against it
+// without the log disclosing it. With WithDigestKey the digest is an HMAC
+// only holders of the key can compute; without a key it leaves out header
+// values and errors, which could otherwise be confirmed by guessing.
+//
+// Sinks store the records append-only: FileSink in a local file, and
+// ObjectSink as immutable objects of an object store such as an S3 bucket
+// with Object Lock. A log must have a single writer.
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Op identifies the kind of a recorded mutation
+type Op string
+
+const (
+	OpStore  Op = "store"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+	OpMove   Op = "move"
+	OpErase  Op = "erase" // see metastorage.EraseByHeader
+)
+
+// Record is one entry of the log
+type Record struct {
+	Seq    uint64    `json:"seq"` // Position in the log, starting at 1
+	Time   time.Time `json:"time"`
+	Op     Op        `json:"op"`
+	ID     string    `json:"id"`
+	From   string    `json:"from,omitempty"`   // State before a move
+	To     string    `json:"to,omitempty"`     // State written by a store, update or move
+	Digest string    `json:"digest,omitempty"` // Digest of the metadata written, see Digest
+	Error  string    `json:"error,omitempty"`  // Failure of the mutation; failed attempts are recorded too
+	Prev   string    `json:"prev"`             // Hash of the previous record, "" for the first
+	Hash   string    `json:"hash"`             // Hash of this record, see Record.Sum
+}
+
+// Sum returns the hash of r: the hex SHA-256 of its JSON encoding with an
+// empty Hash field
+func (r Record) Sum() string {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		panic(err) // all fields marshal
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Digest returns the digest of m recorded for stores and updates: the hex
+// HMAC-SHA256 of the JSON encoding of m under key. Without a key it is the
+// hex SHA-256 of m with header values and LastError cleared.
+func Digest(key []byte, m metastorage.MessageMetadata) string {
+	if len(key) == 0 {
+		headers := make(map[string]string, len(m.Headers))
+		for k := range m.Headers {
+			headers[k] = ""
+		}
+		m.Headers = headers
+		m.LastError = ""
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type digestKeyKey struct{}
+
+// WithDigestKey sets the key of the digests recorded by NewLog, see
+// Digest. Keep it apart from the log, e.g. in a secret store.
+func WithDigestKey(key []byte) options.Option {
+	return options.WithValue(digestKeyKey{}, key)
+}
+
+// Sink stores the records of a log in order
+type Sink interface {
+	// Append durably stores r after the last record
+	Append(ctx context.Context, r Record) error
+
+	// Last returns the last record, false if the log is empty
+	Last(ctx context.Context) (Record, bool, error)
+
+	// Records calls fn for every record in order until fn fails
+	Records(ctx context.Context, fn func(Record) error) error
+
+	// Close releases the sink
+	Close() error
+}
+
+// Log appends chained records to a sink
+type Log struct {
+	sink  Sink
+	clock clock.Clock
+	key   []byte
+
+	mu   sync.Mutex
+	seq  uint64
+	prev string
+}
+
+// NewLog continues the chain of the records in sink
+func NewLog(ctx context.Context, sink Sink, opts ...options.Option) (*Log, error) {
+	o := options.Apply(opts...)
+	last, ok, err := sink.Last(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("audit: read last record: %w", err)
+	}
+	l := &Log{sink: sink, clock: o.Clock, key: options.ValueOr[[]byte](o, digestKeyKey{}, nil)}
+	if ok {
+		if last.Sum() != last.Hash {
+			return nil, fmt.Errorf("audit: %w: last record %d", ErrTampered, last.Seq)
+		}
+		l.seq, l.prev = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// Append chains r to the log and stores it. Seq, Time, Prev and Hash are
+// set by the log.
+func (l *Log) Append(ctx context.Context, r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Seq = l.seq + 1
+	r.Time = l.clock.Now().UTC()
+	r.Prev = l.prev
+	r.Hash = r.Sum()
+	if err := l.sink.Append(ctx, r); err != nil {
+		return err
+	}
+	l.seq, l.prev = r.Seq, r.Hash
+	return nil
+}
+
+// Digest returns the digest of m under the key of the log, see Digest
+func (l *Log) Digest(m metastorage.MessageMetadata) string {
+	return Digest(l.key, m)
+}
+
+// Head returns the hash of the last record, "" for an empty log
+func (l *Log) Head() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.prev
+}
+
+// Close closes the sink
+func (l *Log) Close() error {
+	return l.sink.Close()
+}
+
+// Backend records the mutations of the wrapped backend in a log
+type Backend struct {
+	metastorage.Backend
+	log *Log
+}
+
+// New wraps backend, recording its mutations in log. Close closes the log
+// after the backend.
+func New(backend metastorage.Backend, log *Log) metastorage.Backend {
+	return metastorage.Wrap(backend, &Backend{Backend: backend, log: log})
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(log *Log) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return &Backend{Backend: b, log: log}
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// record appends r for a mutation that returned err. A failing append is
+// returned with err: the mutation took effect, but the log misses it.
+func (b *Backend) record(ctx context.Context, r Record, err error) error {
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if aerr := b.log.Append(ctx, r); aerr != nil {
+		return errors.Join(err, fmt.Errorf("audit: record %s %s: %w", r.Op, r.ID, aerr))
+	}
+	return err
+}
+
+// StoreMeta stores message metadata and records it
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	err := b.Backend.StoreMeta(ctx, messageID, metadata)
+	return b.record(ctx, Record{Op: OpStore, ID: messageID, To: metastorage.StateLabel(metadata.State), Digest: b.log.Digest(metadata)}, err)
+}
+
+// UpdateMeta updates message metadata and records it
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	err := b.Backend.UpdateMeta(ctx, messageID, metadata)
+	return b.record(ctx, Record{Op: OpUpdate, ID: messageID, To: metastorage.StateLabel(metadata.State), Digest: b.log.Digest(metadata)}, err)
+}
+
+// DeleteMeta removes message metadata and records it
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	err := b.Backend.DeleteMeta(ctx, messageID)
+	return b.record(ctx, Record{Op: OpDelete, ID: messageID}, err)
+}
+
+// MoveToState moves the message and records it
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	err := b.Backend.MoveToState(ctx, messageID, fromState, toState)
+	return b.record(ctx, Record{Op: OpMove, ID: messageID, From: metastorage.StateLabel(fromState), To: metastorage.StateLabel(toState)}, err)
+}
+
+// Erase records the erasure of a message, see metastorage.EraseBackend.
+// Records cannot be removed from the log; as they hold no header values,
+// they are kept.
+func (b *Backend) Erase(ctx context.Context, messageID string, _ metastorage.EraseOptions) error {
+	return b.record(ctx, Record{Op: OpErase, ID: messageID}, nil)
+}
+
+// Close closes the wrapped backend and the log
+func (b *Backend) Close() error {
+	return errors.Join(b.Backend.Close(), b.log.Close())
+}
@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// mutate runs a few mutations through an audited memory backend
+func mutate(t *testing.T, sink Sink) *Log {
+	t.Helper()
+	ctx := context.Background()
+	log, err := NewLog(ctx, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := New(memory.New(), log)
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}
+	if err := b.StoreMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive); !errors.Is(err, metastorage.ErrStateConflict) {
+		t.Fatalf("second move: %v, want state conflict", err)
+	}
+	if err := b.DeleteMeta(ctx, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestFileLog(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "meta.audit")
+	sink, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := mutate(t, sink).Head()
+	sink.Close()
+
+	// a second writer continues the chain
+	sink, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, sink)
+	report, err := Verify(ctx, sink, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 8 {
+		t.Fatalf("%d records, want 8", report.Records)
+	}
+	sink.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), `"to":"active"`, `"to":"deferred"`, 1)
+	if err := os.WriteFile(path, []byte(tampered), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyRecords(ctx, func(ctx context.Context, fn func(Record) error) error {
+		return ReadFile(ctx, path, fn)
+	}, "")
+	var chainErr *ChainError
+	if !errors.As(err, &chainErr) || chainErr.Seq != 2 {
+		t.Fatalf("tampered log: %v, want a chain error at record 2", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(path, []byte(strings.Join(lines[:3], "")), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyRecords(ctx, func(ctx context.Context, fn func(Record) error) error {
+		return ReadFile(ctx, path, fn)
+	}, head)
+	if !errors.Is(err, ErrTampered) {
+		t.Fatalf("truncated log: %v, want tampered", err)
+	}
+}
+
+// mapStore is an in-memory ObjectStore
+type mapStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *mapStore) Create(_ context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; ok {
+		return ErrObjectExists
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *mapStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (s *mapStore) List(_ context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestObjectLog(t *testing.T) {
+	ctx := context.Background()
+	store := &mapStore{objects: make(map[string][]byte)}
+	sink := NewObjectSink(store, "audit/")
+	mutate(t, sink)
+	report, err := Verify(ctx, sink, "")
+	if err != nil || report.Records != 4 {
+		t.Fatalf("verify: %+v, %v", report, err)
+	}
+
+	// a stale writer cannot overwrite a record
+	stale := &Log{sink: sink, clock: clock.System}
+	if err := stale.Append(ctx, Record{Op: OpDelete, ID: "m1"}); !errors.Is(err, ErrObjectExists) {
+		t.Fatalf("stale append: %v, want object exists", err)
+	}
+}
+
+func TestDigestHidesHeaderValues(t *testing.T) {
+	m := metastorage.MessageMetadata{ID: "m1", Headers: map[string]string{"to": "alice@example.com"}}
+	guess := m
+	guess.Headers = map[string]string{"to": "bob@example.com"}
+	if Digest(nil, m) != Digest(nil, guess) {
+		t.Fatal("unkeyed digest depends on header values")
+	}
+	key := []byte("secret")
+	if Digest(key, m) == Digest(key, guess) {
+		t.Fatal("keyed digest ignores header values")
+	}
+	if Digest(key, m) == Digest([]byte("other"), m) {
+		t.Fatal("keyed digest ignores the key")
+	}
+}
@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxLine is the longest record line FileSink reads
+const maxLine = 1 << 20
+
+// FileSink stores records as JSON lines in a local file opened for
+// appending only. Every record is synced to disk before Append returns.
+// For write-once protection beyond the process, mark the file append-only
+// (chattr +a) or ship it to a WORM store.
+type FileSink struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFile opens or creates the log file at path
+func OpenFile(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return &FileSink{path: path, f: f}, nil
+}
+
+// Append writes r as one line and syncs the file
+func (s *FileSink) Append(_ context.Context, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Last returns the last record of the file, reading it from the start
+func (s *FileSink) Last(ctx context.Context) (Record, bool, error) {
+	var (
+		last Record
+		ok   bool
+	)
+	err := s.Records(ctx, func(r Record) error {
+		last, ok = r, true
+		return nil
+	})
+	return last, ok, err
+}
+
+// Records reads the records of the file in order
+func (s *FileSink) Records(ctx context.Context, fn func(Record) error) error {
+	return ReadFile(ctx, s.path, fn)
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// ReadFile calls fn for the records of the log file at path in order,
+// e.g. to verify a copy of a log without opening it for writing
+func ReadFile(ctx context.Context, path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("audit: %s line %d: %w", path, line, err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ErrObjectExists is returned by ObjectStore.Create for existing keys
+var ErrObjectExists = errors.New("object exists")
+
+// ObjectStore is the object storage an ObjectSink writes to
+type ObjectStore interface {
+	// Create stores a new object, failing with ErrObjectExists if key
+	// exists; objects are never overwritten
+	Create(ctx context.Context, key string, data []byte) error
+
+	// Get returns the data of an object
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// List returns the keys starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ObjectSink stores every record as an object of its own named
+// "<prefix><seq>.json", with seq zero-padded so keys sort in log order.
+// Objects are only created, never overwritten; with a store enforcing
+// retention, such as an S3 bucket with Object Lock in compliance mode,
+// records cannot be removed either.
+type ObjectSink struct {
+	store  ObjectStore
+	prefix string
+}
+
+// NewObjectSink creates a sink writing below prefix, e.g. "audit/"
+func NewObjectSink(store ObjectStore, prefix string) *ObjectSink {
+	return &ObjectSink{store: store, prefix: prefix}
+}
+
+func (s *ObjectSink) key(seq uint64) string {
+	return fmt.Sprintf("%s%020d.json", s.prefix, seq)
+}
+
+// Append creates the object of r. A second writer creating the same
+// record fails with ErrObjectExists instead of overwriting it.
+func (s *ObjectSink) Append(ctx context.Context, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.store.Create(ctx, s.key(r.Seq), data)
+}
+
+// keys returns the record keys in log order
+func (s *ObjectSink) keys(ctx context.Context) ([]string, error) {
+	listed, err := s.store.List(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := listed[:0]
+	for _, k := range listed {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(k, s.prefix), ".json")
+		if _, err := strconv.ParseUint(name, 10, 64); ok && err == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *ObjectSink) get(ctx context.Context, key string) (Record, error) {
+	data, err := s.store.Get(ctx, key)
+	if err != nil {
+		return Record{}, err
+	}
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Record{}, fmt.Errorf("audit: %s: %w", key, err)
+	}
+	return r, nil
+}
+
+// Last returns the record with the highest key
+func (s *ObjectSink) Last(ctx context.Context) (Record, bool, error) {
+	keys, err := s.keys(ctx)
+	if err != nil || len(keys) == 0 {
+		return Record{}, false, err
+	}
+	r, err := s.get(ctx, keys[len(keys)-1])
+	return r, err == nil, err
+}
+
+// Records reads the record objects in key order
+func (s *ObjectSink) Records(ctx context.Context, fn func(Record) error) error {
+	keys, err := s.keys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		r, err := s.get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing; the store is owned by the caller
+func (s *ObjectSink) Close() error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTampered is returned for logs whose chain is broken
+var ErrTampered = errors.New("audit log tampered")
+
+// ChainError reports the first record breaking the chain
+type ChainError struct {
+	Seq    uint64 // Position of the record in the log, counted from 1
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("%v: record %d: %s", ErrTampered, e.Seq, e.Reason)
+}
+
+func (e *ChainError) Unwrap() error {
+	return ErrTampered
+}
+
+// Report is the result of Verify
+type Report struct {
+	Records int
+	First   time.Time // Time of the first record
+	Last    time.Time // Time of the last record
+	Head    string    // Hash of the last record; keep it to detect truncation later
+}
+
+// Verify checks the chain of the records in sink: every record must
+// follow its predecessor in sequence, name its hash and match its own
+// hash. With head set, the log must end in the record of that hash or
+// continue after it, which detects logs truncated since head was taken.
+// A broken chain is returned as a *ChainError, with the report covering
+// the records before it.
+func Verify(ctx context.Context, sink Sink, head string) (Report, error) {
+	return VerifyRecords(ctx, sink.Records, head)
+}
+
+// VerifyRecords is Verify for records read by records, e.g. ReadFile
+func VerifyRecords(ctx context.Context, records func(context.Context, func(Record) error) error, head string) (Report, error) {
+	var (
+		report   Report
+		seq      uint64
+		prev     string
+		headSeen bool
+	)
+	err := records(ctx, func(r Record) error {
+		seq++
+		switch {
+		case r.Seq != seq:
+			return &ChainError{Seq: seq, Reason: fmt.Sprintf("has sequence %d", r.Seq)}
+		case r.Prev != prev:
+			return &ChainError{Seq: seq, Reason: "does not follow the previous record"}
+		case r.Sum() != r.Hash:
+			return &ChainError{Seq: seq, Reason: "content does not match its hash"}
+		}
+		if report.Records == 0 {
+			report.First = r.Time
+		}
+		report.Records++
+		report.Last = r.Time
+		report.Head = r.Hash
+		prev = r.Hash
+		headSeen = headSeen || r.Hash == head
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if head != "" && !headSeen {
+		return report, &ChainError{Seq: seq + 1, Reason: "log ends before the expected head " + head}
+	}
+	return report, nil
+}
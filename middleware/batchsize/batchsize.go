@@ -0,0 +1,223 @@
+// Package batchsize provides a decorator that sizes iterator batches
+// adaptively, so a single static batch size hint does not have to suit
+// both tiny metadata and messages with large header payloads.
+//
+// Sizes are controlled per state with additive increase, multiplicative
+// decrease (AIMD): after every batch, the size grows by a fixed step if
+// the batch was read within the target latency and byte budget, and is
+// halved otherwise. Iterators implementing metastorage.ResizableIterator
+// are resized while they run; for all others the learned size applies to
+// the next iterator of the state. As decorators between this layer and the
+// backend hide the resizing, it belongs directly above the backend.
+package batchsize
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricBatchSize is the current batch size, labeled by state
+const MetricBatchSize = "metastorage_iterator_batch_size"
+
+// Defaults
+const (
+	DefaultTargetLatency = 100 * time.Millisecond
+	DefaultMaxBatchBytes = 1 << 20
+	DefaultMinBatchSize  = 10
+	DefaultMaxBatchSize  = 10000
+	DefaultIncrease      = 10
+)
+
+// recordOverhead approximates the bytes of the fixed fields of a message
+const recordOverhead = 128
+
+type (
+	targetLatencyKey struct{}
+	maxBatchBytesKey struct{}
+	minBatchSizeKey  struct{}
+	maxBatchSizeKey  struct{}
+	increaseKey      struct{}
+)
+
+// WithTargetLatency sets how long reading one batch may take before the
+// size is decreased (default DefaultTargetLatency)
+func WithTargetLatency(d time.Duration) options.Option {
+	return options.WithValue(targetLatencyKey{}, d)
+}
+
+// WithMaxBatchBytes sets the approximate size of one batch in bytes
+// before the size is decreased (default DefaultMaxBatchBytes)
+func WithMaxBatchBytes(n int) options.Option {
+	return options.WithValue(maxBatchBytesKey{}, n)
+}
+
+// WithMinBatchSize sets the smallest batch size (default
+// DefaultMinBatchSize)
+func WithMinBatchSize(n int) options.Option {
+	return options.WithValue(minBatchSizeKey{}, n)
+}
+
+// WithMaxBatchSize sets the largest batch size (default
+// DefaultMaxBatchSize)
+func WithMaxBatchSize(n int) options.Option {
+	return options.WithValue(maxBatchSizeKey{}, n)
+}
+
+// WithIncrease sets the additive step of the batch size (default
+// DefaultIncrease)
+func WithIncrease(n int) options.Option {
+	return options.WithValue(increaseKey{}, n)
+}
+
+// Backend sizes the batches of its iterators adaptively
+type Backend struct {
+	metastorage.Backend
+	targetLatency time.Duration
+	maxBytes      int
+	minSize       int
+	maxSize       int
+	increase      int
+	initial       int
+	clock         clock.Clock
+	metrics       metrics.Recorder
+
+	mu    sync.Mutex
+	sizes map[metastorage.QueueState]int // learned per state
+}
+
+// New wraps backend with adaptive batch sizing
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	minSize := max(options.ValueOr(o, minBatchSizeKey{}, DefaultMinBatchSize), 1)
+	return &Backend{
+		Backend:       backend,
+		targetLatency: options.ValueOr(o, targetLatencyKey{}, DefaultTargetLatency),
+		maxBytes:      options.ValueOr(o, maxBatchBytesKey{}, DefaultMaxBatchBytes),
+		minSize:       minSize,
+		maxSize:       max(options.ValueOr(o, maxBatchSizeKey{}, DefaultMaxBatchSize), minSize),
+		increase:      max(options.ValueOr(o, increaseKey{}, DefaultIncrease), 1),
+		initial:       o.BatchSize,
+		clock:         o.Clock,
+		metrics:       o.Metrics,
+		sizes:         make(map[metastorage.QueueState]int),
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// BatchSize returns the batch size the next iterator of state starts
+// with, 0 before the first iterator of state
+func (b *Backend) BatchSize(state metastorage.QueueState) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sizes[state]
+}
+
+// NewMessageIterator creates an iterator starting with the size learned
+// for state. batchSize is only used until the first batch was measured.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	b.mu.Lock()
+	size, ok := b.sizes[state]
+	if !ok {
+		if batchSize <= 0 {
+			batchSize = b.initial
+		}
+		size = b.clamp(batchSize)
+		b.sizes[state] = size
+	}
+	b.mu.Unlock()
+
+	iter, err := b.Backend.NewMessageIterator(ctx, state, size)
+	if err != nil {
+		return nil, err
+	}
+	resizable, _ := iter.(metastorage.ResizableIterator)
+	return &iterator{MessageIterator: iter, backend: b, state: state, size: size, resizable: resizable}, nil
+}
+
+func (b *Backend) clamp(n int) int {
+	return min(max(n, b.minSize), b.maxSize)
+}
+
+// adjust returns the size following a batch of size messages, read in
+// latency with the given bytes, and records it for state
+func (b *Backend) adjust(state metastorage.QueueState, size int, latency time.Duration, bytes int) int {
+	if latency > b.targetLatency || (b.maxBytes > 0 && bytes > b.maxBytes) {
+		size /= 2
+	} else {
+		size += b.increase
+	}
+	size = b.clamp(size)
+	b.mu.Lock()
+	b.sizes[state] = size
+	b.mu.Unlock()
+	b.metrics.Gauge(MetricBatchSize, metrics.Labels{"state": metastorage.StateLabel(state)}, float64(size))
+	return size
+}
+
+// recordSize approximates the bytes of m
+func recordSize(m metastorage.MessageMetadata) int {
+	n := recordOverhead + len(m.ID) + len(m.LastError) + len(m.RetryPolicyName)
+	for k, v := range m.Headers {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// iterator measures the batches of the wrapped iterator. A batch is
+// assumed to end after size messages; its latency is the time spent in
+// Next, which is dominated by the backend call fetching it.
+type iterator struct {
+	metastorage.MessageIterator
+	backend   *Backend
+	state     metastorage.QueueState
+	resizable metastorage.ResizableIterator // nil if not resizable
+
+	size    int // of the current batch
+	count   int
+	bytes   int
+	latency time.Duration
+}
+
+// Next returns the next message and adjusts the batch size after every
+// full batch
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	start := it.backend.clock.Now()
+	m, more, err := it.MessageIterator.Next(ctx)
+	it.latency += it.backend.clock.Now().Sub(start)
+	if err != nil || !more {
+		return m, more, err
+	}
+	it.count++
+	it.bytes += recordSize(m)
+	if it.count >= it.size {
+		size := it.backend.adjust(it.state, it.size, it.latency, it.bytes)
+		// other iterators keep their batches, the size applies to the next
+		if it.resizable != nil {
+			it.resizable.SetBatchSize(size)
+			it.size = size
+		}
+		it.count, it.bytes, it.latency = 0, 0, 0
+	}
+	return m, more, err
+}
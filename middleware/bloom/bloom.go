@@ -0,0 +1,308 @@
+// Package bloom provides a decorator that keeps a counting bloom filter
+// of message IDs per state, so lookups of unknown IDs are answered
+// without a backend round trip. This matters for duplicate checks at high
+// ingest rates, where almost every checked ID is new.
+//
+// The filters live in process memory and are loaded by Recover (see
+// metastorage.Recover); until then every call passes through. Afterwards
+// GetMeta of an ID no filter contains fails with
+// metastorage.ErrMessageNotFound immediately, and Exists and ExistsIn
+// answer false. A filter can report an ID that is not stored, a false
+// positive, but never misses a stored one, as long as all writers of the
+// backend go through the same wrapper instance. Multi-node deployments
+// must not use it.
+//
+// Deleted messages stay in the filters until the next Recover, since
+// DeleteMeta does not name the state to remove them from. They cost a
+// backend lookup, like any false positive; MetricFalsePositives tells
+// when the filters should be reloaded or sized up.
+package bloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+const (
+	// MetricNegatives counts lookups answered by the filters without the
+	// backend, labeled by op (get, exists)
+	MetricNegatives = "metastorage_bloom_negatives_total"
+
+	// MetricFalsePositives counts lookups the filters passed to the
+	// backend for IDs that are not stored (in the state), labeled by op
+	MetricFalsePositives = "metastorage_bloom_false_positives_total"
+)
+
+// Defaults
+const (
+	DefaultExpected          = 100000
+	DefaultFalsePositiveRate = 0.01
+)
+
+type (
+	expectedKey struct{}
+	fpRateKey   struct{}
+)
+
+// WithExpected sets the number of messages per state each filter is sized
+// for (default DefaultExpected). Beyond it the false positive rate rises.
+func WithExpected(n int) options.Option {
+	return options.WithValue(expectedKey{}, n)
+}
+
+// WithFalsePositiveRate sets the false positive rate at the expected
+// number of messages (default DefaultFalsePositiveRate)
+func WithFalsePositiveRate(p float64) options.Option {
+	return options.WithValue(fpRateKey{}, p)
+}
+
+// added is a filter addition made while the filters are being loaded
+type added struct {
+	id    string
+	state metastorage.QueueState
+}
+
+// Backend short-circuits lookups of IDs missing from its filters
+type Backend struct {
+	metastorage.Backend
+	expected  int
+	fpRate    float64
+	batchSize int
+	seed      maphash.Seed
+	metrics   metrics.Recorder
+
+	load    sync.Mutex // serializes Recover
+	mu      sync.RWMutex
+	filters map[metastorage.QueueState]*filter // nil until loaded
+	loading bool
+	pending []added // additions during loading, replayed into the new filters
+}
+
+// New wraps backend with bloom filters. They are empty and unused until
+// Recover loads them.
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	fpRate := options.ValueOr(o, fpRateKey{}, DefaultFalsePositiveRate)
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultFalsePositiveRate
+	}
+	return &Backend{
+		Backend:   backend,
+		expected:  options.ValueOr(o, expectedKey{}, DefaultExpected),
+		fpRate:    fpRate,
+		batchSize: o.BatchSize,
+		seed:      maphash.MakeSeed(),
+		metrics:   o.Metrics,
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// Loaded reports whether the filters are loaded and answer lookups
+func (b *Backend) Loaded() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.filters != nil
+}
+
+// Recover (re)loads the filters from every state of the backend; see
+// metastorage.Recover. Writes made meanwhile are replayed into the new
+// filters, so they are complete when they replace the old ones.
+func (b *Backend) Recover(ctx context.Context) error {
+	b.load.Lock()
+	defer b.load.Unlock()
+
+	b.mu.Lock()
+	b.loading, b.pending = true, nil
+	b.mu.Unlock()
+
+	filters := make(map[metastorage.QueueState]*filter)
+	for _, state := range metastorage.States() {
+		f := newFilter(b.seed, b.expected, b.fpRate)
+		if err := b.scan(ctx, state, f); err != nil {
+			b.mu.Lock()
+			b.loading, b.pending = false, nil
+			b.mu.Unlock()
+			return fmt.Errorf("bloom: scan %s: %w", state, err)
+		}
+		filters[state] = f
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filters = filters
+	for _, a := range b.pending {
+		b.filterLocked(a.state).add(a.id)
+	}
+	b.loading, b.pending = false, nil
+	return nil
+}
+
+func (b *Backend) scan(ctx context.Context, state metastorage.QueueState, f *filter) error {
+	iter, err := b.Backend.NewMessageIterator(ctx, state, b.batchSize)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		f.add(m.ID)
+	}
+}
+
+// filterLocked returns the filter of state, creating it for states the
+// load did not know
+func (b *Backend) filterLocked(state metastorage.QueueState) *filter {
+	f, ok := b.filters[state]
+	if !ok {
+		f = newFilter(b.seed, b.expected, b.fpRate)
+		b.filters[state] = f
+	}
+	return f
+}
+
+func (b *Backend) add(id string, state metastorage.QueueState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.filters != nil {
+		b.filterLocked(state).add(id)
+	}
+	if b.loading {
+		b.pending = append(b.pending, added{id: id, state: state})
+	}
+}
+
+// remove drops id from the filter of state. While loading it is skipped,
+// as the new filters may not contain id yet.
+func (b *Backend) remove(id string, state metastorage.QueueState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.filters != nil && !b.loading {
+		b.filterLocked(state).remove(id)
+	}
+}
+
+// absent reports whether id is definitely not stored in one of states, or
+// in any state if none are given
+func (b *Backend) absent(id string, states ...metastorage.QueueState) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.filters == nil {
+		return false
+	}
+	if len(states) == 0 {
+		for _, f := range b.filters {
+			if f.contains(id) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, s := range states {
+		if f, ok := b.filters[s]; ok && f.contains(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetMeta fails with metastorage.ErrMessageNotFound without a backend call
+// if no filter contains messageID
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if b.absent(messageID) {
+		b.metrics.Counter(MetricNegatives, metrics.Labels{"op": "get"}, 1)
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	if errors.Is(err, metastorage.ErrMessageNotFound) && b.Loaded() {
+		b.metrics.Counter(MetricFalsePositives, metrics.Labels{"op": "get"}, 1)
+	}
+	return m, err
+}
+
+// Exists reports whether messageID is stored, see metastorage.Exists
+func (b *Backend) Exists(ctx context.Context, messageID string) (bool, error) {
+	return b.ExistsIn(ctx, messageID)
+}
+
+// ExistsIn reports whether messageID is stored in one of states, or in
+// any state if none are given. Only IDs the filters may contain are looked
+// up in the backend.
+func (b *Backend) ExistsIn(ctx context.Context, messageID string, states ...metastorage.QueueState) (bool, error) {
+	if b.absent(messageID, states...) {
+		b.metrics.Counter(MetricNegatives, metrics.Labels{"op": "exists"}, 1)
+		return false, nil
+	}
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	switch {
+	case errors.Is(err, metastorage.ErrMessageNotFound):
+	case err != nil:
+		return false, err
+	case len(states) == 0 || slices.Contains(states, m.State):
+		return true, nil
+	}
+	if b.Loaded() {
+		b.metrics.Counter(MetricFalsePositives, metrics.Labels{"op": "exists"}, 1)
+	}
+	return false, nil
+}
+
+// StoreMeta adds the message to the filter of its state and stores it.
+// Adding first means no lookup can miss a message that is stored.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	b.add(messageID, metadata.State)
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
+
+// MoveToState adds the message to the filter of toState and, once moved,
+// removes it from the filter of fromState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	b.add(messageID, toState)
+	if err := b.Backend.MoveToState(ctx, messageID, fromState, toState); err != nil {
+		return err
+	}
+	b.remove(messageID, fromState)
+	return nil
+}
+
+// ClaimBatch claims through the wrapped backend, see
+// metastorage.ClaimBatch, and moves the claimed messages to the active
+// filter. Messages are added once claimed, so lookups of a message being
+// claimed may briefly miss it in StateActive, though not in state.
+func (b *Backend) ClaimBatch(ctx context.Context, state metastorage.QueueState, workerID string, n int, lease time.Duration) ([]metastorage.MessageMetadata, error) {
+	claimed, err := metastorage.ClaimBatch(ctx, b.Backend, state, workerID, n, lease)
+	for _, m := range claimed {
+		b.add(m.ID, metastorage.StateActive)
+		b.remove(m.ID, state)
+	}
+	return claimed, err
+}
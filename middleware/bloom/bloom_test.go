@@ -0,0 +1,93 @@
+package bloom
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// lookups counts the GetMeta calls reaching the backend
+type lookups struct {
+	*memory.Backend
+	n int
+}
+
+func (l *lookups) GetMeta(ctx context.Context, id string) (metastorage.MessageMetadata, error) {
+	l.n++
+	return l.Backend.GetMeta(ctx, id)
+}
+
+func TestFilter(t *testing.T) {
+	f := newFilter(maphash.MakeSeed(), 100, 0.01)
+	f.add("a")
+	f.add("a")
+	if !f.contains("a") {
+		t.Fatal("added ID missing")
+	}
+	f.remove("a")
+	if !f.contains("a") {
+		t.Fatal("ID added twice missing after one removal")
+	}
+	f.remove("a")
+	if f.contains("a") {
+		t.Fatal("removed ID still contained")
+	}
+}
+
+func TestLookups(t *testing.T) {
+	ctx := context.Background()
+	inner := &lookups{Backend: memory.New()}
+	if err := inner.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	b, ok := metastorage.As[*Backend](New(inner))
+	if !ok {
+		t.Fatal("bloom backend not reachable with As")
+	}
+
+	// not loaded yet: every lookup passes through
+	if _, err := b.GetMeta(ctx, "unknown"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("GetMeta before Recover: %v", err)
+	}
+	if inner.n != 1 {
+		t.Fatalf("backend lookups before Recover = %d, want 1", inner.n)
+	}
+
+	if err := b.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	inner.n = 0
+	if _, err := b.GetMeta(ctx, "unknown"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("GetMeta of unknown ID: %v", err)
+	}
+	if ok, err := b.Exists(ctx, "unknown"); ok || err != nil {
+		t.Fatalf("Exists of unknown ID = %v, %v", ok, err)
+	}
+	if inner.n != 0 {
+		t.Fatalf("backend lookups of unknown IDs = %d, want 0", inner.n)
+	}
+	if _, err := b.GetMeta(ctx, "m1"); err != nil {
+		t.Fatalf("GetMeta of loaded ID: %v", err)
+	}
+
+	if err := b.StoreMeta(ctx, "m2", metastorage.MessageMetadata{ID: "m2", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MoveToState(ctx, "m2", metastorage.StateIncoming, metastorage.StateActive); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.ExistsIn(ctx, "m2", metastorage.StateActive); !ok || err != nil {
+		t.Fatalf("ExistsIn active after move = %v, %v", ok, err)
+	}
+	inner.n = 0
+	if ok, err := b.ExistsIn(ctx, "m2", metastorage.StateIncoming); ok || err != nil {
+		t.Fatalf("ExistsIn incoming after move = %v, %v", ok, err)
+	}
+	if inner.n != 0 {
+		t.Fatalf("backend lookups for the state left = %d, want 0", inner.n)
+	}
+}
@@ -0,0 +1,66 @@
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+// filter is a counting bloom filter: every ID increments k counters, so
+// IDs can be removed again. Saturated counters are never decremented,
+// which only keeps false positives, never causes false negatives.
+type filter struct {
+	seed     maphash.Seed
+	counters []uint8
+	k        int
+}
+
+// newFilter sizes a filter for n IDs at false positive rate p
+func newFilter(seed maphash.Seed, n int, p float64) *filter {
+	n = max(n, 1)
+	m := int(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &filter{seed: seed, counters: make([]uint8, max(m, 1)), k: max(k, 1)}
+}
+
+// positions calls fn with the k counter indexes of id, derived by double
+// hashing from one 64 bit hash
+func (f *filter) positions(id string, fn func(i uint64) bool) {
+	h := maphash.String(f.seed, id)
+	h1, h2 := h, bits.RotateLeft64(h, 32)|1
+	m := uint64(len(f.counters))
+	for i := 0; i < f.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % m) {
+			return
+		}
+	}
+}
+
+func (f *filter) add(id string) {
+	f.positions(id, func(i uint64) bool {
+		if f.counters[i] < math.MaxUint8 {
+			f.counters[i]++
+		}
+		return true
+	})
+}
+
+// remove must only be called for IDs that were added
+func (f *filter) remove(id string) {
+	f.positions(id, func(i uint64) bool {
+		if c := f.counters[i]; c > 0 && c < math.MaxUint8 {
+			f.counters[i]--
+		}
+		return true
+	})
+}
+
+// contains reports false if id was definitely not added
+func (f *filter) contains(id string) bool {
+	found := true
+	f.positions(id, func(i uint64) bool {
+		found = f.counters[i] > 0
+		return found
+	})
+	return found
+}
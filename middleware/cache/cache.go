@@ -0,0 +1,624 @@
+// Package cache provides a read-through cache for message metadata and
+// state counts. It is meant for clients of remote backends, where most
+// reads hit a small set of hot messages.
+//
+// If the wrapped backend implements metastorage.WatchBackend, the cache
+// subscribes to its events and invalidates changed entries as soon as the
+// event arrives, so entries are only stale for the event latency. Without
+// a watch, or while it is disconnected, entries expire after the TTL.
+//
+// Reads honour the consistency of their context, see
+// metastorage.WithConsistency: metastorage.ConsistencyStrong bypasses the
+// cache and refreshes the entry, metastorage.ConsistencyEventual serves
+// entries past their TTL until they are invalidated or evicted, and
+// metastorage.ConsistencyBoundedStaleness serves entries read within the
+// maximum staleness, regardless of the TTL.
+//
+// Whenever an entry is read again from the backend, its Version, or a
+// fingerprint of the metadata for backends without versions, is compared
+// with the new one. A difference means the entry was served stale, e.g.
+// because an invalidation event was lost; it is counted in MetricStale
+// with the age of the entry in MetricStaleAge. ForceRefresh re-reads
+// single entries on demand; iterators read with
+// metastorage.ConsistencyStrong refresh the entries of all messages they
+// return, and such listings drop them.
+//
+// metastorage.UpdateMetaFields, AddNote, Pin, Unpin and DeleteUnpinned
+// are passed to the wrapped backend, so their reads never see cached
+// metadata, and invalidate the message afterwards.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+const (
+	// MetricHits counts reads answered from the cache, labeled by kind
+	// (meta, count)
+	MetricHits = "metastorage_cache_hits_total"
+
+	// MetricMisses counts reads passed to the backend, labeled by kind
+	MetricMisses = "metastorage_cache_misses_total"
+
+	// MetricWatching is 1 while the cache receives invalidation events
+	MetricWatching = "metastorage_cache_watching"
+
+	// MetricStale counts entries found to differ from the backend when
+	// read again, labeled by source (read, refresh, list)
+	MetricStale = "metastorage_cache_stale_total"
+
+	// MetricStaleAge is the age in seconds of entries found stale, an
+	// upper bound of how long they were stale
+	MetricStaleAge = "metastorage_cache_stale_age_seconds"
+)
+
+// Defaults
+const (
+	DefaultSize = 10000
+	DefaultTTL  = 30 * time.Second
+)
+
+// watch reconnect backoff
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+type (
+	sizeKey  struct{}
+	ttlKey   struct{}
+	watchKey struct{}
+)
+
+// WithSize sets the maximum number of cached messages (default DefaultSize)
+func WithSize(n int) options.Option {
+	return options.WithValue(sizeKey{}, n)
+}
+
+// WithTTL sets how long entries are served without revalidation (default
+// DefaultTTL). It bounds staleness when no watch is available.
+func WithTTL(ttl time.Duration) options.Option {
+	return options.WithValue(ttlKey{}, ttl)
+}
+
+// WithWatch enables or disables invalidation through the wrapped
+// backend's Watch (default enabled)
+func WithWatch(enabled bool) options.Option {
+	return options.WithValue(watchKey{}, enabled)
+}
+
+type entry struct {
+	id      string
+	meta    metastorage.MessageMetadata
+	fetched time.Time // when the read of meta started
+	expiry  time.Time
+}
+
+type countEntry struct {
+	value  int64
+	expiry time.Time
+}
+
+// Backend caches GetMeta results of the wrapped backend
+type Backend struct {
+	metastorage.Backend
+	size    int
+	ttl     time.Duration
+	clock   clock.Clock
+	logger  *slog.Logger
+	metrics metrics.Recorder
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front = most recently used
+	counts  map[metastorage.QueueState]countEntry
+	gen     uint64 // incremented on every invalidation
+
+	watching atomic.Bool
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// counterBackend additionally caches GetStateCount. It is a layer of its
+// own above the cache, like the forwarding layer of metastorage.Wrap, so
+// the *Backend stays reachable with metastorage.As.
+type counterBackend struct {
+	*Backend
+	counter metastorage.StateCounterBackend
+}
+
+// New wraps backend with a cache. If a layer of backend implements
+// metastorage.StateCounterBackend, counts are cached as well.
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) metastorage.Backend {
+	o := options.Apply(opts...)
+	b := &Backend{
+		Backend: backend,
+		size:    options.ValueOr(o, sizeKey{}, DefaultSize),
+		ttl:     options.ValueOr(o, ttlKey{}, DefaultTTL),
+		clock:   o.Clock,
+		logger:  o.Logger,
+		metrics: o.Metrics,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		counts:  make(map[metastorage.QueueState]countEntry),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stop = cancel
+	if watcher, ok := metastorage.As[metastorage.WatchBackend](backend); ok && options.ValueOr(o, watchKey{}, true) {
+		b.wg.Add(1)
+		go b.watchLoop(ctx, watcher)
+	}
+	if counter, ok := metastorage.As[metastorage.StateCounterBackend](backend); ok {
+		return &counterBackend{Backend: b, counter: counter}
+	}
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// Watching reports whether invalidation events are currently received
+func (b *Backend) Watching() bool {
+	return b.watching.Load()
+}
+
+// Len returns the number of cached messages
+func (b *Backend) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lru.Len()
+}
+
+// Purge drops all cached data
+func (b *Backend) Purge() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gen++
+	b.entries = make(map[string]*list.Element)
+	b.lru.Init()
+	clear(b.counts)
+}
+
+// Recover drops all cached data, so reads after the repairs of inner
+// layers see the backend, see metastorage.Recover
+func (b *Backend) Recover(context.Context) error {
+	b.Purge()
+	return nil
+}
+
+func (b *Backend) watchLoop(ctx context.Context, watcher metastorage.WatchBackend) {
+	defer b.wg.Done()
+	backoff := minBackoff
+	for {
+		events, err := watcher.Watch(ctx)
+		if err == nil {
+			backoff = minBackoff
+			b.setWatching(true)
+			for e := range events {
+				b.apply(e)
+			}
+			// events may have been missed while the watch broke
+			b.setWatching(false)
+			b.Purge()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errors.ErrUnsupported) {
+			b.logger.Info("metastorage cache: backend cannot watch, entries expire by TTL only")
+			return
+		}
+		if err != nil {
+			b.logger.Warn("metastorage cache watch failed", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (b *Backend) setWatching(on bool) {
+	b.watching.Store(on)
+	v := 0.0
+	if on {
+		v = 1
+	}
+	b.metrics.Gauge(MetricWatching, nil, v)
+}
+
+// apply invalidates everything affected by e
+func (b *Backend) apply(e metastorage.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.invalidateLocked(e.MessageID)
+	switch e.Type {
+	case metastorage.EventDeleted:
+		// the state is not part of the event
+		clear(b.counts)
+	case metastorage.EventMoved:
+		delete(b.counts, e.From)
+		delete(b.counts, e.State)
+	default:
+		delete(b.counts, e.State)
+	}
+}
+
+func (b *Backend) invalidateLocked(id string) {
+	b.gen++
+	if el, ok := b.entries[id]; ok {
+		b.lru.Remove(el)
+		delete(b.entries, id)
+	}
+}
+
+func (b *Backend) invalidate(id string, states ...metastorage.QueueState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.invalidateLocked(id)
+	for _, s := range states {
+		delete(b.counts, s)
+	}
+}
+
+// clone copies the reference types of m so callers cannot modify cached data
+func clone(m metastorage.MessageMetadata) metastorage.MessageMetadata {
+	m.Headers = maps.Clone(m.Headers)
+	m.DeliveryWindow.Weekdays = slices.Clone(m.DeliveryWindow.Weekdays)
+	return m
+}
+
+// fresh reports whether e may be served at now to a read with the
+// consistency of ctx
+func fresh(ctx context.Context, now time.Time, e *entry) bool {
+	o := metastorage.ReadOptionsFromContext(ctx)
+	switch o.Consistency {
+	case metastorage.ConsistencyStrong:
+		return false
+	case metastorage.ConsistencyEventual:
+		return true
+	case metastorage.ConsistencyBoundedStaleness:
+		return now.Sub(e.fetched) <= o.MaxStaleness
+	}
+	return now.Before(e.expiry)
+}
+
+// etag identifies the content of m: its Version, or a fingerprint of the
+// metadata for backends without versions
+func etag(m metastorage.MessageMetadata) uint64 {
+	if m.Version != 0 {
+		return m.Version
+	}
+	h := fnv.New64a()
+	_ = json.NewEncoder(h).Encode(metastorage.NormalizeTimes(m))
+	return h.Sum64()
+}
+
+// detect compares the entry cached before a read with the result of the
+// read, m or err, and records the entry as stale if they differ
+func (b *Backend) detect(cached *entry, m metastorage.MessageMetadata, err error, now time.Time, source string) bool {
+	if cached == nil {
+		return false
+	}
+	switch {
+	case errors.Is(err, metastorage.ErrMessageNotFound):
+		// deleted behind the cache's back
+	case err != nil, etag(cached.meta) == etag(m):
+		return false
+	}
+	b.metrics.Counter(MetricStale, metrics.Labels{"source": source}, 1)
+	b.metrics.Histogram(MetricStaleAge, nil, now.Sub(cached.fetched).Seconds())
+	b.logger.Debug("metastorage cache: stale entry", slog.String("message_id", cached.id), slog.String("source", source))
+	return true
+}
+
+// GetMeta returns cached metadata or reads it from the backend
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	now := b.clock.Now()
+	b.mu.Lock()
+	var cached *entry
+	if el, ok := b.entries[messageID]; ok {
+		e := el.Value.(*entry)
+		if fresh(ctx, now, e) {
+			b.lru.MoveToFront(el)
+			m := clone(e.meta)
+			b.mu.Unlock()
+			b.metrics.Counter(MetricHits, metrics.Labels{"kind": "meta"}, 1)
+			return m, nil
+		}
+		cached = e
+	}
+	gen := b.gen
+	b.mu.Unlock()
+	b.metrics.Counter(MetricMisses, metrics.Labels{"kind": "meta"}, 1)
+
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	b.detect(cached, m, err, now, "read")
+	if err != nil {
+		if cached != nil && errors.Is(err, metastorage.ErrMessageNotFound) {
+			b.invalidate(messageID)
+		}
+		return m, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// skip caching if anything was invalidated during the read, the value
+	// may predate the change
+	if b.gen != gen || b.size <= 0 {
+		return m, nil
+	}
+	b.put(messageID, m, now)
+	return m, nil
+}
+
+// ForceRefresh reads a message from the backend with strong consistency
+// and replaces its entry, e.g. to bust entries suspected stale during an
+// incident. It reports whether the cached entry differed from the
+// backend. A message deleted from the backend is dropped from the cache
+// and ErrMessageNotFound returned.
+func (b *Backend) ForceRefresh(ctx context.Context, messageID string) (bool, error) {
+	now := b.clock.Now()
+	b.mu.Lock()
+	var cached *entry
+	if el, ok := b.entries[messageID]; ok {
+		cached = el.Value.(*entry)
+	}
+	gen := b.gen
+	b.mu.Unlock()
+
+	m, err := b.Backend.GetMeta(metastorage.WithConsistency(ctx, metastorage.ConsistencyStrong), messageID)
+	stale := b.detect(cached, m, err, now, "refresh")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil || b.gen != gen || b.size <= 0 {
+		// drop what may be outdated rather than keep it
+		b.invalidateLocked(messageID)
+		return stale, err
+	}
+	b.put(messageID, m, now)
+	return stale, nil
+}
+
+// refresher is implemented by the cache layers
+type refresher interface {
+	ForceRefresh(ctx context.Context, messageID string) (bool, error)
+}
+
+// ForceRefresh refreshes the entry of messageID in the first cache layer
+// of backend, see Backend.ForceRefresh. It fails with
+// errors.ErrUnsupported if backend has no cache layer.
+func ForceRefresh(ctx context.Context, backend metastorage.Backend, messageID string) (bool, error) {
+	r, ok := metastorage.As[refresher](backend)
+	if !ok {
+		return false, fmt.Errorf("%w: no cache layer", errors.ErrUnsupported)
+	}
+	return r.ForceRefresh(ctx, messageID)
+}
+
+// put caches m read at now, evicting the least recently used entries
+// beyond the size; the caller holds the lock
+func (b *Backend) put(messageID string, m metastorage.MessageMetadata, now time.Time) {
+	e := &entry{id: messageID, meta: clone(m), fetched: now, expiry: now.Add(b.ttl)}
+	if el, ok := b.entries[messageID]; ok {
+		el.Value = e
+		b.lru.MoveToFront(el)
+	} else {
+		b.entries[messageID] = b.lru.PushFront(e)
+	}
+	for b.lru.Len() > b.size {
+		oldest := b.lru.Back()
+		b.lru.Remove(oldest)
+		delete(b.entries, oldest.Value.(*entry).id)
+	}
+}
+
+// StoreMeta stores message metadata
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	err := b.Backend.StoreMeta(ctx, messageID, metadata)
+	b.invalidate(messageID, metadata.State)
+	return err
+}
+
+// UpdateMeta updates message metadata
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	err := b.Backend.UpdateMeta(ctx, messageID, metadata)
+	// the state may have changed as well
+	b.invalidate(messageID, metastorage.States()...)
+	return err
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	err := b.Backend.DeleteMeta(ctx, messageID)
+	b.invalidate(messageID, metastorage.States()...)
+	return err
+}
+
+// MoveToState moves a message between states with CAS semantics
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	err := b.Backend.MoveToState(ctx, messageID, fromState, toState)
+	b.invalidate(messageID, fromState, toState)
+	return err
+}
+
+// UpdateMetaFields writes a patch through the wrapped backend, natively if
+// it implements metastorage.PatchBackend, otherwise with a read-modify-write
+// that reads past the cache
+func (b *Backend) UpdateMetaFields(ctx context.Context, messageID string, patch metastorage.MetadataPatch) error {
+	err := metastorage.UpdateMetaFields(ctx, b.Backend, messageID, patch)
+	b.invalidate(messageID, metastorage.States()...)
+	return err
+}
+
+// AddNote appends a note through the wrapped backend, see UpdateMetaFields
+func (b *Backend) AddNote(ctx context.Context, messageID, author, text string) error {
+	err := metastorage.AddNote(ctx, b.Backend, messageID, author, text)
+	b.invalidate(messageID)
+	return err
+}
+
+// Notes reads the notes of a message from the wrapped backend
+func (b *Backend) Notes(ctx context.Context, messageID string) ([]metastorage.Note, error) {
+	return metastorage.Notes(ctx, b.Backend, messageID)
+}
+
+// Pin pins a message through the wrapped backend, see UpdateMetaFields
+func (b *Backend) Pin(ctx context.Context, messageID, reason string) error {
+	err := metastorage.Pin(ctx, b.Backend, messageID, reason)
+	b.invalidate(messageID)
+	return err
+}
+
+// Unpin removes a message's pin through the wrapped backend
+func (b *Backend) Unpin(ctx context.Context, messageID string) error {
+	err := metastorage.Unpin(ctx, b.Backend, messageID)
+	b.invalidate(messageID)
+	return err
+}
+
+// DeleteUnpinned deletes a message unless it is pinned, checking the pin
+// in the wrapped backend rather than in the cache
+func (b *Backend) DeleteUnpinned(ctx context.Context, messageID string) error {
+	err := metastorage.DeleteUnpinned(ctx, b.Backend, messageID)
+	b.invalidate(messageID, metastorage.States()...)
+	return err
+}
+
+// ListMessages lists messages from the backend. Listings read with
+// metastorage.ConsistencyStrong only return IDs, so they drop the entries
+// of the listed messages and the cached count of the state, and the next
+// reads of them go to the backend.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, options metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	ctx = metastorage.ListContext(ctx, options)
+	result, err := b.Backend.ListMessages(ctx, state, options)
+	if err != nil || metastorage.ConsistencyFromContext(ctx) != metastorage.ConsistencyStrong {
+		return result, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range result.MessageIDs {
+		b.invalidateLocked(id)
+	}
+	delete(b.counts, state)
+	return result, nil
+}
+
+// NewMessageIterator iterates the messages of a state in the backend.
+// Iterators created with metastorage.ConsistencyStrong refresh the entries
+// of the messages they return.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	iter, err := b.Backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil || metastorage.ConsistencyFromContext(ctx) != metastorage.ConsistencyStrong {
+		return iter, err
+	}
+	return &refreshingIterator{MessageIterator: iter, backend: b}, nil
+}
+
+// refreshingIterator refreshes the entries of the messages it returns
+type refreshingIterator struct {
+	metastorage.MessageIterator
+	backend *Backend
+}
+
+// Next returns the next message and refreshes its entry
+func (it *refreshingIterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	b := it.backend
+	now := b.clock.Now()
+	b.mu.Lock()
+	gen := b.gen
+	b.mu.Unlock()
+	m, ok, err := it.MessageIterator.Next(ctx)
+	if ok && err == nil {
+		b.refresh(m, gen, now)
+	}
+	return m, ok, err
+}
+
+// refresh replaces the entry of m, read from the backend at now by a
+// listing that started at generation gen. A stale entry is recorded and,
+// if the value may predate an invalidation during the read, dropped.
+func (b *Backend) refresh(m metastorage.MessageMetadata, gen uint64, now time.Time) {
+	b.mu.Lock()
+	var cached *entry
+	if el, ok := b.entries[m.ID]; ok {
+		cached = el.Value.(*entry)
+	}
+	b.mu.Unlock()
+	stale := b.detect(cached, m, nil, now, "list")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.gen == gen && b.size > 0:
+		b.put(m.ID, m, now)
+	case stale:
+		b.invalidateLocked(m.ID)
+	}
+}
+
+// Close stops watching and closes the wrapped backend
+func (b *Backend) Close() error {
+	b.stop()
+	b.wg.Wait()
+	return b.Backend.Close()
+}
+
+// Unwrap returns the cache layer below the count cache
+func (b *counterBackend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// GetStateCount returns the cached count or asks the backend
+func (b *counterBackend) GetStateCount(state metastorage.QueueState) int64 {
+	now := b.clock.Now()
+	b.mu.Lock()
+	if c, ok := b.counts[state]; ok && now.Before(c.expiry) {
+		b.mu.Unlock()
+		b.metrics.Counter(MetricHits, metrics.Labels{"kind": "count"}, 1)
+		return c.value
+	}
+	gen := b.gen
+	b.mu.Unlock()
+	b.metrics.Counter(MetricMisses, metrics.Labels{"kind": "count"}, 1)
+
+	n := b.counter.GetStateCount(state)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.gen == gen {
+		b.counts[state] = countEntry{value: n, expiry: now.Add(b.ttl)}
+	}
+	return n
+}
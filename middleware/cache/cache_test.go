@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+func TestStaleDetection(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	rec := metrics.NewMemory()
+	inner := memory.New()
+	b := New(inner, WithWatch(false), WithTTL(time.Minute), options.WithClock(clk), options.WithMetrics(rec))
+	defer b.Close()
+	for _, id := range []string{"m1", "m2"} {
+		if err := b.StoreMeta(ctx, id, metastorage.MessageMetadata{ID: id, State: metastorage.StateDeferred}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.GetMeta(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// changed behind the cache's back, as if an event was lost
+	if err := inner.MoveToState(ctx, "m1", metastorage.StateDeferred, metastorage.StateHold); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := ForceRefresh(ctx, b, "m1")
+	if err != nil || !stale {
+		t.Fatalf("refresh of m1: %v %v, want stale", stale, err)
+	}
+	if m, _ := b.GetMeta(ctx, "m1"); m.State != metastorage.StateHold {
+		t.Errorf("after refresh: %s, want hold", m.State)
+	}
+	if stale, err := ForceRefresh(ctx, b, "m2"); err != nil || stale {
+		t.Errorf("refresh of unchanged m2: %v %v", stale, err)
+	}
+
+	// expiry finds the next change
+	if err := inner.DeleteMeta(ctx, "m2"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(2 * time.Minute)
+	if _, err := b.GetMeta(ctx, "m2"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("deleted m2: %v", err)
+	}
+
+	if n := rec.CounterValue(MetricStale, metrics.Labels{"source": "refresh"}); n != 1 {
+		t.Errorf("stale on refresh: %v, want 1", n)
+	}
+	if n := rec.CounterValue(MetricStale, metrics.Labels{"source": "read"}); n != 1 {
+		t.Errorf("stale on read: %v, want 1", n)
+	}
+	if h := rec.HistogramValue(MetricStaleAge, nil); h.Count != 2 || h.Max < 120 {
+		t.Errorf("stale age: %+v", h)
+	}
+
+	if _, err := ForceRefresh(ctx, inner, "m1"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("without cache: %v, want ErrUnsupported", err)
+	}
+}
+
+// generic hides the native extensions of the backend it wraps
+type generic struct {
+	metastorage.Backend
+}
+
+func (g generic) Unwrap() metastorage.Backend {
+	return g.Backend
+}
+
+func TestReadModifyWriteBypassesCache(t *testing.T) {
+	for name, wrap := range map[string]func(*memory.Backend) metastorage.Backend{
+		"native":  func(b *memory.Backend) metastorage.Backend { return b },
+		"generic": func(b *memory.Backend) metastorage.Backend { return generic{b} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			inner := memory.New()
+			b := New(wrap(inner), WithWatch(false), WithTTL(time.Hour))
+			defer b.Close()
+			if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateDeferred}); err != nil {
+				t.Fatal(err)
+			}
+			m, err := b.GetMeta(ctx, "m1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// changed behind the cache's back
+			m.Attempts = 5
+			if err := inner.UpdateMeta(ctx, "m1", m); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := metastorage.UpdateMetaFields(ctx, b, "m1", metastorage.MetadataPatch{AddAttempts: 1}); err != nil {
+				t.Fatal(err)
+			}
+			if err := metastorage.Pin(ctx, b, "m1", "investigating"); err != nil {
+				t.Fatal(err)
+			}
+			m, err = b.GetMeta(ctx, "m1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, pinned := metastorage.IsPinned(m); m.Attempts != 6 || !pinned {
+				t.Fatalf("after patch and pin: %d attempts, pinned %v; want 6 and pinned", m.Attempts, pinned)
+			}
+			if err := metastorage.DeleteUnpinned(ctx, b, "m1"); !errors.Is(err, metastorage.ErrPinned) {
+				t.Fatalf("delete of pinned message: %v, want ErrPinned", err)
+			}
+		})
+	}
+}
+
+func TestStrongListingsRefresh(t *testing.T) {
+	ctx := context.Background()
+	strong := metastorage.WithConsistency(ctx, metastorage.ConsistencyStrong)
+	rec := metrics.NewMemory()
+	inner := memory.New()
+	b := New(inner, WithWatch(false), WithTTL(time.Hour), options.WithMetrics(rec))
+	defer b.Close()
+	if _, ok := metastorage.As[*Backend](b); !ok {
+		t.Fatal("cache not reachable with As")
+	}
+	if _, ok := metastorage.As[metastorage.StateCounterBackend](b); !ok {
+		t.Fatal("counts not reachable with As")
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if err := b.StoreMeta(ctx, id, metastorage.MessageMetadata{ID: id, State: metastorage.StateDeferred}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := b.GetMeta(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// changed behind the cache's back
+		m.Attempts = 3
+		if err := inner.UpdateMeta(ctx, id, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := b.ListMessages(ctx, metastorage.StateDeferred, metastorage.MessageListOptions{Limit: 10, Consistency: metastorage.ConsistencyStrong}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if m, err := b.GetMeta(ctx, id); err != nil || m.Attempts != 3 {
+			t.Fatalf("%s after strong listing: %d attempts, %v; want 3", id, m.Attempts, err)
+		}
+	}
+
+	m, err := inner.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Attempts = 4
+	if err := inner.UpdateMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+	iter, err := b.NewMessageIterator(strong, metastorage.StateDeferred, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, ok, err := iter.Next(strong)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+	}
+	iter.Close()
+	if m, err := b.GetMeta(ctx, "m1"); err != nil || m.Attempts != 4 {
+		t.Fatalf("m1 after strong iteration: %d attempts, %v; want 4", m.Attempts, err)
+	}
+	if n := rec.CounterValue(MetricStale, metrics.Labels{"source": "list"}); n != 1 {
+		t.Errorf("stale on iteration: %v, want 1", n)
+	}
+}
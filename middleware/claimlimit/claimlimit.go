@@ -0,0 +1,196 @@
+// Package claimlimit caps the number of messages claimed at the same time
+// per source state and per group, so a flood in one state or for one
+// group (e.g. one recipient domain) cannot take all worker capacity.
+//
+// Current claims are counted from the active state before every claim:
+// active messages whose lease has not expired count against the state
+// they were claimed from (metastorage.HeaderClaimedFrom) and against their
+// group. Claims through one wrapper are serialized; claimers on other
+// nodes may briefly exceed a limit by the size of their concurrent batches.
+package claimlimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricLimited counts claim calls that were cut short by a limit, labeled
+// by limit (state, group)
+const MetricLimited = "metastorage_claims_limited_total"
+
+// claimOverscan matches the candidates per requested message the generic
+// metastorage.ClaimBatch reads, as some are skipped for their group
+const claimOverscan = 4
+
+type (
+	stateLimitKey  struct{ state metastorage.QueueState }
+	groupHeaderKey struct{}
+	groupLimitKey  struct{}
+)
+
+// WithStateLimit limits the simultaneous claims from state to n. It can
+// be given once per state.
+func WithStateLimit(state metastorage.QueueState, n int) options.Option {
+	return options.WithValue(stateLimitKey{state}, n)
+}
+
+// WithGroupHeader sets the header whose value groups messages for
+// WithGroupLimit
+func WithGroupHeader(header string) options.Option {
+	return options.WithValue(groupHeaderKey{}, header)
+}
+
+// WithGroupLimit limits the simultaneous claims per group to n, across
+// all states. Messages without the group header are not limited.
+func WithGroupLimit(n int) options.Option {
+	return options.WithValue(groupLimitKey{}, n)
+}
+
+// Backend enforces claim limits on metastorage.ClaimBatch
+type Backend struct {
+	metastorage.Backend
+	stateLimits map[metastorage.QueueState]int
+	groupHeader string
+	groupLimit  int
+	clock       clock.Clock
+	metrics     metrics.Recorder
+
+	mu sync.Mutex // serializes claims, so counts stay valid while claiming
+}
+
+// New wraps backend with claim limits
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	b := &Backend{
+		Backend:     backend,
+		stateLimits: make(map[metastorage.QueueState]int),
+		groupHeader: options.ValueOr(o, groupHeaderKey{}, ""),
+		groupLimit:  options.ValueOr(o, groupLimitKey{}, 0),
+		clock:       o.Clock,
+		metrics:     o.Metrics,
+	}
+	for _, s := range metastorage.States() {
+		if n, ok := options.Value[int](o, stateLimitKey{s}); ok {
+			b.stateLimits[s] = n
+		}
+	}
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// usage is the number of current claims per source state and group
+type usage struct {
+	states map[metastorage.QueueState]int
+	groups map[string]int
+}
+
+// usage counts the unexpired claims in the active state
+func (b *Backend) usage(ctx context.Context) (usage, error) {
+	u := usage{states: make(map[metastorage.QueueState]int), groups: make(map[string]int)}
+	iter, err := b.Backend.NewMessageIterator(ctx, metastorage.StateActive, 100)
+	if err != nil {
+		return u, err
+	}
+	defer iter.Close()
+
+	now := b.clock.Now()
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return u, err
+		}
+		if !more {
+			return u, nil
+		}
+		if _, expires, ok := metastorage.LeaseOf(m); !ok || (!expires.IsZero() && !now.Before(expires)) {
+			continue
+		}
+		if from, err := metastorage.ParseQueueState(m.Headers[metastorage.HeaderClaimedFrom]); err == nil {
+			u.states[from]++
+		}
+		if g, ok := m.Headers[b.groupHeader]; ok && b.groupHeader != "" {
+			u.groups[g]++
+		}
+	}
+}
+
+// ClaimBatch claims up to n due messages of state like
+// metastorage.ClaimBatch, but never more than the configured limits allow
+func (b *Backend) ClaimBatch(ctx context.Context, state metastorage.QueueState, workerID string, n int, lease time.Duration) ([]metastorage.MessageMetadata, error) {
+	groups := b.groupHeader != "" && b.groupLimit > 0
+	limit, limited := b.stateLimits[state]
+	if (!limited && !groups) || n <= 0 || state == metastorage.StateActive {
+		return metastorage.ClaimBatch(ctx, b.Backend, state, workerID, n, lease)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if limited && u.states[state]+n > limit {
+		n = max(limit-u.states[state], 0)
+		b.metrics.Counter(MetricLimited, metrics.Labels{"limit": "state"}, 1)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if !groups {
+		return metastorage.ClaimBatch(ctx, b.Backend, state, workerID, n, lease)
+	}
+
+	candidates, err := metastorage.DueMessages(ctx, b.Backend, state, b.clock.Now(), n*claimOverscan)
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]metastorage.MessageMetadata, 0, n)
+	skipped := false
+	for _, c := range candidates {
+		if len(claimed) == n {
+			break
+		}
+		g, grouped := c.Headers[b.groupHeader]
+		if grouped && u.groups[g] >= b.groupLimit {
+			skipped = true
+			continue
+		}
+		m, err := metastorage.Claim(ctx, b.Backend, c.ID, state, workerID, lease)
+		if errors.Is(err, metastorage.ErrStateConflict) || errors.Is(err, metastorage.ErrMessageNotFound) {
+			continue // another worker was faster
+		}
+		if err != nil {
+			return claimed, err
+		}
+		if grouped {
+			u.groups[g]++
+		}
+		claimed = append(claimed, m)
+	}
+	if skipped {
+		b.metrics.Counter(MetricLimited, metrics.Labels{"limit": "group"}, 1)
+	}
+	return claimed, nil
+}
@@ -0,0 +1,149 @@
+// Package defaults provides a decorator that completes and validates
+// metadata on StoreMeta, so producers only set what is specific to a
+// message:
+//
+//   - Created is set to the current time and Updated to Created if zero
+//   - MaxAttempts, Priority and RetryPolicyName are taken from the
+//     template if zero
+//   - template headers are added unless the message sets them
+//
+// The completed metadata is then validated: required headers must be
+// present, the delivery window must be valid and the WithValidator
+// function must accept it. Invalid metadata is not stored.
+//
+// Producers that leave Priority 0 on purpose get the template priority;
+// use a template priority of 0 to keep them unchanged.
+package defaults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// ErrInvalid is wrapped by the errors of metadata rejected by validation
+var ErrInvalid = errors.New("invalid metadata")
+
+type (
+	templateKey        struct{}
+	requiredHeadersKey struct{}
+	validatorKey       struct{}
+)
+
+// WithTemplate sets the metadata whose MaxAttempts, Priority,
+// RetryPolicyName and Headers fill in unset fields
+func WithTemplate(t metastorage.MessageMetadata) options.Option {
+	return options.WithValue(templateKey{}, t)
+}
+
+// WithRequiredHeaders rejects metadata without any of headers, after the
+// template headers were added
+func WithRequiredHeaders(headers ...string) options.Option {
+	return options.WithValue(requiredHeadersKey{}, headers)
+}
+
+// WithValidator sets an additional check of the completed metadata
+func WithValidator(fn func(metastorage.MessageMetadata) error) options.Option {
+	return options.WithValue(validatorKey{}, fn)
+}
+
+// Backend completes and validates metadata on StoreMeta
+type Backend struct {
+	metastorage.Backend
+	template  metastorage.MessageMetadata
+	required  []string
+	validator func(metastorage.MessageMetadata) error
+	clock     clock.Clock
+}
+
+// New wraps backend with metadata defaults
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	return &Backend{
+		Backend:   backend,
+		template:  options.ValueOr(o, templateKey{}, metastorage.MessageMetadata{}),
+		required:  options.ValueOr[[]string](o, requiredHeadersKey{}, nil),
+		validator: options.ValueOr[func(metastorage.MessageMetadata) error](o, validatorKey{}, nil),
+		clock:     o.Clock,
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// Complete returns m with the defaults applied
+func (b *Backend) Complete(m metastorage.MessageMetadata) metastorage.MessageMetadata {
+	if m.Created.IsZero() {
+		m.Created = b.clock.Now().UTC()
+	}
+	if m.Updated.IsZero() {
+		m.Updated = m.Created
+	}
+	if m.MaxAttempts == 0 {
+		m.MaxAttempts = b.template.MaxAttempts
+	}
+	if m.Priority == 0 {
+		m.Priority = b.template.Priority
+	}
+	if m.RetryPolicyName == "" {
+		m.RetryPolicyName = b.template.RetryPolicyName
+	}
+	if len(b.template.Headers) > 0 {
+		headers := make(map[string]string, len(m.Headers)+len(b.template.Headers))
+		for k, v := range b.template.Headers {
+			headers[k] = v
+		}
+		for k, v := range m.Headers {
+			headers[k] = v
+		}
+		m.Headers = headers
+	}
+	return m
+}
+
+// Validate checks completed metadata
+func (b *Backend) Validate(m metastorage.MessageMetadata) error {
+	for _, h := range b.required {
+		if _, ok := m.Headers[h]; !ok {
+			return fmt.Errorf("%w: required header %q missing", ErrInvalid, h)
+		}
+	}
+	if m.MaxAttempts < 0 {
+		return fmt.Errorf("%w: negative MaxAttempts %d", ErrInvalid, m.MaxAttempts)
+	}
+	if err := m.DeliveryWindow.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if b.validator != nil {
+		if err := b.validator(m); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+// StoreMeta stores message metadata after completing and validating it
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata = b.Complete(metadata)
+	if err := b.Validate(metadata); err != nil {
+		return fmt.Errorf("%s: %w", messageID, err)
+	}
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
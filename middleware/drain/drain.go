@@ -0,0 +1,59 @@
+// Package drain provides a decorator implementing
+// metastorage.DrainBackend: while draining, storing new incoming messages
+// fails with metastorage.ErrDraining. Messages already in the spool are
+// processed as usual, so metastorage.Drain can wait for them to empty
+// before the node is shut down.
+//
+// The flag is held in process memory and only affects producers using
+// this wrapper.
+package drain
+
+import (
+	"context"
+	"sync/atomic"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Backend refuses new incoming messages while draining
+type Backend struct {
+	metastorage.Backend
+	draining atomic.Bool
+}
+
+// New wraps backend with a draining flag
+func New(backend metastorage.Backend, _ ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, &Backend{Backend: backend})
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(_ ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return &Backend{Backend: b}
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// SetDraining sets the draining flag
+func (b *Backend) SetDraining(draining bool) {
+	b.draining.Store(draining)
+}
+
+// Draining reports whether the flag is set
+func (b *Backend) Draining() bool {
+	return b.draining.Load()
+}
+
+// StoreMeta stores message metadata. New incoming messages are refused
+// with metastorage.ErrDraining while draining.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if metadata.State == metastorage.StateIncoming && b.Draining() {
+		return metastorage.ErrDraining
+	}
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
@@ -0,0 +1,68 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// counted counts stored messages per state; other Backend methods are
+// not used
+type counted struct {
+	metastorage.Backend
+	counts map[metastorage.QueueState]int64
+}
+
+func (c *counted) StoreMeta(_ context.Context, _ string, m metastorage.MessageMetadata) error {
+	c.counts[m.State]++
+	return nil
+}
+
+func (c *counted) GetStateCount(state metastorage.QueueState) int64 {
+	return c.counts[state]
+}
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	inner := &counted{counts: map[metastorage.QueueState]int64{metastorage.StateActive: 1}}
+	b := New(inner)
+	if metastorage.IsDraining(b) {
+		t.Fatal("draining before Drain")
+	}
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := metastorage.DrainOptions{Timeout: 50 * time.Millisecond, PollInterval: 5 * time.Millisecond}
+	report, err := metastorage.Drain(ctx, b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Drained || !report.Refusing || report.Remaining[metastorage.StateActive] != 1 {
+		t.Fatalf("report = %+v, want one active message remaining", report)
+	}
+	if err := b.StoreMeta(ctx, "m2", metastorage.MessageMetadata{ID: "m2", State: metastorage.StateIncoming}); !errors.Is(err, metastorage.ErrDraining) {
+		t.Fatalf("incoming while draining: %v, want ErrDraining", err)
+	}
+	if err := b.StoreMeta(ctx, "m3", metastorage.MessageMetadata{ID: "m3", State: metastorage.StateDeferred}); err != nil {
+		t.Fatalf("deferred while draining: %v", err)
+	}
+
+	inner.counts[metastorage.StateActive] = 0
+	inner.counts[metastorage.StateDeferred] = 0
+	if report, err := metastorage.Drain(ctx, b, opts); err != nil || !report.Drained {
+		t.Fatalf("report = %+v, %v; want drained", report, err)
+	}
+
+	d, ok := metastorage.As[metastorage.DrainBackend](b)
+	if !ok {
+		t.Fatal("DrainBackend not reachable with As")
+	}
+	d.SetDraining(false)
+	if err := b.StoreMeta(ctx, "m4", metastorage.MessageMetadata{ID: "m4", State: metastorage.StateIncoming}); err != nil {
+		t.Fatalf("incoming after resume: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build go1.24
+
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// newAEAD returns AES-GCM with random nonces prepended to the sealed
+// data, the mode approved in FIPS 140-3 mode
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithRandomNonce(block)
+}
@@ -0,0 +1,55 @@
+//go:build !go1.24
+
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// newAEAD returns AES-GCM with random nonces prepended to the sealed
+// data, the format cipher.NewGCMWithRandomNonce writes since Go 1.24
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return randomNonce{gcm}, nil
+}
+
+// randomNonce generates the nonce of every Seal; callers pass none
+type randomNonce struct {
+	gcm cipher.AEAD
+}
+
+func (a randomNonce) NonceSize() int { return 0 }
+
+func (a randomNonce) Overhead() int { return a.gcm.NonceSize() + a.gcm.Overhead() }
+
+func (a randomNonce) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != 0 {
+		panic("encrypt: nonce is generated by Seal")
+	}
+	nonce = make([]byte, a.gcm.NonceSize(), a.gcm.NonceSize()+len(plaintext)+a.gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("encrypt: " + err.Error())
+	}
+	return append(dst, a.gcm.Seal(nonce, nonce, plaintext, additionalData)...)
+}
+
+func (a randomNonce) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 0 {
+		panic("encrypt: nonce is read from the ciphertext")
+	}
+	n := a.gcm.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("cipher: message authentication failed")
+	}
+	return a.gcm.Open(dst, ciphertext[:n], ciphertext[n:], additionalData)
+}
@@ -0,0 +1,323 @@
+// Package encrypt provides a decorator that encrypts the free-form fields
+// of messages at rest: header values and LastError, which may carry
+// personal data such as addresses or remote server replies. Fields the
+// queue logic works with (state, timestamps, attempts, priority) stay
+// readable, so the backend can still index and order messages.
+//
+// Values are sealed with AES-256-GCM under the current key of the
+// namespace (options.WithNamespace) from a Keyring, so tenants in
+// separate compliance domains can use separate keys. Each value records
+// the ID of its key and is bound to its message ID and field, so it
+// cannot be copied to another message or header unnoticed. Rotate
+// re-encrypts existing data after the current key changed.
+//
+// Reserved headers (metastorage.ReservedHeaderPrefix) are left in plain
+// text: claim leases and pins are read and written by backends natively.
+// The decorator belongs innermost, next to the backend, so all other
+// layers see plain text.
+package encrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// prefix marks encrypted values: "enc1:<key ID>:<base64 nonce|ciphertext>"
+const prefix = "enc1:"
+
+// ErrDecrypt is returned for values that cannot be decrypted with the
+// key they name, e.g. because they were tampered with
+var ErrDecrypt = errors.New("cannot decrypt value")
+
+type plainHeadersKey struct{}
+
+// WithPlainHeaders keeps the given headers unencrypted, e.g. headers
+// other layers select or group messages by
+func WithPlainHeaders(names ...string) options.Option {
+	return options.WithValue(plainHeadersKey{}, names)
+}
+
+// Backend encrypts header values and LastError of the messages it stores
+type Backend struct {
+	metastorage.Backend
+	keys      Keyring
+	namespace string
+	plain     map[string]bool
+}
+
+// New wraps backend with encryption under the keys of keys
+func New(backend metastorage.Backend, keys Keyring, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, keys, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(keys Keyring, opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, keys, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, keys Keyring, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	b := &Backend{
+		Backend:   backend,
+		keys:      keys,
+		namespace: o.Namespace,
+		plain:     make(map[string]bool),
+	}
+	for _, name := range options.ValueOr(o, plainHeadersKey{}, []string(nil)) {
+		b.plain[name] = true
+	}
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// StoreMeta encrypts and stores metadata
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	m, err := b.seal(ctx, messageID, metadata)
+	if err != nil {
+		return err
+	}
+	return b.Backend.StoreMeta(ctx, messageID, m)
+}
+
+// UpdateMeta encrypts and updates metadata
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	m, err := b.seal(ctx, messageID, metadata)
+	if err != nil {
+		return err
+	}
+	return b.Backend.UpdateMeta(ctx, messageID, m)
+}
+
+// GetMeta retrieves and decrypts metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	if err != nil {
+		return m, err
+	}
+	return b.open(ctx, m)
+}
+
+// NewMessageIterator returns an iterator decrypting the messages of the
+// wrapped backend's iterator
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	iter, err := b.Backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{MessageIterator: iter, b: b}, nil
+}
+
+// ClaimBatch claims through the wrapped backend, see
+// metastorage.ClaimBatch, and decrypts the claimed messages
+func (b *Backend) ClaimBatch(ctx context.Context, state metastorage.QueueState, workerID string, n int, lease time.Duration) ([]metastorage.MessageMetadata, error) {
+	claimed, err := metastorage.ClaimBatch(ctx, b.Backend, state, workerID, n, lease)
+	if err != nil {
+		return claimed, err
+	}
+	return b.openAll(ctx, claimed)
+}
+
+// GetMetaMulti retrieves and decrypts several messages, see
+// metastorage.GetMetaMulti
+func (b *Backend) GetMetaMulti(ctx context.Context, ids []string) (map[string]metastorage.MessageMetadata, []string, error) {
+	found, missing, err := metastorage.GetMetaMulti(ctx, b.Backend, ids)
+	if err != nil {
+		return found, missing, err
+	}
+	for id, m := range found {
+		if found[id], err = b.open(ctx, m); err != nil {
+			return nil, nil, err
+		}
+	}
+	return found, missing, nil
+}
+
+// StoreMetaBatch encrypts the messages and stores them through the
+// wrapped backend, see metastorage.StoreMetaBatch. Messages failing to
+// encrypt are not stored.
+func (b *Backend) StoreMetaBatch(ctx context.Context, messages []metastorage.MessageMetadata) []error {
+	errs := make([]error, len(messages))
+	sealed := make([]metastorage.MessageMetadata, 0, len(messages))
+	index := make([]int, 0, len(messages)) // position of sealed[i] in messages
+	for i, m := range messages {
+		s, err := b.seal(ctx, m.ID, m)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		sealed = append(sealed, s)
+		index = append(index, i)
+	}
+	for i, err := range metastorage.StoreMetaBatch(ctx, b.Backend, sealed) {
+		errs[index[i]] = err
+	}
+	return errs
+}
+
+// GetMetaBatch retrieves and decrypts several messages, see
+// metastorage.GetMetaBatch
+func (b *Backend) GetMetaBatch(ctx context.Context, ids []string) ([]metastorage.MessageMetadata, []error) {
+	ms, errs := metastorage.GetMetaBatch(ctx, b.Backend, ids)
+	for i := range ms {
+		if errs[i] == nil {
+			ms[i], errs[i] = b.open(ctx, ms[i])
+		}
+	}
+	return ms, errs
+}
+
+// DeleteMetaBatch deletes through the wrapped backend, see
+// metastorage.DeleteMetaBatch
+func (b *Backend) DeleteMetaBatch(ctx context.Context, ids []string) []error {
+	return metastorage.DeleteMetaBatch(ctx, b.Backend, ids)
+}
+
+// SampleMessages samples through the wrapped backend, see
+// metastorage.SampleMessages, and decrypts the sample
+func (b *Backend) SampleMessages(ctx context.Context, state metastorage.QueueState, n int) ([]metastorage.MessageMetadata, error) {
+	sample, err := metastorage.SampleMessages(ctx, b.Backend, state, n)
+	if err != nil {
+		return sample, err
+	}
+	return b.openAll(ctx, sample)
+}
+
+type iterator struct {
+	metastorage.MessageIterator
+	b *Backend
+}
+
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	m, more, err := it.MessageIterator.Next(ctx)
+	if err != nil || !more {
+		return m, more, err
+	}
+	m, err = it.b.open(ctx, m)
+	return m, err == nil, err
+}
+
+// encrypted reports whether the header key is stored encrypted
+func (b *Backend) encrypted(key string) bool {
+	return !strings.HasPrefix(key, metastorage.ReservedHeaderPrefix) && !b.plain[key]
+}
+
+// seal returns m with its header values and LastError encrypted under
+// the current key. Every value is encrypted, including values that look
+// encrypted, as they come from the caller.
+func (b *Backend) seal(ctx context.Context, id string, m metastorage.MessageMetadata) (metastorage.MessageMetadata, error) {
+	keyID, key, err := b.keys.Current(ctx, b.namespace)
+	if err != nil {
+		return m, err
+	}
+	aead, err := newAEAD(key)
+	clear(key)
+	if err != nil {
+		return m, err
+	}
+	seal := func(field, v string) string {
+		if v == "" {
+			return v
+		}
+		sealed := aead.Seal(nil, nil, []byte(v), additionalData(id, field))
+		return prefix + keyID + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+	}
+
+	m.LastError = seal("last_error", m.LastError)
+	if len(m.Headers) > 0 {
+		headers := make(map[string]string, len(m.Headers))
+		for k, v := range m.Headers {
+			if b.encrypted(k) {
+				v = seal("header:"+k, v)
+			}
+			headers[k] = v
+		}
+		m.Headers = headers
+	}
+	return m, nil
+}
+
+// open returns m with its encrypted values decrypted
+func (b *Backend) open(ctx context.Context, m metastorage.MessageMetadata) (metastorage.MessageMetadata, error) {
+	var err error
+	if m.LastError, err = b.openValue(ctx, m.ID, "last_error", m.LastError); err != nil {
+		return m, err
+	}
+	cloned := false
+	for k, v := range m.Headers {
+		if !b.encrypted(k) || !strings.HasPrefix(v, prefix) {
+			continue
+		}
+		if !cloned { // the map may be shared with the wrapped backend
+			m.Headers = maps.Clone(m.Headers)
+			cloned = true
+		}
+		if m.Headers[k], err = b.openValue(ctx, m.ID, "header:"+k, v); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+func (b *Backend) openAll(ctx context.Context, messages []metastorage.MessageMetadata) ([]metastorage.MessageMetadata, error) {
+	for i, m := range messages {
+		var err error
+		if messages[i], err = b.open(ctx, m); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// openValue decrypts v if it is encrypted
+func (b *Backend) openValue(ctx context.Context, id, field, v string) (string, error) {
+	keyID, data, ok := splitValue(v)
+	if !ok {
+		return v, nil
+	}
+	key, err := b.keys.Key(ctx, keyID)
+	if err != nil {
+		return "", fmt.Errorf("message %s %s: %w", id, field, err)
+	}
+	aead, err := newAEAD(key)
+	clear(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("message %s %s: %w", id, field, ErrDecrypt)
+	}
+	plain, err := aead.Open(nil, nil, sealed, additionalData(id, field))
+	if err != nil {
+		return "", fmt.Errorf("message %s %s: %w", id, field, ErrDecrypt)
+	}
+	return string(plain), nil
+}
+
+// splitValue returns the key ID and payload of an encrypted value
+func splitValue(v string) (keyID, data string, ok bool) {
+	rest, ok := strings.CutPrefix(v, prefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// additionalData binds a value to its message and field
+func additionalData(id, field string) []byte {
+	return []byte(id + "\x00" + field)
+}
@@ -0,0 +1,97 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func testKeyring(t *testing.T, ids ...string) *StaticKeyring {
+	t.Helper()
+	keys := NewStaticKeyring()
+	for i, id := range ids {
+		if err := keys.Add(id, bytes.Repeat([]byte{byte(i + 1)}, KeySize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := keys.SetCurrent("", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestValuesLookingEncryptedAreEncrypted(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	b := New(inner, testKeyring(t, "k1"))
+	value := prefix + "k1:bm90IGEgY2lwaGVydGV4dA"
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, LastError: value, Headers: map[string]string{"subject": value}}
+	if err := b.StoreMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := inner.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Headers["subject"] == value || raw.LastError == value {
+		t.Fatal("caller value stored as is")
+	}
+	got, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Headers["subject"] != value || got.LastError != value {
+		t.Fatalf("got %q, %q; want the caller's values back", got.Headers["subject"], got.LastError)
+	}
+}
+
+// mover moves the message to StateDeferred right before the first update
+// reaches it, like a worker claiming it during rotation
+type mover struct {
+	metastorage.Backend
+	moved bool
+}
+
+func (m *mover) UpdateMeta(ctx context.Context, id string, meta metastorage.MessageMetadata) error {
+	if !m.moved {
+		m.moved = true
+		if err := m.Backend.MoveToState(ctx, id, meta.State, metastorage.StateDeferred); err != nil {
+			return err
+		}
+	}
+	return m.Backend.UpdateMeta(ctx, id, meta)
+}
+
+func TestRotateRetriesMessagesMovedDuringRotation(t *testing.T) {
+	ctx := context.Background()
+	keys := testKeyring(t, "k1", "k2")
+	inner := &mover{Backend: memory.New()}
+	b := New(inner, keys)
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, Headers: map[string]string{"subject": "hello"}}
+	if err := b.StoreMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SetCurrent("", "k2"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Rotate(ctx, b, RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rotated != 1 {
+		t.Fatalf("rotated %d, want 1", stats.Rotated)
+	}
+	raw, err := inner.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw.Headers["subject"], prefix+"k2:") {
+		t.Fatalf("subject %q not under the new key", raw.Headers["subject"])
+	}
+}
@@ -0,0 +1,181 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the size of the AES-256 keys
+const KeySize = 32
+
+// ErrUnknownKey is returned for key IDs the keyring does not hold
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring provides the data keys. New data is encrypted with the current
+// key of its namespace; each encrypted value records the ID of its key,
+// so values of old keys stay readable as long as the keyring holds them.
+// Keys are returned as copies the caller clears after use.
+type Keyring interface {
+	// Current returns the ID and key new data of namespace is encrypted
+	// with. Namespaces without a key of their own use the key of "".
+	Current(ctx context.Context, namespace string) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeyring is an in-memory Keyring. It is safe for concurrent use,
+// so keys can be rotated while the backend is running.
+type StaticKeyring struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current map[string]string // namespace -> key ID
+}
+
+// NewStaticKeyring returns an empty keyring
+func NewStaticKeyring() *StaticKeyring {
+	return &StaticKeyring{
+		keys:    make(map[string][]byte),
+		current: make(map[string]string),
+	}
+}
+
+// Add adds a KeySize key. IDs must not contain ':'.
+func (k *StaticKeyring) Add(id string, key []byte) error {
+	if err := checkKeyID(id); err != nil {
+		return err
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("key %q: %d bytes, want %d", id, len(key), KeySize)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// checkKeyID rejects IDs that cannot be recorded in encrypted values
+func checkKeyID(id string) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	return nil
+}
+
+// SetCurrent makes the key with id the current key of namespace; ""
+// sets the default for all namespaces without a key of their own
+func (k *StaticKeyring) SetCurrent(namespace, id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	k.current[namespace] = id
+	return nil
+}
+
+// Current returns the current key of namespace
+func (k *StaticKeyring) Current(_ context.Context, namespace string) (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	id, ok := k.current[namespace]
+	if !ok {
+		if id, ok = k.current[""]; !ok {
+			return "", nil, fmt.Errorf("%w: no current key for namespace %q", ErrUnknownKey, namespace)
+		}
+	}
+	return id, bytes.Clone(k.keys[id]), nil
+}
+
+// Key returns the key with id
+func (k *StaticKeyring) Key(_ context.Context, id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return bytes.Clone(key), nil
+}
+
+// keyFile is the JSON form of a keyring file
+type keyFile struct {
+	Service  string            `json:"service,omitempty"`   // key service URI wrapping the keys
+	CacheTTL string            `json:"cache_ttl,omitempty"` // with a service: how long unwrapped keys are kept
+	Keys     map[string]string `json:"keys"`                // key ID -> base64 key
+	Current  map[string]string `json:"current"`             // namespace -> key ID
+}
+
+// LoadKeyring reads a keyring file of the form
+//
+//	{
+//	  "keys":    {"2026-01": "<base64>", "tenant-a-1": "<base64>"},
+//	  "current": {"": "2026-01", "tenant-a": "tenant-a-1"}
+//	}
+//
+// With a "service" URI, e.g. "awskms://alias/retryspool", the keys are
+// data keys wrapped by that key service (see GenerateKey) and an
+// EnvelopeKeyring is returned; "cache_ttl" overrides DefaultKeyCacheTTL.
+// The key service scheme must be registered by importing its package.
+//
+// Rotating a key means adding the new key, pointing "current" at it and,
+// once Rotate has re-encrypted the data, removing the old key.
+func LoadKeyring(ctx context.Context, path string) (Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode keyring %s: %w", path, err)
+	}
+
+	var (
+		add        func(id string, key []byte) error
+		setCurrent func(namespace, id string) error
+		keyring    Keyring
+	)
+	if f.Service == "" {
+		k := NewStaticKeyring()
+		add, setCurrent, keyring = k.Add, k.SetCurrent, k
+	} else {
+		ttl := DefaultKeyCacheTTL
+		if f.CacheTTL != "" {
+			if ttl, err = time.ParseDuration(f.CacheTTL); err != nil {
+				return nil, fmt.Errorf("keyring %s: cache_ttl: %w", path, err)
+			}
+		}
+		service, err := OpenKeyService(ctx, f.Service)
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: %w", path, err)
+		}
+		k := NewEnvelopeKeyring(service, ttl)
+		add, setCurrent, keyring = k.AddWrapped, k.SetCurrent, k
+	}
+
+	for id, s := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: key %q: %w", path, id, err)
+		}
+		err = add(id, key)
+		clear(key)
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: %w", path, err)
+		}
+	}
+	for namespace, id := range f.Current {
+		if err := setCurrent(namespace, id); err != nil {
+			return nil, fmt.Errorf("keyring %s: %w", path, err)
+		}
+	}
+	return keyring, nil
+}
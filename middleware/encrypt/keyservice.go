@@ -0,0 +1,215 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultKeyCacheTTL is how long an EnvelopeKeyring keeps unwrapped keys
+const DefaultKeyCacheTTL = 5 * time.Minute
+
+// ErrUnknownKeyService is returned for key service URIs of unregistered
+// schemes
+var ErrUnknownKeyService = errors.New("unknown key service")
+
+// KeyService wraps and unwraps data keys with a master key that never
+// leaves the service, e.g. a cloud KMS or Vault transit
+type KeyService interface {
+	// WrapKey encrypts a data key
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key wrapped by WrapKey
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// KeyServiceOpener creates a key service from a parsed URI
+type KeyServiceOpener func(ctx context.Context, uri *url.URL) (KeyService, error)
+
+var (
+	keyServicesMu sync.RWMutex
+	keyServices   = make(map[string]KeyServiceOpener)
+)
+
+// RegisterKeyService makes a key service available under scheme. It
+// panics if opener is nil or the scheme is registered twice.
+func RegisterKeyService(scheme string, opener KeyServiceOpener) {
+	keyServicesMu.Lock()
+	defer keyServicesMu.Unlock()
+	if opener == nil {
+		panic("encrypt: RegisterKeyService opener is nil")
+	}
+	if _, dup := keyServices[scheme]; dup {
+		panic("encrypt: RegisterKeyService called twice for scheme " + scheme)
+	}
+	keyServices[scheme] = opener
+}
+
+// KeyServices returns the sorted list of registered schemes
+func KeyServices() []string {
+	keyServicesMu.RLock()
+	defer keyServicesMu.RUnlock()
+	schemes := make([]string, 0, len(keyServices))
+	for scheme := range keyServices {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// OpenKeyService opens the key service identified by uri, e.g.
+// "awskms://alias/retryspool" or "vault://transit/retryspool"
+func OpenKeyService(ctx context.Context, uri string) (KeyService, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse key service URI: %w", err)
+	}
+	keyServicesMu.RLock()
+	opener, ok := keyServices[u.Scheme]
+	keyServicesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKeyService, u.Scheme)
+	}
+	return opener(ctx, u)
+}
+
+// GenerateKey returns a new random data key wrapped by service. The
+// plain key only exists for the duration of the call.
+func GenerateKey(ctx context.Context, service KeyService) ([]byte, error) {
+	key := make([]byte, KeySize)
+	defer clear(key)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return service.WrapKey(ctx, key)
+}
+
+// EnvelopeKeyring is a Keyring of data keys wrapped by a KeyService.
+// Keys are unwrapped on first use and kept for a limited time only, then
+// cleared from memory, so a memory dump exposes at most the keys used
+// recently. It is safe for concurrent use.
+type EnvelopeKeyring struct {
+	service KeyService
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	wrapped map[string][]byte
+	current map[string]string // namespace -> key ID
+	cache   map[string]*cachedKey
+}
+
+type cachedKey struct {
+	key   []byte
+	timer *time.Timer
+}
+
+// NewEnvelopeKeyring returns an empty keyring unwrapping keys with
+// service and caching them for ttl; ttl <= 0 unwraps on every use
+func NewEnvelopeKeyring(service KeyService, ttl time.Duration) *EnvelopeKeyring {
+	return &EnvelopeKeyring{
+		service: service,
+		ttl:     ttl,
+		wrapped: make(map[string][]byte),
+		current: make(map[string]string),
+		cache:   make(map[string]*cachedKey),
+	}
+}
+
+// AddWrapped adds a data key wrapped by the key service. IDs must not
+// contain ':'.
+func (k *EnvelopeKeyring) AddWrapped(id string, wrapped []byte) error {
+	if err := checkKeyID(id); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.wrapped[id] = bytes.Clone(wrapped)
+	return nil
+}
+
+// SetCurrent makes the key with id the current key of namespace; ""
+// sets the default for all namespaces without a key of their own
+func (k *EnvelopeKeyring) SetCurrent(namespace, id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.wrapped[id]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	k.current[namespace] = id
+	return nil
+}
+
+// Current returns the current key of namespace
+func (k *EnvelopeKeyring) Current(ctx context.Context, namespace string) (string, []byte, error) {
+	k.mu.Lock()
+	id, ok := k.current[namespace]
+	if !ok {
+		id, ok = k.current[""]
+	}
+	k.mu.Unlock()
+	if !ok {
+		return "", nil, fmt.Errorf("%w: no current key for namespace %q", ErrUnknownKey, namespace)
+	}
+	key, err := k.Key(ctx, id)
+	return id, key, err
+}
+
+// Key returns the key with id, unwrapping it if it is not cached
+func (k *EnvelopeKeyring) Key(ctx context.Context, id string) ([]byte, error) {
+	k.mu.Lock()
+	if c, ok := k.cache[id]; ok {
+		key := bytes.Clone(c.key)
+		k.mu.Unlock()
+		return key, nil
+	}
+	wrapped, ok := k.wrapped[id]
+	k.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	key, err := k.service.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key %q: %w", id, err)
+	}
+	if len(key) != KeySize {
+		clear(key)
+		return nil, fmt.Errorf("unwrap key %q: %d bytes, want %d", id, len(key), KeySize)
+	}
+	if k.ttl > 0 {
+		k.mu.Lock()
+		if _, ok := k.cache[id]; !ok { // unwrapped concurrently otherwise
+			c := &cachedKey{key: bytes.Clone(key)}
+			c.timer = time.AfterFunc(k.ttl, func() { k.evict(id, c) })
+			k.cache[id] = c
+		}
+		k.mu.Unlock()
+	}
+	return key, nil
+}
+
+func (k *EnvelopeKeyring) evict(id string, c *cachedKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.cache[id] == c {
+		delete(k.cache, id)
+	}
+	clear(c.key)
+}
+
+// Purge clears all cached keys, e.g. on shutdown
+func (k *EnvelopeKeyring) Purge() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, c := range k.cache {
+		c.timer.Stop()
+		clear(c.key)
+		delete(k.cache, id)
+	}
+}
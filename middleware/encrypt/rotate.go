@@ -0,0 +1,245 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// DefaultProgressEvery is the default number of scanned messages between
+// progress reports of Rotate
+const DefaultProgressEvery = 1000
+
+// RotateOptions configures Rotate
+type RotateOptions struct {
+	States        []metastorage.QueueState // States to rotate, default all
+	BatchSize     int                      // Iterator batch size hint
+	Checkpoint    string                   // File recording completed states, so an interrupted run resumes
+	Progress      func(RotateProgress)     // Called every ProgressEvery messages and after each state
+	ProgressEvery int                      // Default DefaultProgressEvery
+}
+
+// RotateProgress reports the progress of Rotate
+type RotateProgress struct {
+	State   metastorage.QueueState // State being scanned
+	Done    bool                   // State is complete
+	Scanned int                    // Messages scanned in State so far
+	Rotated int                    // Messages re-encrypted in State so far
+}
+
+// RotateStats summarizes a Rotate run
+type RotateStats struct {
+	Scanned  int // Messages scanned
+	Rotated  int // Messages re-encrypted
+	Vanished int // Messages deleted while being rotated
+	Resumed  int // States skipped as completed by a previous run
+}
+
+// rotateCheckpoint is the JSON form of a Rotate checkpoint file
+type rotateCheckpoint struct {
+	KeyID string   `json:"key_id"` // current key of the run
+	Done  []string `json:"done"`   // completed states by label
+}
+
+// Rotate re-encrypts all messages of the encryption layer in backend
+// whose values are not encrypted with the current key of its namespace,
+// e.g. after the keyring's current key changed or to encrypt data stored
+// before the layer was added. Messages are rewritten in place through the
+// wrapped backend; plain fields are left untouched.
+//
+// Rotate is idempotent: messages already under the current key are only
+// read. With a checkpoint file completed states are recorded and skipped
+// when an interrupted run is started again; a checkpoint of another
+// current key is ignored.
+//
+// Each message is re-read right before it is rewritten, and re-read again
+// if it changed state in between, but other writes by other clients in
+// between are lost. Run Rotate at low traffic. Messages moved into an
+// already completed state during the run keep their old key until the
+// next run, so keep old keys in the keyring until a run reports nothing
+// rotated.
+func Rotate(ctx context.Context, backend metastorage.Backend, opts RotateOptions) (RotateStats, error) {
+	var stats RotateStats
+	b, ok := metastorage.As[*Backend](backend)
+	if !ok {
+		return stats, errors.New("rotate: backend has no encryption layer")
+	}
+	keyID, _, err := b.keys.Current(ctx, b.namespace)
+	if err != nil {
+		return stats, err
+	}
+	states := opts.States
+	if len(states) == 0 {
+		states = metastorage.States()
+	}
+	every := opts.ProgressEvery
+	if every <= 0 {
+		every = DefaultProgressEvery
+	}
+	report := func(p RotateProgress) {
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	cp := rotateCheckpoint{KeyID: keyID}
+	if opts.Checkpoint != "" {
+		prev, err := loadRotateCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return stats, err
+		}
+		if prev != nil && prev.KeyID == keyID {
+			cp = *prev
+		}
+	}
+	done := make(map[string]bool, len(cp.Done))
+	for _, label := range cp.Done {
+		done[label] = true
+	}
+
+	for _, state := range states {
+		if done[metastorage.StateLabel(state)] {
+			stats.Resumed++
+			continue
+		}
+		p := RotateProgress{State: state}
+		iter, err := b.Backend.NewMessageIterator(ctx, state, opts.BatchSize)
+		if err != nil {
+			return stats, err
+		}
+		for {
+			m, more, err := iter.Next(ctx)
+			if err != nil {
+				iter.Close()
+				return stats, err
+			}
+			if !more {
+				break
+			}
+			p.Scanned++
+			stats.Scanned++
+			if b.stale(m, keyID) {
+				rotated, err := b.rotate(ctx, m.ID, keyID)
+				if err != nil {
+					iter.Close()
+					return stats, err
+				}
+				if rotated {
+					p.Rotated++
+					stats.Rotated++
+				} else {
+					stats.Vanished++
+				}
+			}
+			if p.Scanned%every == 0 {
+				report(p)
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return stats, err
+		}
+		p.Done = true
+		report(p)
+
+		cp.Done = append(cp.Done, metastorage.StateLabel(state))
+		if opts.Checkpoint != "" {
+			if err := cp.save(opts.Checkpoint); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
+
+// stale reports whether m has values that are not encrypted with the key
+// keyID but should be
+func (b *Backend) stale(m metastorage.MessageMetadata, keyID string) bool {
+	staleValue := func(v string) bool {
+		if v == "" {
+			return false
+		}
+		id, _, ok := splitValue(v)
+		return !ok || id != keyID
+	}
+	if staleValue(m.LastError) {
+		return true
+	}
+	for k, v := range m.Headers {
+		if b.encrypted(k) && staleValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// rotateAttempts bounds how often a message that keeps changing state
+// during its rotation is re-read
+const rotateAttempts = 5
+
+// rotate re-encrypts the stored message id under the current key. It
+// returns false if the message no longer exists.
+func (b *Backend) rotate(ctx context.Context, id, keyID string) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		rotated, err := b.rotateOnce(ctx, id, keyID)
+		if errors.Is(err, metastorage.ErrStateConflict) && attempt < rotateAttempts {
+			continue // moved by a worker after it was read
+		}
+		return rotated, err
+	}
+}
+
+func (b *Backend) rotateOnce(ctx context.Context, id, keyID string) (bool, error) {
+	m, err := b.Backend.GetMeta(ctx, id)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !b.stale(m, keyID) {
+		return true, nil
+	}
+	if m, err = b.open(ctx, m); err != nil {
+		return false, err
+	}
+	if m, err = b.seal(ctx, id, m); err != nil {
+		return false, err
+	}
+	err = b.Backend.UpdateMeta(ctx, id, m)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func loadRotateCheckpoint(path string) (*rotateCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp rotateCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// save writes the checkpoint atomically to path
+func (cp rotateCheckpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,148 @@
+// Package vaulttransit provides an encrypt.KeyService on the transit
+// secrets engine of HashiCorp Vault: data keys are wrapped and unwrapped
+// by a transit key that never leaves Vault, and every unwrap is recorded
+// in Vault's audit log.
+//
+// Importing the package registers the "vault" key service scheme,
+// "vault://<mount>/<key>", e.g. "vault://transit/retryspool", with the
+// optional query parameter "addr". The address, token and namespace
+// otherwise come from VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE, as for
+// the vault CLI.
+package vaulttransit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"schneider.vip/retryspool/storage/meta/middleware/encrypt"
+)
+
+func init() {
+	encrypt.RegisterKeyService("vault", open)
+}
+
+// open handles "vault://transit/retryspool?addr=https://vault:8200" URIs
+func open(_ context.Context, uri *url.URL) (encrypt.KeyService, error) {
+	// mounts may be nested, the key is the last path element
+	path := strings.Trim(uri.Host+uri.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return nil, fmt.Errorf("vault: %q is no <mount>/<key>", uri.Redacted())
+	}
+	cfg := Config{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Mount:     path[:i],
+		Key:       path[i+1:],
+	}
+	if addr := uri.Query().Get("addr"); addr != "" {
+		cfg.Address = addr
+	}
+	return New(cfg)
+}
+
+// Config configures a Service
+type Config struct {
+	Address   string       // Vault address, e.g. "https://vault:8200"
+	Token     string       // token allowed to encrypt and decrypt with the key
+	Namespace string       // Vault Enterprise namespace, optional
+	Mount     string       // mount path of the transit engine, e.g. "transit"
+	Key       string       // name of the transit key
+	Client    *http.Client // defaults to http.DefaultClient
+}
+
+// Service wraps data keys with a transit key
+type Service struct {
+	cfg Config
+}
+
+// New returns a service for cfg
+func New(cfg Config) (*Service, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("vault: no address, set VAULT_ADDR")
+	}
+	if cfg.Mount == "" || cfg.Key == "" {
+		return nil, fmt.Errorf("vault: no transit mount or key")
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	return &Service{cfg: cfg}, nil
+}
+
+// WrapKey encrypts key with the transit key. The result is Vault's
+// "vault:v<n>:..." ciphertext, so keys wrapped before a rotation of the
+// transit key stay readable.
+func (s *Service) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := s.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(resp.Ciphertext), nil
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey
+func (s *Service) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := s.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault: decrypt: %w", err)
+	}
+	return key, nil
+}
+
+// call posts body to the transit endpoint op and decodes the data of the
+// response into out
+func (s *Service) call(ctx context.Context, op string, body map[string]string, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	endpoint := s.cfg.Address + "/v1/" + s.cfg.Mount + "/" + op + "/" + url.PathEscape(s.cfg.Key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", s.cfg.Token)
+	if s.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.cfg.Namespace)
+	}
+	res, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer res.Body.Close()
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("vault: %s: %s", op, res.Status)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault: %s: %s: %s", op, res.Status, strings.Join(resp.Errors, "; "))
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("vault: %s: %w", op, err)
+	}
+	return nil
+}
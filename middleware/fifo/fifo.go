@@ -0,0 +1,196 @@
+// Package fifo provides a strict arrival-order mode for message iteration.
+//
+// In FIFO states, iterators return messages ordered by Sequence (falling
+// back to Created, then ID for messages without a sequence). With a group
+// header configured, only the oldest message of every group is returned
+// and groups that currently have a message in flight are skipped, so a
+// later message for the same group (e.g. the same recipient) can never
+// overtake an earlier one.
+//
+// Claims made with metastorage.ClaimBatch, ClaimBands or DueMessages
+// through the wrapper follow the same order: the wrapper implements
+// metastorage.OrderedBackend, so claim candidates of FIFO states are not
+// re-sorted by priority.
+//
+// The wrapper reads the complete state before returning the first message,
+// so memory use grows with the size of FIFO states.
+package fifo
+
+import (
+	"context"
+	"sort"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+type (
+	statesKey         struct{}
+	groupHeaderKey    struct{}
+	inFlightStatesKey struct{}
+)
+
+// WithStates selects the states iterated in FIFO order (default: all states)
+func WithStates(states ...metastorage.QueueState) options.Option {
+	return options.WithValue(statesKey{}, states)
+}
+
+// WithGroupHeader enables per-group ordering keyed by the given header
+func WithGroupHeader(header string) options.Option {
+	return options.WithValue(groupHeaderKey{}, header)
+}
+
+// WithInFlightStates sets the states whose messages block their group in
+// per-group mode (default: StateActive)
+func WithInFlightStates(states ...metastorage.QueueState) options.Option {
+	return options.WithValue(inFlightStatesKey{}, states)
+}
+
+// Less reports whether a arrived before b
+func Less(a, b metastorage.MessageMetadata) bool {
+	switch {
+	case a.Sequence != 0 && b.Sequence != 0 && a.Sequence != b.Sequence:
+		return a.Sequence < b.Sequence
+	case !a.Created.Equal(b.Created):
+		return a.Created.Before(b.Created)
+	default:
+		return a.ID < b.ID
+	}
+}
+
+// Backend iterates FIFO states in strict arrival order
+type Backend struct {
+	metastorage.Backend
+	states      map[metastorage.QueueState]bool
+	groupHeader string
+	inFlight    []metastorage.QueueState
+	batchSize   int
+}
+
+// New wraps backend with FIFO iteration
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	states := options.ValueOr(o, statesKey{}, metastorage.States())
+	b := &Backend{
+		Backend:     backend,
+		states:      make(map[metastorage.QueueState]bool, len(states)),
+		groupHeader: options.ValueOr(o, groupHeaderKey{}, ""),
+		inFlight:    options.ValueOr(o, inFlightStatesKey{}, []metastorage.QueueState{metastorage.StateActive}),
+		batchSize:   o.BatchSize,
+	}
+	for _, s := range states {
+		b.states[s] = true
+	}
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// ClaimsInIterationOrder reports whether state is iterated in FIFO order,
+// see metastorage.OrderedBackend
+func (b *Backend) ClaimsInIterationOrder(state metastorage.QueueState) bool {
+	return b.states[state]
+}
+
+// NewMessageIterator returns an arrival-ordered iterator for FIFO states
+// and the wrapped backend's iterator for all others
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if !b.states[state] {
+		return b.Backend.NewMessageIterator(ctx, state, batchSize)
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	messages, err := collect(ctx, b.Backend, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return Less(messages[i], messages[j]) })
+
+	if b.groupHeader != "" {
+		blocked := make(map[string]bool)
+		for _, s := range b.inFlight {
+			if s == state {
+				continue
+			}
+			inFlight, err := collect(ctx, b.Backend, s, batchSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range inFlight {
+				blocked[m.Headers[b.groupHeader]] = true
+			}
+		}
+		messages = heads(messages, b.groupHeader, blocked)
+	}
+	return &sliceIterator{messages: messages}, nil
+}
+
+// heads keeps the first message of every group that is not blocked.
+// messages must be sorted in arrival order.
+func heads(messages []metastorage.MessageMetadata, header string, blocked map[string]bool) []metastorage.MessageMetadata {
+	out := messages[:0]
+	for _, m := range messages {
+		group := m.Headers[header]
+		if blocked[group] {
+			continue
+		}
+		blocked[group] = true // later messages of this group wait for the head
+		out = append(out, m)
+	}
+	return out
+}
+
+func collect(ctx context.Context, backend metastorage.Backend, state metastorage.QueueState, batchSize int) ([]metastorage.MessageMetadata, error) {
+	iter, err := backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var messages []metastorage.MessageMetadata
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return messages, nil
+		}
+		messages = append(messages, m)
+	}
+}
+
+type sliceIterator struct {
+	messages []metastorage.MessageMetadata
+}
+
+func (it *sliceIterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return metastorage.MessageMetadata{}, false, err
+	}
+	if len(it.messages) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.messages[0]
+	it.messages = it.messages[1:]
+	return m, true, nil
+}
+
+func (it *sliceIterator) Close() error {
+	it.messages = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package fifo
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// listed serves fixed state listings in the given order
+type listed struct {
+	metastorage.Backend
+	states map[metastorage.QueueState][]metastorage.MessageMetadata
+}
+
+func (l *listed) NewMessageIterator(_ context.Context, state metastorage.QueueState, _ int) (metastorage.MessageIterator, error) {
+	return &sliceIterator{messages: append([]metastorage.MessageMetadata(nil), l.states[state]...)}, nil
+}
+
+func ids(t *testing.T, b metastorage.Backend, state metastorage.QueueState) []string {
+	t.Helper()
+	ctx := context.Background()
+	iter, err := b.NewMessageIterator(ctx, state, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	var out []string
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more {
+			return out
+		}
+		out = append(out, m.ID)
+	}
+}
+
+func message(id, group string, seq uint64, created time.Time) metastorage.MessageMetadata {
+	return metastorage.MessageMetadata{ID: id, Sequence: seq, Created: created, Headers: map[string]string{"rcpt": group}}
+}
+
+func TestArrivalOrder(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	inner := &listed{states: map[metastorage.QueueState][]metastorage.MessageMetadata{
+		metastorage.StateIncoming: {
+			message("c", "x", 3, t0),
+			message("a", "y", 1, t0.Add(time.Minute)),
+			message("e", "x", 0, t0.Add(-time.Minute)), // no sequence: ordered by Created
+			message("b", "z", 2, t0),
+			message("d", "y", 0, t0.Add(-time.Minute)),
+		},
+		metastorage.StateDeferred: {
+			message("q", "x", 2, t0),
+			message("p", "x", 1, t0),
+		},
+	}}
+
+	b := New(inner, WithStates(metastorage.StateIncoming))
+	if got, want := ids(t, b, metastorage.StateIncoming), []string{"d", "e", "a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("incoming = %v, want %v", got, want)
+	}
+	if got, want := ids(t, b, metastorage.StateDeferred), []string{"q", "p"}; !slices.Equal(got, want) {
+		t.Errorf("deferred, not a FIFO state = %v, want the inner order %v", got, want)
+	}
+}
+
+func TestGroupHeads(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	inner := &listed{states: map[metastorage.QueueState][]metastorage.MessageMetadata{
+		metastorage.StateIncoming: {
+			message("x2", "x", 4, t0),
+			message("y1", "y", 2, t0),
+			message("x1", "x", 1, t0),
+			message("z1", "z", 3, t0),
+			message("y2", "y", 5, t0),
+		},
+		metastorage.StateActive: {
+			message("z0", "z", 1, t0),
+		},
+	}}
+
+	b := New(inner, WithStates(metastorage.StateIncoming), WithGroupHeader("rcpt"))
+	// z is blocked by its message in flight, x and y only return their head
+	if got, want := ids(t, b, metastorage.StateIncoming), []string{"x1", "y1"}; !slices.Equal(got, want) {
+		t.Errorf("incoming = %v, want %v", got, want)
+	}
+}
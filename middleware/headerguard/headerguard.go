@@ -0,0 +1,266 @@
+// Package headerguard provides a decorator that bounds the headers
+// producers attach to messages: the number of headers, the length of
+// their keys and the size of their values. Unbounded headers bloat every
+// backend, as they are stored and read with each message.
+//
+// Violations are rejected with ErrHeadersTooLarge or, with the Truncate
+// policy, repaired: values are cut to the maximum size, headers with too
+// long keys are dropped and headers beyond the maximum count are dropped
+// in key order. Every violation is counted in MetricViolations.
+//
+// Reserved headers registered with metastorage.RegisterReservedHeader are
+// managed by retryspool itself and exempt from all limits. Other keys with
+// metastorage.ReservedHeaderPrefix are rejected or, with the Truncate
+// policy, dropped, so producers cannot pass headers off as reserved to
+// escape the limits.
+//
+// The limits also apply to the stores and updates of transactions begun
+// through the decorator.
+package headerguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricViolations counts headers violating a limit, labeled by limit
+// (count, key_length, value_size, reserved) and action (rejected,
+// truncated)
+const MetricViolations = "metastorage_header_violations_total"
+
+// Default limits
+const (
+	DefaultMaxHeaders   = 64
+	DefaultMaxKeyLength = 128
+	DefaultMaxValueSize = 4096
+)
+
+// ErrHeadersTooLarge is wrapped by the errors of rejected metadata
+var ErrHeadersTooLarge = errors.New("headers exceed limits")
+
+// Policy selects how violations are handled
+type Policy int
+
+const (
+	// Reject fails StoreMeta and UpdateMeta with ErrHeadersTooLarge
+	Reject Policy = iota
+	// Truncate stores the message with the offending headers cut down
+	Truncate
+)
+
+// ParsePolicy parses "reject" or "truncate"
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(s) {
+	case "reject":
+		return Reject, nil
+	case "truncate":
+		return Truncate, nil
+	}
+	return 0, fmt.Errorf("unknown header policy %q", s)
+}
+
+type (
+	maxHeadersKey   struct{}
+	maxKeyLengthKey struct{}
+	maxValueSizeKey struct{}
+	policyKey       struct{}
+)
+
+// WithMaxHeaders limits the number of headers (default DefaultMaxHeaders,
+// 0 = unlimited)
+func WithMaxHeaders(n int) options.Option {
+	return options.WithValue(maxHeadersKey{}, n)
+}
+
+// WithMaxKeyLength limits the length of header keys in bytes (default
+// DefaultMaxKeyLength, 0 = unlimited)
+func WithMaxKeyLength(n int) options.Option {
+	return options.WithValue(maxKeyLengthKey{}, n)
+}
+
+// WithMaxValueSize limits the size of header values in bytes (default
+// DefaultMaxValueSize, 0 = unlimited)
+func WithMaxValueSize(n int) options.Option {
+	return options.WithValue(maxValueSizeKey{}, n)
+}
+
+// WithPolicy sets how violations are handled (default Reject)
+func WithPolicy(p Policy) options.Option {
+	return options.WithValue(policyKey{}, p)
+}
+
+// Backend enforces header limits on StoreMeta and UpdateMeta
+type Backend struct {
+	metastorage.Backend
+	maxHeaders   int
+	maxKeyLength int
+	maxValueSize int
+	policy       Policy
+	metrics      metrics.Recorder
+}
+
+// New wraps backend with header limits
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	return &Backend{
+		Backend:      backend,
+		maxHeaders:   options.ValueOr(o, maxHeadersKey{}, DefaultMaxHeaders),
+		maxKeyLength: options.ValueOr(o, maxKeyLengthKey{}, DefaultMaxKeyLength),
+		maxValueSize: options.ValueOr(o, maxValueSizeKey{}, DefaultMaxValueSize),
+		policy:       options.ValueOr(o, policyKey{}, Reject),
+		metrics:      o.Metrics,
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// Enforce applies the limits to m. It returns m unchanged if it complies,
+// a truncated copy with the Truncate policy, or an error wrapping
+// ErrHeadersTooLarge with the Reject policy.
+func (b *Backend) Enforce(m metastorage.MessageMetadata) (metastorage.MessageMetadata, error) {
+	var keys []string // unreserved keys in order
+	for k := range m.Headers {
+		if !metastorage.IsReservedHeader(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var headers map[string]string // copy, made on the first change
+	drop := func(k string) {
+		if headers == nil {
+			headers = make(map[string]string, len(m.Headers))
+			for k, v := range m.Headers {
+				headers[k] = v
+			}
+		}
+		delete(headers, k)
+	}
+	set := func(k, v string) {
+		drop(k)
+		headers[k] = v
+	}
+
+	kept := 0
+	for _, k := range keys {
+		v := m.Headers[k]
+		if strings.HasPrefix(k, metastorage.ReservedHeaderPrefix) {
+			if err := b.violation("reserved", "header %q uses the reserved prefix %s", k, metastorage.ReservedHeaderPrefix); err != nil {
+				return m, err
+			}
+			drop(k)
+			continue
+		}
+		if b.maxKeyLength > 0 && len(k) > b.maxKeyLength {
+			if err := b.violation("key_length", "header key of %d bytes, limit %d", len(k), b.maxKeyLength); err != nil {
+				return m, err
+			}
+			drop(k)
+			continue
+		}
+		if b.maxHeaders > 0 && kept == b.maxHeaders {
+			if err := b.violation("count", "more than %d headers", b.maxHeaders); err != nil {
+				return m, err
+			}
+			drop(k)
+			continue
+		}
+		kept++
+		if b.maxValueSize > 0 && len(v) > b.maxValueSize {
+			if err := b.violation("value_size", "header %q value of %d bytes, limit %d", k, len(v), b.maxValueSize); err != nil {
+				return m, err
+			}
+			set(k, truncate(v, b.maxValueSize))
+		}
+	}
+	if headers != nil {
+		m.Headers = headers
+	}
+	return m, nil
+}
+
+// violation records a violation of limit and returns the rejection error,
+// or nil if the policy truncates
+func (b *Backend) violation(limit, format string, args ...any) error {
+	if b.policy == Truncate {
+		b.metrics.Counter(MetricViolations, metrics.Labels{"limit": limit, "action": "truncated"}, 1)
+		return nil
+	}
+	b.metrics.Counter(MetricViolations, metrics.Labels{"limit": limit, "action": "rejected"}, 1)
+	return fmt.Errorf("%w: %s", ErrHeadersTooLarge, fmt.Sprintf(format, args...))
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// StoreMeta stores message metadata after enforcing the header limits
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata, err := b.Enforce(metadata)
+	if err != nil {
+		return fmt.Errorf("%s: %w", messageID, err)
+	}
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
+
+// UpdateMeta updates message metadata after enforcing the header limits
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata, err := b.Enforce(metadata)
+	if err != nil {
+		return fmt.Errorf("%s: %w", messageID, err)
+	}
+	return b.Backend.UpdateMeta(ctx, messageID, metadata)
+}
+
+// WrapTxn enforces the header limits on the stores and updates of
+// transactions, see metastorage.TxnDecorator
+func (b *Backend) WrapTxn(txn metastorage.Txn) metastorage.Txn {
+	return guardedTxn{Txn: txn, b: b}
+}
+
+type guardedTxn struct {
+	metastorage.Txn
+	b *Backend
+}
+
+func (t guardedTxn) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata, err := t.b.Enforce(metadata)
+	if err != nil {
+		return fmt.Errorf("%s: %w", messageID, err)
+	}
+	return t.Txn.StoreMeta(ctx, messageID, metadata)
+}
+
+func (t guardedTxn) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata, err := t.b.Enforce(metadata)
+	if err != nil {
+		return fmt.Errorf("%s: %w", messageID, err)
+	}
+	return t.Txn.UpdateMeta(ctx, messageID, metadata)
+}
@@ -0,0 +1,46 @@
+package headerguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+func TestReservedHeaders(t *testing.T) {
+	m := metastorage.MessageMetadata{ID: "m1", Headers: map[string]string{
+		metastorage.HeaderPinned:                 "legal hold",
+		metastorage.ReservedHeaderPrefix + "big": "producer supplied",
+		"x-domain":                               "example.org",
+	}}
+
+	reject := newBackend(nil, nil)
+	if _, err := reject.Enforce(m); !errors.Is(err, ErrHeadersTooLarge) {
+		t.Fatalf("Enforce with unregistered reserved key: %v, want rejection", err)
+	}
+
+	truncate := newBackend(nil, []options.Option{WithPolicy(Truncate), WithMaxHeaders(1)})
+	got, err := truncate.Enforce(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Headers) != 2 || got.Headers[metastorage.HeaderPinned] == "" || got.Headers["x-domain"] == "" {
+		t.Fatalf("headers = %v, want the pin and x-domain", got.Headers)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	b := New(memory.New(), WithMaxHeaders(1))
+	txn, err := metastorage.Begin(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, Headers: map[string]string{"a": "1", "b": "2"}}
+	if err := txn.StoreMeta(ctx, "m1", m); !errors.Is(err, ErrHeadersTooLarge) {
+		t.Fatalf("store in transaction: %v, want rejection", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package itermetrics provides a decorator that measures how efficiently
+// iterators read, so batch sizes and filters can be tuned with real data
+// instead of guesses.
+//
+// Per state it counts the iterators opened and abandoned (closed before
+// they were exhausted), the batches fetched and the records read, and,
+// with a filter set, the records read but skipped by it. Skipped per read
+// is the share of wasted reads a native filter or index would save; read
+// per batch shows whether the batch size fits how far iterators get.
+//
+// Batches are inferred like in the batchsize middleware: a batch is
+// assumed to end after as many records as the batch size, and the final
+// fetch finding no more records counts as a batch of its own. As
+// decorators between this layer and the backend may hide the real batch
+// size, it belongs directly above the backend, or above batchsize.
+package itermetrics
+
+import (
+	"context"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/query"
+)
+
+// Metric names, all labeled by state
+const (
+	MetricOpened    = "metastorage_iterator_opened_total"
+	MetricAbandoned = "metastorage_iterator_abandoned_total"
+	MetricBatches   = "metastorage_iterator_batches_total"
+	MetricRead      = "metastorage_iterator_read_total"
+	MetricSkipped   = "metastorage_iterator_skipped_total"
+)
+
+type (
+	filterKey struct{}
+	queryKey  struct{}
+)
+
+// WithFilter sets a filter for the messages of all iterators: messages
+// keep returns false for are skipped and counted in MetricSkipped
+func WithFilter(keep func(metastorage.MessageMetadata) bool) options.Option {
+	return options.WithValue(filterKey{}, keep)
+}
+
+// WithQuery is WithFilter for the messages matching q, evaluated at the
+// time of reading them
+func WithQuery(q *query.Query) options.Option {
+	return options.WithValue(queryKey{}, q)
+}
+
+// Backend counts the reads of its iterators
+type Backend struct {
+	metastorage.Backend
+	keep      func(metastorage.MessageMetadata) bool // nil to keep all
+	batchSize int
+	clock     clock.Clock
+	metrics   metrics.Recorder
+}
+
+// New wraps backend with iterator metrics
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	b := &Backend{
+		Backend:   backend,
+		keep:      options.ValueOr[func(metastorage.MessageMetadata) bool](o, filterKey{}, nil),
+		batchSize: o.BatchSize,
+		clock:     o.Clock,
+		metrics:   o.Metrics,
+	}
+	if q := options.ValueOr[*query.Query](o, queryKey{}, nil); q != nil {
+		keep := b.keep
+		b.keep = func(m metastorage.MessageMetadata) bool {
+			return (keep == nil || keep(m)) && q.Match(m, b.clock.Now())
+		}
+	}
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// NewMessageIterator creates an iterator counting its reads. Iterators of
+// the wrapped backend implementing metastorage.ResizableIterator stay
+// resizable.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	iter, err := b.Backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	labels := metrics.Labels{metrics.LabelState: metastorage.StateLabel(state)}
+	b.metrics.Counter(MetricOpened, labels, 1)
+	it := &iterator{MessageIterator: iter, backend: b, labels: labels, size: max(batchSize, 1)}
+	if resizable, ok := iter.(metastorage.ResizableIterator); ok {
+		return &resizableIterator{iterator: it, resizable: resizable}, nil
+	}
+	return it, nil
+}
+
+// iterator counts the batches and records read by the wrapped iterator
+type iterator struct {
+	metastorage.MessageIterator
+	backend *Backend
+	labels  metrics.Labels
+
+	size    int // of the current batch
+	inBatch int // records read of the current batch
+	done    bool
+}
+
+// Next returns the next message kept by the filter
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if it.done {
+		return it.MessageIterator.Next(ctx)
+	}
+	for {
+		m, more, err := it.read(ctx)
+		if err != nil || !more {
+			it.done = true
+			return m, more, err
+		}
+		if it.backend.keep == nil || it.backend.keep(m) {
+			return m, true, nil
+		}
+		it.backend.metrics.Counter(MetricSkipped, it.labels, 1)
+	}
+}
+
+// read returns the next message of the wrapped iterator, counting a batch
+// whenever it starts one
+func (it *iterator) read(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if it.inBatch == 0 {
+		it.backend.metrics.Counter(MetricBatches, it.labels, 1)
+	}
+	m, more, err := it.MessageIterator.Next(ctx)
+	if err != nil || !more {
+		return m, more, err
+	}
+	it.backend.metrics.Counter(MetricRead, it.labels, 1)
+	if it.inBatch++; it.inBatch >= it.size {
+		it.inBatch = 0
+	}
+	return m, true, nil
+}
+
+// Close closes the wrapped iterator, counting it as abandoned if it was
+// not exhausted
+func (it *iterator) Close() error {
+	if !it.done {
+		it.done = true
+		it.backend.metrics.Counter(MetricAbandoned, it.labels, 1)
+	}
+	return it.MessageIterator.Close()
+}
+
+// resizableIterator forwards SetBatchSize to the wrapped iterator
+type resizableIterator struct {
+	*iterator
+	resizable metastorage.ResizableIterator
+}
+
+// SetBatchSize resizes the wrapped iterator starting with its next fetch
+func (it *resizableIterator) SetBatchSize(n int) {
+	if n <= 0 {
+		return
+	}
+	it.resizable.SetBatchSize(n)
+	it.size = n
+}
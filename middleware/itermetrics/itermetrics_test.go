@@ -0,0 +1,72 @@
+package itermetrics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/query"
+)
+
+func TestIteratorMetrics(t *testing.T) {
+	ctx := context.Background()
+	rec := metrics.NewMemory()
+	b := New(memory.New(), options.WithMetrics(rec), WithQuery(query.MustParse("attempts >= 5")))
+	for i := 0; i < 10; i++ {
+		m := metastorage.MessageMetadata{ID: fmt.Sprintf("m%d", i), State: metastorage.StateDeferred, Attempts: i}
+		if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// exhausted: 10 reads in batches of 4, 4 and 2, of which 5 are skipped
+	iter, err := b.NewMessageIterator(ctx, metastorage.StateDeferred, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !more {
+			break
+		}
+		if m.Attempts < 5 {
+			t.Errorf("%s with %d attempts not skipped", m.ID, m.Attempts)
+		}
+		kept++
+	}
+	iter.Close()
+	if kept != 5 {
+		t.Errorf("kept %d messages, want 5", kept)
+	}
+
+	// abandoned after the first message
+	iter, err = b.NewMessageIterator(ctx, metastorage.StateDeferred, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := iter.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	iter.Close()
+
+	labels := metrics.Labels{metrics.LabelState: metastorage.StateLabel(metastorage.StateDeferred)}
+	for name, want := range map[string]float64{
+		MetricOpened:    2,
+		MetricAbandoned: 1,
+		MetricBatches:   3 + 2,
+		MetricRead:      10 + 6,
+		MetricSkipped:   5 + 5,
+	} {
+		if got := rec.CounterValue(name, labels); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package logging provides a metadata backend decorator that logs every
+// operation with its duration and outcome.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Backend logs all operations of the wrapped backend
+type Backend struct {
+	metastorage.Backend
+	logger *slog.Logger
+}
+
+// New wraps backend with operation logging. The logger is taken from
+// options.WithLogger. Optional extension interfaces of backend (e.g.
+// StateCounterBackend) are preserved.
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	return &Backend{Backend: backend, logger: o.Logger}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+func (b *Backend) log(ctx context.Context, op string, start time.Time, err error, attrs ...slog.Attr) {
+	attrs = append(attrs, slog.String("op", op), slog.Duration("duration", time.Since(start)))
+	level := slog.LevelDebug
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	b.logger.LogAttrs(ctx, level, "metastorage operation", attrs...)
+}
+
+// StoreMeta stores message metadata
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	start := time.Now()
+	err := b.Backend.StoreMeta(ctx, messageID, metadata)
+	b.log(ctx, "StoreMeta", start, err, slog.String("id", messageID), slog.String("state", metadata.State.String()))
+	return err
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	start := time.Now()
+	metadata, err := b.Backend.GetMeta(ctx, messageID)
+	b.log(ctx, "GetMeta", start, err, slog.String("id", messageID))
+	return metadata, err
+}
+
+// UpdateMeta updates message metadata
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	start := time.Now()
+	err := b.Backend.UpdateMeta(ctx, messageID, metadata)
+	b.log(ctx, "UpdateMeta", start, err, slog.String("id", messageID))
+	return err
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	start := time.Now()
+	err := b.Backend.DeleteMeta(ctx, messageID)
+	b.log(ctx, "DeleteMeta", start, err, slog.String("id", messageID))
+	return err
+}
+
+// ListMessages lists messages with pagination and filtering
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, options metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	start := time.Now()
+	result, err := b.Backend.ListMessages(ctx, state, options)
+	b.log(ctx, "ListMessages", start, err, slog.String("state", state.String()), slog.Int("returned", len(result.MessageIDs)))
+	return result, err
+}
+
+// NewMessageIterator creates an iterator for messages in a specific state
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	start := time.Now()
+	iter, err := b.Backend.NewMessageIterator(ctx, state, batchSize)
+	b.log(ctx, "NewMessageIterator", start, err, slog.String("state", state.String()), slog.Int("batch_size", batchSize))
+	return iter, err
+}
+
+// MoveToState moves a message from one queue state to another atomically
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	start := time.Now()
+	err := b.Backend.MoveToState(ctx, messageID, fromState, toState)
+	b.log(ctx, "MoveToState", start, err, slog.String("id", messageID), slog.String("from", fromState.String()), slog.String("to", toState.String()))
+	return err
+}
+
+// Close closes the wrapped backend
+func (b *Backend) Close() error {
+	start := time.Now()
+	err := b.Backend.Close()
+	b.log(context.Background(), "Close", start, err)
+	return err
+}
+
+// WrapTxn logs the outcome of transactions, see metastorage.TxnDecorator
+func (b *Backend) WrapTxn(txn metastorage.Txn) metastorage.Txn {
+	return &loggedTxn{Txn: txn, b: b}
+}
+
+// loggedTxn logs Commit and Rollback of a transaction
+type loggedTxn struct {
+	metastorage.Txn
+	b *Backend
+}
+
+func (t *loggedTxn) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := t.Txn.Commit(ctx)
+	t.b.log(ctx, "Commit", start, err)
+	return err
+}
+
+func (t *loggedTxn) Rollback() error {
+	start := time.Now()
+	err := t.Txn.Rollback()
+	t.b.log(context.Background(), "Rollback", start, err)
+	return err
+}
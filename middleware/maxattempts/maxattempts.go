@@ -0,0 +1,118 @@
+// Package maxattempts provides a decorator that fills in MaxAttempts when
+// a producer stores a message without one, so the retry limit is
+// configured once for the spool instead of in every producer.
+//
+// The first configured default that applies wins: the message's group
+// (WithGroupDefaults), its priority band (WithBandDefault), then the
+// global default (WithDefault). Messages matching none keep
+// MaxAttempts 0.
+package maxattempts
+
+import (
+	"context"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+type (
+	defaultKey       struct{}
+	bandDefaultKey   struct{ band metastorage.Band }
+	bandMappingKey   struct{}
+	groupDefaultsKey struct{}
+)
+
+// groupDefaults is the value of WithGroupDefaults
+type groupDefaults struct {
+	header   string
+	defaults map[string]int
+}
+
+// WithDefault sets the MaxAttempts of messages no other default applies to
+func WithDefault(n int) options.Option {
+	return options.WithValue(defaultKey{}, n)
+}
+
+// WithBandDefault sets the MaxAttempts of messages in band. It can be
+// given once per band.
+func WithBandDefault(band metastorage.Band, n int) options.Option {
+	return options.WithValue(bandDefaultKey{band}, n)
+}
+
+// WithBandMapping sets the mapping of priorities to bands (default
+// metastorage.DefaultBandMapping)
+func WithBandMapping(m metastorage.BandMapping) options.Option {
+	return options.WithValue(bandMappingKey{}, m)
+}
+
+// WithGroupDefaults sets the MaxAttempts of messages by the value of
+// header, e.g. per recipient domain
+func WithGroupDefaults(header string, defaults map[string]int) options.Option {
+	return options.WithValue(groupDefaultsKey{}, groupDefaults{header: header, defaults: defaults})
+}
+
+// Backend applies MaxAttempts defaults on StoreMeta
+type Backend struct {
+	metastorage.Backend
+	fallback int
+	bands    map[metastorage.Band]int
+	mapping  metastorage.BandMapping
+	groups   groupDefaults
+}
+
+// New wraps backend with MaxAttempts defaults
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	b := &Backend{
+		Backend:  backend,
+		fallback: options.ValueOr(o, defaultKey{}, 0),
+		bands:    make(map[metastorage.Band]int),
+		mapping:  options.ValueOr(o, bandMappingKey{}, metastorage.DefaultBandMapping),
+		groups:   options.ValueOr(o, groupDefaultsKey{}, groupDefaults{}),
+	}
+	for _, band := range metastorage.Bands() {
+		if n, ok := options.Value[int](o, bandDefaultKey{band}); ok {
+			b.bands[band] = n
+		}
+	}
+	return b
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// MaxAttempts returns the MaxAttempts default for m, 0 if none applies
+func (b *Backend) MaxAttempts(m metastorage.MessageMetadata) int {
+	if b.groups.header != "" {
+		if g, ok := m.Headers[b.groups.header]; ok {
+			if n, ok := b.groups.defaults[g]; ok {
+				return n
+			}
+		}
+	}
+	if n, ok := b.bands[b.mapping.Band(m.Priority)]; ok {
+		return n
+	}
+	return b.fallback
+}
+
+// StoreMeta stores message metadata, filling in MaxAttempts if it is 0
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if metadata.MaxAttempts == 0 {
+		metadata.MaxAttempts = b.MaxAttempts(metadata)
+	}
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
@@ -0,0 +1,167 @@
+package offline
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// OpKind is the mutation recorded by an Op
+type OpKind string
+
+const (
+	OpStore  OpKind = "store"
+	OpUpdate OpKind = "update"
+	OpDelete OpKind = "delete"
+	OpMove   OpKind = "move"
+)
+
+// Op is a write accepted while the backend was unreachable
+type Op struct {
+	Seq       uint64                       `json:"seq"`
+	Kind      OpKind                       `json:"kind"`
+	MessageID string                       `json:"id"`
+	Metadata  *metastorage.MessageMetadata `json:"metadata,omitempty"` // store, update
+	From      metastorage.QueueState       `json:"from"`               // move
+	To        metastorage.QueueState       `json:"to"`                 // move
+	Base      uint64                       `json:"base,omitempty"`     // version the write replaces, 0 if unknown
+	Queued    time.Time                    `json:"queued"`
+	Done      bool                         `json:"done,omitempty"` // marker: the op with Seq was replayed
+}
+
+// journal persists queued ops as JSON lines. Every append is synced, so
+// an accepted write survives a crash of the spool node.
+type journal struct {
+	path string
+	f    *os.File
+}
+
+// openJournal opens or creates the journal at path and returns the ops it
+// still contains, without those marked as replayed
+func openJournal(path string) (*journal, []Op, error) {
+	ops, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &journal{path: path, f: f}, ops, nil
+}
+
+func readJournal(path string) ([]Op, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ops []Op
+	done := make(map[uint64]bool)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var op Op
+		if err := json.Unmarshal(sc.Bytes(), &op); err != nil {
+			if !hasMore(sc) {
+				// torn final line from a crash during append; the write
+				// was never acknowledged
+				break
+			}
+			return nil, fmt.Errorf("offline journal %s line %d: %w", path, line, err)
+		}
+		if op.Done {
+			done[op.Seq] = true
+			continue
+		}
+		ops = append(ops, op)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	pending := ops[:0]
+	for _, op := range ops {
+		if !done[op.Seq] {
+			pending = append(pending, op)
+		}
+	}
+	return pending, nil
+}
+
+func hasMore(sc *bufio.Scanner) bool {
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *journal) append(op Op) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// done marks the op with seq as replayed, so it is not replayed again
+// after a crash before the next rewrite
+func (j *journal) done(seq uint64) error {
+	return j.append(Op{Seq: seq, Done: true})
+}
+
+// rewrite atomically replaces the journal with ops
+func (j *journal) rewrite(ops []Op) error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, op := range ops {
+		data, err := json.Marshal(op)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	old := j.f
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	old.Close()
+	return err
+}
+
+func (j *journal) close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,471 @@
+// Package offline lets edge spool nodes keep accepting writes while the
+// central metadata service is unreachable.
+//
+// Writes failing with a transient error (metastorage.IsRetryable) are
+// appended to a local journal and reported as successful. Once anything
+// is queued, later writes are queued behind it so the service sees all
+// writes in their original order. A background loop replays the journal
+// when the service is reachable again. Replayed writes that no longer
+// apply, e.g. because another node changed the message in the meantime,
+// are reported to the conflict handler and dropped. On backends tracking
+// versions (metastorage.VersionBackend) a change is detected by the
+// version; otherwise by Updated, tolerating the backend's clock skew.
+//
+// Reads of messages with queued writes are answered from the journal, so
+// a node sees its own offline writes.
+//
+// Every replayed write is marked in the journal, so writes replayed
+// before a crash are not replayed again. A write whose response was lost
+// cannot be told apart from a concurrent change by another node and is
+// reported as a conflict.
+package offline
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+const (
+	// MetricPending is the number of queued writes
+	MetricPending = "metastorage_offline_pending"
+
+	// MetricConflicts counts replayed writes dropped as conflicts, labeled
+	// by op kind
+	MetricConflicts = "metastorage_offline_conflicts_total"
+)
+
+// DefaultReplayInterval is how often replay is attempted while writes
+// are queued
+const DefaultReplayInterval = 5 * time.Second
+
+// ErrNoJournal is returned by New without WithJournal
+var ErrNoJournal = errors.New("offline: journal path not configured")
+
+// Conflict describes a queued write that could not be applied on replay
+type Conflict struct {
+	Op     Op
+	Err    error                        // Error returned by the backend, or why the op was rejected
+	Remote *metastorage.MessageMetadata // Metadata found in the backend, if any
+}
+
+type (
+	journalKey  struct{}
+	intervalKey struct{}
+	conflictKey struct{}
+)
+
+// WithJournal sets the journal file (required). Queued writes of a
+// previous run found in the file are replayed.
+func WithJournal(path string) options.Option {
+	return options.WithValue(journalKey{}, path)
+}
+
+// WithReplayInterval sets how often replay is attempted (default
+// DefaultReplayInterval)
+func WithReplayInterval(d time.Duration) options.Option {
+	return options.WithValue(intervalKey{}, d)
+}
+
+// WithConflictHandler is called for every dropped write. Without a
+// handler, conflicts are logged.
+func WithConflictHandler(fn func(Conflict)) options.Option {
+	return options.WithValue(conflictKey{}, fn)
+}
+
+// Backend queues writes while the wrapped backend is unreachable
+type Backend struct {
+	metastorage.Backend
+	clock      clock.Clock
+	logger     *slog.Logger
+	metrics    metrics.Recorder
+	onConflict func(Conflict)
+
+	mu      sync.Mutex
+	direct  sync.RWMutex // held for reading by writes sent to the backend directly
+	journal *journal
+	ops     []Op
+	seq     uint64
+	overlay map[string]*metastorage.MessageMetadata // latest queued state per message, nil = deleted
+
+	replayMu sync.Mutex
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New wraps backend with an offline write queue. Flush and Pending are
+// reached with metastorage.As[*offline.Backend].
+func New(backend metastorage.Backend, opts ...options.Option) (metastorage.Backend, error) {
+	b, err := newBackend(backend, opts)
+	if err != nil {
+		return nil, err
+	}
+	return metastorage.Wrap(backend, b), nil
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	path := options.ValueOr(o, journalKey{}, "")
+	if path == "" {
+		return nil, ErrNoJournal
+	}
+	j, ops, err := openJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backend{
+		Backend:    backend,
+		clock:      o.Clock,
+		logger:     o.Logger,
+		metrics:    o.Metrics,
+		onConflict: options.ValueOr[func(Conflict)](o, conflictKey{}, nil),
+		journal:    j,
+		overlay:    make(map[string]*metastorage.MessageMetadata),
+	}
+	for _, op := range ops {
+		b.ops = append(b.ops, op)
+		b.seq = max(b.seq, op.Seq)
+		b.applyOverlay(op)
+	}
+	b.metrics.Gauge(MetricPending, nil, float64(len(b.ops)))
+	if len(ops) > 0 {
+		b.logger.Info("metastorage offline journal has queued writes", slog.Int("pending", len(ops)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stop = cancel
+	b.wg.Add(1)
+	go b.replayLoop(ctx, options.ValueOr(o, intervalKey{}, DefaultReplayInterval))
+	return b, nil
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// Pending returns the number of queued writes
+func (b *Backend) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.ops)
+}
+
+// applyOverlay records the effect of op for local reads. On backends
+// tracking versions the overlay carries the version the message will have
+// once op is replayed, so writes based on it replay against that version.
+func (b *Backend) applyOverlay(op Op) {
+	switch op.Kind {
+	case OpStore, OpUpdate:
+		m := *op.Metadata
+		if op.Base != 0 {
+			m.Version = op.Base + 1
+		}
+		b.overlay[op.MessageID] = &m
+	case OpDelete:
+		b.overlay[op.MessageID] = nil
+	case OpMove:
+		if m := b.overlay[op.MessageID]; m != nil {
+			m.State = op.To
+			if op.Base != 0 {
+				m.Version = op.Base + 1
+			}
+		}
+	}
+}
+
+// baseLocked returns the version of the message op replaces: the version
+// of the queued state if there is one, else the version the caller read
+func (b *Backend) baseLocked(op Op) uint64 {
+	if m, ok := b.overlay[op.MessageID]; ok {
+		if m == nil {
+			return 0
+		}
+		return m.Version
+	}
+	if op.Metadata != nil {
+		return op.Metadata.Version
+	}
+	return 0
+}
+
+// write runs op against the backend unless writes are already queued, and
+// queues it if the backend is unreachable. Direct writes hold direct for
+// reading from the check until they complete, and the first queued write
+// waits for them, so a write can never overtake one queued before it.
+func (b *Backend) write(ctx context.Context, op Op, run func() error) error {
+	b.mu.Lock()
+	if len(b.ops) == 0 {
+		b.direct.RLock()
+		b.mu.Unlock()
+		err := run()
+		b.direct.RUnlock()
+		if err == nil || !metastorage.IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		b.logger.Warn("metastorage backend unreachable, queueing write", slog.String("error", err.Error()))
+		b.mu.Lock()
+	}
+	defer b.mu.Unlock()
+	if len(b.ops) == 0 {
+		b.direct.Lock()
+		defer b.direct.Unlock()
+	}
+
+	b.seq++
+	op.Seq = b.seq
+	op.Base = b.baseLocked(op)
+	op.Queued = b.clock.Now()
+	if err := b.journal.append(op); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, op)
+	b.applyOverlay(op)
+	b.metrics.Gauge(MetricPending, nil, float64(len(b.ops)))
+	return nil
+}
+
+// StoreMeta stores message metadata, queueing it while offline
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	return b.write(ctx, Op{Kind: OpStore, MessageID: messageID, Metadata: &metadata}, func() error {
+		return b.Backend.StoreMeta(ctx, messageID, metadata)
+	})
+}
+
+// UpdateMeta updates message metadata, queueing it while offline
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	return b.write(ctx, Op{Kind: OpUpdate, MessageID: messageID, Metadata: &metadata}, func() error {
+		return b.Backend.UpdateMeta(ctx, messageID, metadata)
+	})
+}
+
+// DeleteMeta removes message metadata, queueing it while offline
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	return b.write(ctx, Op{Kind: OpDelete, MessageID: messageID}, func() error {
+		return b.Backend.DeleteMeta(ctx, messageID)
+	})
+}
+
+// MoveToState moves a message between states. While offline the move is
+// queued and checked against the locally known state only; the CAS is
+// enforced by the backend on replay.
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	b.mu.Lock()
+	if m, ok := b.overlay[messageID]; ok {
+		if m == nil {
+			b.mu.Unlock()
+			return metastorage.ErrMessageNotFound
+		}
+		if m.State != fromState {
+			b.mu.Unlock()
+			return metastorage.ErrStateConflict
+		}
+	}
+	b.mu.Unlock()
+	return b.write(ctx, Op{Kind: OpMove, MessageID: messageID, From: fromState, To: toState}, func() error {
+		return b.Backend.MoveToState(ctx, messageID, fromState, toState)
+	})
+}
+
+// GetMeta returns the queued state of a message if there is one, and asks
+// the backend otherwise
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	b.mu.Lock()
+	m, ok := b.overlay[messageID]
+	b.mu.Unlock()
+	if ok {
+		if m == nil {
+			return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+		}
+		return *m, nil
+	}
+	return b.Backend.GetMeta(ctx, messageID)
+}
+
+func (b *Backend) replayLoop(ctx context.Context, interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if b.Pending() == 0 {
+			continue
+		}
+		if err := b.Flush(ctx); err != nil && ctx.Err() == nil {
+			b.logger.Debug("metastorage offline replay paused", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// compactEvery bounds how many replayed ops and their markers may remain
+// in the journal file before it is rewritten
+const compactEvery = 100
+
+// Flush replays queued writes in order until the journal is empty or the
+// backend fails with a transient error, which is returned
+func (b *Backend) Flush(ctx context.Context) (err error) {
+	b.replayMu.Lock()
+	defer b.replayMu.Unlock()
+	replayed := 0
+	defer func() {
+		if replayed > 0 {
+			b.mu.Lock()
+			err = errors.Join(err, b.journal.rewrite(b.ops))
+			b.mu.Unlock()
+		}
+	}()
+	for {
+		b.mu.Lock()
+		if len(b.ops) == 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		op := b.ops[0]
+		b.mu.Unlock()
+
+		err := b.replay(ctx, op)
+		if err != nil && (metastorage.IsRetryable(err) || ctx.Err() != nil) {
+			return err
+		}
+		if err != nil {
+			// replay only returns transient errors or conflicts
+			b.conflict(ctx, op, err)
+		}
+
+		b.mu.Lock()
+		b.ops = b.ops[1:]
+		replayed++
+		if err := b.journal.done(op.Seq); err != nil {
+			b.mu.Unlock()
+			return err
+		}
+		if replayed%compactEvery == 0 {
+			if err := b.journal.rewrite(b.ops); err != nil {
+				b.mu.Unlock()
+				return err
+			}
+		}
+		// reads go to the backend again once no queued write is left
+		if !b.queuedLocked(op.MessageID) {
+			delete(b.overlay, op.MessageID)
+		}
+		b.metrics.Gauge(MetricPending, nil, float64(len(b.ops)))
+		b.mu.Unlock()
+	}
+}
+
+// Recover replays the journal left by the previous run, see
+// metastorage.Recover. A transient backend failure is not an error: the
+// writes stay queued and are replayed in the background.
+func (b *Backend) Recover(ctx context.Context) error {
+	err := b.Flush(ctx)
+	if err != nil && metastorage.IsRetryable(err) && ctx.Err() == nil {
+		b.logger.Warn("metastorage offline: journal replay deferred", slog.Int("pending", b.Pending()), slog.String("error", err.Error()))
+		return nil
+	}
+	return err
+}
+
+func (b *Backend) queuedLocked(id string) bool {
+	for _, op := range b.ops {
+		if op.MessageID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// errModified rejects a queued write because the message changed in the
+// backend after the write was queued
+var errModified = errors.New("offline: message modified in backend after write was queued")
+
+// modified reports whether remote changed after op was queued. With a
+// known base version the versions are compared. Otherwise only the
+// timestamps are left, which come from different clocks, so a change
+// within the clock skew tolerance of the backend counts as modified.
+func (b *Backend) modified(remote metastorage.MessageMetadata, op Op) bool {
+	if op.Base != 0 {
+		return remote.Version != op.Base
+	}
+	return remote.Updated.After(op.Queued.Add(-metastorage.ClockSkew(b.Backend)))
+}
+
+// replay applies op. Stores and updates are idempotent and deletes of
+// missing messages succeed; a move whose CAS fails is a conflict even if
+// the message is already in the target state, as another node may have
+// moved it.
+func (b *Backend) replay(ctx context.Context, op Op) error {
+	switch op.Kind {
+	case OpStore, OpUpdate:
+		remote, err := b.Backend.GetMeta(ctx, op.MessageID)
+		switch {
+		case err == nil:
+			if b.modified(remote, op) {
+				return errModified
+			}
+		case errors.Is(err, metastorage.ErrMessageNotFound):
+			if op.Kind == OpUpdate {
+				return err
+			}
+		default:
+			return err
+		}
+		if op.Kind == OpStore {
+			return b.Backend.StoreMeta(ctx, op.MessageID, *op.Metadata)
+		}
+		return b.Backend.UpdateMeta(ctx, op.MessageID, *op.Metadata)
+	case OpDelete:
+		err := b.Backend.DeleteMeta(ctx, op.MessageID)
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			return nil
+		}
+		return err
+	case OpMove:
+		if op.Base != 0 {
+			return metastorage.MoveToStateIfVersion(ctx, b.Backend, op.MessageID, op.From, op.To, op.Base)
+		}
+		return b.Backend.MoveToState(ctx, op.MessageID, op.From, op.To)
+	}
+	return nil
+}
+
+func (b *Backend) conflict(ctx context.Context, op Op, err error) {
+	c := Conflict{Op: op, Err: err}
+	if remote, gerr := b.Backend.GetMeta(ctx, op.MessageID); gerr == nil {
+		c.Remote = &remote
+	}
+	b.metrics.Counter(MetricConflicts, metrics.Labels{"op": string(op.Kind)}, 1)
+	if b.onConflict != nil {
+		b.onConflict(c)
+		return
+	}
+	b.logger.Warn("metastorage offline write dropped on replay",
+		slog.String("op", string(op.Kind)),
+		slog.String("id", op.MessageID),
+		slog.Time("queued", op.Queued),
+		slog.String("error", err.Error()))
+}
+
+// Close stops replaying and closes the journal and the wrapped backend.
+// Queued writes stay in the journal for the next start.
+func (b *Backend) Close() error {
+	b.stop()
+	b.wg.Wait()
+	b.replayMu.Lock()
+	defer b.replayMu.Unlock()
+	b.mu.Lock()
+	jerr := b.journal.close()
+	b.mu.Unlock()
+	return errors.Join(b.Backend.Close(), jerr)
+}
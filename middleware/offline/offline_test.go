@@ -0,0 +1,211 @@
+package offline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+var errDown = metastorage.Transient(errors.New("backend down"))
+
+// remote keeps messages in a map and fails writes with a transient error
+// while down is set; other Backend methods are not used
+type remote struct {
+	metastorage.Backend
+	down     atomic.Bool
+	mu       sync.Mutex
+	messages map[string]metastorage.MessageMetadata
+}
+
+func newRemote() *remote {
+	return &remote{messages: make(map[string]metastorage.MessageMetadata)}
+}
+
+func (r *remote) GetMeta(_ context.Context, id string) (metastorage.MessageMetadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.messages[id]
+	if !ok {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func (r *remote) StoreMeta(_ context.Context, id string, m metastorage.MessageMetadata) error {
+	if r.down.Load() {
+		return errDown
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages[id] = m
+	return nil
+}
+
+func (r *remote) UpdateMeta(_ context.Context, id string, m metastorage.MessageMetadata) error {
+	if r.down.Load() {
+		return errDown
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.messages[id]; !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	r.messages[id] = m
+	return nil
+}
+
+func (r *remote) MoveToState(_ context.Context, id string, from, to metastorage.QueueState) error {
+	if r.down.Load() {
+		return errDown
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.messages[id]
+	if !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	if m.State != from {
+		return metastorage.ErrStateConflict
+	}
+	m.State = to
+	r.messages[id] = m
+	return nil
+}
+
+func (r *remote) Close() error { return nil }
+
+func newOffline(t *testing.T, backend metastorage.Backend, opts ...options.Option) *Backend {
+	t.Helper()
+	opts = append([]options.Option{WithJournal(filepath.Join(t.TempDir(), "journal")), WithReplayInterval(time.Hour)}, opts...)
+	wrapped, err := New(backend, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := metastorage.As[*Backend](wrapped)
+	if !ok {
+		t.Fatal("offline backend not reachable with As")
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestWritesQueuedBehindPendingOnesKeepOrder(t *testing.T) {
+	ctx := context.Background()
+	r := newRemote()
+	b := newOffline(t, r)
+
+	r.down.Store(true)
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	r.down.Store(false)
+	// reachable again, but the move must not overtake the queued store
+	if err := b.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.Pending(); n != 2 {
+		t.Fatalf("pending = %d, want 2", n)
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.State != metastorage.StateActive {
+		t.Fatalf("state = %s, want active", m.State)
+	}
+}
+
+func TestReplayedOpsAreNotReplayedAgain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, _, err := openJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := j.append(Op{Seq: seq, Kind: OpDelete, MessageID: "m"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// crash after replaying op 1, before the journal is rewritten
+	if err := j.done(1); err != nil {
+		t.Fatal(err)
+	}
+	j.close()
+
+	ops, err := readJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Seq != 2 {
+		t.Fatalf("pending ops = %+v, want only seq 2", ops)
+	}
+}
+
+func TestMoveReplayIntoTargetStateIsConflict(t *testing.T) {
+	ctx := context.Background()
+	r := newRemote()
+	var conflicts []Conflict
+	b := newOffline(t, r, WithConflictHandler(func(c Conflict) { conflicts = append(conflicts, c) }))
+	if err := b.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.down.Store(true)
+	if err := b.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive); err != nil {
+		t.Fatal(err)
+	}
+	r.down.Store(false)
+	// another node claims the message in the meantime
+	if err := r.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || !errors.Is(conflicts[0].Err, metastorage.ErrStateConflict) {
+		t.Fatalf("conflicts = %+v, want one state conflict", conflicts)
+	}
+	if data, err := os.ReadFile(b.journal.path); err != nil || len(data) != 0 {
+		t.Fatalf("journal after flush = %q, %v; want empty", data, err)
+	}
+}
+
+func TestReplayComparesVersions(t *testing.T) {
+	ctx := context.Background()
+	r := newRemote()
+	r.messages["m1"] = metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, Version: 1}
+	var conflicts []Conflict
+	b := newOffline(t, r, WithConflictHandler(func(c Conflict) { conflicts = append(conflicts, c) }))
+
+	m, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.down.Store(true)
+	m.Attempts++
+	if err := b.UpdateMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+	r.down.Store(false)
+	// another node with a clock far behind changes the message
+	r.mu.Lock()
+	r.messages["m1"] = metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, Version: 2, Updated: time.Unix(0, 0).UTC()}
+	r.mu.Unlock()
+	if err := b.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || !errors.Is(conflicts[0].Err, errModified) {
+		t.Fatalf("conflicts = %+v, want one modified conflict", conflicts)
+	}
+}
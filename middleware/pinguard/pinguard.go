@@ -0,0 +1,63 @@
+// Package pinguard provides a decorator that refuses to delete pinned
+// messages, protecting them from expiry jobs, garbage collection and bulk
+// purges that remove messages through DeleteMeta. Below it, backends
+// implementing metastorage.PinBackend check the pin atomically with the
+// delete.
+package pinguard
+
+import (
+	"context"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Backend rejects DeleteMeta for pinned messages with metastorage.ErrPinned
+// unless the context carries metastorage.WithPinOverride. It implements
+// metastorage.PinBackend by forwarding to the next layer.
+type Backend struct {
+	metastorage.Backend
+}
+
+// New wraps backend with pin protection
+func New(backend metastorage.Backend, _ ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, &Backend{Backend: backend})
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(_ ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return &Backend{Backend: b}
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// DeleteMeta removes message metadata unless the message is pinned, see
+// metastorage.DeleteUnpinned
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if metastorage.PinOverride(ctx) {
+		return b.Backend.DeleteMeta(ctx, messageID)
+	}
+	return metastorage.DeleteUnpinned(ctx, b.Backend, messageID)
+}
+
+// Pin pins a message in the next layer, see metastorage.Pin
+func (b *Backend) Pin(ctx context.Context, messageID, reason string) error {
+	return metastorage.Pin(ctx, b.Backend, messageID, reason)
+}
+
+// Unpin removes the pin of a message in the next layer, see
+// metastorage.Unpin
+func (b *Backend) Unpin(ctx context.Context, messageID string) error {
+	return metastorage.Unpin(ctx, b.Backend, messageID)
+}
+
+// DeleteUnpinned deletes a message unless it is pinned, regardless of
+// metastorage.WithPinOverride, see metastorage.DeleteUnpinned
+func (b *Backend) DeleteUnpinned(ctx context.Context, messageID string) error {
+	return metastorage.DeleteUnpinned(ctx, b.Backend, messageID)
+}
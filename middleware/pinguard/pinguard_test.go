@@ -0,0 +1,121 @@
+package pinguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// store keeps messages in a map; other Backend methods are not used
+type store struct {
+	metastorage.Backend
+	messages map[string]metastorage.MessageMetadata
+}
+
+func (s *store) GetMeta(_ context.Context, id string) (metastorage.MessageMetadata, error) {
+	m, ok := s.messages[id]
+	if !ok {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func (s *store) UpdateMeta(_ context.Context, id string, m metastorage.MessageMetadata) error {
+	if _, ok := s.messages[id]; !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	s.messages[id] = m
+	return nil
+}
+
+func (s *store) DeleteMeta(_ context.Context, id string) error {
+	if _, ok := s.messages[id]; !ok {
+		return metastorage.ErrMessageNotFound
+	}
+	delete(s.messages, id)
+	return nil
+}
+
+func TestDeletePinned(t *testing.T) {
+	ctx := context.Background()
+	inner := &store{messages: map[string]metastorage.MessageMetadata{
+		"m1": {ID: "m1"},
+		"m2": {ID: "m2"},
+	}}
+	b := New(inner)
+	if err := metastorage.Pin(ctx, b, "m1", "investigation"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason, pinned := metastorage.IsPinned(m); !pinned || reason != "investigation" {
+		t.Fatalf("pin = %q, %v", reason, pinned)
+	}
+
+	if err := b.DeleteMeta(ctx, "m1"); !errors.Is(err, metastorage.ErrPinned) {
+		t.Fatalf("delete of pinned message: %v, want ErrPinned", err)
+	}
+	if err := b.DeleteMeta(ctx, "m2"); err != nil {
+		t.Fatalf("delete of unpinned message: %v", err)
+	}
+	if err := b.DeleteMeta(ctx, "missing"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("delete of missing message: %v, want ErrMessageNotFound", err)
+	}
+
+	if err := b.DeleteMeta(metastorage.WithPinOverride(ctx), "m1"); err != nil {
+		t.Fatalf("delete with override: %v", err)
+	}
+	if _, ok := inner.messages["m1"]; ok {
+		t.Fatal("pinned message not deleted with override")
+	}
+}
+
+func TestUnpin(t *testing.T) {
+	ctx := context.Background()
+	inner := &store{messages: map[string]metastorage.MessageMetadata{"m1": {ID: "m1"}}}
+	b := New(inner)
+	if err := metastorage.Pin(ctx, b, "m1", "hold"); err != nil {
+		t.Fatal(err)
+	}
+	if err := metastorage.Unpin(ctx, b, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := metastorage.Unpin(ctx, b, "m1"); err != nil {
+		t.Fatalf("unpin of unpinned message: %v", err)
+	}
+	if err := b.DeleteMeta(ctx, "m1"); err != nil {
+		t.Fatalf("delete after unpin: %v", err)
+	}
+}
+
+func TestDeletePinnedNative(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	if err := inner.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	b := New(inner)
+	if _, ok := b.(metastorage.PinBackend); !ok {
+		t.Fatal("PinBackend not forwarded")
+	}
+	if err := metastorage.Pin(ctx, b, "m1", "hold"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteMeta(ctx, "m1"); !errors.Is(err, metastorage.ErrPinned) {
+		t.Fatalf("delete of pinned message: %v, want ErrPinned", err)
+	}
+	if err := metastorage.Unpin(ctx, b, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteMeta(ctx, "m1"); err != nil {
+		t.Fatalf("delete after unpin: %v", err)
+	}
+	if err := b.DeleteMeta(ctx, "m1"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("delete of missing message: %v, want ErrMessageNotFound", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package recording provides a dry-run backend decorator. Mutations are
+// validated against the wrapped backend and recorded instead of applied,
+// so admin tools can preview exactly which messages an operation would
+// touch and replay the recorded changes later.
+package recording
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Op identifies the kind of a recorded mutation
+type Op string
+
+const (
+	OpStore  Op = "store"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+	OpMove   Op = "move"
+)
+
+// Mutation is a recorded, not yet applied change
+type Mutation struct {
+	Op        Op
+	MessageID string
+	Metadata  metastorage.MessageMetadata // set for OpStore and OpUpdate
+	FromState metastorage.QueueState      // set for OpMove
+	ToState   metastorage.QueueState      // set for OpMove
+	At        time.Time                   // when the mutation was recorded
+}
+
+// String returns a human readable description of the mutation
+func (m Mutation) String() string {
+	switch m.Op {
+	case OpMove:
+		return fmt.Sprintf("move %s %s -> %s", m.MessageID, m.FromState, m.ToState)
+	case OpStore, OpUpdate:
+		return fmt.Sprintf("%s %s (%s)", m.Op, m.MessageID, m.Metadata.State)
+	default:
+		return fmt.Sprintf("%s %s", m.Op, m.MessageID)
+	}
+}
+
+// ReplayError reports the mutation that failed during Replay
+type ReplayError struct {
+	Index    int
+	Mutation Mutation
+	Err      error
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("replay mutation %d (%s): %v", e.Index, e.Mutation, e.Err)
+}
+
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
+
+// Backend records mutations instead of applying them.
+//
+// Point reads (GetMeta) reflect the recorded mutations; ListMessages and
+// iterators pass through to the wrapped backend unchanged.
+type Backend struct {
+	metastorage.Backend
+	clock clock.Clock
+
+	mu        sync.Mutex
+	mutations []Mutation
+	overlay   map[string]*metastorage.MessageMetadata // nil value = deleted
+}
+
+// New wraps backend in dry-run mode
+func New(backend metastorage.Backend, opts ...options.Option) *Backend {
+	o := options.Apply(opts...)
+	return &Backend{
+		Backend: backend,
+		clock:   o.Clock,
+		overlay: make(map[string]*metastorage.MessageMetadata),
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// lookup returns the metadata as it would look after the recorded mutations.
+// b.mu must be held.
+func (b *Backend) lookup(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if m, ok := b.overlay[messageID]; ok {
+		if m == nil {
+			return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+		}
+		return *m, nil
+	}
+	return b.Backend.GetMeta(ctx, messageID)
+}
+
+func (b *Backend) record(m Mutation, result *metastorage.MessageMetadata) {
+	m.At = b.clock.Now()
+	b.mutations = append(b.mutations, m)
+	b.overlay[m.MessageID] = result
+}
+
+// GetMeta returns the metadata including recorded, unapplied changes
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lookup(ctx, messageID)
+}
+
+// StoreMeta records a store
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stored := metadata
+	b.record(Mutation{Op: OpStore, MessageID: messageID, Metadata: metadata}, &stored)
+	return nil
+}
+
+// UpdateMeta records an update. It fails like the real backend would if the message does not exist.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.lookup(ctx, messageID); err != nil {
+		return err
+	}
+	updated := metadata
+	b.record(Mutation{Op: OpUpdate, MessageID: messageID, Metadata: metadata}, &updated)
+	return nil
+}
+
+// DeleteMeta records a delete. It fails like the real backend would if the message does not exist.
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.lookup(ctx, messageID); err != nil {
+		return err
+	}
+	b.record(Mutation{Op: OpDelete, MessageID: messageID}, nil)
+	return nil
+}
+
+// MoveToState records a move, enforcing the same CAS check as a real backend
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	current, err := b.lookup(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if current.State != fromState {
+		return metastorage.ErrStateConflict
+	}
+	current.State = toState
+	b.record(Mutation{Op: OpMove, MessageID: messageID, FromState: fromState, ToState: toState}, &current)
+	return nil
+}
+
+// Mutations returns a copy of the recorded mutations in order
+func (b *Backend) Mutations() []Mutation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Mutation(nil), b.mutations...)
+}
+
+// Touched returns the sorted IDs of all messages a replay would modify
+func (b *Backend) Touched() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := make([]string, 0, len(b.overlay))
+	for id := range b.overlay {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Reset discards all recorded mutations
+func (b *Backend) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mutations = nil
+	b.overlay = make(map[string]*metastorage.MessageMetadata)
+}
+
+// Replay applies the recorded mutations to target in recording order.
+// It stops at the first failure and returns a *ReplayError.
+func (b *Backend) Replay(ctx context.Context, target metastorage.Backend) error {
+	return Replay(ctx, target, b.Mutations())
+}
+
+// Replay applies mutations to target in order.
+// It stops at the first failure and returns a *ReplayError.
+func Replay(ctx context.Context, target metastorage.Backend, mutations []Mutation) error {
+	for i, m := range mutations {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		switch m.Op {
+		case OpStore:
+			err = target.StoreMeta(ctx, m.MessageID, m.Metadata)
+		case OpUpdate:
+			err = target.UpdateMeta(ctx, m.MessageID, m.Metadata)
+		case OpDelete:
+			err = target.DeleteMeta(ctx, m.MessageID)
+		case OpMove:
+			err = target.MoveToState(ctx, m.MessageID, m.FromState, m.ToState)
+		default:
+			err = fmt.Errorf("unknown op %q", m.Op)
+		}
+		if err != nil {
+			return &ReplayError{Index: i, Mutation: m, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Package recovery provides a backend decorator that converts panics of
+// the wrapped backend into errors, so one buggy backend implementation
+// cannot crash the whole spool process.
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricPanics counts recovered panics, labeled by operation
+const MetricPanics = "metastorage_panics_total"
+
+// PanicError is returned instead of a panic
+type PanicError struct {
+	Op    string // Backend operation that panicked
+	Value any    // Value passed to panic
+	Stack []byte // Stack trace captured at recovery
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("metastorage: panic in %s: %v", e.Op, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Backend recovers panics of the wrapped backend and its iterators
+type Backend struct {
+	metastorage.Backend
+	logger  *slog.Logger
+	metrics metrics.Recorder
+}
+
+// counterBackend additionally protects GetStateCount
+type counterBackend struct {
+	*Backend
+	counter metastorage.StateCounterBackend
+}
+
+// New wraps backend with panic recovery. Recovered panics are logged with
+// their stack at error level and counted in MetricPanics.
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	o := options.Apply(opts...)
+	b := &Backend{Backend: backend, logger: o.Logger, metrics: o.Metrics}
+	if counter, ok := backend.(metastorage.StateCounterBackend); ok {
+		return &counterBackend{Backend: b, counter: counter}
+	}
+	return b
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return New(b, opts...)
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// recover converts a recovered panic into a *PanicError stored in errp
+func (b *Backend) recover(op string, errp *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	perr := &PanicError{Op: op, Value: v, Stack: debug.Stack()}
+	b.metrics.Counter(MetricPanics, metrics.Labels{"op": op}, 1)
+	b.logger.Error("metastorage backend panic recovered",
+		slog.String("op", op),
+		slog.Any("panic", v),
+		slog.String("stack", string(perr.Stack)))
+	if errp != nil {
+		*errp = perr
+	}
+}
+
+// StoreMeta stores message metadata
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) (err error) {
+	defer b.recover("StoreMeta", &err)
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (_ metastorage.MessageMetadata, err error) {
+	defer b.recover("GetMeta", &err)
+	return b.Backend.GetMeta(ctx, messageID)
+}
+
+// UpdateMeta updates message metadata
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) (err error) {
+	defer b.recover("UpdateMeta", &err)
+	return b.Backend.UpdateMeta(ctx, messageID, metadata)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) (err error) {
+	defer b.recover("DeleteMeta", &err)
+	return b.Backend.DeleteMeta(ctx, messageID)
+}
+
+// ListMessages lists messages with pagination and filtering
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, options metastorage.MessageListOptions) (_ metastorage.MessageListResult, err error) {
+	defer b.recover("ListMessages", &err)
+	return b.Backend.ListMessages(ctx, state, options)
+}
+
+// NewMessageIterator creates an iterator whose Next and Close are protected as well
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (_ metastorage.MessageIterator, err error) {
+	defer b.recover("NewMessageIterator", &err)
+	iter, err := b.Backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{MessageIterator: iter, backend: b}, nil
+}
+
+// MoveToState moves a message from one queue state to another atomically
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) (err error) {
+	defer b.recover("MoveToState", &err)
+	return b.Backend.MoveToState(ctx, messageID, fromState, toState)
+}
+
+// Close closes the wrapped backend
+func (b *Backend) Close() (err error) {
+	defer b.recover("Close", &err)
+	return b.Backend.Close()
+}
+
+// GetStateCount returns the cached count of the wrapped backend, or 0 if it panics
+func (b *counterBackend) GetStateCount(state metastorage.QueueState) (count int64) {
+	defer b.recover("GetStateCount", nil)
+	return b.counter.GetStateCount(state)
+}
+
+type iterator struct {
+	metastorage.MessageIterator
+	backend *Backend
+}
+
+func (it *iterator) Next(ctx context.Context) (_ metastorage.MessageMetadata, _ bool, err error) {
+	defer it.backend.recover("MessageIterator.Next", &err)
+	return it.MessageIterator.Next(ctx)
+}
+
+func (it *iterator) Close() (err error) {
+	defer it.backend.recover("MessageIterator.Close", &err)
+	return it.MessageIterator.Close()
+}
@@ -0,0 +1,87 @@
+package sequence
+
+import (
+	"context"
+	"sort"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Gap is a range of missing sequence numbers (inclusive)
+type Gap struct {
+	From, To uint64
+}
+
+// Report is the result of auditing the sequences of one state
+type Report struct {
+	State      metastorage.QueueState
+	Count      int
+	First      uint64
+	Last       uint64
+	Unassigned []string // message IDs without a sequence
+	Duplicates []uint64 // sequence numbers used more than once
+	Gaps       []Gap
+}
+
+// Gaps returns the missing ranges in seqs. seqs need not be sorted; zero
+// (unassigned) values are ignored.
+func Gaps(seqs []uint64) []Gap {
+	sorted := make([]uint64, 0, len(seqs))
+	for _, s := range seqs {
+		if s != 0 {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var gaps []Gap
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] > sorted[i-1]+1 {
+			gaps = append(gaps, Gap{From: sorted[i-1] + 1, To: sorted[i] - 1})
+		}
+	}
+	return gaps
+}
+
+// Audit collects the sequences currently stored in state. Gaps are
+// expected in states messages leave again (a moved message leaves its
+// number behind); in terminal states such as archived, gaps indicate lost
+// or deleted records.
+func Audit(ctx context.Context, backend metastorage.Backend, state metastorage.QueueState, batchSize int) (Report, error) {
+	iter, err := backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return Report{}, err
+	}
+	defer iter.Close()
+
+	r := Report{State: state}
+	seen := make(map[uint64]int)
+	var seqs []uint64
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return Report{}, err
+		}
+		if !more {
+			break
+		}
+		r.Count++
+		if m.Sequence == 0 {
+			r.Unassigned = append(r.Unassigned, m.ID)
+			continue
+		}
+		seen[m.Sequence]++
+		if seen[m.Sequence] == 2 {
+			r.Duplicates = append(r.Duplicates, m.Sequence)
+		}
+		if r.First == 0 || m.Sequence < r.First {
+			r.First = m.Sequence
+		}
+		if m.Sequence > r.Last {
+			r.Last = m.Sequence
+		}
+		seqs = append(seqs, m.Sequence)
+	}
+	sort.Slice(r.Duplicates, func(i, j int) bool { return r.Duplicates[i] < r.Duplicates[j] })
+	r.Gaps = Gaps(seqs)
+	return r, nil
+}
@@ -0,0 +1,131 @@
+// Package sequence assigns MessageMetadata.Sequence for backends that do
+// not implement metastorage.SequenceBackend, enabling strictly ordered FIFO
+// consumption and gap detection. Backends with native sequences assign
+// them inside StoreMeta and MoveToState, so the move and the new sequence
+// are one atomic step.
+//
+// Wrapper-generated sequences are kept in process memory: they are only
+// strictly increasing if all writers of a backend go through the same
+// wrapper instance. After a move, the sequence is written with a separate
+// update, so a concurrent UpdateMeta of the same message can overwrite it.
+// Multi-node deployments should use a backend with native sequences.
+package sequence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// Backend assigns per-state sequence numbers
+type Backend struct {
+	metastorage.Backend
+
+	mu   sync.Mutex
+	last map[metastorage.QueueState]uint64
+}
+
+// New wraps backend with sequence assignment. If backend, or a backend
+// it wraps, implements metastorage.SequenceBackend it is returned
+// unchanged.
+// Otherwise every state is scanned once to continue after the highest
+// sequence already stored.
+func New(ctx context.Context, backend metastorage.Backend, opts ...options.Option) (metastorage.Backend, error) {
+	if _, ok := metastorage.As[metastorage.SequenceBackend](backend); ok {
+		return backend, nil
+	}
+	o := options.Apply(opts...)
+	b := &Backend{Backend: backend, last: make(map[metastorage.QueueState]uint64)}
+	for _, state := range metastorage.States() {
+		highest, err := scanMax(ctx, backend, state, o.BatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("sequence: scan %s: %w", state, err)
+		}
+		b.last[state] = highest
+	}
+	return metastorage.Wrap(backend, b), nil
+}
+
+func scanMax(ctx context.Context, backend metastorage.Backend, state metastorage.QueueState, batchSize int) (uint64, error) {
+	iter, err := backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	var highest uint64
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if !more {
+			return highest, nil
+		}
+		if m.Sequence > highest {
+			highest = m.Sequence
+		}
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+func (b *Backend) next(state metastorage.QueueState) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last[state]++
+	return b.last[state]
+}
+
+// LastSequence returns the highest sequence assigned in state
+func (b *Backend) LastSequence(_ context.Context, state metastorage.QueueState) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last[state], nil
+}
+
+// Recover rescans every state for the highest stored sequence, so
+// numbering continues after messages written since New, e.g. by replayed
+// journals; see metastorage.Recover
+func (b *Backend) Recover(ctx context.Context) error {
+	for _, state := range metastorage.States() {
+		highest, err := scanMax(ctx, b.Backend, state, 0)
+		if err != nil {
+			return fmt.Errorf("sequence: scan %s: %w", state, err)
+		}
+		b.mu.Lock()
+		b.last[state] = max(b.last[state], highest)
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// StoreMeta assigns the next sequence of the message's state and stores it
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata.Sequence = b.next(metadata.State)
+	return b.Backend.StoreMeta(ctx, messageID, metadata)
+}
+
+// MoveToState moves the message and assigns the next sequence of toState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := b.Backend.MoveToState(ctx, messageID, fromState, toState); err != nil {
+		return err
+	}
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("sequence: read moved message: %w", err)
+	}
+	if m.State != toState {
+		return nil // moved again concurrently; that move assigns the sequence
+	}
+	m.Sequence = b.next(toState)
+	if err := b.Backend.UpdateMeta(ctx, messageID, m); err != nil {
+		return fmt.Errorf("sequence: assign: %w", err)
+	}
+	return nil
+}
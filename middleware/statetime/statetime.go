@@ -0,0 +1,116 @@
+// Package statetime records MessageMetadata.StateEnteredAt for backends
+// that do not set it themselves, so the time a message has spent in its
+// current state can be queried and alerted on. Every move also records
+// the time spent in the state left in the MetricDwell histogram, e.g. the
+// incoming to active latency or the deferred dwell time.
+//
+// Backends that implement metastorage.StateTimeBackend set the timestamp
+// inside MoveToState themselves; on them the middleware only observes
+// MetricDwell, reading the entry time before the move. On other backends,
+// like the sequence middleware, the timestamp is written with a separate
+// update after a move, so a concurrent UpdateMeta of the same message can
+// overwrite it with the previous value.
+package statetime
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricDwell observes the seconds a message spent in a state before it was
+// moved, labeled by from and to state. Messages without StateEnteredAt are
+// not observed.
+const MetricDwell = "metastorage_state_dwell_seconds"
+
+// Backend sets StateEnteredAt on StoreMeta and MoveToState
+type Backend struct {
+	metastorage.Backend
+	clock   clock.Clock
+	metrics metrics.Recorder
+	native  bool // the backend sets StateEnteredAt itself
+}
+
+// New wraps backend with state entry tracking
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	st, ok := metastorage.As[metastorage.StateTimeBackend](backend)
+	return &Backend{Backend: backend, clock: o.Clock, metrics: o.Metrics, native: ok && st.TracksStateTime()}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// StoreMeta stores message metadata, setting StateEnteredAt to Created or
+// the current time if it is unset
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	return b.Backend.StoreMeta(ctx, messageID, metastorage.EnterState(metadata, b.clock.Now()))
+}
+
+// MoveToState moves the message, records when it entered toState and
+// observes how long it was in fromState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	now := b.clock.Now().UTC()
+	if b.native {
+		return b.observeMove(ctx, messageID, fromState, toState, now)
+	}
+	if err := b.Backend.MoveToState(ctx, messageID, fromState, toState); err != nil {
+		return err
+	}
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("statetime: read moved message: %w", err)
+	}
+	if m.State != toState {
+		return nil // moved again concurrently; that move records its own time
+	}
+	if !m.StateEnteredAt.IsZero() {
+		b.metrics.Histogram(MetricDwell, metrics.Labels{
+			"from": metastorage.StateLabel(fromState),
+			"to":   metastorage.StateLabel(toState),
+		}, now.Sub(m.StateEnteredAt).Seconds())
+	}
+	m.StateEnteredAt = now
+	if err := b.Backend.UpdateMeta(ctx, messageID, m); err != nil {
+		return fmt.Errorf("statetime: record: %w", err)
+	}
+	return nil
+}
+
+// observeMove moves the message on a backend that records the entry time
+// itself and observes how long it was in fromState, as read before the
+// move
+func (b *Backend) observeMove(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, now time.Time) error {
+	m, err := b.Backend.GetMeta(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if err := b.Backend.MoveToState(ctx, messageID, fromState, toState); err != nil {
+		return err
+	}
+	if m.State == fromState && !m.StateEnteredAt.IsZero() {
+		b.metrics.Histogram(MetricDwell, metrics.Labels{
+			"from": metastorage.StateLabel(fromState),
+			"to":   metastorage.StateLabel(toState),
+		}, now.Sub(m.StateEnteredAt).Seconds())
+	}
+	return nil
+}
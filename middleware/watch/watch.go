@@ -0,0 +1,207 @@
+// Package watch adds change notifications to any backend. The decorator
+// publishes an event after every successful mutation made through it, so
+// it sees all changes only if every writer goes through the same
+// decorated backend, e.g. a central gRPC service.
+package watch
+
+import (
+	"context"
+	"sync"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// DefaultBuffer is the number of events buffered per subscriber
+const DefaultBuffer = 1024
+
+type bufferKey struct{}
+
+// WithBuffer sets the number of events buffered per subscriber (default
+// DefaultBuffer). A subscriber whose buffer is full is dropped.
+func WithBuffer(n int) options.Option {
+	return options.WithValue(bufferKey{}, n)
+}
+
+// Broadcaster fans events out to subscribers. Publish never blocks: a
+// subscriber that cannot keep up has its channel closed, which tells it
+// to resynchronize. Backends with native change feeds can use it to
+// implement Watch.
+type Broadcaster struct {
+	mu     sync.Mutex
+	subs   map[chan metastorage.Event]struct{}
+	buffer int
+	closed bool
+	done   chan struct{}
+}
+
+// NewBroadcaster creates a broadcaster buffering up to buffer events per
+// subscriber
+func NewBroadcaster(buffer int) *Broadcaster {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Broadcaster{subs: make(map[chan metastorage.Event]struct{}), buffer: buffer, done: make(chan struct{})}
+}
+
+// Subscribe returns a channel receiving all events published until ctx is
+// done
+func (b *Broadcaster) Subscribe(ctx context.Context) (<-chan metastorage.Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, metastorage.ErrBackendClosed
+	}
+	ch := make(chan metastorage.Event, b.buffer)
+	b.subs[ch] = struct{}{}
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.remove(ch)
+		case <-b.done:
+		}
+	}()
+	return ch, nil
+}
+
+func (b *Broadcaster) remove(ch chan metastorage.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish delivers e to all subscribers
+func (b *Broadcaster) Publish(e metastorage.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// lagging subscriber: drop it rather than block writers
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscribers
+func (b *Broadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Close closes all subscriber channels and rejects new subscriptions
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.done)
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publisher accepts events produced outside of backend mutations, e.g. by
+// the lease expiry monitor, and delivers them to the watchers
+type Publisher interface {
+	Publish(e metastorage.Event)
+}
+
+// Backend publishes events for mutations of the wrapped backend
+type Backend struct {
+	metastorage.Backend
+	hub   *Broadcaster
+	clock clock.Clock
+}
+
+// New wraps backend with change notifications. The result implements
+// metastorage.WatchBackend.
+func New(backend metastorage.Backend, opts ...options.Option) metastorage.Backend {
+	return metastorage.Wrap(backend, newBackend(backend, opts))
+}
+
+// Middleware returns a metastorage.Middleware that applies New
+func Middleware(opts ...options.Option) metastorage.Middleware {
+	return func(b metastorage.Backend) metastorage.Backend {
+		return newBackend(b, opts)
+	}
+}
+
+func newBackend(backend metastorage.Backend, opts []options.Option) *Backend {
+	o := options.Apply(opts...)
+	return &Backend{
+		Backend: backend,
+		hub:     NewBroadcaster(options.ValueOr(o, bufferKey{}, DefaultBuffer)),
+		clock:   o.Clock,
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// Watch subscribes to changes made through b
+func (b *Backend) Watch(ctx context.Context) (<-chan metastorage.Event, error) {
+	return b.hub.Subscribe(ctx)
+}
+
+// Publish delivers e to all watchers. A zero e.Time is set to now.
+func (b *Backend) Publish(e metastorage.Event) {
+	if e.Time.IsZero() {
+		e.Time = b.clock.Now()
+	}
+	b.hub.Publish(e)
+}
+
+// StoreMeta stores message metadata
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := b.Backend.StoreMeta(ctx, messageID, metadata); err != nil {
+		return err
+	}
+	b.Publish(metastorage.Event{Type: metastorage.EventStored, MessageID: messageID, State: metadata.State})
+	return nil
+}
+
+// UpdateMeta updates message metadata
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := b.Backend.UpdateMeta(ctx, messageID, metadata); err != nil {
+		return err
+	}
+	b.Publish(metastorage.Event{Type: metastorage.EventUpdated, MessageID: messageID, State: metadata.State})
+	return nil
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	if err := b.Backend.DeleteMeta(ctx, messageID); err != nil {
+		return err
+	}
+	b.Publish(metastorage.Event{Type: metastorage.EventDeleted, MessageID: messageID})
+	return nil
+}
+
+// MoveToState moves a message between states with CAS semantics
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	if err := b.Backend.MoveToState(ctx, messageID, fromState, toState); err != nil {
+		return err
+	}
+	b.Publish(metastorage.Event{Type: metastorage.EventMoved, MessageID: messageID, State: toState, From: fromState})
+	return nil
+}
+
+// Close closes all watches and the wrapped backend
+func (b *Backend) Close() error {
+	b.hub.Close()
+	return b.Backend.Close()
+}
@@ -0,0 +1,103 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongooptions "go.mongodb.org/mongo-driver/mongo/options"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// changeEvent is the part of a change stream event the backend reads
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		Key key `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      *document `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields struct {
+			State         *metastorage.QueueState `bson:"state"`
+			PreviousState *metastorage.QueueState `bson:"previousState"`
+		} `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+	WallTime    time.Time           `bson:"wallTime"` // MongoDB 6.0 and later
+}
+
+// event converts c, reporting false for operations that are not changes
+// of one message
+func (c *changeEvent) event() (metastorage.Event, bool) {
+	e := metastorage.Event{MessageID: c.DocumentKey.Key.ID, Time: c.WallTime}
+	if e.Time.IsZero() {
+		e.Time = time.Unix(int64(c.ClusterTime.T), 0).UTC()
+	}
+	switch c.OperationType {
+	case "insert", "replace":
+		e.Type = metastorage.EventStored
+		if c.FullDocument != nil {
+			e.State = c.FullDocument.State
+		}
+	case "update":
+		fields := c.UpdateDescription.UpdatedFields
+		if fields.State != nil {
+			e.Type = metastorage.EventMoved
+			e.State = *fields.State
+			if fields.PreviousState != nil {
+				e.From = *fields.PreviousState
+			}
+			break
+		}
+		e.Type = metastorage.EventUpdated
+		if c.FullDocument != nil {
+			e.State = c.FullDocument.State
+		}
+	case "delete":
+		e.Type = metastorage.EventDeleted
+	default:
+		return metastorage.Event{}, false
+	}
+	return e, true
+}
+
+// Watch delivers events for changes of the backend's namespace from a
+// change stream, which requires a replica set or sharded cluster.
+// StoreMeta is reported as EventStored, UpdateMeta as EventUpdated and
+// MoveToState as EventMoved, including changes made by other nodes. The
+// channel is closed when ctx is done or the stream fails or is
+// invalidated, e.g. by dropping the collection.
+func (b *Backend) Watch(ctx context.Context) (<-chan metastorage.Event, error) {
+	pipeline := bson.A{bson.D{{Key: "$match", Value: bson.D{{Key: "documentKey._id.ns", Value: b.namespace}}}}}
+	// the looked up document supplies the state of updates
+	stream, err := b.coll.Watch(ctx, pipeline, mongooptions.ChangeStream().SetFullDocument(mongooptions.UpdateLookup))
+	if err != nil {
+		return nil, translate(err)
+	}
+	events := make(chan metastorage.Event)
+	go func() {
+		defer close(events)
+		defer stream.Close(context.WithoutCancel(ctx))
+		for stream.Next(ctx) {
+			var c changeEvent
+			if err := stream.Decode(&c); err != nil {
+				return
+			}
+			e, ok := c.event()
+			if !ok {
+				if c.OperationType == "invalidate" {
+					return
+				}
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,71 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MultiGetBackend is implemented by backends that read several messages
+// in one round trip, e.g. with a single WHERE id IN (...) query
+type MultiGetBackend interface {
+	Backend
+
+	// GetMetaMulti reads the messages of ids, see the GetMetaMulti
+	// function
+	GetMetaMulti(ctx context.Context, ids []string) (map[string]MessageMetadata, []string, error)
+}
+
+// GetMetaMulti reads the messages of ids, e.g. for reconciliation or
+// detail pages showing many messages at once. Found messages are returned
+// by ID; IDs that are not stored are listed in missing, in the order of
+// ids, so callers can tell unknown messages from failures. Duplicate IDs
+// are read once.
+//
+// If the outermost layer of b implements MultiGetBackend it answers;
+// otherwise every ID is read with GetMeta, so decorators see every read.
+// Failures of single IDs do not stop the others: err joins them, each
+// naming its ID, while found and missing hold the results of the IDs that
+// were read.
+func GetMetaMulti(ctx context.Context, b Backend, ids []string) (found map[string]MessageMetadata, missing []string, err error) {
+	if m, ok := Outer[MultiGetBackend](b); ok {
+		return m.GetMetaMulti(ctx, ids)
+	}
+	found = make(map[string]MessageMetadata, len(ids))
+	seen := make(map[string]bool, len(ids))
+	var errs []error
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := ctx.Err(); err != nil {
+			return found, missing, errors.Join(append(errs, err)...)
+		}
+		m, err := b.GetMeta(ctx, id)
+		switch {
+		case errors.Is(err, ErrMessageNotFound):
+			missing = append(missing, id)
+		case err != nil:
+			errs = append(errs, fmt.Errorf("get %s: %w", id, err))
+		default:
+			found[id] = m
+		}
+	}
+	return found, missing, errors.Join(errs...)
+}
+
+// MissingIDs returns the IDs of ids without an entry in found, in the
+// order of ids and without duplicates. Backends implementing
+// MultiGetBackend use it to report missing IDs.
+func MissingIDs(ids []string, found map[string]MessageMetadata) []string {
+	var missing []string
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := found[id]; !ok && !seen[id] {
+			seen[id] = true
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,43 @@
+package metastorage_test
+
+import (
+	"context"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+// countingReads is a decorator counting GetMeta calls
+type countingReads struct {
+	metastorage.Backend
+	reads int
+}
+
+func (c *countingReads) GetMeta(ctx context.Context, id string) (metastorage.MessageMetadata, error) {
+	c.reads++
+	return c.Backend.GetMeta(ctx, id)
+}
+
+func (c *countingReads) Unwrap() metastorage.Backend {
+	return c.Backend
+}
+
+func TestGetMetaMultiThroughDecorator(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	if err := inner.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming}); err != nil {
+		t.Fatal(err)
+	}
+	c := &countingReads{Backend: inner}
+	found, missing, err := metastorage.GetMetaMulti(ctx, c, []string{"m1", "m2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || len(missing) != 1 || missing[0] != "m2" {
+		t.Fatalf("found %v, missing %v; want m1 found and m2 missing", found, missing)
+	}
+	if c.reads != 2 {
+		t.Fatalf("decorator saw %d reads, want 2", c.reads)
+	}
+}
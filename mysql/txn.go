@@ -0,0 +1,42 @@
+package mysql
+
+import (
+	"context"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Begin starts a transaction committed in one database transaction, see
+// metastorage.TxnBackend
+func (b *Backend) Begin(ctx context.Context) (metastorage.Txn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metastorage.NewTxn(b.commit), nil
+}
+
+// commit runs ops in a database transaction; a failing operation rolls
+// back all of them
+func (b *Backend) commit(ctx context.Context, ops []metastorage.TxnOp) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+	for i, op := range ops {
+		switch op.Kind {
+		case metastorage.TxnStore:
+			err = b.store(ctx, tx, op.ID, op.Metadata)
+		case metastorage.TxnUpdate:
+			err = b.update(ctx, tx, op.ID, op.Metadata)
+		case metastorage.TxnMove:
+			err = b.moveIn(ctx, tx, op.ID, op.From, op.To, 0)
+		case metastorage.TxnDelete:
+			err = b.delete(ctx, tx, op.ID)
+		}
+		if err != nil {
+			return op.Err(i, err)
+		}
+	}
+	return translate(tx.Commit())
+}
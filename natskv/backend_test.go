@@ -0,0 +1,29 @@
+package natskv
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/metatest"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// TestMoveRace runs against the server in META_TEST_NATS_DSN, e.g.
+// "nats://localhost:4222/metatest"; every subtest uses a namespace of its
+// own.
+func TestMoveRace(t *testing.T) {
+	dsn := os.Getenv("META_TEST_NATS_DSN")
+	if dsn == "" {
+		t.Skip("META_TEST_NATS_DSN not set")
+	}
+	metatest.RunMoveRaceSuite(t, func(t *testing.T) metastorage.Backend {
+		b, err := registry.Open(context.Background(), dsn, options.WithNamespace(metatest.Namespace()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
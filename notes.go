@@ -0,0 +1,95 @@
+package metastorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HeaderNotes holds the JSON encoded operator notes of a message
+const HeaderNotes = ReservedHeaderPrefix + "notes"
+
+// ErrEmptyNote is returned when a note has no text
+var ErrEmptyNote = errors.New("note text is empty")
+
+// Note is a free-form operator annotation of a message
+type Note struct {
+	Author  string    `json:"author"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+// NoteBackend extends Backend with native storage of operator notes
+type NoteBackend interface {
+	Backend
+
+	// AddNote appends a note to a message
+	AddNote(ctx context.Context, messageID, author, text string) error
+
+	// Notes returns the notes of a message, oldest first
+	Notes(ctx context.Context, messageID string) ([]Note, error)
+}
+
+// NotesOf decodes the notes stored in m's HeaderNotes header
+func NotesOf(m MessageMetadata) ([]Note, error) {
+	raw, ok := m.Headers[HeaderNotes]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	var notes []Note
+	if err := json.Unmarshal([]byte(raw), &notes); err != nil {
+		return nil, fmt.Errorf("decode notes of %s: %w", m.ID, err)
+	}
+	return notes, nil
+}
+
+// AddNote stores an operator note next to the message's metadata, so
+// on-call handoffs about a problematic message travel with it. If the
+// outermost layer of b implements NoteBackend it stores the note natively;
+// otherwise the notes are kept JSON encoded in the HeaderNotes header with
+// a read-modify-write, which fails with ErrVersionConflict on backends
+// tracking versions if the message changed in between.
+func AddNote(ctx context.Context, b Backend, messageID, author, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrEmptyNote
+	}
+	if n, ok := Outer[NoteBackend](b); ok {
+		return n.AddNote(ctx, messageID, author, text)
+	}
+	m, err := b.GetMeta(WithConsistency(ctx, ConsistencyStrong), messageID)
+	if err != nil {
+		return err
+	}
+	notes, err := NotesOf(m)
+	if err != nil {
+		return err
+	}
+	notes = append(notes, Note{Author: author, Text: text, Created: time.Now().UTC()})
+	encoded, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string, len(m.Headers)+1)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[HeaderNotes] = string(encoded)
+	m.Headers = headers
+	return b.UpdateMeta(ctx, messageID, m)
+}
+
+// Notes returns the operator notes of a message, oldest first. If the
+// outermost layer of b implements NoteBackend it reads them natively.
+func Notes(ctx context.Context, b Backend, messageID string) ([]Note, error) {
+	if n, ok := Outer[NoteBackend](b); ok {
+		return n.Notes(ctx, messageID)
+	}
+	m, err := b.GetMeta(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return NotesOf(m)
+}
@@ -0,0 +1,145 @@
+// Package options implements the functional options shared by all backend
+// constructors and wrappers of this module.
+//
+// Every constructor accepts ...Option. Common settings have dedicated
+// fields; package specific settings are attached with WithValue and read
+// back with Value, so all options of a storage stack can be passed around
+// as a single list.
+package options
+
+import (
+	"log/slog"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/codec"
+	"schneider.vip/retryspool/storage/meta/metrics"
+)
+
+// DefaultBatchSize is used when no batch size is configured
+const DefaultBatchSize = 100
+
+// Options holds the resolved settings
+type Options struct {
+	BatchSize int              // Default batch size for iterators and bulk reads
+	Namespace string           // Key prefix / tenant isolating this backend's data; also labels all metrics
+	Clock     clock.Clock      // Time source
+	ClockSkew time.Duration    // Tolerated clock difference for due/visibility checks
+	Codec     codec.Codec      // Serialization for byte oriented stores
+	Logger    *slog.Logger     // Logger for diagnostics
+	Metrics   metrics.Recorder // Metric sink
+
+	values map[any]any
+}
+
+// Option configures Options
+type Option func(*Options)
+
+// Apply resolves opts on top of the defaults
+func Apply(opts ...Option) Options {
+	o := Options{
+		BatchSize: DefaultBatchSize,
+		Clock:     clock.System,
+		ClockSkew: clock.DefaultSkewTolerance,
+		Codec:     codec.JSON,
+		Logger:    slog.Default(),
+		Metrics:   metrics.Nop,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	if o.Namespace != "" {
+		// per-tenant series; aggregate across tenants by dropping the label
+		o.Metrics = metrics.WithLabels(o.Metrics, metrics.Labels{metrics.LabelNamespace: o.Namespace})
+	}
+	return o
+}
+
+// WithBatchSize sets the default batch size. Values <= 0 are ignored.
+func WithBatchSize(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.BatchSize = n
+		}
+	}
+}
+
+// WithNamespace sets the namespace
+func WithNamespace(namespace string) Option {
+	return func(o *Options) {
+		o.Namespace = namespace
+	}
+}
+
+// WithClock sets the time source
+func WithClock(c clock.Clock) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Clock = c
+		}
+	}
+}
+
+// WithClockSkew sets the skew window applied when deciding whether a
+// message is due or a lease has expired. Negative values are ignored.
+func WithClockSkew(d time.Duration) Option {
+	return func(o *Options) {
+		if d >= 0 {
+			o.ClockSkew = d
+		}
+	}
+}
+
+// WithCodec sets the metadata codec
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		if c != nil {
+			o.Codec = c
+		}
+	}
+}
+
+// WithLogger sets the logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		if logger != nil {
+			o.Logger = logger
+		}
+	}
+}
+
+// WithMetrics sets the metric recorder
+func WithMetrics(recorder metrics.Recorder) Option {
+	return func(o *Options) {
+		if recorder != nil {
+			o.Metrics = recorder
+		}
+	}
+}
+
+// WithValue attaches a package specific setting. Packages should use an
+// unexported key type and expose their own WithXxx helper built on this.
+func WithValue(key, value any) Option {
+	return func(o *Options) {
+		if o.values == nil {
+			o.values = make(map[any]any)
+		}
+		o.values[key] = value
+	}
+}
+
+// Value returns the package specific setting stored under key
+func Value[T any](o Options, key any) (T, bool) {
+	v, ok := o.values[key].(T)
+	return v, ok
+}
+
+// ValueOr returns the package specific setting stored under key, or def if unset
+func ValueOr[T any](o Options, key any, def T) T {
+	if v, ok := Value[T](o, key); ok {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,118 @@
+package metastorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MetadataPatch is a partial update of a message: only the fields set are
+// written, nil fields keep their stored value. State, Sequence and
+// StateEnteredAt are not patched; they change with MoveToState.
+type MetadataPatch struct {
+	Attempts        *int
+	AddAttempts     int // Added to Attempts after setting it, e.g. 1 for a failed delivery
+	MaxAttempts     *int
+	NextRetry       *time.Time
+	Updated         *time.Time
+	LastError       *string
+	Size            *int64
+	Priority        *int
+	RetryPolicyName *string
+	DeliveryWindow  *DeliveryWindow
+	Headers         map[string]string // Merged into the stored headers
+	RemoveHeaders   []string          // Removed from the stored headers after merging Headers
+
+	// IfState makes the patch fail with ErrStateConflict unless the
+	// message is in this state, e.g. to record a failure only while the
+	// message is still active
+	IfState *QueueState
+}
+
+// Apply returns m with the fields of p written. The headers of m are
+// copied, not modified.
+func (p MetadataPatch) Apply(m MessageMetadata) MessageMetadata {
+	if p.Attempts != nil {
+		m.Attempts = *p.Attempts
+	}
+	m.Attempts += p.AddAttempts
+	if p.MaxAttempts != nil {
+		m.MaxAttempts = *p.MaxAttempts
+	}
+	if p.NextRetry != nil {
+		m.NextRetry = *p.NextRetry
+	}
+	if p.Updated != nil {
+		m.Updated = *p.Updated
+	}
+	if p.LastError != nil {
+		m.LastError = *p.LastError
+	}
+	if p.Size != nil {
+		m.Size = *p.Size
+	}
+	if p.Priority != nil {
+		m.Priority = *p.Priority
+	}
+	if p.RetryPolicyName != nil {
+		m.RetryPolicyName = *p.RetryPolicyName
+	}
+	if p.DeliveryWindow != nil {
+		m.DeliveryWindow = *p.DeliveryWindow
+	}
+	if len(p.Headers) > 0 || len(p.RemoveHeaders) > 0 {
+		headers := make(map[string]string, len(m.Headers)+len(p.Headers))
+		for k, v := range m.Headers {
+			headers[k] = v
+		}
+		for k, v := range p.Headers {
+			headers[k] = v
+		}
+		for _, k := range p.RemoveHeaders {
+			delete(headers, k)
+		}
+		m.Headers = headers
+	}
+	return m
+}
+
+// Check returns ErrStateConflict if p.IfState is set and m is in another
+// state
+func (p MetadataPatch) Check(m MessageMetadata) error {
+	if p.IfState != nil && m.State != *p.IfState {
+		return fmt.Errorf("%w: %s is %s, expected %s", ErrStateConflict, m.ID, m.State, *p.IfState)
+	}
+	return nil
+}
+
+// PatchBackend is implemented by backends that write a MetadataPatch
+// atomically, e.g. as one UPDATE of the set columns, so concurrent patches
+// of different fields do not overwrite each other
+type PatchBackend interface {
+	Backend
+
+	// UpdateMetaFields writes the fields set in patch; unknown messages
+	// fail with ErrMessageNotFound
+	UpdateMetaFields(ctx context.Context, messageID string, patch MetadataPatch) error
+}
+
+// UpdateMetaFields writes the fields set in patch to a message, sparing
+// callers the read-modify-write of UpdateMeta. If the outermost layer of b
+// implements PatchBackend the patch is written atomically; otherwise it is
+// read with GetMeta, with ConsistencyStrong so no cache serves it, and
+// written back with UpdateMeta, which can lose updates made in between by
+// other writers, or fails with ErrVersionConflict on backends tracking
+// versions.
+func UpdateMetaFields(ctx context.Context, b Backend, messageID string, patch MetadataPatch) error {
+	if pb, ok := Outer[PatchBackend](b); ok {
+		return pb.UpdateMetaFields(ctx, messageID, patch)
+	}
+	m, err := b.GetMeta(WithConsistency(ctx, ConsistencyStrong), messageID)
+	if err != nil {
+		return err
+	}
+	if err := patch.Check(m); err != nil {
+		return err
+	}
+	return b.UpdateMeta(ctx, messageID, patch.Apply(m))
+}
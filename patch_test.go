@@ -0,0 +1,61 @@
+package metastorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func TestUpdateMetaFields(t *testing.T) {
+	for name, b := range map[string]metastorage.Backend{
+		"native":   memory.New(),
+		"fallback": plain{memory.New()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			m := metastorage.MessageMetadata{
+				ID: "m1", State: metastorage.StateActive, Attempts: 2, Priority: 5,
+				Headers: map[string]string{"x-keep": "1", "x-drop": "1"},
+			}
+			if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+				t.Fatal(err)
+			}
+
+			lastError := "451 try later"
+			active := metastorage.StateActive
+			err := metastorage.UpdateMetaFields(ctx, b, "m1", metastorage.MetadataPatch{
+				AddAttempts:   1,
+				LastError:     &lastError,
+				Headers:       map[string]string{"x-new": "1"},
+				RemoveHeaders: []string{"x-drop"},
+				IfState:       &active,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := b.GetMeta(ctx, "m1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Attempts != 3 || got.LastError != lastError || got.Priority != 5 {
+				t.Errorf("patched %+v", got)
+			}
+			if len(got.Headers) != 2 || got.Headers["x-keep"] != "1" || got.Headers["x-new"] != "1" {
+				t.Errorf("headers %v", got.Headers)
+			}
+
+			deferred := metastorage.StateDeferred
+			err = metastorage.UpdateMetaFields(ctx, b, "m1", metastorage.MetadataPatch{AddAttempts: 1, IfState: &deferred})
+			if !errors.Is(err, metastorage.ErrStateConflict) {
+				t.Errorf("patch of other state: %v, want state conflict", err)
+			}
+			err = metastorage.UpdateMetaFields(ctx, b, "unknown", metastorage.MetadataPatch{AddAttempts: 1})
+			if !errors.Is(err, metastorage.ErrMessageNotFound) {
+				t.Errorf("patch of unknown message: %v, want not found", err)
+			}
+		})
+	}
+}
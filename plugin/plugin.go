@@ -0,0 +1,312 @@
+// Package plugin loads backends shipped as executables of their own, so
+// proprietary or heavyweight backends can be built and released apart
+// from this module and picked by DSN at runtime.
+//
+// A plugin is a program calling Serve with a function opening its
+// backend. The host starts it as a subprocess and speaks the HTTP/JSON
+// protocol of package httpbackend to it over loopback TCP:
+//
+//  1. The host starts the executable with the magic cookie, the DSN for
+//     the plugin's backend and a random token in the environment.
+//  2. The plugin opens its backend, listens on a free loopback port and
+//     writes the handshake line "retryspool-meta-plugin|1|tcp|<addr>" to
+//     stdout.
+//  3. The host sends every request with the token; the plugin rejects
+//     requests without it.
+//  4. Close ends the plugin with an interrupt, killing it after the stop
+//     timeout. Plugins also exit when their stdin closes, so they do not
+//     outlive a crashed host.
+//
+// Everything else the plugin writes to stdout or stderr is logged by the
+// host. Calls reaching a plugin that exited fail with connection errors,
+// which metastorage.IsRetryable reports as transient.
+//
+// Importing the package registers the "plugin://" DSN scheme:
+// "plugin:///opt/retryspool/oracle-meta?dsn=oracle://db/spool" runs the
+// executable at the path, "plugin://oracle-meta?dsn=..." looks the name
+// up in PATH. The dsn parameter is passed to the plugin unchanged.
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/httpbackend"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// ProtocolVersion is the version of the handshake and wire protocol. A
+// host only talks to plugins announcing the same version.
+const ProtocolVersion = 1
+
+// Environment passed to plugins
+const (
+	EnvCookie = "RETRYSPOOL_META_PLUGIN"       // set to Cookie
+	EnvDSN    = "RETRYSPOOL_META_PLUGIN_DSN"   // DSN of the plugin's backend
+	EnvToken  = "RETRYSPOOL_META_PLUGIN_TOKEN" // token of the host's requests
+)
+
+// Cookie marks a process started by a host; it is no secret, but keeps
+// plugins from being run by accident
+const Cookie = "9c3b1f5e-retryspool-meta"
+
+// handshakePrefix starts the first line a plugin writes to stdout
+const handshakePrefix = "retryspool-meta-plugin"
+
+// Defaults
+const (
+	DefaultStartTimeout = 10 * time.Second
+	DefaultStopTimeout  = 5 * time.Second
+)
+
+type (
+	startTimeoutKey struct{}
+	stopTimeoutKey  struct{}
+	argsKey         struct{}
+)
+
+// WithStartTimeout sets how long a plugin may take to open its backend
+// and complete the handshake (default DefaultStartTimeout)
+func WithStartTimeout(d time.Duration) options.Option {
+	return options.WithValue(startTimeoutKey{}, d)
+}
+
+// WithStopTimeout sets how long Close waits for an interrupted plugin to
+// exit before killing it (default DefaultStopTimeout)
+func WithStopTimeout(d time.Duration) options.Option {
+	return options.WithValue(stopTimeoutKey{}, d)
+}
+
+// WithArgs sets command line arguments of the plugin
+func WithArgs(args ...string) options.Option {
+	return options.WithValue(argsKey{}, args)
+}
+
+func init() {
+	registry.Register("plugin", open)
+}
+
+// open handles "plugin:///path?dsn=..." and "plugin://name?dsn=..." DSNs.
+// The start_timeout and stop_timeout parameters set WithStartTimeout and
+// WithStopTimeout.
+func open(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	path := dsn.Path
+	if dsn.Host != "" {
+		path = dsn.Host + dsn.Path
+	}
+	if path == "" {
+		return nil, errors.New("plugin DSN: executable is missing")
+	}
+	q := dsn.Query()
+	for param, opt := range map[string]func(time.Duration) options.Option{
+		"start_timeout": WithStartTimeout,
+		"stop_timeout":  WithStopTimeout,
+	} {
+		if v := q.Get(param); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("plugin DSN: invalid %s %q", param, v)
+			}
+			opts = append(opts, opt(d))
+		}
+	}
+	return Open(ctx, path, q.Get("dsn"), opts...)
+}
+
+// Backend is a backend served by a plugin process
+type Backend struct {
+	metastorage.Backend
+	cmd         *exec.Cmd
+	stdin       io.Closer
+	stopTimeout time.Duration
+	logger      *slog.Logger
+
+	stderr sync.WaitGroup // forwarding stderr, done before waiting for the process
+	exited chan struct{}  // closed when the process exited
+	err    error          // of the process, set before exited is closed
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Open starts the plugin executable at path, or found in PATH, and
+// connects to the backend it opens for dsn. Options are passed to the
+// httpbackend client, e.g. httpbackend.WithRetries.
+func Open(ctx context.Context, path, dsn string, opts ...options.Option) (metastorage.Backend, error) {
+	o := options.Apply(opts...)
+	exe, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin: %w", err)
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, options.ValueOr[[]string](o, argsKey{}, nil)...)
+	cmd.Env = append(os.Environ(), EnvCookie+"="+Cookie, EnvDSN+"="+dsn, EnvToken+"="+token)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	logger := o.Logger.With(slog.String("plugin", exe))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin: %w", err)
+	}
+	b := &Backend{
+		cmd:         cmd,
+		stdin:       stdin,
+		stopTimeout: options.ValueOr(o, stopTimeoutKey{}, DefaultStopTimeout),
+		logger:      logger,
+		exited:      make(chan struct{}),
+	}
+	b.stderr.Add(1)
+	go func() {
+		defer b.stderr.Done()
+		forward(stderr, logger)
+	}()
+
+	addr, err := b.handshake(ctx, stdout, options.ValueOr(o, startTimeoutKey{}, DefaultStartTimeout))
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	client, err := httpbackend.New("http://"+addr, append(opts, httpbackend.WithHeader("Authorization", "Bearer "+token))...)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.Backend = client
+	return metastorage.Wrap(client, b), nil
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("plugin: token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handshake reads the address of the plugin from the first line of
+// stdout and forwards the rest to the log. It starts waiting for the
+// process, which must not happen before its output was read.
+func (b *Backend) handshake(ctx context.Context, stdout io.Reader, timeout time.Duration) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	lines := bufio.NewReader(stdout)
+	first := make(chan result, 1)
+	go func() {
+		line, err := lines.ReadString('\n')
+		first <- result{strings.TrimSpace(line), err}
+		if err == nil {
+			forward(lines, b.logger)
+		}
+		b.stderr.Wait()
+		b.err = b.cmd.Wait()
+		close(b.exited)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var r result
+	select {
+	case r = <-first:
+	case <-timer.C:
+		return "", fmt.Errorf("plugin: no handshake within %s", timeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if r.err != nil {
+		<-b.exited
+		return "", fmt.Errorf("plugin: exited before the handshake: %v", b.err)
+	}
+	parts := strings.Split(r.line, "|")
+	switch {
+	case len(parts) != 4 || parts[0] != handshakePrefix:
+		return "", fmt.Errorf("plugin: invalid handshake %q", r.line)
+	case parts[1] != fmt.Sprint(ProtocolVersion):
+		return "", fmt.Errorf("plugin: protocol version %s, host speaks %d", parts[1], ProtocolVersion)
+	case parts[2] != "tcp":
+		return "", fmt.Errorf("plugin: unsupported network %q", parts[2])
+	}
+	return parts[3], nil
+}
+
+// forward logs the lines of r
+func forward(r io.Reader, logger *slog.Logger) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logger.Info(scanner.Text())
+	}
+}
+
+// Unwrap returns the client of the plugin
+func (b *Backend) Unwrap() metastorage.Backend {
+	return b.Backend
+}
+
+// GetStateCount returns the number of messages in state, counted by the
+// backend of the plugin
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	return b.Backend.(metastorage.StateCounterBackend).GetStateCount(state)
+}
+
+// Exited returns a channel closed when the plugin process exited
+func (b *Backend) Exited() <-chan struct{} {
+	return b.exited
+}
+
+// Close closes the client and ends the plugin, which closes its backend.
+// A plugin not exiting within the stop timeout is killed.
+func (b *Backend) Close() error {
+	b.closeOnce.Do(func() {
+		if b.Backend != nil {
+			b.Backend.Close()
+		}
+		b.stdin.Close()
+		// not supported on Windows, where the closed stdin ends the plugin
+		b.cmd.Process.Signal(os.Interrupt)
+		timer := time.NewTimer(b.stopTimeout)
+		defer timer.Stop()
+		select {
+		case <-b.exited:
+		case <-timer.C:
+			b.logger.Warn("plugin did not exit, killing it", slog.Duration("timeout", b.stopTimeout))
+			b.cmd.Process.Kill()
+			<-b.exited
+			b.closeErr = fmt.Errorf("plugin: killed after %s", b.stopTimeout)
+			return
+		}
+		var exit *exec.ExitError
+		if b.err != nil && !errors.As(b.err, &exit) {
+			b.closeErr = b.err
+		} else if exit != nil && exit.Exited() {
+			b.closeErr = fmt.Errorf("plugin: %w", exit)
+		}
+	})
+	return b.closeErr
+}
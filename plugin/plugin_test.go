@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// TestMain turns the test binary into a plugin serving a memory backend
+// when a test starts it as one
+func TestMain(m *testing.M) {
+	if IsPlugin() {
+		err := Serve(func(ctx context.Context, dsn string) (metastorage.Backend, error) {
+			if dsn != "memory://" {
+				return nil, fmt.Errorf("unexpected DSN %q", dsn)
+			}
+			return memory.New(), nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPlugin(t *testing.T) {
+	ctx := context.Background()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := registry.Open(ctx, "plugin://"+exe+"?dsn=memory://&stop_timeout=10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming, Headers: map[string]string{"x": "1"}}
+	if err := b.StoreMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive); err != nil {
+		t.Fatal(err)
+	}
+	err = b.MoveToState(ctx, "m1", metastorage.StateIncoming, metastorage.StateActive)
+	if !errors.Is(err, metastorage.ErrStateConflict) {
+		t.Fatalf("second move: %v, want state conflict", err)
+	}
+	got, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != metastorage.StateActive || got.Headers["x"] != "1" || got.Version != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if counter, ok := b.(metastorage.StateCounterBackend); !ok || counter.GetStateCount(metastorage.StateActive) != 1 {
+		t.Errorf("state count not forwarded")
+	}
+
+	p, ok := metastorage.As[*Backend](b)
+	if !ok {
+		t.Fatal("plugin backend not found")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Exited():
+	case <-time.After(time.Second):
+		t.Fatal("plugin still running after Close")
+	}
+}
+
+func TestServeOutsideHost(t *testing.T) {
+	if err := Serve(nil); !errors.Is(err, ErrNotPlugin) {
+		t.Fatalf("Serve: %v, want ErrNotPlugin", err)
+	}
+}
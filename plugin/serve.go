@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/httpbackend"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// ErrNotPlugin is returned by Serve for processes not started by a host
+var ErrNotPlugin = errors.New("plugin: not started by a host; load it with a plugin:// DSN")
+
+// IsPlugin reports whether the process was started by a host
+func IsPlugin() bool {
+	return os.Getenv(EnvCookie) == Cookie
+}
+
+// Serve runs the plugin side: it opens the backend for the DSN the host
+// passed, serves it until the host closes it or exits, and closes it.
+// Options are passed to the httpbackend.Handler. A plugin's main is
+// typically:
+//
+//	func main() {
+//		if err := plugin.Serve(oracle.Open); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// Log to stderr; stdout carries the handshake.
+func Serve(open func(ctx context.Context, dsn string) (metastorage.Backend, error), opts ...options.Option) error {
+	if !IsPlugin() {
+		return ErrNotPlugin
+	}
+	token := os.Getenv(EnvToken)
+	if token == "" {
+		return errors.New("plugin: token is missing")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// a closed stdin means the host is gone
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		stop()
+	}()
+
+	backend, err := open(ctx, os.Getenv(EnvDSN))
+	if err != nil {
+		return fmt.Errorf("plugin: open backend: %w", err)
+	}
+	defer backend.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("plugin: %w", err)
+	}
+	handler := httpbackend.NewHandler(backend, opts...)
+	defer handler.Close()
+	srv := &http.Server{Handler: authorize(handler, token)}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	if _, err := fmt.Fprintf(os.Stdout, "%s|%d|tcp|%s\n", handshakePrefix, ProtocolVersion, ln.Addr()); err != nil {
+		srv.Close()
+		return fmt.Errorf("plugin: handshake: %w", err)
+	}
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	return srv.Shutdown(context.Background())
+}
+
+// authorize rejects requests without the host's token
+func authorize(next http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
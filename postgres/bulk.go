@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// MoveStateBulk moves the matching messages of fromState with one UPDATE
+// that takes a block of sequences of toState, see
+// metastorage.BulkMoveBackend. Without filter no message leaves the
+// database; with one, the messages are read and locked first in the same
+// transaction and only the IDs of the matching ones are sent back.
+func (b *Backend) MoveStateBulk(ctx context.Context, fromState, toState metastorage.QueueState, filter func(metastorage.MessageMetadata) bool) (int, error) {
+	if fromState == toState {
+		return 0, ctx.Err()
+	}
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return 0, translate(err)
+	}
+	defer tx.Rollback(ctx)
+	var ids []string // nil is all
+	if filter != nil {
+		rows, err := tx.Query(ctx, `SELECT `+columns+` FROM `+b.table+`
+			WHERE namespace = $1 AND state = $2 FOR UPDATE`,
+			b.namespace, int16(fromState))
+		if err != nil {
+			return 0, translate(err)
+		}
+		ms, err := collect(rows)
+		if err != nil {
+			return 0, err
+		}
+		ids = []string{}
+		for _, m := range ms {
+			if filter(m) {
+				ids = append(ids, m.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return 0, nil
+		}
+	}
+	tag, err := tx.Exec(ctx, b.bulkMoveSQL(), b.namespace, int16(fromState), int16(toState), b.clock.Now().UTC(), ids)
+	if err != nil {
+		return 0, translate(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, translate(err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// bulkMoveSQL locks the messages of a state, or those of an ID array if
+// it is not NULL, takes as many sequences of the new state as there are
+// and moves them, numbered in their old order. It takes the namespace,
+// the old and the new state, the time they enter it and the IDs.
+func (b *Backend) bulkMoveSQL() string {
+	return `WITH moving AS (
+			SELECT id, sequence FROM ` + b.table + `
+			WHERE namespace = $1 AND state = $2 AND ($5::text[] IS NULL OR id = ANY($5))
+			ORDER BY sequence, id FOR UPDATE
+		), ranked AS (
+			SELECT id, row_number() OVER (ORDER BY sequence, id) AS n, count(*) OVER () AS total FROM moving
+		), seq AS (
+			INSERT INTO ` + b.sequences + ` (namespace, state, last)
+			SELECT $1, $3, count(*) FROM moving HAVING count(*) > 0
+			ON CONFLICT (namespace, state) DO UPDATE SET last = ` + b.sequences + `.last + EXCLUDED.last
+			RETURNING last
+		)
+		UPDATE ` + b.table + ` SET state = $3, state_entered_at = $4, sequence = seq.last - ranked.total + ranked.n,
+			version = ` + b.table + `.version + 1
+		FROM ranked, seq WHERE ` + b.table + `.namespace = $1 AND ` + b.table + `.id = ranked.id`
+}
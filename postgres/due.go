@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// GetDueMessages reads the due deferred messages through the
+// (state, next_retry) index, see metastorage.DueBackend. Messages without
+// NextRetry come first.
+func (b *Backend) GetDueMessages(ctx context.Context, before time.Time, limit int) ([]metastorage.MessageMetadata, error) {
+	var n *int // NULL is no limit
+	if limit > 0 {
+		n = &limit
+	}
+	rows, err := b.pool.Query(ctx, `SELECT `+columns+` FROM `+b.table+`
+		WHERE namespace = $1 AND state = $2 AND (next_retry IS NULL OR next_retry <= $3)
+		ORDER BY next_retry NULLS FIRST, id LIMIT $4`,
+		b.namespace, int16(metastorage.StateDeferred), before.UTC(), n)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return collect(rows)
+}
+
+// NewDueIterator iterates over the due deferred messages, see
+// metastorage.DueBackend. Like NewMessageIterator each batch is a keyset
+// query, continuing after the NextRetry and ID last returned.
+func (b *Backend) NewDueIterator(ctx context.Context, before time.Time, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &dueIterator{backend: b, before: before.UTC(), batchSize: max(batchSize, 1), nulls: true}, nil
+}
+
+// collect reads the messages of rows
+func collect(rows pgx.Rows) ([]metastorage.MessageMetadata, error) {
+	defer rows.Close()
+	var ms []metastorage.MessageMetadata
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(r.dest()...); err != nil {
+			return nil, err
+		}
+		m, err := r.metadata()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translate(err)
+	}
+	return ms, nil
+}
+
+// dueIterator reads the messages without NextRetry first, then the ones
+// due by before in NextRetry order
+type dueIterator struct {
+	backend   *Backend
+	before    time.Time
+	batchSize int
+	nulls     bool // still reading messages without NextRetry
+	afterTime time.Time
+	afterID   string
+	batch     []metastorage.MessageMetadata
+	done      bool
+}
+
+// Next returns the next due message
+func (it *dueIterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	for len(it.batch) == 0 && !it.done {
+		if err := it.fetch(ctx); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	if len(it.batch) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+func (it *dueIterator) fetch(ctx context.Context) error {
+	b := it.backend
+	var rows pgx.Rows
+	var err error
+	if it.nulls {
+		rows, err = b.pool.Query(ctx, `SELECT `+columns+` FROM `+b.table+`
+			WHERE namespace = $1 AND state = $2 AND next_retry IS NULL AND id > $3
+			ORDER BY id LIMIT $4`,
+			b.namespace, int16(metastorage.StateDeferred), it.afterID, it.batchSize)
+	} else {
+		rows, err = b.pool.Query(ctx, `SELECT `+columns+` FROM `+b.table+`
+			WHERE namespace = $1 AND state = $2 AND next_retry <= $3 AND (next_retry, id) > ($4, $5)
+			ORDER BY next_retry, id LIMIT $6`,
+			b.namespace, int16(metastorage.StateDeferred), it.before, it.afterTime, it.afterID, it.batchSize)
+	}
+	if err != nil {
+		return translate(err)
+	}
+	ms, err := collect(rows)
+	if err != nil {
+		return err
+	}
+	it.batch = ms
+	if len(ms) > 0 {
+		last := ms[len(ms)-1]
+		it.afterTime, it.afterID = last.NextRetry, last.ID
+	}
+	if len(ms) < it.batchSize {
+		if it.nulls {
+			// continue with the dated messages from the start
+			it.nulls = false
+			it.afterTime, it.afterID = time.Time{}, ""
+		} else {
+			it.done = true
+		}
+	}
+	return nil
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *dueIterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = n
+	}
+}
+
+// Close releases the iterator
+func (it *dueIterator) Close() error {
+	it.done = true
+	it.batch = nil
+	return nil
+}
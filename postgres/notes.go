@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// AddNote appends a note to the notes header with one UPDATE, see
+// metastorage.NoteBackend
+func (b *Backend) AddNote(ctx context.Context, messageID, author, text string) error {
+	note, err := json.Marshal([]metastorage.Note{{Author: author, Text: text, Created: b.clock.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	tag, err := b.pool.Exec(ctx, `UPDATE `+b.table+` SET headers = COALESCE(headers, '{}'::jsonb) ||
+		jsonb_build_object($3::text, (COALESCE((headers->>$3)::jsonb, '[]'::jsonb) || $4::jsonb)::text),
+		version = version + 1
+		WHERE namespace = $1 AND id = $2`,
+		b.namespace, messageID, metastorage.HeaderNotes, note)
+	if err != nil {
+		return translate(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return metastorage.ErrMessageNotFound
+	}
+	return nil
+}
+
+// Notes returns the notes of a message, see metastorage.NoteBackend
+func (b *Backend) Notes(ctx context.Context, messageID string) ([]metastorage.Note, error) {
+	m, err := b.GetMeta(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return metastorage.NotesOf(m)
+}
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Pin sets the pin header with one UPDATE, see metastorage.PinBackend
+func (b *Backend) Pin(ctx context.Context, messageID, reason string) error {
+	return b.UpdateMetaFields(ctx, messageID, metastorage.MetadataPatch{Headers: map[string]string{metastorage.HeaderPinned: reason}})
+}
+
+// Unpin removes the pin header with one UPDATE, see metastorage.PinBackend
+func (b *Backend) Unpin(ctx context.Context, messageID string) error {
+	return b.UpdateMetaFields(ctx, messageID, metastorage.MetadataPatch{RemoveHeaders: []string{metastorage.HeaderPinned}})
+}
+
+// DeleteUnpinned deletes a message with one DELETE conditional on the
+// absence of the pin header, see metastorage.PinBackend
+func (b *Backend) DeleteUnpinned(ctx context.Context, messageID string) error {
+	tag, err := b.pool.Exec(ctx, `DELETE FROM `+b.table+` WHERE namespace = $1 AND id = $2 AND NOT COALESCE(headers ? $3, false)`,
+		b.namespace, messageID, metastorage.HeaderPinned)
+	if err != nil {
+		return translate(err)
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := b.GetMeta(ctx, messageID); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", metastorage.ErrPinned, messageID)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// querier is where the writes send their statements: the pool, or the
+// transaction of a metastorage.Txn
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Begin starts a transaction committed in one database transaction, see
+// metastorage.TxnBackend
+func (b *Backend) Begin(ctx context.Context) (metastorage.Txn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metastorage.NewTxn(b.commit), nil
+}
+
+// commit runs ops in a database transaction; a failing operation rolls
+// back all of them
+func (b *Backend) commit(ctx context.Context, ops []metastorage.TxnOp) error {
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback(ctx)
+	for i, op := range ops {
+		switch op.Kind {
+		case metastorage.TxnStore:
+			err = b.store(ctx, tx, op.ID, op.Metadata)
+		case metastorage.TxnUpdate:
+			err = b.update(ctx, tx, op.ID, op.Metadata)
+		case metastorage.TxnMove:
+			err = b.move(ctx, tx, op.ID, op.From, op.To, 0)
+		case metastorage.TxnDelete:
+			err = b.delete(ctx, tx, op.ID)
+		}
+		if err != nil {
+			return op.Err(i, err)
+		}
+	}
+	return translate(tx.Commit(ctx))
+}
@@ -0,0 +1,195 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Band is a named class of priorities. Producers disagree on raw Priority
+// values; bands give them a shared meaning through a BandMapping.
+type Band int
+
+const (
+	BandBulk Band = iota
+	BandNormal
+	BandUrgent
+)
+
+// String returns the band name
+func (b Band) String() string {
+	switch b {
+	case BandBulk:
+		return "bulk"
+	case BandNormal:
+		return "normal"
+	case BandUrgent:
+		return "urgent"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseBand returns the band with the given name
+func ParseBand(name string) (Band, error) {
+	for _, b := range Bands() {
+		if b.String() == name {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority band %q", name)
+}
+
+// Bands returns all bands from lowest to highest
+func Bands() []Band {
+	return []Band{BandBulk, BandNormal, BandUrgent}
+}
+
+// BandMapping maps raw priorities to bands. Priorities between BulkMax and
+// UrgentMin (exclusive) are normal.
+type BandMapping struct {
+	BulkMax   int // Priorities <= BulkMax are bulk
+	UrgentMin int // Priorities >= UrgentMin are urgent
+}
+
+// DefaultBandMapping treats negative priorities as bulk, 0-9 as normal and
+// 10 and above as urgent
+var DefaultBandMapping = BandMapping{BulkMax: -1, UrgentMin: 10}
+
+// Validate checks that the normal band is not empty
+func (m BandMapping) Validate() error {
+	if m.UrgentMin-m.BulkMax < 2 {
+		return fmt.Errorf("band mapping: no normal priorities between bulk <= %d and urgent >= %d", m.BulkMax, m.UrgentMin)
+	}
+	return nil
+}
+
+// Band returns the band of priority
+func (m BandMapping) Band(priority int) Band {
+	switch {
+	case priority <= m.BulkMax:
+		return BandBulk
+	case priority >= m.UrgentMin:
+		return BandUrgent
+	default:
+		return BandNormal
+	}
+}
+
+// Priority returns the canonical priority producers should use for b:
+// BulkMax, UrgentMin, or for normal 0 if it is a normal priority and
+// BulkMax+1 otherwise
+func (m BandMapping) Priority(b Band) int {
+	switch b {
+	case BandBulk:
+		return m.BulkMax
+	case BandUrgent:
+		return m.UrgentMin
+	}
+	if m.Band(0) == BandNormal {
+		return 0
+	}
+	return m.BulkMax + 1
+}
+
+// BandOf returns the band of m under mapping
+func BandOf(m MessageMetadata, mapping BandMapping) Band {
+	return mapping.Band(m.Priority)
+}
+
+// CountBands counts the messages of state per band
+func CountBands(ctx context.Context, b Backend, state QueueState, mapping BandMapping) (map[Band]int, error) {
+	iter, err := b.NewMessageIterator(ctx, state, 500)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	counts := make(map[Band]int, 3)
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return counts, nil
+		}
+		counts[mapping.Band(m.Priority)]++
+	}
+}
+
+// ListBand returns up to limit messages of state in band, highest
+// priority first, then oldest NextRetry first. limit <= 0 returns all.
+func ListBand(ctx context.Context, b Backend, state QueueState, mapping BandMapping, band Band, limit int) ([]MessageMetadata, error) {
+	iter, err := b.NewMessageIterator(ctx, state, 100)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var ms []MessageMetadata
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		if mapping.Band(m.Priority) == band {
+			ms = append(ms, m)
+		}
+	}
+	sortClaimOrder(ms)
+	if limit > 0 && len(ms) > limit {
+		ms = ms[:limit]
+	}
+	return ms, nil
+}
+
+// ClaimBands claims up to n due messages of state like ClaimBatch, but
+// only from the given bands (default all). Higher bands are claimed
+// first. Native ClaimBatchBackend implementations know nothing about
+// bands, so the state is always scanned and claimed one by one.
+func ClaimBands(ctx context.Context, b Backend, state QueueState, workerID string, n int, lease time.Duration, mapping BandMapping, bands ...Band) ([]MessageMetadata, error) {
+	if state == StateActive {
+		return nil, fmt.Errorf("%w: cannot claim from %s", ErrInvalidState, state)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	if len(bands) == 0 {
+		bands = Bands()
+	}
+	wanted := make(map[Band]bool, len(bands))
+	for _, band := range bands {
+		wanted[band] = true
+	}
+
+	now, err := serverNow(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := dueMessages(ctx, b, state, now, n*claimOverscan, func(m MessageMetadata) bool {
+		return wanted[mapping.Band(m.Priority)]
+	})
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]MessageMetadata, 0, n)
+	for _, c := range candidates {
+		if len(claimed) == n {
+			break
+		}
+		m, err := claim(ctx, b, c.ID, state, workerID, now, lease)
+		if errors.Is(err, ErrStateConflict) || errors.Is(err, ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, m)
+	}
+	return claimed, nil
+}
@@ -0,0 +1,429 @@
+// Package query implements a small filter language for message metadata,
+// used by operator tools to select messages:
+//
+//	attempts >= 3 and header.x-domain = "example.com"
+//	(state = deferred or state = hold) and not due and age > 24h
+//	last_error ~ timeout
+//	band >= normal
+//
+// A comparison is a field, an operator and a value. Operators are =, !=,
+// <, <=, >, >= and ~ (contains). Comparisons combine with and, or, not and
+// parentheses; and binds stronger than or. See Fields for the field names;
+// band maps priorities with metastorage.DefaultBandMapping.
+// An empty query matches every message.
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// HeaderPrefix selects a header, e.g. header.x-domain
+const HeaderPrefix = "header."
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt
+	kindState
+	kindBand     // compared by order, bulk < normal < urgent
+	kindTime     // compared with RFC 3339 timestamps or "now"
+	kindDuration // compared with Go durations, e.g. 90m
+	kindBool     // used without operator and value
+)
+
+type field struct {
+	kind kind
+	get  func(m metastorage.MessageMetadata, now time.Time) any
+}
+
+var fields = map[string]field{
+	"id":            {kindString, func(m metastorage.MessageMetadata, _ time.Time) any { return m.ID }},
+	"state":         {kindState, func(m metastorage.MessageMetadata, _ time.Time) any { return m.State }},
+	"attempts":      {kindInt, func(m metastorage.MessageMetadata, _ time.Time) any { return int64(m.Attempts) }},
+	"max_attempts":  {kindInt, func(m metastorage.MessageMetadata, _ time.Time) any { return int64(m.MaxAttempts) }},
+	"priority":      {kindInt, func(m metastorage.MessageMetadata, _ time.Time) any { return int64(m.Priority) }},
+	"size":          {kindInt, func(m metastorage.MessageMetadata, _ time.Time) any { return m.Size }},
+	"sequence":      {kindInt, func(m metastorage.MessageMetadata, _ time.Time) any { return int64(m.Sequence) }},
+	"last_error":    {kindString, func(m metastorage.MessageMetadata, _ time.Time) any { return m.LastError }},
+	"policy":        {kindString, func(m metastorage.MessageMetadata, _ time.Time) any { return m.RetryPolicyName }},
+	"created":       {kindTime, func(m metastorage.MessageMetadata, _ time.Time) any { return m.Created }},
+	"updated":       {kindTime, func(m metastorage.MessageMetadata, _ time.Time) any { return m.Updated }},
+	"next_retry":    {kindTime, func(m metastorage.MessageMetadata, _ time.Time) any { return m.NextRetry }},
+	"state_entered": {kindTime, func(m metastorage.MessageMetadata, _ time.Time) any { return m.StateEnteredAt }},
+	"band": {kindBand, func(m metastorage.MessageMetadata, _ time.Time) any {
+		return metastorage.BandOf(m, metastorage.DefaultBandMapping)
+	}},
+	"age":                {kindDuration, func(m metastorage.MessageMetadata, now time.Time) any { return m.Age(now) }},
+	"time_in_state":      {kindDuration, func(m metastorage.MessageMetadata, now time.Time) any { return m.TimeInState(now) }},
+	"idle":               {kindDuration, func(m metastorage.MessageMetadata, now time.Time) any { return m.Idle(now) }},
+	"due":                {kindBool, func(m metastorage.MessageMetadata, now time.Time) any { return m.IsDue(now) }},
+	"terminal":           {kindBool, func(m metastorage.MessageMetadata, _ time.Time) any { return m.IsTerminal() }},
+	"exhausted":          {kindBool, func(m metastorage.MessageMetadata, _ time.Time) any { return m.Exhausted() }},
+	"attempts_remaining": {kindInt, func(m metastorage.MessageMetadata, _ time.Time) any { return int64(m.AttemptsRemaining()) }},
+	"pinned": {kindBool, func(m metastorage.MessageMetadata, _ time.Time) any {
+		_, pinned := metastorage.IsPinned(m)
+		return pinned
+	}},
+}
+
+// Fields returns the field names in alphabetical order, without the
+// header.<name> form
+func Fields() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Operators returns the comparison operators
+func Operators() []string {
+	return []string{"=", "!=", "<", "<=", ">", ">=", "~"}
+}
+
+// Keywords returns the logical keywords
+func Keywords() []string {
+	return []string{"and", "or", "not"}
+}
+
+// Query is a parsed filter
+type Query struct {
+	src  string
+	root node
+}
+
+// Parse parses a filter expression
+func Parse(s string) (*Query, error) {
+	p := &parser{src: s}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	q := &Query{src: strings.TrimSpace(s)}
+	if len(p.tokens) == 0 {
+		return q, nil
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	q.root = root
+	return q, nil
+}
+
+// MustParse is like Parse but panics on errors
+func MustParse(s string) *Query {
+	q, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Match reports whether m matches the query at now
+func (q *Query) Match(m metastorage.MessageMetadata, now time.Time) bool {
+	return q == nil || q.root == nil || q.root.eval(m, now)
+}
+
+// String returns the query source
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.src
+}
+
+type node interface {
+	eval(m metastorage.MessageMetadata, now time.Time) bool
+}
+
+type andNode struct{ l, r node }
+type orNode struct{ l, r node }
+type notNode struct{ n node }
+
+func (n andNode) eval(m metastorage.MessageMetadata, now time.Time) bool {
+	return n.l.eval(m, now) && n.r.eval(m, now)
+}
+
+func (n orNode) eval(m metastorage.MessageMetadata, now time.Time) bool {
+	return n.l.eval(m, now) || n.r.eval(m, now)
+}
+
+func (n notNode) eval(m metastorage.MessageMetadata, now time.Time) bool {
+	return !n.n.eval(m, now)
+}
+
+// cmpNode compares a field with a value of the field's kind
+type cmpNode struct {
+	get   func(m metastorage.MessageMetadata, now time.Time) any
+	op    string
+	value any // string, int64, QueueState, Band, time.Time, time.Duration; nil for "now"
+}
+
+func (n cmpNode) eval(m metastorage.MessageMetadata, now time.Time) bool {
+	value := n.value
+	if value == nil {
+		value = now
+	}
+	switch got := n.get(m, now).(type) {
+	case bool:
+		return got
+	case string:
+		want := value.(string)
+		if n.op == "~" {
+			return strings.Contains(got, want)
+		}
+		return compare(strings.Compare(got, want), n.op)
+	case int64:
+		return compare(cmp(got, value.(int64)), n.op)
+	case metastorage.QueueState:
+		return compare(cmp(int(got), int(value.(metastorage.QueueState))), n.op)
+	case metastorage.Band:
+		return compare(cmp(int(got), int(value.(metastorage.Band))), n.op)
+	case time.Duration:
+		return compare(cmp(got, value.(time.Duration)), n.op)
+	case time.Time:
+		return compare(got.Compare(value.(time.Time)), n.op)
+	}
+	return false
+}
+
+func cmp[T int | int64 | time.Duration](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compare(c int, op string) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+type token struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	offset := len(p.src)
+	if p.pos < len(p.tokens) {
+		offset = p.tokens[p.pos].pos
+	}
+	return fmt.Errorf("query: %s at offset %d", fmt.Sprintf(format, args...), offset)
+}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '~' || c == '=':
+			p.tokens = append(p.tokens, token{text: string(c), pos: i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(s) && s[i+1] == '=' {
+				p.tokens = append(p.tokens, token{text: s[i : i+2], pos: i})
+				i += 2
+				continue
+			}
+			if c == '!' {
+				return fmt.Errorf("query: expected != at offset %d", i)
+			}
+			p.tokens = append(p.tokens, token{text: string(c), pos: i})
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return fmt.Errorf("query: unterminated string at offset %d", i)
+			}
+			p.tokens = append(p.tokens, token{text: sb.String(), quoted: true, pos: i})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n()~=!<>\"'", rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{text: s[i:j], pos: i})
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return token{}, false
+}
+
+func (p *parser) keyword(kw string) bool {
+	t, ok := p.peek()
+	if ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.keyword("not") {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	t, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("expected a comparison")
+	}
+	if t.text == "(" && !t.quoted {
+		p.pos++
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.text != ")" {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return n, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	name, _ := p.peek()
+	get, k, err := lookup(name.text)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.pos++
+	if k == kindBool {
+		return cmpNode{get: get}, nil
+	}
+
+	op, ok := p.peek()
+	if !ok || op.quoted || !slices.Contains(Operators(), op.text) {
+		return nil, p.errorf("expected an operator after %s", name.text)
+	}
+	if op.text == "~" && k != kindString {
+		return nil, p.errorf("~ only applies to text fields")
+	}
+	p.pos++
+	v, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("expected a value after %s %s", name.text, op.text)
+	}
+	value, err := parseValue(k, v.text)
+	if err != nil {
+		return nil, p.errorf("%s: %v", name.text, err)
+	}
+	p.pos++
+	return cmpNode{get: get, op: op.text, value: value}, nil
+}
+
+func lookup(name string) (func(metastorage.MessageMetadata, time.Time) any, kind, error) {
+	if header, ok := strings.CutPrefix(name, HeaderPrefix); ok && header != "" {
+		return func(m metastorage.MessageMetadata, _ time.Time) any { return m.Headers[header] }, kindString, nil
+	}
+	f, ok := fields[strings.ToLower(name)]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown field %q", name)
+	}
+	return f.get, f.kind, nil
+}
+
+func parseValue(k kind, s string) (any, error) {
+	switch k {
+	case kindInt:
+		return strconv.ParseInt(s, 10, 64)
+	case kindState:
+		return metastorage.ParseQueueState(s)
+	case kindBand:
+		return metastorage.ParseBand(s)
+	case kindDuration:
+		return time.ParseDuration(s)
+	case kindTime:
+		if strings.EqualFold(s, "now") {
+			return nil, nil
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		return s, nil
+	}
+}
@@ -0,0 +1,226 @@
+// Package queue presents a metadata backend as a plain message queue, for
+// users who want queue semantics without handling states:
+//
+//   - Enqueue stores a message in the incoming state
+//   - Dequeue claims the next due message with a lease (see
+//     metastorage.ClaimBatch) and returns it as a Delivery
+//   - Delivery.Ack finishes it: the message is deleted, or archived with
+//     WithArchive
+//   - Delivery.Nack counts a failed attempt and defers the message by a
+//     delay, or bounces it once MaxAttempts is reached
+//
+// Messages whose consumer dies without Ack or Nack stay active until
+// their lease expires; see metastorage.Recover and the lease package.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// DefaultLease is the default lease of dequeued messages
+const DefaultLease = 5 * time.Minute
+
+// DefaultPollInterval is how often Dequeue looks for due messages while
+// the queue is empty
+const DefaultPollInterval = time.Second
+
+type (
+	leaseKey        struct{}
+	pollIntervalKey struct{}
+	sourcesKey      struct{}
+	archiveKey      struct{}
+)
+
+// WithLease sets how long a dequeued message is owned by the consumer
+// before it is considered orphaned (default DefaultLease)
+func WithLease(d time.Duration) options.Option {
+	return options.WithValue(leaseKey{}, d)
+}
+
+// WithPollInterval sets how often Dequeue polls an empty queue (default
+// DefaultPollInterval)
+func WithPollInterval(d time.Duration) options.Option {
+	return options.WithValue(pollIntervalKey{}, d)
+}
+
+// WithSources sets the states Dequeue claims from, in order of preference
+// (default incoming, then deferred)
+func WithSources(states ...metastorage.QueueState) options.Option {
+	return options.WithValue(sourcesKey{}, states)
+}
+
+// WithArchive makes Ack move messages to the archived state instead of
+// deleting them
+func WithArchive(archive bool) options.Option {
+	return options.WithValue(archiveKey{}, archive)
+}
+
+// Producer adds messages to a queue
+type Producer interface {
+	// Enqueue stores a message for delivery
+	Enqueue(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error
+}
+
+// Consumer takes messages from a queue
+type Consumer interface {
+	// Dequeue waits for the next due message and claims it
+	Dequeue(ctx context.Context) (*Delivery, error)
+}
+
+// Queue is a Producer and Consumer on a backend
+type Queue struct {
+	backend  metastorage.Backend
+	workerID string
+	lease    time.Duration
+	poll     time.Duration
+	sources  []metastorage.QueueState
+	archive  bool
+	clock    clock.Clock
+}
+
+// New creates a queue on backend. workerID identifies the consumer in
+// the leases of dequeued messages and must be unique per process.
+func New(backend metastorage.Backend, workerID string, opts ...options.Option) *Queue {
+	o := options.Apply(opts...)
+	return &Queue{
+		backend:  backend,
+		workerID: workerID,
+		lease:    options.ValueOr(o, leaseKey{}, DefaultLease),
+		poll:     options.ValueOr(o, pollIntervalKey{}, DefaultPollInterval),
+		sources:  options.ValueOr(o, sourcesKey{}, []metastorage.QueueState{metastorage.StateIncoming, metastorage.StateDeferred}),
+		archive:  options.ValueOr(o, archiveKey{}, false),
+		clock:    o.Clock,
+	}
+}
+
+// Enqueue stores a message in the incoming state. Created and Updated
+// default to now; NextRetry is kept, so messages can be scheduled.
+func (q *Queue) Enqueue(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	now := q.clock.Now().UTC()
+	metadata.State = metastorage.StateIncoming
+	if metadata.Created.IsZero() {
+		metadata.Created = now
+	}
+	if metadata.Updated.IsZero() {
+		metadata.Updated = now
+	}
+	return q.backend.StoreMeta(ctx, messageID, metadata)
+}
+
+// TryDequeue claims the next due message without waiting. It returns nil
+// if no message is due.
+func (q *Queue) TryDequeue(ctx context.Context) (*Delivery, error) {
+	for _, state := range q.sources {
+		claimed, err := metastorage.ClaimBatch(ctx, q.backend, state, q.workerID, 1, q.lease)
+		if len(claimed) > 0 {
+			return &Delivery{Message: claimed[0], queue: q}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Dequeue claims the next due message, polling until one is due or ctx
+// is done
+func (q *Queue) Dequeue(ctx context.Context) (*Delivery, error) {
+	ticker := time.NewTicker(q.poll)
+	defer ticker.Stop()
+	for {
+		d, err := q.TryDequeue(ctx)
+		if d != nil || err != nil {
+			return d, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Delivery is a dequeued message owned by the consumer until it is acked,
+// nacked or its lease expires
+type Delivery struct {
+	Message metastorage.MessageMetadata
+	queue   *Queue
+}
+
+// owned returns the current metadata of the message if the consumer
+// still holds its lease
+func (d *Delivery) owned(ctx context.Context) (metastorage.MessageMetadata, error) {
+	id := d.Message.ID
+	m, err := d.queue.backend.GetMeta(ctx, id)
+	if errors.Is(err, metastorage.ErrMessageNotFound) {
+		return m, fmt.Errorf("%w: %s was removed", metastorage.ErrLeaseLost, id)
+	}
+	if err != nil {
+		return m, err
+	}
+	owner, _, ok := metastorage.LeaseOf(m)
+	if m.State != metastorage.StateActive || !ok || owner != d.queue.workerID {
+		return m, fmt.Errorf("%w: %s is not claimed by %s", metastorage.ErrLeaseLost, id, d.queue.workerID)
+	}
+	return m, nil
+}
+
+// Ack finishes the message: it is deleted, or archived with WithArchive.
+// It fails with metastorage.ErrLeaseLost if the consumer no longer owns
+// the message.
+func (d *Delivery) Ack(ctx context.Context) error {
+	m, err := d.owned(ctx)
+	if err != nil {
+		return err
+	}
+	if !d.queue.archive {
+		return d.queue.backend.DeleteMeta(ctx, m.ID)
+	}
+	return d.finish(ctx, m, metastorage.StateArchived)
+}
+
+// Nack records a failed attempt with reason and defers the message by
+// delay. Once MaxAttempts is reached the message is bounced instead. It
+// fails with metastorage.ErrLeaseLost if the consumer no longer owns the
+// message.
+func (d *Delivery) Nack(ctx context.Context, delay time.Duration, reason string) error {
+	m, err := d.owned(ctx)
+	if err != nil {
+		return err
+	}
+	m.Attempts++
+	m.LastError = reason
+	m.NextRetry = d.queue.clock.Now().UTC().Add(delay)
+	if m.Exhausted() {
+		return d.finish(ctx, m, metastorage.StateBounce)
+	}
+	return d.finish(ctx, m, metastorage.StateDeferred)
+}
+
+// Extend renews the lease of the message for another lease duration
+func (d *Delivery) Extend(ctx context.Context) (time.Time, error) {
+	return metastorage.ExtendLease(ctx, d.queue.backend, d.Message.ID, d.queue.workerID, d.queue.lease)
+}
+
+// finish clears the lease of the active message m, stores it and moves
+// it to state
+func (d *Delivery) finish(ctx context.Context, m metastorage.MessageMetadata, state metastorage.QueueState) error {
+	m = metastorage.ClearLease(m)
+	m.Updated = d.queue.clock.Now().UTC()
+	if err := d.queue.backend.UpdateMeta(ctx, m.ID, m); err != nil {
+		return err
+	}
+	d.Message = m
+	if err := d.queue.backend.MoveToState(ctx, m.ID, metastorage.StateActive, state); err != nil {
+		return err
+	}
+	d.Message.State = state
+	return nil
+}
@@ -0,0 +1,129 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RecoverBackend extends Backend with a startup recovery phase. Layers
+// repair what a crash may have left behind in them, e.g. replay a write
+// journal, rebuild in-memory counters or remove partial files.
+type RecoverBackend interface {
+	Backend
+
+	// Recover repairs the layer. It is called once on boot, before any
+	// worker starts; see the Recover function.
+	Recover(ctx context.Context) error
+}
+
+// RecoverOptions controls Recover
+type RecoverOptions struct {
+	// IgnoreLeases requeues every active message, whether its lease
+	// expired or not. Only safe if no other node works on the backend.
+	IgnoreLeases bool
+	// Now is the time leases are checked against, default time.Now
+	Now time.Time
+}
+
+// RecoveryReport is the result of Recover
+type RecoveryReport struct {
+	Layers   int      // Layers whose Recover ran
+	Requeued []string // IDs of orphaned messages moved from active to deferred
+	Kept     int      // Active messages left alone as their lease is valid
+}
+
+// Recover is the startup recovery phase retryspool runs on boot, so crash
+// recovery behaves the same on every backend:
+//
+//  1. every layer of b implementing RecoverBackend is recovered, the
+//     innermost first, so outer layers replay into a repaired backend
+//  2. orphaned active messages, whose worker died before finishing them,
+//     are moved back to StateDeferred and their lease headers removed.
+//     Active messages without a lease or with an expired lease are
+//     orphaned; with IgnoreLeases all of them are.
+//
+// Recovery stops at the first failing layer. Messages that leave the
+// active state while they are requeued are skipped.
+func Recover(ctx context.Context, b Backend, opts RecoverOptions) (RecoveryReport, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	var report RecoveryReport
+
+	var layers []RecoverBackend
+	for l := b; l != nil; l = Unwrap(l) {
+		if r, ok := l.(RecoverBackend); ok {
+			layers = append(layers, r)
+		}
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		if err := layers[i].Recover(ctx); err != nil {
+			return report, fmt.Errorf("recover: %w", err)
+		}
+		report.Layers++
+	}
+
+	orphans, kept, err := orphanedMessages(ctx, b, opts)
+	report.Kept = kept
+	if err != nil {
+		return report, fmt.Errorf("recover: scan active: %w", err)
+	}
+	for _, id := range orphans {
+		err := requeue(ctx, b, id)
+		if errors.Is(err, ErrStateConflict) || errors.Is(err, ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("recover: requeue %s: %w", id, err)
+		}
+		report.Requeued = append(report.Requeued, id)
+	}
+	return report, nil
+}
+
+// orphanedMessages returns the IDs of the active messages to requeue and
+// the number of messages kept
+func orphanedMessages(ctx context.Context, b Backend, opts RecoverOptions) ([]string, int, error) {
+	iter, err := b.NewMessageIterator(ctx, StateActive, 100)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer iter.Close()
+
+	var ids []string
+	kept := 0
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, kept, err
+		}
+		if !more {
+			return ids, kept, nil
+		}
+		_, expires, ok := LeaseOf(m)
+		if !opts.IgnoreLeases && ok && expires.After(opts.Now) {
+			kept++
+			continue
+		}
+		ids = append(ids, m.ID)
+	}
+}
+
+// requeue moves an active message to deferred and removes its lease
+func requeue(ctx context.Context, b Backend, id string) error {
+	if err := b.MoveToState(ctx, id, StateActive, StateDeferred); err != nil {
+		return err
+	}
+	m, err := b.GetMeta(ctx, id)
+	if err != nil {
+		return err
+	}
+	if m.State != StateDeferred {
+		return nil // moved again meanwhile
+	}
+	m = ClearLease(m)
+	m.Updated = time.Now().UTC()
+	return b.UpdateMeta(ctx, id, m)
+}
@@ -0,0 +1,74 @@
+// Package registry maps DSN schemes to backend implementations, similar to
+// database/sql drivers. Backend packages register themselves in init, so
+// importing a backend package for its side effects makes its scheme
+// available to Open.
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// ErrUnknownScheme is returned when no backend is registered for a DSN scheme
+var ErrUnknownScheme = errors.New("unknown backend scheme")
+
+// Opener creates a backend from a parsed DSN
+type Opener func(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error)
+
+var (
+	mu      sync.RWMutex
+	openers = make(map[string]Opener)
+)
+
+// Register makes a backend available under scheme.
+// It panics if opener is nil or the scheme is registered twice.
+func Register(scheme string, opener Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	if opener == nil {
+		panic("registry: Register opener is nil")
+	}
+	if _, dup := openers[scheme]; dup {
+		panic("registry: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = opener
+}
+
+// Schemes returns the sorted list of registered schemes
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(openers))
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open opens the backend identified by dsn, e.g. "memory://" or
+// "postgres://user@host/db"
+func Open(ctx context.Context, dsn string, opts ...options.Option) (metastorage.Backend, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse backend DSN: %w", err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("backend DSN %q has no scheme", dsn)
+	}
+
+	mu.RLock()
+	opener, ok := openers[u.Scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, u.Scheme)
+	}
+	return opener(ctx, u, opts...)
+}
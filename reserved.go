@@ -0,0 +1,34 @@
+package metastorage
+
+import "sync"
+
+var (
+	reservedMu      sync.RWMutex
+	reservedHeaders = map[string]bool{
+		HeaderPinned:       true,
+		HeaderNotes:        true,
+		HeaderLeaseOwner:   true,
+		HeaderLeaseExpires: true,
+		HeaderClaimedFrom:  true,
+	}
+)
+
+// RegisterReservedHeader declares keys as headers managed by retryspool
+// itself. Packages writing their own reserved headers register them in
+// init.
+func RegisterReservedHeader(keys ...string) {
+	reservedMu.Lock()
+	defer reservedMu.Unlock()
+	for _, k := range keys {
+		reservedHeaders[k] = true
+	}
+}
+
+// IsReservedHeader reports whether key is a registered reserved header.
+// Other keys starting with ReservedHeaderPrefix are not written by
+// retryspool and may come from a producer.
+func IsReservedHeader(key string) bool {
+	reservedMu.RLock()
+	defer reservedMu.RUnlock()
+	return reservedHeaders[key]
+}
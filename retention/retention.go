@@ -0,0 +1,249 @@
+// Package retention reports how long personal data is kept in metadata
+// backends, for data-retention audits such as GDPR storage limitation
+// reviews. For every namespace it counts the messages carrying personal
+// data, summarizes their ages by state and age bucket, and lists the
+// messages kept longer than the policy allows.
+//
+// Reports are read-only; deleting or anonymizing violating messages is
+// left to the operator.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// DefaultPersonalHeaders are the headers whose values identify people
+var DefaultPersonalHeaders = []string{"from", "to", "cc", "bcc", "reply-to", "sender", "subject"}
+
+// DefaultBuckets are the upper bounds of the age buckets of a report
+var DefaultBuckets = []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour, 90 * 24 * time.Hour}
+
+// DefaultMaxListed is the number of violating message IDs listed per
+// namespace
+const DefaultMaxListed = 100
+
+// Policy describes the retention rules a report checks
+type Policy struct {
+	MaxAge          time.Duration            // Longest time personal data may be kept, measured from Created; 0 = no limit
+	States          []metastorage.QueueState // States checked, default all
+	PersonalHeaders []string                 // Headers holding personal data (case-insensitive), default DefaultPersonalHeaders
+	Buckets         []time.Duration          // Upper bounds of the age buckets, default DefaultBuckets
+	MaxListed       int                      // Violating IDs listed per namespace, default DefaultMaxListed
+	BatchSize       int                      // Iterator batch size, default 500
+}
+
+func (p *Policy) defaults() {
+	if len(p.States) == 0 {
+		p.States = metastorage.States()
+	}
+	if len(p.PersonalHeaders) == 0 {
+		p.PersonalHeaders = DefaultPersonalHeaders
+	}
+	if len(p.Buckets) == 0 {
+		p.Buckets = DefaultBuckets
+	}
+	p.Buckets = slices.Clone(p.Buckets)
+	slices.Sort(p.Buckets)
+	if p.MaxListed <= 0 {
+		p.MaxListed = DefaultMaxListed
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = 500
+	}
+}
+
+// Personal reports whether m carries personal data: a non-empty personal
+// header, or a LastError, which often quotes addresses from remote
+// server replies
+func (p Policy) Personal(m metastorage.MessageMetadata) bool {
+	if m.LastError != "" {
+		return true
+	}
+	headers := p.PersonalHeaders
+	if len(headers) == 0 {
+		headers = DefaultPersonalHeaders
+	}
+	for k, v := range m.Headers {
+		if v != "" && slices.ContainsFunc(headers, func(h string) bool { return strings.EqualFold(h, k) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// Source is one namespace to report on
+type Source struct {
+	Namespace string
+	Backend   metastorage.Backend
+}
+
+// Namespace is the part of a report covering one namespace
+type Namespace struct {
+	Namespace  string
+	Scanned    int            // Messages read
+	Personal   int            // Messages carrying personal data
+	PerState   map[string]int // Personal messages per state, keyed by metastorage.StateLabel
+	Buckets    []int          // Personal messages per age bucket; the last entry counts the ones older than all bounds
+	Oldest     time.Duration  // Age of the oldest personal message
+	OldestID   string         // ID of the oldest personal message
+	Violations int            // Personal messages older than the policy's MaxAge
+	Violating  []string       // IDs of the first MaxListed violations
+}
+
+// Conforms reports whether no message of the namespace violates the policy
+func (n Namespace) Conforms() bool {
+	return n.Violations == 0
+}
+
+// Report is the outcome of a retention check
+type Report struct {
+	Policy     Policy
+	Time       time.Time // Reference time ages are measured at
+	Namespaces []Namespace
+}
+
+// Conforms reports whether every namespace conforms to the policy
+func (r Report) Conforms() bool {
+	for _, n := range r.Namespaces {
+		if !n.Conforms() {
+			return false
+		}
+	}
+	return true
+}
+
+// Generate scans the sources and reports the ages of their personal
+// messages at now
+func Generate(ctx context.Context, sources []Source, policy Policy, now time.Time) (Report, error) {
+	policy.defaults()
+	r := Report{Policy: policy, Time: now}
+	for _, src := range sources {
+		n, err := scan(ctx, src, policy, now)
+		if err != nil {
+			return r, fmt.Errorf("namespace %q: %w", src.Namespace, err)
+		}
+		r.Namespaces = append(r.Namespaces, n)
+	}
+	return r, nil
+}
+
+func scan(ctx context.Context, src Source, policy Policy, now time.Time) (Namespace, error) {
+	n := Namespace{
+		Namespace: src.Namespace,
+		PerState:  make(map[string]int),
+		Buckets:   make([]int, len(policy.Buckets)+1),
+	}
+	for _, state := range policy.States {
+		iter, err := src.Backend.NewMessageIterator(ctx, state, policy.BatchSize)
+		if err != nil {
+			return n, err
+		}
+		for {
+			m, more, err := iter.Next(ctx)
+			if err != nil {
+				iter.Close()
+				return n, err
+			}
+			if !more {
+				break
+			}
+			n.Scanned++
+			if !policy.Personal(m) {
+				continue
+			}
+			n.add(m, now.Sub(m.Created), policy)
+		}
+		iter.Close()
+	}
+	return n, nil
+}
+
+func (n *Namespace) add(m metastorage.MessageMetadata, age time.Duration, policy Policy) {
+	n.Personal++
+	n.PerState[metastorage.StateLabel(m.State)]++
+	bucket, _ := slices.BinarySearch(policy.Buckets, age)
+	n.Buckets[bucket]++
+	if age > n.Oldest || n.OldestID == "" {
+		n.Oldest, n.OldestID = age, m.ID
+	}
+	if policy.MaxAge > 0 && age > policy.MaxAge {
+		n.Violations++
+		if len(n.Violating) < policy.MaxListed {
+			n.Violating = append(n.Violating, m.ID)
+		}
+	}
+}
+
+func (r Report) String() string {
+	var sb strings.Builder
+	limit := "none"
+	if r.Policy.MaxAge > 0 {
+		limit = formatAge(r.Policy.MaxAge)
+	}
+	fmt.Fprintf(&sb, "retention report at %s, max age %s\n", r.Time.UTC().Format(time.RFC3339), limit)
+	for _, n := range r.Namespaces {
+		status := "OK"
+		if !n.Conforms() {
+			status = "VIOLATION"
+		}
+		name := n.Namespace
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(&sb, "\n[%s] namespace %s: %d of %d messages carry personal data\n", status, name, n.Personal, n.Scanned)
+		if n.Personal == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "  oldest: %s (%s)\n", n.Oldest.Truncate(time.Second), n.OldestID)
+		for _, state := range r.Policy.States {
+			if c := n.PerState[metastorage.StateLabel(state)]; c > 0 {
+				fmt.Fprintf(&sb, "  %-10s %d\n", state, c)
+			}
+		}
+		for i, c := range n.Buckets {
+			if i < len(r.Policy.Buckets) {
+				fmt.Fprintf(&sb, "  <= %-8s %d\n", formatAge(r.Policy.Buckets[i]), c)
+			} else {
+				fmt.Fprintf(&sb, "  >  %-8s %d\n", formatAge(r.Policy.Buckets[len(r.Policy.Buckets)-1]), c)
+			}
+		}
+		if n.Violations > 0 {
+			fmt.Fprintf(&sb, "  %d messages older than %s:\n", n.Violations, limit)
+			for _, id := range n.Violating {
+				fmt.Fprintf(&sb, "    %s\n", id)
+			}
+			if more := n.Violations - len(n.Violating); more > 0 {
+				fmt.Fprintf(&sb, "    ... and %d more\n", more)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// ParseAge parses a duration like time.ParseDuration, and additionally
+// whole days such as "30d"
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatAge prints whole days as "30d"
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
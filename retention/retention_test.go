@@ -0,0 +1,59 @@
+package retention
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func TestGenerate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	b := memory.New()
+	for _, m := range []metastorage.MessageMetadata{
+		{ID: "fresh", State: metastorage.StateDeferred, Created: now.Add(-time.Hour), Headers: map[string]string{"To": "a@example.com"}},
+		{ID: "old", State: metastorage.StateBounce, Created: now.Add(-40 * 24 * time.Hour), Headers: map[string]string{"to": "b@example.com"}},
+		{ID: "old-error", State: metastorage.StateHold, Created: now.Add(-50 * 24 * time.Hour), LastError: "550 unknown user"},
+		{ID: "old-technical", State: metastorage.StateHold, Created: now.Add(-60 * 24 * time.Hour), Headers: map[string]string{"x-route": "eu"}},
+	} {
+		if err := b.StoreMeta(ctx, m.ID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := Generate(ctx, []Source{{Namespace: "tenant-a", Backend: b}}, Policy{MaxAge: 30 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Conforms() {
+		t.Fatal("report conforms despite old personal data")
+	}
+	n := r.Namespaces[0]
+	if n.Scanned != 4 || n.Personal != 3 || n.Violations != 2 {
+		t.Fatalf("scanned %d, personal %d, violations %d; want 4, 3, 2", n.Scanned, n.Personal, n.Violations)
+	}
+	if n.OldestID != "old-error" {
+		t.Fatalf("oldest = %s, want old-error", n.OldestID)
+	}
+	if want := []int{1, 0, 0, 2, 0}; !slices.Equal(n.Buckets, want) {
+		t.Fatalf("buckets = %v, want %v", n.Buckets, want)
+	}
+	if n.PerState["bounce"] != 1 || n.PerState["hold"] != 1 || n.PerState["deferred"] != 1 {
+		t.Fatalf("per state = %v", n.PerState)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "72h": 72 * time.Hour, "0": 0} {
+		if got, err := ParseAge(in); err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseAge("xd"); err == nil {
+		t.Error("ParseAge(xd) succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"schneider.vip/retryspool/storage/meta/middleware/audit"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// AuditStore keeps the records of an audit.ObjectSink in a bucket, see
+// audit.ObjectStore. Objects are created with If-None-Match, so records
+// are never overwritten; with Object Lock in compliance mode on the
+// bucket they cannot be deleted either.
+type AuditStore struct {
+	client *awss3.Client
+	bucket string
+}
+
+// NewAuditStore creates a store on client. The bucket must exist.
+func NewAuditStore(client *awss3.Client, bucket string) *AuditStore {
+	return &AuditStore{client: client, bucket: bucket}
+}
+
+// OpenAuditStore creates a client like Open; WithBucket is required
+func OpenAuditStore(ctx context.Context, opts ...options.Option) (*AuditStore, error) {
+	o := options.Apply(opts...)
+	bucket := options.ValueOr(o, bucketKey{}, "")
+	if bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	client, err := newClient(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditStore(client, bucket), nil
+}
+
+// Create creates the object key, failing with audit.ErrObjectExists if it
+// exists
+func (s *AuditStore) Create(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	if isPreconditionFailed(err) {
+		return audit.ErrObjectExists
+	}
+	return err
+}
+
+// Get returns the data of the object key
+func (s *AuditStore) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// List returns the keys starting with prefix
+func (s *AuditStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	pages := awss3.NewListObjectsV2Paginator(s.client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range out.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	return keys, nil
+}
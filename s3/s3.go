@@ -0,0 +1,600 @@
+// Package s3 implements metastorage.Backend on S3-compatible object
+// storage, for cheap long-term retention of bounced and held messages,
+// e.g. for compliance. It is write-mostly: storing, reading and moving
+// single messages are cheap, while listing a state walks object listings
+// and is meant for occasional exports and audits, not for scheduling.
+//
+// Every message has a record object "<prefix>ids/<key>" holding its
+// encoded metadata, the source of truth for reads. Listing is served by
+// index objects "<prefix><date>/<state>/<key>.<version>", where key is the
+// base64url encoded message ID and date the UTC day the message entered
+// the state, so archives can be browsed, exported or expired by day and
+// state with plain S3 tools. Index objects hold a copy of the metadata.
+// With a namespace, the prefix ends in "<namespace>/".
+//
+// All writes of a record are conditional on the ETag it was read at
+// (If-Match, If-None-Match), so MoveToState has exactly one winner across
+// all clients; the store must support conditional writes, as AWS S3 and
+// recent MinIO releases do. S3 has no transactions spanning objects: the
+// index object of a write is created before the record and the previous
+// one removed after it, so a crash leaves index objects behind but never
+// hides a message from listings. Readers skip index objects their record
+// does not name.
+//
+// Limitations: there are no state counts, and ListMessages and iterators
+// read the record of every listed message. Rewriting messages is
+// supported but each write costs several requests.
+//
+// Importing the package registers the
+// "s3://bucket/prefix?region=eu-central-1&endpoint=http://minio:9000" DSN
+// scheme. An endpoint selects path-style addressing, as most
+// S3-compatible stores require. Credentials come from the default AWS
+// credential chain.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/codec"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// casRetries bounds how often a write is retried after a concurrent
+// change of the record
+const casRetries = 16
+
+// dateLayout is the layout of the date prefixes of index objects
+const dateLayout = "2006-01-02"
+
+// maxKeys is the largest page of object listings
+const maxKeys = 1000
+
+// recordPrefix is the prefix of record objects below the backend prefix
+const recordPrefix = "ids/"
+
+type (
+	bucketKey   struct{}
+	prefixKey   struct{}
+	regionKey   struct{}
+	endpointKey struct{}
+)
+
+// WithBucket sets the bucket, required
+func WithBucket(name string) options.Option {
+	return options.WithValue(bucketKey{}, name)
+}
+
+// WithPrefix sets the key prefix of all objects, e.g. "archive/"
+func WithPrefix(prefix string) options.Option {
+	return options.WithValue(prefixKey{}, prefix)
+}
+
+// WithRegion sets the AWS region of Open, overriding the default
+// configuration
+func WithRegion(region string) options.Option {
+	return options.WithValue(regionKey{}, region)
+}
+
+// WithEndpoint sets the endpoint URL of Open, e.g. http://minio:9000 for
+// S3-compatible stores, and selects path-style addressing
+func WithEndpoint(endpoint string) options.Option {
+	return options.WithValue(endpointKey{}, endpoint)
+}
+
+func init() {
+	registry.Register("s3", open)
+}
+
+// open handles "s3://bucket/prefix?region=eu-central-1" DSNs
+func open(ctx context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	q := dsn.Query()
+	opts = append(opts, WithBucket(dsn.Host))
+	if prefix := strings.Trim(dsn.Path, "/"); prefix != "" {
+		opts = append(opts, WithPrefix(prefix+"/"))
+	}
+	if region := q.Get("region"); region != "" {
+		opts = append(opts, WithRegion(region))
+	}
+	if endpoint := q.Get("endpoint"); endpoint != "" {
+		opts = append(opts, WithEndpoint(endpoint))
+	}
+	return Open(ctx, opts...)
+}
+
+// Backend stores message metadata as objects of an S3 bucket
+type Backend struct {
+	client    *awss3.Client
+	bucket    string
+	prefix    string
+	codec     codec.Codec
+	clock     clock.Clock
+	batchSize int
+	closed    atomic.Bool
+}
+
+// Open creates a client from the default AWS configuration, adjusted by
+// WithRegion and WithEndpoint
+func Open(ctx context.Context, opts ...options.Option) (*Backend, error) {
+	client, err := newClient(ctx, options.Apply(opts...))
+	if err != nil {
+		return nil, err
+	}
+	return New(client, opts...)
+}
+
+// newClient creates a client from the default AWS configuration,
+// adjusted by WithRegion and WithEndpoint
+func newClient(ctx context.Context, o options.Options) (*awss3.Client, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if region, ok := options.Value[string](o, regionKey{}); ok {
+		loadOpts = append(loadOpts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	return awss3.NewFromConfig(cfg, func(c *awss3.Options) {
+		if endpoint, ok := options.Value[string](o, endpointKey{}); ok {
+			c.BaseEndpoint = aws.String(endpoint)
+			c.UsePathStyle = true
+		}
+	}), nil
+}
+
+// New creates a backend on client. The bucket must exist.
+func New(client *awss3.Client, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	bucket := options.ValueOr(o, bucketKey{}, "")
+	if bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	prefix := options.ValueOr(o, prefixKey{}, "")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if o.Namespace != "" {
+		if strings.Contains(o.Namespace, "/") {
+			return nil, fmt.Errorf("s3: namespace %q must not contain '/'", o.Namespace)
+		}
+		prefix += o.Namespace + "/"
+	}
+	return &Backend{
+		client:    client,
+		bucket:    bucket,
+		prefix:    prefix,
+		codec:     o.Codec,
+		clock:     o.Clock,
+		batchSize: o.BatchSize,
+	}, nil
+}
+
+func (b *Backend) check() error {
+	if b.closed.Load() {
+		return metastorage.ErrBackendClosed
+	}
+	return nil
+}
+
+// key returns the object key component of id
+func key(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// recordKey returns the key of the record object of id
+func (b *Backend) recordKey(id string) string {
+	return b.prefix + recordPrefix + key(id)
+}
+
+// statePrefix returns the prefix of the index objects of state on date
+func (b *Backend) statePrefix(date string, state metastorage.QueueState) string {
+	return b.prefix + date + "/" + metastorage.StateLabel(state) + "/"
+}
+
+// newIndexKey returns a fresh index object key of id in state. The
+// version makes every write's index object unique, so removing the
+// object of an earlier write never hits a later one.
+func (b *Backend) newIndexKey(date string, state metastorage.QueueState, id string) (string, error) {
+	version := make([]byte, 8)
+	if _, err := rand.Read(version); err != nil {
+		return "", err
+	}
+	return b.statePrefix(date, state) + key(id) + "." + hex.EncodeToString(version), nil
+}
+
+// indexDate returns the date of an index object key of the backend
+func (b *Backend) indexDate(indexKey string) string {
+	date, _, _ := strings.Cut(strings.TrimPrefix(indexKey, b.prefix), "/")
+	return date
+}
+
+// indexID returns the message ID of an index object key, false for
+// foreign objects
+func indexID(indexKey string) (string, bool) {
+	name := indexKey[strings.LastIndex(indexKey, "/")+1:]
+	k, _, ok := strings.Cut(name, ".")
+	if !ok {
+		return "", false
+	}
+	id, err := base64.RawURLEncoding.DecodeString(k)
+	return string(id), err == nil
+}
+
+// metaIndex is the user metadata entry of records naming their index
+// object
+const metaIndex = "index"
+
+// errorCode returns the S3 error code of err, "" for other errors
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func isNotFound(err error) bool {
+	code := errorCode(err)
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// isPreconditionFailed reports a conditional write lost to a concurrent
+// one
+func isPreconditionFailed(err error) bool {
+	code := errorCode(err)
+	return code == "PreconditionFailed" || code == "ConditionalRequestConflict"
+}
+
+// record is the current record of a message, nil if there is none, with
+// the ETag it was read at and its index object key
+type record struct {
+	m     *metastorage.MessageMetadata
+	etag  string
+	index string
+}
+
+func (b *Backend) load(ctx context.Context, id string) (record, error) {
+	out, err := b.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.recordKey(id)),
+	})
+	if isNotFound(err) {
+		return record{}, nil
+	}
+	if err != nil {
+		return record{}, err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return record{}, err
+	}
+	var m metastorage.MessageMetadata
+	if err := b.codec.Unmarshal(data, &m); err != nil {
+		return record{}, fmt.Errorf("s3: decode %s: %w", id, err)
+	}
+	m.ID = id
+	return record{m: &m, etag: aws.ToString(out.ETag), index: out.Metadata[metaIndex]}, nil
+}
+
+func (b *Backend) put(ctx context.Context, objectKey string, data []byte, meta map[string]string, ifMatch, ifNoneMatch *string) error {
+	_, err := b.client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(objectKey),
+		Body:        bytes.NewReader(data),
+		Metadata:    meta,
+		IfMatch:     ifMatch,
+		IfNoneMatch: ifNoneMatch,
+	})
+	return err
+}
+
+func (b *Backend) remove(ctx context.Context, objectKey string) error {
+	_, err := b.client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
+
+// change computes the new record of a message from the current one; nil
+// deletes the message
+type change func(cur *metastorage.MessageMetadata) (*metastorage.MessageMetadata, error)
+
+// modify writes the result of fn conditional on the record being
+// unchanged since it was read, reading it again after a concurrent write
+// won. A new index object is written first and removed again if the
+// record write loses; the previous one is removed once the record was
+// written.
+func (b *Backend) modify(ctx context.Context, op, id string, fn change) error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	for range casRetries {
+		cur, err := b.load(ctx, id)
+		if err != nil {
+			return err
+		}
+		next, err := fn(cur.m)
+		if err != nil {
+			return err
+		}
+
+		var index string
+		if next == nil {
+			_, err = b.client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+				Bucket:  aws.String(b.bucket),
+				Key:     aws.String(b.recordKey(id)),
+				IfMatch: aws.String(cur.etag),
+			})
+		} else {
+			next.ID = id
+			data, merr := b.codec.Marshal(*next)
+			if merr != nil {
+				return merr
+			}
+			// a message keeps the date it entered its state
+			date := b.clock.Now().UTC().Format(dateLayout)
+			if cur.m != nil && cur.m.State == next.State && cur.index != "" {
+				date = b.indexDate(cur.index)
+			}
+			if index, err = b.newIndexKey(date, next.State, id); err != nil {
+				return err
+			}
+			if err := b.put(ctx, index, data, nil, nil, nil); err != nil {
+				return err
+			}
+			meta := map[string]string{metaIndex: index}
+			if cur.m == nil {
+				err = b.put(ctx, b.recordKey(id), data, meta, nil, aws.String("*"))
+			} else {
+				err = b.put(ctx, b.recordKey(id), data, meta, aws.String(cur.etag), nil)
+			}
+		}
+		if err != nil && index != "" {
+			_ = b.remove(ctx, index) // best effort, readers skip it
+		}
+		if isPreconditionFailed(err) || (cur.m != nil && isNotFound(err)) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if cur.index != "" {
+			_ = b.remove(ctx, cur.index) // best effort, readers skip it
+		}
+		return nil
+	}
+	return fmt.Errorf("s3: %s %s: %w", op, id, metastorage.ErrStateConflict)
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata.Version = 0 // versions are not tracked
+	return b.modify(ctx, "store", messageID, func(*metastorage.MessageMetadata) (*metastorage.MessageMetadata, error) {
+		return &metadata, nil
+	})
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	if err := b.check(); err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	cur, err := b.load(ctx, messageID)
+	if err != nil {
+		return metastorage.MessageMetadata{}, err
+	}
+	if cur.m == nil {
+		return metastorage.MessageMetadata{}, metastorage.ErrMessageNotFound
+	}
+	return *cur.m, nil
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	if err := metastorage.RejectVersion(messageID, metadata.Version); err != nil {
+		return err
+	}
+	return b.modify(ctx, "update", messageID, func(cur *metastorage.MessageMetadata) (*metastorage.MessageMetadata, error) {
+		if cur == nil {
+			return nil, metastorage.ErrMessageNotFound
+		}
+		if cur.State != metadata.State {
+			return nil, fmt.Errorf("%w: %s is %s, expected %s", metastorage.ErrStateConflict, messageID, cur.State, metadata.State)
+		}
+		return &metadata, nil
+	})
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	return b.modify(ctx, "delete", messageID, func(cur *metastorage.MessageMetadata) (*metastorage.MessageMetadata, error) {
+		if cur == nil {
+			return nil, metastorage.ErrMessageNotFound
+		}
+		return nil, nil
+	})
+}
+
+// MoveToState moves the message with a write conditional on the ETag
+// the record was read at in fromState
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	return b.modify(ctx, "move", messageID, func(cur *metastorage.MessageMetadata) (*metastorage.MessageMetadata, error) {
+		if cur == nil {
+			return nil, metastorage.ErrMessageNotFound
+		}
+		if cur.State != fromState {
+			return nil, fmt.Errorf("%w: %s is %s, expected %s", metastorage.ErrStateConflict, messageID, cur.State, fromState)
+		}
+		next := *cur
+		next.State = toState
+		return &next, nil
+	})
+}
+
+// dates returns the sorted dates with index objects
+func (b *Backend) dates(ctx context.Context) ([]string, error) {
+	var dates []string
+	pages := awss3.NewListObjectsV2Paginator(b.client, &awss3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(b.prefix),
+		Delimiter: aws.String("/"),
+	})
+	for pages.HasMorePages() {
+		out, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range out.CommonPrefixes {
+			date := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), b.prefix), "/")
+			if len(date) == len(dateLayout) && date[4] == '-' && date[7] == '-' {
+				dates = append(dates, date)
+			}
+		}
+	}
+	return dates, nil
+}
+
+// ListMessages lists the IDs of messages in state with
+// metastorage.ListPage. All messages of the state are read, as object
+// listings cannot be sorted or skipped into.
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	iter, err := b.NewMessageIterator(ctx, state, 0)
+	if err != nil {
+		return metastorage.MessageListResult{}, err
+	}
+	defer iter.Close()
+	var ms []metastorage.MessageMetadata
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return metastorage.MessageListResult{}, err
+		}
+		if !more {
+			break
+		}
+		ms = append(ms, m)
+	}
+	return metastorage.ListPage(ms, opts)
+}
+
+// NewMessageIterator returns an iterator over the messages in state,
+// ordered by the date they entered it. Each batch is one listing of index
+// objects; the record of every listed message is read, and messages whose
+// record names another index object are skipped.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	dates, err := b.dates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &iterator{backend: b, state: state, dates: dates, batchSize: min(max(batchSize, 1), maxKeys)}, nil
+}
+
+// Close marks the backend closed; the client holds no connections that
+// need closing
+func (b *Backend) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	dates     []string // dates not yet listed completely, the first one is being listed
+	batchSize int
+	token     *string // continuation token within dates[0]
+	batch     []metastorage.MessageMetadata
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	for len(it.batch) == 0 && len(it.dates) > 0 {
+		if err := it.fetch(ctx); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	if len(it.batch) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+func (it *iterator) fetch(ctx context.Context) error {
+	b := it.backend
+	if err := b.check(); err != nil {
+		return err
+	}
+	out, err := b.client.ListObjectsV2(ctx, &awss3.ListObjectsV2Input{
+		Bucket:            aws.String(b.bucket),
+		Prefix:            aws.String(b.statePrefix(it.dates[0], it.state)),
+		MaxKeys:           aws.Int32(int32(it.batchSize)),
+		ContinuationToken: it.token,
+	})
+	if err != nil {
+		return err
+	}
+	for _, obj := range out.Contents {
+		index := aws.ToString(obj.Key)
+		id, ok := indexID(index)
+		if !ok {
+			continue
+		}
+		cur, err := b.load(ctx, id)
+		if err != nil {
+			return err
+		}
+		if cur.m != nil && cur.index == index {
+			it.batch = append(it.batch, *cur.m)
+		}
+	}
+	it.token = out.NextContinuationToken
+	if !aws.ToBool(out.IsTruncated) {
+		it.dates = it.dates[1:]
+		it.token = nil
+	}
+	return nil
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *iterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = min(n, maxKeys)
+	}
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.dates = nil
+	it.batch = nil
+	return nil
+}
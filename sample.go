@@ -0,0 +1,95 @@
+package metastorage
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// SamplerBackend extends Backend with native random sampling (e.g. SQL
+// TABLESAMPLE), avoiding a scan of the whole state
+type SamplerBackend interface {
+	Backend
+
+	// SampleMessages returns up to n uniformly chosen messages of state
+	SampleMessages(ctx context.Context, state QueueState, n int) ([]MessageMetadata, error)
+}
+
+// SampleMessages returns up to n messages of state chosen uniformly at
+// random, e.g. for QA spot checks of the deferred queue. Backends
+// implementing SamplerBackend sample natively; otherwise the state is
+// streamed once through a reservoir of size n, so memory stays O(n).
+func SampleMessages(ctx context.Context, b Backend, state QueueState, n int) ([]MessageMetadata, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if s, ok := As[SamplerBackend](b); ok {
+		return s.SampleMessages(ctx, state, n)
+	}
+	return SampleMessagesWeighted(ctx, b, state, n, nil)
+}
+
+// SampleMessagesWeighted returns up to n messages of state chosen at random
+// without replacement, with probability proportional to weight(m). Messages
+// with a weight <= 0 are never chosen. A nil weight samples uniformly.
+// The result is ordered by descending sampling key, i.e. random order.
+func SampleMessagesWeighted(ctx context.Context, b Backend, state QueueState, n int, weight func(MessageMetadata) float64) ([]MessageMetadata, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	iter, err := b.NewMessageIterator(ctx, state, 100)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	// Efraimidis-Spirakis: keep the n largest keys u^(1/w)
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	type keyed struct {
+		key float64
+		m   MessageMetadata
+	}
+	reservoir := make([]keyed, 0, n)
+	minIdx := -1
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		w := 1.0
+		if weight != nil {
+			if w = weight(m); w <= 0 {
+				continue
+			}
+		}
+		key := math.Pow(rng.Float64(), 1/w)
+		if len(reservoir) < n {
+			reservoir = append(reservoir, keyed{key, m})
+			if minIdx < 0 || key < reservoir[minIdx].key {
+				minIdx = len(reservoir) - 1
+			}
+			continue
+		}
+		if key <= reservoir[minIdx].key {
+			continue
+		}
+		reservoir[minIdx] = keyed{key, m}
+		for i := range reservoir {
+			if reservoir[i].key < reservoir[minIdx].key {
+				minIdx = i
+			}
+		}
+	}
+
+	sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].key > reservoir[j].key })
+	out := make([]MessageMetadata, len(reservoir))
+	for i, k := range reservoir {
+		out[i] = k.m
+	}
+	return out, nil
+}
@@ -0,0 +1,98 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy computes the delay before the next attempt of a message
+type Policy interface {
+	// Delay returns the wait after the failed attempt number attempt (1 =
+	// the first attempt failed)
+	Delay(attempt int) time.Duration
+}
+
+// Fixed retries after the same delay every time
+type Fixed time.Duration
+
+// Delay returns the fixed delay
+func (f Fixed) Delay(int) time.Duration {
+	return time.Duration(f)
+}
+
+func (f Fixed) String() string {
+	return "fixed:" + time.Duration(f).String()
+}
+
+// Exponential multiplies the delay by Factor after every failed attempt,
+// starting at Initial and capped at Max
+type Exponential struct {
+	Initial time.Duration
+	Max     time.Duration // 0 = uncapped
+	Factor  float64       // default 2
+}
+
+// Delay returns Initial * Factor^(attempt-1), capped at Max
+func (e Exponential) Delay(attempt int) time.Duration {
+	factor := e.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+	d := float64(e.Initial) * math.Pow(factor, float64(max(attempt-1, 0)))
+	if e.Max > 0 && d > float64(e.Max) {
+		return e.Max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+func (e Exponential) String() string {
+	return fmt.Sprintf("exp:%s:%s:%g", e.Initial, e.Max, e.Factor)
+}
+
+// DefaultPolicy doubles the delay from one minute up to four hours
+var DefaultPolicy Policy = Exponential{Initial: time.Minute, Max: 4 * time.Hour, Factor: 2}
+
+// ParsePolicy parses "fixed:<delay>" or "exp:<initial>[:<max>[:<factor>]]",
+// e.g. "fixed:15m" or "exp:1m:4h:2"
+func ParsePolicy(s string) (Policy, error) {
+	kind, args, _ := strings.Cut(s, ":")
+	parts := strings.Split(args, ":")
+	switch kind {
+	case "fixed":
+		if len(parts) != 1 {
+			return nil, fmt.Errorf("retry policy %q: want fixed:<delay>", s)
+		}
+		d, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("retry policy %q: %w", s, err)
+		}
+		return Fixed(d), nil
+	case "exp":
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("retry policy %q: want exp:<initial>[:<max>[:<factor>]]", s)
+		}
+		var e Exponential
+		var err error
+		if e.Initial, err = time.ParseDuration(parts[0]); err != nil {
+			return nil, fmt.Errorf("retry policy %q: %w", s, err)
+		}
+		if len(parts) > 1 {
+			if e.Max, err = time.ParseDuration(parts[1]); err != nil {
+				return nil, fmt.Errorf("retry policy %q: %w", s, err)
+			}
+		}
+		if len(parts) > 2 {
+			if e.Factor, err = strconv.ParseFloat(parts[2], 64); err != nil {
+				return nil, fmt.Errorf("retry policy %q: %w", s, err)
+			}
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown retry policy %q", s)
+}
@@ -0,0 +1,353 @@
+// Package simulate projects how a spool behaves under a workload before it
+// happens in production. It replays recorded arrivals (e.g. from an
+// export dump) or synthetic ones against a retry policy, a failure model
+// and a delivery capacity in simulated time, and reports queue depths over
+// time and retry storms: periods where retries spike far above their
+// steady state, e.g. after an outage.
+//
+// The simulation runs entirely in memory and does not touch a backend.
+package simulate
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Arrival is a message entering the spool
+type Arrival struct {
+	At          time.Duration // Offset from the start of the simulation
+	MaxAttempts int           // Overrides Config.MaxAttempts if > 0
+}
+
+// ArrivalsFromMessages returns the arrivals of recorded messages, e.g. an
+// export dump, by their Created time relative to the oldest message.
+// Messages without Created are skipped.
+func ArrivalsFromMessages(ms []metastorage.MessageMetadata) []Arrival {
+	var first time.Time
+	for _, m := range ms {
+		if !m.Created.IsZero() && (first.IsZero() || m.Created.Before(first)) {
+			first = m.Created
+		}
+	}
+	arrivals := make([]Arrival, 0, len(ms))
+	for _, m := range ms {
+		if m.Created.IsZero() {
+			continue
+		}
+		arrivals = append(arrivals, Arrival{At: m.Created.Sub(first), MaxAttempts: m.MaxAttempts})
+	}
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].At < arrivals[j].At })
+	return arrivals
+}
+
+// Outage is a period in which every delivery attempt fails transiently,
+// e.g. a downstream server being unreachable
+type Outage struct {
+	Start, End time.Duration
+}
+
+// Config describes a simulation. Zero values select defaults.
+type Config struct {
+	Arrivals    []Arrival     // Recorded arrivals; if empty, ArrivalRate generates them
+	ArrivalRate float64       // Synthetic Poisson arrivals per second
+	Duration    time.Duration // Simulated time, default 24h after the last recorded arrival or 24h
+
+	Policy        Policy  // Retry delays, default DefaultPolicy
+	Jitter        float64 // Random relative variation of delays (0..1)
+	MaxAttempts   int     // Attempts before a message is given up, default 10, < 0 = unlimited
+	FailureRate   float64 // Probability an attempt fails transiently
+	PermanentRate float64 // Probability an attempt fails permanently (bounce)
+	Outages       []Outage
+
+	Throughput float64 // Delivery attempts per second, 0 = unlimited
+
+	Step           time.Duration // Simulation resolution, default 1s
+	SampleInterval time.Duration // Time between reported samples, default 1m
+	StormFactor    float64       // Samples with more than StormFactor times the median retries of busy samples are a storm, default 3
+	Seed           int64
+}
+
+// Sample is the state of the spool at the end of a sample interval, with
+// the activity during it
+type Sample struct {
+	At       time.Duration
+	Ready    int // Due messages waiting for delivery capacity
+	Deferred int // Messages waiting for their retry time
+	Arrivals int
+	Attempts int
+	Retries  int // Attempts that were not a message's first
+}
+
+// Storm is a period of consecutive samples with retry storm conditions
+type Storm struct {
+	Start, End  time.Duration
+	PeakRetries int // Most retries in one sample
+}
+
+// Report is the result of a simulation
+type Report struct {
+	Samples   []Sample
+	Storms    []Storm
+	Arrived   int
+	Delivered int
+	Bounced   int // Failed permanently
+	Exhausted int // Gave up after MaxAttempts
+	Pending   int // Still queued at the end
+	Attempts  int
+
+	PeakReady      int
+	PeakReadyAt    time.Duration
+	PeakDeferred   int
+	PeakDeferredAt time.Duration
+	MeanLatency    time.Duration // Arrival to delivery of delivered messages
+	MaxLatency     time.Duration
+}
+
+// String formats the summary of r
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "arrived %d, delivered %d, bounced %d, exhausted %d, pending %d, %d attempts\n",
+		r.Arrived, r.Delivered, r.Bounced, r.Exhausted, r.Pending, r.Attempts)
+	fmt.Fprintf(&b, "peak ready %d at %s, peak deferred %d at %s\n", r.PeakReady, r.PeakReadyAt, r.PeakDeferred, r.PeakDeferredAt)
+	fmt.Fprintf(&b, "delivery latency mean %s, max %s\n", r.MeanLatency.Round(time.Second), r.MaxLatency.Round(time.Second))
+	for _, s := range r.Storms {
+		fmt.Fprintf(&b, "  retry storm %s - %s, peak %d retries per sample\n", s.Start, s.End, s.PeakRetries)
+	}
+	return b.String()
+}
+
+// message is a simulated message
+type message struct {
+	arrived     time.Duration
+	attempts    int
+	maxAttempts int
+	next        time.Duration
+}
+
+// deferredQueue is a min-heap of messages by next attempt time
+type deferredQueue []*message
+
+func (q deferredQueue) Len() int           { return len(q) }
+func (q deferredQueue) Less(i, j int) bool { return q[i].next < q[j].next }
+func (q deferredQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *deferredQueue) Push(x any)        { *q = append(*q, x.(*message)) }
+func (q *deferredQueue) Pop() any {
+	old := *q
+	m := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return m
+}
+
+// Run simulates cfg until Duration or ctx is done
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if err := cfg.defaults(); err != nil {
+		return Report{}, err
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	arrivals := cfg.Arrivals
+	if len(arrivals) == 0 {
+		arrivals = poisson(rng, cfg.ArrivalRate, cfg.Duration)
+	}
+
+	var (
+		r         Report
+		ready     []*message
+		deferred  deferredQueue
+		next      int     // index of the next arrival
+		budget    float64 // unused delivery capacity
+		sample    Sample
+		latencies time.Duration
+	)
+	stepsPerSample := int(cfg.SampleInterval / cfg.Step)
+	for step := 0; ; step++ {
+		now := time.Duration(step) * cfg.Step
+		if now >= cfg.Duration {
+			break
+		}
+		if step%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return r, err
+			}
+		}
+		end := now + cfg.Step
+
+		for ; next < len(arrivals) && arrivals[next].At < end; next++ {
+			max := cfg.MaxAttempts
+			if arrivals[next].MaxAttempts > 0 {
+				max = arrivals[next].MaxAttempts
+			}
+			ready = append(ready, &message{arrived: arrivals[next].At, maxAttempts: max})
+			sample.Arrivals++
+			r.Arrived++
+		}
+		for deferred.Len() > 0 && deferred[0].next < end {
+			ready = append(ready, heap.Pop(&deferred).(*message))
+		}
+
+		n := len(ready)
+		if cfg.Throughput > 0 {
+			budget += cfg.Throughput * cfg.Step.Seconds()
+			n = min(n, int(budget))
+			budget -= float64(n)
+			// capacity left idle is not saved up beyond one step
+			budget = min(budget, cfg.Throughput*cfg.Step.Seconds())
+		}
+		outage := cfg.inOutage(now)
+		for _, m := range ready[:n] {
+			sample.Attempts++
+			r.Attempts++
+			if m.attempts > 0 {
+				sample.Retries++
+			}
+			m.attempts++
+			p := rng.Float64()
+			switch {
+			case outage || (p >= cfg.PermanentRate && p < cfg.PermanentRate+cfg.FailureRate):
+				if m.maxAttempts > 0 && m.attempts >= m.maxAttempts {
+					r.Exhausted++
+					continue
+				}
+				m.next = now + cfg.delay(rng, m.attempts)
+				heap.Push(&deferred, m)
+			case p < cfg.PermanentRate:
+				r.Bounced++
+			default:
+				r.Delivered++
+				latency := max(now-m.arrived, 0) // arrivals land anywhere within the step
+				latencies += latency
+				r.MaxLatency = max(r.MaxLatency, latency)
+			}
+		}
+		ready = append(ready[:0], ready[n:]...)
+
+		if (step+1)%stepsPerSample == 0 {
+			sample.At = end
+			sample.Ready = len(ready)
+			sample.Deferred = deferred.Len()
+			if sample.Ready > r.PeakReady {
+				r.PeakReady, r.PeakReadyAt = sample.Ready, end
+			}
+			if sample.Deferred > r.PeakDeferred {
+				r.PeakDeferred, r.PeakDeferredAt = sample.Deferred, end
+			}
+			r.Samples = append(r.Samples, sample)
+			sample = Sample{}
+		}
+	}
+	r.Pending = len(ready) + deferred.Len() + len(arrivals) - next
+	if r.Delivered > 0 {
+		r.MeanLatency = latencies / time.Duration(r.Delivered)
+	}
+	r.Storms = storms(r.Samples, cfg.StormFactor)
+	return r, nil
+}
+
+func (cfg *Config) defaults() error {
+	if len(cfg.Arrivals) == 0 && cfg.ArrivalRate <= 0 {
+		return errors.New("simulate: no arrivals and no arrival rate")
+	}
+	if cfg.FailureRate < 0 || cfg.PermanentRate < 0 || cfg.FailureRate+cfg.PermanentRate > 1 {
+		return fmt.Errorf("simulate: failure rates %g + %g out of range 0..1", cfg.FailureRate, cfg.PermanentRate)
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 24 * time.Hour
+		if n := len(cfg.Arrivals); n > 0 {
+			cfg.Duration += cfg.Arrivals[n-1].At
+		}
+	}
+	if cfg.Policy == nil {
+		cfg.Policy = DefaultPolicy
+	}
+	cfg.Jitter = min(max(cfg.Jitter, 0), 1)
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.Step <= 0 {
+		cfg.Step = time.Second
+	}
+	if cfg.SampleInterval < cfg.Step {
+		cfg.SampleInterval = max(time.Minute, cfg.Step)
+	}
+	if cfg.StormFactor <= 0 {
+		cfg.StormFactor = 3
+	}
+	return nil
+}
+
+func (cfg *Config) inOutage(t time.Duration) bool {
+	for _, o := range cfg.Outages {
+		if t >= o.Start && t < o.End {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the jittered policy delay, at least one step
+func (cfg *Config) delay(rng *rand.Rand, attempt int) time.Duration {
+	d := cfg.Policy.Delay(attempt)
+	if cfg.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + cfg.Jitter*(2*rng.Float64()-1)))
+	}
+	return max(d, cfg.Step)
+}
+
+// poisson generates arrivals with exponentially distributed gaps
+func poisson(rng *rand.Rand, rate float64, d time.Duration) []Arrival {
+	var arrivals []Arrival
+	for t := time.Duration(0); ; {
+		t += time.Duration(rng.ExpFloat64() / rate * float64(time.Second))
+		if t >= d {
+			return arrivals
+		}
+		arrivals = append(arrivals, Arrival{At: t})
+	}
+}
+
+// storms finds runs of samples with more than factor times the median
+// retries per busy sample, i.e. retries spiking above their steady state
+func storms(samples []Sample, factor float64) []Storm {
+	if len(samples) == 0 {
+		return nil
+	}
+	// idle samples, e.g. while draining after the last arrival, do not
+	// count towards the steady state
+	var retries []int
+	for _, s := range samples {
+		if s.Attempts > 0 {
+			retries = append(retries, s.Retries)
+		}
+	}
+	if len(retries) == 0 {
+		return nil
+	}
+	sort.Ints(retries)
+	threshold := max(factor*float64(retries[len(retries)/2]), 1)
+	var out []Storm
+	var cur *Storm
+	for i, s := range samples {
+		if float64(s.Retries) <= threshold {
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			start := time.Duration(0)
+			if i > 0 {
+				start = samples[i-1].At
+			}
+			out = append(out, Storm{Start: start})
+			cur = &out[len(out)-1]
+		}
+		cur.End = s.At
+		cur.PeakRetries = max(cur.PeakRetries, s.Retries)
+	}
+	return out
+}
@@ -0,0 +1,26 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// SnapshotBackend extends Backend with saving and restoring its complete
+// contents, so embedded backends can persist on graceful shutdown and
+// restart in seconds instead of rebuilding indexes and counters from a
+// full scan
+type SnapshotBackend interface {
+	Backend
+
+	// SaveState writes a consistent snapshot of all messages to w
+	SaveState(ctx context.Context, w io.Writer) error
+
+	// LoadState replaces the contents of the backend with a snapshot
+	// written by SaveState
+	LoadState(ctx context.Context, r io.Reader) error
+}
+
+// ErrSnapshotUnsupported is returned when no layer of a backend
+// implements SnapshotBackend
+var ErrSnapshotUnsupported = errors.New("backend does not support snapshots")
@@ -0,0 +1,62 @@
+//go:build !metaminimal && !tinygo && !js
+
+package metastorage
+
+import (
+	"bufio"
+	"context"
+	"os"
+)
+
+// SaveStateFile writes a snapshot of b to path. The file is replaced
+// atomically, so a crash during the save leaves the previous snapshot.
+// Like LoadStateFile it needs a file system and is left out of minimal
+// builds: with the build tag metaminimal, TinyGo and js/wasm.
+func SaveStateFile(ctx context.Context, b Backend, path string) error {
+	s, ok := As[SnapshotBackend](b)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = s.SaveState(ctx, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadStateFile restores b from the snapshot at path. It reports false
+// without error if there is no snapshot yet.
+func LoadStateFile(ctx context.Context, b Backend, path string) (bool, error) {
+	s, ok := As[SnapshotBackend](b)
+	if !ok {
+		return false, ErrSnapshotUnsupported
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if err := s.LoadState(ctx, bufio.NewReader(f)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,604 @@
+// Package soak drives a backend with a randomized workload at a target
+// operation rate for long periods while continuously verifying that its
+// contents match an in-process model. It is meant to qualify new backend
+// implementations before they are used in production.
+//
+// The runner only touches messages it created (IDs are prefixed with a
+// per-run prefix), so it can run against a backend that holds other data.
+// Counter invariants (StateCounterBackend) are only meaningful when no
+// other writer uses the backend and are therefore opt-in.
+package soak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// Config controls a soak run
+type Config struct {
+	Duration      time.Duration // Total run time, default 1h
+	Rate          int           // Target operations per second across all workers, default 100
+	Workers       int           // Concurrent workers, default 4
+	MaxMessages   int           // Live messages per worker, default 1000
+	CheckInterval time.Duration // Invariant check interval, default 30s
+	BatchSize     int           // Iterator batch size used by checks, default 500
+	Seed          int64         // Workload seed, default derived from the start time
+	IDPrefix      string        // Message ID prefix, default "soak-<unix>-"
+	CheckCounters bool          // Verify StateCounterBackend counts (requires exclusive use of the backend)
+	FailFast      bool          // Stop at the first invariant violation
+	Cleanup       bool          // Delete all messages created by the run at the end
+
+	// Progress is called after every invariant check with the current report
+	Progress func(Report)
+}
+
+// Violation describes a failed invariant
+type Violation struct {
+	At        time.Time
+	Invariant string
+	Detail    string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %s: %s", v.At.Format(time.RFC3339), v.Invariant, v.Detail)
+}
+
+// OpStats aggregates the outcome of one operation type
+type OpStats struct {
+	Count   int64
+	Errors  int64
+	Latency LatencySummary
+}
+
+// LatencySummary holds latency percentiles
+type LatencySummary struct {
+	P50, P99, Max time.Duration
+}
+
+// Report summarizes a run
+type Report struct {
+	Started    time.Time
+	Elapsed    time.Duration
+	Ops        map[string]OpStats
+	Checks     int
+	Violations []Violation
+}
+
+// OK reports whether the run finished without violations
+func (r Report) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// String renders a multi-line summary
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "elapsed %s, %d checks, %d violations\n", r.Elapsed.Round(time.Second), r.Checks, len(r.Violations))
+	names := make([]string, 0, len(r.Ops))
+	for name := range r.Ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := r.Ops[name]
+		fmt.Fprintf(&b, "  %-8s n=%-9d err=%-6d p50=%-10s p99=%-10s max=%s\n", name, s.Count, s.Errors, s.Latency.P50, s.Latency.P99, s.Latency.Max)
+	}
+	for _, v := range r.Violations {
+		fmt.Fprintf(&b, "  VIOLATION %s\n", v)
+	}
+	return b.String()
+}
+
+// ErrViolation is returned by Run when invariants were violated
+var ErrViolation = errors.New("soak: invariant violated")
+
+// Runner executes a soak run
+type Runner struct {
+	backend metastorage.Backend
+	cfg     Config
+
+	// workers hold pause.RLock while executing an operation and updating
+	// their model; checks take the write lock to observe a quiescent state
+	pause  sync.RWMutex
+	models []*model
+
+	statsMu    sync.Mutex
+	latencies  map[string]*reservoir
+	errors     map[string]int64
+	violations []Violation
+	checks     int
+	started    time.Time
+	baseline   map[metastorage.QueueState]int64
+	violated   atomic.Bool
+}
+
+type model struct {
+	messages map[string]metastorage.MessageMetadata
+	ids      []string
+}
+
+// New creates a runner for backend
+func New(backend metastorage.Backend, cfg Config) *Runner {
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Hour
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = 100
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = 1000
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = 30 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	now := time.Now()
+	if cfg.Seed == 0 {
+		cfg.Seed = now.UnixNano()
+	}
+	if cfg.IDPrefix == "" {
+		cfg.IDPrefix = fmt.Sprintf("soak-%d-", now.Unix())
+	}
+	r := &Runner{
+		backend:   backend,
+		cfg:       cfg,
+		latencies: make(map[string]*reservoir),
+		errors:    make(map[string]int64),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		r.models = append(r.models, &model{messages: make(map[string]metastorage.MessageMetadata)})
+	}
+	return r
+}
+
+// Run executes the workload until the configured duration elapses, ctx is
+// cancelled or (with FailFast) an invariant is violated. A final invariant
+// check always runs. Run returns ErrViolation if any invariant failed.
+func (r *Runner) Run(ctx context.Context) (Report, error) {
+	r.started = time.Now()
+	if r.cfg.CheckCounters {
+		counter, ok := metastorage.As[metastorage.StateCounterBackend](r.backend)
+		if !ok {
+			return Report{}, errors.New("soak: CheckCounters requires a StateCounterBackend")
+		}
+		r.baseline = make(map[metastorage.QueueState]int64)
+		for _, state := range metastorage.States() {
+			r.baseline[state] = counter.GetStateCount(state)
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, r.cfg.Duration)
+	defer cancel()
+
+	tokens := make(chan struct{})
+	go r.pace(runCtx, tokens)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r.work(runCtx, worker, tokens)
+		}(i)
+	}
+
+	ticker := time.NewTicker(r.cfg.CheckInterval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-runCtx.Done():
+			break loop
+		case <-ticker.C:
+			r.check(runCtx)
+			if r.cfg.Progress != nil {
+				r.cfg.Progress(r.report())
+			}
+			if r.cfg.FailFast && r.violated.Load() {
+				cancel()
+				break loop
+			}
+		}
+	}
+	wg.Wait()
+
+	// final check with a fresh context so a finished duration doesn't abort it
+	final, finalCancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Minute)
+	defer finalCancel()
+	r.check(final)
+	if r.cfg.Cleanup {
+		r.cleanup(final)
+	}
+
+	report := r.report()
+	if !report.OK() {
+		return report, ErrViolation
+	}
+	return report, nil
+}
+
+// pace emits Rate tokens per second
+func (r *Runner) pace(ctx context.Context, tokens chan<- struct{}) {
+	interval := time.Second / time.Duration(r.cfg.Rate)
+	if interval <= 0 {
+		interval = time.Nanosecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			default: // workers are saturated; drop the token
+			}
+		}
+	}
+}
+
+func (r *Runner) work(ctx context.Context, worker int, tokens <-chan struct{}) {
+	rng := rand.New(rand.NewSource(r.cfg.Seed + int64(worker)))
+	m := r.models[worker]
+	seq := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tokens:
+		}
+
+		r.pause.RLock()
+		op := r.pick(rng, m)
+		var err error
+		start := time.Now()
+		switch op {
+		case "store":
+			seq++
+			err = r.store(ctx, m, fmt.Sprintf("%s%d-%d", r.cfg.IDPrefix, worker, seq), rng)
+		case "update":
+			err = r.update(ctx, m, rng)
+		case "move":
+			err = r.move(ctx, m, rng)
+		case "get":
+			err = r.get(ctx, m, rng)
+		case "delete":
+			err = r.delete(ctx, m, rng)
+		}
+		r.pause.RUnlock()
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			return
+		}
+		r.observe(op, time.Since(start), err)
+	}
+}
+
+func (r *Runner) pick(rng *rand.Rand, m *model) string {
+	if len(m.ids) == 0 {
+		return "store"
+	}
+	x := rng.Intn(100)
+	switch {
+	case x < 30:
+		if len(m.ids) >= r.cfg.MaxMessages {
+			return "delete"
+		}
+		return "store"
+	case x < 50:
+		return "update"
+	case x < 75:
+		return "move"
+	case x < 90:
+		return "get"
+	default:
+		return "delete"
+	}
+}
+
+func (r *Runner) store(ctx context.Context, m *model, id string, rng *rand.Rand) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	meta := metastorage.MessageMetadata{
+		ID:          id,
+		State:       metastorage.StateIncoming,
+		MaxAttempts: 5,
+		Created:     now,
+		Updated:     now,
+		Priority:    rng.Intn(3),
+		Size:        int64(rng.Intn(1 << 20)),
+		Headers:     map[string]string{"soak": "1", "to": fmt.Sprintf("rcpt%d@example.com", rng.Intn(1000))},
+	}
+	if err := r.backend.StoreMeta(ctx, id, meta); err != nil {
+		return err
+	}
+	m.messages[id] = meta
+	m.ids = append(m.ids, id)
+	return nil
+}
+
+func (r *Runner) randomID(m *model, rng *rand.Rand) (int, string) {
+	i := rng.Intn(len(m.ids))
+	return i, m.ids[i]
+}
+
+func (r *Runner) update(ctx context.Context, m *model, rng *rand.Rand) error {
+	_, id := r.randomID(m, rng)
+	meta := m.messages[id]
+	meta.Attempts++
+	meta.LastError = fmt.Sprintf("soak error %d", rng.Intn(100))
+	meta.NextRetry = time.Now().UTC().Add(time.Duration(rng.Intn(3600)) * time.Second).Truncate(time.Millisecond)
+	meta.Updated = time.Now().UTC().Truncate(time.Millisecond)
+	if err := r.backend.UpdateMeta(ctx, id, meta); err != nil {
+		return err
+	}
+	m.messages[id] = meta
+	return nil
+}
+
+func (r *Runner) move(ctx context.Context, m *model, rng *rand.Rand) error {
+	_, id := r.randomID(m, rng)
+	meta := m.messages[id]
+	states := metastorage.States()
+	to := states[rng.Intn(len(states))]
+	if to == meta.State {
+		to = states[(int(to)+1)%len(states)]
+	}
+
+	// a stale fromState must be rejected
+	stale := states[(int(meta.State)+1)%len(states)]
+	if err := r.backend.MoveToState(ctx, id, stale, to); !errors.Is(err, metastorage.ErrStateConflict) {
+		r.violate("cas", fmt.Sprintf("MoveToState(%s, %s->%s) with stale fromState returned %v, want ErrStateConflict", id, stale, to, err))
+	}
+
+	if err := r.backend.MoveToState(ctx, id, meta.State, to); err != nil {
+		return err
+	}
+	meta.State = to
+	m.messages[id] = meta
+	return nil
+}
+
+func (r *Runner) get(ctx context.Context, m *model, rng *rand.Rand) error {
+	_, id := r.randomID(m, rng)
+	want := m.messages[id]
+	got, err := r.backend.GetMeta(ctx, id)
+	if err != nil {
+		if errors.Is(err, metastorage.ErrMessageNotFound) {
+			r.violate("get", fmt.Sprintf("%s: stored message not found", id))
+			return nil
+		}
+		return err
+	}
+	if d := compare(want, got); d != "" {
+		r.violate("get", fmt.Sprintf("%s: %s", id, d))
+	}
+	return nil
+}
+
+func (r *Runner) delete(ctx context.Context, m *model, rng *rand.Rand) error {
+	i, id := r.randomID(m, rng)
+	if err := r.backend.DeleteMeta(ctx, id); err != nil {
+		return err
+	}
+	delete(m.messages, id)
+	m.ids[i] = m.ids[len(m.ids)-1]
+	m.ids = m.ids[:len(m.ids)-1]
+
+	if _, err := r.backend.GetMeta(ctx, id); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		r.violate("delete", fmt.Sprintf("%s: GetMeta after delete returned %v, want ErrMessageNotFound", id, err))
+	}
+	return nil
+}
+
+// compare returns a description of the first difference between the model and the backend
+func compare(want, got metastorage.MessageMetadata) string {
+	switch {
+	case got.State != want.State:
+		return fmt.Sprintf("state %s, want %s", got.State, want.State)
+	case got.Attempts != want.Attempts:
+		return fmt.Sprintf("attempts %d, want %d", got.Attempts, want.Attempts)
+	case got.Priority != want.Priority:
+		return fmt.Sprintf("priority %d, want %d", got.Priority, want.Priority)
+	case got.LastError != want.LastError:
+		return fmt.Sprintf("last error %q, want %q", got.LastError, want.LastError)
+	case !got.NextRetry.Equal(want.NextRetry):
+		return fmt.Sprintf("next retry %s, want %s", got.NextRetry, want.NextRetry)
+	case len(got.Headers) != len(want.Headers):
+		return fmt.Sprintf("%d headers, want %d", len(got.Headers), len(want.Headers))
+	}
+	for k, v := range want.Headers {
+		if got.Headers[k] != v {
+			return fmt.Sprintf("header %s=%q, want %q", k, got.Headers[k], v)
+		}
+	}
+	return ""
+}
+
+// check pauses all workers and verifies list, iterator and counter invariants
+func (r *Runner) check(ctx context.Context) {
+	r.pause.Lock()
+	defer r.pause.Unlock()
+
+	r.statsMu.Lock()
+	r.checks++
+	r.statsMu.Unlock()
+
+	expected := make(map[metastorage.QueueState]map[string]bool)
+	for _, state := range metastorage.States() {
+		expected[state] = make(map[string]bool)
+	}
+	for _, m := range r.models {
+		for id, meta := range m.messages {
+			expected[meta.State][id] = true
+		}
+	}
+
+	for _, state := range metastorage.States() {
+		if ctx.Err() != nil {
+			return
+		}
+		seen, total, err := r.scan(ctx, state)
+		if err != nil {
+			r.violate("iterate", fmt.Sprintf("state %s: %v", state, err))
+			continue
+		}
+		for id := range expected[state] {
+			if !seen[id] {
+				r.violate("iterate", fmt.Sprintf("state %s: %s missing", state, id))
+			}
+		}
+		for id := range seen {
+			if !expected[state][id] {
+				r.violate("iterate", fmt.Sprintf("state %s: unexpected %s", state, id))
+			}
+		}
+
+		list, err := r.backend.ListMessages(ctx, state, metastorage.MessageListOptions{Limit: 1})
+		if err != nil {
+			r.violate("list", fmt.Sprintf("state %s: %v", state, err))
+		} else if list.Total != total {
+			r.violate("list", fmt.Sprintf("state %s: ListMessages total %d, iterator saw %d", state, list.Total, total))
+		}
+
+		if r.baseline != nil {
+			counter, _ := metastorage.As[metastorage.StateCounterBackend](r.backend)
+			want := r.baseline[state] + int64(len(expected[state]))
+			if got := counter.GetStateCount(state); got != want {
+				r.violate("counter", fmt.Sprintf("state %s: GetStateCount %d, want %d", state, got, want))
+			}
+		}
+	}
+}
+
+// scan returns the run's own message IDs in state and the total number of messages seen
+func (r *Runner) scan(ctx context.Context, state metastorage.QueueState) (map[string]bool, int, error) {
+	iter, err := r.backend.NewMessageIterator(ctx, state, r.cfg.BatchSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer iter.Close()
+
+	seen := make(map[string]bool)
+	total := 0
+	for {
+		meta, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !more {
+			return seen, total, nil
+		}
+		total++
+		if strings.HasPrefix(meta.ID, r.cfg.IDPrefix) {
+			if seen[meta.ID] {
+				r.violate("iterate", fmt.Sprintf("state %s: %s returned twice", state, meta.ID))
+			}
+			seen[meta.ID] = true
+		}
+	}
+}
+
+func (r *Runner) cleanup(ctx context.Context) {
+	for _, m := range r.models {
+		for _, id := range m.ids {
+			_ = r.backend.DeleteMeta(ctx, id)
+		}
+		m.ids = nil
+		m.messages = make(map[string]metastorage.MessageMetadata)
+	}
+}
+
+func (r *Runner) violate(invariant, detail string) {
+	r.statsMu.Lock()
+	r.violations = append(r.violations, Violation{At: time.Now(), Invariant: invariant, Detail: detail})
+	r.statsMu.Unlock()
+	r.violated.Store(true)
+}
+
+func (r *Runner) observe(op string, d time.Duration, err error) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	res, ok := r.latencies[op]
+	if !ok {
+		res = &reservoir{rng: rand.New(rand.NewSource(r.cfg.Seed))}
+		r.latencies[op] = res
+	}
+	res.add(d)
+	if err != nil {
+		r.errors[op]++
+	}
+}
+
+func (r *Runner) report() Report {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	rep := Report{
+		Started:    r.started,
+		Elapsed:    time.Since(r.started),
+		Ops:        make(map[string]OpStats, len(r.latencies)),
+		Checks:     r.checks,
+		Violations: append([]Violation(nil), r.violations...),
+	}
+	for op, res := range r.latencies {
+		sorted := append([]time.Duration(nil), res.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		rep.Ops[op] = OpStats{
+			Count:  res.n,
+			Errors: r.errors[op],
+			Latency: LatencySummary{
+				P50: percentile(sorted, 0.50),
+				P99: percentile(sorted, 0.99),
+				Max: res.max,
+			},
+		}
+	}
+	return rep
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// reservoirSize bounds the latency samples kept per operation so
+// multi-hour runs use constant memory
+const reservoirSize = 10000
+
+// reservoir keeps a uniform sample of observed latencies
+type reservoir struct {
+	rng     *rand.Rand
+	n       int64
+	max     time.Duration
+	samples []time.Duration
+}
+
+func (r *reservoir) add(d time.Duration) {
+	r.n++
+	if d > r.max {
+		r.max = d
+	}
+	if len(r.samples) < reservoirSize {
+		r.samples = append(r.samples, d)
+		return
+	}
+	if i := r.rng.Int63n(r.n); i < reservoirSize {
+		r.samples[i] = d
+	}
+}
@@ -0,0 +1,683 @@
+// Package sqlite implements metastorage.Backend on an SQLite database
+// with the pure Go modernc.org/sqlite driver, for single-binary
+// appliances that need durability without running a database server.
+//
+// Messages are rows of one table with a column per field; headers and the
+// delivery window are stored as JSON text and timestamps as Unix
+// nanoseconds (0 = zero time). A counts table is maintained by triggers,
+// so GetStateCount is a single row lookup. MoveToState is one conditional
+// UPDATE, which SQLite executes atomically.
+//
+// Every write increments the message's version column; updates carrying
+// a version and MoveToStateIfVersion add it to the WHERE clause, see
+// metastorage.VersionBackend.
+//
+// StoreMeta and MoveToState set StateEnteredAt and assign the next
+// Sequence of the state entered in the same transaction, from a counter
+// row per state in the sequences table.
+//
+// Begin starts a metastorage.Txn whose operations Commit runs in one
+// database transaction.
+//
+// Files are opened in WAL mode with a busy timeout, so readers do not
+// block the writer.
+//
+// Importing the package registers the "sqlite://" DSN scheme.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // database/sql driver "sqlite"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+	"schneider.vip/retryspool/storage/meta/registry"
+)
+
+// DefaultTable is the name of the messages table; the counts and
+// sequences tables are named after it with a "_counts" and "_sequences"
+// suffix
+const DefaultTable = "retryspool_meta"
+
+// busyTimeout is how long a connection waits for the write lock
+const busyTimeout = 5 * time.Second
+
+type tableKey struct{}
+
+// WithTable sets the name of the messages table (default DefaultTable)
+func WithTable(name string) options.Option {
+	return options.WithValue(tableKey{}, name)
+}
+
+func init() {
+	registry.Register("sqlite", open)
+}
+
+// open handles "sqlite:///var/spool/retryspool/meta.db" DSNs
+func open(_ context.Context, dsn *url.URL, opts ...options.Option) (metastorage.Backend, error) {
+	if dsn.Path == "" {
+		return nil, errors.New("sqlite DSN: path is required")
+	}
+	return Open(dsn.Path, opts...)
+}
+
+// insertColumns in the order of the insert values
+const insertColumns = "id, state, attempts, max_attempts, next_retry, created, updated, last_error, size, priority, headers, retry_policy, sequence, state_entered_at, delivery_window"
+
+// columns in the order of scan
+const columns = insertColumns + ", version"
+
+// Backend stores message metadata in an SQLite table
+type Backend struct {
+	db        *sql.DB
+	ownsDB    bool
+	table     string // quoted
+	counts    string // quoted
+	sequences string // quoted
+	namespace string
+	batchSize int
+	skew      time.Duration
+	clock     clock.Clock
+}
+
+// Open opens or creates the database file at path and creates the tables
+// if needed. Close closes the database.
+func Open(path string, opts ...options.Option) (*Backend, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		path, busyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	b, err := New(context.Background(), db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	b.ownsDB = true
+	return b, nil
+}
+
+// New creates a backend on db, creating the tables and triggers if
+// needed. Close leaves db open. With a namespace, rows are tagged with
+// it, so several backends can share the tables.
+//
+// An in-memory database exists per connection: limit db to one
+// connection with SetMaxOpenConns(1).
+func New(ctx context.Context, db *sql.DB, opts ...options.Option) (*Backend, error) {
+	o := options.Apply(opts...)
+	name := options.ValueOr(o, tableKey{}, DefaultTable)
+	b := &Backend{
+		db:        db,
+		table:     quote(name),
+		counts:    quote(name + "_counts"),
+		sequences: quote(name + "_sequences"),
+		namespace: o.Namespace,
+		batchSize: o.BatchSize,
+		skew:      o.ClockSkew,
+		clock:     o.Clock,
+	}
+	if err := b.migrate(ctx, name); err != nil {
+		return nil, fmt.Errorf("sqlite: create schema: %w", err)
+	}
+	return b, nil
+}
+
+// ClockSkew returns the skew window set with options.WithClockSkew, see
+// metastorage.SkewBackend
+func (b *Backend) ClockSkew() time.Duration {
+	return b.skew
+}
+
+// quote returns name as an SQL identifier
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (b *Backend) migrate(ctx context.Context, name string) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + b.table + ` (
+			namespace        TEXT    NOT NULL DEFAULT '',
+			id               TEXT    NOT NULL,
+			state            INTEGER NOT NULL,
+			attempts         INTEGER NOT NULL DEFAULT 0,
+			max_attempts     INTEGER NOT NULL DEFAULT 0,
+			next_retry       INTEGER NOT NULL DEFAULT 0,
+			created          INTEGER NOT NULL DEFAULT 0,
+			updated          INTEGER NOT NULL DEFAULT 0,
+			last_error       TEXT    NOT NULL DEFAULT '',
+			size             INTEGER NOT NULL DEFAULT 0,
+			priority         INTEGER NOT NULL DEFAULT 0,
+			headers          TEXT,
+			retry_policy     TEXT    NOT NULL DEFAULT '',
+			sequence         INTEGER NOT NULL DEFAULT 0,
+			state_entered_at INTEGER NOT NULL DEFAULT 0,
+			delivery_window  TEXT,
+			version          INTEGER NOT NULL DEFAULT 1,
+			PRIMARY KEY (namespace, id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + b.counts + ` (
+			namespace TEXT    NOT NULL,
+			state     INTEGER NOT NULL,
+			n         INTEGER NOT NULL,
+			PRIMARY KEY (namespace, state)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + b.sequences + ` (
+			namespace TEXT    NOT NULL,
+			state     INTEGER NOT NULL,
+			last      INTEGER NOT NULL,
+			PRIMARY KEY (namespace, state)
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS ` + quote(name+"_count_insert") + ` AFTER INSERT ON ` + b.table + ` BEGIN
+			INSERT INTO ` + b.counts + ` (namespace, state, n) VALUES (NEW.namespace, NEW.state, 1)
+				ON CONFLICT (namespace, state) DO UPDATE SET n = n + 1;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS ` + quote(name+"_count_delete") + ` AFTER DELETE ON ` + b.table + ` BEGIN
+			UPDATE ` + b.counts + ` SET n = n - 1 WHERE namespace = OLD.namespace AND state = OLD.state;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS ` + quote(name+"_count_move") + ` AFTER UPDATE OF state ON ` + b.table + `
+			WHEN OLD.state != NEW.state BEGIN
+			UPDATE ` + b.counts + ` SET n = n - 1 WHERE namespace = OLD.namespace AND state = OLD.state;
+			INSERT INTO ` + b.counts + ` (namespace, state, n) VALUES (NEW.namespace, NEW.state, 1)
+				ON CONFLICT (namespace, state) DO UPDATE SET n = n + 1;
+		END`,
+	}
+	for _, idx := range []string{"next_retry", "priority", "created"} {
+		stmts = append(stmts, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (namespace, state, %s)`,
+			quote(name+"_state_"+idx), b.table, idx))
+	}
+	// iterators page by ID within a state
+	stmts = append(stmts, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (namespace, state, id)`,
+		quote(name+"_state_id"), b.table))
+	for _, stmt := range stmts {
+		if _, err := b.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return b.addVersionColumn(ctx, name)
+}
+
+// addVersionColumn adds the version column to tables created before it
+// existed; SQLite has no ADD COLUMN IF NOT EXISTS
+func (b *Backend) addVersionColumn(ctx context.Context, name string) error {
+	var n int
+	err := b.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'version'`, name).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = b.db.ExecContext(ctx, `ALTER TABLE `+b.table+` ADD COLUMN version INTEGER NOT NULL DEFAULT 1`)
+	return err
+}
+
+// translate maps database/sql errors to metastorage errors
+func translate(err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return metastorage.ErrMessageNotFound
+	case errors.Is(err, sql.ErrConnDone), err != nil && strings.Contains(err.Error(), "database is closed"):
+		return metastorage.ErrBackendClosed
+	}
+	return err
+}
+
+// unixNano maps the zero time to 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano maps 0 to the zero time
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// row holds the scanned columns of a message
+type row struct {
+	m                                           metastorage.MessageMetadata
+	nextRetry, created, updated, stateEnteredAt int64
+	headers, window                             sql.NullString
+	sequence, version                           int64
+}
+
+func (r *row) dest() []any {
+	return []any{&r.m.ID, &r.m.State, &r.m.Attempts, &r.m.MaxAttempts, &r.nextRetry, &r.created, &r.updated,
+		&r.m.LastError, &r.m.Size, &r.m.Priority, &r.headers, &r.m.RetryPolicyName, &r.sequence, &r.stateEnteredAt, &r.window, &r.version}
+}
+
+func (r *row) metadata() (metastorage.MessageMetadata, error) {
+	m := r.m
+	m.Sequence = uint64(r.sequence)
+	m.Version = uint64(r.version)
+	m.NextRetry = fromUnixNano(r.nextRetry)
+	m.Created = fromUnixNano(r.created)
+	m.Updated = fromUnixNano(r.updated)
+	m.StateEnteredAt = fromUnixNano(r.stateEnteredAt)
+	if r.headers.Valid {
+		if err := json.Unmarshal([]byte(r.headers.String), &m.Headers); err != nil {
+			return m, fmt.Errorf("sqlite: %s: headers: %w", m.ID, err)
+		}
+	}
+	if r.window.Valid {
+		if err := json.Unmarshal([]byte(r.window.String), &m.DeliveryWindow); err != nil {
+			return m, fmt.Errorf("sqlite: %s: delivery window: %w", m.ID, err)
+		}
+	}
+	return m, nil
+}
+
+// values returns the column values of m after namespace, in column order
+func values(id string, m metastorage.MessageMetadata) ([]any, error) {
+	var headers, window sql.NullString
+	if m.Headers != nil {
+		data, err := json.Marshal(m.Headers)
+		if err != nil {
+			return nil, err
+		}
+		headers = sql.NullString{String: string(data), Valid: true}
+	}
+	if !m.DeliveryWindow.IsZero() {
+		data, err := json.Marshal(m.DeliveryWindow)
+		if err != nil {
+			return nil, err
+		}
+		window = sql.NullString{String: string(data), Valid: true}
+	}
+	return []any{id, int(m.State), m.Attempts, m.MaxAttempts, unixNano(m.NextRetry), unixNano(m.Created), unixNano(m.Updated),
+		m.LastError, m.Size, m.Priority, headers, m.RetryPolicyName, int64(m.Sequence), unixNano(m.StateEnteredAt), window}, nil
+}
+
+// StoreMeta stores message metadata, replacing an existing message with
+// the same ID. It assigns the next sequence of the message's state and
+// sets StateEnteredAt if it is unset.
+func (b *Backend) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+	if err := b.store(ctx, tx, messageID, metadata); err != nil {
+		return err
+	}
+	return translate(tx.Commit())
+}
+
+// store runs the statements of StoreMeta in tx
+func (b *Backend) store(ctx context.Context, tx *sql.Tx, messageID string, metadata metastorage.MessageMetadata) error {
+	vals, err := values(messageID, metastorage.EnterState(metadata, b.clock.Now()))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+b.table+` (namespace, `+insertColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (namespace, id) DO UPDATE SET
+			state = excluded.state, attempts = excluded.attempts, max_attempts = excluded.max_attempts,
+			next_retry = excluded.next_retry, created = excluded.created, updated = excluded.updated,
+			last_error = excluded.last_error, size = excluded.size, priority = excluded.priority,
+			headers = excluded.headers, retry_policy = excluded.retry_policy, sequence = excluded.sequence,
+			state_entered_at = excluded.state_entered_at, delivery_window = excluded.delivery_window,
+			version = version + 1`,
+		append([]any{b.namespace}, vals...)...)
+	if err != nil {
+		return translate(err)
+	}
+	return b.assignSequence(ctx, tx, messageID, metadata.State)
+}
+
+// assignSequence takes the next sequence of state and sets it on the row
+// of id, within tx
+func (b *Backend) assignSequence(ctx context.Context, tx *sql.Tx, id string, state metastorage.QueueState) error {
+	var last int64
+	err := tx.QueryRowContext(ctx, `INSERT INTO `+b.sequences+` (namespace, state, last) VALUES (?, ?, 1)
+		ON CONFLICT (namespace, state) DO UPDATE SET last = last + 1
+		RETURNING last`, b.namespace, int(state)).Scan(&last)
+	if err != nil {
+		return translate(err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE `+b.table+` SET sequence = ? WHERE namespace = ? AND id = ?`, last, b.namespace, id)
+	return translate(err)
+}
+
+// LastSequence returns the highest sequence assigned in state, see
+// metastorage.SequenceBackend
+func (b *Backend) LastSequence(ctx context.Context, state metastorage.QueueState) (uint64, error) {
+	var last int64
+	err := b.db.QueryRowContext(ctx, `SELECT last FROM `+b.sequences+` WHERE namespace = ? AND state = ?`,
+		b.namespace, int(state)).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, translate(err)
+	}
+	return uint64(last), nil
+}
+
+// TracksStateTime reports that StateEnteredAt is maintained, see
+// metastorage.StateTimeBackend
+func (b *Backend) TracksStateTime() bool {
+	return true
+}
+
+// GetMeta retrieves message metadata
+func (b *Backend) GetMeta(ctx context.Context, messageID string) (metastorage.MessageMetadata, error) {
+	var r row
+	err := b.db.QueryRowContext(ctx, `SELECT `+columns+` FROM `+b.table+` WHERE namespace = ? AND id = ?`,
+		b.namespace, messageID).Scan(r.dest()...)
+	if err != nil {
+		return metastorage.MessageMetadata{}, translate(err)
+	}
+	return r.metadata()
+}
+
+// UpdateMeta replaces the metadata of an existing message. The state is
+// only changed by MoveToState: an update carrying a different state fails
+// with ErrStateConflict, as the caller's copy is outdated. An update
+// carrying a version fails with ErrVersionConflict unless it matches.
+func (b *Backend) UpdateMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	return b.update(ctx, b.db, messageID, metadata)
+}
+
+// update is UpdateMeta with the statements sent to q
+func (b *Backend) update(ctx context.Context, q querier, messageID string, metadata metastorage.MessageMetadata) error {
+	vals, err := values(messageID, metadata)
+	if err != nil {
+		return err
+	}
+	// vals start with id and state, which select the row
+	args := append(vals[2:], b.namespace, vals[0], vals[1])
+	where := "namespace = ? AND id = ? AND state = ?"
+	if metadata.Version != 0 {
+		where += " AND version = ?"
+		args = append(args, int64(metadata.Version))
+	}
+	res, err := q.ExecContext(ctx, `UPDATE `+b.table+` SET
+			attempts = ?, max_attempts = ?, next_retry = ?, created = ?, updated = ?, last_error = ?,
+			size = ?, priority = ?, headers = ?, retry_policy = ?, sequence = ?,
+			state_entered_at = ?, delivery_window = ?, version = version + 1
+		WHERE `+where, args...)
+	if err != nil {
+		return translate(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err != nil {
+			return translate(err)
+		}
+		return b.conflictOrNotFound(ctx, q, messageID, metadata.State, metadata.Version)
+	}
+	return nil
+}
+
+// conflictOrNotFound explains why a conditional statement on id matched no
+// row: the message is missing, not in the expected state, or, for a
+// non-zero version, has another version
+func (b *Backend) conflictOrNotFound(ctx context.Context, q querier, id string, expected metastorage.QueueState, version uint64) error {
+	var (
+		state  metastorage.QueueState
+		stored int64
+	)
+	err := q.QueryRowContext(ctx, `SELECT state, version FROM `+b.table+` WHERE namespace = ? AND id = ?`,
+		b.namespace, id).Scan(&state, &stored)
+	if err != nil {
+		return translate(err)
+	}
+	if state != expected {
+		return fmt.Errorf("%w: %s is %s, expected %s", metastorage.ErrStateConflict, id, state, expected)
+	}
+	return metastorage.CheckVersion(metastorage.MessageMetadata{ID: id, Version: uint64(stored)}, version)
+}
+
+// DeleteMeta removes message metadata
+func (b *Backend) DeleteMeta(ctx context.Context, messageID string) error {
+	return b.delete(ctx, b.db, messageID)
+}
+
+// delete is DeleteMeta with the statement sent to q
+func (b *Backend) delete(ctx context.Context, q querier, messageID string) error {
+	res, err := q.ExecContext(ctx, `DELETE FROM `+b.table+` WHERE namespace = ? AND id = ?`, b.namespace, messageID)
+	if err != nil {
+		return translate(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err != nil {
+			return translate(err)
+		}
+		return metastorage.ErrMessageNotFound
+	}
+	return nil
+}
+
+// MoveToState changes the state with one UPDATE conditional on fromState
+// that also records when the message entered toState, and assigns the
+// next sequence of toState in the same transaction
+func (b *Backend) MoveToState(ctx context.Context, messageID string, fromState, toState metastorage.QueueState) error {
+	return b.move(ctx, messageID, fromState, toState, 0)
+}
+
+// MoveToStateIfVersion is MoveToState with the version added to the
+// condition, see metastorage.VersionBackend
+func (b *Backend) MoveToStateIfVersion(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	return b.move(ctx, messageID, fromState, toState, version)
+}
+
+// move moves the message if it is in fromState and, for a non-zero
+// version, has that version
+func (b *Backend) move(ctx context.Context, messageID string, fromState, toState metastorage.QueueState, version uint64) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+	moved, err := b.moveIn(ctx, tx, messageID, fromState, toState, version)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		// release the write lock before looking at the row
+		tx.Rollback()
+		return b.conflictOrNotFound(ctx, b.db, messageID, fromState, version)
+	}
+	return translate(tx.Commit())
+}
+
+// moveIn runs the statements of move in tx. It reports false if the
+// message is missing or not in fromState with version.
+func (b *Backend) moveIn(ctx context.Context, tx *sql.Tx, messageID string, fromState, toState metastorage.QueueState, version uint64) (bool, error) {
+	query, args := `UPDATE `+b.table+` SET state = ?, state_entered_at = ?, version = version + 1 WHERE namespace = ? AND id = ? AND state = ?`,
+		[]any{int(toState), unixNano(b.clock.Now()), b.namespace, messageID, int(fromState)}
+	if fromState == toState {
+		query, args = `UPDATE `+b.table+` SET state = ?, version = version + 1 WHERE namespace = ? AND id = ? AND state = ?`,
+			[]any{int(toState), b.namespace, messageID, int(fromState)}
+	}
+	if version != 0 {
+		query += " AND version = ?"
+		args = append(args, int64(version))
+	}
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, translate(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, translate(err)
+	}
+	if fromState != toState {
+		if err := b.assignSequence(ctx, tx, messageID, toState); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// GetStateCount returns the trigger-maintained count of state, -1 on
+// errors
+func (b *Backend) GetStateCount(state metastorage.QueueState) int64 {
+	var n int64
+	err := b.db.QueryRow(`SELECT n FROM `+b.counts+` WHERE namespace = ? AND state = ?`, b.namespace, int(state)).Scan(&n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0
+	}
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// sortColumns maps MessageListOptions.SortBy to columns
+var sortColumns = map[string]string{
+	"":         "created",
+	"created":  "created",
+	"updated":  "updated",
+	"priority": "priority",
+	"attempts": "attempts",
+}
+
+// ListMessages lists the IDs of messages in state with the semantics of
+// metastorage.ListPage, sorted and paginated by the database
+func (b *Backend) ListMessages(ctx context.Context, state metastorage.QueueState, opts metastorage.MessageListOptions) (metastorage.MessageListResult, error) {
+	column, ok := sortColumns[strings.ToLower(opts.SortBy)]
+	if !ok {
+		return metastorage.MessageListResult{}, fmt.Errorf("unknown sort field %q", opts.SortBy)
+	}
+	order := "ASC"
+	if strings.EqualFold(opts.SortOrder, "desc") {
+		order = "DESC"
+	}
+	where := `namespace = ? AND state = ?`
+	args := []any{b.namespace, int(state)}
+	if !opts.Since.IsZero() {
+		where += ` AND (created > ? OR updated > ?)`
+		since := opts.Since.UnixNano()
+		args = append(args, since, since)
+	}
+
+	var res metastorage.MessageListResult
+	if err := b.db.QueryRowContext(ctx, `SELECT count(*) FROM `+b.table+` WHERE `+where, args...).Scan(&res.Total); err != nil {
+		return metastorage.MessageListResult{}, translate(err)
+	}
+	offset := max(opts.Offset, 0)
+	if opts.Limit <= 0 || offset >= res.Total {
+		return res, nil
+	}
+	rows, err := b.db.QueryContext(ctx, fmt.Sprintf(`SELECT id FROM %s WHERE %s ORDER BY %s %s, id %s LIMIT ? OFFSET ?`,
+		b.table, where, column, order, order), append(args, opts.Limit, offset)...)
+	if err != nil {
+		return metastorage.MessageListResult{}, translate(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return metastorage.MessageListResult{}, err
+		}
+		res.MessageIDs = append(res.MessageIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return metastorage.MessageListResult{}, translate(err)
+	}
+	res.HasMore = offset+len(res.MessageIDs) < res.Total
+	return res, nil
+}
+
+// NewMessageIterator returns an iterator over the messages in state,
+// ordered by ID. Each batch is a keyset query continuing after the last
+// returned ID, so changes between batches are visible.
+func (b *Backend) NewMessageIterator(ctx context.Context, state metastorage.QueueState, batchSize int) (metastorage.MessageIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = b.batchSize
+	}
+	return &iterator{backend: b, state: state, batchSize: max(batchSize, 1)}, nil
+}
+
+// Close closes the database if the backend was created with Open
+func (b *Backend) Close() error {
+	if b.ownsDB {
+		return b.db.Close()
+	}
+	return nil
+}
+
+type iterator struct {
+	backend   *Backend
+	state     metastorage.QueueState
+	batchSize int
+	after     string
+	batch     []metastorage.MessageMetadata
+	done      bool
+}
+
+// Next returns the next message of the state
+func (it *iterator) Next(ctx context.Context) (metastorage.MessageMetadata, bool, error) {
+	if len(it.batch) == 0 && !it.done {
+		if err := it.fetch(ctx); err != nil {
+			return metastorage.MessageMetadata{}, false, err
+		}
+	}
+	if len(it.batch) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.batch[0]
+	it.batch = it.batch[1:]
+	return m, true, nil
+}
+
+func (it *iterator) fetch(ctx context.Context) error {
+	b := it.backend
+	rows, err := b.db.QueryContext(ctx, `SELECT `+columns+` FROM `+b.table+`
+		WHERE namespace = ? AND state = ? AND id > ? ORDER BY id LIMIT ?`,
+		b.namespace, int(it.state), it.after, it.batchSize)
+	if err != nil {
+		return translate(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(r.dest()...); err != nil {
+			return err
+		}
+		m, err := r.metadata()
+		if err != nil {
+			return err
+		}
+		it.batch = append(it.batch, m)
+		it.after = m.ID
+	}
+	if err := rows.Err(); err != nil {
+		return translate(err)
+	}
+	if len(it.batch) < it.batchSize {
+		it.done = true
+	}
+	return nil
+}
+
+// SetBatchSize changes the size of the following batches, see
+// metastorage.ResizableIterator
+func (it *iterator) SetBatchSize(n int) {
+	if n > 0 {
+		it.batchSize = n
+	}
+}
+
+// Close releases the iterator
+func (it *iterator) Close() error {
+	it.done = true
+	it.batch = nil
+	return nil
+}
@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// querier is where the writes send their statements: the database, or the
+// transaction of a metastorage.Txn
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Begin starts a transaction committed in one database transaction, see
+// metastorage.TxnBackend
+func (b *Backend) Begin(ctx context.Context) (metastorage.Txn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return metastorage.NewTxn(b.commit), nil
+}
+
+// commit runs ops in a database transaction; a failing operation rolls
+// back all of them
+func (b *Backend) commit(ctx context.Context, ops []metastorage.TxnOp) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback()
+	for i, op := range ops {
+		switch op.Kind {
+		case metastorage.TxnStore:
+			err = b.store(ctx, tx, op.ID, op.Metadata)
+		case metastorage.TxnUpdate:
+			err = b.update(ctx, tx, op.ID, op.Metadata)
+		case metastorage.TxnMove:
+			var moved bool
+			moved, err = b.moveIn(ctx, tx, op.ID, op.From, op.To, 0)
+			if err == nil && !moved {
+				err = b.conflictOrNotFound(ctx, tx, op.ID, op.From, 0)
+			}
+		case metastorage.TxnDelete:
+			err = b.delete(ctx, tx, op.ID)
+		}
+		if err != nil {
+			return op.Err(i, err)
+		}
+	}
+	return translate(tx.Commit())
+}
@@ -0,0 +1,56 @@
+package metastorage
+
+import (
+	"context"
+	"time"
+)
+
+// StateAge summarizes how long the messages of a state have been in it
+type StateAge struct {
+	Count   int           // Messages in the state
+	Unknown int           // Messages without StateEnteredAt, not part of the other fields
+	Over    int           // Messages in the state for longer than the threshold
+	Mean    time.Duration // Mean time in state
+	Max     time.Duration // Longest time in state
+	Oldest  string        // ID of the message in the state the longest
+}
+
+// StateAges scans state and summarizes the time its messages have spent
+// in it at now, e.g. to alert on messages active for more than 30
+// minutes. threshold <= 0 leaves Over at 0.
+func StateAges(ctx context.Context, b Backend, state QueueState, now time.Time, threshold time.Duration) (StateAge, error) {
+	var age StateAge
+	iter, err := b.NewMessageIterator(ctx, state, 500)
+	if err != nil {
+		return age, err
+	}
+	defer iter.Close()
+
+	var total time.Duration
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return age, err
+		}
+		if !more {
+			break
+		}
+		age.Count++
+		if m.StateEnteredAt.IsZero() {
+			age.Unknown++
+			continue
+		}
+		d := m.TimeInState(now)
+		total += d
+		if d > age.Max || age.Oldest == "" {
+			age.Max, age.Oldest = d, m.ID
+		}
+		if threshold > 0 && d > threshold {
+			age.Over++
+		}
+	}
+	if known := age.Count - age.Unknown; known > 0 {
+		age.Mean = total / time.Duration(known)
+	}
+	return age, nil
+}
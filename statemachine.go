@@ -0,0 +1,100 @@
+package metastorage
+
+import (
+	"context"
+	"slices"
+)
+
+// maxLintIDs caps the message IDs a LintFinding lists
+const maxLintIDs = 100
+
+// StateMachine describes the state transitions a deployment allows
+type StateMachine struct {
+	// Initial are the states new messages are stored in
+	Initial []QueueState
+
+	// Transitions lists the states messages may move to from each state
+	Transitions map[QueueState][]QueueState
+}
+
+// Reachable returns the states messages can get into from the initial
+// states, including the initial states themselves
+func (sm StateMachine) Reachable() map[QueueState]bool {
+	reachable := make(map[QueueState]bool)
+	queue := slices.Clone(sm.Initial)
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if reachable[s] {
+			continue
+		}
+		reachable[s] = true
+		queue = append(queue, sm.Transitions[s]...)
+	}
+	return reachable
+}
+
+// LintFinding reports the messages stored in a state that is unreachable
+// under a StateMachine
+type LintFinding struct {
+	State QueueState
+	Count int      // Messages in the state
+	IDs   []string // The first of these messages, at most 100
+}
+
+// LintReport is the result of LintStateMachine
+type LintReport struct {
+	Unreachable []QueueState  // States not reachable from the initial states
+	Findings    []LintFinding // Unreachable states holding messages
+}
+
+// OK reports whether no message is stored in an unreachable state
+func (r LintReport) OK() bool {
+	return len(r.Findings) == 0
+}
+
+// LintStateMachine is a dry run of sm against the messages stored in b:
+// it reports the states no message can reach under the configured
+// transitions and scans them for messages, which can never be processed
+// again. Run after upgrades, it catches transition configs that strand
+// messages stored by a previous release.
+func LintStateMachine(ctx context.Context, b Backend, sm StateMachine) (LintReport, error) {
+	var r LintReport
+	reachable := sm.Reachable()
+	for _, state := range States() {
+		if reachable[state] {
+			continue
+		}
+		r.Unreachable = append(r.Unreachable, state)
+		f, err := lintState(ctx, b, state)
+		if err != nil {
+			return r, err
+		}
+		if f.Count > 0 {
+			r.Findings = append(r.Findings, f)
+		}
+	}
+	return r, nil
+}
+
+func lintState(ctx context.Context, b Backend, state QueueState) (LintFinding, error) {
+	f := LintFinding{State: state}
+	iter, err := b.NewMessageIterator(ctx, state, 500)
+	if err != nil {
+		return f, err
+	}
+	defer iter.Close()
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return f, err
+		}
+		if !more {
+			return f, nil
+		}
+		f.Count++
+		if len(f.IDs) < maxLintIDs {
+			f.IDs = append(f.IDs, m.ID)
+		}
+	}
+}
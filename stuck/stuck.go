@@ -0,0 +1,296 @@
+// Package stuck finds messages that silently stopped progressing: messages
+// whose state and attempts have not changed for longer than a per-state
+// threshold (stuck), and due messages that no worker picks up (starved).
+//
+// The Detector scans the monitored states periodically. Findings are
+// exported as gauges, kept as Stats for status pages, and reported once
+// per message and state as metastorage.EventStuck events.
+package stuck
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/metrics"
+	"schneider.vip/retryspool/storage/meta/middleware/watch"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// MetricMessages is the number of stuck and starved messages found by the
+// last scan, labeled by state and kind
+const MetricMessages = "metastorage_stuck_messages"
+
+// Defaults
+const (
+	DefaultInterval          = 5 * time.Minute
+	DefaultStarveThreshold   = 15 * time.Minute
+	DefaultIncomingThreshold = 15 * time.Minute
+	DefaultActiveThreshold   = time.Hour
+	DefaultDeferredThreshold = 24 * time.Hour
+)
+
+// Kind classifies a finding
+type Kind string
+
+const (
+	KindStuck   Kind = "stuck"   // state and attempts unchanged beyond the threshold
+	KindStarved Kind = "starved" // due, but not claimed beyond the starvation threshold
+)
+
+// Finding describes one message that does not progress
+type Finding struct {
+	Kind    Kind
+	Message metastorage.MessageMetadata
+	Since   time.Time // since when the message has not progressed, or has been due
+}
+
+// Stats summarizes the last scan
+type Stats struct {
+	Scanned int
+	Stuck   map[metastorage.QueueState]int
+	Starved map[metastorage.QueueState]int
+	Oldest  time.Time // earliest Since of all findings, zero without findings
+	Time    time.Time // when the scan finished
+}
+
+type (
+	thresholdKey       struct{ state metastorage.QueueState }
+	starveThresholdKey struct{}
+	intervalKey        struct{}
+	handlerKey         struct{}
+)
+
+// WithThreshold sets how long messages may stay in state without their
+// state or attempts changing. A zero threshold disables the check for
+// state. Defaults: incoming 15m, active 1h, deferred 24h; other states are
+// not checked.
+func WithThreshold(state metastorage.QueueState, d time.Duration) options.Option {
+	return options.WithValue(thresholdKey{state}, d)
+}
+
+// WithStarveThreshold sets how long a message of a monitored state may be
+// due without being claimed (default DefaultStarveThreshold)
+func WithStarveThreshold(d time.Duration) options.Option {
+	return options.WithValue(starveThresholdKey{}, d)
+}
+
+// WithInterval sets the scan interval of Run (default DefaultInterval)
+func WithInterval(d time.Duration) options.Option {
+	return options.WithValue(intervalKey{}, d)
+}
+
+// WithHandler sets a function called once for every new finding
+func WithHandler(h func(Finding)) options.Option {
+	return options.WithValue(handlerKey{}, h)
+}
+
+var defaultThresholds = map[metastorage.QueueState]time.Duration{
+	metastorage.StateIncoming: DefaultIncomingThreshold,
+	metastorage.StateActive:   DefaultActiveThreshold,
+	metastorage.StateDeferred: DefaultDeferredThreshold,
+}
+
+// observation is the progress of a message seen by earlier scans
+type observation struct {
+	state    metastorage.QueueState
+	attempts int
+	since    time.Time
+	reported bool
+}
+
+// Detector finds stuck and starved messages. Scans must not run
+// concurrently.
+type Detector struct {
+	backend    metastorage.Backend
+	publisher  watch.Publisher
+	handler    func(Finding)
+	thresholds map[metastorage.QueueState]time.Duration
+	starve     time.Duration
+	interval   time.Duration
+	clock      clock.Clock
+	logger     *slog.Logger
+	metrics    metrics.Recorder
+
+	seen map[string]*observation // only accessed by Scan
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewDetector creates a detector for the messages in backend
+func NewDetector(backend metastorage.Backend, opts ...options.Option) *Detector {
+	o := options.Apply(opts...)
+	d := &Detector{
+		backend:    backend,
+		handler:    options.ValueOr[func(Finding)](o, handlerKey{}, nil),
+		thresholds: make(map[metastorage.QueueState]time.Duration),
+		starve:     options.ValueOr(o, starveThresholdKey{}, DefaultStarveThreshold),
+		interval:   options.ValueOr(o, intervalKey{}, DefaultInterval),
+		clock:      o.Clock,
+		logger:     o.Logger,
+		metrics:    o.Metrics,
+		seen:       make(map[string]*observation),
+	}
+	for _, s := range metastorage.States() {
+		t := options.ValueOr(o, thresholdKey{s}, defaultThresholds[s])
+		if t > 0 {
+			d.thresholds[s] = t
+		}
+	}
+	if p, ok := metastorage.As[watch.Publisher](backend); ok {
+		d.publisher = p
+	}
+	return d
+}
+
+// Stats returns the summary of the last scan
+func (d *Detector) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// Run scans every interval until ctx is done and returns ctx.Err()
+func (d *Detector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Scan(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Warn("metastorage stuck detector: scan failed", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan checks all monitored states once and returns the current findings.
+// Progress is judged by comparing with earlier scans; a message seen for
+// the first time is assumed unchanged since its Updated time.
+func (d *Detector) Scan(ctx context.Context) ([]Finding, error) {
+	now := d.clock.Now()
+	stats := Stats{
+		Stuck:   make(map[metastorage.QueueState]int),
+		Starved: make(map[metastorage.QueueState]int),
+	}
+	var findings []Finding
+	visited := make(map[string]bool, len(d.seen))
+	for _, state := range metastorage.States() {
+		threshold, ok := d.thresholds[state]
+		if !ok {
+			continue
+		}
+		err := d.scanState(ctx, state, func(m metastorage.MessageMetadata) {
+			stats.Scanned++
+			visited[m.ID] = true
+			f, ok := d.judge(m, threshold, now)
+			if !ok {
+				return
+			}
+			findings = append(findings, f)
+			if f.Kind == KindStuck {
+				stats.Stuck[state]++
+			} else {
+				stats.Starved[state]++
+			}
+			if stats.Oldest.IsZero() || f.Since.Before(stats.Oldest) {
+				stats.Oldest = f.Since
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for id := range d.seen {
+		if !visited[id] {
+			delete(d.seen, id)
+		}
+	}
+
+	stats.Time = d.clock.Now()
+	d.mu.Lock()
+	d.stats = stats
+	d.mu.Unlock()
+	for state := range d.thresholds {
+		d.metrics.Gauge(MetricMessages, metrics.Labels{metrics.LabelState: metastorage.StateLabel(state), "kind": string(KindStuck)}, float64(stats.Stuck[state]))
+		d.metrics.Gauge(MetricMessages, metrics.Labels{metrics.LabelState: metastorage.StateLabel(state), "kind": string(KindStarved)}, float64(stats.Starved[state]))
+	}
+	for _, f := range findings {
+		if o := d.seen[f.Message.ID]; !o.reported {
+			o.reported = true
+			d.report(f)
+		}
+	}
+	return findings, nil
+}
+
+func (d *Detector) scanState(ctx context.Context, state metastorage.QueueState, fn func(metastorage.MessageMetadata)) error {
+	iter, err := d.backend.NewMessageIterator(ctx, state, 100)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		fn(m)
+	}
+}
+
+// judge updates the observation of m and returns a finding if m does not
+// progress
+func (d *Detector) judge(m metastorage.MessageMetadata, threshold time.Duration, now time.Time) (Finding, bool) {
+	o, ok := d.seen[m.ID]
+	if !ok || o.state != m.State || o.attempts != m.Attempts {
+		since := now
+		if !ok && !m.Updated.IsZero() && m.Updated.Before(now) {
+			since = m.Updated
+		}
+		o = &observation{state: m.State, attempts: m.Attempts, since: since}
+		d.seen[m.ID] = o
+	}
+
+	if m.State != metastorage.StateActive && d.starve > 0 && metastorage.IsDue(m, now) {
+		// due since the later of NextRetry and entering the state
+		due := o.since
+		if m.NextRetry.After(due) {
+			due = m.NextRetry
+		}
+		if now.Sub(due) >= d.starve {
+			return Finding{Kind: KindStarved, Message: m, Since: due}, true
+		}
+	}
+	if now.Sub(o.since) >= threshold {
+		return Finding{Kind: KindStuck, Message: m, Since: o.since}, true
+	}
+	return Finding{}, false
+}
+
+func (d *Detector) report(f Finding) {
+	d.logger.Warn("metastorage message does not progress",
+		slog.String("message_id", f.Message.ID),
+		slog.String("state", f.Message.State.String()),
+		slog.String("kind", string(f.Kind)),
+		slog.Time("since", f.Since))
+	if d.publisher != nil {
+		d.publisher.Publish(metastorage.Event{
+			Type:      metastorage.EventStuck,
+			MessageID: f.Message.ID,
+			State:     f.Message.State,
+		})
+	}
+	if d.handler != nil {
+		d.handler(f)
+	}
+}
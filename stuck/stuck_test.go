@@ -0,0 +1,104 @@
+package stuck
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// listed serves the messages of each state; other Backend methods are not
+// used
+type listed struct {
+	metastorage.Backend
+	messages map[string]metastorage.MessageMetadata
+}
+
+func (l *listed) NewMessageIterator(_ context.Context, state metastorage.QueueState, _ int) (metastorage.MessageIterator, error) {
+	it := &sliceIterator{}
+	for _, m := range l.messages {
+		if m.State == state {
+			it.messages = append(it.messages, m)
+		}
+	}
+	return it, nil
+}
+
+type sliceIterator struct {
+	messages []metastorage.MessageMetadata
+}
+
+func (it *sliceIterator) Next(context.Context) (metastorage.MessageMetadata, bool, error) {
+	if len(it.messages) == 0 {
+		return metastorage.MessageMetadata{}, false, nil
+	}
+	m := it.messages[0]
+	it.messages = it.messages[1:]
+	return m, true, nil
+}
+
+func (it *sliceIterator) Close() error { return nil }
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := clock.NewManual(t0)
+	backend := &listed{messages: map[string]metastorage.MessageMetadata{
+		"claimed": {ID: "claimed", State: metastorage.StateActive, Updated: t0},
+		"hung":    {ID: "hung", State: metastorage.StateActive, Updated: t0.Add(-time.Hour)},
+		"waiting": {ID: "waiting", State: metastorage.StateDeferred, Updated: t0, NextRetry: t0.Add(time.Hour)},
+		"due":     {ID: "due", State: metastorage.StateDeferred, Updated: t0, NextRetry: t0.Add(-time.Hour)},
+		"bounced": {ID: "bounced", State: metastorage.StateBounce, Updated: t0.Add(-48 * time.Hour)},
+	}}
+	var reported []Finding
+	d := NewDetector(backend,
+		WithThreshold(metastorage.StateIncoming, 0),
+		WithThreshold(metastorage.StateActive, 30*time.Minute),
+		WithStarveThreshold(10*time.Minute),
+		WithHandler(func(f Finding) { reported = append(reported, f) }),
+		options.WithClock(c))
+
+	findings, err := d.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Message.ID != "hung" || findings[0].Kind != KindStuck {
+		t.Fatalf("findings = %+v, want hung stuck", findings)
+	}
+
+	c.Advance(20 * time.Minute)
+	if _, err := d.Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s := d.Stats()
+	if s.Scanned != 4 || s.Stuck[metastorage.StateActive] != 1 || s.Starved[metastorage.StateDeferred] != 1 {
+		t.Fatalf("stats = %+v, want hung stuck and due starved of 4 messages", s)
+	}
+	if !s.Oldest.Equal(t0.Add(-time.Hour)) {
+		t.Errorf("oldest = %v, want %v", s.Oldest, t0.Add(-time.Hour))
+	}
+	if len(reported) != 2 {
+		t.Fatalf("reported = %+v, want each finding once", reported)
+	}
+
+	// progress resets the observation
+	m := backend.messages["hung"]
+	m.Attempts++
+	backend.messages["hung"] = m
+	c.Advance(20 * time.Minute)
+	findings, err = d.Scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings {
+		if f.Message.ID == "hung" {
+			t.Errorf("hung reported after progress: %+v", f)
+		}
+	}
+	if len(findings) != 2 {
+		t.Errorf("findings = %+v, want claimed stuck and due starved", findings)
+	}
+}
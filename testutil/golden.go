@@ -0,0 +1,224 @@
+// Package testutil contains helpers for applications that embed retryspool
+// metadata storage in their own tests.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+)
+
+// EnvUpdateGolden rewrites golden files instead of comparing when set to a true value
+const EnvUpdateGolden = "RETRYSPOOL_UPDATE_GOLDEN"
+
+// DumpOptions controls the golden format
+type DumpOptions struct {
+	IgnoreTimes   bool     // Omit Created, Updated, NextRetry and StateEnteredAt (useful with time.Now based fixtures)
+	IgnoreHeaders []string // Header keys to omit
+	BatchSize     int      // Iterator batch size, default 100
+}
+
+// Dump renders the full contents of backend in a deterministic, line
+// oriented text format. States are emitted in metastorage.States order,
+// messages sorted by ID, headers sorted by key.
+func Dump(ctx context.Context, backend metastorage.Backend, opts DumpOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := DumpTo(ctx, &buf, backend, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DumpTo writes the golden format of backend to w
+func DumpTo(ctx context.Context, w io.Writer, backend metastorage.Backend, opts DumpOptions) error {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	ignored := make(map[string]bool, len(opts.IgnoreHeaders))
+	for _, h := range opts.IgnoreHeaders {
+		ignored[h] = true
+	}
+
+	for _, state := range metastorage.States() {
+		messages, err := collect(ctx, backend, state, opts.BatchSize)
+		if err != nil {
+			return fmt.Errorf("dump state %s: %w", state, err)
+		}
+		if _, err := fmt.Fprintf(w, "== %s (%d)\n", state, len(messages)); err != nil {
+			return err
+		}
+		for _, m := range messages {
+			if err := writeMessage(w, m, opts, ignored); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func collect(ctx context.Context, backend metastorage.Backend, state metastorage.QueueState, batchSize int) ([]metastorage.MessageMetadata, error) {
+	iter, err := backend.NewMessageIterator(ctx, state, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var messages []metastorage.MessageMetadata
+	for {
+		m, more, err := iter.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+func writeMessage(w io.Writer, m metastorage.MessageMetadata, opts DumpOptions, ignored map[string]bool) error {
+	lines := []string{
+		fmt.Sprintf("- id: %s", m.ID),
+		fmt.Sprintf("  attempts: %d/%d", m.Attempts, m.MaxAttempts),
+		fmt.Sprintf("  priority: %d", m.Priority),
+		fmt.Sprintf("  size: %d", m.Size),
+	}
+	if m.Sequence != 0 {
+		lines = append(lines, fmt.Sprintf("  sequence: %d", m.Sequence))
+	}
+	if m.RetryPolicyName != "" {
+		lines = append(lines, fmt.Sprintf("  retry_policy: %s", m.RetryPolicyName))
+	}
+	if m.LastError != "" {
+		lines = append(lines, fmt.Sprintf("  last_error: %q", m.LastError))
+	}
+	if !opts.IgnoreTimes {
+		lines = append(lines,
+			"  created: "+formatTime(m.Created),
+			"  updated: "+formatTime(m.Updated),
+			"  next_retry: "+formatTime(m.NextRetry),
+		)
+		if !m.StateEnteredAt.IsZero() {
+			lines = append(lines, "  state_entered: "+formatTime(m.StateEnteredAt))
+		}
+	}
+	if w := m.DeliveryWindow; !w.IsZero() {
+		window := fmt.Sprintf("  window: hours=%d-%d weekdays=%v tz=%q", w.Hours.From, w.Hours.To, w.Weekdays, w.TimeZone)
+		if !opts.IgnoreTimes {
+			window += " not_before=" + formatTime(w.NotBefore) + " not_after=" + formatTime(w.NotAfter)
+		}
+		lines = append(lines, window)
+	}
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		if !ignored[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("  header %s: %q", k, m.Headers[k]))
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// AssertGolden compares the dump of backend with the golden file at path.
+// With RETRYSPOOL_UPDATE_GOLDEN=1 (or -update when the test binary defines
+// that flag) the golden file is (re)written instead.
+func AssertGolden(tb testing.TB, ctx context.Context, backend metastorage.Backend, path string, opts DumpOptions) {
+	tb.Helper()
+	got, err := Dump(ctx, backend, opts)
+	if err != nil {
+		tb.Fatalf("dump backend: %v", err)
+	}
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatalf("create golden dir: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatalf("write golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("read golden file (run with %s=1 to create it): %v", EnvUpdateGolden, err)
+	}
+	if d := Diff(string(want), string(got)); d != "" {
+		tb.Errorf("backend contents differ from %s (-want +got):\n%s", path, d)
+	}
+}
+
+func updateGolden() bool {
+	switch strings.ToLower(os.Getenv(EnvUpdateGolden)) {
+	case "1", "true", "yes":
+		return true
+	}
+	if f := flag.Lookup("update"); f != nil {
+		return f.Value.String() == "true"
+	}
+	return false
+}
+
+// Diff returns a line based diff of want and got, or "" if they are equal.
+// Removed lines are prefixed with "-", added lines with "+".
+func Diff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// longest common subsequence table
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			out.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			out.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,77 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"schneider.vip/retryspool/storage/meta/clock"
+)
+
+// Table is an in-memory token table. The memory backend uses it to
+// implement metastorage.ThrottleBackend; GroupThrottle falls back to it for
+// backends without native support, where limits then only hold per
+// process. It is safe for concurrent use.
+type Table struct {
+	clock clock.Clock
+
+	mu     sync.Mutex
+	groups map[string]map[string]time.Time // group -> holder -> expiry
+}
+
+// NewTable creates an empty token table
+func NewTable(c clock.Clock) *Table {
+	if c == nil {
+		c = clock.System
+	}
+	return &Table{clock: c, groups: make(map[string]map[string]time.Time)}
+}
+
+// Get takes one of limit tokens of group for holder until ttl elapses
+func (t *Table) Get(group, holder string, limit int, ttl time.Duration) bool {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	holders := t.groups[group]
+	if holders == nil {
+		holders = make(map[string]time.Time)
+		t.groups[group] = holders
+	}
+	for h, expires := range holders {
+		if !now.Before(expires) {
+			delete(holders, h)
+		}
+	}
+	if _, owned := holders[holder]; !owned && len(holders) >= limit {
+		return false
+	}
+	holders[holder] = now.Add(ttl)
+	return true
+}
+
+// Return gives back holder's token of group. Returning a token that is not
+// held is a no-op.
+func (t *Table) Return(group, holder string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if holders := t.groups[group]; holders != nil {
+		delete(holders, holder)
+		if len(holders) == 0 {
+			delete(t.groups, group)
+		}
+	}
+}
+
+// InUse returns the number of unexpired tokens of group
+func (t *Table) InUse(group string) int {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, expires := range t.groups[group] {
+		if now.Before(expires) {
+			n++
+		}
+	}
+	return n
+}
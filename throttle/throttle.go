@@ -0,0 +1,142 @@
+// Package throttle enforces per-destination-group concurrency limits with
+// tokens stored in the metadata backend.
+//
+// Backends implementing metastorage.ThrottleBackend share tokens across
+// all nodes using the backend. For other backends GroupThrottle falls back
+// to a process local Table, which only limits concurrency per node.
+package throttle
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/options"
+)
+
+// ErrNoToken is returned by Acquire when the group's limit is reached
+var ErrNoToken = errors.New("throttle: no token available")
+
+// DefaultTTL bounds how long a token stays taken if its holder crashes
+const DefaultTTL = 5 * time.Minute
+
+type (
+	limitKey  struct{}
+	limitsKey struct{}
+	ttlKey    struct{}
+)
+
+// WithLimit sets the default number of concurrent tokens per group (default 1)
+func WithLimit(n int) options.Option {
+	return options.WithValue(limitKey{}, n)
+}
+
+// WithGroupLimits sets limits for individual groups, overriding WithLimit
+func WithGroupLimits(limits map[string]int) options.Option {
+	return options.WithValue(limitsKey{}, limits)
+}
+
+// WithTTL sets the token ttl (default DefaultTTL)
+func WithTTL(ttl time.Duration) options.Option {
+	return options.WithValue(ttlKey{}, ttl)
+}
+
+// Token is a taken concurrency token
+type Token struct {
+	Group  string
+	Holder string
+}
+
+// GroupThrottle hands out concurrency tokens per group key
+type GroupThrottle struct {
+	native metastorage.ThrottleBackend
+	local  *Table
+	limit  int
+	limits map[string]int
+	ttl    time.Duration
+	shared bool
+}
+
+// New creates a throttle for backend
+func New(backend metastorage.Backend, opts ...options.Option) *GroupThrottle {
+	o := options.Apply(opts...)
+	g := &GroupThrottle{
+		limit:  options.ValueOr(o, limitKey{}, 1),
+		limits: options.ValueOr[map[string]int](o, limitsKey{}, nil),
+		ttl:    options.ValueOr(o, ttlKey{}, DefaultTTL),
+	}
+	if native, ok := metastorage.As[metastorage.ThrottleBackend](backend); ok {
+		g.native = native
+		g.shared = true
+	} else {
+		g.local = NewTable(o.Clock)
+		o.Logger.Warn("metastorage backend has no native throttle support; group limits are enforced per process")
+	}
+	return g
+}
+
+// Shared reports whether tokens are shared across nodes through the backend
+func (g *GroupThrottle) Shared() bool {
+	return g.shared
+}
+
+// Limit returns the concurrency limit of group
+func (g *GroupThrottle) Limit(group string) int {
+	if n, ok := g.limits[group]; ok {
+		return n
+	}
+	return g.limit
+}
+
+// GetToken tries to take a token of group for holder. It returns false if
+// the group's limit is reached.
+func (g *GroupThrottle) GetToken(ctx context.Context, group, holder string) (Token, bool, error) {
+	limit := g.Limit(group)
+	if limit <= 0 {
+		return Token{}, false, nil
+	}
+	var ok bool
+	if g.native != nil {
+		var err error
+		if ok, err = g.native.GetToken(ctx, group, holder, limit, g.ttl); err != nil {
+			return Token{}, false, err
+		}
+	} else {
+		ok = g.local.Get(group, holder, limit, g.ttl)
+	}
+	if !ok {
+		return Token{}, false, nil
+	}
+	return Token{Group: group, Holder: holder}, true, nil
+}
+
+// Acquire is GetToken returning ErrNoToken when no token is available
+func (g *GroupThrottle) Acquire(ctx context.Context, group, holder string) (Token, error) {
+	tok, ok, err := g.GetToken(ctx, group, holder)
+	if err != nil {
+		return Token{}, err
+	}
+	if !ok {
+		return Token{}, ErrNoToken
+	}
+	return tok, nil
+}
+
+// Refresh extends the ttl of a token still held
+func (g *GroupThrottle) Refresh(ctx context.Context, tok Token) error {
+	_, ok, err := g.GetToken(ctx, tok.Group, tok.Holder)
+	if err == nil && !ok {
+		err = ErrNoToken
+	}
+	return err
+}
+
+// ReturnToken gives a token back
+func (g *GroupThrottle) ReturnToken(ctx context.Context, tok Token) error {
+	if g.native != nil {
+		return g.native.ReturnToken(ctx, tok.Group, tok.Holder)
+	}
+	g.local.Return(tok.Group, tok.Holder)
+	return nil
+}
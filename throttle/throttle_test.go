@@ -0,0 +1,122 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/clock"
+)
+
+func TestTable(t *testing.T) {
+	c := clock.NewManual(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	tab := NewTable(c)
+	if !tab.Get("g", "w1", 2, time.Minute) || !tab.Get("g", "w2", 2, time.Minute) {
+		t.Fatal("tokens within the limit refused")
+	}
+	if tab.Get("g", "w3", 2, time.Minute) {
+		t.Fatal("token beyond the limit granted")
+	}
+	if !tab.Get("g", "w1", 2, 2*time.Minute) {
+		t.Fatal("holder refused its own token")
+	}
+	if !tab.Get("other", "w3", 2, time.Minute) {
+		t.Fatal("limit shared between groups")
+	}
+
+	c.Advance(time.Minute)
+	// w2 expired, w1 was refreshed
+	if n := tab.InUse("g"); n != 1 {
+		t.Fatalf("in use after expiry = %d, want 1", n)
+	}
+	if !tab.Get("g", "w3", 2, time.Minute) {
+		t.Fatal("expired token not reused")
+	}
+	tab.Return("g", "w1")
+	tab.Return("g", "w1")
+	if n := tab.InUse("g"); n != 1 {
+		t.Fatalf("in use after return = %d, want 1", n)
+	}
+}
+
+// plain has no native throttle support
+type plain struct {
+	metastorage.Backend
+}
+
+// native records the tokens it hands out
+type native struct {
+	metastorage.Backend
+	limits   []int
+	returned []string
+}
+
+func (n *native) GetToken(_ context.Context, group, holder string, limit int, _ time.Duration) (bool, error) {
+	n.limits = append(n.limits, limit)
+	return group != "full", nil
+}
+
+func (n *native) ReturnToken(_ context.Context, group, holder string) error {
+	n.returned = append(n.returned, group+"/"+holder)
+	return nil
+}
+
+func TestGroupThrottleFallback(t *testing.T) {
+	ctx := context.Background()
+	g := New(plain{}, WithLimit(1), WithGroupLimits(map[string]int{"wide": 2, "closed": 0}))
+	if g.Shared() {
+		t.Fatal("local tokens reported as shared")
+	}
+	tok, err := g.Acquire(ctx, "example.com", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Acquire(ctx, "example.com", "w2"); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("second token: %v, want ErrNoToken", err)
+	}
+	if err := g.Refresh(ctx, tok); err != nil {
+		t.Fatalf("refresh of held token: %v", err)
+	}
+	if err := g.ReturnToken(ctx, tok); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Acquire(ctx, "example.com", "w2"); err != nil {
+		t.Fatalf("token after return: %v", err)
+	}
+
+	for _, holder := range []string{"w1", "w2"} {
+		if _, err := g.Acquire(ctx, "wide", holder); err != nil {
+			t.Fatalf("group limit: %v", err)
+		}
+	}
+	if _, ok, err := g.GetToken(ctx, "closed", "w1"); ok || err != nil {
+		t.Fatalf("token of a group limited to 0: %v, %v", ok, err)
+	}
+}
+
+func TestGroupThrottleNative(t *testing.T) {
+	ctx := context.Background()
+	n := &native{}
+	g := New(n, WithLimit(3))
+	if !g.Shared() {
+		t.Fatal("native tokens not reported as shared")
+	}
+	tok, err := g.Acquire(ctx, "example.com", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Acquire(ctx, "full", "w1"); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("token of a full group: %v, want ErrNoToken", err)
+	}
+	if err := g.ReturnToken(ctx, tok); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.limits) != 2 || n.limits[0] != 3 {
+		t.Errorf("native limits = %v, want 3 per call", n.limits)
+	}
+	if len(n.returned) != 1 || n.returned[0] != "example.com/w1" {
+		t.Errorf("returned = %v", n.returned)
+	}
+}
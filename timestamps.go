@@ -0,0 +1,78 @@
+package metastorage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNonUTCTimestamp is returned when a stored timestamp is not in UTC
+var ErrNonUTCTimestamp = errors.New("timestamp is not in UTC")
+
+// timeFields returns pointers to all timestamps of m with their names
+func (m *MessageMetadata) timeFields() map[string]*time.Time {
+	return map[string]*time.Time{
+		"Created":   &m.Created,
+		"Updated":   &m.Updated,
+		"NextRetry": &m.NextRetry,
+
+		"StateEnteredAt": &m.StateEnteredAt,
+
+		"DeliveryWindow.NotBefore": &m.DeliveryWindow.NotBefore,
+		"DeliveryWindow.NotAfter":  &m.DeliveryWindow.NotAfter,
+	}
+}
+
+// NormalizeTimes returns m with all timestamps converted to UTC. Mixed
+// time zones sort incorrectly in backends that index the string form of
+// timestamps, so codecs and backends store normalized metadata only.
+func NormalizeTimes(m MessageMetadata) MessageMetadata {
+	for _, t := range m.timeFields() {
+		if !t.IsZero() {
+			*t = t.UTC()
+		}
+	}
+	return m
+}
+
+// EnterState returns m with StateEnteredAt defaulted for a newly stored
+// message: Created, or now if Created is unset. A set StateEnteredAt is
+// kept. Backends implementing StateTimeBackend apply it in StoreMeta.
+func EnterState(m MessageMetadata, now time.Time) MessageMetadata {
+	if m.StateEnteredAt.IsZero() {
+		m.StateEnteredAt = m.Created
+		if m.StateEnteredAt.IsZero() {
+			m.StateEnteredAt = now.UTC()
+		}
+	}
+	return m
+}
+
+// CheckTimes returns an error wrapping ErrNonUTCTimestamp if any non-zero
+// timestamp of m has a non-zero UTC offset
+func CheckTimes(m MessageMetadata) error {
+	for name, t := range m.timeFields() {
+		if t.IsZero() {
+			continue
+		}
+		if _, offset := t.Zone(); offset != 0 {
+			return fmt.Errorf("%s %s: %w", name, t.Format(time.RFC3339), ErrNonUTCTimestamp)
+		}
+	}
+	return nil
+}
+
+// ErrStaleWrite is returned by last-write-wins writers when the Updated
+// timestamp of a write is older than the stored one
+var ErrStaleWrite = errors.New("stale write: stored message is newer")
+
+// CheckNotStale returns an error wrapping ErrStaleWrite if update carries
+// an older Updated timestamp than stored. Equal timestamps pass, so
+// retrying a write is harmless.
+func CheckNotStale(stored, update MessageMetadata) error {
+	if update.Updated.Before(stored.Updated) {
+		return fmt.Errorf("%w: %s updated %s, stored %s", ErrStaleWrite, update.ID,
+			update.Updated.UTC().Format(time.RFC3339Nano), stored.Updated.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
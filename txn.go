@@ -0,0 +1,183 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSupported is returned for optional operations a backend cannot
+// perform, such as transactions. It is errors.ErrUnsupported, so either
+// can be tested with errors.Is.
+var ErrNotSupported = errors.ErrUnsupported
+
+// ErrTxnDone is returned for operations on a committed or rolled back
+// transaction
+var ErrTxnDone = errors.New("transaction already committed or rolled back")
+
+// Txn groups writes of several messages that take effect together, e.g.
+// expiring 500 deferred messages to bounce. Operations are recorded and
+// run in order by Commit, atomically: if one fails, Commit returns its
+// error and none takes effect. Operations have the semantics of the
+// Backend methods of the same name, seeing the effects of the operations
+// before them. A Txn is not safe for concurrent use.
+type Txn interface {
+	StoreMeta(ctx context.Context, messageID string, metadata MessageMetadata) error
+	UpdateMeta(ctx context.Context, messageID string, metadata MessageMetadata) error
+	MoveToState(ctx context.Context, messageID string, fromState, toState QueueState) error
+	DeleteMeta(ctx context.Context, messageID string) error
+
+	// Commit runs the operations atomically
+	Commit(ctx context.Context) error
+
+	// Rollback discards the operations; it does nothing after Commit
+	Rollback() error
+}
+
+// TxnBackend is implemented by backends with multi-message transactions
+type TxnBackend interface {
+	Backend
+
+	// Begin starts a transaction
+	Begin(ctx context.Context) (Txn, error)
+}
+
+// TxnDecorator is implemented by decorators that take part in the
+// transactions of the backend they wrap. WrapTxn returns txn with the
+// behavior of the decorator applied to its operations, as its Backend
+// methods apply it to single writes.
+type TxnDecorator interface {
+	Backend
+	Wrapper
+
+	// WrapTxn decorates a transaction of the wrapped backend
+	WrapTxn(txn Txn) Txn
+}
+
+// Begin starts a transaction on b. The chain is walked from the outermost
+// layer to the first one implementing TxnBackend; the layers above it
+// must implement TxnDecorator and wrap its transaction, as a transaction
+// bypassing a decorator would skip its behavior. Backends without
+// transactions, or with a decorator not taking part, fail with
+// ErrNotSupported.
+func Begin(ctx context.Context, b Backend) (Txn, error) {
+	var decorators []TxnDecorator
+	for b != nil {
+		if tb, ok := b.(TxnBackend); ok {
+			txn, err := tb.Begin(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for i := len(decorators) - 1; i >= 0; i-- {
+				txn = decorators[i].WrapTxn(txn)
+			}
+			return txn, nil
+		}
+		if f, ok := b.(*stateCounterForwarder); ok {
+			b = f.Backend
+			continue
+		}
+		d, ok := b.(TxnDecorator)
+		if !ok {
+			break
+		}
+		decorators = append(decorators, d)
+		b = d.Unwrap()
+	}
+	return nil, fmt.Errorf("%w: backend has no transactions", ErrNotSupported)
+}
+
+// RunTxn runs fn in a transaction of b and commits it, or rolls it back
+// if fn fails
+func RunTxn(ctx context.Context, b Backend, fn func(Txn) error) error {
+	txn, err := Begin(ctx, b)
+	if err != nil {
+		return err
+	}
+	if err := fn(txn); err != nil {
+		return errors.Join(err, txn.Rollback())
+	}
+	return txn.Commit(ctx)
+}
+
+// TxnOpKind identifies the operation of a TxnOp
+type TxnOpKind string
+
+// Operations of a transaction
+const (
+	TxnStore  TxnOpKind = "store"
+	TxnUpdate TxnOpKind = "update"
+	TxnMove   TxnOpKind = "move"
+	TxnDelete TxnOpKind = "delete"
+)
+
+// TxnOp is an operation recorded by a transaction of NewTxn
+type TxnOp struct {
+	Kind     TxnOpKind
+	ID       string
+	Metadata MessageMetadata // of stores and updates
+	From, To QueueState      // of moves
+}
+
+// Err wraps err, the failure of op, with the operation and its position
+// i in the transaction
+func (op TxnOp) Err(i int, err error) error {
+	return fmt.Errorf("txn op %d: %s %s: %w", i+1, op.Kind, op.ID, err)
+}
+
+// NewTxn returns a Txn recording its operations and passing them to
+// commit, which must run them atomically. Backends implement Begin with
+// it.
+func NewTxn(commit func(ctx context.Context, ops []TxnOp) error) Txn {
+	return &recordedTxn{commit: commit}
+}
+
+type recordedTxn struct {
+	commit func(ctx context.Context, ops []TxnOp) error
+	ops    []TxnOp
+	done   bool
+}
+
+func (t *recordedTxn) record(ctx context.Context, op TxnOp) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, op)
+	return nil
+}
+
+func (t *recordedTxn) StoreMeta(ctx context.Context, messageID string, metadata MessageMetadata) error {
+	return t.record(ctx, TxnOp{Kind: TxnStore, ID: messageID, Metadata: metadata})
+}
+
+func (t *recordedTxn) UpdateMeta(ctx context.Context, messageID string, metadata MessageMetadata) error {
+	return t.record(ctx, TxnOp{Kind: TxnUpdate, ID: messageID, Metadata: metadata})
+}
+
+func (t *recordedTxn) MoveToState(ctx context.Context, messageID string, fromState, toState QueueState) error {
+	return t.record(ctx, TxnOp{Kind: TxnMove, ID: messageID, From: fromState, To: toState})
+}
+
+func (t *recordedTxn) DeleteMeta(ctx context.Context, messageID string) error {
+	return t.record(ctx, TxnOp{Kind: TxnDelete, ID: messageID})
+}
+
+func (t *recordedTxn) Commit(ctx context.Context) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	if len(t.ops) == 0 {
+		return nil
+	}
+	return t.commit(ctx, t.ops)
+}
+
+func (t *recordedTxn) Rollback() error {
+	t.done = true
+	t.ops = nil
+	return nil
+}
@@ -0,0 +1,119 @@
+package metastorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := b.StoreMeta(ctx, id, metastorage.MessageMetadata{ID: id, State: metastorage.StateDeferred}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := metastorage.RunTxn(ctx, b, func(txn metastorage.Txn) error {
+		for _, id := range []string{"m1", "m2"} {
+			if err := txn.MoveToState(ctx, id, metastorage.StateDeferred, metastorage.StateBounce); err != nil {
+				return err
+			}
+		}
+		return txn.DeleteMeta(ctx, "m3")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if m, err := b.GetMeta(ctx, id); err != nil || m.State != metastorage.StateBounce {
+			t.Errorf("%s: %v %v, want bounce", id, m.State, err)
+		}
+	}
+	if _, err := b.GetMeta(ctx, "m3"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Errorf("deleted m3: %v", err)
+	}
+	seq, err := b.LastSequence(ctx, metastorage.StateBounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second move fails, so the first and the store are undone
+	txn, err := metastorage.Begin(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.StoreMeta(ctx, "m4", metastorage.MessageMetadata{ID: "m4", State: metastorage.StateBounce})
+	txn.MoveToState(ctx, "m1", metastorage.StateBounce, metastorage.StateIncoming)
+	txn.MoveToState(ctx, "m3", metastorage.StateBounce, metastorage.StateIncoming)
+	if err := txn.Commit(ctx); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Fatalf("commit: %v, want not found", err)
+	}
+	if m, err := b.GetMeta(ctx, "m1"); err != nil || m.State != metastorage.StateBounce {
+		t.Errorf("m1 after failed commit: %v %v, want bounce", m.State, err)
+	}
+	if _, err := b.GetMeta(ctx, "m4"); !errors.Is(err, metastorage.ErrMessageNotFound) {
+		t.Errorf("m4 after failed commit: %v", err)
+	}
+	if got, _ := b.LastSequence(ctx, metastorage.StateBounce); got != seq {
+		t.Errorf("sequence %d after failed commit, want %d", got, seq)
+	}
+	if err := txn.DeleteMeta(ctx, "m1"); !errors.Is(err, metastorage.ErrTxnDone) {
+		t.Errorf("after commit: %v, want ErrTxnDone", err)
+	}
+
+	if _, err := metastorage.Begin(ctx, plain{b}); !errors.Is(err, metastorage.ErrNotSupported) {
+		t.Errorf("plain backend: %v, want ErrNotSupported", err)
+	}
+}
+
+// tagging is a decorator taking part in transactions; it marks the
+// messages stored
+type tagging struct {
+	metastorage.Backend
+}
+
+func (d tagging) Unwrap() metastorage.Backend {
+	return d.Backend
+}
+
+func (d tagging) WrapTxn(txn metastorage.Txn) metastorage.Txn {
+	return taggedTxn{txn}
+}
+
+type taggedTxn struct {
+	metastorage.Txn
+}
+
+func (t taggedTxn) StoreMeta(ctx context.Context, messageID string, metadata metastorage.MessageMetadata) error {
+	metadata.Headers = map[string]string{"tagged": "yes"}
+	return t.Txn.StoreMeta(ctx, messageID, metadata)
+}
+
+func TestTxnThroughDecorators(t *testing.T) {
+	ctx := context.Background()
+	inner := memory.New()
+	b := metastorage.Chain(inner, func(b metastorage.Backend) metastorage.Backend { return tagging{b} })
+	if _, ok := b.(metastorage.StateCounterBackend); !ok {
+		t.Fatal("GetStateCount not forwarded past the decorator")
+	}
+	err := metastorage.RunTxn(ctx, b, func(txn metastorage.Txn) error {
+		return txn.StoreMeta(ctx, "m1", metastorage.MessageMetadata{ID: "m1", State: metastorage.StateIncoming})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := inner.GetMeta(ctx, "m1"); err != nil || m.Headers["tagged"] != "yes" {
+		t.Fatalf("stored %+v, %v; want the decorator applied", m.Headers, err)
+	}
+
+	// a decorator not taking part must not be bypassed
+	b = metastorage.Chain(inner, func(b metastorage.Backend) metastorage.Backend { return passthrough{b} })
+	if _, err := metastorage.Begin(ctx, b); !errors.Is(err, metastorage.ErrNotSupported) {
+		t.Errorf("begin through passthrough: %v, want ErrNotSupported", err)
+	}
+}
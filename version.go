@@ -0,0 +1,73 @@
+package metastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// VersionBackend is implemented by backends that maintain
+// MessageMetadata.Version, giving writes compare-and-swap semantics so
+// two workers updating the same message cannot stomp each other:
+//
+//   - StoreMeta stores version 1, or the stored version plus one when it
+//     replaces a message
+//   - UpdateMeta with a non-zero Version fails with ErrVersionConflict
+//     unless it matches the stored version; Version 0 updates
+//     unconditionally. The stored version is incremented.
+//   - MoveToState increments the stored version
+//   - MoveToStateIfVersion moves only if the stored version matches
+//
+// Check and write are atomic, like the state check of MoveToState. A
+// message read with GetMeta carries its version, so writing it back with
+// UpdateMeta fails if it was changed in between.
+type VersionBackend interface {
+	Backend
+
+	// MoveToStateIfVersion is MoveToState failing with ErrVersionConflict
+	// unless the message has the given version
+	MoveToStateIfVersion(ctx context.Context, messageID string, fromState, toState QueueState, version uint64) error
+}
+
+// CheckVersion returns ErrVersionConflict if version is non-zero and
+// differs from the version of stored. Backends implementing VersionBackend
+// call it before writing.
+func CheckVersion(stored MessageMetadata, version uint64) error {
+	if version != 0 && version != stored.Version {
+		return fmt.Errorf("%w: %s has version %d, expected %d", ErrVersionConflict, stored.ID, stored.Version, version)
+	}
+	return nil
+}
+
+// MoveToStateIfVersion moves a message from fromState to toState only if
+// it still has version, e.g. the version of the copy a decision was based
+// on. If the outermost layer of b implements VersionBackend the check is
+// atomic with the move; if only a layer below does, the version is
+// checked with GetMeta before the move through all layers. Backends not
+// tracking versions fail with errors.ErrUnsupported.
+func MoveToStateIfVersion(ctx context.Context, b Backend, messageID string, fromState, toState QueueState, version uint64) error {
+	if vb, ok := Outer[VersionBackend](b); ok {
+		return vb.MoveToStateIfVersion(ctx, messageID, fromState, toState, version)
+	}
+	if _, ok := As[VersionBackend](b); !ok {
+		return fmt.Errorf("move %s: %w: backend does not track versions", messageID, errors.ErrUnsupported)
+	}
+	m, err := b.GetMeta(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if err := CheckVersion(m, version); err != nil {
+		return err
+	}
+	return b.MoveToState(ctx, messageID, fromState, toState)
+}
+
+// RejectVersion returns ErrNotSupported for a non-zero version. Backends
+// not tracking versions call it in UpdateMeta, so a compare-and-swap
+// update is refused instead of silently writing unconditionally.
+func RejectVersion(messageID string, version uint64) error {
+	if version != 0 {
+		return fmt.Errorf("update %s: %w: backend does not track versions", messageID, ErrNotSupported)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package metastorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	metastorage "schneider.vip/retryspool/storage/meta"
+	"schneider.vip/retryspool/storage/meta/memory"
+)
+
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	b := memory.New()
+	m := metastorage.MessageMetadata{ID: "m1", State: metastorage.StateActive}
+	if err := b.StoreMeta(ctx, "m1", m); err != nil {
+		t.Fatal(err)
+	}
+
+	// two workers read the same version; the second write loses
+	first, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := first
+	if first.Version != 1 {
+		t.Fatalf("stored version %d, want 1", first.Version)
+	}
+	first.Attempts++
+	if err := b.UpdateMeta(ctx, "m1", first); err != nil {
+		t.Fatal(err)
+	}
+	second.Attempts++
+	if err := b.UpdateMeta(ctx, "m1", second); !errors.Is(err, metastorage.ErrVersionConflict) {
+		t.Fatalf("stale update: %v, want version conflict", err)
+	}
+
+	// moves are checked and counted as well
+	err = metastorage.MoveToStateIfVersion(ctx, b, "m1", metastorage.StateActive, metastorage.StateDeferred, 1)
+	if !errors.Is(err, metastorage.ErrVersionConflict) {
+		t.Fatalf("stale move: %v, want version conflict", err)
+	}
+	if err := metastorage.MoveToStateIfVersion(ctx, b, "m1", metastorage.StateActive, metastorage.StateDeferred, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetMeta(ctx, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 3 || got.Attempts != 1 {
+		t.Errorf("after move: version %d, attempts %d, want 3 and 1", got.Version, got.Attempts)
+	}
+
+	// version 0 writes unconditionally
+	got.Version = 0
+	if err := b.UpdateMeta(ctx, "m1", got); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+
+	err = metastorage.MoveToStateIfVersion(ctx, plain{b}, "m1", metastorage.StateDeferred, metastorage.StateActive, 4)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("move without versions: %v, want unsupported", err)
+	}
+}
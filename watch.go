@@ -0,0 +1,62 @@
+package metastorage
+
+import (
+	"context"
+	"time"
+)
+
+// EventType describes the change reported by an Event
+type EventType int
+
+const (
+	EventStored EventType = iota + 1
+	EventUpdated
+	EventDeleted
+	EventMoved
+	EventLeaseExpired // A claim's lease ran out without the worker releasing it
+	EventStuck        // A message has not progressed for longer than expected
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventStored:
+		return "stored"
+	case EventUpdated:
+		return "updated"
+	case EventDeleted:
+		return "deleted"
+	case EventMoved:
+		return "moved"
+	case EventLeaseExpired:
+		return "lease-expired"
+	case EventStuck:
+		return "stuck"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a successful change of one message, or a finding about it
+// (EventLeaseExpired, EventStuck)
+type Event struct {
+	Type       EventType
+	MessageID  string
+	State      QueueState // State after the change; not set for EventDeleted
+	From       QueueState // Previous state, only set for EventMoved
+	LeaseOwner string     // Worker whose lease expired, only set for EventLeaseExpired
+	Time       time.Time  // When the change was observed
+}
+
+// WatchBackend extends Backend with change notifications, letting caches
+// and coordination tools react to changes instead of polling
+type WatchBackend interface {
+	Backend
+
+	// Watch delivers events for changes made after the call until ctx is
+	// done. The channel is closed when ctx is done or the watch breaks,
+	// including when the receiver falls too far behind; events may have
+	// been missed then, so receivers must resynchronize before watching
+	// again.
+	Watch(ctx context.Context) (<-chan Event, error)
+}
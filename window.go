@@ -0,0 +1,148 @@
+package metastorage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeliveryWindow restricts when a message may be delivered. The zero value
+// imposes no restriction. Due-message queries and claim APIs only return
+// messages whose window allows delivery at the time of the query.
+type DeliveryWindow struct {
+	NotBefore time.Time      // Earliest delivery time
+	NotAfter  time.Time      // Latest delivery time; afterwards the message should expire
+	Hours     HourRange      // Allowed hours of the day in TimeZone
+	Weekdays  []time.Weekday // Allowed days in TimeZone, empty = every day
+	TimeZone  string         // IANA zone for Hours and Weekdays, default UTC
+}
+
+// HourRange is a daily range [From, To) of full hours (0-24). From > To
+// wraps around midnight (e.g. 22-6); the zero value allows the whole day.
+type HourRange struct {
+	From int
+	To   int
+}
+
+// IsZero reports whether the range allows the whole day
+func (r HourRange) IsZero() bool {
+	return r.From == r.To
+}
+
+func (r HourRange) contains(hour int) bool {
+	switch {
+	case r.IsZero():
+		return true
+	case r.From < r.To:
+		return hour >= r.From && hour < r.To
+	default:
+		return hour >= r.From || hour < r.To
+	}
+}
+
+// IsZero reports whether w imposes no restriction
+func (w DeliveryWindow) IsZero() bool {
+	return w.NotBefore.IsZero() && w.NotAfter.IsZero() && w.Hours.IsZero() && len(w.Weekdays) == 0 && w.TimeZone == ""
+}
+
+// Validate checks the window for consistency
+func (w DeliveryWindow) Validate() error {
+	if !w.NotBefore.IsZero() && !w.NotAfter.IsZero() && w.NotAfter.Before(w.NotBefore) {
+		return errors.New("delivery window: NotAfter is before NotBefore")
+	}
+	if w.Hours.From < 0 || w.Hours.From > 24 || w.Hours.To < 0 || w.Hours.To > 24 {
+		return fmt.Errorf("delivery window: hours %d-%d out of range 0-24", w.Hours.From, w.Hours.To)
+	}
+	for _, d := range w.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("delivery window: invalid weekday %d", d)
+		}
+	}
+	if _, err := w.location(); err != nil {
+		return fmt.Errorf("delivery window: %w", err)
+	}
+	return nil
+}
+
+// Expired reports whether the window closed permanently before t
+func (w DeliveryWindow) Expired(t time.Time) bool {
+	return !w.NotAfter.IsZero() && t.After(w.NotAfter)
+}
+
+// Allows reports whether delivery is allowed at t. Invalid time zones
+// allow no delivery.
+func (w DeliveryWindow) Allows(t time.Time) bool {
+	if !w.NotBefore.IsZero() && t.Before(w.NotBefore) {
+		return false
+	}
+	if w.Expired(t) {
+		return false
+	}
+	if w.Hours.IsZero() && len(w.Weekdays) == 0 {
+		return true
+	}
+	loc, err := w.location()
+	if err != nil {
+		return false
+	}
+	local := t.In(loc)
+	return w.Hours.contains(local.Hour()) && w.weekdayAllowed(local.Weekday())
+}
+
+// NextAllowed returns the earliest time at or after t when delivery is
+// allowed, or false if the window never opens again
+func (w DeliveryWindow) NextAllowed(t time.Time) (time.Time, bool) {
+	if !w.NotBefore.IsZero() && t.Before(w.NotBefore) {
+		t = w.NotBefore
+	}
+	if w.Allows(t) {
+		return t, true
+	}
+	loc, err := w.location()
+	if err != nil {
+		return time.Time{}, false
+	}
+	// step through the following full hours; a week covers every pattern
+	local := t.In(loc)
+	candidate := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, loc)
+	for i := 0; i < 8*24; i++ {
+		candidate = candidate.Add(time.Hour)
+		if w.Expired(candidate) {
+			return time.Time{}, false
+		}
+		if w.Allows(candidate) {
+			return candidate.In(t.Location()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w DeliveryWindow) weekdayAllowed(d time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, allowed := range w.Weekdays {
+		if allowed == d {
+			return true
+		}
+	}
+	return false
+}
+
+var locations sync.Map // zone name -> *time.Location
+
+func (w DeliveryWindow) location() (*time.Location, error) {
+	if w.TimeZone == "" || w.TimeZone == "UTC" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(w.TimeZone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(w.TimeZone, loc)
+	return loc, nil
+}